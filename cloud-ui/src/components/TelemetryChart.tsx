@@ -0,0 +1,46 @@
+import { AgentTelemetrySample } from '../services/api';
+
+interface TelemetryChartProps {
+  title: string;
+  samples: AgentTelemetrySample[];
+  value: (s: AgentTelemetrySample) => number;
+  unit?: string;
+  max?: number; // 纵轴上限，不设置时取样本最大值
+  color?: string;
+}
+
+const WIDTH = 520;
+const HEIGHT = 80;
+
+// TelemetryChart 心跳资源样本的折线图
+export default function TelemetryChart({ title, samples, value, unit = '', max, color = '#1677ff' }: TelemetryChartProps) {
+  const values = samples.map(value);
+  const latest = values.length > 0 ? values[values.length - 1] : undefined;
+  const top = max ?? Math.max(1, ...values);
+
+  const points = values
+    .map((v, i) => {
+      const x = values.length > 1 ? (i / (values.length - 1)) * WIDTH : WIDTH / 2;
+      const y = HEIGHT - (Math.min(v, top) / top) * HEIGHT;
+      return `${x.toFixed(1)},${y.toFixed(1)}`;
+    })
+    .join(' ');
+
+  return (
+    <div style={{ marginBottom: 16 }}>
+      <div style={{ display: 'flex', justifyContent: 'space-between', marginBottom: 4 }}>
+        <span style={{ fontWeight: 500 }}>{title}</span>
+        <span style={{ color: '#666' }}>
+          {latest === undefined ? '-' : `${latest}${unit}`}
+          <span style={{ marginLeft: 8, color: '#999', fontSize: 12 }}>峰值 {values.length > 0 ? `${Math.max(...values)}${unit}` : '-'}</span>
+        </span>
+      </div>
+      <svg width="100%" height={HEIGHT} viewBox={`0 0 ${WIDTH} ${HEIGHT}`} preserveAspectRatio="none"
+        style={{ background: '#fafafa', border: '1px solid #f0f0f0', borderRadius: 4 }}>
+        {values.length > 0 && (
+          <polyline points={points} fill="none" stroke={color} strokeWidth={1.5} vectorEffect="non-scaling-stroke" />
+        )}
+      </svg>
+    </div>
+  );
+}
@@ -1,7 +1,29 @@
 import { useEffect, useState, useMemo } from 'react';
-import { Table, Tag, Card, Button, message, Modal, Space, Tooltip, Input } from 'antd';
-import { ReloadOutlined, DeleteOutlined, ExclamationCircleOutlined, SafetyOutlined, EditOutlined, PlusOutlined, TagsOutlined } from '@ant-design/icons';
-import { agentAPI, Agent } from '../services/api';
+import { Table, Tag, Card, Button, message, Modal, Space, Tooltip, Input, Progress, Empty } from 'antd';
+import { ReloadOutlined, DeleteOutlined, ExclamationCircleOutlined, SafetyOutlined, EditOutlined, PlusOutlined, TagsOutlined, LineChartOutlined } from '@ant-design/icons';
+import { agentAPI, Agent, AgentTelemetry, AgentTelemetrySample } from '../services/api';
+import TelemetryChart from '../components/TelemetryChart';
+
+// formatBytes 将字节数格式化为可读单位
+const formatBytes = (bytes: number) => {
+  if (!bytes) return '0 B';
+  const units = ['B', 'KB', 'MB', 'GB', 'TB'];
+  const i = Math.min(Math.floor(Math.log(bytes) / Math.log(1024)), units.length - 1);
+  return `${(bytes / Math.pow(1024, i)).toFixed(1)} ${units[i]}`;
+};
+
+// formatDuration 将秒数格式化为天/小时/分钟
+const formatDuration = (seconds: number) => {
+  const d = Math.floor(seconds / 86400);
+  const h = Math.floor((seconds % 86400) / 3600);
+  const m = Math.floor((seconds % 3600) / 60);
+  if (d > 0) return `${d} 天 ${h} 小时`;
+  if (h > 0) return `${h} 小时 ${m} 分钟`;
+  return `${m} 分钟`;
+};
+
+// usageColor 使用率超过 90% 显示红色，超过 75% 显示橙色
+const usageColor = (percent: number) => (percent >= 90 ? '#ff4d4f' : percent >= 75 ? '#faad14' : undefined);
 
 export default function Agents() {
   const [agents, setAgents] = useState<Agent[]>([]);
@@ -20,6 +42,11 @@ export default function Agents() {
   const [batchTagInputVisible, setBatchTagInputVisible] = useState(false);
   const [batchLoading, setBatchLoading] = useState(false);
 
+  // 资源趋势
+  const [trendAgent, setTrendAgent] = useState<Agent | null>(null);
+  const [trendSamples, setTrendSamples] = useState<AgentTelemetrySample[]>([]);
+  const [trendLoading, setTrendLoading] = useState(false);
+
   const loadAgents = async () => {
     setLoading(true);
     try {
@@ -63,6 +90,20 @@ export default function Agents() {
     });
   };
 
+  const handleShowTrend = async (agent: Agent) => {
+    setTrendAgent(agent);
+    setTrendSamples([]);
+    setTrendLoading(true);
+    try {
+      const res = await agentAPI.telemetry(agent.id);
+      setTrendSamples(Array.isArray(res.data) ? res.data : []);
+    } catch (error: any) {
+      message.error('加载资源趋势失败: ' + (error.message || '未知错误'));
+    } finally {
+      setTrendLoading(false);
+    }
+  };
+
   const handleEditTags = (agent: Agent) => {
     setCurrentAgent(agent);
     setTags(agent.tags || []);
@@ -395,6 +436,54 @@ export default function Agents() {
         return <Tag color={colorMap[status]}>{textMap[status] || status}</Tag>;
       },
     },
+    {
+      title: '资源',
+      dataIndex: 'telemetry',
+      key: 'telemetry',
+      width: 200,
+      sorter: (a: any, b: any) => (a.telemetry?.cpu_percent ?? -1) - (b.telemetry?.cpu_percent ?? -1),
+      render: (t: AgentTelemetry | undefined, record: any) => {
+        if (!t || record.status === 'offline') return <span style={{ color: '#999' }}>-</span>;
+        const bar = (label: string, percent: number) => (
+          <div style={{ display: 'flex', alignItems: 'center', gap: 6, lineHeight: '16px' }}>
+            <span style={{ width: 28, fontSize: 12, color: '#666' }}>{label}</span>
+            <Progress percent={percent} size="small" strokeColor={usageColor(percent)} style={{ margin: 0, flex: 1 }}
+              format={(p) => `${p}%`} />
+          </div>
+        );
+        return (
+          <Tooltip
+            title={
+              <div style={{ fontSize: 12 }}>
+                <div>CPU：{t.cpu_cores} 核，负载 {t.load1} / {t.load5} / {t.load15}</div>
+                <div>内存：{formatBytes(t.mem_used)} / {formatBytes(t.mem_total)}</div>
+                <div>磁盘（{t.disk_path}）：{formatBytes(t.disk_used)} / {formatBytes(t.disk_total)}</div>
+                <div>运行中任务：{t.running_tasks}</div>
+                <div>主机运行：{formatDuration(t.uptime)}，Agent 运行：{formatDuration(t.agent_uptime)}</div>
+                <div>系统：{t.os}/{t.arch}</div>
+                <div>执行器：{(t.executors || []).join(', ') || '-'}</div>
+              </div>
+            }
+          >
+            <div>
+              {bar('CPU', t.cpu_percent)}
+              {bar('内存', t.mem_percent)}
+              {bar('磁盘', t.disk_percent)}
+            </div>
+          </Tooltip>
+        );
+      },
+    },
+    {
+      title: '任务',
+      key: 'running_tasks',
+      width: 80,
+      sorter: (a: any, b: any) => (a.telemetry?.running_tasks ?? 0) - (b.telemetry?.running_tasks ?? 0),
+      render: (_: any, record: any) => {
+        if (!record.telemetry || record.status === 'offline') return <span style={{ color: '#999' }}>-</span>;
+        return <Tooltip title="运行中的任务数">{record.telemetry.running_tasks}</Tooltip>;
+      },
+    },
     {
       title: '最后活跃',
       dataIndex: 'last_seen',
@@ -428,11 +517,19 @@ export default function Agents() {
     {
       title: '操作',
       key: 'action',
-      width: 150,
+      width: 210,
       fixed: 'right' as const,
       render: (_: any, record: any) => {
         return (
           <Space>
+            <Button
+              type="link"
+              size="small"
+              icon={<LineChartOutlined />}
+              onClick={() => handleShowTrend(record)}
+            >
+              趋势
+            </Button>
             <Button
               type="link"
               size="small"
@@ -486,6 +583,29 @@ export default function Agents() {
         scroll={{ x: 'max-content' }}
       />
 
+      {/* 资源趋势 Modal */}
+      <Modal
+        title={`资源趋势（最近 1 小时） - ${trendAgent?.hostname}`}
+        open={trendAgent !== null}
+        onCancel={() => setTrendAgent(null)}
+        footer={null}
+        width={600}
+      >
+        {trendLoading ? (
+          <div style={{ textAlign: 'center', padding: 24 }}>加载中...</div>
+        ) : trendSamples.length === 0 ? (
+          <Empty description="暂无资源样本（Agent 版本过旧或最近没有心跳）" />
+        ) : (
+          <>
+            <TelemetryChart title="CPU 使用率" samples={trendSamples} value={(s) => s.cpu_percent} unit="%" max={100} />
+            <TelemetryChart title="内存使用率" samples={trendSamples} value={(s) => s.mem_percent} unit="%" max={100} color="#722ed1" />
+            <TelemetryChart title="磁盘使用率" samples={trendSamples} value={(s) => s.disk_percent} unit="%" max={100} color="#13c2c2" />
+            <TelemetryChart title="1 分钟负载" samples={trendSamples} value={(s) => s.load1} color="#fa8c16" />
+            <TelemetryChart title="运行中任务" samples={trendSamples} value={(s) => s.running_tasks} color="#52c41a" />
+          </>
+        )}
+      </Modal>
+
       {/* 单个 Agent 标签编辑 Modal */}
       <Modal
         title={`编辑标签 - ${currentAgent?.hostname}`}
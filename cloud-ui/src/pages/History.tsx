@@ -1,22 +1,33 @@
 import { useState, useEffect } from 'react';
-import { Card, Table, Tag, Button, message, Modal, Space } from 'antd';
+import { Card, Table, Tag, Button, message, Modal, Space, Input, Select, DatePicker } from 'antd';
 import { ReloadOutlined } from '@ant-design/icons';
-import { taskAPI, agentAPI, Task, Agent } from '../services/api';
+import { taskAPI, agentAPI, Task, Agent, TaskFilterParams } from '../services/api';
 import LogViewer from '../components/LogViewer';
 
+const statusOptions = ['pending', 'pending_approval', 'running', 'success', 'failed', 'canceled'];
+const typeOptions = ['shell', 'mysql', 'postgres', 'redis', 'mongo', 'elasticsearch', 'clickhouse', 'doris', 'k8s', 'api', 'file'];
+
 export default function History() {
   const [tasks, setTasks] = useState<Task[]>([]);
   const [agents, setAgents] = useState<Agent[]>([]);
   const [loading, setLoading] = useState(false);
   const [logModalVisible, setLogModalVisible] = useState(false);
   const [selectedTaskId, setSelectedTaskId] = useState<string>('');
+  const [filters, setFilters] = useState<TaskFilterParams>({});
+  const [page, setPage] = useState(1);
+  const [pageSize, setPageSize] = useState(20);
+  const [total, setTotal] = useState(0);
+  const [statusCounts, setStatusCounts] = useState<Record<string, number>>({});
 
   useEffect(() => {
     loadAgents();
+  }, []);
+
+  useEffect(() => {
     loadTasks();
     const interval = setInterval(loadTasks, 5000);
     return () => clearInterval(interval);
-  }, []);
+  }, [filters, page, pageSize]);
 
   const loadAgents = async () => {
     try {
@@ -31,9 +42,16 @@ export default function History() {
   const loadTasks = async () => {
     setLoading(true);
     try {
-      const res = await taskAPI.list({ limit: 100 });
+      // 状态统计不按状态过滤，便于在各状态之间切换
+      const statsFilters = { ...filters, status: undefined };
+      const [res, stats] = await Promise.all([
+        taskAPI.list({ ...filters, limit: pageSize, offset: (page - 1) * pageSize }),
+        taskAPI.stats(statsFilters),
+      ]);
       const tasksData: Task[] = Array.isArray(res.data) ? res.data : (res.data?.data || []);
       setTasks(tasksData);
+      setTotal(Number(res.headers['x-total-count'] ?? tasksData.length));
+      setStatusCounts(stats.data.by_status || {});
     } catch (error: any) {
       message.error('加载任务列表失败');
     } finally {
@@ -41,6 +59,13 @@ export default function History() {
     }
   };
 
+  const updateFilters = (changes: TaskFilterParams) => {
+    setFilters((prev) => ({ ...prev, ...changes }));
+    setPage(1);
+  };
+
+  const envOptions = Array.from(new Set(agents.map((a) => a.env).filter(Boolean))) as string[];
+
   const handleViewLogs = (taskId: string) => {
     setSelectedTaskId(taskId);
     setLogModalVisible(true);
@@ -105,6 +130,20 @@ export default function History() {
       key: 'type',
       width: 100,
     },
+    {
+      title: '标签',
+      dataIndex: 'tags',
+      key: 'tags',
+      width: 140,
+      render: (tags?: string[]) => (tags && tags.length > 0 ? tags.map((t) => <Tag key={t}>{t}</Tag>) : '-'),
+    },
+    {
+      title: '创建者',
+      dataIndex: 'created_by',
+      key: 'created_by',
+      width: 100,
+      render: (text?: string) => text || '-',
+    },
     {
       title: '状态',
       dataIndex: 'status',
@@ -113,6 +152,7 @@ export default function History() {
       render: (status: string) => {
         const colorMap: Record<string, string> = {
           pending: 'default',
+          pending_approval: 'gold',
           running: 'processing',
           success: 'success',
           failed: 'error',
@@ -180,12 +220,74 @@ export default function History() {
   return (
     <>
       <Card title="任务历史记录">
+        <Space wrap style={{ marginBottom: 16 }}>
+          <Input.Search
+            placeholder="搜索命令、结果、错误"
+            allowClear
+            style={{ width: 260 }}
+            onSearch={(value) => updateFilters({ q: value || undefined })}
+          />
+          <Select
+            mode="multiple"
+            placeholder="状态"
+            allowClear
+            style={{ minWidth: 160 }}
+            options={statusOptions.map((s) => ({ value: s, label: `${s} (${statusCounts[s] || 0})` }))}
+            onChange={(values: string[]) => updateFilters({ status: values.join(',') || undefined })}
+          />
+          <Select
+            mode="multiple"
+            placeholder="类型"
+            allowClear
+            style={{ minWidth: 160 }}
+            options={typeOptions.map((t) => ({ value: t, label: t }))}
+            onChange={(values: string[]) => updateFilters({ type: values.join(',') || undefined })}
+          />
+          <Select
+            placeholder="环境"
+            allowClear
+            style={{ width: 140 }}
+            options={envOptions.map((e) => ({ value: e, label: e }))}
+            onChange={(value?: string) => updateFilters({ env: value })}
+          />
+          <Input.Search
+            placeholder="标签"
+            allowClear
+            style={{ width: 140 }}
+            onSearch={(value) => updateFilters({ tag: value || undefined })}
+          />
+          <Input.Search
+            placeholder="创建者"
+            allowClear
+            style={{ width: 140 }}
+            onSearch={(value) => updateFilters({ created_by: value || undefined })}
+          />
+          <DatePicker.RangePicker
+            showTime
+            onChange={(values) =>
+              updateFilters({
+                since: values?.[0]?.toISOString(),
+                until: values?.[1]?.toISOString(),
+              })
+            }
+          />
+        </Space>
         <Table
           columns={columns}
           dataSource={tasks}
           rowKey="id"
           loading={loading}
-          pagination={{ pageSize: 20 }}
+          pagination={{
+            current: page,
+            pageSize,
+            total,
+            showSizeChanger: true,
+            showTotal: (n) => `共 ${n} 条`,
+            onChange: (p, size) => {
+              setPage(p);
+              setPageSize(size);
+            },
+          }}
         />
       </Card>
 
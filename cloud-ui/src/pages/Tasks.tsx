@@ -386,6 +386,7 @@ export default function Tasks() {
       render: (status: string) => {
         const colorMap: Record<string, string> = {
           pending: 'default',
+          pending_approval: 'gold',
           running: 'processing',
           success: 'success',
           failed: 'error',
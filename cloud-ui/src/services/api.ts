@@ -16,26 +16,88 @@ export interface Agent {
   status: 'online' | 'offline' | 'error';
   last_seen?: string;
   tags?: string[];
+  telemetry?: AgentTelemetry; // 最近一次心跳上报的资源快照，旧版本 Agent 没有
+  capabilities?: AgentCapability[] | null; // 注册时上报的执行器能力，旧版本 Agent 为 null
   created_at: string;
   updated_at: string;
 }
 
+export interface AgentCapability {
+  type: string;
+  connections?: string[];
+  version?: string;
+}
+
+export interface AgentTelemetry {
+  os: string;
+  arch: string;
+  cpu_cores: number;
+  cpu_percent: number;
+  mem_total: number;
+  mem_used: number;
+  mem_percent: number;
+  disk_path: string;
+  disk_total: number;
+  disk_used: number;
+  disk_percent: number;
+  load1: number;
+  load5: number;
+  load15: number;
+  uptime: number;
+  agent_uptime: number;
+  running_tasks: number;
+  executors: string[];
+  collected_at: number;
+}
+
+export interface AgentTelemetrySample {
+  id: number;
+  agent_id: string;
+  timestamp: string;
+  cpu_percent: number;
+  mem_percent: number;
+  disk_percent: number;
+  load1: number;
+  running_tasks: number;
+}
+
 export interface Task {
   id: string;
   agent_id: string;
   type: 'shell' | 'mysql' | 'postgres' | 'redis' | 'mongo' | 'elasticsearch' | 'clickhouse' | 'doris' | 'k8s' | 'api' | 'file';
-  status: 'pending' | 'running' | 'success' | 'failed' | 'canceled';
+  status: 'pending' | 'pending_approval' | 'running' | 'success' | 'failed' | 'canceled';
   command: string;
   params?: string;
   file_id?: string;
+  tags?: string[];
+  created_by?: string;
+  policy_rule?: string;
+  approved_by?: string;
   result?: string;
   error?: string;
+  log_lines?: number;
+  log_state?: '' | 'archived' | 'purged';
+  job?: JobStatus;
   started_at?: string;
   finished_at?: string;
   created_at: string;
   updated_at: string;
 }
 
+// 后台任务（Shell params.detach）的状态，时间为 Unix 秒
+export interface JobStatus {
+  task_id: string;
+  state: 'running' | 'exited' | 'lost';
+  pid?: number;
+  started_at?: number;
+  finished_at?: number;
+  exit_code?: number;
+  signal?: string;
+  output_bytes: number;
+  last_output?: string;
+  updated_at: number;
+}
+
 export interface Log {
   id: number;
   task_id: string;
@@ -62,8 +124,31 @@ export const agentAPI = {
   getStatus: (id: string) => api.get<{ status: string }>(`/agents/${id}/status`),
   update: (id: string, data: Partial<Agent>) => api.put<any>(`/agents/${id}`, data),
   delete: (id: string) => api.delete(`/agents/${id}`),
+  telemetry: (id: string, params?: { since?: string; limit?: number }) =>
+    api.get<AgentTelemetrySample[]>(`/agents/${id}/telemetry`, { params }),
 };
 
+// 任务过滤参数，status 和 type 支持逗号分隔的多个值
+export interface TaskFilterParams {
+  agent_id?: string;
+  status?: string;
+  type?: string;
+  env?: string;
+  tag?: string;
+  created_by?: string;
+  q?: string;
+  since?: string;
+  until?: string;
+}
+
+export interface TaskListParams extends TaskFilterParams {
+  sort?: 'created_at' | 'updated_at' | 'agent_id' | 'type' | 'status';
+  order?: 'asc' | 'desc';
+  cursor?: string;
+  limit?: number;
+  offset?: number;
+}
+
 // Task API
 export const taskAPI = {
   create: (data: {
@@ -75,8 +160,10 @@ export const taskAPI = {
     sync?: boolean;
     timeout?: number;
   }) => api.post<any>('/tasks', data),
-  list: (params?: { agent_id?: string; limit?: number; offset?: number }) =>
-    api.get<any>('/tasks', { params }),
+  // 总数和下一页游标通过响应头 X-Total-Count、X-Next-Cursor 返回
+  list: (params?: TaskListParams) => api.get<any>('/tasks', { params }),
+  stats: (params?: TaskFilterParams) =>
+    api.get<{ total: number; by_status: Record<string, number> }>('/tasks/stats', { params }),
   get: (id: string) => api.get<any>(`/tasks/${id}`),
   getLogs: (id: string, limit?: number) =>
     api.get<any>(`/tasks/${id}/logs`, { params: { limit } }),
@@ -1,21 +1,32 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/cloud-agent/internal/agent"
+	"github.com/cloud-agent/internal/agent/jobs"
+	"github.com/cloud-agent/internal/agent/sandbox"
+	"github.com/cloud-agent/internal/tracing"
 	"github.com/google/uuid"
 )
 
 func main() {
+	// 作为 Shell 执行配置的沙箱辅助进程或后台任务监护进程启动时不返回
+	sandbox.RunHelper()
+	jobs.RunHelper()
+
 	var (
-		cloudURL  = flag.String("cloud", "http://localhost:8080", "Cloud 服务地址")
-		agentID   = flag.String("id", "", "Agent ID（为空则自动生成）")
-		agentName = flag.String("name", "", "Agent 名称（为空则使用主机名）")
+		cloudURL    = flag.String("cloud", "http://localhost:8080", "Cloud 服务地址")
+		agentID     = flag.String("id", "", "Agent ID（为空则自动生成）")
+		agentName   = flag.String("name", "", "Agent 名称（为空则使用主机名）")
+		metricsAddr = flag.String("metrics-addr", os.Getenv("AGENT_METRICS_ADDR"), "Prometheus 指标监听地址，如 :9100（为空则不启用）")
+		watchPeriod = flag.Duration("config-watch-interval", envDuration("AGENT_CONFIG_WATCH_INTERVAL", 10*time.Second), "检查本地插件和安全配置文件变化的间隔，0 表示只在收到 SIGHUP 时重新加载")
 	)
 	flag.Parse()
 
@@ -43,11 +54,33 @@ func main() {
 	log.Printf("Build Version: 20260208-debug-fix-k8s") // Added for debugging
 	log.Printf("Connecting to cloud: %s", *cloudURL)
 
+	// 初始化链路追踪（设置 OTEL_EXPORTER_OTLP_ENDPOINT 时启用）
+	shutdownTracing, err := tracing.Setup(context.Background(), "agent")
+	if err != nil {
+		log.Fatalf("Failed to initialize tracing: %v", err)
+	}
+
 	// 创建并启动 Agent
 	ag := agent.NewAgent(*cloudURL, *agentID, *agentName)
+	if err := ag.StartMetrics(*metricsAddr); err != nil {
+		log.Fatalf("Failed to start metrics listener: %v", err)
+	}
 	if err := ag.Start(); err != nil {
 		log.Fatalf("Failed to start agent: %v", err)
 	}
+	ag.StartConfigWatcher(*watchPeriod)
+
+	// SIGHUP 重新加载本地配置文件
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			log.Println("Received SIGHUP, reloading local config")
+			if err := ag.ReloadLocalConfig(); err != nil {
+				log.Printf("Failed to reload local config: %v", err)
+			}
+		}
+	}()
 
 	// 等待中断信号
 	quit := make(chan os.Signal, 1)
@@ -58,4 +91,24 @@ func main() {
 	if err := ag.Stop(); err != nil {
 		log.Printf("Error stopping agent: %v", err)
 	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		log.Printf("Failed to flush traces: %v", err)
+	}
+}
+
+// envDuration 读取时长类型的环境变量，未设置或无效时返回默认值
+func envDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("Invalid %s %q, using default %s", key, v, def)
+		return def
+	}
+	return d
 }
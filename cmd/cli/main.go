@@ -59,6 +59,7 @@ func printUsage() {
 	fmt.Println()
 	fmt.Println("Examples:")
 	fmt.Println("  cloudctl run sql --file demo.sql --agent <agent-id>")
+	fmt.Println("  cloudctl run --type postgres --command 'SELECT 1' --params '{\"connection\":\"prod\"}' --env prod --capability postgres:prod")
 	fmt.Println("  cloudctl list tasks")
 	fmt.Println("  cloudctl logs <task-id>")
 	fmt.Println("  cloudctl upload file.zip")
@@ -71,11 +72,25 @@ func handleRun() {
 		file     = flag.String("file", "", "File to use")
 		agentID  = flag.String("agent", "", "Agent ID")
 		params   = flag.String("params", "{}", "JSON parameters")
+		env      = flag.String("env", "", "Select an agent in this env when -agent is empty")
+		tags     = flag.String("tags", "", "Select an agent having all these comma-separated tags")
+		hostname = flag.String("hostname", "", "Select an agent whose hostname matches this glob")
+		capab    = flag.String("capability", "", "Select an agent having this capability, e.g. postgres:prod")
+		strategy = flag.String("strategy", "", "Scheduling strategy (least_loaded, round_robin, sticky)")
 	)
 	flag.Parse()
 
-	if *agentID == "" {
-		log.Fatal("agent is required")
+	selector := map[string]interface{}{}
+	for key, value := range map[string]string{"env": *env, "hostname": *hostname, "capability": *capab, "strategy": *strategy} {
+		if value != "" {
+			selector[key] = value
+		}
+	}
+	if *tags != "" {
+		selector["tags"] = strings.Split(*tags, ",")
+	}
+	if *agentID == "" && len(selector) == 0 {
+		log.Fatal("agent or selector (-env, -tags, -hostname, -capability) is required")
 	}
 
 	var commandStr string
@@ -104,6 +119,9 @@ func handleRun() {
 		"command":  commandStr,
 		"params":   paramsMap,
 	}
+	if *agentID == "" {
+		reqData["selector"] = selector
+	}
 
 	resp, err := postJSON(*cloudURL+"/api/v1/tasks", reqData)
 	if err != nil {
@@ -116,6 +134,7 @@ func handleRun() {
 	}
 
 	fmt.Printf("Task created: %s\n", task.ID)
+	fmt.Printf("Agent: %s\n", task.AgentID)
 	fmt.Printf("Status: %s\n", task.Status)
 
 	// 等待任务完成
@@ -1,43 +1,200 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/cloud-agent/internal/cloud/agent"
+	"github.com/cloud-agent/internal/cloud/cluster"
+	"github.com/cloud-agent/internal/cloud/filestore"
+	"github.com/cloud-agent/internal/cloud/notify"
 	"github.com/cloud-agent/internal/cloud/server"
 	"github.com/cloud-agent/internal/cloud/storage"
+	"github.com/cloud-agent/internal/cloud/task"
+	"github.com/cloud-agent/internal/cloud/vault"
+	"github.com/cloud-agent/internal/common"
+	"github.com/cloud-agent/internal/tracing"
 )
 
 func main() {
 	var (
 		addr        = flag.String("addr", ":8080", "服务器地址")
-		dbPath      = flag.String("db", "./data/cloud.db", "数据库路径")
-		fileStorage = flag.String("storage", "./data/files", "文件存储路径")
+		dbPath      = flag.String("db", "./data/cloud.db", "数据库 DSN：SQLite 文件路径、postgres://... 或 mysql://...")
+		dbLogLevel  = flag.String("db-log-level", "warn", "SQL 日志级别：silent、error、warn、info")
+		dbMaxOpen   = flag.Int("db-max-open-conns", 20, "数据库最大打开连接数（0 表示不限制）")
+		dbMaxIdle   = flag.Int("db-max-idle-conns", 5, "数据库最大空闲连接数")
+		dbLifetime  = flag.Duration("db-conn-max-lifetime", time.Hour, "数据库连接最大存活时间")
+		dbIdleTime  = flag.Duration("db-conn-max-idle-time", 10*time.Minute, "数据库连接最大空闲时间")
+		replicaID   = flag.String("replica-id", os.Getenv("POD_NAME"), "副本 ID（多副本部署时唯一，为空则使用主机名+随机后缀）")
+		clusterBus  = flag.String("cluster-bus", "memory", "副本间消息总线：memory（单副本）或 db（通过共享数据库路由，多副本部署使用）")
+		clusterPoll = flag.Duration("cluster-poll-interval", 500*time.Millisecond, "db 消息总线轮询间隔")
+		fileStorage = flag.String("storage", "./data/files", "文件存储路径（-file-store=local 时使用）")
+		fileStore   = flag.String("file-store", "local", "上传文件存储后端：local（本地磁盘）或 s3（S3 兼容对象存储）")
+		s3Endpoint  = flag.String("s3-endpoint", os.Getenv("S3_ENDPOINT"), "S3 服务地址，如 s3.amazonaws.com、minio:9000")
+		s3Bucket    = flag.String("s3-bucket", os.Getenv("S3_BUCKET"), "S3 存储桶")
+		s3Region    = flag.String("s3-region", os.Getenv("S3_REGION"), "S3 区域（可选）")
+		s3AccessKey = flag.String("s3-access-key", os.Getenv("S3_ACCESS_KEY"), "S3 访问密钥 ID（默认读取环境变量 S3_ACCESS_KEY）")
+		s3SecretKey = flag.String("s3-secret-key", os.Getenv("S3_SECRET_KEY"), "S3 访问密钥（默认读取环境变量 S3_SECRET_KEY）")
+		s3UseSSL    = flag.Bool("s3-use-ssl", true, "S3 是否使用 HTTPS")
+		s3Prefix    = flag.String("s3-prefix", "", "S3 对象 key 前缀（可选）")
+		s3Insecure  = flag.Bool("s3-insecure-skip-verify", false, "跳过 S3 TLS 证书校验（仅用于测试环境）")
+		s3Presign   = flag.Duration("s3-presign-expiry", filestore.DefaultPresignExpiry, "S3 预签名下载链接有效期")
+		fileRetain  = flag.Duration("file-retention", 0, "上传文件默认保留时长（0 表示永久保留，可在上传时单独指定）")
+		fileGC      = flag.Duration("file-gc-interval", time.Hour, "回收过期文件和孤立对象的间隔（0 表示不自动回收）")
+		fileGCGrace = flag.Duration("file-gc-grace", time.Hour, "孤立对象的最小存在时间，避免回收正在上传的对象")
+		logRetainCf = flag.String("log-retention-config", "", "任务日志保留策略配置文件（YAML），为空时任务结束 7 天后归档日志")
+		notifyCf    = flag.String("notify-config", os.Getenv("CLOUD_NOTIFY_CONFIG"), "通知渠道和规则配置文件（YAML），为空时不发送通知")
+		redactCf    = flag.String("redaction-config", os.Getenv("CLOUD_REDACTION_CONFIG"), "任务日志、结果和参数的脱敏规则配置文件（YAML），为空时只使用内置规则")
+		policyCf    = flag.String("policy-config", os.Getenv("CLOUD_POLICY_CONFIG"), "任务策略配置文件（YAML），为空时允许所有任务")
+		hbTimeout   = flag.Duration("agent-heartbeat-timeout", cluster.DefaultAgentTTL, "超过该时长没有心跳的 Agent 标记为离线")
+		healthIntvl = flag.Duration("agent-health-interval", 30*time.Second, "Agent 健康检查间隔")
+		flapWindow  = flag.Duration("agent-flap-window", 10*time.Minute, "Agent 连接抖动检测的时间窗口")
+		flapThresh  = flag.Int("agent-flap-threshold", 3, "时间窗口内断开次数达到该值时视为抖动（0 表示不检测）")
+		requeueTyps = flag.String("agent-requeue-types", "", "Agent 离线时重新排队的任务类型（逗号分隔，如 shell,file），其他类型直接标记为失败")
+		requeueTime = flag.Duration("agent-requeue-timeout", 10*time.Minute, "重新排队的任务等待 Agent 上线的最长时间")
+		telemRetain = flag.Duration("agent-telemetry-retention", 24*time.Hour, "Agent 心跳资源样本保留时长（0 表示永久保留）")
+		secretsKey  = flag.String("secrets-key", os.Getenv("CLOUD_SECRETS_KEY"), "密钥库加密密钥（base64 编码的 32 字节，默认读取环境变量 CLOUD_SECRETS_KEY），为空时密钥库不可用")
+		authHeader  = flag.String("auth-user-header", os.Getenv("CLOUD_AUTH_USER_HEADER"), "认证代理传递已认证用户名的请求头，任务创建者和审批人取自该请求头（默认 X-Forwarded-User）")
+		authProxies = flag.String("auth-trusted-proxies", os.Getenv("CLOUD_AUTH_TRUSTED_PROXIES"), "允许设置用户名请求头的认证代理地址（逗号分隔的 IP 或 CIDR，默认只信任本机 127.0.0.1,::1）")
 		certFile    = flag.String("cert", "", "TLS 证书文件路径（启用 HTTPS/WSS）")
 		keyFile     = flag.String("key", "", "TLS 私钥文件路径（启用 HTTPS/WSS）")
 	)
 	flag.Parse()
 
 	// 确保数据目录存在
-	if err := os.MkdirAll(*fileStorage, 0755); err != nil {
-		log.Fatalf("Failed to create storage directory: %v", err)
-	}
 	if err := os.MkdirAll("./data", 0755); err != nil {
 		log.Fatalf("Failed to create data directory: %v", err)
 	}
 
+	// 初始化链路追踪（设置 OTEL_EXPORTER_OTLP_ENDPOINT 时启用）
+	shutdownTracing, err := tracing.Setup(context.Background(), "cloud")
+	if err != nil {
+		log.Fatalf("Failed to initialize tracing: %v", err)
+	}
+
 	// 初始化数据库
-	db, err := storage.NewDatabase(*dbPath)
+	db, err := storage.NewDatabaseWithConfig(&storage.Config{
+		DSN:             *dbPath,
+		MaxOpenConns:    *dbMaxOpen,
+		MaxIdleConns:    *dbMaxIdle,
+		ConnMaxLifetime: *dbLifetime,
+		ConnMaxIdleTime: *dbIdleTime,
+		LogLevel:        *dbLogLevel,
+	})
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 	defer db.Close()
 
+	// 初始化集群（副本间 Agent 连接注册与消息路由）
+	var cl *cluster.Cluster
+	switch *clusterBus {
+	case "db":
+		if db.Dialect() == storage.DialectSQLite {
+			log.Printf("[WARN] cluster-bus=db with SQLite only works for replicas sharing the same database file")
+		}
+		cl = cluster.NewWithDatabase(*replicaID, db, *clusterPoll)
+	case "memory":
+		cl = cluster.NewLocal(*replicaID)
+	default:
+		log.Fatalf("Unknown cluster bus: %s", *clusterBus)
+	}
+	defer cl.Close()
+
+	// 初始化文件存储后端
+	var store filestore.FileStore
+	switch *fileStore {
+	case filestore.TypeLocal:
+		store, err = filestore.NewLocalStore(*fileStorage)
+		if err != nil {
+			log.Fatalf("Failed to create storage directory: %v", err)
+		}
+	case filestore.TypeS3:
+		s3Store, err := filestore.NewS3Store(&filestore.S3Config{
+			Endpoint:           *s3Endpoint,
+			Bucket:             *s3Bucket,
+			AccessKey:          *s3AccessKey,
+			SecretKey:          *s3SecretKey,
+			Region:             *s3Region,
+			UseSSL:             *s3UseSSL,
+			Prefix:             *s3Prefix,
+			InsecureSkipVerify: *s3Insecure,
+			PresignExpiry:      *s3Presign,
+		})
+		if err != nil {
+			log.Fatalf("Failed to initialize s3 file store: %v", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err = s3Store.EnsureBucket(ctx)
+		cancel()
+		if err != nil {
+			log.Fatalf("Failed to initialize s3 file store: %v", err)
+		}
+		store = s3Store
+	default:
+		log.Fatalf("Unknown file store: %s", *fileStore)
+	}
+
+	logRetention, err := task.LoadLogRetentionConfig(*logRetainCf)
+	if err != nil {
+		log.Fatalf("Failed to load log retention config: %v", err)
+	}
+
+	notifyConfig, err := notify.LoadConfig(*notifyCf)
+	if err != nil {
+		log.Fatalf("Failed to load notify config: %v", err)
+	}
+
+	redaction, err := task.LoadRedactionConfig(*redactCf)
+	if err != nil {
+		log.Fatalf("Failed to load redaction config: %v", err)
+	}
+
+	policy, err := task.LoadPolicyConfig(*policyCf)
+	if err != nil {
+		log.Fatalf("Failed to load policy config: %v", err)
+	}
+
+	vaultKey, err := vault.ParseKey(*secretsKey)
+	if err != nil {
+		log.Fatalf("Invalid secrets key: %v", err)
+	}
+
 	// 创建服务器
-	srv := server.NewServer(db, *fileStorage)
+	srv := server.NewServerWithConfig(db, &server.Config{
+		FileStorage: *fileStorage,
+		FileStore:   store,
+		Cluster:     cl,
+		FileLifecycle: &task.FileLifecycleConfig{
+			DefaultRetention:  *fileRetain,
+			GCInterval:        *fileGC,
+			OrphanGracePeriod: *fileGCGrace,
+		},
+		LogRetention: logRetention,
+		Notify:       notifyConfig,
+		Health: &agent.HealthConfig{
+			CheckInterval:      *healthIntvl,
+			HeartbeatTimeout:   *hbTimeout,
+			FlapWindow:         *flapWindow,
+			FlapThreshold:      *flapThresh,
+			HistoryRetention:   agent.DefaultHealthConfig().HistoryRetention,
+			TelemetryRetention: *telemRetain,
+		},
+		InFlight: &task.InFlightConfig{
+			RequeueTypes:   parseTaskTypes(*requeueTyps),
+			RequeueTimeout: *requeueTime,
+		},
+		SecretsKey: vaultKey,
+		Redaction:  redaction,
+		Policy:     policy,
+		Auth:       authConfig(*authHeader, *authProxies),
+	})
 
 	// 启动服务器
 	go func() {
@@ -60,4 +217,31 @@ func main() {
 	<-quit
 
 	log.Println("Shutting down server...")
+	srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		log.Printf("Failed to flush traces: %v", err)
+	}
+}
+
+// authConfig 构造请求认证配置，为空的选项使用默认值
+func authConfig(header, proxies string) *server.AuthConfig {
+	cfg := &server.AuthConfig{UserHeader: header}
+	if proxies != "" {
+		cfg.TrustedProxies = strings.Split(proxies, ",")
+	}
+	return cfg
+}
+
+// parseTaskTypes 解析逗号分隔的任务类型列表
+func parseTaskTypes(s string) []common.TaskType {
+	var types []common.TaskType
+	for _, t := range strings.Split(s, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types = append(types, common.TaskType(t))
+		}
+	}
+	return types
 }
@@ -1,21 +1,50 @@
 package agent
 
 import (
+	"cmp"
+	"context"
 	"encoding/json"
+	"errors"
 	"log"
+	"net"
+	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/cloud-agent/internal/agent/client"
 	"github.com/cloud-agent/internal/agent/executor"
+	"github.com/cloud-agent/internal/agent/jobs"
+	"github.com/cloud-agent/internal/agent/metrics"
+	"github.com/cloud-agent/internal/agent/plugins"
+	"github.com/cloud-agent/internal/agent/security"
+	"github.com/cloud-agent/internal/agent/telemetry"
 	"github.com/cloud-agent/internal/common"
+	"github.com/cloud-agent/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Agent Agent 主程序
 type Agent struct {
-	client   *client.Client
-	executor *executor.Manager
+	client        *client.Client
+	executor      *executor.Manager
+	metricsServer *http.Server // 指标监听服务，nil 表示未启用
+	telemetry     *telemetry.Collector
+	jobs          *jobs.Manager // 后台任务（Shell 任务 params.detach 为 true）
+
+	// 远程配置，只有设置 AGENT_REMOTE_CONFIG=true 时才应用 Cloud 下发的配置
+	remoteConfig       bool
+	pluginsConfigPath  string // 恢复本地配置时重新加载的文件
+	securityConfigPath string
+	configMu           sync.Mutex
+	appliedConfigs     map[string]string // kind -> 已应用配置的校验和，本地配置为空字符串
+	localChecksums     map[string]string // kind -> 最近加载的本地配置文件的校验和
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
 }
 
 // NewAgent 创建 Agent
@@ -66,10 +95,42 @@ func NewAgent(cloudURL, agentID, agentName string) *Agent {
 	registeredTypes := execMgr.GetRegisteredExecutors()
 	log.Printf("Final registered executors: %v", registeredTypes)
 
-	return &Agent{
-		client:   cl,
-		executor: execMgr,
+	a := &Agent{
+		client:    cl,
+		executor:  execMgr,
+		telemetry: telemetry.NewCollector(os.Getenv("AGENT_TELEMETRY_DISK_PATH")),
+		jobs:      jobs.NewManager(cmp.Or(os.Getenv("AGENT_JOBS_DIR"), "./data/jobs")),
+
+		remoteConfig:       os.Getenv("AGENT_REMOTE_CONFIG") == "true",
+		pluginsConfigPath:  configPath,
+		securityConfigPath: securityConfigPath,
+		appliedConfigs:     make(map[string]string),
+		localChecksums: map[string]string{
+			common.AgentConfigKindPlugins:  fileChecksum(configPath),
+			common.AgentConfigKindSecurity: fileChecksum(securityConfigPath),
+		},
+		stopCh: make(chan struct{}),
 	}
+	if a.remoteConfig {
+		log.Println("Remote config enabled, plugin and security configs pushed by the cloud will be applied")
+	}
+	cl.SetTelemetryProvider(a.collectTelemetry)
+	cl.SetCapabilitiesProvider(execMgr.Capabilities)
+	// 每次连接后上报后台任务，Agent 重启或断线期间运行的任务重新关联
+	execMgr.SetJobs(a.jobs)
+	cl.SetConnectHook(a.syncJobs)
+	// 安全模块的审计日志同时上报 Cloud
+	security.SetAuditSink(a.sendSecurityAudit)
+	return a
+}
+
+// collectTelemetry 采集心跳上报的主机资源和执行器状态
+func (a *Agent) collectTelemetry() *common.AgentTelemetry {
+	t := a.telemetry.Collect()
+	t.RunningTasks = a.executor.RunningCount()
+	t.Executors = a.executor.GetRegisteredExecutors()
+	slices.Sort(t.Executors)
+	return t
 }
 
 // Start 启动 Agent
@@ -81,11 +142,39 @@ func (a *Agent) Start() error {
 
 	// 处理消息
 	go a.handleMessages()
+	// 上报后台任务的日志、进度和结果
+	go a.jobs.Run(a.stopCh, &jobSink{agent: a})
 
 	log.Println("Agent started and ready to receive tasks")
 	return nil
 }
 
+// StartMetrics 在 addr 上启动 Prometheus 指标监听（/metrics），addr 为空时不启用
+// 指标端点不做认证，建议只监听在内网或本机地址
+func (a *Agent) StartMetrics(addr string) error {
+	if addr == "" {
+		return nil
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mt := metrics.New()
+	a.executor.SetMetrics(mt)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", mt.Handler())
+	a.metricsServer = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		if err := a.metricsServer.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Metrics server error: %v", err)
+		}
+	}()
+	log.Printf("Metrics listening on %s", ln.Addr())
+	return nil
+}
+
 // handleMessages 处理来自 Cloud 的消息
 func (a *Agent) handleMessages() {
 	for msg := range a.client.GetMessageChan() {
@@ -94,6 +183,12 @@ func (a *Agent) handleMessages() {
 			a.handleTaskCreate(msg)
 		case common.MessageTypeTaskCancel:
 			a.handleTaskCancel(msg)
+		case common.MessageTypeConfigUpdate:
+			a.handleConfigUpdate(msg)
+		case common.MessageTypeSecretsSync:
+			a.handleSecretsSync(msg)
+		case common.MessageTypeJobAck:
+			a.handleJobAck(msg)
 		case common.MessageTypeAgentStatus:
 			// 忽略状态消息，或者记录日志
 			log.Printf("Received agent status update: %v", msg.Data)
@@ -126,10 +221,40 @@ func (a *Agent) executeTask(taskData *common.TaskCreateData) {
 	// 创建日志回调
 	logCallback := func(taskID string, level string, message string) {
 		a.sendLog(taskID, level, message)
+		if level == "audit" {
+			a.sendAudit(&common.AuditEventData{
+				TaskID:   taskID,
+				TaskType: taskData.Type,
+				Action:   common.AuditActionPluginAudit,
+				Reason:   message,
+			})
+		}
 	}
 
-	// 执行任务
-	result, err := a.executor.Execute(taskData.TaskID, taskData.Type, taskData.Command, taskData.Params, taskData.FileID, logCallback)
+	// 执行任务，沿用 Cloud 下发的链路上下文
+	ctx := tracing.Extract(context.Background(), taskData.TraceContext)
+	ctx, span := tracing.StartKind(ctx, "agent.task", trace.SpanKindConsumer,
+		attribute.String("task.id", taskData.TaskID),
+		attribute.String("task.type", string(taskData.Type)))
+	// 执行前按本地任务策略再次评估，Cloud 的策略被绕过时仍然生效
+	err := a.executor.CheckPolicy(taskData, client.ClusterEnv())
+	result := ""
+	if err == nil && detached(taskData) {
+		// 后台任务的结果在命令结束后由后台任务管理器上报
+		err = a.executor.StartJob(taskData.TaskID, taskData.Type, taskData.Command, taskData.Params, taskData.FileID, logCallback)
+		if errors.Is(err, jobs.ErrJobExists) {
+			// 重复下发的任务，已在运行的后台任务继续上报
+			a.sendLog(taskData.TaskID, "warn", "Detached job is already running on this agent")
+			err = nil
+		}
+		if err == nil {
+			tracing.End(span, nil)
+			return
+		}
+	} else if err == nil {
+		result, err = a.executor.ExecuteContext(ctx, taskData.TaskID, taskData.Type, taskData.Command, taskData.Params, taskData.FileID, logCallback)
+	}
+	tracing.End(span, err)
 
 	// 发送任务完成消息
 	status := common.TaskStatusSuccess
@@ -138,6 +263,16 @@ func (a *Agent) executeTask(taskData *common.TaskCreateData) {
 		status = common.TaskStatusFailed
 		errorMsg = err.Error()
 		a.sendLog(taskData.TaskID, "error", "Task failed: "+errorMsg)
+		if errors.Is(err, plugins.ErrSecurityRejected) {
+			a.sendAudit(&common.AuditEventData{
+				TaskID:   taskData.TaskID,
+				TaskType: taskData.Type,
+				Action:   common.AuditActionValidationRejected,
+				Outcome:  common.AuditOutcomeDenied,
+				Command:  taskData.Command,
+				Reason:   errorMsg,
+			})
+		}
 	} else {
 		a.sendLog(taskData.TaskID, "info", "Task completed successfully")
 	}
@@ -145,8 +280,8 @@ func (a *Agent) executeTask(taskData *common.TaskCreateData) {
 	completeData := common.TaskCompleteData{
 		TaskID:    taskData.TaskID,
 		Status:    status,
-		Result:    result,
-		Error:     errorMsg,
+		Result:    a.executor.Redact(result),
+		Error:     a.executor.Redact(errorMsg),
 		Timestamp: time.Now().Unix(),
 	}
 
@@ -156,6 +291,18 @@ func (a *Agent) executeTask(taskData *common.TaskCreateData) {
 	}
 }
 
+// handleSecretsSync 保存 Cloud 下发的密钥库密钥（只保存在内存中）
+func (a *Agent) handleSecretsSync(msg *common.Message) {
+	dataBytes, _ := json.Marshal(msg.Data)
+	var data common.SecretsSyncData
+	if err := json.Unmarshal(dataBytes, &data); err != nil {
+		log.Printf("Failed to parse secrets: %v", err)
+		return
+	}
+	log.Printf("Received %d secrets from cloud vault", len(data.Secrets))
+	a.executor.SetCloudSecrets(data.Secrets)
+}
+
 // handleTaskCancel 处理任务取消
 func (a *Agent) handleTaskCancel(msg *common.Message) {
 	dataBytes, _ := json.Marshal(msg.Data)
@@ -169,17 +316,21 @@ func (a *Agent) handleTaskCancel(msg *common.Message) {
 		return
 	}
 
-	// 取消任务执行
+	// 后台任务结束其进程，结果由后台任务管理器上报；其他任务取消执行
+	if a.killJob(taskID) {
+		a.sendLog(taskID, "info", "Detached job is being terminated")
+		return
+	}
 	a.executor.Cancel(taskID)
 	a.sendLog(taskID, "info", "Task canceled")
 }
 
-// sendLog 发送日志
+// sendLog 发送日志，发送前按脱敏规则处理
 func (a *Agent) sendLog(taskID, level, message string) {
 	logData := common.TaskLogData{
 		TaskID:    taskID,
 		Level:     level,
-		Message:   message,
+		Message:   a.executor.Redact(message),
 		Timestamp: time.Now().Unix(),
 	}
 
@@ -189,7 +340,51 @@ func (a *Agent) sendLog(taskID, level, message string) {
 	}
 }
 
+// sendAudit 上报审计事件
+func (a *Agent) sendAudit(data *common.AuditEventData) {
+	if data.Timestamp == 0 {
+		data.Timestamp = time.Now().Unix()
+	}
+	data.Reason = a.executor.Redact(data.Reason)
+	msg := common.NewMessage(common.MessageTypeAuditEvent, data)
+	if err := a.client.SendMessage(msg); err != nil {
+		log.Printf("Failed to send audit event: %v", err)
+	}
+}
+
+// sendSecurityAudit 上报安全模块记录的命令执行尝试和结果
+// 被拒绝的尝试由 executeTask 以 validation.rejected 上报，这里不重复上报
+func (a *Agent) sendSecurityAudit(l *security.AuditLog) {
+	data := &common.AuditEventData{
+		TaskID:     l.TaskID,
+		TaskType:   common.TaskType(l.TaskType),
+		Command:    l.Command,
+		DurationMs: l.DurationMs,
+		Timestamp:  l.Timestamp.Unix(),
+	}
+	switch {
+	case l.Result != "":
+		data.Action = common.AuditActionCommandResult
+		data.Outcome = l.Result
+		data.Reason = l.Error
+	case l.Allowed:
+		data.Action = common.AuditActionCommandAttempt
+		data.Outcome = common.AuditOutcomeAllowed
+	default:
+		return
+	}
+	a.sendAudit(data)
+}
+
 // Stop 停止 Agent
 func (a *Agent) Stop() error {
+	a.stopOnce.Do(func() { close(a.stopCh) })
+	if a.metricsServer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := a.metricsServer.Shutdown(ctx); err != nil {
+			log.Printf("Failed to stop metrics server: %v", err)
+		}
+	}
 	return a.client.Close()
 }
@@ -9,6 +9,7 @@ import (
 	"net"
 	"net/url"
 	"os"
+	"runtime"
 	"strconv"
 	"strings"
 	"time"
@@ -29,6 +30,12 @@ type Client struct {
 	connected   bool
 	messageChan chan *common.Message
 	done        chan struct{}
+	// telemetry 心跳时采集资源快照，为 nil 时只上报 agent_id
+	telemetry func() *common.AgentTelemetry
+	// capabilities 注册时上报的执行器能力，为 nil 时不上报
+	capabilities func() []common.AgentCapability
+	// onConnect 每次连接并注册后调用（包括重新连接），为 nil 时不调用
+	onConnect func()
 }
 
 // NewClient 创建 Agent 客户端
@@ -42,6 +49,21 @@ func NewClient(cloudURL, agentID, agentName string) *Client {
 	}
 }
 
+// SetTelemetryProvider 设置心跳资源快照的采集函数，需在 Connect 之前调用
+func (c *Client) SetTelemetryProvider(provider func() *common.AgentTelemetry) {
+	c.telemetry = provider
+}
+
+// SetCapabilitiesProvider 设置注册时上报的执行器能力，需在 Connect 之前调用
+func (c *Client) SetCapabilitiesProvider(provider func() []common.AgentCapability) {
+	c.capabilities = provider
+}
+
+// SetConnectHook 设置每次连接并注册后调用的函数（如上报后台任务），需在 Connect 之前调用
+func (c *Client) SetConnectHook(hook func()) {
+	c.onConnect = hook
+}
+
 // Connect 连接到 Cloud
 func (c *Client) Connect() error {
 	u, err := url.Parse(c.cloudURL)
@@ -107,6 +129,10 @@ func (c *Client) Connect() error {
 		return fmt.Errorf("failed to register: %w", err)
 	}
 
+	if c.onConnect != nil {
+		c.onConnect()
+	}
+
 	// 启动心跳
 	go c.heartbeat()
 
@@ -114,15 +140,19 @@ func (c *Client) Connect() error {
 	return nil
 }
 
-// register 注册 Agent
-func (c *Client) register() error {
-	hostname, _ := os.Hostname()
-
-	// 从环境变量获取 K8s 集群名称
+// ClusterEnv 从环境变量获取 Agent 所在的 K8s 集群名称，注册时作为 Agent 的环境上报
+func ClusterEnv() string {
 	env := os.Getenv("K8S_CLUSTER_NAME")
 	if env == "" {
 		env = os.Getenv("CLUSTER_NAME") // 兼容其他环境变量名
 	}
+	return env
+}
+
+// register 注册 Agent
+func (c *Client) register() error {
+	hostname, _ := os.Hostname()
+	env := ClusterEnv()
 
 	registerData := common.AgentRegisterData{
 		AgentID:  c.agentID,
@@ -132,36 +162,44 @@ func (c *Client) register() error {
 		Version:  "1.0.0",
 		Env:      env,
 		Metadata: map[string]string{
-			"os": os.Getenv("GOOS"),
+			"os":         runtime.GOOS,
+			"arch":       runtime.GOARCH,
+			"go_version": runtime.Version(),
+			"cpu_cores":  strconv.Itoa(runtime.NumCPU()),
 		},
 	}
 
+	if c.capabilities != nil {
+		registerData.Capabilities = c.capabilities()
+	}
+
 	msg := common.NewMessage(common.MessageTypeAgentRegister, registerData)
 	return c.conn.WriteMessage(msg)
 }
 
-// heartbeat 心跳保持
+// heartbeat 心跳保持，连接后立即发送一次，使 Cloud 尽快获得资源快照
 func (c *Client) heartbeat() {
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
 
 	for {
-		select {
-		case <-ticker.C:
-			if !c.connected {
-				return
-			}
+		if !c.connected {
+			return
+		}
 
-			heartbeatData := map[string]interface{}{
-				"agent_id": c.agentID,
-			}
-			msg := common.NewMessage(common.MessageTypeAgentHeartbeat, heartbeatData)
-			if err := c.conn.WriteMessage(msg); err != nil {
-				log.Printf("Failed to send heartbeat: %v", err)
-				c.reconnect()
-				return
-			}
+		heartbeatData := common.AgentHeartbeatData{AgentID: c.agentID}
+		if c.telemetry != nil {
+			heartbeatData.Telemetry = c.telemetry()
+		}
+		msg := common.NewMessage(common.MessageTypeAgentHeartbeat, heartbeatData)
+		if err := c.conn.WriteMessage(msg); err != nil {
+			log.Printf("Failed to send heartbeat: %v", err)
+			c.reconnect()
+			return
+		}
 
+		select {
+		case <-ticker.C:
 		case <-c.done:
 			return
 		}
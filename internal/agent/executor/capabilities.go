@@ -0,0 +1,86 @@
+package executor
+
+import (
+	"cmp"
+	"runtime/debug"
+	"slices"
+	"sync"
+
+	"github.com/cloud-agent/internal/common"
+)
+
+// driverModules 执行器使用的客户端库，其版本作为能力版本上报
+var driverModules = map[common.TaskType]string{
+	common.TaskTypePostgres:      "github.com/jackc/pgx/v5",
+	common.TaskTypeMongo:         "go.mongodb.org/mongo-driver",
+	common.TaskTypeElasticsearch: "github.com/elastic/go-elasticsearch/v8",
+	common.TaskTypeClickHouse:    "github.com/ClickHouse/clickhouse-go/v2",
+	common.TaskTypeK8s:           "k8s.io/client-go",
+	common.TaskTypeHelm:          "helm.sh/helm/v3",
+}
+
+var (
+	moduleVersionsOnce sync.Once
+	moduleVersions     map[string]string
+)
+
+// driverVersion 返回执行器客户端库的版本，没有对应客户端库或无法读取构建信息时返回空
+func driverVersion(taskType common.TaskType) string {
+	moduleVersionsOnce.Do(func() {
+		moduleVersions = make(map[string]string)
+		if info, ok := debug.ReadBuildInfo(); ok {
+			for _, dep := range info.Deps {
+				moduleVersions[dep.Path] = dep.Version
+			}
+		}
+	})
+	return moduleVersions[driverModules[taskType]]
+}
+
+// connectionNames 返回插件配置 connections 列表中的连接名称，与插件一致，未命名的连接为 default
+func connectionNames(config map[string]interface{}) []string {
+	connections, _ := config["connections"].([]interface{})
+	var names []string
+	for _, conn := range connections {
+		connMap, ok := conn.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := connMap["name"].(string)
+		if name == "" {
+			name = "default"
+		}
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// setConnections 记录执行器配置的连接名称
+func (m *Manager) setConnections(taskType common.TaskType, names []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(names) == 0 {
+		delete(m.connections, taskType)
+		return
+	}
+	m.connections[taskType] = names
+}
+
+// Capabilities 返回已注册执行器的能力，按类型排序，注册时上报给 Cloud
+func (m *Manager) Capabilities() []common.AgentCapability {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	caps := make([]common.AgentCapability, 0, len(m.executors))
+	for taskType := range m.executors {
+		caps = append(caps, common.AgentCapability{
+			Type:        taskType,
+			Connections: m.connections[taskType],
+			Version:     driverVersion(taskType),
+		})
+	}
+	slices.SortFunc(caps, func(a, b common.AgentCapability) int {
+		return cmp.Compare(a.Type, b.Type)
+	})
+	return caps
+}
@@ -4,9 +4,16 @@ import (
 	"context"
 	"log"
 	"sync"
+	"time"
 
+	"github.com/cloud-agent/internal/agent/jobs"
+	"github.com/cloud-agent/internal/agent/metrics"
 	"github.com/cloud-agent/internal/agent/plugins"
+	"github.com/cloud-agent/internal/agent/secrets"
+	"github.com/cloud-agent/internal/agent/security"
 	"github.com/cloud-agent/internal/common"
+	"github.com/cloud-agent/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // Manager 执行器管理器
@@ -20,6 +27,16 @@ type Manager struct {
 	typeSemaphores     map[common.TaskType]chan struct{} // 按类型的并发控制信号量
 	agentID            string                            // Agent ID
 	securityConfigPath string                            // 安全配置文件路径
+	metrics            *metrics.Metrics                  // 指标，nil 表示未启用
+	connections        map[common.TaskType][]string      // 插件配置中的连接名称，注册时上报给 Cloud
+	securityConfig     *security.SecurityConfig          // 运行时更新的安全配置，nil 时使用 securityConfigPath
+	active             *sync.WaitGroup                   // 使用当前这组执行器的执行中任务，重新加载后等待其结束再关闭旧执行器
+	secrets            *secrets.Resolver                 // 解析插件配置和任务参数中的密钥引用
+	pluginConfig       *PluginConfig                     // 最近加载的插件配置，Cloud 密钥更新后据此重建执行器
+	secretsPending     bool                              // 插件配置中有未能解析的密钥引用
+	redactor           *common.Redactor                  // 日志和结果上报前的脱敏规则
+	policy             *common.PolicyEngine              // 执行前再次评估的任务策略，nil 时允许所有任务
+	jobs               *jobs.Manager                     // 后台任务管理器，nil 时不支持后台任务
 }
 
 // ManagerConfig 管理器配置
@@ -47,6 +64,11 @@ func NewManagerWithConfigAndLimits(agentID, configPath, securityConfigPath strin
 		running:            make(map[string]context.CancelFunc),
 		typeConcurrency:    make(map[common.TaskType]int),
 		typeSemaphores:     make(map[common.TaskType]chan struct{}),
+		connections:        make(map[common.TaskType][]string),
+		active:             &sync.WaitGroup{},
+		secrets:            secrets.NewResolver(),
+		redactor:           loadRedactor(securityConfigPath),
+		policy:             loadPolicy(securityConfigPath),
 		agentID:            agentID,
 		securityConfigPath: securityConfigPath,
 	}
@@ -116,37 +138,112 @@ func (m *Manager) GetRegisteredExecutors() []common.TaskType {
 	return types
 }
 
+// RunningCount 返回正在执行的任务数（不含等待并发名额的任务）
+func (m *Manager) RunningCount() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.running)
+}
+
+// SetMetrics 设置指标并记录当前的并发限制
+func (m *Manager) SetMetrics(mt *metrics.Metrics) {
+	m.mu.Lock()
+	m.metrics = mt
+	m.mu.Unlock()
+	mt.SetConcurrencyLimit("", m.maxConcurrency)
+	for taskType, limit := range m.typeConcurrency {
+		mt.SetConcurrencyLimit(taskType, limit)
+	}
+}
+
 // Execute 执行任务（带并发控制）
 func (m *Manager) Execute(taskID string, taskType common.TaskType, command string, params map[string]interface{}, fileID string, logCallback plugins.LogCallback) (string, error) {
+	return m.ExecuteContext(context.Background(), taskID, taskType, command, params, fileID, logCallback)
+}
+
+// ExecuteContext 执行任务（带并发控制），ctx 中的链路上下文会传递给支持 plugins.ContextExecutor 的执行器
+func (m *Manager) ExecuteContext(ctx context.Context, taskID string, taskType common.TaskType, command string, params map[string]interface{}, fileID string, logCallback plugins.LogCallback) (result string, err error) {
+	ctx, span := tracing.Start(ctx, "executor.execute",
+		attribute.String("task.id", taskID),
+		attribute.String("task.type", string(taskType)))
+	defer func() { tracing.End(span, err) }()
+
 	m.mu.RLock()
 	exec, exists := m.executors[taskType]
+	mt := m.metrics
+	active := m.active
+	resolver := m.secrets
+	if exists && active != nil {
+		active.Add(1)
+	}
 	m.mu.RUnlock()
 
 	if !exists {
+		mt.PluginNotFound(taskType)
 		return "", common.NewErrorf("executor not found for type: %s", taskType)
 	}
+	if active != nil {
+		defer active.Done()
+	}
+
+	// 解析参数中的密钥引用，日志、结果和错误信息中的密钥值脱敏
+	params, err = resolver.ResolveParams(params)
+	if err != nil {
+		return "", common.NewErrorf("failed to resolve secrets: %v", err)
+	}
+	if logCallback != nil {
+		rawCallback := logCallback
+		logCallback = func(taskID, level, message string) {
+			rawCallback(taskID, level, resolver.Redact(message))
+		}
+	}
+	defer func() {
+		result = resolver.Redact(result)
+		if err != nil {
+			if msg := resolver.Redact(err.Error()); msg != err.Error() {
+				err = &redactedError{msg: msg, err: err}
+			}
+		}
+	}()
 
 	// 全局并发控制
 	if m.semaphore != nil {
+		waitStart := time.Now()
 		m.semaphore <- struct{}{}        // 获取信号量
 		defer func() { <-m.semaphore }() // 释放信号量
+		wait := time.Since(waitStart)
+		mt.SemaphoreWait(taskType, metrics.ScopeGlobal, wait)
+		span.SetAttributes(attribute.Float64("executor.global_wait_seconds", wait.Seconds()))
 	}
 
 	// 按类型的并发控制
 	typeSem, hasTypeLimit := m.typeSemaphores[taskType]
 	if hasTypeLimit {
+		waitStart := time.Now()
 		typeSem <- struct{}{}        // 获取类型信号量
 		defer func() { <-typeSem }() // 释放类型信号量
+		wait := time.Since(waitStart)
+		mt.SemaphoreWait(taskType, metrics.ScopeType, wait)
+		span.SetAttributes(attribute.Float64("executor.type_wait_seconds", wait.Seconds()))
 	}
 
 	// 创建取消上下文
-	_, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(ctx)
 	m.mu.Lock()
 	m.running[taskID] = cancel
 	m.mu.Unlock()
 
 	// 执行任务
-	result, err := exec.Execute(taskID, command, params, fileID, logCallback)
+	mt.TaskStarted(taskType)
+	start := time.Now()
+	pluginCtx, pluginSpan := tracing.Start(ctx, "plugin."+string(taskType), attribute.String("task.id", taskID))
+	if ctxExec, ok := exec.(plugins.ContextExecutor); ok {
+		result, err = ctxExec.ExecuteContext(pluginCtx, taskID, command, params, fileID, logCallback)
+	} else {
+		result, err = exec.Execute(taskID, command, params, fileID, logCallback)
+	}
+	tracing.End(pluginSpan, err)
+	mt.TaskFinished(taskType, time.Since(start), err)
 
 	// 清理
 	m.mu.Lock()
@@ -157,6 +254,51 @@ func (m *Manager) Execute(taskID string, taskType common.TaskType, command strin
 	return result, err
 }
 
+// SetJobs 设置后台任务管理器
+func (m *Manager) SetJobs(j *jobs.Manager) {
+	m.mu.Lock()
+	m.jobs = j
+	m.mu.Unlock()
+}
+
+// StartJob 以后台任务方式启动任务，执行器需要实现 plugins.JobStarter
+// 后台任务不占用并发名额，其数量由安全配置的 jobs.max_running 限制
+func (m *Manager) StartJob(taskID string, taskType common.TaskType, command string, params map[string]interface{}, fileID string, logCallback plugins.LogCallback) (err error) {
+	m.mu.RLock()
+	exec, exists := m.executors[taskType]
+	resolver := m.secrets
+	jobManager := m.jobs
+	m.mu.RUnlock()
+
+	if !exists {
+		return common.NewErrorf("executor not found for type: %s", taskType)
+	}
+	starter, ok := exec.(plugins.JobStarter)
+	if !ok {
+		return common.NewErrorf("executor %s does not support detached jobs", taskType)
+	}
+
+	// 与 ExecuteContext 相同，解析参数中的密钥引用，日志和错误信息中的密钥值脱敏
+	params, err = resolver.ResolveParams(params)
+	if err != nil {
+		return common.NewErrorf("failed to resolve secrets: %v", err)
+	}
+	if logCallback != nil {
+		rawCallback := logCallback
+		logCallback = func(taskID, level, message string) {
+			rawCallback(taskID, level, resolver.Redact(message))
+		}
+	}
+	defer func() {
+		if err != nil {
+			if msg := resolver.Redact(err.Error()); msg != err.Error() {
+				err = &redactedError{msg: msg, err: err}
+			}
+		}
+	}()
+	return starter.StartJob(taskID, command, params, fileID, logCallback, jobManager)
+}
+
 // Cancel 取消任务
 func (m *Manager) Cancel(taskID string) error {
 	m.mu.Lock()
@@ -0,0 +1,278 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/cloud-agent/internal/agent/metrics"
+	"github.com/cloud-agent/internal/agent/plugins"
+	"github.com/cloud-agent/internal/agent/secrets"
+	"github.com/cloud-agent/internal/agent/security"
+	"github.com/cloud-agent/internal/common"
+	"github.com/cloud-agent/internal/tracing"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// fakeExecutor 测试用执行器，命令为 fail 时返回错误
+type fakeExecutor struct{}
+
+func (fakeExecutor) Execute(taskID, command string, params map[string]interface{}, fileID string, logCallback plugins.LogCallback) (string, error) {
+	if command == "fail" {
+		return "", errors.New("boom")
+	}
+	return "ok", nil
+}
+
+func (fakeExecutor) Cancel(taskID string) error { return nil }
+
+func (fakeExecutor) Type() common.TaskType { return common.TaskTypeShell }
+
+func TestExecuteMetrics(t *testing.T) {
+	m := &Manager{
+		executors:       map[common.TaskType]plugins.Executor{common.TaskTypeShell: fakeExecutor{}},
+		running:         make(map[string]context.CancelFunc),
+		maxConcurrency:  4,
+		semaphore:       make(chan struct{}, 4),
+		typeConcurrency: map[common.TaskType]int{common.TaskTypeShell: 2},
+		typeSemaphores:  map[common.TaskType]chan struct{}{common.TaskTypeShell: make(chan struct{}, 2)},
+	}
+	mt := metrics.New()
+	m.SetMetrics(mt)
+
+	m.Execute("t1", common.TaskTypeShell, "echo", nil, "", nil)
+	m.Execute("t2", common.TaskTypeShell, "fail", nil, "", nil)
+	if _, err := m.Execute("t3", common.TaskTypeK8s, "", nil, "", nil); err == nil {
+		t.Fatal("expected executor not found error")
+	}
+
+	rec := httptest.NewRecorder()
+	mt.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		`agent_executor_tasks_total{status="success",type="shell"} 1`,
+		`agent_executor_tasks_total{status="failed",type="shell"} 1`,
+		`agent_executor_tasks_total{status="failed",type="k8s"} 1`,
+		`agent_plugin_errors_total{plugin="shell",reason="execute"} 1`,
+		`agent_plugin_errors_total{plugin="k8s",reason="not_found"} 1`,
+		`agent_executor_running_tasks{type="shell"} 0`,
+		`agent_executor_concurrency_limit{type=""} 4`,
+		`agent_executor_concurrency_limit{type="shell"} 2`,
+		`agent_executor_semaphore_wait_seconds_count{scope="global",type="shell"} 2`,
+		`agent_executor_semaphore_wait_seconds_count{scope="type",type="shell"} 2`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics output missing %q", want)
+		}
+	}
+}
+
+// ctxExecutor 测试用执行器，记录收到的上下文
+type ctxExecutor struct {
+	fakeExecutor
+	got trace.SpanContext
+}
+
+func (e *ctxExecutor) ExecuteContext(ctx context.Context, taskID, command string, params map[string]interface{}, fileID string, logCallback plugins.LogCallback) (string, error) {
+	e.got = trace.SpanContextFromContext(ctx)
+	return "ok", nil
+}
+
+func TestExecuteContextTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := tracing.SetupWithExporter("agent-test", exporter)
+
+	exec := &ctxExecutor{}
+	m := &Manager{
+		executors: map[common.TaskType]plugins.Executor{common.TaskTypeShell: exec},
+		running:   make(map[string]context.CancelFunc),
+	}
+
+	// 模拟 Cloud 下发的链路上下文
+	ctx, parent := tracing.Start(context.Background(), "task.dispatch")
+	carrier := tracing.Inject(ctx)
+	parent.End()
+	if _, err := m.ExecuteContext(tracing.Extract(context.Background(), carrier), "t1", common.TaskTypeShell, "echo", nil, "", nil); err != nil {
+		t.Fatalf("ExecuteContext failed: %v", err)
+	}
+
+	traceID := parent.SpanContext().TraceID()
+	if exec.got.TraceID() != traceID {
+		t.Errorf("plugin trace id = %s, want %s", exec.got.TraceID(), traceID)
+	}
+	tp.ForceFlush(context.Background())
+	names := map[string]bool{}
+	for _, span := range exporter.GetSpans() {
+		if span.SpanContext.TraceID() != traceID {
+			t.Errorf("span %s has trace id %s", span.Name, span.SpanContext.TraceID())
+		}
+		names[span.Name] = true
+	}
+	if !names["executor.execute"] || !names["plugin.shell"] {
+		t.Errorf("spans = %v, want executor.execute and plugin.shell", names)
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	m := NewManager("agent-1")
+	config := &PluginConfig{Plugins: []PluginDefinition{
+		{Type: string(common.TaskTypeFile), Enabled: true},
+		{Type: string(common.TaskTypeMySQL), Enabled: true, Config: map[string]interface{}{
+			"connections": []interface{}{
+				map[string]interface{}{"name": "prod", "database": "app"},
+				map[string]interface{}{"database": "test"},
+			},
+		}},
+		{Type: string(common.TaskTypeMongo), Enabled: false},
+	}}
+	if err := LoadPluginsFromConfig(config, m); err != nil {
+		t.Fatalf("LoadPluginsFromConfig failed: %v", err)
+	}
+
+	var mysql *common.AgentCapability
+	caps := m.Capabilities()
+	for i, c := range caps {
+		if i > 0 && caps[i-1].Type >= c.Type {
+			t.Errorf("capabilities not sorted: %v", caps)
+		}
+		if c.Type == common.TaskTypeMongo {
+			t.Error("disabled plugin should not be advertised")
+		}
+		if c.Type == common.TaskTypeMySQL {
+			mysql = &caps[i]
+		}
+	}
+	if mysql == nil {
+		t.Fatalf("mysql capability missing: %v", caps)
+	}
+	if strings.Join(mysql.Connections, ",") != "default,prod" {
+		t.Errorf("mysql connections = %v, want [default prod]", mysql.Connections)
+	}
+}
+
+func TestReloadPlugins(t *testing.T) {
+	m := NewManager("agent-1")
+	before := m.GetRegisteredExecutors()
+	slices.Sort(before)
+
+	// 任一插件无效时保留当前执行器
+	invalid := &PluginConfig{Plugins: []PluginDefinition{
+		{Type: string(common.TaskTypeAPI), Enabled: true},
+		{Type: "unknown", Enabled: true},
+	}}
+	if err := m.ReloadPlugins(invalid); err == nil {
+		t.Fatal("ReloadPlugins with unknown plugin should fail")
+	}
+	after := m.GetRegisteredExecutors()
+	slices.Sort(after)
+	if !slices.Equal(before, after) {
+		t.Fatalf("executors changed after failed reload: %v, want %v", after, before)
+	}
+
+	config, err := ParsePluginConfig([]byte("plugins:\n  - type: api\n    enabled: true\n"))
+	if err != nil {
+		t.Fatalf("ParsePluginConfig failed: %v", err)
+	}
+	if err := m.ReloadPlugins(config); err != nil {
+		t.Fatalf("ReloadPlugins failed: %v", err)
+	}
+	if types := m.GetRegisteredExecutors(); len(types) != 1 || types[0] != common.TaskTypeAPI {
+		t.Fatalf("executors after reload = %v, want [api]", types)
+	}
+	if _, err := ParsePluginConfig([]byte("plugins:\n  - type: api\n    enable: true\n")); err == nil {
+		t.Error("unknown fields should be rejected")
+	}
+}
+
+// echoExecutor 测试用执行器，把 password 参数写入日志、结果和错误
+type echoExecutor struct{ fakeExecutor }
+
+func (echoExecutor) Execute(taskID, command string, params map[string]interface{}, fileID string, logCallback plugins.LogCallback) (string, error) {
+	password, _ := params["target"].(map[string]interface{})["password"].(string)
+	logCallback(taskID, "info", "connecting with "+password)
+	return "result " + password, errors.New("auth failed for " + password)
+}
+
+func TestExecuteResolvesAndRedactsSecrets(t *testing.T) {
+	t.Setenv("TEST_TARGET_PASSWORD", "s3cr3t-value")
+	resolver, err := secrets.NewResolver().WithDefinitions(map[string]secrets.Definition{
+		"target": {Env: "TEST_TARGET_PASSWORD", AllowedHosts: []string{"db.internal"}},
+	})
+	if err != nil {
+		t.Fatalf("WithDefinitions failed: %v", err)
+	}
+	m := &Manager{
+		executors: map[common.TaskType]plugins.Executor{common.TaskTypeShell: echoExecutor{}},
+		running:   make(map[string]context.CancelFunc),
+		secrets:   resolver,
+	}
+
+	var logs []string
+	params := map[string]interface{}{"target": map[string]interface{}{"host": "db.internal", "password": "secret://target"}}
+	result, err := m.Execute("t1", common.TaskTypeShell, "", params, "", func(taskID, level, message string) {
+		logs = append(logs, message)
+	})
+	for _, s := range append(logs, result, err.Error()) {
+		if strings.Contains(s, "s3cr3t-value") || !strings.Contains(s, common.RedactedValue) {
+			t.Errorf("output not redacted: %q", s)
+		}
+	}
+
+	params["target"].(map[string]interface{})["host"] = "evil.example.com"
+	if _, err := m.Execute("t2", common.TaskTypeShell, "", params, "", nil); err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Errorf("secret sent to a host outside allowed_hosts, err = %v", err)
+	}
+}
+
+func TestCheckPolicy(t *testing.T) {
+	m := NewManager("agent-1")
+	config, err := security.ParseSecurityConfig([]byte(`
+policy:
+  roles:
+    dba: [alice]
+  rules:
+    - name: prod-ddl
+      effect: require_approval
+      envs: [prod]
+      sql_classes: [ddl]
+    - name: pci-writes
+      effect: deny
+      tags: [pci]
+      sql_classes: [write]
+      reason: writes on pci hosts are not allowed
+`))
+	if err != nil {
+		t.Fatalf("ParseSecurityConfig failed: %v", err)
+	}
+	if err := m.UpdateSecurityConfig(config); err != nil {
+		t.Fatalf("UpdateSecurityConfig failed: %v", err)
+	}
+
+	tests := []struct {
+		task    common.TaskCreateData
+		env     string
+		wantErr string
+	}{
+		{common.TaskCreateData{Type: common.TaskTypeMySQL, Command: "DROP TABLE t", CreatedBy: "alice"}, "dev", ""},
+		{common.TaskCreateData{Type: common.TaskTypeMySQL, Command: "DROP TABLE t", CreatedBy: "alice"}, "prod", "requires approval"},
+		{common.TaskCreateData{Type: common.TaskTypeMySQL, Command: "DROP TABLE t", CreatedBy: "alice", ApprovedBy: "bob"}, "prod", ""},
+		{common.TaskCreateData{Type: common.TaskTypeMySQL, Command: "UPDATE t SET a = 1", AgentTags: []string{"pci"}}, "dev", "writes on pci hosts"},
+		{common.TaskCreateData{Type: common.TaskTypeMySQL, Command: "UPDATE t SET a = 1"}, "dev", ""},
+	}
+	for _, tt := range tests {
+		err := m.CheckPolicy(&tt.task, tt.env)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("CheckPolicy(%q in %s) = %v, want nil", tt.task.Command, tt.env, err)
+			}
+			continue
+		}
+		if !errors.Is(err, plugins.ErrSecurityRejected) || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("CheckPolicy(%q in %s) = %v, want %q", tt.task.Command, tt.env, err, tt.wantErr)
+		}
+	}
+}
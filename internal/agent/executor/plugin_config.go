@@ -1,12 +1,16 @@
 package executor
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
 
 	"github.com/cloud-agent/internal/agent/plugins"
+	"github.com/cloud-agent/internal/agent/secrets"
 	"github.com/cloud-agent/internal/common"
 	"gopkg.in/yaml.v3"
 )
@@ -14,6 +18,8 @@ import (
 // PluginConfig 插件配置
 type PluginConfig struct {
 	Plugins []PluginDefinition `yaml:"plugins"`
+	// Secrets 插件配置和任务参数中 secret://<name> 引用的密钥来源
+	Secrets map[string]secrets.Definition `yaml:"secrets,omitempty"`
 }
 
 // PluginDefinition 插件定义
@@ -65,6 +71,17 @@ func LoadPluginConfig(configPath string) (*PluginConfig, error) {
 	return &config, nil
 }
 
+// ParsePluginConfig 解析插件配置内容，未知字段返回错误，用于校验 Cloud 下发的配置
+func ParsePluginConfig(data []byte) (*PluginConfig, error) {
+	var config PluginConfig
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse plugin config: %w", err)
+	}
+	return &config, nil
+}
+
 // SavePluginConfig 保存插件配置
 func SavePluginConfig(config *PluginConfig, configPath string) error {
 	// 确保目录存在
@@ -87,6 +104,14 @@ func SavePluginConfig(config *PluginConfig, configPath string) error {
 // LoadPluginsFromConfig 从配置加载插件
 func LoadPluginsFromConfig(config *PluginConfig, manager *Manager) error {
 	log.Printf("Loading plugins from config with %d definitions", len(config.Plugins))
+	resolver, err := manager.secrets.WithDefinitions(config.Secrets)
+	if err != nil {
+		return err
+	}
+	manager.secrets = resolver
+	manager.pluginConfig = config
+	manager.secretsPending = false
+
 	for i, pluginDef := range config.Plugins {
 		log.Printf("Processing plugin definition #%d: type=%s, enabled=%v", i, pluginDef.Type, pluginDef.Enabled)
 		if !pluginDef.Enabled {
@@ -94,41 +119,52 @@ func LoadPluginsFromConfig(config *PluginConfig, manager *Manager) error {
 			continue
 		}
 
+		// 无法解析的密钥引用保持原样，对应的连接无法建立；Cloud 密钥下发后重新加载
+		pluginConfig, err := manager.secrets.ResolveConfig(pluginDef.Config)
+		if err != nil {
+			log.Printf("[WARN] Plugin %s has unresolved secrets: %v", pluginDef.Type, err)
+			manager.secretsPending = true
+		}
+
 		taskType := common.TaskType(pluginDef.Type)
 		var exec plugins.Executor
-		var err error
 
 		switch taskType {
 		case common.TaskTypeShell:
-			if exec, err = plugins.NewShellExecutor(manager.agentID, manager.securityConfigPath); err != nil {
+			if manager.securityConfig != nil {
+				exec, err = plugins.NewShellExecutorWithSecurityConfig(manager.agentID, manager.securityConfig)
+			} else {
+				exec, err = plugins.NewShellExecutor(manager.agentID, manager.securityConfigPath)
+			}
+			if err != nil {
 				return fmt.Errorf("failed to create shell executor: %w", err)
 			}
 		case common.TaskTypeMySQL:
-			exec = plugins.NewMySQLExecutor(pluginDef.Config)
+			exec = plugins.NewMySQLExecutor(pluginConfig)
 		case common.TaskTypeSQL:
 			// 兼容旧版本，映射到 MySQL
-			exec = plugins.NewMySQLExecutor(pluginDef.Config)
+			exec = plugins.NewMySQLExecutor(pluginConfig)
 		case common.TaskTypePostgres:
-			exec = plugins.NewPostgresExecutor(pluginDef.Config)
+			exec = plugins.NewPostgresExecutor(pluginConfig)
 		case common.TaskTypeRedis:
-			exec, err = plugins.NewDatabaseExecutor("redis", pluginDef.Config)
+			exec, err = plugins.NewDatabaseExecutor("redis", pluginConfig)
 			if err != nil {
 				return err
 			}
 		case common.TaskTypeMongo:
-			exec = plugins.NewMongoExecutor(pluginDef.Config)
+			exec = plugins.NewMongoExecutor(pluginConfig)
 		case common.TaskTypeElasticsearch:
-			exec = plugins.NewESExecutor(pluginDef.Config)
+			exec = plugins.NewESExecutor(pluginConfig)
 		case common.TaskTypeClickHouse:
-			exec = plugins.NewClickHouseExecutor(pluginDef.Config)
+			exec = plugins.NewClickHouseExecutor(pluginConfig)
 		case common.TaskTypeDoris:
-			exec = plugins.NewDorisExecutor(pluginDef.Config)
+			exec = plugins.NewDorisExecutor(pluginConfig)
 		case common.TaskTypeK8s:
-			exec = plugins.NewK8sExecutor(pluginDef.Config)
+			exec = plugins.NewK8sExecutor(pluginConfig)
 		case common.TaskTypeAPI:
-			exec = plugins.NewAPIExecutor(pluginDef.Config)
+			exec = plugins.NewAPIExecutor(pluginConfig)
 		case common.TaskTypeFile:
-			exec = plugins.NewFileExecutor(pluginDef.Config)
+			exec = plugins.NewFileExecutor(pluginConfig)
 		default:
 			log.Printf("Unknown plugin type: %s", pluginDef.Type)
 			return fmt.Errorf("unknown plugin type: %s", pluginDef.Type)
@@ -137,6 +173,7 @@ func LoadPluginsFromConfig(config *PluginConfig, manager *Manager) error {
 		if exec != nil {
 			log.Printf("Registering executor for type: %s", taskType)
 			manager.RegisterExecutor(exec)
+			manager.setConnections(exec.Type(), connectionNames(pluginDef.Config))
 		} else {
 			log.Printf("Executor for type %s is nil, skipping registration", taskType)
 		}
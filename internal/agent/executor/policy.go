@@ -0,0 +1,52 @@
+package executor
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/cloud-agent/internal/agent/plugins"
+	"github.com/cloud-agent/internal/common"
+)
+
+// CheckPolicy 按安全配置中的任务策略再次评估 Cloud 下发的任务，env 为 Agent 所在环境
+// 策略拒绝或要求审批但任务没有审批人时返回 plugins.ErrSecurityRejected
+func (m *Manager) CheckPolicy(task *common.TaskCreateData, env string) error {
+	m.mu.RLock()
+	policy := m.policy
+	m.mu.RUnlock()
+
+	decision := policy.Evaluate(&common.PolicyInput{
+		User:     task.CreatedBy,
+		Env:      env,
+		Tags:     task.AgentTags,
+		TaskType: task.Type,
+		Command:  task.Command,
+		Params:   task.Params,
+	})
+	reason := ""
+	if decision.Reason != "" {
+		reason = ": " + decision.Reason
+	}
+	switch {
+	case decision.Effect == common.PolicyEffectDeny:
+		return fmt.Errorf("%w: denied by policy rule %q%s", plugins.ErrSecurityRejected, decision.Rule, reason)
+	case decision.Effect == common.PolicyEffectRequireApproval && task.ApprovedBy == "":
+		return fmt.Errorf("%w: policy rule %q requires approval%s", plugins.ErrSecurityRejected, decision.Rule, reason)
+	}
+	return nil
+}
+
+// loadPolicy 从安全配置文件读取任务策略，文件不存在时允许所有任务
+// 策略无效时拒绝所有任务，避免配置错误导致策略失效
+func loadPolicy(path string) *common.PolicyEngine {
+	config := readSecurityConfig(path)
+	if config == nil {
+		return nil
+	}
+	policy, err := common.NewPolicyEngine(&config.Policy)
+	if err != nil {
+		log.Printf("Invalid task policy in %s, denying all tasks: %v", path, err)
+		policy, _ = common.NewPolicyEngine(&common.PolicyConfig{Default: common.PolicyEffectDeny})
+	}
+	return policy
+}
@@ -0,0 +1,48 @@
+package executor
+
+import (
+	"log"
+	"os"
+
+	"github.com/cloud-agent/internal/agent/security"
+	"github.com/cloud-agent/internal/common"
+	"gopkg.in/yaml.v3"
+)
+
+// Redact 按安全配置中的脱敏规则和已解析的密钥脱敏，在日志和结果上报 Cloud 前调用
+func (m *Manager) Redact(s string) string {
+	m.mu.RLock()
+	resolver, redactor := m.secrets, m.redactor
+	m.mu.RUnlock()
+	return redactor.Redact(resolver.Redact(s))
+}
+
+// readSecurityConfig 读取安全配置文件，路径为空、文件不存在或格式错误时返回 nil
+func readSecurityConfig(path string) *security.SecurityConfig {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var config security.SecurityConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil
+	}
+	return &config
+}
+
+// loadRedactor 从安全配置文件读取脱敏规则，文件不存在或规则无效时只使用内置规则
+func loadRedactor(path string) *common.Redactor {
+	config := readSecurityConfig(path)
+	if config == nil {
+		return common.DefaultRedactor()
+	}
+	redactor, err := common.NewRedactor(&config.Redaction)
+	if err != nil {
+		log.Printf("Invalid redaction rules in %s, using builtin rules: %v", path, err)
+		return common.DefaultRedactor()
+	}
+	return redactor
+}
@@ -0,0 +1,94 @@
+package executor
+
+import (
+	"io"
+	"log"
+	"sync"
+
+	"github.com/cloud-agent/internal/agent/plugins"
+	"github.com/cloud-agent/internal/agent/security"
+	"github.com/cloud-agent/internal/common"
+)
+
+// ReloadPlugins 按新的插件配置重建全部执行器并整体替换
+// 任一插件创建失败时返回错误并保留当前执行器；执行中的任务继续使用旧执行器，结束后关闭旧执行器
+func (m *Manager) ReloadPlugins(config *PluginConfig) error {
+	m.mu.RLock()
+	staged := &Manager{
+		executors:          make(map[common.TaskType]plugins.Executor),
+		connections:        make(map[common.TaskType][]string),
+		agentID:            m.agentID,
+		securityConfigPath: m.securityConfigPath,
+		securityConfig:     m.securityConfig,
+		secrets:            m.secrets,
+	}
+	m.mu.RUnlock()
+
+	if err := LoadPluginsFromConfig(config, staged); err != nil {
+		closeExecutors(staged.executors)
+		return err
+	}
+
+	m.mu.Lock()
+	old, oldActive := m.executors, m.active
+	m.executors = staged.executors
+	m.connections = staged.connections
+	m.secrets = staged.secrets
+	m.pluginConfig = staged.pluginConfig
+	m.secretsPending = staged.secretsPending
+	m.active = &sync.WaitGroup{}
+	m.mu.Unlock()
+
+	log.Printf("Reloaded executors: %v", m.GetRegisteredExecutors())
+	go func() {
+		if oldActive != nil {
+			oldActive.Wait()
+		}
+		closeExecutors(old)
+	}()
+	return nil
+}
+
+// UpdateSecurityConfig 替换执行器的安全配置，之后重新加载插件时也使用该配置
+// 配置无效时返回错误，不做任何修改
+func (m *Manager) UpdateSecurityConfig(config *security.SecurityConfig) error {
+	if _, err := security.NewCommandValidator(config); err != nil {
+		return err
+	}
+	if err := security.ValidateShellConfig(config); err != nil {
+		return err
+	}
+	redactor, err := common.NewRedactor(&config.Redaction)
+	if err != nil {
+		return err
+	}
+	policy, err := common.NewPolicyEngine(&config.Policy)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for taskType, exec := range m.executors {
+		if configurable, ok := exec.(plugins.SecurityConfigurable); ok {
+			if err := configurable.UpdateSecurityConfig(config); err != nil {
+				log.Printf("Failed to update security config of %s executor: %v", taskType, err)
+			}
+		}
+	}
+	m.securityConfig = config
+	m.redactor = redactor
+	m.policy = policy
+	return nil
+}
+
+// closeExecutors 关闭持有连接等资源的执行器
+func closeExecutors(executors map[common.TaskType]plugins.Executor) {
+	for taskType, exec := range executors {
+		if closer, ok := exec.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				log.Printf("Failed to close %s executor: %v", taskType, err)
+			}
+		}
+	}
+}
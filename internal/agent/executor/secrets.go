@@ -0,0 +1,44 @@
+package executor
+
+import (
+	"log"
+
+	"github.com/cloud-agent/internal/agent/secrets"
+	"github.com/cloud-agent/internal/common"
+)
+
+// SetCloudSecrets 替换 Cloud 下发的密钥库密钥
+// 插件配置中有未能解析的引用，或引用的密钥可能已更新时，按最近加载的插件配置重建执行器
+func (m *Manager) SetCloudSecrets(values []common.SecretValue) {
+	m.mu.RLock()
+	resolver, config, pending := m.secrets, m.pluginConfig, m.secretsPending
+	m.mu.RUnlock()
+
+	resolver.SetCloudSecrets(values)
+	if config == nil || !(pending || usesSecrets(config)) {
+		return
+	}
+	if err := m.ReloadPlugins(config); err != nil {
+		log.Printf("Failed to reload plugins after secrets update: %v", err)
+	}
+}
+
+// usesSecrets 插件配置中是否引用了密钥
+func usesSecrets(config *PluginConfig) bool {
+	for _, def := range config.Plugins {
+		if def.Enabled && secrets.ContainsRef(def.Config) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactedError 错误信息脱敏后的错误，保留原错误用于 errors.Is 判断
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string { return e.msg }
+
+func (e *redactedError) Unwrap() error { return e.err }
@@ -0,0 +1,87 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/cloud-agent/internal/agent/jobs"
+	"github.com/cloud-agent/internal/common"
+)
+
+// detached 任务是否以后台任务方式运行（Shell 任务 params.detach 为 true）
+func detached(taskData *common.TaskCreateData) bool {
+	detach, _ := taskData.Params["detach"].(bool)
+	return taskData.Type == common.TaskTypeShell && detach
+}
+
+// syncJobs 连接 Cloud 后上报 Cloud 尚未确认结果的后台任务，Cloud 据此重新关联任务、保存已上报的结果
+func (a *Agent) syncJobs() {
+	completed := a.jobs.Completed()
+	for i := range completed {
+		a.redactComplete(&completed[i])
+	}
+	msg := common.NewMessage(common.MessageTypeJobSync, common.JobSyncData{Jobs: a.jobs.List(), Completed: completed})
+	if err := a.client.SendMessage(msg); err != nil {
+		log.Printf("Failed to sync detached jobs: %v", err)
+	}
+}
+
+// handleJobAck Cloud 确认已保存后台任务的结果，删除任务目录
+func (a *Agent) handleJobAck(msg *common.Message) {
+	dataBytes, _ := json.Marshal(msg.Data)
+	var data common.JobAckData
+	if err := json.Unmarshal(dataBytes, &data); err != nil || data.TaskID == "" {
+		return
+	}
+	if err := a.jobs.Ack(data.TaskID); err != nil && !errors.Is(err, jobs.ErrJobNotFound) {
+		log.Printf("Failed to remove detached job of task %s: %v", data.TaskID, err)
+	}
+}
+
+// redactComplete 上报后台任务结果前按脱敏规则处理
+func (a *Agent) redactComplete(data *common.TaskCompleteData) {
+	data.Result = a.executor.Redact(data.Result)
+	data.Error = a.executor.Redact(data.Error)
+}
+
+// killJob 取消后台任务，任务不是后台任务时返回 false
+func (a *Agent) killJob(taskID string) bool {
+	err := a.jobs.Kill(taskID)
+	if errors.Is(err, jobs.ErrJobNotFound) {
+		return false
+	}
+	if err != nil {
+		log.Printf("Failed to kill detached job of task %s: %v", taskID, err)
+	}
+	return true
+}
+
+// jobSink 通过 Cloud 连接上报后台任务的日志、状态和结果，发送前按脱敏规则处理
+// 未连接时返回错误，后台任务管理器重新连接后继续上报
+type jobSink struct {
+	agent *Agent
+}
+
+// SendLog 上报后台任务的输出
+func (s *jobSink) SendLog(taskID, level, message string) error {
+	return s.agent.client.SendMessage(common.NewMessage(common.MessageTypeTaskLog, common.TaskLogData{
+		TaskID:    taskID,
+		Level:     level,
+		Message:   s.agent.executor.Redact(message),
+		Timestamp: time.Now().Unix(),
+	}))
+}
+
+// SendStatus 上报后台任务的状态和进度
+func (s *jobSink) SendStatus(status *common.JobStatus) error {
+	status.LastOutput = s.agent.executor.Redact(status.LastOutput)
+	return s.agent.client.SendMessage(common.NewMessage(common.MessageTypeJobStatus, status))
+}
+
+// SendComplete 上报后台任务的结果
+func (s *jobSink) SendComplete(data *common.TaskCompleteData) error {
+	s.agent.redactComplete(data)
+	return s.agent.client.SendMessage(common.NewMessage(common.MessageTypeTaskComplete, data))
+}
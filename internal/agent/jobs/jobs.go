@@ -0,0 +1,578 @@
+// Package jobs 运行和监护后台任务（detached job）
+//
+// 每个后台任务由 Agent 以监护进程方式重新执行自身来运行，监护进程与 Agent 不在同一会话中，
+// Agent 退出或重启后命令继续运行。任务的定义、输出和结束状态保存在任务目录中，
+// Agent 定期读取新的输出上报为任务日志，命令结束且日志上报完后上报任务结果，Cloud 确认保存结果后删除任务目录。
+package jobs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloud-agent/internal/agent/security"
+	"github.com/cloud-agent/internal/common"
+)
+
+// 任务目录中的文件
+const (
+	specFile       = "job.json"        // 任务定义，Agent 写入
+	supervisorFile = "supervisor.json" // 监护进程，Agent 写入
+	stateFile      = "state.json"      // 命令的进程，监护进程启动命令后写入
+	resultFile     = "result.json"     // 结束状态，监护进程写入
+	offsetsFile    = "offsets.json"    // 已上报的输出位置，Agent 写入
+	cancelFile     = "canceled"        // 任务被取消，Agent 写入
+	completeFile   = "complete.json"   // 已上报、等待 Cloud 确认的任务结果，Agent 写入
+	stdoutFile     = "stdout.log"
+	stderrFile     = "stderr.log"
+	helperLogFile  = "supervisor.log" // 监护进程自身的错误输出
+)
+
+// killGracePeriod 结束命令时发送 SIGTERM 后等待的时间，超时后发送 SIGKILL
+const killGracePeriod = 10 * time.Second
+
+// maxLine 单条日志的上限，超过时按该长度拆分
+const maxLine = 64 << 10
+
+// maxResult 任务结果中保留的 stdout 末尾的字节数
+const maxResult = 64 << 10
+
+// completedRetention 已上报结果的任务等待 Cloud 确认的最长时间，超时后删除任务目录（旧版本 Cloud 不确认结果）
+const completedRetention = 24 * time.Hour
+
+// ErrJobNotFound 任务不是后台任务或 Cloud 已确认结果
+var ErrJobNotFound = common.NewError("job not found")
+
+// ErrJobExists 任务已作为后台任务启动（例如任务被重复下发）
+var ErrJobExists = common.NewError("job already exists")
+
+// Spec 后台任务的定义
+type Spec struct {
+	TaskID           string                 `json:"task_id"`
+	Command          string                 `json:"command"` // 日志中显示的命令
+	Argv             []string               `json:"argv"`
+	Dir              string                 `json:"dir,omitempty"`   // 执行配置没有工作目录时使用
+	Env              []string               `json:"env,omitempty"`   // 追加的环境变量，任务参数中的原值
+	Stdin            *string                `json:"stdin,omitempty"` // 未设置时标准输入为空，任务参数中的原值
+	ProfileName      string                 `json:"profile_name,omitempty"`
+	Profile          *security.ShellProfile `json:"profile,omitempty"`
+	Timeout          time.Duration          `json:"timeout,omitempty"` // 运行时长上限，0 表示不限制
+	SuccessExitCodes []int                  `json:"success_exit_codes,omitempty"`
+	Cleanup          []string               `json:"cleanup,omitempty"` // 命令结束后删除的路径（如脚本临时目录）
+}
+
+// Sink 上报后台任务的日志、状态和结果，返回错误时（如与 Cloud 断开）稍后重试
+type Sink interface {
+	SendLog(taskID, level, message string) error
+	SendStatus(status *common.JobStatus) error
+	SendComplete(data *common.TaskCompleteData) error
+}
+
+// processState 命令的进程
+type processState struct {
+	PID       int       `json:"pid"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// result 命令的结束状态
+type result struct {
+	ExitCode   int       `json:"exit_code"`
+	Signal     string    `json:"signal,omitempty"`
+	TimedOut   bool      `json:"timed_out,omitempty"`
+	Error      string    `json:"error,omitempty"` // 命令无法启动等错误
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// offsets 已上报的日志位置
+type offsets struct {
+	Stdout     int64  `json:"stdout"`
+	Stderr     int64  `json:"stderr"`
+	LastOutput string `json:"last_output,omitempty"`
+}
+
+// job Agent 内存中的后台任务
+type job struct {
+	dir         string
+	spec        *Spec
+	supervisor  int
+	offsets     offsets
+	lastStatus  time.Time
+	completed   *common.TaskCompleteData // 已上报、等待 Cloud 确认的结果
+	completedAt time.Time
+}
+
+// Manager 后台任务管理器，任务保存在 root 下的子目录中
+type Manager struct {
+	root           string
+	PollInterval   time.Duration // 检查输出和结束状态的间隔
+	StatusInterval time.Duration // 运行中的任务上报状态的间隔
+
+	mu   sync.Mutex
+	jobs map[string]*job // 任务 ID -> 任务
+
+	// pollMu 保证同一时间只有一次上报，任务的上报位置只由 Poll 修改
+	pollMu sync.Mutex
+}
+
+// NewManager 创建后台任务管理器，root 在启动第一个任务时创建
+// root 转换为绝对路径，Agent 在其他目录重启后仍能识别监护进程
+func NewManager(root string) *Manager {
+	if abs, err := filepath.Abs(root); err == nil {
+		root = abs
+	}
+	return &Manager{
+		root:           root,
+		PollInterval:   time.Second,
+		StatusInterval: 30 * time.Second,
+		jobs:           make(map[string]*job),
+	}
+}
+
+// dirName 任务目录名，只保留任务 ID 中的字母、数字、- 和 _
+func dirName(taskID string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, taskID)
+}
+
+// Start 保存任务定义并启动监护进程
+func (m *Manager) Start(spec *Spec) error {
+	if spec.TaskID == "" || len(spec.Argv) == 0 {
+		return common.NewError("invalid job: task id and command are required")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.load()
+	if _, ok := m.jobs[spec.TaskID]; ok {
+		return ErrJobExists
+	}
+
+	if err := os.MkdirAll(m.root, 0o700); err != nil {
+		return fmt.Errorf("failed to create jobs dir: %w", err)
+	}
+	dir := filepath.Join(m.root, dirName(spec.TaskID))
+	if err := os.Mkdir(dir, 0o700); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return ErrJobExists
+		}
+		return fmt.Errorf("failed to create job dir: %w", err)
+	}
+	// 任务定义中的 Env 和 Stdin 是任务参数中的原值，可能包含调用方写入的敏感信息（secret:// 引用不会解析到 Shell 任务中）：
+	// 监护进程在 Agent 重启、与 Cloud 断开时也要独立启动命令，因此原样保存。任务目录为 0700、文件为 0600，只有 Agent 用户可读，
+	// Cloud 确认结果后整个目录被删除
+	if err := writeJSON(filepath.Join(dir, specFile), spec); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	pid, err := startSupervisor(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		return fmt.Errorf("failed to start job supervisor: %w", err)
+	}
+	if err := writeJSON(filepath.Join(dir, supervisorFile), processState{PID: pid, StartedAt: time.Now()}); err != nil {
+		log.Printf("[jobs] failed to record supervisor of task %s: %v", spec.TaskID, err)
+	}
+	m.jobs[spec.TaskID] = &job{dir: dir, spec: spec, supervisor: pid}
+	return nil
+}
+
+// Kill 取消后台任务：先发送 SIGTERM，命令在 10 秒内没有结束时发送 SIGKILL
+func (m *Manager) Kill(taskID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.load()
+	j, ok := m.jobs[taskID]
+	if !ok {
+		return ErrJobNotFound
+	}
+	if err := os.WriteFile(filepath.Join(j.dir, cancelFile), nil, 0o600); err != nil {
+		return fmt.Errorf("failed to mark job canceled: %w", err)
+	}
+	if fileExists(filepath.Join(j.dir, resultFile)) || !supervisorAlive(j.supervisor, j.dir) {
+		return nil
+	}
+	return terminate(j.supervisor)
+}
+
+// Running 返回未结束的后台任务数
+func (m *Manager) Running() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.load()
+	n := 0
+	for _, j := range m.jobs {
+		if !fileExists(filepath.Join(j.dir, resultFile)) {
+			n++
+		}
+	}
+	return n
+}
+
+// List 返回 Cloud 尚未确认结果的后台任务的状态，Agent 连接 Cloud 后通过 job.sync 上报
+func (m *Manager) List() []common.JobStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.load()
+	statuses := make([]common.JobStatus, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		status, _ := j.status()
+		statuses = append(statuses, *status)
+	}
+	slices.SortFunc(statuses, func(a, b common.JobStatus) int { return strings.Compare(a.TaskID, b.TaskID) })
+	return statuses
+}
+
+// Completed 返回已上报、Cloud 尚未确认的任务结果，Agent 连接 Cloud 后随 job.sync 再次上报
+func (m *Manager) Completed() []common.TaskCompleteData {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.load()
+	completed := make([]common.TaskCompleteData, 0)
+	for _, j := range m.jobs {
+		if j.completed != nil {
+			completed = append(completed, *j.completed)
+		}
+	}
+	slices.SortFunc(completed, func(a, b common.TaskCompleteData) int { return strings.Compare(a.TaskID, b.TaskID) })
+	return completed
+}
+
+// Ack Cloud 确认已保存任务结果后删除任务目录，任务尚未上报结果时返回 ErrJobNotFound
+func (m *Manager) Ack(taskID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.load()
+	j, ok := m.jobs[taskID]
+	if !ok || j.completed == nil {
+		return ErrJobNotFound
+	}
+	m.remove(j)
+	return nil
+}
+
+// remove 删除任务目录，调用方需持有锁
+func (m *Manager) remove(j *job) {
+	if err := os.RemoveAll(j.dir); err != nil {
+		log.Printf("[jobs] failed to remove job dir of task %s: %v", j.spec.TaskID, err)
+	}
+	delete(m.jobs, j.spec.TaskID)
+}
+
+// Run 定期上报后台任务的日志、状态和结果，直到 stop 关闭
+func (m *Manager) Run(stop <-chan struct{}, sink Sink) {
+	ticker := time.NewTicker(m.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			m.Poll(sink)
+		}
+	}
+}
+
+// Poll 上报一次后台任务的新日志、状态和结果，上报失败时保留位置，下次继续
+// 上报时不持有 m.mu，发送阻塞（如连接拥塞）时不影响启动、取消和查询任务
+func (m *Manager) Poll(sink Sink) {
+	m.pollMu.Lock()
+	defer m.pollMu.Unlock()
+
+	m.mu.Lock()
+	m.load()
+	jobs := make([]job, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		jobs = append(jobs, *j)
+	}
+	m.mu.Unlock()
+
+	for i := range jobs {
+		j := &jobs[i]
+		if j.completed != nil {
+			// 等待 Cloud 确认，超过保留时间仍未确认时删除
+			if time.Since(j.completedAt) >= completedRetention {
+				m.mu.Lock()
+				if current, ok := m.jobs[j.spec.TaskID]; ok {
+					log.Printf("[jobs] result of task %s was not acknowledged by cloud, removing job dir", j.spec.TaskID)
+					m.remove(current)
+				}
+				m.mu.Unlock()
+			}
+			continue
+		}
+		err := j.poll(sink, m.StatusInterval)
+		m.mu.Lock()
+		if current, ok := m.jobs[j.spec.TaskID]; ok {
+			current.offsets, current.lastStatus = j.offsets, j.lastStatus
+			current.completed, current.completedAt = j.completed, j.completedAt
+		}
+		m.mu.Unlock()
+		if err != nil {
+			// 通常是与 Cloud 断开，重新连接后继续
+			return
+		}
+	}
+}
+
+// load 加载 root 下尚未加载的任务（Agent 重启后恢复），调用方需持有锁
+func (m *Manager) load() {
+	entries, err := os.ReadDir(m.root)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(m.root, entry.Name())
+		var spec Spec
+		if err := readJSON(filepath.Join(dir, specFile), &spec); err != nil || spec.TaskID == "" {
+			continue
+		}
+		if _, ok := m.jobs[spec.TaskID]; ok {
+			continue
+		}
+		j := &job{dir: dir, spec: &spec}
+		var supervisor processState
+		if err := readJSON(filepath.Join(dir, supervisorFile), &supervisor); err == nil {
+			j.supervisor = supervisor.PID
+		}
+		readJSON(filepath.Join(dir, offsetsFile), &j.offsets)
+		var completed common.TaskCompleteData
+		if info, err := os.Stat(filepath.Join(dir, completeFile)); err == nil && readJSON(filepath.Join(dir, completeFile), &completed) == nil {
+			j.completed, j.completedAt = &completed, info.ModTime()
+		}
+		m.jobs[spec.TaskID] = j
+		log.Printf("[jobs] resumed job of task %s", spec.TaskID)
+	}
+}
+
+// status 返回任务的状态和结束状态（未结束时为 nil）
+func (j *job) status() (*common.JobStatus, *result) {
+	status := &common.JobStatus{
+		TaskID:     j.spec.TaskID,
+		State:      common.JobStateRunning,
+		LastOutput: j.offsets.LastOutput,
+		UpdatedAt:  time.Now().Unix(),
+	}
+	var state processState
+	if err := readJSON(filepath.Join(j.dir, stateFile), &state); err == nil {
+		status.PID = state.PID
+		status.StartedAt = state.StartedAt.Unix()
+	}
+	for _, name := range []string{stdoutFile, stderrFile} {
+		if info, err := os.Stat(filepath.Join(j.dir, name)); err == nil {
+			status.OutputBytes += info.Size()
+		}
+	}
+
+	var res result
+	err := readJSON(filepath.Join(j.dir, resultFile), &res)
+	if err != nil && j.supervisor > 0 && supervisorAlive(j.supervisor, j.dir) {
+		return status, nil
+	}
+	// 监护进程可能在检查存活之前刚写入结果
+	if err != nil {
+		err = readJSON(filepath.Join(j.dir, resultFile), &res)
+	}
+	if err != nil {
+		status.State = common.JobStateLost
+		return status, &result{ExitCode: -1, Error: "job supervisor exited without reporting a result", FinishedAt: time.Now()}
+	}
+	status.State = common.JobStateExited
+	status.FinishedAt = res.FinishedAt.Unix()
+	exitCode := res.ExitCode
+	status.ExitCode = &exitCode
+	status.Signal = res.Signal
+	return status, &res
+}
+
+// poll 上报任务的新日志；命令结束后上报剩余日志、最终状态和结果，结果保存在 j.completed
+// 结果在上报前保存到任务目录，Cloud 确认前（包括上报失败时）Agent 重新连接后随 job.sync 再次上报
+func (j *job) poll(sink Sink, statusInterval time.Duration) error {
+	status, res := j.status()
+	final := res != nil
+	changed := false
+	defer func() {
+		if changed {
+			if err := writeJSON(filepath.Join(j.dir, offsetsFile), &j.offsets); err != nil {
+				log.Printf("[jobs] failed to save log offsets of task %s: %v", j.spec.TaskID, err)
+			}
+		}
+	}()
+
+	for _, stream := range []struct {
+		name   string
+		offset *int64
+		level  string
+	}{{stdoutFile, &j.offsets.Stdout, "info"}, {stderrFile, &j.offsets.Stderr, "error"}} {
+		n, err := j.sendLines(sink, stream.name, *stream.offset, stream.level, final)
+		if n > 0 {
+			*stream.offset += n
+			changed = true
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if !final {
+		if time.Since(j.lastStatus) >= statusInterval {
+			if err := sink.SendStatus(status); err != nil {
+				return err
+			}
+			j.lastStatus = time.Now()
+		}
+		return nil
+	}
+
+	status.LastOutput = j.offsets.LastOutput
+	if err := sink.SendStatus(status); err != nil {
+		return err
+	}
+	data := j.complete(res)
+	if err := writeJSON(filepath.Join(j.dir, completeFile), data); err != nil {
+		log.Printf("[jobs] failed to save result of task %s: %v", j.spec.TaskID, err)
+	}
+	j.completed, j.completedAt = data, time.Now()
+	return sink.SendComplete(data)
+}
+
+// sendLines 从 offset 开始上报文件中的完整行，final 为 true 时同时上报末尾不完整的行
+// 返回已上报的字节数
+func (j *job) sendLines(sink Sink, name string, offset int64, level string, final bool) (int64, error) {
+	f, err := os.Open(filepath.Join(j.dir, name))
+	if err != nil {
+		return 0, nil
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, nil
+	}
+	data, err := io.ReadAll(io.LimitReader(f, 1<<20))
+	if err != nil {
+		return 0, nil
+	}
+
+	var sent int64
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		var line []byte
+		switch {
+		case i >= 0 && i < maxLine:
+			line = data[:i+1]
+		case len(data) >= maxLine:
+			line = data[:maxLine]
+		case final:
+			line = data
+		default:
+			return sent, nil
+		}
+		if text := strings.TrimRight(string(line), "\r\n"); strings.TrimSpace(text) != "" {
+			if err := sink.SendLog(j.spec.TaskID, level, text); err != nil {
+				return sent, err
+			}
+			j.offsets.LastOutput = text
+		}
+		sent += int64(len(line))
+		data = data[len(line):]
+	}
+	return sent, nil
+}
+
+// complete 根据结束状态构造任务结果，结果为 stdout 末尾最多 64 KiB
+func (j *job) complete(res *result) *common.TaskCompleteData {
+	data := &common.TaskCompleteData{
+		TaskID:    j.spec.TaskID,
+		Status:    common.TaskStatusFailed,
+		Result:    tail(filepath.Join(j.dir, stdoutFile), maxResult),
+		Timestamp: time.Now().Unix(),
+		Detached:  true,
+	}
+	successCodes := j.spec.SuccessExitCodes
+	if len(successCodes) == 0 {
+		successCodes = []int{0}
+	}
+	exit := fmt.Sprintf("exit status %d", res.ExitCode)
+	if res.Signal != "" {
+		exit = "signal: " + res.Signal
+	}
+	switch {
+	case fileExists(filepath.Join(j.dir, cancelFile)):
+		data.Status = common.TaskStatusCanceled
+		data.Error = "job canceled: " + exit
+	case res.Error != "":
+		data.Error = res.Error
+	case res.TimedOut:
+		data.Error = fmt.Sprintf("job exceeded max duration %s: %s", j.spec.Timeout, exit)
+	case res.Signal == "" && slices.Contains(successCodes, res.ExitCode):
+		data.Status = common.TaskStatusSuccess
+	default:
+		data.Error = "command failed: " + exit
+	}
+	return data
+}
+
+// tail 返回文件末尾最多 n 字节，从第一个完整行开始
+func tail(path string, n int64) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return ""
+	}
+	offset := max(info.Size()-n, 0)
+	data, err := io.ReadAll(io.NewSectionReader(f, offset, n))
+	if err != nil {
+		return ""
+	}
+	if offset > 0 {
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			data = data[i+1:]
+		}
+	}
+	return string(data)
+}
+
+// writeJSON 原子地写入 JSON 文件
+func writeJSON(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// readJSON 读取 JSON 文件
+func readJSON(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// fileExists 文件是否存在
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
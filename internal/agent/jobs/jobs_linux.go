@@ -0,0 +1,257 @@
+//go:build linux
+
+package jobs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cloud-agent/internal/agent/sandbox"
+)
+
+// helperArg Agent 以后台任务监护进程方式运行时的第一个参数
+const helperArg = "__job-run"
+
+// startSupervisor 在新会话中启动监护进程，Agent 退出后监护进程和命令继续运行
+func startSupervisor(dir string) (int, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return 0, fmt.Errorf("failed to locate agent executable: %w", err)
+	}
+	logFile, err := os.OpenFile(filepath.Join(dir, helperLogFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return 0, err
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(self, helperArg, dir)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	// 回收监护进程，避免 Agent 运行期间产生僵尸进程
+	go cmd.Wait()
+	return cmd.Process.Pid, nil
+}
+
+// supervisorAlive 监护进程是否仍在运行，通过命令行确认 PID 没有被其他进程复用
+func supervisorAlive(pid int, dir string) bool {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return false
+	}
+	args := strings.Split(strings.TrimRight(string(data), "\x00"), "\x00")
+	if len(args) < 3 || args[1] != helperArg || args[2] != dir {
+		return false
+	}
+	// 已退出但尚未被回收的进程
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	if i := bytes.LastIndexByte(stat, ')'); i >= 0 && i+2 < len(stat) && stat[i+2] == 'Z' {
+		return false
+	}
+	return true
+}
+
+// terminate 通知监护进程结束命令
+func terminate(pid int) error {
+	if err := syscall.Kill(pid, syscall.SIGTERM); err != nil && !errors.Is(err, syscall.ESRCH) {
+		return fmt.Errorf("failed to signal job supervisor: %w", err)
+	}
+	return nil
+}
+
+// RunHelper Agent 作为后台任务监护进程启动时，运行任务目录中的命令并写入结束状态后退出
+// 不是监护进程时直接返回，需要在 Agent 的 main 函数开头调用
+func RunHelper() {
+	if len(os.Args) < 3 || os.Args[1] != helperArg {
+		return
+	}
+	if err := runHelper(os.Args[2]); err != nil {
+		fmt.Fprintf(os.Stderr, "job: %v\n", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// runHelper 监护进程的实现
+func runHelper(dir string) error {
+	// 尽早处理信号，Kill 可能在命令启动之前发生
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	signal.Ignore(syscall.SIGHUP)
+
+	var spec Spec
+	if err := readJSON(filepath.Join(dir, specFile), &spec); err != nil {
+		return fmt.Errorf("failed to read job spec: %w", err)
+	}
+	res := run(dir, &spec, signals)
+	res.FinishedAt = time.Now()
+	for _, path := range spec.Cleanup {
+		os.RemoveAll(path)
+	}
+	return writeJSON(filepath.Join(dir, resultFile), res)
+}
+
+// run 运行命令直到结束，收到 SIGTERM/SIGINT 或超过运行时长上限时结束命令的进程组
+func run(dir string, spec *Spec, signals <-chan os.Signal) *result {
+	failed := func(format string, args ...interface{}) *result {
+		return &result{ExitCode: -1, Error: fmt.Sprintf(format, args...)}
+	}
+	if fileExists(filepath.Join(dir, cancelFile)) {
+		return failed("job canceled before start")
+	}
+
+	cmd := exec.Command(spec.Argv[0], spec.Argv[1:]...)
+	cmd.Env = os.Environ()
+	if spec.Profile != nil {
+		cleanup, err := sandbox.Apply(cmd, spec.TaskID, spec.Profile)
+		if err != nil {
+			return failed("failed to apply shell profile %q: %v", spec.ProfileName, err)
+		}
+		defer cleanup()
+	}
+	if cmd.Dir == "" {
+		cmd.Dir = spec.Dir
+	}
+	cmd.Env = append(cmd.Env, spec.Env...)
+	if spec.Stdin != nil {
+		cmd.Stdin = strings.NewReader(*spec.Stdin)
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	// 命令在独立的进程组中运行，结束时连同其子进程一起结束
+	cmd.SysProcAttr.Setpgid = true
+	cmd.WaitDelay = killGracePeriod
+
+	limit := spec.Profile.OutputLimit()
+	stdout, err := openOutput(filepath.Join(dir, stdoutFile), limit)
+	if err != nil {
+		return failed("failed to create output file: %v", err)
+	}
+	defer stdout.Close()
+	stderr, err := openOutput(filepath.Join(dir, stderrFile), limit)
+	if err != nil {
+		return failed("failed to create output file: %v", err)
+	}
+	defer stderr.Close()
+	cmd.Stdout = stdout.writer()
+	cmd.Stderr = stderr.writer()
+
+	if err := cmd.Start(); err != nil {
+		return failed("failed to start command: %v", err)
+	}
+	pid := cmd.Process.Pid
+	if err := writeJSON(filepath.Join(dir, stateFile), processState{PID: pid, StartedAt: time.Now()}); err != nil {
+		fmt.Fprintf(os.Stderr, "job: failed to record process: %v\n", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	var timeout <-chan time.Time
+	if spec.Timeout > 0 {
+		timer := time.NewTimer(spec.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	res := &result{}
+	var waitErr error
+	select {
+	case waitErr = <-done:
+	case <-signals:
+		waitErr = killGroup(pid, done)
+	case <-timeout:
+		res.TimedOut = true
+		waitErr = killGroup(pid, done)
+	}
+
+	res.ExitCode = cmd.ProcessState.ExitCode()
+	if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		res.Signal = status.Signal().String()
+	}
+	var exitErr *exec.ExitError
+	if waitErr != nil && !errors.As(waitErr, &exitErr) && !errors.Is(waitErr, exec.ErrWaitDelay) {
+		res.Error = waitErr.Error()
+	}
+	return res
+}
+
+// killGroup 向命令的进程组发送 SIGTERM，10 秒内没有结束时发送 SIGKILL
+func killGroup(pid int, done <-chan error) error {
+	syscall.Kill(-pid, syscall.SIGTERM)
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(killGracePeriod):
+		syscall.Kill(-pid, syscall.SIGKILL)
+		return <-done
+	}
+}
+
+// output 命令的输出文件，limit 大于 0 时只保留前 limit 字节
+type output struct {
+	f         *os.File
+	limit     int64
+	written   int64
+	truncated bool
+}
+
+// openOutput 创建输出文件
+func openOutput(path string, limit int64) (*output, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &output{f: f, limit: limit}, nil
+}
+
+// writer 没有输出上限时命令直接写入文件
+func (o *output) writer() io.Writer {
+	if o.limit <= 0 {
+		return o.f
+	}
+	return o
+}
+
+// Write 写入不超过上限的部分，第一次超过时写入截断说明
+func (o *output) Write(p []byte) (int, error) {
+	if o.truncated {
+		return len(p), nil
+	}
+	if remain := o.limit - o.written; int64(len(p)) > remain {
+		o.truncated = true
+		if _, err := o.f.Write(p[:remain]); err != nil {
+			return 0, err
+		}
+		note := "[output truncated at " + strconv.FormatInt(o.limit, 10) + " bytes]\n"
+		if remain > 0 && p[remain-1] != '\n' {
+			note = "\n" + note
+		}
+		o.f.WriteString(note)
+		return len(p), nil
+	}
+	n, err := o.f.Write(p)
+	o.written += int64(n)
+	return n, err
+}
+
+// Close 关闭输出文件
+func (o *output) Close() error {
+	return o.f.Close()
+}
@@ -0,0 +1,302 @@
+//go:build linux
+
+package jobs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloud-agent/internal/agent/sandbox"
+	"github.com/cloud-agent/internal/common"
+)
+
+// TestMain 测试二进制同样作为监护进程和沙箱辅助进程使用
+func TestMain(m *testing.M) {
+	RunHelper()
+	sandbox.RunHelper()
+	os.Exit(m.Run())
+}
+
+// fakeSink 记录上报的内容，fail 为 true 时上报失败（模拟与 Cloud 断开）
+type fakeSink struct {
+	mu       sync.Mutex
+	fail     bool
+	logs     []string
+	statuses []common.JobStatus
+	complete *common.TaskCompleteData
+}
+
+func (s *fakeSink) SendLog(taskID, level, message string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail {
+		return errors.New("not connected")
+	}
+	s.logs = append(s.logs, level+": "+message)
+	return nil
+}
+
+func (s *fakeSink) SendStatus(status *common.JobStatus) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail {
+		return errors.New("not connected")
+	}
+	s.statuses = append(s.statuses, *status)
+	return nil
+}
+
+func (s *fakeSink) SendComplete(data *common.TaskCompleteData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail {
+		return errors.New("not connected")
+	}
+	s.complete = data
+	return nil
+}
+
+// wait 轮询直到任务上报结果
+func wait(t *testing.T, m *Manager, sink *fakeSink) *common.TaskCompleteData {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		m.Poll(sink)
+		sink.mu.Lock()
+		complete := sink.complete
+		sink.mu.Unlock()
+		if complete != nil {
+			return complete
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("job did not complete")
+	return nil
+}
+
+func shellSpec(taskID, script string) *Spec {
+	return &Spec{TaskID: taskID, Command: script, Argv: []string{"sh", "-c", script}}
+}
+
+func TestJobCompletes(t *testing.T) {
+	m := NewManager(t.TempDir())
+	if err := m.Start(shellSpec("t1", "echo hello; echo; echo oops >&2; printf partial")); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if err := m.Start(shellSpec("t1", "true")); !errors.Is(err, ErrJobExists) {
+		t.Errorf("duplicate Start error = %v, want ErrJobExists", err)
+	}
+
+	sink := &fakeSink{}
+	complete := wait(t, m, sink)
+	if complete.Status != common.TaskStatusSuccess || complete.Result != "hello\n\npartial" {
+		t.Errorf("complete = %+v", complete)
+	}
+	if got := strings.Join(sink.logs, "|"); got != "info: hello|info: partial|error: oops" && got != "info: hello|error: oops|info: partial" {
+		t.Errorf("logs = %q", got)
+	}
+	final := sink.statuses[len(sink.statuses)-1]
+	if final.State != common.JobStateExited || final.ExitCode == nil || *final.ExitCode != 0 || final.PID == 0 || final.OutputBytes != 19 {
+		t.Errorf("final status = %+v", final)
+	}
+	if !complete.Detached {
+		t.Error("job result should be marked as detached")
+	}
+
+	// 上报结果后保留任务目录，Cloud 确认前随 job.sync 再次上报
+	if m.Running() != 0 {
+		t.Errorf("Running = %d, want 0", m.Running())
+	}
+	if jobs := m.List(); len(jobs) != 1 || jobs[0].State != common.JobStateExited {
+		t.Errorf("List before ack = %+v", jobs)
+	}
+	if completed := NewManager(m.root).Completed(); len(completed) != 1 || completed[0].TaskID != "t1" || completed[0].Status != common.TaskStatusSuccess {
+		t.Errorf("Completed after restart = %+v", completed)
+	}
+	sink.complete = nil
+	m.Poll(sink)
+	if sink.complete != nil {
+		t.Error("result should not be sent again before the next job.sync")
+	}
+
+	if err := m.Ack("missing"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Ack missing job error = %v", err)
+	}
+	if err := m.Ack("t1"); err != nil {
+		t.Fatalf("Ack failed: %v", err)
+	}
+	if entries, _ := os.ReadDir(m.root); len(entries) != 0 || len(m.List()) != 0 {
+		t.Errorf("job dir not removed after ack: %v", entries)
+	}
+}
+
+func TestJobResultKeptUntilAck(t *testing.T) {
+	m := NewManager(t.TempDir())
+	if err := m.Start(shellSpec("t1", "echo done")); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	// 运行中的任务不能确认
+	if err := m.Ack("t1"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Ack running job error = %v, want ErrJobNotFound", err)
+	}
+
+	// 上报结果时与 Cloud 断开：结果保留，重新连接后通过 Completed 随 job.sync 上报
+	sink := &completeFailSink{}
+	deadline := time.Now().Add(10 * time.Second)
+	for len(m.Completed()) == 0 && time.Now().Before(deadline) {
+		m.Poll(sink)
+		time.Sleep(20 * time.Millisecond)
+	}
+	completed := m.Completed()
+	if len(completed) != 1 || completed[0].Status != common.TaskStatusSuccess || completed[0].Result != "done\n" {
+		t.Fatalf("Completed = %+v", completed)
+	}
+
+	// Cloud 一直没有确认（旧版本 Cloud）时超过保留时间后删除
+	m.mu.Lock()
+	m.jobs["t1"].completedAt = time.Now().Add(-completedRetention)
+	m.mu.Unlock()
+	m.Poll(sink)
+	if entries, _ := os.ReadDir(m.root); len(entries) != 0 || len(m.List()) != 0 {
+		t.Errorf("unacknowledged job dir not removed after retention: %v", entries)
+	}
+}
+
+// completeFailSink 上报结果时失败（模拟上报结果时与 Cloud 断开）
+type completeFailSink struct {
+	fakeSink
+}
+
+func (s *completeFailSink) SendComplete(data *common.TaskCompleteData) error {
+	return errors.New("not connected")
+}
+
+func TestJobKill(t *testing.T) {
+	m := NewManager(t.TempDir())
+	if err := m.Start(shellSpec("t1", "echo started; sleep 60")); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if m.Running() != 1 {
+		t.Errorf("Running = %d, want 1", m.Running())
+	}
+	if err := m.Kill("missing"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Kill missing job error = %v", err)
+	}
+	if err := m.Kill("t1"); err != nil {
+		t.Fatalf("Kill failed: %v", err)
+	}
+	complete := wait(t, m, &fakeSink{})
+	if complete.Status != common.TaskStatusCanceled || !strings.Contains(complete.Error, "job canceled") {
+		t.Errorf("complete = %+v", complete)
+	}
+}
+
+func TestJobTimeoutAndExitCodes(t *testing.T) {
+	m := NewManager(t.TempDir())
+	spec := shellSpec("timeout", "sleep 60")
+	spec.Timeout = 100 * time.Millisecond
+	if err := m.Start(spec); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	complete := wait(t, m, &fakeSink{})
+	if complete.Status != common.TaskStatusFailed || !strings.Contains(complete.Error, "exceeded max duration 100ms: signal: terminated") {
+		t.Errorf("timeout complete = %+v", complete)
+	}
+
+	spec = shellSpec("code", "exit 3")
+	spec.SuccessExitCodes = []int{0, 3}
+	m.Start(spec)
+	if complete := wait(t, m, &fakeSink{}); complete.Status != common.TaskStatusSuccess {
+		t.Errorf("accepted exit code complete = %+v", complete)
+	}
+	m.Start(shellSpec("failed", "exit 4"))
+	if complete := wait(t, m, &fakeSink{}); complete.Status != common.TaskStatusFailed || complete.Error != "command failed: exit status 4" {
+		t.Errorf("failed complete = %+v", complete)
+	}
+}
+
+func TestJobSurvivesAgentRestart(t *testing.T) {
+	root := t.TempDir()
+	m := NewManager(root)
+	if err := m.Start(shellSpec("t1", "echo one; sleep 60")); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	// 与 Cloud 断开时不丢失日志
+	offline := &fakeSink{fail: true}
+	time.Sleep(200 * time.Millisecond)
+	m.Poll(offline)
+
+	// Agent 重启：新的管理器从任务目录恢复任务
+	restarted := NewManager(root)
+	jobs := restarted.List()
+	if len(jobs) != 1 || jobs[0].TaskID != "t1" || jobs[0].State != common.JobStateRunning {
+		t.Fatalf("List after restart = %+v", jobs)
+	}
+	sink := &fakeSink{}
+	restarted.Poll(sink)
+	if len(sink.logs) != 1 || sink.logs[0] != "info: one" {
+		t.Errorf("logs after restart = %v", sink.logs)
+	}
+	if err := restarted.Kill("t1"); err != nil {
+		t.Fatalf("Kill failed: %v", err)
+	}
+	if complete := wait(t, restarted, sink); complete.Status != common.TaskStatusCanceled {
+		t.Errorf("complete = %+v", complete)
+	}
+}
+
+// reentrantSink 上报时查询管理器，Poll 持有锁发送时会死锁
+type reentrantSink struct {
+	fakeSink
+	m *Manager
+}
+
+func (s *reentrantSink) SendLog(taskID, level, message string) error {
+	s.m.Running()
+	return s.fakeSink.SendLog(taskID, level, message)
+}
+
+func TestPollSendsWithoutLock(t *testing.T) {
+	m := NewManager(t.TempDir())
+	spec := shellSpec("t1", "echo hello")
+	spec.Env = []string{"TOKEN=secret-value"}
+	if err := m.Start(spec); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	// 任务定义包含密钥明文，只有 Agent 用户可读
+	dir := filepath.Join(m.root, "t1")
+	for path, want := range map[string]os.FileMode{dir: 0o700, filepath.Join(dir, specFile): 0o600} {
+		if info, err := os.Stat(path); err != nil || info.Mode().Perm() != want {
+			t.Errorf("%s mode = %v, want %v (err %v)", path, info.Mode().Perm(), want, err)
+		}
+	}
+
+	sink := &reentrantSink{m: m}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 500 {
+			m.Poll(sink)
+			sink.mu.Lock()
+			complete := sink.complete
+			sink.mu.Unlock()
+			if complete != nil {
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(15 * time.Second):
+		t.Fatal("Poll deadlocked while sending")
+	}
+	if sink.complete == nil || sink.complete.Status != common.TaskStatusSuccess || len(sink.logs) != 1 {
+		t.Errorf("complete = %+v, logs = %v", sink.complete, sink.logs)
+	}
+}
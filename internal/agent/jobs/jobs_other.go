@@ -0,0 +1,26 @@
+//go:build !linux
+
+package jobs
+
+import "errors"
+
+// errUnsupported 非 Linux 平台不支持后台任务
+var errUnsupported = errors.New("detached jobs are only supported on linux")
+
+// startSupervisor 非 Linux 平台不支持后台任务
+func startSupervisor(dir string) (int, error) {
+	return 0, errUnsupported
+}
+
+// supervisorAlive 非 Linux 平台不支持后台任务
+func supervisorAlive(pid int, dir string) bool {
+	return false
+}
+
+// terminate 非 Linux 平台不支持后台任务
+func terminate(pid int) error {
+	return errUnsupported
+}
+
+// RunHelper 非 Linux 平台没有后台任务监护进程
+func RunHelper() {}
@@ -0,0 +1,147 @@
+// Package metrics 提供 Agent 的 Prometheus 指标
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/cloud-agent/internal/common"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "agent"
+
+// 信号量范围标签
+const (
+	ScopeGlobal = "global" // 全局并发限制
+	ScopeType   = "type"   // 按任务类型的并发限制
+)
+
+// 插件错误原因标签
+const (
+	ReasonNotFound = "not_found" // 没有注册对应类型的执行器
+	ReasonExecute  = "execute"   // 执行器返回错误
+)
+
+// Metrics Agent 指标
+// 所有方法都可以在 nil 上调用，未启用指标时不做任何处理
+type Metrics struct {
+	registry         *prometheus.Registry
+	running          *prometheus.GaugeVec
+	concurrencyLimit *prometheus.GaugeVec
+	semaphoreWait    *prometheus.HistogramVec
+	tasks            *prometheus.CounterVec
+	taskDuration     *prometheus.HistogramVec
+	pluginErrors     *prometheus.CounterVec
+}
+
+// New 创建 Agent 指标，包含 Go 运行时和进程指标
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		running: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "executor_running_tasks",
+			Help:      "Number of tasks currently executing, by task type.",
+		}, []string{"type"}),
+		concurrencyLimit: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "executor_concurrency_limit",
+			Help:      "Configured concurrency limit; type=\"\" is the global limit.",
+		}, []string{"type"}),
+		semaphoreWait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "executor_semaphore_wait_seconds",
+			Help:      "Time tasks waited for a concurrency slot, by task type and limit scope.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+		}, []string{"type", "scope"}),
+		tasks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "executor_tasks_total",
+			Help:      "Number of tasks executed, by task type and result status.",
+		}, []string{"type", "status"}),
+		taskDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "executor_task_duration_seconds",
+			Help:      "Task execution time excluding semaphore wait, by task type.",
+			Buckets:   []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1800, 3600},
+		}, []string{"type"}),
+		pluginErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "plugin_errors_total",
+			Help:      "Number of plugin errors, by plugin (task type) and reason.",
+		}, []string{"plugin", "reason"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.running,
+		m.concurrencyLimit,
+		m.semaphoreWait,
+		m.tasks,
+		m.taskDuration,
+		m.pluginErrors,
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "ws_send_dropped_total",
+			Help:      "Messages dropped because the WebSocket send buffer was full.",
+		}, func() float64 { return float64(common.DroppedMessages()) }),
+	)
+	return m
+}
+
+// Handler 返回 /metrics 的 HTTP 处理器
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// SetConcurrencyLimit 记录并发限制，taskType 为空表示全局限制
+func (m *Metrics) SetConcurrencyLimit(taskType common.TaskType, limit int) {
+	if m == nil {
+		return
+	}
+	m.concurrencyLimit.WithLabelValues(string(taskType)).Set(float64(limit))
+}
+
+// SemaphoreWait 记录等待并发信号量的时间
+func (m *Metrics) SemaphoreWait(taskType common.TaskType, scope string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.semaphoreWait.WithLabelValues(string(taskType), scope).Observe(d.Seconds())
+}
+
+// TaskStarted 记录任务开始执行
+func (m *Metrics) TaskStarted(taskType common.TaskType) {
+	if m == nil {
+		return
+	}
+	m.running.WithLabelValues(string(taskType)).Inc()
+}
+
+// TaskFinished 记录任务执行结束，err 不为 nil 时计入插件错误
+func (m *Metrics) TaskFinished(taskType common.TaskType, d time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.running.WithLabelValues(string(taskType)).Dec()
+	m.taskDuration.WithLabelValues(string(taskType)).Observe(d.Seconds())
+	status := common.TaskStatusSuccess
+	if err != nil {
+		status = common.TaskStatusFailed
+		m.pluginErrors.WithLabelValues(string(taskType), ReasonExecute).Inc()
+	}
+	m.tasks.WithLabelValues(string(taskType), string(status)).Inc()
+}
+
+// PluginNotFound 记录没有对应执行器的任务
+func (m *Metrics) PluginNotFound(taskType common.TaskType) {
+	if m == nil {
+		return
+	}
+	m.pluginErrors.WithLabelValues(string(taskType), ReasonNotFound).Inc()
+	m.tasks.WithLabelValues(string(taskType), string(common.TaskStatusFailed)).Inc()
+}
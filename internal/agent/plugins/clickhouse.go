@@ -10,6 +10,7 @@ import (
 	"github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
 	"github.com/cloud-agent/internal/common"
+	"github.com/cloud-agent/internal/tracing"
 )
 
 // ClickHouseExecutor ClickHouse 执行器
@@ -210,14 +211,20 @@ func (e *ClickHouseExecutor) GetDatabaseType() string {
 
 // Execute 执行 ClickHouse SQL
 func (e *ClickHouseExecutor) Execute(taskID string, command string, params map[string]interface{}, fileID string, logCallback LogCallback) (string, error) {
+	return e.ExecuteContext(context.Background(), taskID, command, params, fileID, logCallback)
+}
+
+// ExecuteContext 执行 ClickHouse SQL，数据库操作记录在 ctx 的链路中
+func (e *ClickHouseExecutor) ExecuteContext(ctx context.Context, taskID string, command string, params map[string]interface{}, fileID string, logCallback LogCallback) (string, error) {
 	startTime := time.Now()
 
 	// 如果提供了 fileID，优先从文件读取 SQL
 	if fileID != "" {
 		filePath, _ := params["file_path"].(string)
 		fileName, _ := params["file_name"].(string)
+		fileSHA256, _ := params["file_sha256"].(string)
 
-		fileSQL, err := ReadSQLFromFile(fileID, filePath, fileName, logCallback, taskID)
+		fileSQL, err := ReadSQLFromFile(fileID, filePath, fileName, fileSHA256, logCallback, taskID)
 		if err != nil {
 			return "", fmt.Errorf("failed to read SQL from file: %w", err)
 		}
@@ -264,7 +271,6 @@ func (e *ClickHouseExecutor) Execute(taskID string, command string, params map[s
 	}
 
 	// 创建上下文（支持超时）
-	ctx := context.Background()
 	if execOpts.TimeoutMs > 0 {
 		var cancel context.CancelFunc
 		ctx, cancel = context.WithTimeout(ctx, time.Duration(execOpts.TimeoutMs)*time.Millisecond)
@@ -272,7 +278,9 @@ func (e *ClickHouseExecutor) Execute(taskID string, command string, params map[s
 	}
 
 	// 执行 SQL
-	result, err := e.executeSQL(ctx, conn, command, execOpts, logCallback, taskID)
+	dbCtx, span := startDBSpan(ctx, "clickhouse", sqlOperation(command))
+	result, err := e.executeSQL(dbCtx, conn, command, execOpts, logCallback, taskID)
+	tracing.End(span, err)
 	if err != nil {
 		return "", err
 	}
@@ -321,7 +329,7 @@ func (e *ClickHouseExecutor) executeSQL(ctx context.Context, conn driver.Conn, c
 			if logCallback != nil {
 				logCallback(taskID, "error", errorMsg)
 			}
-			return nil, fmt.Errorf("security validation failed for statement %d: %w", i+1, err)
+			return nil, fmt.Errorf("%w for statement %d: %w", ErrSecurityRejected, i+1, err)
 		}
 	}
 
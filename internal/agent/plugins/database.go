@@ -1,9 +1,14 @@
 package plugins
 
 import (
+	"context"
 	"fmt"
+	"strings"
 
 	"github.com/cloud-agent/internal/common"
+	"github.com/cloud-agent/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // DatabaseExecutor 数据库执行器接口
@@ -63,3 +68,28 @@ func (e *RedisExecutor) Execute(taskID string, command string, params map[string
 func (e *RedisExecutor) Cancel(taskID string) error {
 	return nil
 }
+
+// startDBSpan 为数据库操作创建客户端 span
+// 只记录数据库类型和操作类型，不记录语句内容和连接信息，避免敏感数据随链路导出
+func startDBSpan(ctx context.Context, system, operation string) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{attribute.String("db.system.name", system)}
+	name := system
+	if operation != "" {
+		attrs = append(attrs, attribute.String("db.operation.name", operation))
+		name = operation + " " + system
+	}
+	return tracing.StartKind(ctx, name, trace.SpanKindClient, attrs...)
+}
+
+// sqlOperation 返回 SQL 第一条语句的操作类型（如 SELECT、UPDATE），多条语句时返回 BATCH
+func sqlOperation(sql string) string {
+	sql = strings.TrimSpace(sql)
+	if strings.Contains(strings.TrimSuffix(sql, ";"), ";") {
+		return "BATCH"
+	}
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.ToUpper(fields[0])
+}
@@ -1,6 +1,8 @@
 package plugins
 
 import (
+	"context"
+
 	"github.com/cloud-agent/internal/common"
 )
 
@@ -38,6 +40,11 @@ func (e *DorisExecutor) GetDatabaseType() string {
 
 // Execute 执行 SQL（复用 MySQLExecutor，但可以添加 Doris 特定逻辑）
 func (e *DorisExecutor) Execute(taskID string, command string, params map[string]interface{}, fileID string, logCallback LogCallback) (string, error) {
+	return e.ExecuteContext(context.Background(), taskID, command, params, fileID, logCallback)
+}
+
+// ExecuteContext 执行 SQL，覆盖 MySQLExecutor.ExecuteContext 以保留 Doris 的默认超时
+func (e *DorisExecutor) ExecuteContext(ctx context.Context, taskID string, command string, params map[string]interface{}, fileID string, logCallback LogCallback) (string, error) {
 	// Doris 查询可能较慢，增加默认超时时间
 	if params == nil {
 		params = make(map[string]interface{})
@@ -55,5 +62,5 @@ func (e *DorisExecutor) Execute(taskID string, command string, params map[string
 	}
 
 	// 调用 MySQLExecutor 执行
-	return e.MySQLExecutor.Execute(taskID, command, params, fileID, logCallback)
+	return e.MySQLExecutor.ExecuteContext(ctx, taskID, command, params, fileID, logCallback)
 }
@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/cloud-agent/internal/common"
+	"github.com/cloud-agent/internal/tracing"
 	"github.com/elastic/go-elasticsearch/v8"
 	"github.com/elastic/go-elasticsearch/v8/esapi"
 )
@@ -196,6 +197,11 @@ func (e *ESExecutor) GetDatabaseType() string {
 
 // Execute 执行 Elasticsearch DSL
 func (e *ESExecutor) Execute(taskID string, command string, params map[string]interface{}, fileID string, logCallback LogCallback) (string, error) {
+	return e.ExecuteContext(context.Background(), taskID, command, params, fileID, logCallback)
+}
+
+// ExecuteContext 执行 Elasticsearch DSL，数据库操作记录在 ctx 的链路中
+func (e *ESExecutor) ExecuteContext(ctx context.Context, taskID string, command string, params map[string]interface{}, fileID string, logCallback LogCallback) (string, error) {
 	startTime := time.Now()
 
 	if command == "" {
@@ -236,7 +242,6 @@ func (e *ESExecutor) Execute(taskID string, command string, params map[string]in
 	}
 
 	// 创建上下文（支持超时）
-	ctx := context.Background()
 	if execOpts.TimeoutMs > 0 {
 		var cancel context.CancelFunc
 		ctx, cancel = context.WithTimeout(ctx, time.Duration(execOpts.TimeoutMs)*time.Millisecond)
@@ -244,7 +249,9 @@ func (e *ESExecutor) Execute(taskID string, command string, params map[string]in
 	}
 
 	// 执行 DSL
-	result, err := e.executeDSL(ctx, client, command, execOpts, logCallback, taskID)
+	dbCtx, span := startDBSpan(ctx, "elasticsearch", "")
+	result, err := e.executeDSL(dbCtx, client, command, execOpts, logCallback, taskID)
+	tracing.End(span, err)
 	if err != nil {
 		return "", err
 	}
@@ -288,7 +295,7 @@ func (e *ESExecutor) executeDSL(ctx context.Context, client *elasticsearch.Clien
 		if logCallback != nil {
 			logCallback(taskID, "error", fmt.Sprintf("Operation security validation failed: %v", err))
 		}
-		return nil, fmt.Errorf("security validation failed: %w", err)
+		return nil, fmt.Errorf("%w: %w", ErrSecurityRejected, err)
 	}
 
 	// 记录审计日志
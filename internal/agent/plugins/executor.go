@@ -1,9 +1,17 @@
 package plugins
 
 import (
+	"context"
+	"errors"
+
+	"github.com/cloud-agent/internal/agent/jobs"
+	"github.com/cloud-agent/internal/agent/security"
 	"github.com/cloud-agent/internal/common"
 )
 
+// ErrSecurityRejected 命令或语句未通过插件的安全校验，可用 errors.Is 判断
+var ErrSecurityRejected = errors.New("security validation failed")
+
 // LogCallback 日志回调函数
 type LogCallback func(taskID string, level string, message string)
 
@@ -13,3 +21,20 @@ type Executor interface {
 	Cancel(taskID string) error
 	Type() common.TaskType
 }
+
+// SecurityConfigurable 支持在运行中替换安全配置的执行器（可选）
+type SecurityConfigurable interface {
+	UpdateSecurityConfig(config *security.SecurityConfig) error
+}
+
+// ContextExecutor 支持上下文的执行器（可选）
+// ctx 携带任务的链路追踪上下文，并在任务取消时结束
+type ContextExecutor interface {
+	ExecuteContext(ctx context.Context, taskID string, command string, params map[string]interface{}, fileID string, logCallback LogCallback) (string, error)
+}
+
+// JobStarter 支持以后台任务方式运行命令的执行器（可选）
+// 命令由 jobs 管理器监护，日志、进度和结果由 jobs 管理器上报，Agent 重启后继续运行
+type JobStarter interface {
+	StartJob(taskID string, command string, params map[string]interface{}, fileID string, logCallback LogCallback, jobs *jobs.Manager) error
+}
@@ -86,13 +86,22 @@ func (e *FileExecutor) copyFile(ctx context.Context, fileID string, targetPath s
 		logCallback(taskID, "info", fmt.Sprintf("Copying file %s to %s", fileID, targetPath))
 	}
 
-	// 从 params 获取源文件路径
-	// 实际实现中，应该从 Cloud 下载文件
-	// 这里简化处理，假设文件已经在本地
+	// 从 params 获取源文件路径：
+	// 对象存储后端传递预签名下载链接，先下载到临时文件；本地存储后端传递共享存储卷中的路径
 	sourcePath, ok := params["file_path"].(string)
 	if !ok || sourcePath == "" {
 		return "", common.NewError("file_path is required for copy operation")
 	}
+	if IsRemoteFile(sourcePath) {
+		fileName, _ := params["file_name"].(string)
+		fileSHA256, _ := params["file_sha256"].(string)
+		localPath, cleanup, err := FetchRemoteFile(ctx, sourcePath, fileName, fileSHA256, logCallback, taskID)
+		if err != nil {
+			return "", err
+		}
+		defer cleanup()
+		sourcePath = localPath
+	}
 
 	// 获取文件名：优先使用传递的原始文件名，否则从路径提取
 	sourceFileName := ""
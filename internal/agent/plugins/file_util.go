@@ -2,22 +2,125 @@ package plugins
 
 import (
 	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
+// remoteFileTimeout 下载远程文件的超时时间
+const remoteFileTimeout = 10 * time.Minute
+
+// IsRemoteFile 判断文件路径是否为 http(s) 下载链接
+func IsRemoteFile(filePath string) bool {
+	return strings.HasPrefix(filePath, "http://") || strings.HasPrefix(filePath, "https://")
+}
+
+// FetchRemoteFile 下载远程文件到临时文件，返回本地路径和清理函数
+// 临时文件保留 fileName 的扩展名（用于识别 zip 等格式），expectedSHA256 不为空时校验文件内容
+func FetchRemoteFile(ctx context.Context, fileURL string, fileName string, expectedSHA256 string, logCallback LogCallback, taskID string) (string, func(), error) {
+	if fileName == "" {
+		if u, err := url.Parse(fileURL); err == nil {
+			fileName = path.Base(u.Path)
+		}
+	}
+	if logCallback != nil {
+		logCallback(taskID, "info", fmt.Sprintf("Downloading file %s", fileName))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, remoteFileTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid file url: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to download file: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("failed to download file: HTTP %d", resp.StatusCode)
+	}
+
+	tmp, err := os.CreateTemp("", "cloud-agent-*"+filepath.Ext(fileName))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	cleanup := func() { os.Remove(tmp.Name()) }
+
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(tmp, hash), resp.Body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("failed to download file: %w", err)
+	}
+
+	if expectedSHA256 != "" {
+		if actual := hex.EncodeToString(hash.Sum(nil)); !strings.EqualFold(actual, expectedSHA256) {
+			cleanup()
+			return "", nil, fmt.Errorf("file checksum mismatch: expected sha256 %s, got %s", expectedSHA256, actual)
+		}
+	}
+
+	if logCallback != nil {
+		logCallback(taskID, "info", fmt.Sprintf("Downloaded %d bytes", written))
+	}
+	return tmp.Name(), cleanup, nil
+}
+
 // ReadSQLFromFile 从文件读取 SQL 内容
 // 支持普通 SQL 文件和 zip 压缩包
 // 如果 fileID 为空，返回空字符串
 // 如果 filePath 为空，尝试从多个可能的位置查找文件
-func ReadSQLFromFile(fileID string, filePath string, fileName string, logCallback LogCallback, taskID string) (string, error) {
+// 如果 filePath 是 http(s) 下载链接（对象存储预签名 URL），先下载到临时文件，fileSHA256 不为空时校验内容
+func ReadSQLFromFile(fileID string, filePath string, fileName string, fileSHA256 string, logCallback LogCallback, taskID string) (string, error) {
 	if fileID == "" {
 		return "", nil
 	}
 
+	filePath, cleanup, err := ResolveTaskFile(fileID, filePath, fileName, fileSHA256, logCallback, taskID)
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+
+	// 检查文件扩展名
+	ext := strings.ToLower(filepath.Ext(filePath))
+	if ext == ".zip" {
+		// 处理 zip 文件
+		return readSQLFromZip(filePath, fileName, logCallback, taskID)
+	}
+
+	// 处理普通文件
+	return readSQLFromPlainFile(filePath, logCallback, taskID)
+}
+
+// ResolveTaskFile 返回任务文件的本地路径，调用方使用完后调用 cleanup
+// 如果 filePath 为空，尝试从多个可能的位置查找文件
+// 如果 filePath 是 http(s) 下载链接（对象存储预签名 URL），先下载到临时文件，fileSHA256 不为空时校验内容
+func ResolveTaskFile(fileID string, filePath string, fileName string, fileSHA256 string, logCallback LogCallback, taskID string) (string, func(), error) {
+	cleanup := func() {}
+	if IsRemoteFile(filePath) {
+		localPath, remove, err := FetchRemoteFile(context.Background(), filePath, fileName, fileSHA256, logCallback, taskID)
+		if err != nil {
+			return "", nil, err
+		}
+		filePath, cleanup = localPath, remove
+	}
+
 	// 如果没有提供 filePath，尝试从多个可能的位置查找文件
 	if filePath == "" {
 		// 尝试多个可能的路径
@@ -46,30 +149,24 @@ func ReadSQLFromFile(fileID string, filePath string, fileName string, logCallbac
 		}
 		
 		if filePath == "" {
-			return "", fmt.Errorf("file not found for fileID: %s (searched in: %v)", fileID, possiblePaths)
+			return "", nil, fmt.Errorf("file not found for fileID: %s (searched in: %v)", fileID, possiblePaths)
 		}
 	}
 
 	// 检查文件是否存在
 	fileInfo, err := os.Stat(filePath)
 	if err != nil {
-		return "", fmt.Errorf("file not found: %s: %w", filePath, err)
+		cleanup()
+		return "", nil, fmt.Errorf("file not found: %s: %w", filePath, err)
 	}
 
 	// 如果是目录，返回错误
 	if fileInfo.IsDir() {
-		return "", fmt.Errorf("path is a directory, not a file: %s", filePath)
-	}
-
-	// 检查文件扩展名
-	ext := strings.ToLower(filepath.Ext(filePath))
-	if ext == ".zip" {
-		// 处理 zip 文件
-		return readSQLFromZip(filePath, fileName, logCallback, taskID)
+		cleanup()
+		return "", nil, fmt.Errorf("path is a directory, not a file: %s", filePath)
 	}
 
-	// 处理普通文件
-	return readSQLFromPlainFile(filePath, logCallback, taskID)
+	return filePath, cleanup, nil
 }
 
 // readSQLFromPlainFile 从普通文件读取 SQL
@@ -0,0 +1,55 @@
+package plugins
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadSQLFromRemoteFile(t *testing.T) {
+	content := "SELECT 1;"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(content))
+	}))
+	defer srv.Close()
+
+	sum := sha256.Sum256([]byte(content))
+	sql, err := ReadSQLFromFile("file-1", srv.URL+"/file-1/init.sql?X-Amz-Signature=abc", "init.sql", hex.EncodeToString(sum[:]), nil, "task-1")
+	if err != nil {
+		t.Fatalf("ReadSQLFromFile failed: %v", err)
+	}
+	if sql != content {
+		t.Errorf("sql = %q, want %q", sql, content)
+	}
+
+	if _, err := ReadSQLFromFile("file-1", srv.URL+"/file-1/init.sql", "init.sql", "deadbeef", nil, "task-1"); err == nil {
+		t.Error("expected checksum mismatch error")
+	}
+}
+
+func TestFileExecutorCopyRemoteFile(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("payload"))
+	}))
+	defer srv.Close()
+
+	base := t.TempDir()
+	exec := NewFileExecutor(map[string]interface{}{"base_path": base})
+	_, err := exec.Execute("task-1", "", map[string]interface{}{
+		"operation": "distribute",
+		"file_path": srv.URL + "/presigned",
+		"file_name": "app.conf",
+	}, "file-1", nil)
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(base, "app.conf"))
+	if err != nil || string(data) != "payload" {
+		t.Errorf("copied file = %q, %v", data, err)
+	}
+}
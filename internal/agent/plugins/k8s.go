@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/cloud-agent/internal/common"
+	"github.com/cloud-agent/internal/tracing"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -111,6 +112,8 @@ func NewK8sExecutor(config map[string]interface{}) *K8sExecutor {
 			CAData: caBytes,
 		},
 		Timeout: exec.timeout,
+		// 为每个 API 请求创建 span，并向 API Server 传递链路上下文
+		WrapTransport: tracing.Transport,
 	}
 
 	// 创建 clientset
@@ -179,13 +182,18 @@ func (e *K8sExecutor) Type() common.TaskType {
 
 // Execute 执行 K8s 操作
 func (e *K8sExecutor) Execute(taskID string, command string, params map[string]interface{}, fileID string, logCallback LogCallback) (string, error) {
+	return e.ExecuteContext(context.Background(), taskID, command, params, fileID, logCallback)
+}
+
+// ExecuteContext 执行 K8s 操作，API 请求携带 ctx 中的链路上下文
+func (e *K8sExecutor) ExecuteContext(ctx context.Context, taskID string, command string, params map[string]interface{}, fileID string, logCallback LogCallback) (string, error) {
 	// 获取操作类型，默认为 apply（create 或 update）
 	operation := "apply"
 	if op, ok := params["operation"].(string); ok && op != "" {
 		operation = strings.ToLower(op)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
 	defer cancel()
 
 	// logs 操作特殊处理：不需要 YAML/JSON 内容
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/cloud-agent/internal/common"
+	"github.com/cloud-agent/internal/tracing"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -202,6 +203,11 @@ func (e *MongoExecutor) GetDatabaseType() string {
 
 // Execute 执行 MongoDB 操作
 func (e *MongoExecutor) Execute(taskID string, command string, params map[string]interface{}, fileID string, logCallback LogCallback) (string, error) {
+	return e.ExecuteContext(context.Background(), taskID, command, params, fileID, logCallback)
+}
+
+// ExecuteContext 执行 MongoDB 操作，数据库操作记录在 ctx 的链路中
+func (e *MongoExecutor) ExecuteContext(ctx context.Context, taskID string, command string, params map[string]interface{}, fileID string, logCallback LogCallback) (string, error) {
 	startTime := time.Now()
 
 	if command == "" {
@@ -261,7 +267,6 @@ func (e *MongoExecutor) Execute(taskID string, command string, params map[string
 	}
 
 	// 创建上下文（支持超时）
-	ctx := context.Background()
 	if execOpts.TimeoutMs > 0 {
 		var cancel context.CancelFunc
 		ctx, cancel = context.WithTimeout(ctx, time.Duration(execOpts.TimeoutMs)*time.Millisecond)
@@ -269,7 +274,9 @@ func (e *MongoExecutor) Execute(taskID string, command string, params map[string
 	}
 
 	// 执行操作
-	result, err := e.executeOperations(ctx, db, command, execOpts, logCallback, taskID)
+	dbCtx, span := startDBSpan(ctx, "mongodb", "")
+	result, err := e.executeOperations(dbCtx, db, command, execOpts, logCallback, taskID)
+	tracing.End(span, err)
 	if err != nil {
 		return "", err
 	}
@@ -329,7 +336,7 @@ func (e *MongoExecutor) executeJSONOperations(ctx context.Context, db *mongo.Dat
 			if logCallback != nil {
 				logCallback(taskID, "error", errorMsg)
 			}
-			return nil, fmt.Errorf("security validation failed for operation %d: %w", i+1, err)
+			return nil, fmt.Errorf("%w for operation %d: %w", ErrSecurityRejected, i+1, err)
 		}
 	}
 
@@ -2,6 +2,7 @@ package plugins
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,6 +11,7 @@ import (
 	"time"
 
 	"github.com/cloud-agent/internal/common"
+	"github.com/cloud-agent/internal/tracing"
 )
 
 // goInceptionRequest goInception 请求结构
@@ -65,7 +67,8 @@ func NewMySQLExecutor(config map[string]interface{}) *MySQLExecutor {
 		connections:    make(map[string]connectionInfo),
 		config:         config,
 		httpClient: &http.Client{
-			Timeout: 30 * time.Minute,
+			Timeout:   30 * time.Minute,
+			Transport: tracing.Transport(nil),
 		},
 		// 默认不允许危险操作，启用严格模式
 		// 注意：goInception 本身也会进行 SQL 审核，这里是双重保护
@@ -120,14 +123,20 @@ func (e *MySQLExecutor) GetDatabaseType() string {
 
 // Execute 执行 SQL（通过 goInception）
 func (e *MySQLExecutor) Execute(taskID string, command string, params map[string]interface{}, fileID string, logCallback LogCallback) (string, error) {
+	return e.ExecuteContext(context.Background(), taskID, command, params, fileID, logCallback)
+}
+
+// ExecuteContext 执行 SQL（通过 goInception），goInception 请求携带 ctx 中的链路上下文
+func (e *MySQLExecutor) ExecuteContext(ctx context.Context, taskID string, command string, params map[string]interface{}, fileID string, logCallback LogCallback) (string, error) {
 	startTime := time.Now()
 
 	// 如果提供了 fileID，优先从文件读取 SQL
 	if fileID != "" {
 		filePath, _ := params["file_path"].(string)
 		fileName, _ := params["file_name"].(string)
+		fileSHA256, _ := params["file_sha256"].(string)
 
-		fileSQL, err := ReadSQLFromFile(fileID, filePath, fileName, logCallback, taskID)
+		fileSQL, err := ReadSQLFromFile(fileID, filePath, fileName, fileSHA256, logCallback, taskID)
 		if err != nil {
 			return "", fmt.Errorf("failed to read SQL from file: %w", err)
 		}
@@ -145,7 +154,7 @@ func (e *MySQLExecutor) Execute(taskID string, command string, params map[string
 		if logCallback != nil {
 			logCallback(taskID, "error", fmt.Sprintf("SQL security validation failed: %v", err))
 		}
-		return "", fmt.Errorf("security validation failed: %w", err)
+		return "", fmt.Errorf("%w: %w", ErrSecurityRejected, err)
 	}
 
 	// 记录审计日志
@@ -193,7 +202,7 @@ func (e *MySQLExecutor) Execute(taskID string, command string, params map[string
 	}
 
 	// 调用 goInception
-	result, err := e.callGoInception(req, logCallback, taskID, taskID, startTime, execOpts)
+	result, err := e.callGoInception(ctx, req, logCallback, taskID, taskID, startTime, execOpts)
 	if err != nil {
 		return "", err
 	}
@@ -296,7 +305,7 @@ func (e *MySQLExecutor) extractMetadata(params map[string]interface{}) map[strin
 }
 
 // callGoInception 调用 goInception API
-func (e *MySQLExecutor) callGoInception(req goInceptionRequest, logCallback LogCallback, taskID string, runID string, startTime time.Time, execOpts execOptions) (string, error) {
+func (e *MySQLExecutor) callGoInception(ctx context.Context, req goInceptionRequest, logCallback LogCallback, taskID string, runID string, startTime time.Time, execOpts execOptions) (string, error) {
 	url := strings.TrimSuffix(e.goInceptionURL, "/") + "/check"
 
 	// 序列化请求
@@ -310,7 +319,7 @@ func (e *MySQLExecutor) callGoInception(req goInceptionRequest, logCallback LogC
 	}
 
 	// 创建 HTTP 请求
-	httpReq, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return "", fmt.Errorf("failed to create request: %w", err)
 	}
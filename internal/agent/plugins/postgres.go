@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/cloud-agent/internal/common"
+	"github.com/cloud-agent/internal/tracing"
 	_ "github.com/jackc/pgx/v5/stdlib"
 )
 
@@ -202,14 +203,20 @@ func (e *PostgresExecutor) GetDatabaseType() string {
 
 // Execute 执行 PostgreSQL 脚本
 func (e *PostgresExecutor) Execute(taskID string, command string, params map[string]interface{}, fileID string, logCallback LogCallback) (string, error) {
+	return e.ExecuteContext(context.Background(), taskID, command, params, fileID, logCallback)
+}
+
+// ExecuteContext 执行 PostgreSQL 脚本，数据库操作记录在 ctx 的链路中
+func (e *PostgresExecutor) ExecuteContext(ctx context.Context, taskID string, command string, params map[string]interface{}, fileID string, logCallback LogCallback) (string, error) {
 	startTime := time.Now()
 
 	// 如果提供了 fileID，优先从文件读取 SQL
 	if fileID != "" {
 		filePath, _ := params["file_path"].(string)
 		fileName, _ := params["file_name"].(string)
+		fileSHA256, _ := params["file_sha256"].(string)
 
-		fileSQL, err := ReadSQLFromFile(fileID, filePath, fileName, logCallback, taskID)
+		fileSQL, err := ReadSQLFromFile(fileID, filePath, fileName, fileSHA256, logCallback, taskID)
 		if err != nil {
 			return "", fmt.Errorf("failed to read SQL from file: %w", err)
 		}
@@ -257,7 +264,6 @@ func (e *PostgresExecutor) Execute(taskID string, command string, params map[str
 	}
 
 	// 创建上下文（支持超时）
-	ctx := context.Background()
 	if execOpts.TimeoutMs > 0 {
 		var cancel context.CancelFunc
 		ctx, cancel = context.WithTimeout(ctx, time.Duration(execOpts.TimeoutMs)*time.Millisecond)
@@ -265,7 +271,9 @@ func (e *PostgresExecutor) Execute(taskID string, command string, params map[str
 	}
 
 	// 执行 SQL
-	result, err := e.executeSQL(ctx, db, command, execOpts, logCallback, taskID)
+	dbCtx, span := startDBSpan(ctx, "postgresql", sqlOperation(command))
+	result, err := e.executeSQL(dbCtx, db, command, execOpts, logCallback, taskID)
+	tracing.End(span, err)
 	if err != nil {
 		return "", err
 	}
@@ -314,7 +322,7 @@ func (e *PostgresExecutor) executeSQL(ctx context.Context, db *sql.DB, command s
 			if logCallback != nil {
 				logCallback(taskID, "error", errorMsg)
 			}
-			return nil, fmt.Errorf("security validation failed for statement %d: %w", i+1, err)
+			return nil, fmt.Errorf("%w for statement %d: %w", ErrSecurityRejected, i+1, err)
 		}
 	}
 
@@ -3,11 +3,17 @@ package plugins
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/cloud-agent/internal/agent/sandbox"
 	"github.com/cloud-agent/internal/agent/security"
 	"github.com/cloud-agent/internal/common"
 )
@@ -17,6 +23,8 @@ type ShellExecutor struct {
 	timeout   time.Duration
 	validator *security.CommandValidator
 	audit     *security.AuditLogger
+	config    *security.SecurityConfig // 执行配置（shell_profiles）取自该配置
+	mu        sync.RWMutex
 }
 
 // NewShellExecutor 创建 Shell 执行器
@@ -27,11 +35,19 @@ func NewShellExecutor(agentID string, securityConfigPath string) (*ShellExecutor
 		return nil, fmt.Errorf("failed to load security config: %w", err)
 	}
 
+	return NewShellExecutorWithSecurityConfig(agentID, config)
+}
+
+// NewShellExecutorWithSecurityConfig 使用给定的安全配置创建 Shell 执行器（如 Cloud 下发的配置）
+func NewShellExecutorWithSecurityConfig(agentID string, config *security.SecurityConfig) (*ShellExecutor, error) {
 	// 创建命令验证器
 	validator, err := security.NewCommandValidator(config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create command validator: %w", err)
 	}
+	if err := security.ValidateShellConfig(config); err != nil {
+		return nil, err
+	}
 
 	// 创建审计日志记录器
 	audit := security.NewAuditLogger(agentID)
@@ -40,51 +56,181 @@ func NewShellExecutor(agentID string, securityConfigPath string) (*ShellExecutor
 		timeout:   30 * time.Minute, // 默认超时 30 分钟
 		validator: validator,
 		audit:     audit,
+		config:    config,
 	}, nil
 }
 
+// UpdateSecurityConfig 替换命令校验规则和执行配置，执行中的命令不受影响
+func (e *ShellExecutor) UpdateSecurityConfig(config *security.SecurityConfig) error {
+	if err := security.ValidateShellConfig(config); err != nil {
+		return err
+	}
+	if err := e.validator.Update(config); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	e.config = config
+	e.mu.Unlock()
+	return nil
+}
+
 // Type 返回执行器类型
 func (e *ShellExecutor) Type() common.TaskType {
 	return common.TaskTypeShell
 }
 
-// Execute 执行 Shell 命令
-func (e *ShellExecutor) Execute(taskID string, command string, params map[string]interface{}, fileID string, logCallback LogCallback) (string, error) {
-	if command == "" {
-		return "", common.NewError("command is empty")
+// shellCommand 通过校验的命令（或脚本）及其执行配置
+type shellCommand struct {
+	command      string      // 命令，脚本模式下为脚本的描述
+	script       *scriptTask // 脚本模式时不为 nil
+	resultFormat string
+	successCodes []int
+	profileName  string
+	profile      *security.ShellProfile
+	config       *security.SecurityConfig
+}
+
+// prepare 解析任务参数、选择执行配置并校验命令，Execute 和 StartJob 共用
+func (e *ShellExecutor) prepare(taskID string, command string, params map[string]interface{}, fileID string, logCallback LogCallback) (*shellCommand, error) {
+	c := &shellCommand{command: command}
+
+	// 脚本模式执行 params.script 或上传的脚本文件，否则执行 command
+	if operation, _ := params["operation"].(string); operation == scriptOperation {
+		script, err := parseScriptTask(taskID, params, fileID, logCallback)
+		if err != nil {
+			return nil, err
+		}
+		c.script = script
+		c.command = script.String()
+	} else if command == "" {
+		return nil, common.NewError("command is empty")
 	}
 
-	// 验证命令是否允许执行
-	startTime := time.Now()
-	if err := e.validator.ValidateCommand(command); err != nil {
+	// 结果格式和表示成功的退出码
+	c.resultFormat, _ = params["result_format"].(string)
+	if c.resultFormat != "" && c.resultFormat != "text" && c.resultFormat != shellResultJSON {
+		return nil, common.NewErrorf("unsupported result_format %q (text or json)", c.resultFormat)
+	}
+	var err error
+	if c.successCodes, err = parseSuccessExitCodes(params); err != nil {
+		return nil, err
+	}
+
+	// 选择执行配置，未定义的执行配置拒绝执行
+	profileName, _ := params["profile"].(string)
+	e.mu.RLock()
+	c.config = e.config
+	e.mu.RUnlock()
+	c.profileName, c.profile, err = c.config.ShellProfile(profileName)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSecurityRejected, err)
+	}
+
+	// 验证命令（脚本）是否允许执行
+	if c.script != nil {
+		err = c.script.check(c.config, e.validator)
+	} else {
+		err = e.validator.ValidateCommand(c.command)
+	}
+	if err != nil {
 		// 记录被阻止的命令
-		e.audit.LogCommandAttempt(taskID, string(common.TaskTypeShell), command, false, err.Error())
+		e.audit.LogCommandAttempt(taskID, string(common.TaskTypeShell), c.command, false, err.Error())
 		if logCallback != nil {
 			logCallback(taskID, "error", fmt.Sprintf("Command blocked by security policy: %v", err))
 		}
-		return "", fmt.Errorf("security validation failed: %w", err)
+		return nil, fmt.Errorf("%w: %w", ErrSecurityRejected, err)
 	}
 
 	// 记录允许的命令
-	e.audit.LogCommandAttempt(taskID, string(common.TaskTypeShell), command, true, "")
+	e.audit.LogCommandAttempt(taskID, string(common.TaskTypeShell), c.command, true, "")
 
 	if logCallback != nil {
-		logCallback(taskID, "info", "Executing command: "+command)
+		if c.script != nil {
+			logCallback(taskID, "info", "Executing script: "+c.command)
+		} else {
+			logCallback(taskID, "info", "Executing command: "+c.command)
+		}
+		if c.profile != nil {
+			logCallback(taskID, "info", "Using shell profile: "+c.profileName)
+		}
+	}
+	return c, nil
+}
+
+// argv 返回执行命令的参数；脚本模式下脚本写入临时目录，调用方在命令结束后删除返回的目录
+func (c *shellCommand) argv() ([]string, string, error) {
+	if c.script == nil {
+		if strings.HasPrefix(c.command, "/") || strings.Contains(c.command, " ") {
+			// 完整命令，直接执行
+			return []string{"sh", "-c", c.command}, "", nil
+		}
+		// 简单命令
+		return strings.Fields(c.command), "", nil
 	}
 
+	// 有工作目录时写入工作目录，chroot 后也能访问
+	base := os.TempDir()
+	if c.profile != nil && c.profile.WorkDir != "" {
+		base = c.profile.WorkDir
+	}
+	dir, scriptPath, err := c.script.write(base, c.profile)
+	if err != nil {
+		return nil, "", err
+	}
+	if c.profile != nil && c.profile.Chroot {
+		rel, err := filepath.Rel(c.profile.WorkDir, scriptPath)
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, "", fmt.Errorf("failed to locate script in chroot: %w", err)
+		}
+		scriptPath = "/" + filepath.ToSlash(rel)
+	}
+	return append([]string{c.script.Interpreter, scriptPath}, c.script.Args...), dir, nil
+}
+
+// Execute 执行 Shell 命令
+func (e *ShellExecutor) Execute(taskID string, command string, params map[string]interface{}, fileID string, logCallback LogCallback) (string, error) {
+	startTime := time.Now()
+	c, err := e.prepare(taskID, command, params, fileID, logCallback)
+	if err != nil {
+		return "", err
+	}
+	command, script, profileName, profile := c.command, c.script, c.profileName, c.profile
+
 	// 创建上下文，支持超时和取消
 	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
 	defer cancel()
 
-	// 根据操作系统选择 shell
-	var cmd *exec.Cmd
-	if strings.HasPrefix(command, "/") || strings.Contains(command, " ") {
-		// 完整命令，直接执行
-		cmd = exec.CommandContext(ctx, "sh", "-c", command)
-	} else {
-		// 简单命令
-		parts := strings.Fields(command)
-		cmd = exec.CommandContext(ctx, parts[0], parts[1:]...)
+	// 脚本写入临时目录，执行后删除
+	argv, scriptDir, err := c.argv()
+	if err != nil {
+		return "", err
+	}
+	if scriptDir != "" {
+		defer os.RemoveAll(scriptDir)
+	}
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	if profile != nil {
+		cleanup, err := sandbox.Apply(cmd, taskID, profile)
+		if err != nil {
+			return "", fmt.Errorf("failed to apply shell profile %q: %w", profileName, err)
+		}
+		defer cleanup()
+	}
+	if script != nil {
+		// 执行配置没有工作目录时在脚本所在目录执行
+		if cmd.Dir == "" {
+			cmd.Dir = scriptDir
+		}
+		if len(script.Env) > 0 {
+			if cmd.Env == nil {
+				cmd.Env = os.Environ()
+			}
+			cmd.Env = append(cmd.Env, script.Env...)
+		}
+		if script.Stdin != nil {
+			cmd.Stdin = strings.NewReader(*script.Stdin)
+		}
 	}
 
 	// 创建管道以实时读取输出
@@ -103,42 +249,61 @@ func (e *ShellExecutor) Execute(taskID string, command string, params map[string
 		return "", fmt.Errorf("failed to start command: %w", err)
 	}
 
-	// 实时读取输出
-	var output strings.Builder
-	go func() {
-		scanner := bufio.NewScanner(stdoutPipe)
-		for scanner.Scan() {
-			line := scanner.Text()
-			// 过滤空行，避免产生多余的日志
-			if strings.TrimSpace(line) == "" {
-				continue
-			}
-			output.WriteString(line + "\n")
-			if logCallback != nil {
-				logCallback(taskID, "info", line)
+	// 实时读取输出，stdout 和 stderr 分别保存并按输出顺序合并，超过输出上限（执行配置或默认 10 MiB）后丢弃
+	limit := profile.OutputLimit()
+	output := newShellOutput(limit)
+	var readers sync.WaitGroup
+	for _, stream := range []struct {
+		pipe  io.Reader
+		out   *cappedOutput
+		level string
+	}{{stdoutPipe, &output.stdout, "info"}, {stderrPipe, &output.stderr, "error"}} {
+		readers.Go(func() {
+			// 按行读取，超过 maxOutputLine 的行按该长度拆分，没有换行的输出也不会整体读入内存
+			reader := bufio.NewReaderSize(stream.pipe, maxOutputLine)
+			for {
+				chunk, readErr := reader.ReadSlice('\n')
+				if len(chunk) > 0 {
+					line := string(chunk)
+					stream.out.add(line)
+					kept, truncated := output.combined.add(line)
+					// 空行保留在结果中，但不产生日志
+					if text := strings.TrimRight(line, "\r\n"); logCallback != nil && kept && strings.TrimSpace(text) != "" {
+						logCallback(taskID, stream.level, text)
+					}
+					if logCallback != nil && truncated {
+						logCallback(taskID, "warn", fmt.Sprintf("Output exceeds %d bytes, the rest is discarded", limit))
+					}
+				}
+				if readErr != nil && !errors.Is(readErr, bufio.ErrBufferFull) {
+					return
+				}
+				// 两份输出都达到上限后不再保留任何内容，丢弃剩余输出直到命令结束
+				if stream.out.isTruncated() && output.combined.isTruncated() {
+					io.Copy(io.Discard, reader)
+					return
+				}
 			}
-		}
-	}()
-
-	go func() {
-		scanner := bufio.NewScanner(stderrPipe)
-		for scanner.Scan() {
-			line := scanner.Text()
-			// 过滤空行，避免产生多余的日志
-			if strings.TrimSpace(line) == "" {
-				continue
-			}
-			output.WriteString(line + "\n")
-			if logCallback != nil {
-				logCallback(taskID, "error", line)
-			}
-		}
-	}()
+		})
+	}
 
-	// 等待命令完成
-	err = cmd.Wait()
-	result := output.String()
+	// 读取完所有输出后等待命令完成
+	readers.Wait()
+	waitErr := cmd.Wait()
 	duration := time.Since(startTime)
+	shellResult, err := newShellResult(cmd, waitErr, output, c.successCodes, duration)
+	if err == nil && !shellResult.Success {
+		// 退出码不在 success_exit_codes 中，错误信息与 cmd.Wait 一致（exit status N / signal: killed）
+		err = waitErr
+		if err == nil {
+			err = errors.New(cmd.ProcessState.String())
+		}
+	}
+	shellResult.TimedOut = !shellResult.Success && ctx.Err() == context.DeadlineExceeded
+	result := output.combined.String()
+	if c.resultFormat == shellResultJSON {
+		result = shellResult.toJSON()
+	}
 
 	// 记录命令执行结果
 	resultStatus := "success"
@@ -147,10 +312,17 @@ func (e *ShellExecutor) Execute(taskID string, command string, params map[string
 	}
 	e.audit.LogCommandResult(taskID, string(common.TaskTypeShell), command, resultStatus, err, duration)
 
+	if logCallback != nil && cmd.ProcessState != nil {
+		logCallback(taskID, "info", shellResult.describe())
+	}
+
 	if err != nil {
 		if logCallback != nil {
 			logCallback(taskID, "error", "Command failed: "+err.Error())
 		}
+		if shellResult.TimedOut {
+			return result, fmt.Errorf("command timed out after %s: %w", e.timeout, err)
+		}
 		return result, fmt.Errorf("command failed: %w", err)
 	}
 
@@ -166,3 +338,53 @@ func (e *ShellExecutor) Cancel(taskID string) error {
 	// Shell 执行器的取消由 Manager 通过 context 处理
 	return nil
 }
+
+// maxOutputLine 单行输出的上限，超过时按该长度拆分
+const maxOutputLine = 64 << 10
+
+// cappedOutput 并发写入的命令输出，limit 大于 0 时只保留前 limit 字节
+type cappedOutput struct {
+	mu        sync.Mutex
+	b         strings.Builder
+	limit     int64
+	truncated bool
+}
+
+// add 追加一行输出，返回该行是否保留以及是否因为本行首次超出上限
+func (o *cappedOutput) add(line string) (bool, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.truncated {
+		return false, false
+	}
+	if o.limit > 0 && int64(o.b.Len()+len(line)) > o.limit {
+		o.truncated = true
+		return false, true
+	}
+	o.b.WriteString(line)
+	return true, false
+}
+
+// text 返回保留的输出
+func (o *cappedOutput) text() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.b.String()
+}
+
+// isTruncated 是否有输出因超出上限被丢弃
+func (o *cappedOutput) isTruncated() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.truncated
+}
+
+// String 返回保留的输出，超出上限时末尾带有截断说明
+func (o *cappedOutput) String() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.truncated {
+		return o.b.String() + fmt.Sprintf("[output truncated at %d bytes]\n", o.limit)
+	}
+	return o.b.String()
+}
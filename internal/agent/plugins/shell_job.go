@@ -0,0 +1,80 @@
+package plugins
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/cloud-agent/internal/agent/jobs"
+	"github.com/cloud-agent/internal/common"
+)
+
+// StartJob 以后台任务方式启动命令（params.detach 为 true），需要安全配置启用 jobs
+// 运行时长上限为 params.job_timeout（秒）和 jobs.max_duration 中较小的一个
+func (e *ShellExecutor) StartJob(taskID string, command string, params map[string]interface{}, fileID string, logCallback LogCallback, mgr *jobs.Manager) error {
+	if mgr == nil {
+		return common.NewError("detached jobs are not available on this agent")
+	}
+	e.mu.RLock()
+	jobsConfig := e.config.Jobs
+	e.mu.RUnlock()
+	if !jobsConfig.Enabled {
+		return fmt.Errorf("%w: detached jobs are disabled (jobs.enabled)", ErrSecurityRejected)
+	}
+	if format, _ := params["result_format"].(string); format == shellResultJSON {
+		return common.NewError("result_format json is not supported for detached jobs")
+	}
+	timeout := jobsConfig.MaxDuration
+	if value, ok := params["job_timeout"]; ok {
+		seconds, ok := value.(float64)
+		if !ok || seconds <= 0 {
+			return common.NewError("job_timeout must be a positive number of seconds")
+		}
+		if d := time.Duration(seconds * float64(time.Second)); timeout == 0 || d < timeout {
+			timeout = d
+		}
+	}
+	if jobsConfig.MaxRunning > 0 && mgr.Running() >= jobsConfig.MaxRunning {
+		return common.NewErrorf("too many detached jobs running (jobs.max_running %d)", jobsConfig.MaxRunning)
+	}
+
+	c, err := e.prepare(taskID, command, params, fileID, logCallback)
+	if err != nil {
+		return err
+	}
+	// 脚本在命令结束后由监护进程删除
+	argv, scriptDir, err := c.argv()
+	if err != nil {
+		return err
+	}
+	spec := &jobs.Spec{
+		TaskID:           taskID,
+		Command:          c.command,
+		Argv:             argv,
+		ProfileName:      c.profileName,
+		Profile:          c.profile,
+		Timeout:          timeout,
+		SuccessExitCodes: c.successCodes,
+	}
+	if c.script != nil {
+		spec.Dir = scriptDir
+		spec.Env = c.script.Env
+		spec.Stdin = c.script.Stdin
+		spec.Cleanup = []string{scriptDir}
+	}
+	if err := mgr.Start(spec); err != nil {
+		if scriptDir != "" {
+			os.RemoveAll(scriptDir)
+		}
+		return err
+	}
+
+	if logCallback != nil {
+		message := "Started detached job"
+		if timeout > 0 {
+			message += fmt.Sprintf(" (max duration %s)", timeout)
+		}
+		logCallback(taskID, "info", message)
+	}
+	return nil
+}
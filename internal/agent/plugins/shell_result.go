@@ -0,0 +1,99 @@
+package plugins
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/cloud-agent/internal/common"
+)
+
+// shellResultJSON params.result_format 为 json 时返回结构化结果
+const shellResultJSON = "json"
+
+// ShellResult Shell 命令的结构化结果（params.result_format 为 json 时作为任务结果返回）
+type ShellResult struct {
+	ExitCode        int    `json:"exit_code"`        // 退出码，被信号结束时为 -1
+	Signal          string `json:"signal,omitempty"` // 结束命令的信号，如 killed
+	TimedOut        bool   `json:"timed_out,omitempty"`
+	Success         bool   `json:"success"` // 退出码是否在 success_exit_codes 中
+	Stdout          string `json:"stdout"`
+	Stderr          string `json:"stderr"`
+	StdoutTruncated bool   `json:"stdout_truncated,omitempty"` // 超过 max_output_bytes，其余输出已丢弃
+	StderrTruncated bool   `json:"stderr_truncated,omitempty"`
+	DurationMs      int64  `json:"duration_ms"`
+}
+
+// shellOutput 命令的输出，combined 按输出顺序合并 stdout 和 stderr，作为文本格式的结果
+type shellOutput struct {
+	combined, stdout, stderr cappedOutput
+}
+
+// newShellOutput 创建输出，limit 大于 0 时合并输出和每个流各自最多保留 limit 字节
+func newShellOutput(limit int64) *shellOutput {
+	o := &shellOutput{}
+	o.combined.limit, o.stdout.limit, o.stderr.limit = limit, limit, limit
+	return o
+}
+
+// parseSuccessExitCodes 解析 params.success_exit_codes，未设置时只有 0 表示成功
+func parseSuccessExitCodes(params map[string]interface{}) ([]int, error) {
+	raw, ok := params["success_exit_codes"]
+	if !ok || raw == nil {
+		return []int{0}, nil
+	}
+	values, ok := raw.([]interface{})
+	if !ok || len(values) == 0 {
+		return nil, common.NewError("success_exit_codes must be a non-empty array of exit codes")
+	}
+	codes := make([]int, 0, len(values))
+	for _, v := range values {
+		n, ok := v.(float64)
+		if !ok || n != float64(int(n)) || n < 0 || n > 255 {
+			return nil, common.NewErrorf("invalid exit code in success_exit_codes: %v", v)
+		}
+		codes = append(codes, int(n))
+	}
+	return codes, nil
+}
+
+// newShellResult 根据命令结束状态构造结构化结果，waitErr 为 cmd.Wait 的返回值
+// 命令被信号结束时不认为成功；返回的 error 为非 nil 时命令没有正常运行（如无法读取输出）
+func newShellResult(cmd *exec.Cmd, waitErr error, output *shellOutput, successCodes []int, duration time.Duration) (*ShellResult, error) {
+	result := &ShellResult{
+		Stdout:          output.stdout.text(),
+		Stderr:          output.stderr.text(),
+		StdoutTruncated: output.stdout.isTruncated(),
+		StderrTruncated: output.stderr.isTruncated(),
+		DurationMs:      duration.Milliseconds(),
+	}
+	var exitErr *exec.ExitError
+	if waitErr != nil && !errors.As(waitErr, &exitErr) {
+		return result, waitErr
+	}
+	state := cmd.ProcessState
+	result.ExitCode = state.ExitCode()
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		result.Signal = status.Signal().String()
+	}
+	result.Success = result.Signal == "" && slices.Contains(successCodes, result.ExitCode)
+	return result, nil
+}
+
+// describe 结果的简要说明，用于日志
+func (r *ShellResult) describe() string {
+	if r.Signal != "" {
+		return fmt.Sprintf("Terminated by signal: %s", r.Signal)
+	}
+	return fmt.Sprintf("Exit code: %d", r.ExitCode)
+}
+
+// toJSON 转换为 JSON 字符串
+func (r *ShellResult) toJSON() string {
+	data, _ := json.Marshal(r)
+	return string(data)
+}
@@ -0,0 +1,197 @@
+package plugins
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/cloud-agent/internal/agent/security"
+	"github.com/cloud-agent/internal/common"
+)
+
+// scriptOperation params.operation 为 script 时执行脚本
+const scriptOperation = "script"
+
+// maxScriptSize 脚本文件的大小上限
+const maxScriptSize = 10 << 20
+
+// shellInterpreters 脚本内容按 shell 语法校验的解释器
+var shellInterpreters = []string{"sh", "bash"}
+
+// scriptExtensions 没有文件名时按解释器使用的扩展名
+var scriptExtensions = map[string]string{"sh": ".sh", "bash": ".sh", "python3": ".py", "perl": ".pl"}
+
+// unsafeScriptEnv 启用白名单时脚本不能设置的环境变量，这些变量会改变解释器或动态链接器的行为
+var unsafeScriptEnv = []string{
+	"LD_*", "BASH_ENV", "ENV", "BASH_FUNC_*", "SHELLOPTS", "BASHOPTS", "PS4", "PROMPT_COMMAND", "IFS", "PATH",
+	"PYTHON*", "PERL5*", "PERLLIB",
+}
+
+// scriptTask 脚本模式的参数
+type scriptTask struct {
+	Interpreter string
+	Name        string // 写入临时目录时的文件名
+	Content     []byte
+	Args        []string
+	Env         []string
+	Stdin       *string
+}
+
+// parseScriptTask 解析脚本模式的参数，脚本内容取自 params.script 或上传的文件（file_id）
+func parseScriptTask(taskID string, params map[string]interface{}, fileID string, logCallback LogCallback) (*scriptTask, error) {
+	script := &scriptTask{Interpreter: "sh"}
+	if interpreter, ok := params["interpreter"].(string); ok && interpreter != "" {
+		script.Interpreter = interpreter
+	}
+
+	args, ok := params["args"].([]interface{})
+	if !ok && params["args"] != nil {
+		return nil, common.NewError("args must be an array of strings")
+	}
+	for _, arg := range args {
+		s, ok := arg.(string)
+		if !ok {
+			return nil, common.NewErrorf("args must be an array of strings, got %v", arg)
+		}
+		script.Args = append(script.Args, s)
+	}
+
+	env, ok := params["env"].(map[string]interface{})
+	if !ok && params["env"] != nil {
+		return nil, common.NewError("env must be an object of strings")
+	}
+	for key, value := range env {
+		s, ok := value.(string)
+		if !ok || key == "" || strings.ContainsAny(key, "=\x00") {
+			return nil, common.NewErrorf("invalid env %s=%v", key, value)
+		}
+		script.Env = append(script.Env, key+"="+s)
+	}
+	slices.Sort(script.Env)
+
+	if stdin, ok := params["stdin"].(string); ok {
+		script.Stdin = &stdin
+	}
+
+	inline, _ := params["script"].(string)
+	switch {
+	case inline != "" && fileID != "":
+		return nil, common.NewError("provide either params.script or file_id, not both")
+	case inline != "":
+		script.Content = []byte(inline)
+	case fileID != "":
+		content, name, err := readScriptFile(taskID, params, fileID, logCallback)
+		if err != nil {
+			return nil, err
+		}
+		script.Content, script.Name = content, name
+	default:
+		return nil, common.NewError("script is empty (provide params.script or file_id)")
+	}
+	if script.Name == "" || script.Name == "." || script.Name == "/" {
+		script.Name = "script" + scriptExtensions[script.Interpreter]
+	}
+	return script, nil
+}
+
+// readScriptFile 读取上传的脚本文件，返回内容和文件名
+func readScriptFile(taskID string, params map[string]interface{}, fileID string, logCallback LogCallback) ([]byte, string, error) {
+	filePath, _ := params["file_path"].(string)
+	fileName, _ := params["file_name"].(string)
+	fileSHA256, _ := params["file_sha256"].(string)
+
+	localPath, cleanup, err := ResolveTaskFile(fileID, filePath, fileName, fileSHA256, logCallback, taskID)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read script file: %w", err)
+	}
+	defer cleanup()
+	if strings.EqualFold(filepath.Ext(localPath), ".zip") {
+		return nil, "", common.NewError("zip archives are not supported for scripts")
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read script file: %w", err)
+	}
+	defer f.Close()
+	content, err := io.ReadAll(io.LimitReader(f, maxScriptSize+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read script file: %w", err)
+	}
+	if len(content) > maxScriptSize {
+		return nil, "", common.NewErrorf("script file exceeds %d bytes", maxScriptSize)
+	}
+	if logCallback != nil {
+		logCallback(taskID, "info", fmt.Sprintf("Read %d bytes from script file %s", len(content), fileName))
+	}
+	return content, path.Base(filepath.ToSlash(fileName)), nil
+}
+
+// check 按安全配置检查解释器、环境变量和 shell 脚本的内容
+func (s *scriptTask) check(config *security.SecurityConfig, validator *security.CommandValidator) error {
+	if err := config.AllowScriptInterpreter(s.Interpreter); err != nil {
+		return err
+	}
+	if validator.IsEnabled() {
+		for _, kv := range s.Env {
+			key, _, _ := strings.Cut(kv, "=")
+			if slices.ContainsFunc(unsafeScriptEnv, func(pattern string) bool {
+				ok, _ := path.Match(pattern, key)
+				return ok
+			}) {
+				return fmt.Errorf("environment variable %s is not allowed for scripts", key)
+			}
+		}
+	}
+	// shell 脚本与命令模式一样逐条校验，其他解释器只能通过 script_interpreters 控制
+	if slices.Contains(shellInterpreters, s.Interpreter) {
+		return validator.ValidateCommand(string(s.Content))
+	}
+	return nil
+}
+
+// String 脚本的说明，用于日志和审计
+func (s *scriptTask) String() string {
+	sum := sha256.Sum256(s.Content)
+	desc := fmt.Sprintf("%s %s", s.Interpreter, s.Name)
+	if len(s.Args) > 0 {
+		desc += " " + strings.Join(s.Args, " ")
+	}
+	return fmt.Sprintf("%s (script sha256 %s, %d bytes)", desc, hex.EncodeToString(sum[:8]), len(s.Content))
+}
+
+// write 把脚本写入 base 下新建的临时目录，返回目录和脚本路径
+// 执行配置切换用户时目录和脚本属于该用户，其他用户无法读取
+func (s *scriptTask) write(base string, profile *security.ShellProfile) (string, string, error) {
+	dir, err := os.MkdirTemp(base, ".cloud-agent-script-*")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create script dir: %w", err)
+	}
+	scriptPath := filepath.Join(dir, s.Name)
+	if err := os.WriteFile(scriptPath, s.Content, 0o600); err != nil {
+		os.RemoveAll(dir)
+		return "", "", fmt.Errorf("failed to write script: %w", err)
+	}
+	if profile != nil && profile.SwitchesUser() {
+		uid, gid := -1, -1
+		if profile.UID != nil {
+			uid = int(*profile.UID)
+		}
+		if profile.GID != nil {
+			gid = int(*profile.GID)
+		}
+		for _, p := range []string{dir, scriptPath} {
+			if err := os.Chown(p, uid, gid); err != nil {
+				os.RemoveAll(dir)
+				return "", "", fmt.Errorf("failed to change owner of script: %w", err)
+			}
+		}
+	}
+	return dir, scriptPath, nil
+}
@@ -0,0 +1,370 @@
+package plugins
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cloud-agent/internal/agent/jobs"
+	"github.com/cloud-agent/internal/agent/security"
+)
+
+func TestShellProfiles(t *testing.T) {
+	config, err := security.ParseSecurityConfig([]byte(`
+default_shell_profile: limited
+shell_profiles:
+  limited:
+    env:
+      MODE: limited
+    max_output_bytes: 16
+  plain:
+    inherit_env: [PATH]
+`))
+	if err != nil {
+		t.Fatalf("ParseSecurityConfig failed: %v", err)
+	}
+	e, err := NewShellExecutorWithSecurityConfig("agent-1", config)
+	if err != nil {
+		t.Fatalf("NewShellExecutorWithSecurityConfig failed: %v", err)
+	}
+
+	// 未指定执行配置时使用 default_shell_profile，超过输出上限的部分丢弃
+	var logs []string
+	result, err := e.Execute("t1", `echo "$MODE"; echo 0123456789abcdef`, nil, "", func(taskID, level, message string) {
+		logs = append(logs, level+":"+message)
+	})
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if result != "limited\n[output truncated at 16 bytes]\n" {
+		t.Errorf("result = %q", result)
+	}
+	if slices.Contains(logs, "info:0123456789abcdef") {
+		t.Errorf("truncated output still logged: %v", logs)
+	}
+
+	result, err = e.Execute("t2", `echo "mode=$MODE"`, map[string]interface{}{"profile": "plain"}, "", nil)
+	if err != nil || result != "mode=\n" {
+		t.Errorf("plain profile result = %q, err = %v", result, err)
+	}
+
+	if _, err := e.Execute("t3", "echo hi", map[string]interface{}{"profile": "root"}, "", nil); !errors.Is(err, ErrSecurityRejected) {
+		t.Errorf("unknown profile error = %v, want ErrSecurityRejected", err)
+	}
+
+	for _, invalid := range []string{
+		"default_shell_profile: missing\n",
+		"shell_profiles:\n  none: {}\n",
+		"shell_profiles:\n  jail:\n    chroot: true\n",
+		"shell_profiles:\n  rel:\n    work_dir: tmp\n",
+	} {
+		if _, err := security.ParseSecurityConfig([]byte(invalid)); err == nil {
+			t.Errorf("ParseSecurityConfig(%q) should fail", invalid)
+		}
+	}
+}
+
+func TestShellScript(t *testing.T) {
+	config, err := security.ParseSecurityConfig([]byte("shell_profiles:\n  plain:\n    inherit_env: [PATH]\n"))
+	if err != nil {
+		t.Fatalf("ParseSecurityConfig failed: %v", err)
+	}
+	e, err := NewShellExecutorWithSecurityConfig("agent-1", config)
+	if err != nil {
+		t.Fatalf("NewShellExecutorWithSecurityConfig failed: %v", err)
+	}
+
+	// 参数、环境变量和标准输入传给脚本，执行后记录退出码
+	var logs []string
+	result, err := e.Execute("t1", "", map[string]interface{}{
+		"operation": "script",
+		"script":    "read line\necho \"$1 $GREETING $line\"\n",
+		"args":      []interface{}{"hello"},
+		"env":       map[string]interface{}{"GREETING": "from"},
+		"stdin":     "stdin\n",
+		"profile":   "plain",
+	}, "", func(taskID, level, message string) {
+		logs = append(logs, level+":"+message)
+	})
+	if err != nil || result != "hello from stdin\n" {
+		t.Errorf("sh script result = %q, err = %v", result, err)
+	}
+	if !slices.Contains(logs, "info:Exit code: 0") {
+		t.Errorf("exit code not logged: %v", logs)
+	}
+
+	logs = nil
+	_, err = e.Execute("t2", "", map[string]interface{}{
+		"operation":   "script",
+		"interpreter": "python3",
+		"script":      "import sys\nprint(sys.argv[1])\nsys.exit(3)\n",
+		"args":        []interface{}{"py"},
+	}, "", func(taskID, level, message string) {
+		logs = append(logs, level+":"+message)
+	})
+	if err == nil || !slices.Contains(logs, "info:py") || !slices.Contains(logs, "info:Exit code: 3") {
+		t.Errorf("python3 script err = %v, logs = %v", err, logs)
+	}
+
+	for name, params := range map[string]map[string]interface{}{
+		"empty":       {"operation": "script"},
+		"bad args":    {"operation": "script", "script": "true", "args": "a b"},
+		"bad env":     {"operation": "script", "script": "true", "env": map[string]interface{}{"A=B": "c"}},
+		"interpreter": {"operation": "script", "script": "true", "interpreter": "ruby"},
+	} {
+		if _, err := e.Execute("t3", "", params, "", nil); err == nil {
+			t.Errorf("%s: Execute should fail", name)
+		}
+	}
+
+	// 启用白名单时只允许 script_interpreters 中的解释器，shell 脚本逐条校验，不能设置危险的环境变量
+	config, err = security.ParseSecurityConfig([]byte(`
+command_whitelist_enabled: true
+allowed_commands:
+  - pattern: "^echo"
+script_interpreters: [sh]
+`))
+	if err != nil {
+		t.Fatalf("ParseSecurityConfig failed: %v", err)
+	}
+	if err := e.UpdateSecurityConfig(config); err != nil {
+		t.Fatalf("UpdateSecurityConfig failed: %v", err)
+	}
+	if result, err := e.Execute("t4", "", map[string]interface{}{"operation": "script", "script": "echo ok"}, "", nil); err != nil || result != "ok\n" {
+		t.Errorf("whitelisted script result = %q, err = %v", result, err)
+	}
+	for name, params := range map[string]map[string]interface{}{
+		"command":     {"operation": "script", "script": "echo ok\nrm -rf /tmp/x"},
+		"interpreter": {"operation": "script", "script": "print(1)", "interpreter": "python3"},
+		"env":         {"operation": "script", "script": "echo ok", "env": map[string]interface{}{"LD_PRELOAD": "/tmp/x.so"}},
+	} {
+		if _, err := e.Execute("t5", "", params, "", nil); !errors.Is(err, ErrSecurityRejected) {
+			t.Errorf("%s: error = %v, want ErrSecurityRejected", name, err)
+		}
+	}
+
+	if _, err := security.ParseSecurityConfig([]byte("script_interpreters: [ruby]\n")); err == nil {
+		t.Error("ParseSecurityConfig should reject unsupported script interpreters")
+	}
+}
+
+func TestShellResult(t *testing.T) {
+	config, err := security.ParseSecurityConfig([]byte("shell_profiles:\n  small:\n    max_output_bytes: 8\n"))
+	if err != nil {
+		t.Fatalf("ParseSecurityConfig failed: %v", err)
+	}
+	e, err := NewShellExecutorWithSecurityConfig("agent-1", config)
+	if err != nil {
+		t.Fatalf("NewShellExecutorWithSecurityConfig failed: %v", err)
+	}
+	run := func(command string, params map[string]interface{}) (*ShellResult, error) {
+		t.Helper()
+		params["result_format"] = "json"
+		out, err := e.Execute("t1", command, params, "", nil)
+		var result ShellResult
+		if jsonErr := json.Unmarshal([]byte(out), &result); jsonErr != nil {
+			t.Fatalf("result %q is not JSON: %v", out, jsonErr)
+		}
+		return &result, err
+	}
+
+	// stdout 和 stderr 分开返回，空行保留
+	result, err := run("echo out; echo; echo err >&2; exit 3", map[string]interface{}{})
+	if err == nil || err.Error() != "command failed: exit status 3" {
+		t.Errorf("err = %v", err)
+	}
+	if result.ExitCode != 3 || result.Success || result.Stdout != "out\n\n" || result.Stderr != "err\n" {
+		t.Errorf("result = %+v", result)
+	}
+
+	// success_exit_codes 中的退出码视为成功
+	result, err = run("exit 1", map[string]interface{}{"success_exit_codes": []interface{}{float64(0), float64(1)}})
+	if err != nil || !result.Success || result.ExitCode != 1 {
+		t.Errorf("accepted exit code: result = %+v, err = %v", result, err)
+	}
+	result, err = run("true", map[string]interface{}{"success_exit_codes": []interface{}{float64(1)}})
+	if err == nil || result.Success {
+		t.Errorf("exit 0 not in success_exit_codes: result = %+v, err = %v", result, err)
+	}
+
+	// 被信号结束时不成功
+	result, err = run("kill -KILL $$", map[string]interface{}{"success_exit_codes": []interface{}{float64(0)}})
+	if err == nil || result.Signal != "killed" || result.ExitCode != -1 {
+		t.Errorf("signal: result = %+v, err = %v", result, err)
+	}
+
+	// 每个流分别截断
+	result, _ = run("echo 0123456789; echo ok >&2", map[string]interface{}{"profile": "small"})
+	if !result.StdoutTruncated || result.Stdout != "" || result.StderrTruncated || result.Stderr != "ok\n" {
+		t.Errorf("truncated result = %+v", result)
+	}
+
+	// 默认仍返回合并后的文本
+	out, err := e.Execute("t2", "printf 'a\\n\\nb\\n'", nil, "", nil)
+	if err != nil || out != "a\n\nb\n" {
+		t.Errorf("text result = %q, err = %v", out, err)
+	}
+
+	for name, params := range map[string]map[string]interface{}{
+		"format":     {"result_format": "xml"},
+		"codes":      {"success_exit_codes": "0"},
+		"empty":      {"success_exit_codes": []interface{}{}},
+		"range":      {"success_exit_codes": []interface{}{float64(256)}},
+		"fractional": {"success_exit_codes": []interface{}{1.5}},
+	} {
+		if _, err := e.Execute("t3", "true", params, "", nil); err == nil {
+			t.Errorf("%s: Execute should fail", name)
+		}
+	}
+}
+
+func TestShellDefaultOutputLimit(t *testing.T) {
+	e, err := NewShellExecutorWithSecurityConfig("agent-1", &security.SecurityConfig{})
+	if err != nil {
+		t.Fatalf("NewShellExecutorWithSecurityConfig failed: %v", err)
+	}
+
+	// 没有执行配置时同样限制保留的输出，超出上限的行丢弃，命令继续运行
+	command := fmt.Sprintf("echo first; head -c %d /dev/zero | tr '\\0' x; echo", security.DefaultMaxOutputBytes)
+	var logs []string
+	out, err := e.Execute("t1", command, nil, "", func(taskID, level, message string) {
+		logs = append(logs, level+":"+message)
+	})
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	// 没有换行的长输出按行上限拆分，保留到上限为止
+	marker := fmt.Sprintf("[output truncated at %d bytes]\n", security.DefaultMaxOutputBytes)
+	kept, ok := strings.CutSuffix(out, marker)
+	if !ok || !strings.HasPrefix(kept, "first\nxxx") || int64(len(kept)) > security.DefaultMaxOutputBytes || strings.Trim(kept[len("first\n"):], "x") != "" {
+		t.Errorf("result = %.100q (%d bytes), want first line, x prefix and %q", out, len(out), marker)
+	}
+	for _, l := range logs {
+		if len(l) > maxOutputLine+len("info:") {
+			t.Fatalf("log line of %d bytes exceeds the line limit", len(l))
+		}
+	}
+	if !slices.Contains(logs, fmt.Sprintf("warn:Output exceeds %d bytes, the rest is discarded", security.DefaultMaxOutputBytes)) {
+		t.Errorf("truncation not logged: %v", logs)
+	}
+
+	out, _ = e.Execute("t2", command+"; echo last >&2", map[string]interface{}{"result_format": "json"}, "", nil)
+	var result ShellResult
+	if err := json.Unmarshal([]byte(out), &result); err != nil {
+		t.Fatalf("result is not JSON: %v", err)
+	}
+	if !result.StdoutTruncated || !strings.HasPrefix(result.Stdout, "first\nxxx") || int64(len(result.Stdout)) > security.DefaultMaxOutputBytes ||
+		result.StderrTruncated || result.Stderr != "last\n" {
+		t.Errorf("stdout = %.100q (%d bytes), truncated = %v; stderr = %q, truncated = %v",
+			result.Stdout, len(result.Stdout), result.StdoutTruncated, result.Stderr, result.StderrTruncated)
+	}
+}
+
+// TestShellOutputWithoutNewlines 没有换行的大量输出不会整体读入内存，超出上限的部分直接丢弃
+func TestShellOutputWithoutNewlines(t *testing.T) {
+	config, err := security.ParseSecurityConfig([]byte(`
+default_shell_profile: small
+shell_profiles:
+  small:
+    max_output_bytes: 1024
+`))
+	if err != nil {
+		t.Fatalf("ParseSecurityConfig failed: %v", err)
+	}
+	e, err := NewShellExecutorWithSecurityConfig("agent-1", config)
+	if err != nil {
+		t.Fatalf("NewShellExecutorWithSecurityConfig failed: %v", err)
+	}
+
+	const size = 64 << 20
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	out, err := e.Execute("t1", fmt.Sprintf("head -c %d /dev/zero", size), nil, "", nil)
+	runtime.ReadMemStats(&after)
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if out != "[output truncated at 1024 bytes]\n" {
+		t.Errorf("result = %.100q, want only the truncation marker", out)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > size/16 {
+		t.Errorf("reading %d bytes of output allocated %d bytes", size, allocated)
+	}
+}
+
+// TestShellOutputConcurrentStreams stdout 和 stderr 由两个 goroutine 同时写入合并输出，需要在 -race 下运行
+func TestShellOutputConcurrentStreams(t *testing.T) {
+	output := newShellOutput(0)
+	var wg sync.WaitGroup
+	for _, stream := range []*cappedOutput{&output.stdout, &output.stderr} {
+		wg.Go(func() {
+			for range 1000 {
+				stream.add("line\n")
+				output.combined.add("line\n")
+			}
+		})
+	}
+	wg.Wait()
+	if got := len(output.combined.text()); got != 2000*len("line\n") {
+		t.Errorf("combined output has %d bytes, want %d", got, 2000*len("line\n"))
+	}
+
+	e, err := NewShellExecutorWithSecurityConfig("agent-1", &security.SecurityConfig{})
+	if err != nil {
+		t.Fatalf("NewShellExecutorWithSecurityConfig failed: %v", err)
+	}
+	out, err := e.Execute("t1", "for i in $(seq 500); do echo out; echo err >&2; done", nil, "", nil)
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if strings.Count(out, "out\n") != 500 || strings.Count(out, "err\n") != 500 {
+		t.Errorf("combined result lost lines: %d out, %d err", strings.Count(out, "out\n"), strings.Count(out, "err\n"))
+	}
+}
+
+func TestShellStartJob(t *testing.T) {
+	config, err := security.ParseSecurityConfig([]byte("jobs:\n  enabled: true\n  max_duration: 1h\n"))
+	if err != nil {
+		t.Fatalf("ParseSecurityConfig failed: %v", err)
+	}
+	e, err := NewShellExecutorWithSecurityConfig("agent-1", config)
+	if err != nil {
+		t.Fatalf("NewShellExecutorWithSecurityConfig failed: %v", err)
+	}
+	root := t.TempDir()
+	mgr := jobs.NewManager(root)
+
+	// 未通过校验的任务不会启动
+	for name, tc := range map[string]struct {
+		command string
+		params  map[string]interface{}
+	}{
+		"blocked": {"rm -rf /", map[string]interface{}{"detach": true}},
+		"timeout": {"sleep 1", map[string]interface{}{"detach": true, "job_timeout": "1h"}},
+		"format":  {"sleep 1", map[string]interface{}{"detach": true, "result_format": "json"}},
+		"profile": {"sleep 1", map[string]interface{}{"detach": true, "profile": "missing"}},
+	} {
+		if err := e.StartJob("t1", tc.command, tc.params, "", nil, mgr); err == nil {
+			t.Errorf("%s: StartJob succeeded", name)
+		}
+	}
+	if entries, _ := os.ReadDir(root); len(entries) != 0 {
+		t.Errorf("rejected jobs created dirs: %v", entries)
+	}
+
+	// 默认不允许后台任务
+	disabled, err := NewShellExecutorWithSecurityConfig("agent-1", &security.SecurityConfig{})
+	if err != nil {
+		t.Fatalf("NewShellExecutorWithSecurityConfig failed: %v", err)
+	}
+	if err := disabled.StartJob("t1", "sleep 1", map[string]interface{}{"detach": true}, "", nil, mgr); !errors.Is(err, ErrSecurityRejected) {
+		t.Errorf("disabled jobs: err = %v, want ErrSecurityRejected", err)
+	}
+}
@@ -0,0 +1,135 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/cloud-agent/internal/agent/executor"
+	"github.com/cloud-agent/internal/agent/security"
+	"github.com/cloud-agent/internal/common"
+	"gopkg.in/yaml.v3"
+)
+
+// localConfigKinds 本地配置文件的类型，安全配置先于插件配置加载，新建的 Shell 执行器使用新规则
+var localConfigKinds = []string{common.AgentConfigKindSecurity, common.AgentConfigKindPlugins}
+
+// ReloadLocalConfig 重新加载本地插件和安全配置文件，收到 SIGHUP 时调用
+// 文件无法读取或解析时保留当前配置；正在使用远程配置的类型跳过
+func (a *Agent) ReloadLocalConfig() error {
+	return a.reloadLocalConfig(true)
+}
+
+// StartConfigWatcher 每隔 interval 检查本地配置文件，内容变化时重新加载，interval <= 0 时不启用
+func (a *Agent) StartConfigWatcher(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-a.stopCh:
+				return
+			case <-ticker.C:
+				if err := a.reloadLocalConfig(false); err != nil {
+					log.Printf("Failed to reload local config: %v", err)
+				}
+			}
+		}
+	}()
+	log.Printf("Watching local config files every %s", interval)
+}
+
+// reloadLocalConfig 按类型重新加载本地配置文件，force 为 false 时只加载内容变化的文件
+// 每种配置单独校验和应用，失败的类型保留当前配置，直到文件再次变化或收到 SIGHUP
+func (a *Agent) reloadLocalConfig(force bool) error {
+	a.configMu.Lock()
+	defer a.configMu.Unlock()
+
+	var errs []error
+	for _, kind := range localConfigKinds {
+		if a.appliedConfigs[kind] != "" {
+			continue
+		}
+		path := a.localConfigPath(kind)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			// 文件被删除时不退回默认配置（默认不启用白名单），保留当前配置
+			if force || !os.IsNotExist(err) {
+				errs = append(errs, fmt.Errorf("failed to read %s config: %w", kind, err))
+			}
+			continue
+		}
+		sum := sha256.Sum256(data)
+		checksum := hex.EncodeToString(sum[:])
+		if !force && checksum == a.localChecksums[kind] {
+			continue
+		}
+		a.localChecksums[kind] = checksum
+
+		if err := a.applyLocalConfig(kind, data); err != nil {
+			errs = append(errs, fmt.Errorf("%s config %s not applied, keeping the current config: %w", kind, path, err))
+			a.reportConfig(&common.AgentConfigAppliedData{Kind: kind, Status: common.AgentConfigStatusFailed, Error: err.Error()})
+			continue
+		}
+		log.Printf("Reloaded %s config from %s", kind, path)
+		a.reportConfig(&common.AgentConfigAppliedData{Kind: kind, Status: common.AgentConfigStatusApplied})
+	}
+	return errors.Join(errs...)
+}
+
+// applyLocalConfig 解析并应用本地配置文件内容，解析和校验规则与 Cloud 下发的配置相同
+// 空文档（编辑器保存时可能先截断文件）视为无效，不能当作全部使用默认值的配置应用
+func (a *Agent) applyLocalConfig(kind string, data []byte) error {
+	if emptyDocument(data) {
+		return errors.New("config file is empty")
+	}
+	switch kind {
+	case common.AgentConfigKindPlugins:
+		config, err := executor.ParsePluginConfig(data)
+		if err != nil {
+			return err
+		}
+		return a.executor.ReloadPlugins(config)
+	case common.AgentConfigKindSecurity:
+		config, err := security.ParseSecurityConfig(data)
+		if err != nil {
+			return err
+		}
+		return a.executor.UpdateSecurityConfig(config)
+	}
+	return fmt.Errorf("unknown config kind: %s", kind)
+}
+
+// emptyDocument YAML 内容是否为空（只有空白或注释）
+func emptyDocument(data []byte) bool {
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return false
+	}
+	return len(node.Content) == 0 || node.Content[0].Kind == yaml.ScalarNode && node.Content[0].Tag == "!!null"
+}
+
+// localConfigPath 本地配置文件路径
+func (a *Agent) localConfigPath(kind string) string {
+	if kind == common.AgentConfigKindPlugins {
+		return a.pluginsConfigPath
+	}
+	return a.securityConfigPath
+}
+
+// fileChecksum 文件内容的 SHA-256，文件无法读取时返回空字符串
+func fileChecksum(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,84 @@
+package agent
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/cloud-agent/internal/agent/plugins"
+	"github.com/cloud-agent/internal/common"
+)
+
+func TestReloadLocalConfig(t *testing.T) {
+	dir := t.TempDir()
+	pluginsPath := filepath.Join(dir, "agent-plugins.yaml")
+	securityPath := filepath.Join(dir, "agent-security.yaml")
+	write := func(path, content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(pluginsPath, "plugins:\n  - type: shell\n    enabled: true\n  - type: file\n    enabled: true\n")
+	write(securityPath, "blocked_patterns:\n  - pattern: \"^echo blocked\"\n    reason: test\n")
+	t.Setenv("AGENT_PLUGINS_CONFIG", pluginsPath)
+	t.Setenv("AGENT_SECURITY_CONFIG", securityPath)
+
+	a := NewAgent("http://127.0.0.1:0", "agent-1", "agent-1")
+	t.Cleanup(func() { a.Stop() })
+	blocked := func() bool {
+		_, err := a.executor.Execute("t1", common.TaskTypeShell, "echo blocked", nil, "", nil)
+		return errors.Is(err, plugins.ErrSecurityRejected)
+	}
+	if !blocked() {
+		t.Fatal("command should be blocked by the initial security config")
+	}
+
+	// 未变化的文件不重新加载
+	if err := a.reloadLocalConfig(false); err != nil {
+		t.Fatalf("reload without changes failed: %v", err)
+	}
+
+	// 无效的规则不生效，保留当前配置
+	write(securityPath, "blocked_patterns:\n  - pattern: \"(\"\n")
+	if err := a.reloadLocalConfig(false); err == nil {
+		t.Fatal("invalid security config should fail to reload")
+	}
+	if !blocked() {
+		t.Fatal("previous security config should be kept after a failed reload")
+	}
+
+	// 截断的空文件和未知字段不生效，不会退回默认配置
+	for _, content := range []string{"", "\n", "# agent security\n", "~\n", "command_whitelist_enable: true\n"} {
+		write(securityPath, content)
+		if err := a.reloadLocalConfig(false); err == nil {
+			t.Errorf("security config %q should fail to reload", content)
+		}
+		if !blocked() {
+			t.Fatalf("previous security config should be kept after reloading %q", content)
+		}
+	}
+
+	write(securityPath, "command_whitelist_enabled: false\n")
+	write(pluginsPath, "plugins:\n  - type: shell\n    enabled: true\n")
+	if err := a.reloadLocalConfig(false); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	if blocked() {
+		t.Error("new security config should take effect")
+	}
+	if types := a.executor.GetRegisteredExecutors(); !slices.Equal(types, []common.TaskType{common.TaskTypeShell}) {
+		t.Errorf("executors after reload = %v, want [shell]", types)
+	}
+
+	// 删除的文件不会退回默认配置，SIGHUP 时返回错误
+	os.Remove(securityPath)
+	if err := a.reloadLocalConfig(false); err != nil {
+		t.Errorf("missing file should be ignored by the watcher, got %v", err)
+	}
+	if err := a.ReloadLocalConfig(); err == nil {
+		t.Error("forced reload should report the missing file")
+	}
+}
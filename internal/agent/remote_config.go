@@ -0,0 +1,106 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/cloud-agent/internal/agent/executor"
+	"github.com/cloud-agent/internal/agent/security"
+	"github.com/cloud-agent/internal/common"
+)
+
+// handleConfigUpdate 校验并应用 Cloud 下发的插件或安全配置，然后上报应用结果
+// 未启用远程配置（AGENT_REMOTE_CONFIG）时不做修改，上报 disabled
+func (a *Agent) handleConfigUpdate(msg *common.Message) {
+	dataBytes, _ := json.Marshal(msg.Data)
+	var data common.AgentConfigData
+	if err := json.Unmarshal(dataBytes, &data); err != nil {
+		log.Printf("Failed to parse config update: %v", err)
+		return
+	}
+
+	applied := &common.AgentConfigAppliedData{
+		Kind:     data.Kind,
+		ConfigID: data.ConfigID,
+		Version:  data.Version,
+		Checksum: data.Checksum,
+		Status:   common.AgentConfigStatusApplied,
+	}
+	switch err := a.applyConfig(&data); {
+	case !a.remoteConfig:
+		applied.Status = common.AgentConfigStatusDisabled
+	case err != nil:
+		log.Printf("Failed to apply %s config version %d: %v", data.Kind, data.Version, err)
+		applied.Status = common.AgentConfigStatusFailed
+		applied.Error = err.Error()
+	}
+
+	a.reportConfig(applied)
+}
+
+// reportConfig 向 Cloud 上报配置应用结果，插件配置应用成功时附带新的执行器能力
+// ConfigID 为 0 表示使用本地配置文件，未启用远程配置时状态为 disabled
+func (a *Agent) reportConfig(applied *common.AgentConfigAppliedData) {
+	if applied.ConfigID == 0 && !a.remoteConfig && applied.Status == common.AgentConfigStatusApplied {
+		applied.Status = common.AgentConfigStatusDisabled
+	}
+	if applied.Kind == common.AgentConfigKindPlugins && applied.Error == "" {
+		applied.Capabilities = a.executor.Capabilities()
+	}
+	if err := a.client.SendMessage(common.NewMessage(common.MessageTypeConfigApplied, applied)); err != nil {
+		log.Printf("Failed to report config state: %v", err)
+	}
+}
+
+// applyConfig 应用配置，校验和与当前已应用的相同时不重复应用
+// ConfigID 为 0 表示 Cloud 上没有适用的配置，恢复使用本地配置文件
+func (a *Agent) applyConfig(data *common.AgentConfigData) error {
+	if !a.remoteConfig {
+		return nil
+	}
+
+	a.configMu.Lock()
+	defer a.configMu.Unlock()
+
+	if a.appliedConfigs[data.Kind] == data.Checksum {
+		return nil
+	}
+
+	var err error
+	switch data.Kind {
+	case common.AgentConfigKindPlugins:
+		var config *executor.PluginConfig
+		if data.ConfigID == 0 {
+			config, err = executor.LoadPluginConfig(a.pluginsConfigPath)
+		} else {
+			config, err = executor.ParsePluginConfig([]byte(data.Content))
+		}
+		if err == nil {
+			err = a.executor.ReloadPlugins(config)
+		}
+	case common.AgentConfigKindSecurity:
+		var config *security.SecurityConfig
+		if data.ConfigID == 0 {
+			config, err = security.LoadSecurityConfig(a.securityConfigPath)
+		} else {
+			config, err = security.ParseSecurityConfig([]byte(data.Content))
+		}
+		if err == nil {
+			err = a.executor.UpdateSecurityConfig(config)
+		}
+	default:
+		return fmt.Errorf("unknown config kind: %s", data.Kind)
+	}
+	if err != nil {
+		return err
+	}
+
+	a.appliedConfigs[data.Kind] = data.Checksum
+	if data.ConfigID == 0 {
+		log.Printf("Reverted %s config to local file", data.Kind)
+	} else {
+		log.Printf("Applied remote %s config version %d", data.Kind, data.Version)
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package sandbox
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/cloud-agent/internal/agent/security"
+)
+
+// Apply 按执行配置设置命令的环境变量、工作目录、用户、权限和资源限制，需要在 cmd.Start 之前调用
+// 返回的清理函数在命令结束后调用，删除任务的 cgroup 并结束其中残留的进程
+func Apply(cmd *exec.Cmd, taskID string, profile *security.ShellProfile) (func(), error) {
+	cmd.Env = profile.Environ(os.Environ())
+	if profile.WorkDir != "" {
+		cmd.Dir = profile.WorkDir
+	}
+	return apply(cmd, taskID, profile)
+}
+
+// cgroupName 任务 cgroup 的目录名，只保留任务 ID 中的字母、数字、- 和 _
+func cgroupName(taskID string) string {
+	return "task-" + strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, taskID)
+}
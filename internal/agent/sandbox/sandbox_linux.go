@@ -0,0 +1,228 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"cmp"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cloud-agent/internal/agent/security"
+)
+
+// helperArg Agent 以沙箱辅助进程方式运行时的第一个参数
+const helperArg = "__sandbox-exec"
+
+// prSetNoNewPrivs prctl 的 PR_SET_NO_NEW_PRIVS
+const prSetNoNewPrivs = 38
+
+// cpuPeriod cpu.max 的周期（微秒）
+const cpuPeriod = 100000
+
+// apply 切换用户、根目录和 no_new_privs 由辅助进程完成，资源限制通过 cgroup v2 实现
+func apply(cmd *exec.Cmd, taskID string, profile *security.ShellProfile) (func(), error) {
+	if profile.SwitchesUser() || profile.Chroot || profile.NoNewPrivileges {
+		if err := wrapHelper(cmd, profile); err != nil {
+			return nil, err
+		}
+	}
+	if !profile.NeedsCgroup() {
+		return func() {}, nil
+	}
+	return joinCgroup(cmd, taskID, profile)
+}
+
+// wrapHelper 改为通过 Agent 自身（辅助进程）执行命令
+// os/exec 无法在 exec 之前设置 no_new_privs，chroot 后也找不到 Agent 的可执行文件，因此都由辅助进程完成
+func wrapHelper(cmd *exec.Cmd, profile *security.ShellProfile) error {
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate agent executable for sandbox: %w", err)
+	}
+	args := []string{self, helperArg}
+	if profile.UID != nil {
+		args = append(args, "-uid", strconv.FormatUint(uint64(*profile.UID), 10))
+	}
+	if profile.GID != nil {
+		args = append(args, "-gid", strconv.FormatUint(uint64(*profile.GID), 10))
+	}
+	if profile.SwitchesUser() {
+		groups := make([]string, len(profile.Groups))
+		for i, g := range profile.Groups {
+			groups[i] = strconv.FormatUint(uint64(g), 10)
+		}
+		args = append(args, "-groups", strings.Join(groups, ","), "-set-groups")
+	}
+	if profile.Chroot {
+		args = append(args, "-chroot", profile.WorkDir)
+	}
+	if profile.NoNewPrivileges {
+		args = append(args, "-no-new-privs")
+	}
+	cmd.Path = self
+	cmd.Args = append(append(args, "--"), cmd.Args...)
+	return nil
+}
+
+// RunHelper Agent 作为沙箱辅助进程启动时，切换根目录、用户并设置 no_new_privs 后执行命令，不会返回
+// 不是辅助进程时直接返回，需要在 Agent 的 main 函数开头调用
+func RunHelper() {
+	if len(os.Args) < 2 || os.Args[1] != helperArg {
+		return
+	}
+	if err := runHelper(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+		os.Exit(126)
+	}
+}
+
+// runHelper 辅助进程的实现，成功时 exec 为目标命令
+func runHelper(args []string) error {
+	fs := flag.NewFlagSet(helperArg, flag.ContinueOnError)
+	uid := fs.Int("uid", -1, "")
+	gid := fs.Int("gid", -1, "")
+	groups := fs.String("groups", "", "")
+	setGroups := fs.Bool("set-groups", false, "")
+	chroot := fs.String("chroot", "", "")
+	noNewPrivs := fs.Bool("no-new-privs", false, "")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	argv := fs.Args()
+	if len(argv) == 0 {
+		return errors.New("no command")
+	}
+
+	if *chroot != "" {
+		if err := syscall.Chroot(*chroot); err != nil {
+			return fmt.Errorf("chroot %s: %w", *chroot, err)
+		}
+		if err := syscall.Chdir("/"); err != nil {
+			return err
+		}
+	}
+	// 先设置组再设置用户，放弃 root 后无法再修改组
+	if *setGroups {
+		var gids []int
+		for _, g := range strings.Split(*groups, ",") {
+			if g == "" {
+				continue
+			}
+			n, err := strconv.Atoi(g)
+			if err != nil {
+				return fmt.Errorf("invalid group %q", g)
+			}
+			gids = append(gids, n)
+		}
+		if err := syscall.Setgroups(gids); err != nil {
+			return fmt.Errorf("setgroups: %w", err)
+		}
+	}
+	if *gid >= 0 {
+		if err := syscall.Setgid(*gid); err != nil {
+			return fmt.Errorf("setgid %d: %w", *gid, err)
+		}
+	}
+	if *uid >= 0 {
+		if err := syscall.Setuid(*uid); err != nil {
+			return fmt.Errorf("setuid %d: %w", *uid, err)
+		}
+	}
+	if *noNewPrivs {
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+			return fmt.Errorf("prctl(PR_SET_NO_NEW_PRIVS): %w", errno)
+		}
+	}
+
+	path, err := exec.LookPath(argv[0])
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(path, argv, os.Environ())
+}
+
+// joinCgroup 为任务创建 cgroup 并设置资源限制，命令启动时直接进入该 cgroup
+func joinCgroup(cmd *exec.Cmd, taskID string, profile *security.ShellProfile) (func(), error) {
+	parent := cmp.Or(profile.CgroupParent, security.DefaultCgroupParent)
+	if _, err := os.Stat(parent); os.IsNotExist(err) {
+		if _, err := os.Stat(filepath.Join(filepath.Dir(parent), "cgroup.controllers")); err != nil {
+			return nil, fmt.Errorf("cgroup v2 is not available at %s", filepath.Dir(parent))
+		}
+		if err := os.Mkdir(parent, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create cgroup %s: %w", parent, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(parent, "cgroup.controllers")); err != nil {
+		return nil, fmt.Errorf("%s is not a cgroup v2 directory", parent)
+	}
+
+	// 在父 cgroup 中为子 cgroup 启用需要的控制器
+	limits := map[string]map[string]string{}
+	if profile.CPU > 0 {
+		quota := max(int64(profile.CPU*cpuPeriod), 1000)
+		limits["cpu"] = map[string]string{"cpu.max": fmt.Sprintf("%d %d", quota, cpuPeriod)}
+	}
+	if profile.MemoryMB > 0 {
+		limits["memory"] = map[string]string{"memory.max": strconv.FormatInt(profile.MemoryMB<<20, 10)}
+	}
+	if profile.PidsMax > 0 {
+		limits["pids"] = map[string]string{"pids.max": strconv.FormatInt(profile.PidsMax, 10)}
+	}
+	for controller := range limits {
+		if err := os.WriteFile(filepath.Join(parent, "cgroup.subtree_control"), []byte("+"+controller), 0); err != nil {
+			return nil, fmt.Errorf("failed to enable %s controller in %s: %w", controller, parent, err)
+		}
+	}
+
+	dir := filepath.Join(parent, cgroupName(taskID))
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create cgroup %s: %w", dir, err)
+	}
+	cleanup := func() {
+		// 结束后台残留的进程后删除 cgroup
+		os.WriteFile(filepath.Join(dir, "cgroup.kill"), []byte("1"), 0)
+		for range 50 {
+			if err := os.Remove(dir); err == nil || os.IsNotExist(err) {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		log.Printf("Failed to remove cgroup %s", dir)
+	}
+	for _, files := range limits {
+		for file, value := range files {
+			if err := os.WriteFile(filepath.Join(dir, file), []byte(value), 0); err != nil {
+				cleanup()
+				return nil, fmt.Errorf("failed to set %s: %w", file, err)
+			}
+		}
+	}
+	if profile.MemoryMB > 0 {
+		// 不允许使用 swap 绕过内存限制，未启用 swap 统计时该文件不存在
+		os.WriteFile(filepath.Join(dir, "memory.swap.max"), []byte("0"), 0)
+	}
+
+	fd, err := os.Open(dir)
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(fd.Fd())
+	return func() {
+		fd.Close()
+		cleanup()
+	}, nil
+}
@@ -0,0 +1,92 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cloud-agent/internal/agent/security"
+)
+
+// TestMain 测试二进制同样作为沙箱辅助进程使用
+func TestMain(m *testing.M) {
+	RunHelper()
+	os.Exit(m.Run())
+}
+
+func run(t *testing.T, profile *security.ShellProfile, script string) (string, error) {
+	t.Helper()
+	cmd := exec.Command("sh", "-c", script)
+	cleanup, err := Apply(cmd, "t1", profile)
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+	out, err := cmd.CombinedOutput()
+	return strings.TrimSpace(string(out)), err
+}
+
+func TestApplyEnvironmentAndWorkDir(t *testing.T) {
+	t.Setenv("SANDBOX_KEEP", "kept")
+	t.Setenv("SANDBOX_SECRET", "leaked")
+	dir := t.TempDir()
+	profile := &security.ShellProfile{
+		WorkDir:    dir,
+		InheritEnv: []string{"SANDBOX_K*"},
+		Env:        map[string]string{"GREETING": "hello"},
+	}
+	out, err := run(t, profile, `echo "$SANDBOX_KEEP,$SANDBOX_SECRET,$GREETING,$PATH"; pwd`)
+	if err != nil {
+		t.Fatalf("run failed: %v: %s", err, out)
+	}
+	want := "kept,,hello,/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin\n" + dir
+	if out != want {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+}
+
+func TestApplyNoNewPrivilegesAndUser(t *testing.T) {
+	out, err := run(t, &security.ShellProfile{NoNewPrivileges: true}, "grep NoNewPrivs /proc/self/status")
+	if err != nil || !strings.HasSuffix(out, "1") {
+		t.Errorf("no_new_privileges: output = %q, err = %v", out, err)
+	}
+
+	if os.Geteuid() != 0 {
+		t.Skip("switching user requires root")
+	}
+	nobody := uint32(65534)
+	out, err = run(t, &security.ShellProfile{UID: &nobody, GID: &nobody}, "id -u; id -g; id -G")
+	if err != nil || out != "65534\n65534\n65534" {
+		t.Errorf("uid/gid: output = %q, err = %v", out, err)
+	}
+}
+
+func TestApplyCgroup(t *testing.T) {
+	if _, err := os.Stat("/sys/fs/cgroup/cgroup.controllers"); err != nil || os.Geteuid() != 0 {
+		t.Skip("cgroup v2 is not writable")
+	}
+	parent := filepath.Join("/sys/fs/cgroup", "cloud-agent-test")
+	t.Cleanup(func() { os.Remove(parent) })
+	profile := &security.ShellProfile{PidsMax: 16, MemoryMB: 64, CgroupParent: parent}
+	out, err := run(t, profile, "cat /sys/fs/cgroup$(cut -d: -f3 /proc/self/cgroup)/pids.max")
+	if err != nil {
+		t.Skipf("cgroup not usable here: %v: %s", err, out)
+	}
+	if out != "16" {
+		t.Errorf("pids.max = %q, want 16", out)
+	}
+	if _, err := os.Stat(filepath.Join(parent, cgroupName("t1"))); !os.IsNotExist(err) {
+		t.Errorf("task cgroup not removed: %v", err)
+	}
+}
+
+func TestApplyCgroupUnavailable(t *testing.T) {
+	profile := &security.ShellProfile{PidsMax: 16, CgroupParent: filepath.Join(t.TempDir(), "missing", "cloud-agent")}
+	if _, err := Apply(exec.Command("true"), "t1", profile); err == nil {
+		t.Error("Apply should fail when cgroup v2 is not available instead of running without limits")
+	}
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+
+package sandbox
+
+import (
+	"fmt"
+	"os/exec"
+
+	"github.com/cloud-agent/internal/agent/security"
+)
+
+// apply 非 Linux 平台只支持环境变量、工作目录和输出上限
+func apply(cmd *exec.Cmd, taskID string, profile *security.ShellProfile) (func(), error) {
+	if profile.SwitchesUser() || profile.Chroot || profile.NoNewPrivileges || profile.NeedsCgroup() {
+		return nil, fmt.Errorf("uid/gid, chroot, no_new_privileges and resource limits are only supported on linux")
+	}
+	return func() {}, nil
+}
+
+// RunHelper 非 Linux 平台没有沙箱辅助进程
+func RunHelper() {}
@@ -0,0 +1,420 @@
+// Package secrets 解析插件配置和任务参数中的 secret:// 引用
+//
+// 密钥在插件配置的 secrets 段中定义来源（环境变量、文件、Kubernetes Secret 或 Cloud 密钥库），
+// 未在本地定义的名称从 Cloud 下发的密钥库密钥中查找。任务参数中的引用只能出现在连接字段
+// （target 和 API 请求的 headers）中，并且目标主机匹配密钥的 allowed_hosts 时才会解析，
+// 防止通过任务把密钥发送到任意主机或交给 Shell 命令。
+// 解析过的值会被记录下来，用于从日志、结果和错误信息中脱敏。
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloud-agent/internal/common"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+var (
+	// ErrSecretNotFound 密钥未定义或来源中没有该值
+	ErrSecretNotFound = errors.New("secret not found")
+	// ErrSecretNotAllowed 任务参数中引用的密钥不允许用于该主机
+	ErrSecretNotAllowed = errors.New("secret not allowed for target")
+)
+
+// k8sCacheTTL Kubernetes Secret 值的缓存时长
+const k8sCacheTTL = time.Minute
+
+// minRedactLength 参与脱敏的最短密钥长度，过短的值容易误伤正常输出
+const minRedactLength = 4
+
+// Definition 密钥来源，env、file、k8s、cloud 只能设置一个
+type Definition struct {
+	Env          string     `yaml:"env"`           // 环境变量名
+	File         string     `yaml:"file"`          // 文件路径，去掉末尾换行
+	K8s          *K8sSecret `yaml:"k8s"`           // Agent 所在集群的 Secret
+	Cloud        string     `yaml:"cloud"`         // Cloud 密钥库中的密钥名
+	AllowedHosts []string   `yaml:"allowed_hosts"` // 允许在任务 target 中使用的主机（支持 * 通配），为空时只能用于插件配置
+}
+
+// K8sSecret Kubernetes Secret 中的一个键
+type K8sSecret struct {
+	Namespace string `yaml:"namespace"`
+	Name      string `yaml:"name"`
+	Key       string `yaml:"key"`
+}
+
+// Resolver 密钥解析器
+// WithDefinitions 返回的解析器与原解析器共享 Cloud 密钥和已解析的值
+// 所有方法都可以在 nil 上调用，此时没有可用的密钥
+type Resolver struct {
+	defs  map[string]Definition
+	store *store
+}
+
+// store 解析器之间共享的状态
+type store struct {
+	mu       sync.RWMutex
+	cloud    map[string]common.SecretValue
+	k8sCache map[string]cachedValue
+	k8s      kubernetes.Interface
+	values   map[string]struct{} // 已解析的值，用于脱敏
+	replacer *strings.Replacer
+}
+
+// cachedValue 缓存的 Kubernetes Secret 值
+type cachedValue struct {
+	value   string
+	expires time.Time
+}
+
+// NewResolver 创建没有本地定义的密钥解析器
+func NewResolver() *Resolver {
+	return &Resolver{
+		store: &store{
+			cloud:    make(map[string]common.SecretValue),
+			k8sCache: make(map[string]cachedValue),
+			values:   make(map[string]struct{}),
+		},
+	}
+}
+
+// WithDefinitions 校验本地密钥定义，返回使用这些定义的解析器
+func (r *Resolver) WithDefinitions(defs map[string]Definition) (*Resolver, error) {
+	if r == nil {
+		r = NewResolver()
+	}
+	for name, def := range defs {
+		sources := 0
+		for _, set := range []bool{def.Env != "", def.File != "", def.K8s != nil, def.Cloud != ""} {
+			if set {
+				sources++
+			}
+		}
+		if sources != 1 {
+			return nil, fmt.Errorf("secret %s must set exactly one of env, file, k8s, cloud", name)
+		}
+		if def.K8s != nil && (def.K8s.Namespace == "" || def.K8s.Name == "" || def.K8s.Key == "") {
+			return nil, fmt.Errorf("secret %s: k8s requires namespace, name and key", name)
+		}
+		for _, pattern := range def.AllowedHosts {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("secret %s: invalid allowed_hosts pattern %q", name, pattern)
+			}
+		}
+	}
+	return &Resolver{defs: defs, store: r.store}, nil
+}
+
+// SetCloudSecrets 替换 Cloud 下发的密钥库密钥
+func (r *Resolver) SetCloudSecrets(secrets []common.SecretValue) {
+	if r == nil {
+		return
+	}
+	cloud := make(map[string]common.SecretValue, len(secrets))
+	for _, s := range secrets {
+		cloud[s.Name] = s
+	}
+	r.store.mu.Lock()
+	r.store.cloud = cloud
+	r.store.mu.Unlock()
+}
+
+// ResolveConfig 返回插件配置的副本，其中的密钥引用替换为实际值
+// 无法解析的引用保持原样并返回错误，调用方可以继续使用其余配置
+func (r *Resolver) ResolveConfig(config map[string]interface{}) (map[string]interface{}, error) {
+	if !ContainsRef(config) {
+		return config, nil
+	}
+	var errs []error
+	resolved, _ := r.resolve(config, nil, false, &errs).(map[string]interface{})
+	return resolved, errors.Join(errs...)
+}
+
+// ResolveParams 返回任务参数的副本，其中的密钥引用替换为实际值，任一引用无法解析时返回错误
+// 引用只能出现在 target（目标主机取自 target 自身的 host、url、endpoint）和 headers（目标主机取自参数的 url）中，
+// 其他字段（如 Shell 的 env、stdin、args）中的引用一律拒绝
+func (r *Resolver) ResolveParams(params map[string]interface{}) (map[string]interface{}, error) {
+	if !ContainsRef(params) {
+		return params, nil
+	}
+	var errs []error
+	resolved := make(map[string]interface{}, len(params))
+	for key, value := range params {
+		switch {
+		case !ContainsRef(value):
+			resolved[key] = value
+		case key == "target":
+			if _, ok := value.(map[string]interface{}); !ok {
+				errs = append(errs, fmt.Errorf("%w: target must be an object", ErrSecretNotAllowed))
+				resolved[key] = value
+				continue
+			}
+			resolved[key] = r.resolve(value, nil, true, &errs)
+		case key == "headers":
+			resolved[key] = r.resolveHeaders(value, params["url"], &errs)
+		default:
+			errs = append(errs, fmt.Errorf("%w: %s: secret references are only allowed in target or headers", ErrSecretNotAllowed, key))
+			resolved[key] = value
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return resolved, nil
+}
+
+// resolveHeaders 解析 HTTP 请求头中的密钥引用，目标主机只取自请求的 url，请求头本身（如 Host）不参与授权
+func (r *Resolver) resolveHeaders(value, rawURL interface{}, errs *[]error) interface{} {
+	headers, ok := value.(map[string]interface{})
+	if !ok {
+		*errs = append(*errs, fmt.Errorf("%w: headers must be an object of strings", ErrSecretNotAllowed))
+		return value
+	}
+	var hosts []string
+	if raw, ok := rawURL.(string); ok {
+		hosts = targetHosts(map[string]interface{}{"url": raw})
+	}
+	out := make(map[string]interface{}, len(headers))
+	for k, item := range headers {
+		str, ok := item.(string)
+		if !ok {
+			if ContainsRef(item) {
+				*errs = append(*errs, fmt.Errorf("%w: header %s must be a string", ErrSecretNotAllowed, k))
+			}
+			out[k] = item
+			continue
+		}
+		out[k] = r.resolve(str, hosts, true, errs)
+	}
+	return out
+}
+
+// Redact 把字符串中出现的已解析密钥替换为占位值
+func (r *Resolver) Redact(s string) string {
+	if r == nil {
+		return s
+	}
+	r.store.mu.RLock()
+	replacer := r.store.replacer
+	r.store.mu.RUnlock()
+	if replacer == nil || s == "" {
+		return s
+	}
+	return replacer.Replace(s)
+}
+
+// ContainsRef 判断配置或参数中是否包含密钥引用
+func ContainsRef(value interface{}) bool {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, item := range v {
+			if ContainsRef(item) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if ContainsRef(item) {
+				return true
+			}
+		}
+	case string:
+		return common.IsSecretRef(v)
+	}
+	return false
+}
+
+// resolve 递归替换密钥引用，hosts 为引用所在对象中的目标主机
+func (r *Resolver) resolve(value interface{}, hosts []string, fromParams bool, errs *[]error) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		// 嵌套对象（如 target.auth）沿用外层对象的主机，任务参数只从 target 开始递归
+		if objectHosts := targetHosts(v); len(objectHosts) > 0 {
+			hosts = objectHosts
+		}
+		for k, item := range v {
+			out[k] = r.resolve(item, hosts, fromParams, errs)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = r.resolve(item, hosts, fromParams, errs)
+		}
+		return out
+	case string:
+		name := common.SecretRefName(v)
+		if name == "" {
+			return v
+		}
+		secret, err := r.lookup(name, hosts, fromParams)
+		if err != nil {
+			*errs = append(*errs, err)
+			return v
+		}
+		r.store.remember(secret)
+		return secret
+	}
+	return value
+}
+
+// lookup 按名称查找密钥，fromParams 时检查目标主机
+func (r *Resolver) lookup(name string, hosts []string, fromParams bool) (string, error) {
+	if r == nil {
+		return "", fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+	}
+	def, defined := r.defs[name]
+	if !defined {
+		// 未在本地定义的密钥从 Cloud 密钥库查找
+		def = Definition{Cloud: name}
+	}
+
+	allowed := def.AllowedHosts
+	if def.Cloud != "" && len(allowed) == 0 {
+		r.store.mu.RLock()
+		allowed = r.store.cloud[def.Cloud].AllowedHosts
+		r.store.mu.RUnlock()
+	}
+	if fromParams {
+		if err := checkHosts(name, allowed, hosts); err != nil {
+			return "", err
+		}
+	}
+
+	switch {
+	case def.Env != "":
+		if value, ok := os.LookupEnv(def.Env); ok {
+			return value, nil
+		}
+		return "", fmt.Errorf("%w: %s (environment variable %s is not set)", ErrSecretNotFound, name, def.Env)
+	case def.File != "":
+		data, err := os.ReadFile(def.File)
+		if err != nil {
+			return "", fmt.Errorf("%w: %s: %v", ErrSecretNotFound, name, err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case def.K8s != nil:
+		return r.store.k8sSecret(name, def.K8s)
+	default:
+		r.store.mu.RLock()
+		secret, ok := r.store.cloud[def.Cloud]
+		r.store.mu.RUnlock()
+		if !ok {
+			return "", fmt.Errorf("%w: %s (not in cloud vault)", ErrSecretNotFound, name)
+		}
+		return secret.Value, nil
+	}
+}
+
+// checkHosts 检查目标主机都匹配 allowed_hosts
+func checkHosts(name string, allowed, hosts []string) error {
+	if len(allowed) == 0 {
+		return fmt.Errorf("%w: %s has no allowed_hosts and can only be used in plugin config", ErrSecretNotAllowed, name)
+	}
+	if len(hosts) == 0 {
+		return fmt.Errorf("%w: %s is referenced without a target host or url", ErrSecretNotAllowed, name)
+	}
+	for _, host := range hosts {
+		matched := false
+		for _, pattern := range allowed {
+			if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(host)); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%w: %s cannot be sent to %s", ErrSecretNotAllowed, name, host)
+		}
+	}
+	return nil
+}
+
+// targetHosts 提取对象中 host、url、endpoint 字段指向的主机名
+func targetHosts(obj map[string]interface{}) []string {
+	var hosts []string
+	if host, ok := obj["host"].(string); ok && host != "" {
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		hosts = append(hosts, host)
+	}
+	for _, key := range []string{"url", "endpoint"} {
+		if raw, ok := obj[key].(string); ok && raw != "" {
+			if u, err := url.Parse(raw); err == nil && u.Hostname() != "" {
+				hosts = append(hosts, u.Hostname())
+			}
+		}
+	}
+	return hosts
+}
+
+// remember 记录已解析的值并更新脱敏替换器
+func (s *store) remember(value string) {
+	if len(value) < minRedactLength {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.values[value]; ok {
+		return
+	}
+	s.values[value] = struct{}{}
+	pairs := make([]string, 0, len(s.values)*2)
+	for v := range s.values {
+		pairs = append(pairs, v, common.RedactedValue)
+	}
+	s.replacer = strings.NewReplacer(pairs...)
+}
+
+// k8sSecret 读取 Agent 所在集群的 Secret，结果缓存 k8sCacheTTL
+func (s *store) k8sSecret(name string, ref *K8sSecret) (string, error) {
+	cacheKey := ref.Namespace + "/" + ref.Name + "/" + ref.Key
+	s.mu.RLock()
+	cached, ok := s.k8sCache[cacheKey]
+	client := s.k8s
+	s.mu.RUnlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.value, nil
+	}
+
+	if client == nil {
+		config, err := rest.InClusterConfig()
+		if err != nil {
+			return "", fmt.Errorf("%w: %s: kubernetes secrets require running in a cluster: %v", ErrSecretNotFound, name, err)
+		}
+		if client, err = kubernetes.NewForConfig(config); err != nil {
+			return "", fmt.Errorf("%w: %s: %v", ErrSecretNotFound, name, err)
+		}
+		s.mu.Lock()
+		s.k8s = client
+		s.mu.Unlock()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	secret, err := client.CoreV1().Secrets(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("%w: %s: %v", ErrSecretNotFound, name, err)
+	}
+	data, ok := secret.Data[ref.Key]
+	if !ok {
+		return "", fmt.Errorf("%w: %s (key %s not in secret %s/%s)", ErrSecretNotFound, name, ref.Key, ref.Namespace, ref.Name)
+	}
+
+	s.mu.Lock()
+	s.k8sCache[cacheKey] = cachedValue{value: string(data), expires: time.Now().Add(k8sCacheTTL)}
+	s.mu.Unlock()
+	return string(data), nil
+}
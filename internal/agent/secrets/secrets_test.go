@@ -0,0 +1,133 @@
+package secrets
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloud-agent/internal/common"
+)
+
+func TestResolveConfigSources(t *testing.T) {
+	t.Setenv("TEST_PG_PASSWORD", "env-secret")
+	file := filepath.Join(t.TempDir(), "mongo")
+	os.WriteFile(file, []byte("file-secret\n"), 0600)
+
+	r, err := NewResolver().WithDefinitions(map[string]Definition{
+		"pg":    {Env: "TEST_PG_PASSWORD"},
+		"mongo": {File: file},
+	})
+	if err != nil {
+		t.Fatalf("WithDefinitions failed: %v", err)
+	}
+	r.SetCloudSecrets([]common.SecretValue{{Name: "es", Value: "cloud-secret"}})
+
+	resolved, err := r.ResolveConfig(map[string]interface{}{
+		"connections": []interface{}{
+			map[string]interface{}{"name": "pg", "password": "secret://pg"},
+			map[string]interface{}{"name": "mongo", "password": "secret://mongo"},
+			map[string]interface{}{"name": "es", "password": "secret://es"},
+			map[string]interface{}{"name": "missing", "password": "secret://missing"},
+		},
+	})
+	if !errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("missing secret error = %v, want ErrSecretNotFound", err)
+	}
+	conns := resolved["connections"].([]interface{})
+	for i, want := range []string{"env-secret", "file-secret", "cloud-secret", "secret://missing"} {
+		if got := conns[i].(map[string]interface{})["password"]; got != want {
+			t.Errorf("connection %d password = %v, want %s", i, got, want)
+		}
+	}
+
+	if got := r.Redact("login env-secret / cloud-secret"); got != "login ****** / ******" {
+		t.Errorf("Redact() = %q", got)
+	}
+
+	for name, def := range map[string]Definition{
+		"none": {},
+		"two":  {Env: "A", File: "b"},
+		"k8s":  {K8s: &K8sSecret{Name: "x"}},
+	} {
+		if _, err := r.WithDefinitions(map[string]Definition{name: def}); err == nil {
+			t.Errorf("definition %s should be rejected", name)
+		}
+	}
+}
+
+func TestResolveParamsAllowedHosts(t *testing.T) {
+	t.Setenv("TEST_DB_PASSWORD", "db-secret")
+	r, err := NewResolver().WithDefinitions(map[string]Definition{
+		"db":     {Env: "TEST_DB_PASSWORD", AllowedHosts: []string{"*.db.internal"}},
+		"config": {Env: "TEST_DB_PASSWORD"},
+	})
+	if err != nil {
+		t.Fatalf("WithDefinitions failed: %v", err)
+	}
+
+	params := map[string]interface{}{
+		"target": map[string]interface{}{
+			"host": "pg1.db.internal:5432",
+			"auth": map[string]interface{}{"password": "secret://db"},
+		},
+	}
+	resolved, err := r.ResolveParams(params)
+	if err != nil {
+		t.Fatalf("ResolveParams failed: %v", err)
+	}
+	auth := resolved["target"].(map[string]interface{})["auth"].(map[string]interface{})
+	if auth["password"] != "db-secret" {
+		t.Errorf("password = %v, want db-secret", auth["password"])
+	}
+	if params["target"].(map[string]interface{})["auth"].(map[string]interface{})["password"] != "secret://db" {
+		t.Error("ResolveParams should not modify the original params")
+	}
+
+	tests := []map[string]interface{}{
+		{"target": map[string]interface{}{"url": "https://evil.example.com", "password": "secret://db"}},
+		{"password": "secret://db"},
+		{"target": map[string]interface{}{"host": "pg1.db.internal", "password": "secret://config"}},
+	}
+	for _, p := range tests {
+		if _, err := r.ResolveParams(p); !errors.Is(err, ErrSecretNotAllowed) {
+			t.Errorf("ResolveParams(%v) error = %v, want ErrSecretNotAllowed", p, err)
+		}
+	}
+}
+
+func TestResolveParamsOnlyInConnectionFields(t *testing.T) {
+	t.Setenv("TEST_DB_PASSWORD", "db-secret")
+	r, err := NewResolver().WithDefinitions(map[string]Definition{
+		"db": {Env: "TEST_DB_PASSWORD", AllowedHosts: []string{"*.internal"}},
+	})
+	if err != nil {
+		t.Fatalf("WithDefinitions failed: %v", err)
+	}
+
+	resolved, err := r.ResolveParams(map[string]interface{}{
+		"url":     "https://api.internal/v1",
+		"headers": map[string]interface{}{"Authorization": "secret://db", "Accept": "application/json"},
+	})
+	if err != nil {
+		t.Fatalf("ResolveParams failed: %v", err)
+	}
+	if resolved["headers"].(map[string]interface{})["Authorization"] != "db-secret" {
+		t.Errorf("headers = %v, want resolved Authorization", resolved["headers"])
+	}
+
+	// 顶层的 host 不能授权 Shell 的 env、stdin、args 等字段，请求头中的 Host 也不能代替 url
+	tests := map[string]map[string]interface{}{
+		"shell env":     {"operation": "script", "host": "db.internal", "env": map[string]interface{}{"P": "secret://db"}, "script": "echo $P | base64"},
+		"shell stdin":   {"host": "db.internal", "stdin": "secret://db"},
+		"shell args":    {"url": "https://db.internal", "args": []interface{}{"secret://db"}},
+		"nested object": {"host": "db.internal", "options": map[string]interface{}{"password": "secret://db"}},
+		"host header":   {"url": "https://evil.example.com", "headers": map[string]interface{}{"host": "db.internal", "X-Token": "secret://db"}},
+		"target list":   {"target": []interface{}{map[string]interface{}{"host": "db.internal", "password": "secret://db"}}},
+	}
+	for name, params := range tests {
+		if _, err := r.ResolveParams(params); !errors.Is(err, ErrSecretNotAllowed) {
+			t.Errorf("%s: error = %v, want ErrSecretNotAllowed", name, err)
+		}
+	}
+}
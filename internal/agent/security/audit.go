@@ -3,9 +3,23 @@ package security
 import (
 	"encoding/json"
 	"log"
+	"sync"
 	"time"
 )
 
+var (
+	sinkMu sync.RWMutex
+	sink   func(*AuditLog)
+)
+
+// SetAuditSink 设置审计日志的额外输出（如上报 Cloud），为 nil 时只写本地日志
+// sink 在记录审计日志的 goroutine 中同步调用，不应阻塞
+func SetAuditSink(fn func(*AuditLog)) {
+	sinkMu.Lock()
+	defer sinkMu.Unlock()
+	sink = fn
+}
+
 // AuditLog 审计日志
 type AuditLog struct {
 	Timestamp  time.Time `json:"timestamp"`
@@ -72,4 +86,11 @@ func (l *AuditLogger) writeLog(auditLog *AuditLog) {
 		return
 	}
 	log.Printf("[AUDIT] %s", string(data))
+
+	sinkMu.RLock()
+	fn := sink
+	sinkMu.RUnlock()
+	if fn != nil {
+		fn(auditLog)
+	}
 }
@@ -1,10 +1,15 @@
 package security
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"time"
 
+	"github.com/cloud-agent/internal/common"
 	"gopkg.in/yaml.v3"
 )
 
@@ -18,6 +23,37 @@ type SecurityConfig struct {
 
 	// 禁止的命令模式
 	BlockedPatterns []CommandPattern `yaml:"blocked_patterns"`
+
+	// 按可执行文件配置的参数策略，键为命令名（或完整路径）
+	// 启用白名单时，命令行中每个简单命令都必须匹配这里的策略或 allowed_commands
+	Binaries map[string]BinaryPolicy `yaml:"binaries"`
+
+	// 任务日志和结果上报前的脱敏规则，内置规则默认启用
+	Redaction common.RedactionConfig `yaml:"redaction"`
+
+	// 任务策略，执行 Cloud 下发的任务前再次评估，格式与 Cloud 的 -policy-config 相同
+	Policy common.PolicyConfig `yaml:"policy"`
+
+	// Shell 命令的执行配置，键为名称，任务通过 params.profile 选择
+	ShellProfiles map[string]ShellProfile `yaml:"shell_profiles"`
+
+	// 任务未指定 params.profile 时使用的执行配置，为空时命令以 Agent 进程的权限运行
+	DefaultShellProfile string `yaml:"default_shell_profile"`
+
+	// 脚本模式（params.operation 为 script）允许的解释器，可选 sh、bash、python3、perl
+	// 为空时：未启用白名单则允许全部，启用白名单时不允许脚本模式
+	ScriptInterpreters []string `yaml:"script_interpreters"`
+
+	// 后台任务（Shell 任务 params.detach 为 true），默认不允许
+	Jobs JobsConfig `yaml:"jobs"`
+}
+
+// JobsConfig 后台任务配置
+// 后台任务由独立的监护进程运行，不受 Shell 命令 30 分钟超时限制，Agent 重启后继续运行
+type JobsConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	MaxDuration time.Duration `yaml:"max_duration"` // 运行时长上限，超过后结束命令，0 表示不限制
+	MaxRunning  int           `yaml:"max_running"`  // 同时运行的后台任务数上限，0 表示不限制
 }
 
 // LoadSecurityConfig 从文件加载安全配置
@@ -50,3 +86,27 @@ func LoadSecurityConfig(path string) (*SecurityConfig, error) {
 
 	return &config, nil
 }
+
+// ParseSecurityConfig 解析并校验安全配置内容，未知字段和无效的正则表达式返回错误
+// 用于校验 Cloud 下发的配置，格式与配置文件相同
+func ParseSecurityConfig(data []byte) (*SecurityConfig, error) {
+	var config SecurityConfig
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse security config: %w", err)
+	}
+	if _, err := NewCommandValidator(&config); err != nil {
+		return nil, err
+	}
+	if _, err := common.NewRedactor(&config.Redaction); err != nil {
+		return nil, err
+	}
+	if _, err := common.NewPolicyEngine(&config.Policy); err != nil {
+		return nil, err
+	}
+	if err := ValidateShellConfig(&config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
@@ -0,0 +1,308 @@
+package security
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// BinaryPolicy 按可执行文件配置的参数策略
+// 命令行中每个调用该可执行文件的简单命令（包括管道、命令替换和 sh -c 中的命令）都按此策略校验
+type BinaryPolicy struct {
+	Description string `yaml:"description"`
+	// 允许的子命令（第一个位置参数），如 kubectl 的 get、describe，为空时不限制
+	Subcommands []string `yaml:"subcommands"`
+	// 允许的选项，为空时不限制；组合的短选项（-la）逐个检查
+	AllowedFlags []string `yaml:"allowed_flags"`
+	// 禁止的选项，优先于 allowed_flags
+	DeniedFlags []string `yaml:"denied_flags"`
+	// 带值的选项，其后的参数作为选项值不做路径检查，同时视为允许的选项
+	ValueFlags []string `yaml:"value_flags"`
+	// 位置参数必须是这些路径或其下的绝对路径，为空时不限制
+	AllowedPaths []string `yaml:"allowed_paths"`
+	// 子命令之后前 N 个位置参数不做路径检查，如 grep 的匹配模式
+	NonPathArgs int `yaml:"non_path_args"`
+}
+
+// restricted 策略是否限制参数，限制参数时无法在执行前确定的参数一律拒绝
+func (p *BinaryPolicy) restricted() bool {
+	return len(p.Subcommands) > 0 || len(p.AllowedFlags) > 0 || len(p.AllowedPaths) > 0
+}
+
+// validate 校验策略配置
+func (p *BinaryPolicy) validate(name string) error {
+	if name == "" || strings.ContainsAny(name, " \t") {
+		return fmt.Errorf("invalid binary name %q", name)
+	}
+	for _, prefix := range p.AllowedPaths {
+		if !path.IsAbs(prefix) {
+			return fmt.Errorf("binary %q: allowed path %q must be absolute", name, prefix)
+		}
+	}
+	for _, flags := range [][]string{p.AllowedFlags, p.DeniedFlags, p.ValueFlags} {
+		for _, flag := range flags {
+			if len(flag) < 2 || flag[0] != '-' {
+				return fmt.Errorf("binary %q: invalid flag %q", name, flag)
+			}
+		}
+	}
+	if p.NonPathArgs < 0 {
+		return fmt.Errorf("binary %q: non_path_args must not be negative", name)
+	}
+	return nil
+}
+
+// check 校验简单命令的参数，返回拒绝原因，允许时返回空字符串
+func (p *BinaryPolicy) check(cmd *ShellCommand) string {
+	if cmd.OpenArgs && p.restricted() {
+		return fmt.Sprintf("arguments are appended at runtime by %s and cannot be checked", cmd.Via)
+	}
+
+	name := cmd.Argv[0]
+	positional := 0
+	endOfOptions := false
+	for i := 1; i < len(cmd.Argv); i++ {
+		arg := cmd.Argv[i]
+		if cmd.Dynamic[i] {
+			if p.restricted() {
+				return fmt.Sprintf("argument %q is expanded at runtime and cannot be checked", arg)
+			}
+			continue
+		}
+		if !endOfOptions && arg == "--" {
+			endOfOptions = true
+			continue
+		}
+		if !endOfOptions && len(arg) > 1 && arg[0] == '-' {
+			takesValue, reason := p.checkFlag(name, arg)
+			if reason != "" {
+				return reason
+			}
+			if takesValue {
+				i++
+			}
+			continue
+		}
+
+		if positional == 0 && len(p.Subcommands) > 0 {
+			if !slices.Contains(p.Subcommands, arg) {
+				return fmt.Sprintf("subcommand %q is not allowed for %s (allowed: %s)", arg, name, strings.Join(p.Subcommands, ", "))
+			}
+			positional++
+			continue
+		}
+		index := positional
+		if len(p.Subcommands) > 0 {
+			index--
+		}
+		positional++
+		if index >= p.NonPathArgs {
+			if reason := p.checkPath(arg); reason != "" {
+				return reason
+			}
+		}
+	}
+	if len(p.Subcommands) > 0 && positional == 0 {
+		return fmt.Sprintf("%s requires one of the subcommands: %s", name, strings.Join(p.Subcommands, ", "))
+	}
+
+	// 输入重定向读取的文件同样受路径限制
+	for _, r := range cmd.Redirects {
+		if r.Op != "<" && r.Op != "<>" {
+			continue
+		}
+		if r.Dynamic && len(p.AllowedPaths) > 0 {
+			return fmt.Sprintf("redirection target %q is expanded at runtime and cannot be checked", r.Target)
+		}
+		if reason := p.checkPath(r.Target); reason != "" {
+			return reason
+		}
+	}
+	return ""
+}
+
+// checkFlag 校验选项，返回该选项是否带有单独的值参数
+func (p *BinaryPolicy) checkFlag(name, arg string) (bool, string) {
+	flag, inlineValue := arg, false
+	if strings.HasPrefix(arg, "--") {
+		if i := strings.IndexByte(arg, '='); i > 0 {
+			flag, inlineValue = arg[:i], true
+		}
+	}
+	if slices.Contains(p.DeniedFlags, flag) {
+		return false, fmt.Sprintf("flag %s is not allowed for %s", flag, name)
+	}
+	if slices.Contains(p.AllowedFlags, flag) || slices.Contains(p.ValueFlags, flag) {
+		return !inlineValue && slices.Contains(p.ValueFlags, flag), ""
+	}
+	if strings.HasPrefix(arg, "--") || len(arg) == 2 {
+		if len(p.AllowedFlags) > 0 {
+			return false, fmt.Sprintf("flag %s is not allowed for %s", flag, name)
+		}
+		return slices.Contains(p.ValueFlags, flag), ""
+	}
+
+	// 组合的短选项逐个检查，带值的短选项之后的字符是它的值
+	for j := 1; j < len(arg); j++ {
+		short := "-" + arg[j:j+1]
+		if slices.Contains(p.DeniedFlags, short) {
+			return false, fmt.Sprintf("flag %s (in %s) is not allowed for %s", short, arg, name)
+		}
+		if slices.Contains(p.ValueFlags, short) {
+			return j == len(arg)-1, ""
+		}
+		if len(p.AllowedFlags) > 0 && !slices.Contains(p.AllowedFlags, short) {
+			return false, fmt.Sprintf("flag %s (in %s) is not allowed for %s", short, arg, name)
+		}
+	}
+	return false, ""
+}
+
+// checkPath 校验路径参数，返回拒绝原因
+func (p *BinaryPolicy) checkPath(arg string) string {
+	if len(p.AllowedPaths) == 0 {
+		return ""
+	}
+	if !path.IsAbs(arg) {
+		return fmt.Sprintf("path %q must be absolute", arg)
+	}
+	cleaned := path.Clean(arg)
+	for _, prefix := range p.AllowedPaths {
+		prefix = path.Clean(prefix)
+		if cleaned == prefix || strings.HasPrefix(cleaned, strings.TrimSuffix(prefix, "/")+"/") {
+			return ""
+		}
+	}
+	return fmt.Sprintf("path %s is outside the allowed paths (%s)", arg, strings.Join(p.AllowedPaths, ", "))
+}
+
+// Decision 命令的校验结果，说明允许或拒绝的依据
+type Decision struct {
+	Command  string            `json:"command"`
+	Allowed  bool              `json:"allowed"`
+	Reason   string            `json:"reason,omitempty"`   // 拒绝原因，允许时为空
+	Commands []CommandDecision `json:"commands,omitempty"` // 解析出的每个简单命令的校验结果
+}
+
+// CommandDecision 简单命令的校验结果
+type CommandDecision struct {
+	Argv    []string `json:"argv"`
+	Via     string   `json:"via,omitempty"`
+	Allowed bool     `json:"allowed"`
+	// 决定结果的规则：builtin、blocked_patterns[i]、redirect、assignment、binaries.<name>、allowed_commands[i]、whitelist、whitelist_disabled
+	Rule   string `json:"rule"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// protectedPaths 递归删除、修改权限时受内置规则保护的目录
+var protectedPaths = []string{
+	"/", "/bin", "/boot", "/dev", "/etc", "/home", "/lib", "/lib32", "/lib64",
+	"/opt", "/proc", "/root", "/sbin", "/srv", "/sys", "/usr", "/var",
+}
+
+// safeDevices 允许写入的设备文件
+var safeDevices = []string{"/dev/null", "/dev/stdout", "/dev/stderr", "/dev/tty"}
+
+// readableDevices 无法解析的命令中允许出现的只读设备文件
+var readableDevices = []string{"/dev/zero", "/dev/random", "/dev/urandom", "/dev/stdin"}
+
+// builtinNamePattern 原文中作为单词出现的、受内置规则检查的可执行文件名
+var builtinNamePattern = regexp.MustCompile(`(?:^|[^\w.-])(rm|chmod|chown|chgrp|dd|mkfs(?:\.\w+)?)(?:$|[^\w.-])`)
+
+// devicePattern 原文中的设备文件路径
+var devicePattern = regexp.MustCompile(`/dev/[\w./-]*`)
+
+// unparsedDeny 无法解析的命令不能逐个校验内置规则，原文中出现受检查的可执行文件或设备文件时拒绝，返回拒绝原因
+func unparsedDeny(cmd string) string {
+	if m := builtinNamePattern.FindStringSubmatch(cmd); m != nil {
+		return fmt.Sprintf("it contains %s, which is checked by builtin rules", m[1])
+	}
+	for _, dev := range devicePattern.FindAllString(cmd, -1) {
+		dev = path.Clean(dev)
+		if !slices.Contains(safeDevices, dev) && !slices.Contains(readableDevices, dev) {
+			return fmt.Sprintf("it contains device %s", dev)
+		}
+	}
+	return ""
+}
+
+// builtinDeny 内置规则，无论配置如何都拒绝破坏系统的命令，返回拒绝原因
+func builtinDeny(cmd *ShellCommand) string {
+	for _, r := range cmd.Redirects {
+		if r.isOutput() && strings.HasPrefix(path.Clean(r.Target), "/dev/") && !slices.Contains(safeDevices, path.Clean(r.Target)) {
+			return fmt.Sprintf("writing to device %s is never allowed", r.Target)
+		}
+	}
+	if len(cmd.Argv) == 0 {
+		return ""
+	}
+
+	name := path.Base(cmd.Argv[0])
+	switch {
+	case name == "mkfs" || strings.HasPrefix(name, "mkfs."):
+		return "creating file systems is never allowed"
+	case name == "dd":
+		for _, arg := range cmd.Argv[1:] {
+			if target, ok := strings.CutPrefix(arg, "of="); ok && strings.HasPrefix(path.Clean(target), "/dev/") && !slices.Contains(safeDevices, path.Clean(target)) {
+				return fmt.Sprintf("writing to device %s with dd is never allowed", target)
+			}
+		}
+	case name == "rm" || name == "chmod" || name == "chown" || name == "chgrp":
+		recursive, targets := parseRecursive(cmd, name == "rm")
+		if !recursive {
+			return ""
+		}
+		for _, target := range targets {
+			if target == "--no-preserve-root" {
+				return fmt.Sprintf("%s --no-preserve-root is never allowed", name)
+			}
+			if isProtectedPath(target) && name == "rm" {
+				return fmt.Sprintf("recursive removal of %s is never allowed", target)
+			}
+			if isProtectedPath(target) {
+				return fmt.Sprintf("recursive %s of %s is never allowed", name, target)
+			}
+		}
+	}
+	return ""
+}
+
+// parseRecursive 返回命令是否带递归选项以及执行前可确定的目标参数
+func parseRecursive(cmd *ShellCommand, lowerR bool) (bool, []string) {
+	recursive := false
+	var targets []string
+	endOfOptions := false
+	for i := 1; i < len(cmd.Argv); i++ {
+		arg := cmd.Argv[i]
+		switch {
+		case cmd.Dynamic[i]:
+		case endOfOptions:
+			targets = append(targets, arg)
+		case arg == "--":
+			endOfOptions = true
+		case arg == "--recursive":
+			recursive = true
+		case arg == "--no-preserve-root":
+			targets = append(targets, arg)
+		case strings.HasPrefix(arg, "--"):
+		case len(arg) > 1 && arg[0] == '-':
+			if strings.Contains(arg, "R") || (lowerR && strings.Contains(arg, "r")) {
+				recursive = true
+			}
+		default:
+			targets = append(targets, arg)
+		}
+	}
+	return recursive, targets
+}
+
+// isProtectedPath 路径是否为受保护的目录，/* 形式的通配视为目录本身
+func isProtectedPath(target string) bool {
+	if !path.IsAbs(target) {
+		return false
+	}
+	target = strings.TrimRight(target, "*")
+	return slices.Contains(protectedPaths, path.Clean(target))
+}
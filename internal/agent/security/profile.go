@@ -0,0 +1,184 @@
+package security
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/cloud-agent/internal/common"
+)
+
+// DefaultCgroupParent 执行配置未指定 cgroup_parent 时任务 cgroup 的父目录
+const DefaultCgroupParent = "/sys/fs/cgroup/cloud-agent"
+
+// defaultPath 执行配置未继承或设置 PATH 时命令使用的 PATH
+const defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// ShellProfile Shell 命令的执行配置（沙箱），任务通过 params.profile 选择
+type ShellProfile struct {
+	Description string `yaml:"description"`
+
+	// 运行命令的用户和组，未设置时使用 Agent 进程的用户（切换用户需要 Agent 以 root 运行）
+	UID *uint32 `yaml:"uid"`
+	GID *uint32 `yaml:"gid"`
+	// 附加组，切换用户时未设置则清空附加组
+	Groups []uint32 `yaml:"groups"`
+
+	// cgroup v2 资源限制，任一项大于 0 时为每个任务创建 cgroup，0 表示不限制
+	CPU      float64 `yaml:"cpu"`       // CPU 核数，如 0.5
+	MemoryMB int64   `yaml:"memory_mb"` // 内存上限（MiB）
+	PidsMax  int64   `yaml:"pids_max"`  // 进程数上限
+	// 任务 cgroup 的父目录，需要是 cgroup v2 中 Agent 有写权限的目录，默认 /sys/fs/cgroup/cloud-agent
+	CgroupParent string `yaml:"cgroup_parent"`
+
+	// 命令的工作目录；chroot 为 true 时以该目录为根目录（目录中需要有 sh 和命令用到的文件）
+	WorkDir string `yaml:"work_dir"`
+	Chroot  bool   `yaml:"chroot"`
+
+	// 命令不继承 Agent 的环境变量，只保留 inherit_env 中的变量（支持 * 通配）并设置 env 中的变量
+	InheritEnv []string          `yaml:"inherit_env"`
+	Env        map[string]string `yaml:"env"`
+
+	// 结果和日志中保留的输出上限（字节），超出部分丢弃，0 表示使用 DefaultMaxOutputBytes
+	MaxOutputBytes int64 `yaml:"max_output_bytes"`
+
+	// 禁止命令通过 setuid 程序（如 sudo）或文件 capabilities 获得新权限
+	NoNewPrivileges bool `yaml:"no_new_privileges"`
+}
+
+// DefaultMaxOutputBytes 没有使用执行配置或执行配置未设置 max_output_bytes 时保留的输出上限（10 MiB）
+const DefaultMaxOutputBytes int64 = 10 << 20
+
+// OutputLimit 返回命令输出的保留上限，p 为 nil 时使用 DefaultMaxOutputBytes
+func (p *ShellProfile) OutputLimit() int64 {
+	if p == nil || p.MaxOutputBytes <= 0 {
+		return DefaultMaxOutputBytes
+	}
+	return p.MaxOutputBytes
+}
+
+// validate 校验执行配置
+func (p *ShellProfile) validate(name string) error {
+	if name == "" || name == common.PolicyProfileNone || strings.ContainsAny(name, " \t/") {
+		return fmt.Errorf("invalid shell profile name %q", name)
+	}
+	if p.CPU < 0 || p.MemoryMB < 0 || p.PidsMax < 0 || p.MaxOutputBytes < 0 {
+		return fmt.Errorf("shell profile %q: limits must not be negative", name)
+	}
+	if p.CgroupParent != "" && !path.IsAbs(p.CgroupParent) {
+		return fmt.Errorf("shell profile %q: cgroup_parent %q must be absolute", name, p.CgroupParent)
+	}
+	if p.WorkDir != "" && !path.IsAbs(p.WorkDir) {
+		return fmt.Errorf("shell profile %q: work_dir %q must be absolute", name, p.WorkDir)
+	}
+	if p.Chroot && p.WorkDir == "" {
+		return fmt.Errorf("shell profile %q: chroot requires work_dir", name)
+	}
+	for _, pattern := range p.InheritEnv {
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return fmt.Errorf("shell profile %q: invalid inherit_env pattern %q", name, pattern)
+		}
+	}
+	for key := range p.Env {
+		if key == "" || strings.ContainsAny(key, "=\x00") {
+			return fmt.Errorf("shell profile %q: invalid env name %q", name, key)
+		}
+	}
+	return nil
+}
+
+// NeedsCgroup 是否需要为任务创建 cgroup
+func (p *ShellProfile) NeedsCgroup() bool {
+	return p.CPU > 0 || p.MemoryMB > 0 || p.PidsMax > 0
+}
+
+// SwitchesUser 是否切换运行命令的用户或组
+func (p *ShellProfile) SwitchesUser() bool {
+	return p.UID != nil || p.GID != nil || p.Groups != nil
+}
+
+// Environ 根据 Agent 的环境变量 parent 返回命令的环境变量
+func (p *ShellProfile) Environ(parent []string) []string {
+	var env []string
+	for _, kv := range parent {
+		key, _, _ := strings.Cut(kv, "=")
+		if _, overridden := p.Env[key]; overridden {
+			continue
+		}
+		if slices.ContainsFunc(p.InheritEnv, func(pattern string) bool {
+			ok, _ := path.Match(pattern, key)
+			return ok
+		}) {
+			env = append(env, kv)
+		}
+	}
+	for key, value := range p.Env {
+		env = append(env, key+"="+value)
+	}
+	if !slices.ContainsFunc(env, func(kv string) bool { return strings.HasPrefix(kv, "PATH=") }) {
+		env = append(env, "PATH="+defaultPath)
+	}
+	slices.Sort(env)
+	return env
+}
+
+// ScriptInterpreters 脚本模式支持的解释器
+var ScriptInterpreters = []string{"sh", "bash", "python3", "perl"}
+
+// ValidateShellConfig 校验安全配置中的执行配置、脚本解释器和后台任务配置，default_shell_profile 必须是已定义的执行配置
+func ValidateShellConfig(config *SecurityConfig) error {
+	for _, name := range config.ScriptInterpreters {
+		if !slices.Contains(ScriptInterpreters, name) {
+			return fmt.Errorf("unsupported script interpreter %q", name)
+		}
+	}
+	if config.Jobs.MaxDuration < 0 || config.Jobs.MaxRunning < 0 {
+		return fmt.Errorf("jobs: max_duration and max_running must not be negative")
+	}
+	for name, profile := range config.ShellProfiles {
+		if err := profile.validate(name); err != nil {
+			return err
+		}
+	}
+	if config.DefaultShellProfile != "" {
+		if _, ok := config.ShellProfiles[config.DefaultShellProfile]; !ok {
+			return fmt.Errorf("default_shell_profile %q is not defined in shell_profiles", config.DefaultShellProfile)
+		}
+	}
+	return nil
+}
+
+// ShellProfile 返回任务选择的执行配置，name 为空时使用 default_shell_profile
+// 没有选择执行配置时返回 nil，执行配置不存在时返回错误
+func (c *SecurityConfig) ShellProfile(name string) (string, *ShellProfile, error) {
+	if name == "" {
+		name = c.DefaultShellProfile
+	}
+	if name == "" {
+		return "", nil, nil
+	}
+	profile, ok := c.ShellProfiles[name]
+	if !ok {
+		return "", nil, fmt.Errorf("shell profile %q is not defined", name)
+	}
+	return name, &profile, nil
+}
+
+// AllowScriptInterpreter 检查脚本模式能否使用解释器
+// 脚本内容无法按白名单逐条校验，启用白名单时只允许 script_interpreters 中列出的解释器
+func (c *SecurityConfig) AllowScriptInterpreter(name string) error {
+	if !slices.Contains(ScriptInterpreters, name) {
+		return fmt.Errorf("unsupported script interpreter %q (available: %s)", name, strings.Join(ScriptInterpreters, ", "))
+	}
+	if len(c.ScriptInterpreters) > 0 {
+		if !slices.Contains(c.ScriptInterpreters, name) {
+			return fmt.Errorf("script interpreter %q is not in script_interpreters", name)
+		}
+		return nil
+	}
+	if c.CommandWhitelistEnabled {
+		return fmt.Errorf("script mode is disabled while the command whitelist is enabled, list allowed interpreters in script_interpreters")
+	}
+	return nil
+}
@@ -0,0 +1,607 @@
+package security
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// maxShellDepth 命令替换、子 shell、sh -c 等嵌套的最大层数
+const maxShellDepth = 16
+
+// ShellCommand 从命令行中解析出的简单命令
+type ShellCommand struct {
+	Argv        []string   // 去掉引号和转义后的参数，第一个为可执行文件
+	Dynamic     []bool     // 与 Argv 对应，参数包含变量、命令替换、~ 或花括号展开，实际值在执行时才确定
+	Assignments []string   // 命令前的环境变量赋值，如 FOO=bar
+	Redirects   []Redirect // 重定向
+	Via         string     // 包装命令，如 sh -c、xargs、find -exec；为空表示直接出现在命令行中
+	OpenArgs    bool       // 执行时还会追加参数（xargs）
+}
+
+// Redirect 重定向
+type Redirect struct {
+	Op      string // <、>、>>、&>、>& 等
+	Target  string
+	Dynamic bool
+}
+
+// String 返回以空格连接的参数，用于匹配正则规则和错误信息
+func (c *ShellCommand) String() string {
+	return strings.Join(c.Argv, " ")
+}
+
+// isOutput 重定向是否写入文件
+func (r *Redirect) isOutput() bool {
+	switch r.Op {
+	case ">", ">>", ">|", "&>", "&>>", "<>":
+		return true
+	case ">&":
+		// >&N 复制文件描述符，>&file 写入文件
+		return !isFD(r.Target)
+	}
+	return false
+}
+
+// ParseShell 把命令行拆分为简单命令
+// 管道、命令列表、子 shell、命令替换、进程替换以及 sh -c、eval、xargs、find -exec 等执行的命令都会展开为独立的简单命令
+// 无法可靠解析的语法（for、case、函数定义、here-doc 等）返回错误
+func ParseShell(cmd string) ([]*ShellCommand, error) {
+	var out []*ShellCommand
+	p := &shellParser{src: cmd, out: &out}
+	if err := p.parseList(false); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// shellParser 递归下降的 shell 命令行解析器
+type shellParser struct {
+	src   string
+	pos   int
+	depth int
+	out   *[]*ShellCommand
+}
+
+// word 解析出的单词
+type word struct {
+	value   string
+	raw     string
+	dynamic bool
+	quoted  bool
+}
+
+// transparentKeywords 不影响执行哪些命令的保留字，跳过后继续解析其后的命令
+var transparentKeywords = []string{"if", "then", "else", "elif", "fi", "while", "until", "do", "done", "!", "{", "}"}
+
+// unsupportedKeywords 无法可靠解析的保留字
+var unsupportedKeywords = []string{"for", "case", "esac", "select", "function", "coproc", "[[", "]]", "((", "in"}
+
+// assignmentPattern 命令前的环境变量赋值
+var assignmentPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*\+?=`)
+
+// parseList 解析命令列表，nested 为 true 时在匹配的 ) 处结束
+func (p *shellParser) parseList(nested bool) error {
+	if p.depth > maxShellDepth {
+		return fmt.Errorf("command nested too deeply")
+	}
+	p.depth++
+	defer func() { p.depth-- }()
+
+	cur := &ShellCommand{}
+	flush := func() error {
+		err := p.emit(cur, "")
+		cur = &ShellCommand{}
+		return err
+	}
+
+	for {
+		p.skipBlanks()
+		if p.pos >= len(p.src) {
+			if nested {
+				return fmt.Errorf("unterminated subshell or command substitution")
+			}
+			return flush()
+		}
+
+		c := p.src[p.pos]
+		switch {
+		case c == '#':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		case c == '\n' || c == ';':
+			p.pos++
+			if err := flush(); err != nil {
+				return err
+			}
+		case c == '|':
+			p.pos++
+			if p.peek('|') || p.peek('&') {
+				p.pos++
+			}
+			if err := flush(); err != nil {
+				return err
+			}
+		case c == '&':
+			if p.next() == '>' {
+				if err := p.parseRedirect(cur); err != nil {
+					return err
+				}
+				continue
+			}
+			p.pos++
+			if p.peek('&') {
+				p.pos++
+			}
+			if err := flush(); err != nil {
+				return err
+			}
+		case c == ')':
+			p.pos++
+			if err := flush(); err != nil {
+				return err
+			}
+			if nested {
+				return nil
+			}
+		case c == '(':
+			if len(cur.Argv) > 0 {
+				if p.next() == ')' {
+					return fmt.Errorf("unsupported shell syntax: function definition")
+				}
+				return fmt.Errorf("unexpected ( after %q", cur.String())
+			}
+			if p.next() == '(' {
+				return fmt.Errorf("unsupported shell syntax: arithmetic command")
+			}
+			// 子 shell 中的命令与外层命令一样校验
+			p.pos++
+			if err := p.parseList(true); err != nil {
+				return err
+			}
+		case (c == '<' || c == '>') && p.next() == '(':
+			if err := p.parseProcessSubstitution(cur); err != nil {
+				return err
+			}
+		case c == '<' || c == '>' || p.fdRedirect():
+			if err := p.parseRedirect(cur); err != nil {
+				return err
+			}
+		default:
+			w, err := p.parseWord()
+			if err != nil {
+				return err
+			}
+			if len(cur.Argv) == 0 && !w.quoted {
+				if slices.Contains(unsupportedKeywords, w.value) {
+					return fmt.Errorf("unsupported shell syntax: %s", w.value)
+				}
+				if slices.Contains(transparentKeywords, w.value) {
+					continue
+				}
+				if assignmentPattern.MatchString(w.raw) {
+					cur.Assignments = append(cur.Assignments, w.value)
+					continue
+				}
+			}
+			cur.Argv = append(cur.Argv, w.value)
+			cur.Dynamic = append(cur.Dynamic, w.dynamic)
+		}
+	}
+}
+
+// emit 输出简单命令，并展开其中执行的其他命令
+func (p *shellParser) emit(cmd *ShellCommand, via string) error {
+	if len(cmd.Argv) == 0 && len(cmd.Redirects) == 0 && len(cmd.Assignments) == 0 {
+		return nil
+	}
+	if via != "" {
+		cmd.Via = via
+	}
+	*p.out = append(*p.out, cmd)
+	if len(cmd.Argv) == 0 || cmd.Dynamic[0] {
+		return nil
+	}
+	return p.expandNested(cmd)
+}
+
+// shells 支持 -c 参数的 shell
+var shells = []string{"sh", "bash", "dash", "zsh", "ksh", "ash", "busybox"}
+
+// wrapperValueFlags 执行其他命令的包装命令及其带值的选项
+var wrapperValueFlags = map[string][]string{
+	"command": nil,
+	"builtin": nil,
+	"exec":    {"-a"},
+	"nohup":   nil,
+	"setsid":  nil,
+	"time":    {"-f", "-o", "--format", "--output"},
+	"nice":    {"-n", "--adjustment"},
+	"ionice":  {"-c", "-n", "-p", "--class", "--classdata"},
+	"stdbuf":  {"-i", "-o", "-e", "--input", "--output", "--error"},
+	"env":     {"-u", "-C", "-S", "--unset", "--chdir", "--split-string"},
+	"timeout": {"-s", "-k", "--signal", "--kill-after"},
+	"sudo":    {"-u", "-g", "-h", "-p", "-C", "-U", "-r", "-t", "--user", "--group", "--host", "--prompt"},
+	"doas":    {"-u", "-C"},
+	"chroot":  {"--userspec", "--groups"},
+	"xargs":   {"-I", "-L", "-n", "-P", "-d", "-E", "-s", "-a", "--max-args", "--max-procs", "--delimiter", "--arg-file", "--replace"},
+}
+
+// wrapperPositionals 包装命令在被执行的命令之前的位置参数个数（timeout 的时长、chroot 的根目录）
+var wrapperPositionals = map[string]int{"timeout": 1, "chroot": 1}
+
+// expandNested 展开 sh -c、eval、watch、包装命令和 find -exec 执行的命令
+func (p *shellParser) expandNested(cmd *ShellCommand) error {
+	name := path.Base(cmd.Argv[0])
+	args, dynamic := cmd.Argv[1:], cmd.Dynamic[1:]
+
+	switch {
+	case slices.Contains(shells, name):
+		script, found, err := shellScriptArg(args, dynamic)
+		if err != nil || !found {
+			return err
+		}
+		return p.parseScript(script, name+" -c")
+	case name == "eval" || name == "watch":
+		// watch 把参数连接后交给 sh -c 执行
+		var parts []string
+		for i := 0; i < len(args); i++ {
+			a := args[i]
+			if name == "watch" && len(parts) == 0 && strings.HasPrefix(a, "-") {
+				if a == "-n" || a == "--interval" {
+					i++
+				}
+				continue
+			}
+			if dynamic[i] {
+				return fmt.Errorf("%s argument %q is expanded at runtime", name, a)
+			}
+			parts = append(parts, a)
+		}
+		if len(parts) == 0 {
+			return nil
+		}
+		return p.parseScript(strings.Join(parts, " "), name)
+	case name == "find":
+		return p.expandFindExec(args, dynamic)
+	}
+
+	valueFlags, ok := wrapperValueFlags[name]
+	if !ok {
+		return nil
+	}
+	skip := wrapperPositionals[name]
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == "--" {
+			continue
+		}
+		if strings.HasPrefix(a, "-") && len(a) > 1 {
+			if slices.Contains(valueFlags, a) {
+				i++
+			}
+			continue
+		}
+		if name == "env" && assignmentPattern.MatchString(a) {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		wrapped := &ShellCommand{Argv: args[i:], Dynamic: dynamic[i:], OpenArgs: name == "xargs"}
+		return p.emit(wrapped, name)
+	}
+	return nil
+}
+
+// expandFindExec 展开 find -exec、-execdir、-ok、-okdir 执行的命令，{} 视为执行时确定的参数
+func (p *shellParser) expandFindExec(args []string, dynamic []bool) error {
+	for i := 0; i < len(args); i++ {
+		if !slices.Contains([]string{"-exec", "-execdir", "-ok", "-okdir"}, args[i]) {
+			continue
+		}
+		wrapped := &ShellCommand{}
+		for i++; i < len(args) && args[i] != ";" && args[i] != "+"; i++ {
+			wrapped.Argv = append(wrapped.Argv, args[i])
+			wrapped.Dynamic = append(wrapped.Dynamic, dynamic[i] || strings.Contains(args[i], "{}"))
+		}
+		if err := p.emit(wrapped, "find -exec"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shellScriptArg 查找 sh -c 的脚本参数
+// 脚本之前可以有长选项（如 --norc）和带参数的选项（-o、+O、--rcfile 等），-- 或 - 结束选项
+func shellScriptArg(args []string, dynamic []bool) (string, bool, error) {
+	hasC := false
+	i := 0
+	for ; i < len(args); i++ {
+		a := args[i]
+		if a == "--" || a == "-" {
+			i++
+			break
+		}
+		if strings.HasPrefix(a, "--") {
+			if a == "--rcfile" || a == "--init-file" {
+				i++
+			}
+			continue
+		}
+		if len(a) < 2 || (a[0] != '-' && a[0] != '+') {
+			break
+		}
+		if a[0] == '-' && strings.Contains(a[1:], "c") {
+			hasC = true
+		}
+		// -o、-O、+o、+O 的选项名是下一个参数
+		if strings.ContainsAny(a[1:], "oO") {
+			i++
+		}
+	}
+	if !hasC || i >= len(args) {
+		return "", false, nil
+	}
+	if dynamic[i] {
+		return "", false, fmt.Errorf("shell script %q is expanded at runtime", args[i])
+	}
+	return args[i], true, nil
+}
+
+// parseScript 解析嵌套执行的脚本，其中的命令标记为由 via 执行
+func (p *shellParser) parseScript(script, via string) error {
+	var nested []*ShellCommand
+	sub := &shellParser{src: script, depth: p.depth + 1, out: &nested}
+	if err := sub.parseList(false); err != nil {
+		return err
+	}
+	for _, c := range nested {
+		if c.Via == "" {
+			c.Via = via
+		}
+	}
+	*p.out = append(*p.out, nested...)
+	return nil
+}
+
+// parseProcessSubstitution 解析 <(...) 和 >(...)，其中的命令单独校验，结果作为执行时确定的参数
+func (p *shellParser) parseProcessSubstitution(cur *ShellCommand) error {
+	p.pos += 2
+	if err := p.parseList(true); err != nil {
+		return err
+	}
+	cur.Argv = append(cur.Argv, "/dev/fd/N")
+	cur.Dynamic = append(cur.Dynamic, true)
+	return nil
+}
+
+// parseRedirect 解析重定向及其目标
+func (p *shellParser) parseRedirect(cur *ShellCommand) error {
+	start := p.pos
+	for p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+		p.pos++
+	}
+	opStart := p.pos
+	for _, op := range []string{"&>>", "<<<", "&>", ">>", ">|", ">&", "<&", "<>", "<<", "<", ">"} {
+		if strings.HasPrefix(p.src[p.pos:], op) {
+			p.pos += len(op)
+			break
+		}
+	}
+	op := p.src[opStart:p.pos]
+	if op == "<<" {
+		return fmt.Errorf("unsupported shell syntax: here-document")
+	}
+	if op == "" {
+		return fmt.Errorf("invalid redirection at %q", p.src[start:])
+	}
+
+	p.skipBlanks()
+	if p.pos >= len(p.src) || strings.ContainsRune(";|&()<>\n", rune(p.src[p.pos])) {
+		return fmt.Errorf("missing redirection target after %s", op)
+	}
+	w, err := p.parseWord()
+	if err != nil {
+		return err
+	}
+	if op == "<&" || (op == ">&" && isFD(w.value)) {
+		return nil
+	}
+	cur.Redirects = append(cur.Redirects, Redirect{Op: op, Target: w.value, Dynamic: w.dynamic})
+	return nil
+}
+
+// fdRedirect 当前位置是否为带文件描述符的重定向，如 2>&1
+func (p *shellParser) fdRedirect() bool {
+	i := p.pos
+	for i < len(p.src) && p.src[i] >= '0' && p.src[i] <= '9' {
+		i++
+	}
+	return i > p.pos && i < len(p.src) && (p.src[i] == '<' || p.src[i] == '>')
+}
+
+// parseWord 解析一个单词，处理引号、转义和展开
+func (p *shellParser) parseWord() (*word, error) {
+	w := &word{}
+	var b strings.Builder
+	start := p.pos
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == ' ' || c == '\t' || strings.IndexByte(";|&()<>\n", c) >= 0:
+			w.value, w.raw = b.String(), p.src[start:p.pos]
+			return w, nil
+		case c == '\\':
+			p.pos++
+			if p.pos < len(p.src) {
+				if p.src[p.pos] != '\n' {
+					b.WriteByte(p.src[p.pos])
+				}
+				p.pos++
+			}
+		case c == '\'':
+			w.quoted = true
+			end := strings.IndexByte(p.src[p.pos+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated single quote")
+			}
+			b.WriteString(p.src[p.pos+1 : p.pos+1+end])
+			p.pos += end + 2
+		case c == '"':
+			w.quoted = true
+			if err := p.parseDoubleQuoted(w, &b); err != nil {
+				return nil, err
+			}
+		case c == '$' || c == '`':
+			if err := p.parseExpansion(w, &b); err != nil {
+				return nil, err
+			}
+		case c == '~' && p.pos == start, c == '{':
+			// ~ 和花括号展开的结果在执行时才确定
+			w.dynamic = true
+			b.WriteByte(c)
+			p.pos++
+		default:
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+	w.value, w.raw = b.String(), p.src[start:p.pos]
+	return w, nil
+}
+
+// parseDoubleQuoted 解析双引号中的内容，其中的变量和命令替换仍会展开
+func (p *shellParser) parseDoubleQuoted(w *word, b *strings.Builder) error {
+	p.pos++
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == '"':
+			p.pos++
+			return nil
+		case c == '\\' && p.pos+1 < len(p.src) && strings.IndexByte("$`\"\\\n", p.src[p.pos+1]) >= 0:
+			if p.src[p.pos+1] != '\n' {
+				b.WriteByte(p.src[p.pos+1])
+			}
+			p.pos += 2
+		case c == '$' || c == '`':
+			if err := p.parseExpansion(w, b); err != nil {
+				return err
+			}
+		default:
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+	return fmt.Errorf("unterminated double quote")
+}
+
+// parseExpansion 解析 $ 和反引号开头的展开，命令替换中的命令单独校验
+func (p *shellParser) parseExpansion(w *word, b *strings.Builder) error {
+	c := p.src[p.pos]
+	if c == '`' {
+		end := p.pos + 1
+		for end < len(p.src) && p.src[end] != '`' {
+			if p.src[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(p.src) {
+			return fmt.Errorf("unterminated backquote")
+		}
+		inner := strings.NewReplacer("\\`", "`", "\\\\", "\\", "\\$", "$").Replace(p.src[p.pos+1 : end])
+		p.pos = end + 1
+		w.dynamic = true
+		b.WriteString("$(...)")
+		return p.parseScript(inner, "")
+	}
+
+	next := p.next()
+	switch {
+	case next == '(':
+		if strings.HasPrefix(p.src[p.pos:], "$((") {
+			return fmt.Errorf("unsupported shell syntax: arithmetic expansion")
+		}
+		p.pos += 2
+		if err := p.parseList(true); err != nil {
+			return err
+		}
+		w.dynamic = true
+		b.WriteString("$(...)")
+	case next == '{':
+		end := strings.IndexByte(p.src[p.pos:], '}')
+		if end < 0 {
+			return fmt.Errorf("unterminated parameter expansion")
+		}
+		if strings.ContainsAny(p.src[p.pos+2:p.pos+end], "$`") {
+			return fmt.Errorf("unsupported shell syntax: nested parameter expansion")
+		}
+		b.WriteString(p.src[p.pos : p.pos+end+1])
+		p.pos += end + 1
+		w.dynamic = true
+	case next == '\'':
+		return fmt.Errorf("unsupported shell syntax: ANSI-C quoting")
+	case next == '_' || next >= 'a' && next <= 'z' || next >= 'A' && next <= 'Z':
+		end := p.pos + 1
+		for end < len(p.src) && (p.src[end] == '_' || p.src[end] >= 'a' && p.src[end] <= 'z' || p.src[end] >= 'A' && p.src[end] <= 'Z' || p.src[end] >= '0' && p.src[end] <= '9') {
+			end++
+		}
+		b.WriteString(p.src[p.pos:end])
+		p.pos = end
+		w.dynamic = true
+	case next != 0 && strings.IndexByte("?#@*!$-0123456789", next) >= 0:
+		b.WriteString(p.src[p.pos : p.pos+2])
+		p.pos += 2
+		w.dynamic = true
+	default:
+		b.WriteByte('$')
+		p.pos++
+	}
+	return nil
+}
+
+// skipBlanks 跳过空格、制表符和续行
+func (p *shellParser) skipBlanks() {
+	for p.pos < len(p.src) {
+		switch {
+		case p.src[p.pos] == ' ' || p.src[p.pos] == '\t':
+			p.pos++
+		case strings.HasPrefix(p.src[p.pos:], "\\\n"):
+			p.pos += 2
+		default:
+			return
+		}
+	}
+}
+
+// next 返回下一个字符，没有时返回 0
+func (p *shellParser) next() byte {
+	if p.pos+1 < len(p.src) {
+		return p.src[p.pos+1]
+	}
+	return 0
+}
+
+// peek 当前字符是否为 c
+func (p *shellParser) peek(c byte) bool {
+	return p.pos < len(p.src) && p.src[p.pos] == c
+}
+
+// isFD 是否为文件描述符（复制重定向的目标）
+func isFD(s string) bool {
+	if s == "-" {
+		return true
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
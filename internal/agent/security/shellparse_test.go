@@ -0,0 +1,87 @@
+package security
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseShell(t *testing.T) {
+	tests := []struct {
+		name string
+		cmd  string
+		want []string // 每个简单命令的参数，以 | 分隔
+	}{
+		{"simple", "ls -la /tmp", []string{"ls|-la|/tmp"}},
+		{"quotes", `grep "a b" 'c;d' e\ f`, []string{"grep|a b|c;d|e f"}},
+		{"pipeline", "ps aux | grep nginx | wc -l", []string{"ps|aux", "grep|nginx", "wc|-l"}},
+		{"lists", "a && b || c; d & e", []string{"a", "b", "c", "d", "e"}},
+		{"command substitution", "echo $(cat /etc/passwd) done", []string{"cat|/etc/passwd", "echo|$(...)|done"}},
+		{"backquote", "echo `id -u`", []string{"id|-u", "echo|$(...)"}},
+		{"quoted substitution", `echo "x $(whoami)"`, []string{"whoami", "echo|x $(...)"}},
+		{"subshell", "(cd /tmp && ls)", []string{"cd|/tmp", "ls"}},
+		{"sh -c", `bash -ec "ls /; rm -rf /tmp/x"`, []string{"bash|-ec|ls /; rm -rf /tmp/x", "ls|/", "rm|-rf|/tmp/x"}},
+		{"wrapper", "nice -n 10 timeout 5 rm -rf /data", []string{"nice|-n|10|timeout|5|rm|-rf|/data", "timeout|5|rm|-rf|/data", "rm|-rf|/data"}},
+		{"find exec", `find /tmp -name '*.log' -exec rm {} \;`, []string{"find|/tmp|-name|*.log|-exec|rm|{}|;", "rm|{}"}},
+		{"redirects", "cat < /etc/hosts 2>&1 >/dev/null", []string{"cat"}},
+		{"keywords", "if true; then ls; fi", []string{"true", "ls"}},
+		{"comment", "ls # rm -rf /", []string{"ls"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			commands, err := ParseShell(tt.cmd)
+			if err != nil {
+				t.Fatalf("ParseShell(%q) failed: %v", tt.cmd, err)
+			}
+			var got []string
+			for _, c := range commands {
+				got = append(got, strings.Join(c.Argv, "|"))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseShell(%q) = %q, want %q", tt.cmd, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseShellDetails(t *testing.T) {
+	commands, err := ParseShell(`FOO=1 cat $HOME/x ~/y < /etc/hosts > out.txt 2>&1`)
+	if err != nil {
+		t.Fatalf("ParseShell failed: %v", err)
+	}
+	c := commands[0]
+	if !reflect.DeepEqual(c.Assignments, []string{"FOO=1"}) {
+		t.Errorf("assignments = %q", c.Assignments)
+	}
+	if !reflect.DeepEqual(c.Dynamic, []bool{false, true, true}) {
+		t.Errorf("dynamic = %v", c.Dynamic)
+	}
+	want := []Redirect{{Op: "<", Target: "/etc/hosts"}, {Op: ">", Target: "out.txt"}}
+	if !reflect.DeepEqual(c.Redirects, want) {
+		t.Errorf("redirects = %+v, want %+v", c.Redirects, want)
+	}
+
+	commands, _ = ParseShell("ls | xargs rm")
+	if last := commands[len(commands)-1]; last.Via != "xargs" || !last.OpenArgs {
+		t.Errorf("xargs command = %+v", last)
+	}
+}
+
+func TestParseShellUnsupported(t *testing.T) {
+	for _, cmd := range []string{
+		"for f in *; do rm $f; done",
+		"cat <<EOF\nx\nEOF",
+		"f() { ls; }; f",
+		"echo $((1+2))",
+		"echo 'unterminated",
+		`echo "unterminated`,
+		"echo $(ls",
+		"sh -c \"$CMD\"",
+		"[[ -f /tmp/x ]]",
+	} {
+		if _, err := ParseShell(cmd); err == nil {
+			t.Errorf("ParseShell(%q) = nil error, want error", cmd)
+		}
+	}
+}
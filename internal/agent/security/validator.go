@@ -1,8 +1,11 @@
 package security
 
 import (
+	"errors"
 	"fmt"
+	"path"
 	"regexp"
+	"slices"
 	"sync"
 )
 
@@ -14,70 +17,195 @@ type CommandPattern struct {
 }
 
 // CommandValidator 命令验证器
+// 命令行按 shell 语法拆分为简单命令（管道、命令列表、命令替换、sh -c 等），每个简单命令单独校验
 type CommandValidator struct {
 	enabled         bool
 	allowedPatterns []*regexp.Regexp
 	blockedPatterns []*regexp.Regexp
+	blockedReasons  []string
+	binaries        map[string]*BinaryPolicy
 	mu              sync.RWMutex
 }
 
 // NewCommandValidator 创建命令验证器
 func NewCommandValidator(config *SecurityConfig) (*CommandValidator, error) {
-	v := &CommandValidator{
-		enabled: config.CommandWhitelistEnabled,
+	v := &CommandValidator{}
+	if err := v.Update(config); err != nil {
+		return nil, err
 	}
+	return v, nil
+}
 
+// Update 替换验证规则，新规则编译失败时保留原规则并返回错误
+// 正在进行的校验使用旧规则完成，之后的校验使用新规则
+func (v *CommandValidator) Update(config *SecurityConfig) error {
 	// 编译允许的命令模式
+	var allowed []*regexp.Regexp
 	for _, pattern := range config.AllowedCommands {
 		re, err := regexp.Compile(pattern.Pattern)
 		if err != nil {
-			return nil, fmt.Errorf("invalid allowed pattern %q: %w", pattern.Pattern, err)
+			return fmt.Errorf("invalid allowed pattern %q: %w", pattern.Pattern, err)
 		}
-		v.allowedPatterns = append(v.allowedPatterns, re)
+		allowed = append(allowed, re)
 	}
 
 	// 编译禁止的命令模式
+	var blocked []*regexp.Regexp
+	var reasons []string
 	for _, pattern := range config.BlockedPatterns {
 		re, err := regexp.Compile(pattern.Pattern)
 		if err != nil {
-			return nil, fmt.Errorf("invalid blocked pattern %q: %w", pattern.Pattern, err)
+			return fmt.Errorf("invalid blocked pattern %q: %w", pattern.Pattern, err)
 		}
-		v.blockedPatterns = append(v.blockedPatterns, re)
+		blocked = append(blocked, re)
+		reasons = append(reasons, pattern.Reason)
 	}
 
-	return v, nil
+	// 校验按可执行文件配置的策略
+	binaries := make(map[string]*BinaryPolicy, len(config.Binaries))
+	for name, policy := range config.Binaries {
+		if err := policy.validate(name); err != nil {
+			return err
+		}
+		binaries[name] = &policy
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.enabled = config.CommandWhitelistEnabled
+	v.allowedPatterns = allowed
+	v.blockedPatterns = blocked
+	v.blockedReasons = reasons
+	v.binaries = binaries
+	return nil
 }
 
 // ValidateCommand 验证命令是否允许执行
 func (v *CommandValidator) ValidateCommand(cmd string) error {
+	decision := v.Explain(cmd)
+	if decision.Allowed {
+		return nil
+	}
+	return errors.New(decision.Reason)
+}
+
+// Explain 校验命令并返回每个简单命令的结果和依据
+func (v *CommandValidator) Explain(cmd string) *Decision {
 	v.mu.RLock()
 	defer v.mu.RUnlock()
 
-	// 1. 检查黑名单（优先级最高，无论白名单是否启用都要检查）
+	decision := &Decision{Command: cmd, Allowed: true}
+
+	// 1. 整条命令检查黑名单（优先级最高，无论白名单是否启用都要检查）
 	for _, pattern := range v.blockedPatterns {
 		if pattern.MatchString(cmd) {
-			return fmt.Errorf("command blocked by security policy: %q matches blocked pattern %q", cmd, pattern.String())
+			decision.Allowed = false
+			decision.Reason = fmt.Sprintf("command blocked by security policy: %q matches blocked pattern %q", cmd, pattern.String())
+			return decision
 		}
 	}
 
-	// 2. 如果未启用白名单，通过黑名单检查后即允许执行
-	if !v.enabled {
-		return nil
+	// 2. 拆分为简单命令，配置了白名单规则时无法解析的命令一律拒绝；
+	// 未配置时无法逐个校验内置规则，原文中出现受内置规则检查的命令或设备时同样拒绝
+	commands, err := ParseShell(cmd)
+	if err != nil {
+		if v.strict() {
+			decision.Allowed = false
+			decision.Reason = fmt.Sprintf("command blocked by security policy: cannot parse %q: %v", cmd, err)
+		} else if reason := unparsedDeny(cmd); reason != "" {
+			decision.Allowed = false
+			decision.Reason = fmt.Sprintf("command blocked by security policy: cannot parse %q (%v) and %s", cmd, err, reason)
+		}
+		return decision
+	}
+
+	// 3. 逐个校验简单命令，任一被拒绝则整条命令被拒绝
+	for _, c := range commands {
+		result := v.evaluate(c)
+		decision.Commands = append(decision.Commands, result)
+		if result.Allowed || !decision.Allowed {
+			continue
+		}
+		decision.Allowed = false
+		if result.Rule == "whitelist" {
+			decision.Reason = fmt.Sprintf("command not in whitelist: %q", c.String())
+		} else {
+			decision.Reason = fmt.Sprintf("command blocked by security policy: %q: %s", c.String(), result.Reason)
+		}
+	}
+	return decision
+}
+
+// strict 是否启用了白名单且配置了规则，此时未匹配规则的命令被拒绝
+func (v *CommandValidator) strict() bool {
+	return v.enabled && (len(v.allowedPatterns) > 0 || len(v.binaries) > 0)
+}
+
+// evaluate 校验简单命令
+func (v *CommandValidator) evaluate(c *ShellCommand) CommandDecision {
+	result := CommandDecision{Argv: c.Argv, Via: c.Via}
+	deny := func(rule, reason string) CommandDecision {
+		result.Rule, result.Reason = rule, reason
+		return result
+	}
+	allow := func(rule string) CommandDecision {
+		result.Allowed, result.Rule = true, rule
+		return result
 	}
 
-	// 3. 检查白名单
-	if len(v.allowedPatterns) > 0 {
-		for _, pattern := range v.allowedPatterns {
-			if pattern.MatchString(cmd) {
-				return nil // 匹配白名单，允许执行
+	// 内置规则和黑名单对每个简单命令都生效
+	if reason := builtinDeny(c); reason != "" {
+		return deny("builtin", reason)
+	}
+	text := c.String()
+	for i, pattern := range v.blockedPatterns {
+		if pattern.MatchString(text) {
+			reason := fmt.Sprintf("matches blocked pattern %q", pattern.String())
+			if v.blockedReasons[i] != "" {
+				reason += " (" + v.blockedReasons[i] + ")"
 			}
+			return deny(fmt.Sprintf("blocked_patterns[%d]", i), reason)
 		}
-		// 没有匹配任何白名单模式
-		return fmt.Errorf("command not in whitelist: %q", cmd)
 	}
 
-	// 没有配置白名单，允许执行（但已通过黑名单检查）
-	return nil
+	// 白名单模式下只允许输出到 /dev/null 等设备，不允许在执行前注入环境变量
+	if v.strict() {
+		for _, r := range c.Redirects {
+			if r.isOutput() && (r.Dynamic || !slices.Contains(safeDevices, path.Clean(r.Target))) {
+				return deny("redirect", fmt.Sprintf("output redirection to %s is not allowed", r.Target))
+			}
+		}
+		if len(c.Assignments) > 0 && len(c.Argv) > 0 {
+			return deny("assignment", fmt.Sprintf("environment assignment %s is not allowed", c.Assignments[0]))
+		}
+	}
+	if len(c.Argv) == 0 {
+		return allow("redirect")
+	}
+
+	name := c.Argv[0]
+	if c.Dynamic[0] {
+		if v.strict() {
+			return deny("whitelist", fmt.Sprintf("executable %q is expanded at runtime", name))
+		}
+		return allow("whitelist_disabled")
+	}
+	if policy, ok := v.binaries[name]; ok {
+		if reason := policy.check(c); reason != "" {
+			return deny("binaries."+name, reason)
+		}
+		return allow("binaries." + name)
+	}
+
+	if !v.strict() {
+		return allow("whitelist_disabled")
+	}
+	for i, pattern := range v.allowedPatterns {
+		if pattern.MatchString(text) {
+			return allow(fmt.Sprintf("allowed_commands[%d]", i))
+		}
+	}
+	return deny("whitelist", fmt.Sprintf("%s is not in the whitelist", name))
 }
 
 // IsEnabled 返回验证器是否启用
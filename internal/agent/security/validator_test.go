@@ -1,6 +1,7 @@
 package security
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -129,10 +130,14 @@ func TestEmptyConfig(t *testing.T) {
 		t.Fatalf("NewCommandValidator failed: %v", err)
 	}
 
-	// 空配置时所有命令都应该被允许（因为没有任何规则）
-	err = v.ValidateCommand("rm -rf /")
-	if err != nil {
-		t.Errorf("ValidateCommand with empty config = %v, want nil", err)
+	// 空配置时普通命令允许执行，内置规则仍然拒绝破坏系统的命令
+	if err := v.ValidateCommand("ls -la /tmp"); err != nil {
+		t.Errorf("ValidateCommand(ls) with empty config = %v, want nil", err)
+	}
+	for _, cmd := range []string{"rm -rf /", "rm -r -f /etc", "echo ok; rm -fr /*", "sh -c 'rm -rf /usr'", "mkfs.ext4 /dev/sda1", "dd if=/dev/zero of=/dev/sda"} {
+		if err := v.ValidateCommand(cmd); err == nil {
+			t.Errorf("ValidateCommand(%q) with empty config = nil, want error", cmd)
+		}
 	}
 }
 
@@ -157,3 +162,209 @@ func TestLoadSecurityConfig_NonExistentPath(t *testing.T) {
 		t.Error("Expected CommandWhitelistEnabled=false for nonexistent path")
 	}
 }
+
+// TestUpdateKeepsConfigOnInvalidPattern 验证热更新：规则有效时立即生效，无效时保留原规则
+func TestUpdateKeepsConfigOnInvalidPattern(t *testing.T) {
+	v, err := NewCommandValidator(&SecurityConfig{})
+	if err != nil {
+		t.Fatalf("NewCommandValidator failed: %v", err)
+	}
+	if err := v.ValidateCommand("reboot"); err != nil {
+		t.Fatalf("empty config should allow reboot, got %v", err)
+	}
+
+	if err := v.Update(&SecurityConfig{BlockedPatterns: []CommandPattern{{Pattern: "^reboot", Reason: "禁止重启"}}}); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if err := v.ValidateCommand("reboot"); err == nil {
+		t.Error("reboot should be blocked after update")
+	}
+
+	if err := v.Update(&SecurityConfig{CommandWhitelistEnabled: true, AllowedCommands: []CommandPattern{{Pattern: "("}}}); err == nil {
+		t.Fatal("Update with invalid pattern should fail")
+	}
+	if v.IsEnabled() || v.ValidateCommand("reboot") == nil || v.ValidateCommand("ls") != nil {
+		t.Error("invalid update should keep the previous rules")
+	}
+}
+
+func TestParseSecurityConfig(t *testing.T) {
+	if _, err := ParseSecurityConfig([]byte("command_whitelist_enabled: true\nunknown_field: 1\n")); err == nil {
+		t.Error("unknown fields should be rejected")
+	}
+	if _, err := ParseSecurityConfig([]byte("blocked_patterns:\n  - pattern: \"[\"\n")); err == nil {
+		t.Error("invalid patterns should be rejected")
+	}
+	if _, err := ParseSecurityConfig([]byte("redaction:\n  disable_builtin: [unknown]\n")); err == nil {
+		t.Error("unknown builtin redaction rules should be rejected")
+	}
+	if _, err := ParseSecurityConfig([]byte("redaction:\n  rules:\n    - name: bad\n      pattern: \"(\"\n")); err == nil {
+		t.Error("invalid redaction patterns should be rejected")
+	}
+	if _, err := ParseSecurityConfig([]byte("policy:\n  rules:\n    - name: bad\n      effect: block\n")); err == nil {
+		t.Error("invalid policy effects should be rejected")
+	}
+	config, err := ParseSecurityConfig([]byte("command_whitelist_enabled: true\n"))
+	if err != nil || !config.CommandWhitelistEnabled {
+		t.Errorf("ParseSecurityConfig = %+v, %v", config, err)
+	}
+}
+
+// TestWhitelistChecksEachCommand 白名单对管道、命令替换和 sh -c 中的每个命令生效
+func TestWhitelistChecksEachCommand(t *testing.T) {
+	v, err := NewCommandValidator(&SecurityConfig{
+		CommandWhitelistEnabled: true,
+		AllowedCommands: []CommandPattern{
+			{Pattern: `^ls\s*.*`},
+			{Pattern: `^ps\s+.*`},
+			{Pattern: `^grep\s+.*`},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewCommandValidator failed: %v", err)
+	}
+
+	tests := []struct {
+		command string
+		allowed bool
+	}{
+		{"ls -la /tmp", true},
+		{"ps aux | grep nginx", true},
+		{"ls | sh", false},
+		{"ls $(curl http://evil)", false},
+		{"ls `rm -rf /tmp/x`", false},
+		{"ls; rm -rf /tmp/x", false},
+		{"ls > /etc/passwd", false},
+		{"ls 2>/dev/null", true},
+		{"LD_PRELOAD=/tmp/x.so ls", false},
+		{"for f in a; do ls; done", false},
+	}
+	for _, tt := range tests {
+		err := v.ValidateCommand(tt.command)
+		if (err == nil) != tt.allowed {
+			t.Errorf("ValidateCommand(%q) = %v, want allowed=%v", tt.command, err, tt.allowed)
+		}
+	}
+}
+
+// TestBinaryPolicy 按可执行文件校验子命令、选项和路径
+func TestBinaryPolicy(t *testing.T) {
+	v, err := NewCommandValidator(&SecurityConfig{
+		CommandWhitelistEnabled: true,
+		Binaries: map[string]BinaryPolicy{
+			"ls":      {AllowedFlags: []string{"-l", "-a", "-h"}, AllowedPaths: []string{"/var/log", "/tmp"}},
+			"grep":    {DeniedFlags: []string{"-r", "-R"}, ValueFlags: []string{"-e", "-m"}, AllowedPaths: []string{"/var/log"}, NonPathArgs: 1},
+			"kubectl": {Subcommands: []string{"get", "describe"}, ValueFlags: []string{"-n", "--namespace"}},
+			"find":    {DeniedFlags: []string{"-delete", "-exec"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewCommandValidator failed: %v", err)
+	}
+
+	tests := []struct {
+		command string
+		allowed bool
+	}{
+		{"ls -la /var/log", true},
+		{"ls -lh /tmp/../var/log/nginx", true},
+		{"ls -R /var/log", false},
+		{"ls /etc", false},
+		{"ls /var/logs", false},
+		{"ls ../../etc", false},
+		{"ls /var/log/$USER", false},
+		{"grep -m 5 error /var/log/syslog", true},
+		{"grep -rn error /var/log", false},
+		{"grep error /etc/shadow", false},
+		{"grep -e /etc/shadow /var/log/syslog", true},
+		{"grep error < /etc/shadow", false},
+		{"kubectl get pods -n kube-system", true},
+		{"kubectl --namespace=prod describe pod x", true},
+		{"kubectl delete pod x", false},
+		{"kubectl", false},
+		{"find /tmp -name '*.log' -delete", false},
+		{"cat /var/log/syslog | grep error", false},
+		{"/bin/ls /tmp", false},
+	}
+	for _, tt := range tests {
+		err := v.ValidateCommand(tt.command)
+		if (err == nil) != tt.allowed {
+			t.Errorf("ValidateCommand(%q) = %v, want allowed=%v", tt.command, err, tt.allowed)
+		}
+	}
+}
+
+// TestExplain 校验结果说明每个简单命令匹配的规则
+func TestExplain(t *testing.T) {
+	v, err := NewCommandValidator(&SecurityConfig{
+		CommandWhitelistEnabled: true,
+		AllowedCommands:         []CommandPattern{{Pattern: `^ps\s+.*`}},
+		Binaries:                map[string]BinaryPolicy{"grep": {DeniedFlags: []string{"-r"}}},
+	})
+	if err != nil {
+		t.Fatalf("NewCommandValidator failed: %v", err)
+	}
+
+	d := v.Explain("ps aux | grep -r nginx /etc")
+	if d.Allowed || len(d.Commands) != 2 {
+		t.Fatalf("Explain = %+v, want denied with 2 commands", d)
+	}
+	if c := d.Commands[0]; !c.Allowed || c.Rule != "allowed_commands[0]" {
+		t.Errorf("first command = %+v", c)
+	}
+	if c := d.Commands[1]; c.Allowed || c.Rule != "binaries.grep" || !strings.Contains(c.Reason, "-r") {
+		t.Errorf("second command = %+v", c)
+	}
+	if !strings.Contains(d.Reason, "grep -r nginx /etc") {
+		t.Errorf("reason = %q, should name the rejected command", d.Reason)
+	}
+
+	if d := v.Explain("ps aux | wc -l"); d.Allowed || d.Reason != `command not in whitelist: "wc -l"` {
+		t.Errorf("Explain(wc) = %+v", d)
+	}
+}
+
+func TestBinaryPolicyValidation(t *testing.T) {
+	for name, policy := range map[string]BinaryPolicy{
+		"ls":      {AllowedPaths: []string{"var/log"}},
+		"grep":    {AllowedFlags: []string{"r"}},
+		"bad cmd": {},
+	} {
+		config := &SecurityConfig{Binaries: map[string]BinaryPolicy{name: policy}}
+		if _, err := NewCommandValidator(config); err == nil {
+			t.Errorf("NewCommandValidator(%q: %+v) = nil error, want error", name, policy)
+		}
+	}
+}
+
+// TestUnparsedCommandsFailClosed 未配置白名单时，无法解析的命令不能绕过内置规则
+func TestUnparsedCommandsFailClosed(t *testing.T) {
+	v, err := NewCommandValidator(&SecurityConfig{})
+	if err != nil {
+		t.Fatalf("NewCommandValidator failed: %v", err)
+	}
+
+	denied := []string{
+		"rm -rf / <<EOF\nx\nEOF",
+		"for i in 1; do rm -rf /; done",
+		"echo $((1)); rm -rf /",
+		"rm -rf /; case x in x) ;; esac",
+		"for d in sda; do cat x > /dev/$d; done",
+		"bash --norc -c 'rm -rf /'",
+		"bash --rcfile /tmp/rc -c 'rm -rf /'",
+		"bash -o errexit -c 'rm -rf /'",
+		"bash -c -- 'rm -rf /'",
+	}
+	for _, cmd := range denied {
+		if err := v.ValidateCommand(cmd); err == nil {
+			t.Errorf("ValidateCommand(%q) = nil, want error", cmd)
+		}
+	}
+
+	// 不涉及内置规则的命令即使无法解析也允许执行
+	for _, cmd := range []string{"for i in 1 2; do echo $i; done", "echo $((1 + 2))", "head -c 16 /dev/urandom | base64 >/dev/null; echo $((1))", "bash --norc -c 'ls /tmp'"} {
+		if err := v.ValidateCommand(cmd); err != nil {
+			t.Errorf("ValidateCommand(%q) = %v, want nil", cmd, err)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+// Package telemetry 采集 Agent 所在主机的资源使用情况，随心跳上报 Cloud
+//
+// Linux 上从 /proc 读取 CPU、内存、负载和运行时长，通过 statfs 读取磁盘用量；
+// 以 DaemonSet 部署时 /proc 反映的是节点的资源使用。其他平台只上报系统和进程信息。
+package telemetry
+
+import (
+	"bufio"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloud-agent/internal/common"
+)
+
+// Collector 资源采集器，CPU 使用率为相邻两次采集之间的平均值
+type Collector struct {
+	diskPath string
+	started  time.Time
+
+	mu        sync.Mutex
+	prevIdle  uint64
+	prevTotal uint64
+}
+
+// NewCollector 创建采集器，diskPath 为统计磁盘用量的路径，为空时使用根目录
+func NewCollector(diskPath string) *Collector {
+	if diskPath == "" {
+		diskPath = "/"
+	}
+	c := &Collector{diskPath: diskPath, started: time.Now()}
+	// 记录 CPU 基线，第一次采集得到的是从创建到采集之间的使用率
+	if idle, total, err := readCPUTimes(); err == nil {
+		c.prevIdle, c.prevTotal = idle, total
+	}
+	return c
+}
+
+// Collect 采集一次资源快照，采集失败的项保持零值
+func (c *Collector) Collect() *common.AgentTelemetry {
+	t := &common.AgentTelemetry{
+		OS:          runtime.GOOS,
+		Arch:        runtime.GOARCH,
+		CPUCores:    runtime.NumCPU(),
+		DiskPath:    c.diskPath,
+		AgentUptime: int64(time.Since(c.started).Seconds()),
+		CollectedAt: time.Now().Unix(),
+	}
+
+	if idle, total, err := readCPUTimes(); err == nil {
+		c.mu.Lock()
+		t.CPUPercent = cpuPercent(c.prevIdle, c.prevTotal, idle, total)
+		c.prevIdle, c.prevTotal = idle, total
+		c.mu.Unlock()
+	}
+	if total, available, err := readMemory(); err == nil && total > 0 {
+		t.MemTotal = total
+		t.MemUsed = total - available
+		t.MemPercent = percent(t.MemUsed, total)
+	}
+	if total, used, err := diskUsage(c.diskPath); err == nil && total > 0 {
+		t.DiskTotal = total
+		t.DiskUsed = used
+		t.DiskPercent = percent(used, total)
+	}
+	if load, err := readLoadAvg(); err == nil {
+		t.Load1, t.Load5, t.Load15 = load[0], load[1], load[2]
+	}
+	if uptime, err := readUptime(); err == nil {
+		t.Uptime = uptime
+	}
+	return t
+}
+
+// cpuPercent 根据两次累计 CPU 时间计算使用率
+func cpuPercent(prevIdle, prevTotal, idle, total uint64) float64 {
+	if total <= prevTotal || idle < prevIdle {
+		return 0
+	}
+	busy := (total - prevTotal) - (idle - prevIdle)
+	return round2(float64(busy) / float64(total-prevTotal) * 100)
+}
+
+// percent 计算百分比，保留两位小数
+func percent(used, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return round2(float64(used) / float64(total) * 100)
+}
+
+func round2(v float64) float64 {
+	return float64(int64(v*100+0.5)) / 100
+}
+
+// parseCPUStat 解析 /proc/stat 的 cpu 汇总行，返回空闲时间（idle + iowait）和总时间
+func parseCPUStat(data string) (idle, total uint64, err error) {
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 || fields[0] != "cpu" {
+			continue
+		}
+		// guest 和 guest_nice 已计入 user 和 nice，不重复累加
+		for i, f := range fields[1:] {
+			if i >= 8 {
+				break
+			}
+			v, err := strconv.ParseUint(f, 10, 64)
+			if err != nil {
+				return 0, 0, err
+			}
+			total += v
+			if i == 3 || i == 4 {
+				idle += v
+			}
+		}
+		return idle, total, nil
+	}
+	return 0, 0, common.NewError("cpu line not found in /proc/stat")
+}
+
+// parseMemInfo 解析 /proc/meminfo，返回总内存和可用内存（字节）
+// 没有 MemAvailable 的旧内核使用 MemFree + Buffers + Cached 估算
+func parseMemInfo(data string) (total, available uint64, err error) {
+	values := make(map[string]uint64)
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		key, rest, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		values[key] = v * 1024 // 单位为 kB
+	}
+
+	total, ok := values["MemTotal"]
+	if !ok {
+		return 0, 0, common.NewError("MemTotal not found in /proc/meminfo")
+	}
+	available, ok = values["MemAvailable"]
+	if !ok {
+		available = values["MemFree"] + values["Buffers"] + values["Cached"]
+	}
+	if available > total {
+		available = total
+	}
+	return total, available, nil
+}
+
+// parseLoadAvg 解析 /proc/loadavg 的前三项
+func parseLoadAvg(data string) ([3]float64, error) {
+	var load [3]float64
+	fields := strings.Fields(data)
+	if len(fields) < 3 {
+		return load, common.NewError("invalid /proc/loadavg")
+	}
+	for i := range load {
+		v, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return load, err
+		}
+		load[i] = v
+	}
+	return load, nil
+}
+
+// parseUptime 解析 /proc/uptime 的第一项（秒）
+func parseUptime(data string) (int64, error) {
+	fields := strings.Fields(data)
+	if len(fields) == 0 {
+		return 0, common.NewError("invalid /proc/uptime")
+	}
+	v, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, err
+	}
+	return int64(v), nil
+}
@@ -0,0 +1,53 @@
+//go:build linux
+
+package telemetry
+
+import (
+	"os"
+	"syscall"
+)
+
+func readCPUTimes() (idle, total uint64, err error) {
+	data, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return 0, 0, err
+	}
+	return parseCPUStat(string(data))
+}
+
+func readMemory() (total, available uint64, err error) {
+	data, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0, 0, err
+	}
+	return parseMemInfo(string(data))
+}
+
+func readLoadAvg() ([3]float64, error) {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return [3]float64{}, err
+	}
+	return parseLoadAvg(string(data))
+}
+
+func readUptime() (int64, error) {
+	data, err := os.ReadFile("/proc/uptime")
+	if err != nil {
+		return 0, err
+	}
+	return parseUptime(string(data))
+}
+
+// diskUsage 返回 path 所在文件系统的总容量和已用容量（字节），已用容量不含仅 root 可用的保留空间
+func diskUsage(path string) (total, used uint64, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	bsize := uint64(st.Bsize)
+	used = (st.Blocks - st.Bfree) * bsize
+	// 与 df 一致：总容量 = 已用 + 普通用户可用
+	total = used + st.Bavail*bsize
+	return total, used, nil
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package telemetry
+
+import "github.com/cloud-agent/internal/common"
+
+var errUnsupported = common.NewError("host telemetry is only supported on linux")
+
+func readCPUTimes() (idle, total uint64, err error) { return 0, 0, errUnsupported }
+
+func readMemory() (total, available uint64, err error) { return 0, 0, errUnsupported }
+
+func readLoadAvg() ([3]float64, error) { return [3]float64{}, errUnsupported }
+
+func readUptime() (int64, error) { return 0, errUnsupported }
+
+func diskUsage(path string) (total, used uint64, err error) { return 0, 0, errUnsupported }
@@ -0,0 +1,62 @@
+package telemetry
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestParseCPUStat(t *testing.T) {
+	data := "cpu  100 5 50 800 40 3 2 0 10 0\ncpu0 50 2 25 400 20 1 1 0 5 0\nintr 1\n"
+	idle, total, err := parseCPUStat(data)
+	if err != nil {
+		t.Fatalf("parseCPUStat failed: %v", err)
+	}
+	// guest 列不计入总时间
+	if idle != 840 || total != 1000 {
+		t.Fatalf("idle = %d, total = %d, want 840, 1000", idle, total)
+	}
+	if got := cpuPercent(840, 1000, 1020, 1200); got != 10 {
+		t.Errorf("cpuPercent = %v, want 10", got)
+	}
+	if got := cpuPercent(840, 1000, 840, 1000); got != 0 {
+		t.Errorf("cpuPercent without progress = %v, want 0", got)
+	}
+}
+
+func TestParseMemInfo(t *testing.T) {
+	total, available, err := parseMemInfo("MemTotal:       16000 kB\nMemFree:         1000 kB\nMemAvailable:    4000 kB\n")
+	if err != nil || total != 16000*1024 || available != 4000*1024 {
+		t.Fatalf("parseMemInfo = %d, %d, %v", total, available, err)
+	}
+
+	// 旧内核没有 MemAvailable
+	total, available, err = parseMemInfo("MemTotal: 1000 kB\nMemFree: 100 kB\nBuffers: 50 kB\nCached: 250 kB\n")
+	if err != nil || total != 1000*1024 || available != 400*1024 {
+		t.Fatalf("parseMemInfo fallback = %d, %d, %v", total, available, err)
+	}
+
+	if _, _, err := parseMemInfo("MemFree: 100 kB\n"); err == nil {
+		t.Error("expected error without MemTotal")
+	}
+}
+
+func TestParseLoadAvgAndUptime(t *testing.T) {
+	load, err := parseLoadAvg("0.52 1.25 2.00 3/512 12345\n")
+	if err != nil || load != [3]float64{0.52, 1.25, 2} {
+		t.Fatalf("parseLoadAvg = %v, %v", load, err)
+	}
+	uptime, err := parseUptime("3600.75 7000.00\n")
+	if err != nil || uptime != 3600 {
+		t.Fatalf("parseUptime = %d, %v", uptime, err)
+	}
+}
+
+func TestCollect(t *testing.T) {
+	snapshot := NewCollector("").Collect()
+	if snapshot.OS != runtime.GOOS || snapshot.CPUCores <= 0 || snapshot.DiskPath != "/" || snapshot.CollectedAt == 0 {
+		t.Fatalf("unexpected snapshot: %+v", snapshot)
+	}
+	if runtime.GOOS == "linux" && (snapshot.MemTotal == 0 || snapshot.DiskTotal == 0 || snapshot.MemPercent <= 0) {
+		t.Errorf("expected memory and disk usage on linux: %+v", snapshot)
+	}
+}
@@ -0,0 +1,262 @@
+package agent
+
+import (
+	"log"
+	"time"
+
+	"github.com/cloud-agent/internal/cloud/cluster"
+	"github.com/cloud-agent/internal/common"
+)
+
+// HealthConfig Agent 健康检查配置
+type HealthConfig struct {
+	CheckInterval      time.Duration // 检查间隔
+	HeartbeatTimeout   time.Duration // 超过该时长没有心跳的 Agent 视为离线
+	FlapWindow         time.Duration // 抖动检测的时间窗口
+	FlapThreshold      int           // 时间窗口内断开次数达到该值时视为抖动，0 表示不检测
+	HistoryRetention   time.Duration // 连接历史保留时长，0 表示永久保留
+	TelemetryRetention time.Duration // 心跳资源样本保留时长，0 表示永久保留
+}
+
+// DefaultHealthConfig 返回默认健康检查配置
+func DefaultHealthConfig() *HealthConfig {
+	return &HealthConfig{
+		CheckInterval:      30 * time.Second,
+		HeartbeatTimeout:   cluster.DefaultAgentTTL,
+		FlapWindow:         10 * time.Minute,
+		FlapThreshold:      3,
+		HistoryRetention:   7 * 24 * time.Hour,
+		TelemetryRetention: 24 * time.Hour,
+	}
+}
+
+// applyDefaults 补全未设置的配置项
+func (c *HealthConfig) applyDefaults() {
+	defaults := DefaultHealthConfig()
+	if c.CheckInterval <= 0 {
+		c.CheckInterval = defaults.CheckInterval
+	}
+	if c.HeartbeatTimeout <= 0 {
+		c.HeartbeatTimeout = defaults.HeartbeatTimeout
+	}
+	if c.FlapWindow <= 0 {
+		c.FlapWindow = defaults.FlapWindow
+	}
+	if c.FlapThreshold < 0 {
+		c.FlapThreshold = 0
+	}
+}
+
+// TaskHandler 处理 Agent 上下线时的在途任务，由任务管理器实现
+type TaskHandler interface {
+	// HandleAgentOffline Agent 离线后处理其未完成的任务（失败或重新排队）
+	HandleAgentOffline(agentID string)
+	// HandleAgentOnline Agent 重新上线后下发 since 之前重新排队的任务
+	HandleAgentOnline(agentID string, since time.Time)
+	// ExpireRequeuedTasks 将排队超时的任务标记为失败
+	ExpireRequeuedTasks()
+}
+
+// SetTaskHandler 设置在途任务处理器，为 nil 时 Agent 离线不处理任务
+func (m *Manager) SetTaskHandler(h TaskHandler) {
+	m.taskHandler = h
+}
+
+// ConnectionHistory Agent 连接历史和抖动状态
+type ConnectionHistory struct {
+	Flapping            bool                           `json:"flapping"`
+	DisconnectsInWindow int64                          `json:"disconnects_in_window"`
+	Window              string                         `json:"window"`
+	Events              []*common.AgentConnectionEvent `json:"events"`
+}
+
+// StartHealthMonitor 启动后台健康检查，cfg 为 nil 时使用默认配置，Close 时停止
+// 每次检查关闭本副本上心跳超时的连接，并将集群中没有任何副本持有连接、心跳超时的 Agent 标记为离线
+func (m *Manager) StartHealthMonitor(cfg *HealthConfig) {
+	if cfg == nil {
+		cfg = DefaultHealthConfig()
+	}
+	cfg.applyDefaults()
+	m.health = cfg
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		ticker := time.NewTicker(cfg.CheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-m.stopCh:
+				return
+			case <-ticker.C:
+				m.CheckHealth()
+			}
+		}
+	}()
+}
+
+// CheckHealth 执行一次健康检查
+func (m *Manager) CheckHealth() {
+	cutoff := time.Now().Add(-m.health.HeartbeatTimeout)
+
+	// 本副本上心跳超时的连接：连接可能已失效但未触发读错误
+	var stale []string
+	m.mu.RLock()
+	for agentID := range m.connections {
+		if agent, ok := m.agents[agentID]; ok && agent.LastSeen != nil && agent.LastSeen.Before(cutoff) {
+			stale = append(stale, agentID)
+		}
+	}
+	m.mu.RUnlock()
+	for _, agentID := range stale {
+		log.Printf("[health] agent %s missed heartbeats since %s, closing connection", agentID, cutoff.Format(time.RFC3339))
+		m.mu.Lock()
+		agent := m.unregisterLocked(agentID, common.AgentConnEventHeartbeatTimeout)
+		m.mu.Unlock()
+		if agent == nil {
+			continue
+		}
+		m.agentDisconnected(agent)
+		// Agent 已重新连接到其他副本
+		if _, ok, err := m.cluster.Registry.Lookup(agentID); err == nil && ok {
+			continue
+		}
+		m.markOffline(agent, time.Time{})
+	}
+
+	// 集群中没有副本持有连接的 Agent（例如所在副本已退出）
+	m.reapStaleAgents(cutoff)
+
+	if m.taskHandler != nil {
+		m.taskHandler.ExpireRequeuedTasks()
+	}
+
+	if time.Since(m.lastPurge) > time.Hour {
+		m.lastPurge = time.Now()
+		if m.health.HistoryRetention > 0 {
+			if _, err := m.db.PurgeAgentConnectionEvents(time.Now().Add(-m.health.HistoryRetention)); err != nil {
+				log.Printf("[health] failed to purge connection history: %v", err)
+			}
+		}
+		if m.health.TelemetryRetention > 0 {
+			if _, err := m.db.PurgeAgentTelemetrySamples(time.Now().Add(-m.health.TelemetryRetention)); err != nil {
+				log.Printf("[health] failed to purge telemetry samples: %v", err)
+			}
+		}
+	}
+}
+
+// reapStaleAgents 将数据库中仍为在线、但心跳超时且不在任何副本上的 Agent 标记为离线
+// 包括连接断开后宽限期内没有重新连接的 Agent 和所在副本已退出的 Agent
+// 多副本同时检查时只有一个副本完成标记并处理其任务
+func (m *Manager) reapStaleAgents(cutoff time.Time) {
+	agents, err := m.db.ListStaleOnlineAgents(cutoff)
+	if err != nil {
+		log.Printf("[health] failed to list stale agents: %v", err)
+		return
+	}
+	if len(agents) == 0 {
+		return
+	}
+	online, err := m.cluster.Registry.Online()
+	if err != nil {
+		log.Printf("[cluster] failed to list online agents: %v", err)
+		return
+	}
+
+	for _, agent := range agents {
+		if _, ok := online[agent.ID]; ok {
+			continue
+		}
+		if _, local := m.GetConnection(agent.ID); local {
+			continue
+		}
+		m.markOffline(agent, cutoff)
+	}
+}
+
+// markOffline 将最后活跃时间早于 seenBefore 的 Agent 标记为离线（为零值时不限制），调用方不能持有锁
+// 由本次调用完成标记时发送通知并处理在途任务；连接没有正常断开（所在副本已退出）时补记心跳超时事件
+func (m *Manager) markOffline(agent *common.Agent, seenBefore time.Time) {
+	marked, err := m.db.MarkAgentOffline(agent.ID, seenBefore)
+	if err != nil {
+		log.Printf("[health] failed to mark agent %s offline: %v", agent.ID, err)
+		return
+	}
+	if !marked {
+		return
+	}
+	log.Printf("[health] agent %s missed heartbeats, marked offline", agent.ID)
+	agent.Status = common.AgentStatusOffline
+	if !m.disconnectRecorded(agent.ID) {
+		m.recordConnectionEvent(agent.ID, common.AgentConnEventHeartbeatTimeout, "no replica holds the connection")
+		m.agentDisconnected(agent)
+	}
+	m.agentOffline(agent)
+}
+
+// disconnectRecorded Agent 最近一次连接事件是否为断开（包括心跳超时），查询失败时视为已记录，避免重复计入抖动
+func (m *Manager) disconnectRecorded(agentID string) bool {
+	events, err := m.db.ListAgentConnectionEvents(agentID, 1)
+	if err != nil {
+		log.Printf("[health] failed to get connection history of agent %s: %v", agentID, err)
+		return true
+	}
+	return len(events) > 0 && events[0].Event != common.AgentConnEventConnected
+}
+
+// agentOffline Agent 被标记为离线后发送通知并处理在途任务，调用方不能持有锁
+func (m *Manager) agentOffline(agent *common.Agent) {
+	m.notifier.AgentOffline(agent)
+	if m.taskHandler != nil {
+		m.taskHandler.HandleAgentOffline(agent.ID)
+	}
+}
+
+// agentDisconnected 记录 Agent 断开事件后检测抖动，调用方不能持有锁
+func (m *Manager) agentDisconnected(agent *common.Agent) {
+	if agent == nil || m.health.FlapThreshold <= 0 {
+		return
+	}
+	count, err := m.db.CountAgentDisconnects(agent.ID, time.Now().Add(-m.health.FlapWindow))
+	if err != nil {
+		log.Printf("[health] failed to count disconnects of agent %s: %v", agent.ID, err)
+		return
+	}
+	// 只在刚达到阈值时告警一次，窗口内继续断开不重复告警
+	if count == int64(m.health.FlapThreshold) {
+		log.Printf("[health] agent %s is flapping: %d disconnects in %s", agent.ID, count, m.health.FlapWindow)
+		m.notifier.AgentFlapping(agent, int(count), m.health.FlapWindow)
+	}
+}
+
+// recordConnectionEvent 记录 Agent 连接事件
+func (m *Manager) recordConnectionEvent(agentID, event, reason string) {
+	err := m.db.CreateAgentConnectionEvent(&common.AgentConnectionEvent{
+		AgentID:   agentID,
+		Event:     event,
+		ReplicaID: m.cluster.ReplicaID,
+		Reason:    reason,
+	})
+	if err != nil {
+		log.Printf("[health] failed to record %s event of agent %s: %v", event, agentID, err)
+	}
+}
+
+// ConnectionHistory 返回 Agent 最近 limit 条连接事件和抖动状态
+func (m *Manager) ConnectionHistory(agentID string, limit int) (*ConnectionHistory, error) {
+	events, err := m.db.ListAgentConnectionEvents(agentID, limit)
+	if err != nil {
+		return nil, err
+	}
+	count, err := m.db.CountAgentDisconnects(agentID, time.Now().Add(-m.health.FlapWindow))
+	if err != nil {
+		return nil, err
+	}
+	return &ConnectionHistory{
+		Flapping:            m.health.FlapThreshold > 0 && count >= int64(m.health.FlapThreshold),
+		DisconnectsInWindow: count,
+		Window:              m.health.FlapWindow.String(),
+		Events:              events,
+	}, nil
+}
@@ -0,0 +1,169 @@
+package agent
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloud-agent/internal/common"
+)
+
+// recordingHandler 记录 Agent 上下线回调
+type recordingHandler struct {
+	mu      sync.Mutex
+	offline []string
+	online  []string
+}
+
+func (h *recordingHandler) HandleAgentOffline(agentID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.offline = append(h.offline, agentID)
+}
+
+func (h *recordingHandler) HandleAgentOnline(agentID string, since time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.online = append(h.online, agentID)
+}
+
+func (h *recordingHandler) ExpireRequeuedTasks() {}
+
+func TestHealthMonitorClosesStaleConnection(t *testing.T) {
+	replicaA, _ := newReplicas(t)
+	handler := &recordingHandler{}
+	replicaA.SetTaskHandler(handler)
+	connectAgent(t, replicaA, "host-1")
+
+	// 心跳正常时不处理
+	replicaA.CheckHealth()
+	if _, ok := replicaA.GetConnection("host-1"); !ok {
+		t.Fatal("healthy agent should stay connected")
+	}
+
+	replicaA.mu.Lock()
+	old := time.Now().Add(-time.Hour)
+	replicaA.agents["host-1"].LastSeen = &old
+	replicaA.mu.Unlock()
+	replicaA.CheckHealth()
+
+	if _, ok := replicaA.GetConnection("host-1"); ok {
+		t.Fatal("agent without heartbeats should be disconnected")
+	}
+	if agent, _ := replicaA.db.GetAgent("host-1"); agent.Status != common.AgentStatusOffline {
+		t.Errorf("agent status = %s, want offline", agent.Status)
+	}
+	if len(handler.online) != 1 || len(handler.offline) != 1 || handler.offline[0] != "host-1" {
+		t.Errorf("handler calls: online=%v offline=%v", handler.online, handler.offline)
+	}
+
+	history, err := replicaA.ConnectionHistory("host-1", 10)
+	if err != nil {
+		t.Fatalf("ConnectionHistory failed: %v", err)
+	}
+	if len(history.Events) != 2 || history.Events[0].Event != common.AgentConnEventHeartbeatTimeout ||
+		history.Events[1].Event != common.AgentConnEventConnected {
+		t.Errorf("unexpected history: %+v", history.Events)
+	}
+}
+
+func TestHealthMonitorReapsAgentsOfDeadReplica(t *testing.T) {
+	replicaA, replicaB := newReplicas(t)
+	handlerA, handlerB := &recordingHandler{}, &recordingHandler{}
+	replicaA.SetTaskHandler(handlerA)
+	replicaB.SetTaskHandler(handlerB)
+
+	// 副本退出后数据库中遗留的在线 Agent，注册表中没有记录
+	old := time.Now().Add(-time.Hour)
+	replicaA.db.CreateAgent(&common.Agent{ID: "orphan", Hostname: "orphan", Status: common.AgentStatusOnline, LastSeen: &old})
+	// 刚连接的 Agent 不应被处理
+	connectAgent(t, replicaB, "host-1")
+
+	replicaA.CheckHealth()
+	replicaB.CheckHealth()
+
+	if agent, _ := replicaA.db.GetAgent("orphan"); agent.Status != common.AgentStatusOffline {
+		t.Errorf("orphan status = %s, want offline", agent.Status)
+	}
+	if agent, _ := replicaA.db.GetAgent("host-1"); agent.Status != common.AgentStatusOnline {
+		t.Errorf("host-1 status = %s, want online", agent.Status)
+	}
+	if total := len(handlerA.offline) + len(handlerB.offline); total != 1 {
+		t.Errorf("orphan should be handled by exactly one replica, got %v and %v", handlerA.offline, handlerB.offline)
+	}
+}
+
+func TestFlappingDetection(t *testing.T) {
+	replicaA, _ := newReplicas(t)
+	replicaA.health.FlapThreshold = 2
+
+	for i := 0; i < 2; i++ {
+		connectAgent(t, replicaA, "host-1")
+		replicaA.UnregisterAgent("host-1")
+	}
+
+	history, err := replicaA.ConnectionHistory("host-1", 10)
+	if err != nil {
+		t.Fatalf("ConnectionHistory failed: %v", err)
+	}
+	if !history.Flapping || history.DisconnectsInWindow != 2 || len(history.Events) != 4 {
+		t.Errorf("unexpected history: flapping=%v disconnects=%d events=%d",
+			history.Flapping, history.DisconnectsInWindow, len(history.Events))
+	}
+}
+
+func TestUnregisterKeepsAgentOnlineOnOtherReplica(t *testing.T) {
+	replicaA, replicaB := newReplicas(t)
+	handler := &recordingHandler{}
+	replicaA.SetTaskHandler(handler)
+
+	connectAgent(t, replicaA, "host-1")
+	connectAgent(t, replicaB, "host-1")
+	// 旧副本上遗留的连接随后断开
+	replicaA.UnregisterAgent("host-1")
+
+	if agent, _ := replicaA.db.GetAgent("host-1"); agent.Status != common.AgentStatusOnline {
+		t.Errorf("agent reconnected to replica B should stay online, got %s", agent.Status)
+	}
+	if len(handler.offline) != 0 {
+		t.Errorf("in-flight tasks should not be handled, got %v", handler.offline)
+	}
+}
+
+func TestDisconnectDefersInFlightTasks(t *testing.T) {
+	replicaA, _ := newReplicas(t)
+	handler := &recordingHandler{}
+	replicaA.SetTaskHandler(handler)
+	connectAgent(t, replicaA, "host-1")
+	replicaA.UnregisterAgent("host-1")
+
+	// 宽限期内：连接已注销，但 Agent 仍视为在线，在途任务不处理
+	replicaA.CheckHealth()
+	if agent, _ := replicaA.db.GetAgent("host-1"); agent.Status != common.AgentStatusOnline {
+		t.Errorf("agent status within grace period = %s, want online", agent.Status)
+	}
+	if len(handler.offline) != 0 {
+		t.Errorf("in-flight tasks should not be handled on disconnect, got %v", handler.offline)
+	}
+
+	// 心跳超时后由健康检查标记为离线并处理在途任务
+	old := time.Now().Add(-time.Hour)
+	replicaA.db.GetDB().Model(&common.Agent{}).Where("id = ?", "host-1").Update("last_seen", old)
+	replicaA.CheckHealth()
+	replicaA.CheckHealth()
+	if agent, _ := replicaA.db.GetAgent("host-1"); agent.Status != common.AgentStatusOffline {
+		t.Errorf("agent status after grace period = %s, want offline", agent.Status)
+	}
+	if len(handler.offline) != 1 || handler.offline[0] != "host-1" {
+		t.Errorf("handler offline calls = %v, want [host-1]", handler.offline)
+	}
+
+	// 断开只计一次，不再补记心跳超时
+	history, err := replicaA.ConnectionHistory("host-1", 10)
+	if err != nil {
+		t.Fatalf("ConnectionHistory failed: %v", err)
+	}
+	if history.DisconnectsInWindow != 1 || len(history.Events) != 2 || history.Events[0].Event != common.AgentConnEventDisconnected {
+		t.Errorf("unexpected history: %+v", history.Events)
+	}
+}
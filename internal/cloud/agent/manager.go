@@ -1,36 +1,139 @@
 package agent
 
 import (
+	"encoding/json"
+	"log"
 	"sync"
 	"time"
 
+	"github.com/cloud-agent/internal/cloud/cluster"
+	"github.com/cloud-agent/internal/cloud/notify"
 	"github.com/cloud-agent/internal/cloud/storage"
 	"github.com/cloud-agent/internal/common"
 )
 
 // Manager Agent 管理器
+// connections 只保存当前副本持有的连接；其他副本上的 Agent 通过 cluster 注册表查询，
+// 发往这些 Agent 的消息经消息总线转发给持有连接的副本。
 type Manager struct {
 	db             *storage.Database
+	cluster        *cluster.Cluster
 	connections    map[string]*common.WSConnection // agentID -> connection
 	agents         map[string]*common.Agent        // agentID -> agent
 	mu             sync.RWMutex
 	messageHandler func(agentID string, msgType string, data interface{})
+	unsubscribe    func()
+	notifier       *notify.Notifier
+	taskHandler    TaskHandler
+	secretsPusher  func(agentID string)
+	health         *HealthConfig
+	lastPurge      time.Time
+	stopCh         chan struct{}
+	stopOnce       sync.Once
+	wg             sync.WaitGroup
 }
 
-// NewManager 创建 Agent 管理器
-func NewManager(db *storage.Database, messageHandler func(agentID string, msgType string, data interface{})) *Manager {
-	return &Manager{
+// SetNotifier 设置通知器，为 nil 时不发送 Agent 离线通知
+func (m *Manager) SetNotifier(n *notify.Notifier) {
+	m.notifier = n
+}
+
+// NewManager 创建 Agent 管理器，cl 为 nil 时使用单副本集群
+func NewManager(db *storage.Database, cl *cluster.Cluster, messageHandler func(agentID string, msgType string, data interface{})) *Manager {
+	if cl == nil {
+		cl = cluster.NewLocal("")
+	}
+
+	m := &Manager{
 		db:             db,
+		cluster:        cl,
 		connections:    make(map[string]*common.WSConnection),
 		agents:         make(map[string]*common.Agent),
 		messageHandler: messageHandler,
+		health:         DefaultHealthConfig(),
+		stopCh:         make(chan struct{}),
+	}
+
+	// 订阅本副本的私有主题，接收其他副本转发给本地 Agent 的消息
+	unsubscribe, err := cl.Bus.Subscribe(cluster.ReplicaTopic(cl.ReplicaID), m.handleEnvelope)
+	if err != nil {
+		log.Printf("[cluster] failed to subscribe replica topic: %v", err)
+	} else {
+		m.unsubscribe = unsubscribe
+	}
+
+	return m
+}
+
+// ReplicaID 返回当前副本 ID
+func (m *Manager) ReplicaID() string {
+	return m.cluster.ReplicaID
+}
+
+// handleEnvelope 处理其他副本发来的消息
+func (m *Manager) handleEnvelope(env *cluster.Envelope) {
+	switch env.Kind {
+	case cluster.KindAgentSend:
+		if env.Message == nil {
+			return
+		}
+		conn, exists := m.GetConnection(env.AgentID)
+		if !exists {
+			log.Printf("[cluster] agent %s is not connected to replica %s, dropping %s from %s",
+				env.AgentID, m.cluster.ReplicaID, env.Message.Type, env.Source)
+			return
+		}
+		if err := conn.WriteMessage(env.Message); err != nil {
+			log.Printf("[cluster] failed to deliver %s to agent %s: %v", env.Message.Type, env.AgentID, err)
+		}
+	case cluster.KindSecretsSync:
+		m.mu.RLock()
+		pusher := m.secretsPusher
+		m.mu.RUnlock()
+		if _, exists := m.GetConnection(env.AgentID); exists && pusher != nil {
+			pusher(env.AgentID)
+		}
+	case cluster.KindAgentDisconnect:
+		// Agent 已在其他副本重新连接，关闭本地遗留的旧连接（不注销注册表记录）
+		m.mu.Lock()
+		if conn, exists := m.connections[env.AgentID]; exists {
+			conn.Close()
+			delete(m.connections, env.AgentID)
+			delete(m.agents, env.AgentID)
+		}
+		m.mu.Unlock()
+	}
+}
+
+// Close 停止接收集群消息和健康检查
+func (m *Manager) Close() {
+	if m.unsubscribe != nil {
+		m.unsubscribe()
 	}
+	m.stopOnce.Do(func() { close(m.stopCh) })
+	m.wg.Wait()
 }
 
 // RegisterAgent 注册 Agent，返回实际的 agentID
+// 注册成功后记录连接事件，并下发 Agent 离线期间重新排队的任务
 func (m *Manager) RegisterAgent(agentID string, conn *common.WSConnection, data *common.AgentRegisterData, protocol string) (string, error) {
+	registeredAt := time.Now()
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	agentID, err := m.registerLocked(agentID, conn, data, protocol)
+	m.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	m.recordConnectionEvent(agentID, common.AgentConnEventConnected, "")
+	if m.taskHandler != nil {
+		m.taskHandler.HandleAgentOnline(agentID, registeredAt)
+	}
+	return agentID, nil
+}
+
+// registerLocked 注册 Agent，调用方需持有写锁
+func (m *Manager) registerLocked(agentID string, conn *common.WSConnection, data *common.AgentRegisterData, protocol string) (string, error) {
 
 	// 根据 env-主机名查找或创建 Agent（保证唯一性）
 	var agent *common.Agent
@@ -39,6 +142,13 @@ func (m *Manager) RegisterAgent(agentID string, conn *common.WSConnection, data
 	// 使用 env（可能为空字符串）
 	env := data.Env
 
+	metadata := ""
+	if len(data.Metadata) > 0 {
+		if b, err := json.Marshal(data.Metadata); err == nil {
+			metadata = string(b)
+		}
+	}
+
 	// 先尝试根据 env-主机名查找
 	agent, err = m.db.GetAgentByEnvHostname(env, data.Hostname)
 	if err != nil {
@@ -49,15 +159,17 @@ func (m *Manager) RegisterAgent(agentID string, conn *common.WSConnection, data
 			agentID = env + "-" + data.Hostname
 		}
 		agent = &common.Agent{
-			ID:       agentID,
-			Name:     data.Name,
-			Hostname: data.Hostname,
-			IP:       data.IP,
-			Version:  data.Version,
-			Env:      env,
-			Protocol: protocol,
-			Status:   common.AgentStatusOnline,
-			LastSeen: &[]time.Time{time.Now()}[0],
+			ID:           agentID,
+			Name:         data.Name,
+			Hostname:     data.Hostname,
+			IP:           data.IP,
+			Version:      data.Version,
+			Env:          env,
+			Protocol:     protocol,
+			Metadata:     metadata,
+			Status:       common.AgentStatusOnline,
+			LastSeen:     &[]time.Time{time.Now()}[0],
+			Capabilities: data.Capabilities,
 		}
 		if err := m.db.CreateAgent(agent); err != nil {
 			return "", err
@@ -71,6 +183,11 @@ func (m *Manager) RegisterAgent(agentID string, conn *common.WSConnection, data
 		agent.Version = data.Version
 		agent.Env = env
 		agent.Protocol = protocol
+		if metadata != "" {
+			agent.Metadata = metadata
+		}
+		// 每次注册以 Agent 上报为准，升级或回退版本后能力随之更新
+		agent.Capabilities = data.Capabilities
 		agent.Status = common.AgentStatusOnline
 		now := time.Now()
 		agent.LastSeen = &now
@@ -87,6 +204,18 @@ func (m *Manager) RegisterAgent(agentID string, conn *common.WSConnection, data
 	m.connections[agentID] = conn
 	m.agents[agentID] = agent
 
+	// 登记到集群注册表；如果 Agent 之前连接在其他副本，通知旧副本关闭遗留连接
+	previous, err := m.cluster.Registry.Register(agentID, m.cluster.ReplicaID)
+	if err != nil {
+		log.Printf("[cluster] failed to register agent %s: %v", agentID, err)
+	} else if previous != "" && previous != m.cluster.ReplicaID {
+		env := m.cluster.NewEnvelope(cluster.KindAgentDisconnect)
+		env.AgentID = agentID
+		if err := m.cluster.Bus.Publish(cluster.ReplicaTopic(previous), env); err != nil {
+			log.Printf("[cluster] failed to notify replica %s about agent %s: %v", previous, agentID, err)
+		}
+	}
+
 	return agentID, nil
 }
 
@@ -138,21 +267,65 @@ func (m *Manager) UpdateAgent(agentID string, updates map[string]interface{}) (*
 	return agent, nil
 }
 
+// UpdateCapabilities 更新 Agent 的执行器能力，Agent 热加载插件配置后调用
+func (m *Manager) UpdateCapabilities(agentID string, capabilities []common.AgentCapability) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	agent, exists := m.agents[agentID]
+	if !exists {
+		var err error
+		agent, err = m.db.GetAgent(agentID)
+		if err != nil {
+			return err
+		}
+		m.agents[agentID] = agent
+	}
+	agent.Capabilities = capabilities
+	return m.db.UpdateAgent(agent)
+}
+
 // UnregisterAgent 注销 Agent
+// 数据库中的状态保持在线，心跳超时后由健康检查标记为离线并处理在途任务，Agent 在此之前重新连接时任务继续执行
 func (m *Manager) UnregisterAgent(agentID string) {
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	disconnected := m.unregisterLocked(agentID, common.AgentConnEventDisconnected)
+	m.mu.Unlock()
+	m.agentDisconnected(disconnected)
+}
 
+// UnregisterConnection 连接断开时注销对应的 Agent（如果该连接仍是 Agent 的当前连接）
+func (m *Manager) UnregisterConnection(conn *common.WSConnection) {
+	var disconnected *common.Agent
+	m.mu.Lock()
+	for agentID, c := range m.connections {
+		if c == conn {
+			disconnected = m.unregisterLocked(agentID, common.AgentConnEventDisconnected)
+			break
+		}
+	}
+	m.mu.Unlock()
+	m.agentDisconnected(disconnected)
+}
+
+// unregisterLocked 关闭本副本上 Agent 的连接并记录连接事件 event，调用方需持有写锁
+// 返回被注销的 Agent；Agent 不在本副本上时返回 nil
+func (m *Manager) unregisterLocked(agentID, event string) *common.Agent {
 	if conn, exists := m.connections[agentID]; exists {
 		conn.Close()
 		delete(m.connections, agentID)
+		if err := m.cluster.Registry.Unregister(agentID, m.cluster.ReplicaID); err != nil {
+			log.Printf("[cluster] failed to unregister agent %s: %v", agentID, err)
+		}
 	}
 
-	if agent, exists := m.agents[agentID]; exists {
-		agent.Status = common.AgentStatusOffline
-		m.db.UpdateAgent(agent)
-		delete(m.agents, agentID)
+	agent, exists := m.agents[agentID]
+	if !exists {
+		return nil
 	}
+	delete(m.agents, agentID)
+	m.recordConnectionEvent(agentID, event, "")
+	return agent
 }
 
 // DeleteAgent 删除 Agent
@@ -164,6 +337,7 @@ func (m *Manager) DeleteAgent(agentID string) error {
 	if conn, exists := m.connections[agentID]; exists {
 		conn.Close()
 		delete(m.connections, agentID)
+		m.cluster.Registry.Unregister(agentID, m.cluster.ReplicaID)
 	}
 
 	// 从内存中移除
@@ -181,18 +355,47 @@ func (m *Manager) GetConnection(agentID string) (*common.WSConnection, bool) {
 	return conn, exists
 }
 
+// AgentIDForConnection 返回通过该连接注册的 Agent ID
+func (m *Manager) AgentIDForConnection(conn *common.WSConnection) (string, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for agentID, c := range m.connections {
+		if c == conn {
+			return agentID, true
+		}
+	}
+	return "", false
+}
+
+// IsOnline 检查 Agent 是否在线（连接在本副本或集群中的其他副本）
+func (m *Manager) IsOnline(agentID string) bool {
+	if _, exists := m.GetConnection(agentID); exists {
+		return true
+	}
+	_, ok, err := m.cluster.Registry.Lookup(agentID)
+	if err != nil {
+		log.Printf("[cluster] failed to lookup agent %s: %v", agentID, err)
+		return false
+	}
+	return ok
+}
+
 // GetAgentStatus 获取 Agent 状态
 func (m *Manager) GetAgentStatus(agentID string) string {
 	m.mu.RLock()
-	defer m.mu.RUnlock()
-	if agent, exists := m.agents[agentID]; exists {
+	agent, exists := m.agents[agentID]
+	m.mu.RUnlock()
+	if exists {
 		return string(agent.Status)
 	}
+	if m.IsOnline(agentID) {
+		return string(common.AgentStatusOnline)
+	}
 	return string(common.AgentStatusOffline)
 }
 
-// UpdateHeartbeat 更新心跳
-func (m *Manager) UpdateHeartbeat(agentID string) {
+// UpdateHeartbeat 更新心跳，telemetry 为 Agent 上报的资源快照（旧版本 Agent 为 nil）
+func (m *Manager) UpdateHeartbeat(agentID string, telemetry *common.AgentTelemetry) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -200,15 +403,22 @@ func (m *Manager) UpdateHeartbeat(agentID string) {
 		now := time.Now()
 		agent.LastSeen = &now
 		agent.Status = common.AgentStatusOnline
-		m.db.UpdateAgentStatus(agentID, common.AgentStatusOnline)
+		if telemetry != nil {
+			agent.Telemetry = telemetry
+		}
+		if err := m.db.UpdateAgentHeartbeat(agentID, telemetry); err != nil {
+			log.Printf("Failed to save heartbeat of agent %s: %v", agentID, err)
+		}
+		m.cluster.Registry.Touch(agentID, m.cluster.ReplicaID)
 	}
 }
 
 // SendMessage 向 Agent 发送消息
+// Agent 连接在其他副本时，通过消息总线转发给持有连接的副本
 func (m *Manager) SendMessage(agentID string, msg *common.Message) error {
 	conn, exists := m.GetConnection(agentID)
 	if !exists {
-		return common.NewError("agent not connected")
+		return m.forwardMessage(agentID, msg)
 	}
 
 	if conn.IsClosed() {
@@ -219,6 +429,94 @@ func (m *Manager) SendMessage(agentID string, msg *common.Message) error {
 	return conn.WriteMessage(msg)
 }
 
+// SendLocalMessage 向连接在当前副本的 Agent 发送消息，不经过消息总线转发
+// 用于发送密钥等不应写入消息总线的内容
+func (m *Manager) SendLocalMessage(agentID string, msg *common.Message) error {
+	conn, exists := m.GetConnection(agentID)
+	if !exists {
+		return common.NewError("agent not connected to this replica")
+	}
+	return conn.WriteMessage(msg)
+}
+
+// SetSecretsPusher 设置向本副本上的 Agent 下发密钥的函数
+func (m *Manager) SetSecretsPusher(pusher func(agentID string)) {
+	m.mu.Lock()
+	m.secretsPusher = pusher
+	m.mu.Unlock()
+}
+
+// SyncSecrets 让持有 Agent 连接的副本下发密钥
+func (m *Manager) SyncSecrets(agentID string) error {
+	if _, exists := m.GetConnection(agentID); exists {
+		m.mu.RLock()
+		pusher := m.secretsPusher
+		m.mu.RUnlock()
+		if pusher != nil {
+			pusher(agentID)
+		}
+		return nil
+	}
+
+	replicaID, ok, err := m.cluster.Registry.Lookup(agentID)
+	if err != nil {
+		return common.NewErrorf("failed to lookup agent connection: %v", err)
+	}
+	if !ok || replicaID == m.cluster.ReplicaID {
+		return common.NewError("agent not connected")
+	}
+	env := m.cluster.NewEnvelope(cluster.KindSecretsSync)
+	env.AgentID = agentID
+	return m.cluster.Bus.Publish(cluster.ReplicaTopic(replicaID), env)
+}
+
+// forwardMessage 把消息转发给持有 Agent 连接的副本
+func (m *Manager) forwardMessage(agentID string, msg *common.Message) error {
+	replicaID, ok, err := m.cluster.Registry.Lookup(agentID)
+	if err != nil {
+		return common.NewErrorf("failed to lookup agent connection: %v", err)
+	}
+	if !ok || replicaID == m.cluster.ReplicaID {
+		return common.NewError("agent not connected")
+	}
+
+	env := m.cluster.NewEnvelope(cluster.KindAgentSend)
+	env.AgentID = agentID
+	env.Message = msg
+	return m.cluster.Bus.Publish(cluster.ReplicaTopic(replicaID), env)
+}
+
+// ConnectedAgentsByEnv 按环境统计连接到本副本的 Agent 数
+func (m *Manager) ConnectedAgentsByEnv() map[string]int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	counts := make(map[string]int)
+	for agentID := range m.connections {
+		env := ""
+		if agent, ok := m.agents[agentID]; ok {
+			env = agent.Env
+		}
+		counts[env]++
+	}
+	return counts
+}
+
+// FindAgents 返回符合选择条件的在线 Agent
+func (m *Manager) FindAgents(sel *Selector) ([]*common.Agent, error) {
+	agents, err := m.ListAgents()
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []*common.Agent
+	for _, agent := range agents {
+		if agent.Status == common.AgentStatusOnline && sel.Matches(agent) {
+			matched = append(matched, agent)
+		}
+	}
+	return matched, nil
+}
+
 // ListAgents 列出所有 Agent（从数据库查询，并根据连接状态更新）
 func (m *Manager) ListAgents() ([]*common.Agent, error) {
 	// 从数据库查询所有 agents
@@ -235,6 +533,15 @@ func (m *Manager) ListAgents() ([]*common.Agent, error) {
 	}
 	m.mu.RUnlock()
 
+	// 合并集群中其他副本持有的连接
+	if online, err := m.cluster.Registry.Online(); err == nil {
+		for agentID := range online {
+			connectedAgents[agentID] = true
+		}
+	} else {
+		log.Printf("[cluster] failed to list online agents: %v", err)
+	}
+
 	// 更新状态并返回
 	now := time.Now()
 	for _, agent := range dbAgents {
@@ -253,8 +560,8 @@ func (m *Manager) ListAgents() ([]*common.Agent, error) {
 		} else {
 			// 无连接，检查最后活跃时间
 			if agent.LastSeen != nil {
-				// 如果超过 2 分钟没有心跳，标记为离线
-				if now.Sub(*agent.LastSeen) > 2*time.Minute {
+				// 如果超过心跳超时时间没有心跳，标记为离线
+				if now.Sub(*agent.LastSeen) > m.health.HeartbeatTimeout {
 					agent.Status = common.AgentStatusOffline
 				} else {
 					agent.Status = common.AgentStatusOnline
@@ -0,0 +1,113 @@
+package agent
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloud-agent/internal/cloud/cluster"
+	"github.com/cloud-agent/internal/cloud/storage"
+	"github.com/cloud-agent/internal/common"
+	"github.com/gorilla/websocket"
+)
+
+// newReplicas 创建两个共享数据库、消息总线和注册表的副本
+func newReplicas(t *testing.T) (*Manager, *Manager) {
+	t.Helper()
+	db, err := storage.NewDatabaseWithConfig(&storage.Config{
+		DSN:      filepath.Join(t.TempDir(), "cloud.db"),
+		LogLevel: "silent",
+	})
+	if err != nil {
+		t.Fatalf("NewDatabaseWithConfig failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	bus := cluster.NewMemoryBus()
+	registry := cluster.NewMemoryRegistry(cluster.DefaultAgentTTL)
+	t.Cleanup(func() { bus.Close() })
+
+	a := NewManager(db, &cluster.Cluster{ReplicaID: "replica-a", Bus: bus, Registry: registry}, nil)
+	b := NewManager(db, &cluster.Cluster{ReplicaID: "replica-b", Bus: bus, Registry: registry}, nil)
+	return a, b
+}
+
+// connectAgent 模拟 Agent 通过 WebSocket 连接到指定副本，返回 Agent 端的原始连接
+func connectAgent(t *testing.T, m *Manager, hostname string) *websocket.Conn {
+	t.Helper()
+	upgrader := websocket.Upgrader{}
+	registered := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		wsConn := common.NewWSConnection(conn)
+		wsConn.Start()
+		if _, err := m.RegisterAgent("", wsConn, &common.AgentRegisterData{Name: hostname, Hostname: hostname}, "ws"); err != nil {
+			t.Errorf("RegisterAgent failed: %v", err)
+		}
+		close(registered)
+	}))
+	t.Cleanup(srv.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	<-registered
+	return conn
+}
+
+func TestSendMessageRoutesToOwningReplica(t *testing.T) {
+	replicaA, replicaB := newReplicas(t)
+	agentConn := connectAgent(t, replicaB, "host-1")
+
+	if _, local := replicaA.GetConnection("host-1"); local {
+		t.Fatal("agent should not be connected to replica A")
+	}
+	if !replicaA.IsOnline("host-1") {
+		t.Fatal("replica A should see agent connected to replica B as online")
+	}
+
+	msg := common.NewMessage(common.MessageTypeTaskCancel, map[string]interface{}{"task_id": "t1"})
+	if err := replicaA.SendMessage("host-1", msg); err != nil {
+		t.Fatalf("SendMessage via replica A failed: %v", err)
+	}
+
+	agentConn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	var got common.Message
+	if err := agentConn.ReadJSON(&got); err != nil {
+		t.Fatalf("agent did not receive routed message: %v", err)
+	}
+	if got.Type != common.MessageTypeTaskCancel {
+		t.Errorf("agent received %s, want %s", got.Type, common.MessageTypeTaskCancel)
+	}
+}
+
+func TestSendMessageToOfflineAgent(t *testing.T) {
+	replicaA, _ := newReplicas(t)
+	if replicaA.IsOnline("missing") {
+		t.Fatal("unknown agent should be offline")
+	}
+	if err := replicaA.SendMessage("missing", common.NewMessage(common.MessageTypeTaskCancel, nil)); err == nil {
+		t.Error("SendMessage to offline agent should fail")
+	}
+}
+
+func TestListAgentsIncludesRemoteConnections(t *testing.T) {
+	replicaA, replicaB := newReplicas(t)
+	connectAgent(t, replicaB, "host-1")
+
+	agents, err := replicaA.ListAgents()
+	if err != nil {
+		t.Fatalf("ListAgents failed: %v", err)
+	}
+	if len(agents) != 1 || agents[0].Status != common.AgentStatusOnline {
+		t.Errorf("ListAgents = %+v, want host-1 online", agents)
+	}
+}
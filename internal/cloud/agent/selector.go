@@ -0,0 +1,64 @@
+package agent
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/cloud-agent/internal/common"
+)
+
+// Selector Agent 选择条件，各条件同时满足才匹配，空条件不限制
+type Selector struct {
+	Env        string   `json:"env,omitempty"`        // Agent 所在环境（K8s 集群名称）
+	Tags       []string `json:"tags,omitempty"`       // 必须包含的全部标签
+	Hostname   string   `json:"hostname,omitempty"`   // 主机名通配符，如 web-*
+	Capability string   `json:"capability,omitempty"` // 要求的能力，格式为 "type" 或 "type:connection"
+}
+
+// Empty 是否没有任何条件
+func (s *Selector) Empty() bool {
+	return s.Env == "" && len(s.Tags) == 0 && s.Hostname == "" && s.Capability == ""
+}
+
+// Validate 检查主机名通配符是否合法
+func (s *Selector) Validate() error {
+	if s.Hostname != "" {
+		if _, err := path.Match(s.Hostname, ""); err != nil {
+			return fmt.Errorf("invalid hostname pattern %q: %w", s.Hostname, err)
+		}
+	}
+	return nil
+}
+
+// Matches Agent 是否满足选择条件，未上报能力的旧版本 Agent 不匹配任何能力要求
+func (s *Selector) Matches(agent *common.Agent) bool {
+	if s.Env != "" && agent.Env != s.Env {
+		return false
+	}
+	for _, tag := range s.Tags {
+		if !slices.Contains(agent.Tags, tag) {
+			return false
+		}
+	}
+	if s.Hostname != "" {
+		if ok, _ := path.Match(s.Hostname, agent.Hostname); !ok {
+			return false
+		}
+	}
+	if s.Capability != "" {
+		taskType, connection := common.ParseCapability(s.Capability)
+		if !agent.CapabilitiesKnown() || !agent.HasCapability(taskType, connection) {
+			return false
+		}
+	}
+	return true
+}
+
+// String 选择条件的规范表示，条件相同的选择器结果相同，用作轮询和粘滞调度的默认键
+func (s *Selector) String() string {
+	tags := slices.Clone(s.Tags)
+	slices.Sort(tags)
+	return fmt.Sprintf("env=%s;tags=%s;hostname=%s;capability=%s", s.Env, strings.Join(tags, ","), s.Hostname, s.Capability)
+}
@@ -0,0 +1,321 @@
+// Package agentconfig 管理下发给 Agent 的插件和安全配置
+//
+// 配置按类型（plugins、security）和作用范围（global、env、tag）发布，每次发布生成新版本。
+// Agent 注册、标签变更或有新版本发布时，Cloud 为其选出适用的配置并通过 WebSocket 下发；
+// Agent 校验并应用后上报结果，未启用远程配置的 Agent 上报 disabled 并继续使用本地配置文件。
+package agentconfig
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/cloud-agent/internal/cloud/agent"
+	"github.com/cloud-agent/internal/cloud/audit"
+	"github.com/cloud-agent/internal/cloud/storage"
+	"github.com/cloud-agent/internal/common"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrInvalidConfig 配置类型、作用范围或内容不合法
+var ErrInvalidConfig = common.NewError("invalid agent config")
+
+// kinds 支持的配置类型
+var kinds = []string{common.AgentConfigKindPlugins, common.AgentConfigKindSecurity}
+
+// scopePriority 作用范围的优先级，数值越大越优先
+var scopePriority = map[string]int{
+	common.AgentConfigScopeGlobal: 1,
+	common.AgentConfigScopeEnv:    2,
+	common.AgentConfigScopeTag:    3,
+}
+
+// KindStatus Agent 某类配置的期望版本和应用结果
+type KindStatus struct {
+	Kind    string                   `json:"kind"`
+	Desired *common.AgentConfig      `json:"desired"` // 适用的最新配置，nil 表示使用本地配置文件
+	Applied *common.AgentConfigState `json:"applied"` // Agent 最近上报的结果，nil 表示尚未上报
+	InSync  bool                     `json:"in_sync"` // 已应用期望的配置
+}
+
+// Manager Agent 远程配置管理器
+type Manager struct {
+	db       *storage.Database
+	agentMgr *agent.Manager
+	auditor  *audit.Recorder
+}
+
+// NewManager 创建远程配置管理器
+func NewManager(db *storage.Database, agentMgr *agent.Manager) *Manager {
+	return &Manager{db: db, agentMgr: agentMgr}
+}
+
+// SetAuditor 设置审计记录器，为 nil 时不记录审计事件
+func (m *Manager) SetAuditor(r *audit.Recorder) {
+	m.auditor = r
+}
+
+// Publish 校验并发布新版本配置，然后下发给适用的在线 Agent
+func (m *Manager) Publish(cfg *common.AgentConfig) error {
+	if err := validate(cfg); err != nil {
+		return err
+	}
+	sum := sha256.Sum256([]byte(cfg.Content))
+	cfg.Checksum = hex.EncodeToString(sum[:])
+	if err := m.db.CreateAgentConfig(cfg); err != nil {
+		return err
+	}
+	log.Printf("[agentconfig] published %s config %s/%s version %d", cfg.Kind, cfg.ScopeType, cfg.Scope, cfg.Version)
+	m.auditor.Record(&common.AuditEvent{
+		Action:  common.AuditActionConfigPublished,
+		Actor:   cfg.CreatedBy,
+		Outcome: "published",
+		Command: fmt.Sprintf("%s %s v%d", cfg.Kind, scopeString(cfg), cfg.Version),
+		Reason:  cfg.Comment,
+	})
+
+	agents, err := m.agentMgr.FindAgents(&agent.Selector{})
+	if err != nil {
+		return fmt.Errorf("config published but failed to list agents: %w", err)
+	}
+	for _, a := range agents {
+		if !matches(cfg, a) {
+			continue
+		}
+		// 优先级更高的配置仍然适用时下发的是该配置，Agent 按校验和跳过未变化的配置
+		resolved, err := m.Resolve(a)
+		if err != nil {
+			log.Printf("[agentconfig] failed to resolve configs for agent %s: %v", a.ID, err)
+			continue
+		}
+		m.pushKind(a.ID, cfg.Kind, resolved[cfg.Kind])
+	}
+	return nil
+}
+
+// Rollback 将历史版本的内容作为新版本重新发布
+func (m *Manager) Rollback(id uint, createdBy string) (*common.AgentConfig, error) {
+	old, err := m.db.GetAgentConfig(id)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &common.AgentConfig{
+		Kind:      old.Kind,
+		ScopeType: old.ScopeType,
+		Scope:     old.Scope,
+		Content:   old.Content,
+		Comment:   fmt.Sprintf("rollback to version %d", old.Version),
+		CreatedBy: createdBy,
+	}
+	if err := m.Publish(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Resolve 返回适用于 Agent 的各类最新配置，没有适用配置的类型不在结果中
+// 同时适用时 tag 优先于 env，env 优先于 global；多个标签都有配置时使用最近发布的
+func (m *Manager) Resolve(a *common.Agent) (map[string]*common.AgentConfig, error) {
+	configs, err := m.db.LatestAgentConfigs()
+	if err != nil {
+		return nil, err
+	}
+	resolved := make(map[string]*common.AgentConfig)
+	// configs 按发布时间倒序，优先级相同时保留最先遇到的（最近发布的）
+	for _, cfg := range configs {
+		if !matches(cfg, a) {
+			continue
+		}
+		if current, ok := resolved[cfg.Kind]; !ok || scopePriority[cfg.ScopeType] > scopePriority[current.ScopeType] {
+			resolved[cfg.Kind] = cfg
+		}
+	}
+	return resolved, nil
+}
+
+// PushToAgent 将适用的配置下发给 Agent，在 Agent 注册和标签变更后调用
+// 之前应用过远程配置、现在没有适用配置的类型，通知 Agent 恢复使用本地配置文件
+func (m *Manager) PushToAgent(agentID string) error {
+	a, err := m.db.GetAgent(agentID)
+	if err != nil {
+		return err
+	}
+	resolved, err := m.Resolve(a)
+	if err != nil {
+		return err
+	}
+	for _, kind := range kinds {
+		m.pushKind(agentID, kind, resolved[kind])
+	}
+	return nil
+}
+
+// pushKind 下发 Agent 某类配置的期望版本，cfg 为 nil 时通知使用远程配置的 Agent 恢复本地配置
+func (m *Manager) pushKind(agentID, kind string, cfg *common.AgentConfig) {
+	data := &common.AgentConfigData{Kind: kind}
+	if cfg != nil {
+		data.ConfigID = cfg.ID
+		data.Version = cfg.Version
+		data.Checksum = cfg.Checksum
+		data.Content = cfg.Content
+	} else if !m.appliedRemote(agentID, kind) {
+		return
+	}
+
+	if err := m.agentMgr.SendMessage(agentID, common.NewMessage(common.MessageTypeConfigUpdate, data)); err != nil {
+		log.Printf("[agentconfig] failed to push %s config to agent %s: %v", kind, agentID, err)
+	}
+}
+
+// appliedRemote Agent 当前是否使用远程配置
+func (m *Manager) appliedRemote(agentID, kind string) bool {
+	states, err := m.db.ListAgentConfigStates(agentID)
+	if err != nil {
+		return false
+	}
+	for _, state := range states {
+		if state.Kind == kind {
+			return state.ConfigID != 0 && state.Status == common.AgentConfigStatusApplied
+		}
+	}
+	return false
+}
+
+// RecordApplied 保存 Agent 上报的配置应用结果，插件配置生效后更新 Agent 的执行器能力
+func (m *Manager) RecordApplied(agentID string, data *common.AgentConfigAppliedData) error {
+	if !slices.Contains(kinds, data.Kind) {
+		return common.NewErrorf("unknown config kind: %s", data.Kind)
+	}
+	state := &common.AgentConfigState{
+		AgentID:  agentID,
+		Kind:     data.Kind,
+		ConfigID: data.ConfigID,
+		Version:  data.Version,
+		Checksum: data.Checksum,
+		Status:   data.Status,
+		Error:    data.Error,
+	}
+	if err := m.db.SaveAgentConfigState(state); err != nil {
+		return err
+	}
+
+	command := data.Kind + " local"
+	if data.ConfigID != 0 {
+		command = fmt.Sprintf("%s v%d", data.Kind, data.Version)
+	}
+	m.auditor.Record(&common.AuditEvent{
+		Source:  audit.SourceAgent,
+		Action:  common.AuditActionConfigApplied,
+		AgentID: agentID,
+		Outcome: data.Status,
+		Command: command,
+		Reason:  data.Error,
+	})
+
+	// 未启用远程配置的 Agent 重新加载本地插件配置后以 disabled 状态上报新的能力
+	if data.Kind == common.AgentConfigKindPlugins && data.Status != common.AgentConfigStatusFailed && data.Capabilities != nil {
+		if err := m.agentMgr.UpdateCapabilities(agentID, data.Capabilities); err != nil {
+			log.Printf("[agentconfig] failed to update capabilities of agent %s: %v", agentID, err)
+		}
+	}
+	return nil
+}
+
+// Status 返回 Agent 各类配置的期望版本和应用结果
+func (m *Manager) Status(agentID string) ([]*KindStatus, error) {
+	a, err := m.db.GetAgent(agentID)
+	if err != nil {
+		return nil, err
+	}
+	resolved, err := m.Resolve(a)
+	if err != nil {
+		return nil, err
+	}
+	states, err := m.db.ListAgentConfigStates(agentID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*KindStatus, 0, len(kinds))
+	for _, kind := range kinds {
+		status := &KindStatus{Kind: kind, Desired: resolved[kind]}
+		for _, state := range states {
+			if state.Kind == kind {
+				status.Applied = state
+			}
+		}
+		switch {
+		case status.Applied == nil:
+			status.InSync = status.Desired == nil
+		case status.Desired == nil:
+			status.InSync = status.Applied.ConfigID == 0
+		default:
+			status.InSync = status.Applied.Status == common.AgentConfigStatusApplied && status.Applied.ConfigID == status.Desired.ID
+		}
+		result = append(result, status)
+	}
+	return result, nil
+}
+
+// matches 配置是否适用于 Agent
+func matches(cfg *common.AgentConfig, a *common.Agent) bool {
+	switch cfg.ScopeType {
+	case common.AgentConfigScopeGlobal:
+		return true
+	case common.AgentConfigScopeEnv:
+		return a.Env == cfg.Scope
+	case common.AgentConfigScopeTag:
+		return slices.Contains(a.Tags, cfg.Scope)
+	}
+	return false
+}
+
+// scopeString 作用范围的可读表示
+func scopeString(cfg *common.AgentConfig) string {
+	if cfg.ScopeType == common.AgentConfigScopeGlobal {
+		return cfg.ScopeType
+	}
+	return cfg.ScopeType + "=" + cfg.Scope
+}
+
+// validate 检查配置类型、作用范围和 YAML 结构；插件能否创建、正则表达式是否有效等由 Agent 应用时校验
+func validate(cfg *common.AgentConfig) error {
+	if !slices.Contains(kinds, cfg.Kind) {
+		return fmt.Errorf("%w: kind must be one of %s", ErrInvalidConfig, strings.Join(kinds, ", "))
+	}
+	if _, ok := scopePriority[cfg.ScopeType]; !ok {
+		return fmt.Errorf("%w: scope_type must be global, env or tag", ErrInvalidConfig)
+	}
+	if cfg.ScopeType == common.AgentConfigScopeGlobal {
+		cfg.Scope = ""
+	} else if cfg.Scope == "" {
+		return fmt.Errorf("%w: scope is required for scope_type %s", ErrInvalidConfig, cfg.ScopeType)
+	}
+	if strings.TrimSpace(cfg.Content) == "" {
+		return fmt.Errorf("%w: content is empty", ErrInvalidConfig)
+	}
+
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal([]byte(cfg.Content), &doc); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+	if cfg.Kind == common.AgentConfigKindPlugins {
+		plugins, ok := doc["plugins"].([]interface{})
+		if !ok {
+			return fmt.Errorf("%w: plugins config must contain a plugins list", ErrInvalidConfig)
+		}
+		for i, p := range plugins {
+			def, ok := p.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("%w: plugins[%d] must be a mapping", ErrInvalidConfig, i)
+			}
+			if t, _ := def["type"].(string); t == "" {
+				return fmt.Errorf("%w: plugins[%d].type is required", ErrInvalidConfig, i)
+			}
+		}
+	}
+	return nil
+}
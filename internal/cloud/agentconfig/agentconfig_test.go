@@ -0,0 +1,117 @@
+package agentconfig
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloud-agent/internal/cloud/agent"
+	"github.com/cloud-agent/internal/cloud/storage"
+	"github.com/cloud-agent/internal/common"
+)
+
+func newTestManager(t *testing.T) (*Manager, *storage.Database) {
+	t.Helper()
+	db, err := storage.NewDatabaseWithConfig(&storage.Config{
+		DSN:      filepath.Join(t.TempDir(), "cloud.db"),
+		LogLevel: "silent",
+	})
+	if err != nil {
+		t.Fatalf("NewDatabaseWithConfig failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	agentMgr := agent.NewManager(db, nil, nil)
+	t.Cleanup(agentMgr.Close)
+	return NewManager(db, agentMgr), db
+}
+
+const pluginsContent = "plugins:\n  - type: shell\n    enabled: true\n"
+
+func TestPublishValidation(t *testing.T) {
+	m, _ := newTestManager(t)
+	tests := []*common.AgentConfig{
+		{Kind: "unknown", ScopeType: common.AgentConfigScopeGlobal, Content: pluginsContent},
+		{Kind: common.AgentConfigKindPlugins, ScopeType: common.AgentConfigScopeEnv, Content: pluginsContent},
+		{Kind: common.AgentConfigKindPlugins, ScopeType: common.AgentConfigScopeGlobal, Content: "  "},
+		{Kind: common.AgentConfigKindPlugins, ScopeType: common.AgentConfigScopeGlobal, Content: "plugins: [{enabled: true}]"},
+		{Kind: common.AgentConfigKindSecurity, ScopeType: common.AgentConfigScopeGlobal, Content: "a: [b"},
+	}
+	for _, cfg := range tests {
+		if err := m.Publish(cfg); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("Publish(%+v) = %v, want ErrInvalidConfig", cfg, err)
+		}
+	}
+
+	cfg := &common.AgentConfig{Kind: common.AgentConfigKindPlugins, ScopeType: common.AgentConfigScopeGlobal, Scope: "ignored", Content: pluginsContent}
+	if err := m.Publish(cfg); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	if cfg.Version != 1 || cfg.Scope != "" || len(cfg.Checksum) != 64 {
+		t.Errorf("unexpected published config: %+v", cfg)
+	}
+}
+
+func TestResolvePrecedenceAndStatus(t *testing.T) {
+	m, db := newTestManager(t)
+	publish := func(kind, scopeType, scope string) *common.AgentConfig {
+		t.Helper()
+		content := pluginsContent
+		if kind == common.AgentConfigKindSecurity {
+			content = "command_whitelist_enabled: true\n"
+		}
+		cfg := &common.AgentConfig{Kind: kind, ScopeType: scopeType, Scope: scope, Content: content}
+		if err := m.Publish(cfg); err != nil {
+			t.Fatalf("Publish failed: %v", err)
+		}
+		return cfg
+	}
+
+	global := publish(common.AgentConfigKindPlugins, common.AgentConfigScopeGlobal, "")
+	env := publish(common.AgentConfigKindPlugins, common.AgentConfigScopeEnv, "prod")
+	publish(common.AgentConfigKindPlugins, common.AgentConfigScopeTag, "db")
+	tag := publish(common.AgentConfigKindPlugins, common.AgentConfigScopeTag, "edge")
+	security := publish(common.AgentConfigKindSecurity, common.AgentConfigScopeEnv, "prod")
+
+	tests := []struct {
+		agent    *common.Agent
+		plugins  uint
+		security uint
+	}{
+		{&common.Agent{ID: "dev", Env: "dev"}, global.ID, 0},
+		{&common.Agent{ID: "prod", Env: "prod"}, env.ID, security.ID},
+		// 多个标签都有配置时使用最近发布的
+		{&common.Agent{ID: "tagged", Env: "prod", Tags: []string{"db", "edge"}}, tag.ID, security.ID},
+	}
+	for _, tt := range tests {
+		resolved, err := m.Resolve(tt.agent)
+		if err != nil {
+			t.Fatalf("Resolve failed: %v", err)
+		}
+		if cfg := resolved[common.AgentConfigKindPlugins]; cfg == nil || cfg.ID != tt.plugins {
+			t.Errorf("agent %s plugins config = %+v, want id %d", tt.agent.ID, cfg, tt.plugins)
+		}
+		cfg := resolved[common.AgentConfigKindSecurity]
+		if (tt.security == 0) != (cfg == nil) || (cfg != nil && cfg.ID != tt.security) {
+			t.Errorf("agent %s security config = %+v, want id %d", tt.agent.ID, cfg, tt.security)
+		}
+	}
+
+	db.CreateAgent(&common.Agent{ID: "prod", Env: "prod"})
+	err := m.RecordApplied("prod", &common.AgentConfigAppliedData{
+		Kind: common.AgentConfigKindPlugins, ConfigID: env.ID, Version: env.Version, Status: common.AgentConfigStatusApplied,
+		Capabilities: []common.AgentCapability{{Type: common.TaskTypeShell}},
+	})
+	if err != nil {
+		t.Fatalf("RecordApplied failed: %v", err)
+	}
+	status, err := m.Status("prod")
+	if err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	if len(status) != 2 || !status[0].InSync || status[1].InSync || status[1].Desired.ID != security.ID {
+		t.Errorf("unexpected status: plugins=%+v security=%+v", status[0], status[1])
+	}
+	if a, _ := db.GetAgent("prod"); len(a.Capabilities) != 1 {
+		t.Errorf("capabilities should be updated after plugins are applied, got %+v", a.Capabilities)
+	}
+}
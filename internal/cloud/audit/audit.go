@@ -0,0 +1,250 @@
+// Package audit 记录审计事件到只追加的审计表，并支持导出
+package audit
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloud-agent/internal/cloud/storage"
+	"github.com/cloud-agent/internal/common"
+)
+
+// 事件来源
+const (
+	SourceCloud = "cloud"
+	SourceAgent = "agent"
+)
+
+// 导出格式
+const (
+	FormatJSONL = "jsonl"
+	FormatCSV   = "csv"
+)
+
+// csvHeader CSV 导出的列
+var csvHeader = []string{"id", "timestamp", "action", "actor", "source", "agent_id", "task_id", "task_type", "outcome", "command", "reason", "duration_ms", "prev_hash", "hash"}
+
+// Recorder 审计事件记录器
+// 所有方法都可以在 nil 上调用，未启用审计时不做任何处理
+type Recorder struct {
+	db *storage.Database
+}
+
+// NewRecorder 创建审计事件记录器
+func NewRecorder(db *storage.Database) *Recorder {
+	return &Recorder{db: db}
+}
+
+// Record 写入审计事件，写入失败时记录日志（审计不影响业务流程）
+func (r *Recorder) Record(e *common.AuditEvent) {
+	if r == nil {
+		return
+	}
+	if e.Source == "" {
+		e.Source = SourceCloud
+	}
+	if err := r.db.AppendAuditEvent(e); err != nil {
+		log.Printf("[audit] failed to record %s event for task %s: %v", e.Action, e.TaskID, err)
+	}
+}
+
+// TaskSubmitted 记录任务提交
+func (r *Recorder) TaskSubmitted(task *common.Task) {
+	r.Record(&common.AuditEvent{
+		Action:   common.AuditActionTaskSubmitted,
+		Actor:    task.CreatedBy,
+		AgentID:  task.AgentID,
+		TaskID:   task.ID,
+		TaskType: task.Type,
+		Command:  task.Command,
+	})
+}
+
+// TaskCanceled 记录任务取消
+func (r *Recorder) TaskCanceled(task *common.Task) {
+	r.Record(&common.AuditEvent{
+		Action:   common.AuditActionTaskCanceled,
+		Actor:    task.CreatedBy,
+		AgentID:  task.AgentID,
+		TaskID:   task.ID,
+		TaskType: task.Type,
+		Outcome:  string(common.TaskStatusCanceled),
+	})
+}
+
+// TaskCompleted 记录任务结束及结果（耗时从开始执行计算，未开始时从创建计算）
+func (r *Recorder) TaskCompleted(task *common.Task) {
+	if r == nil {
+		return
+	}
+	e := &common.AuditEvent{
+		Action:   common.AuditActionTaskCompleted,
+		Actor:    task.CreatedBy,
+		AgentID:  task.AgentID,
+		TaskID:   task.ID,
+		TaskType: task.Type,
+		Outcome:  string(task.Status),
+		Reason:   task.Error,
+	}
+	start := task.CreatedAt
+	if task.StartedAt != nil {
+		start = *task.StartedAt
+	}
+	end := time.Now()
+	if task.FinishedAt != nil {
+		end = *task.FinishedAt
+	}
+	if !start.IsZero() && end.After(start) {
+		e.DurationMs = end.Sub(start).Milliseconds()
+	}
+	r.Record(e)
+}
+
+// Approval 记录审批决定
+func (r *Recorder) Approval(task *common.Task, approver string, approved bool, reason string) {
+	outcome := common.AuditOutcomeRejected
+	if approved {
+		outcome = common.AuditOutcomeApproved
+	}
+	r.Record(&common.AuditEvent{
+		Action:   common.AuditActionApproval,
+		Actor:    approver,
+		AgentID:  task.AgentID,
+		TaskID:   task.ID,
+		TaskType: task.Type,
+		Outcome:  outcome,
+		Reason:   reason,
+	})
+}
+
+// PolicyDenied 记录被任务策略拒绝的任务（任务未创建，没有任务 ID）
+func (r *Recorder) PolicyDenied(agentID, user string, taskType common.TaskType, command, reason string) {
+	r.Record(&common.AuditEvent{
+		Action:   common.AuditActionPolicyDenied,
+		Actor:    user,
+		AgentID:  agentID,
+		TaskType: taskType,
+		Outcome:  common.AuditOutcomeDenied,
+		Command:  command,
+		Reason:   reason,
+	})
+}
+
+// AgentEvent 记录 Agent 上报的审计事件
+// agentID 取自上报事件的连接而不是消息内容，操作人取自任务创建者
+func (r *Recorder) AgentEvent(agentID string, data *common.AuditEventData) {
+	if r == nil {
+		return
+	}
+	e := &common.AuditEvent{
+		Source:     SourceAgent,
+		Action:     data.Action,
+		AgentID:    agentID,
+		TaskID:     data.TaskID,
+		TaskType:   data.TaskType,
+		Outcome:    data.Outcome,
+		Command:    data.Command,
+		Reason:     data.Reason,
+		DurationMs: data.DurationMs,
+	}
+	if data.Timestamp > 0 {
+		e.Timestamp = time.Unix(data.Timestamp, 0)
+	}
+	if data.TaskID != "" {
+		if task, err := r.db.GetTask(data.TaskID); err == nil {
+			e.Actor = task.CreatedBy
+			if e.TaskType == "" {
+				e.TaskType = task.Type
+			}
+		}
+	}
+	r.Record(e)
+}
+
+// Export 按过滤条件导出审计事件（按 ID 升序），返回导出的事件数
+// filter.Limit 为每批读取的数量，导出不受分页限制
+func Export(db *storage.Database, w io.Writer, format string, filter storage.AuditFilter) (int, error) {
+	var (
+		csvWriter *csv.Writer
+		encoder   *json.Encoder
+	)
+	switch format {
+	case FormatCSV:
+		csvWriter = csv.NewWriter(w)
+		if err := csvWriter.Write(csvHeader); err != nil {
+			return 0, err
+		}
+	case FormatJSONL, "":
+		encoder = json.NewEncoder(w)
+	default:
+		return 0, common.NewErrorf("unsupported export format: %s", format)
+	}
+
+	filter.Limit = storage.MaxAuditPageSize
+	total := 0
+	for {
+		events, err := db.ListAuditEvents(&filter)
+		if err != nil {
+			return total, err
+		}
+		for _, e := range events {
+			if csvWriter != nil {
+				err = csvWriter.Write(csvRecord(e))
+			} else {
+				err = encoder.Encode(e)
+			}
+			if err != nil {
+				return total, err
+			}
+			total++
+		}
+		if csvWriter != nil {
+			csvWriter.Flush()
+			if err := csvWriter.Error(); err != nil {
+				return total, err
+			}
+		}
+		if len(events) < filter.Limit {
+			return total, nil
+		}
+		filter.AfterID = events[len(events)-1].ID
+	}
+}
+
+// csvRecord 将审计事件转换为 CSV 行
+func csvRecord(e *common.AuditEvent) []string {
+	record := []string{
+		strconv.FormatUint(e.ID, 10),
+		e.Timestamp.Format(time.RFC3339Nano),
+		e.Action,
+		e.Actor,
+		e.Source,
+		e.AgentID,
+		e.TaskID,
+		string(e.TaskType),
+		e.Outcome,
+		e.Command,
+		e.Reason,
+		strconv.FormatInt(e.DurationMs, 10),
+		e.PrevHash,
+		e.Hash,
+	}
+	for i, v := range record {
+		record[i] = csvSafe(v)
+	}
+	return record
+}
+
+// csvSafe 避免单元格在电子表格中被当作公式执行（以 = + - @ 开头时加 ' 前缀）
+// 需要校验哈希时使用 JSON Lines 导出
+func csvSafe(v string) string {
+	if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+		return "'" + v
+	}
+	return v
+}
@@ -0,0 +1,64 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cloud-agent/internal/cloud/storage"
+	"github.com/cloud-agent/internal/common"
+)
+
+func TestRecorderAndExport(t *testing.T) {
+	db, err := storage.NewDatabaseWithConfig(&storage.Config{
+		DSN:      filepath.Join(t.TempDir(), "cloud.db"),
+		LogLevel: "silent",
+	})
+	if err != nil {
+		t.Fatalf("NewDatabaseWithConfig failed: %v", err)
+	}
+	defer db.Close()
+
+	task := &common.Task{ID: "t1", AgentID: "a1", Type: common.TaskTypeShell, Command: "=cmd", CreatedBy: "alice"}
+	if err := db.CreateTask(task); err != nil {
+		t.Fatalf("CreateTask failed: %v", err)
+	}
+	r := NewRecorder(db)
+	r.TaskSubmitted(task)
+	r.AgentEvent("a1", &common.AuditEventData{TaskID: "t1", Action: common.AuditActionValidationRejected, Outcome: common.AuditOutcomeDenied})
+
+	var buf bytes.Buffer
+	n, err := Export(db, &buf, FormatJSONL, storage.AuditFilter{})
+	if err != nil || n != 2 {
+		t.Fatalf("jsonl export: n=%d err=%v", n, err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	var rejected common.AuditEvent
+	if err := json.Unmarshal([]byte(lines[1]), &rejected); err != nil {
+		t.Fatalf("invalid jsonl line: %v", err)
+	}
+	if rejected.Source != SourceAgent || rejected.Actor != "alice" || rejected.TaskType != common.TaskTypeShell {
+		t.Fatalf("agent event not enriched: %+v", rejected)
+	}
+	if rejected.Hash != storage.AuditEventHash(&rejected) {
+		t.Fatal("exported event hash does not verify")
+	}
+
+	buf.Reset()
+	if _, err := Export(db, &buf, FormatCSV, storage.AuditFilter{Actions: []string{common.AuditActionTaskSubmitted}}); err != nil {
+		t.Fatalf("csv export failed: %v", err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil || len(records) != 2 {
+		t.Fatalf("csv export: records=%v err=%v", records, err)
+	}
+	if records[1][9] != "'=cmd" {
+		t.Fatalf("expected formula to be escaped, got %q", records[1][9])
+	}
+
+	var nilRecorder *Recorder
+	nilRecorder.TaskSubmitted(task)
+}
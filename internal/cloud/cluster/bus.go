@@ -0,0 +1,139 @@
+package cluster
+
+import (
+	"log"
+	"sync"
+)
+
+// Handler 消息处理函数
+type Handler func(env *Envelope)
+
+// Bus 副本间消息总线
+type Bus interface {
+	// Publish 向主题发布消息
+	Publish(topic string, env *Envelope) error
+	// Subscribe 订阅主题，返回取消订阅函数
+	Subscribe(topic string, handler Handler) (func(), error)
+	// Close 关闭总线，停止所有订阅
+	Close() error
+}
+
+// subscription 单个订阅：使用独立的 goroutine 顺序投递，保证同一订阅内的消息有序
+type subscription struct {
+	topic   string
+	handler Handler
+	queue   chan *Envelope
+	done    chan struct{}
+	once    sync.Once
+}
+
+func newSubscription(topic string, handler Handler) *subscription {
+	s := &subscription{
+		topic:   topic,
+		handler: handler,
+		queue:   make(chan *Envelope, 1024),
+		done:    make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *subscription) run() {
+	for {
+		select {
+		case env := <-s.queue:
+			s.handler(env)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// deliver 非阻塞投递，队列满时丢弃并记录日志，避免慢订阅者拖垮发布方
+func (s *subscription) deliver(env *Envelope) {
+	select {
+	case s.queue <- env:
+	case <-s.done:
+	default:
+		log.Printf("[cluster] subscription queue full, dropping %s message on topic %s", env.Kind, s.topic)
+	}
+}
+
+func (s *subscription) stop() {
+	s.once.Do(func() { close(s.done) })
+}
+
+// MemoryBus 进程内消息总线
+// 同一进程内的多个 Cluster 共享同一个 MemoryBus 即可模拟多副本（用于测试和单实例部署）
+type MemoryBus struct {
+	mu     sync.RWMutex
+	subs   map[string][]*subscription
+	closed bool
+}
+
+// NewMemoryBus 创建进程内消息总线
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{
+		subs: make(map[string][]*subscription),
+	}
+}
+
+// Publish 发布消息
+func (b *MemoryBus) Publish(topic string, env *Envelope) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return errBusClosed
+	}
+	for _, s := range b.subs[topic] {
+		s.deliver(env)
+	}
+	return nil
+}
+
+// Subscribe 订阅主题
+func (b *MemoryBus) Subscribe(topic string, handler Handler) (func(), error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, errBusClosed
+	}
+
+	s := newSubscription(topic, handler)
+	b.subs[topic] = append(b.subs[topic], s)
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.subs[topic] = removeSubscription(b.subs[topic], s)
+		s.stop()
+	}, nil
+}
+
+// Close 关闭总线
+func (b *MemoryBus) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	for _, subs := range b.subs {
+		for _, s := range subs {
+			s.stop()
+		}
+	}
+	b.subs = make(map[string][]*subscription)
+	return nil
+}
+
+// removeSubscription 从订阅列表中移除指定订阅
+func removeSubscription(subs []*subscription, target *subscription) []*subscription {
+	result := make([]*subscription, 0, len(subs))
+	for _, s := range subs {
+		if s != target {
+			result = append(result, s)
+		}
+	}
+	return result
+}
@@ -0,0 +1,122 @@
+package cluster
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/cloud-agent/internal/cloud/storage"
+	"github.com/cloud-agent/internal/common"
+	"github.com/google/uuid"
+)
+
+// 消息主题
+const (
+	// TopicBroadcast 广播主题，所有副本都会收到（任务日志、任务完成等）
+	TopicBroadcast = "broadcast"
+	// topicReplicaPrefix 副本私有主题前缀，用于把消息路由到持有 Agent 连接的副本
+	topicReplicaPrefix = "replica."
+)
+
+// 消息类型
+const (
+	// KindAgentSend 请求持有连接的副本把 Message 发送给 Agent
+	KindAgentSend = "agent.send"
+	// KindAgentDisconnect Agent 已在其他副本重新连接，旧副本应关闭本地连接
+	KindAgentDisconnect = "agent.disconnect"
+	// KindTaskLog 单条任务日志（旧版本副本发送，保留用于滚动升级）
+	KindTaskLog = "task.log"
+	// KindTaskLogs 批量任务日志，用于推送给其他副本上的日志订阅者
+	KindTaskLogs = "task.logs"
+	// KindTaskComplete 任务完成，用于唤醒其他副本上的同步等待
+	KindTaskComplete = "task.complete"
+	// KindSecretsSync 请求持有连接的副本向 Agent 下发密钥（密钥值不经过消息总线）
+	KindSecretsSync = "secrets.sync"
+)
+
+// DefaultAgentTTL 注册表中 Agent 连接的有效期（超过该时间无心跳视为离线）
+const DefaultAgentTTL = 2 * time.Minute
+
+// Envelope 副本间传递的消息
+type Envelope struct {
+	Kind    string          `json:"kind"`
+	Source  string          `json:"source"` // 发送方副本 ID
+	AgentID string          `json:"agent_id,omitempty"`
+	TaskID  string          `json:"task_id,omitempty"`
+	Message *common.Message `json:"message,omitempty"` // KindAgentSend 时发送给 Agent 的消息
+	Data    json.RawMessage `json:"data,omitempty"`    // 其他类型的附加数据
+}
+
+// DecodeData 解析附加数据
+func (e *Envelope) DecodeData(v interface{}) error {
+	if len(e.Data) == 0 {
+		return common.NewError("envelope data is empty")
+	}
+	return json.Unmarshal(e.Data, v)
+}
+
+// ReplicaTopic 返回副本私有主题
+func ReplicaTopic(replicaID string) string {
+	return topicReplicaPrefix + replicaID
+}
+
+// Cluster 当前副本在集群中的视图：副本 ID、消息总线和连接注册表
+type Cluster struct {
+	ReplicaID string
+	Bus       Bus
+	Registry  Registry
+}
+
+// NewLocal 创建单副本（进程内）集群，适用于单实例部署和测试
+func NewLocal(replicaID string) *Cluster {
+	return &Cluster{
+		ReplicaID: defaultReplicaID(replicaID),
+		Bus:       NewMemoryBus(),
+		Registry:  NewMemoryRegistry(DefaultAgentTTL),
+	}
+}
+
+// NewWithDatabase 创建基于共享数据库的集群，多个副本连接同一个数据库即可互相路由
+func NewWithDatabase(replicaID string, db *storage.Database, pollInterval time.Duration) *Cluster {
+	return &Cluster{
+		ReplicaID: defaultReplicaID(replicaID),
+		Bus:       NewDBBus(db, pollInterval),
+		Registry:  NewDBRegistry(db, DefaultAgentTTL),
+	}
+}
+
+// NewEnvelope 创建由当前副本发出的消息
+func (c *Cluster) NewEnvelope(kind string) *Envelope {
+	return &Envelope{Kind: kind, Source: c.ReplicaID}
+}
+
+// PublishBroadcast 向所有副本广播消息，data 会序列化为 JSON
+func (c *Cluster) PublishBroadcast(kind, taskID string, data interface{}) error {
+	env := c.NewEnvelope(kind)
+	env.TaskID = taskID
+	if data != nil {
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		env.Data = raw
+	}
+	return c.Bus.Publish(TopicBroadcast, env)
+}
+
+// Close 关闭消息总线
+func (c *Cluster) Close() error {
+	return c.Bus.Close()
+}
+
+// defaultReplicaID 未指定副本 ID 时使用 主机名-随机后缀
+func defaultReplicaID(replicaID string) string {
+	if replicaID != "" {
+		return replicaID
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "cloud"
+	}
+	return hostname + "-" + uuid.New().String()[:8]
+}
@@ -0,0 +1,215 @@
+package cluster
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cloud-agent/internal/cloud/storage"
+)
+
+func waitEnvelope(t *testing.T, ch <-chan *Envelope) *Envelope {
+	t.Helper()
+	select {
+	case env := <-ch:
+		return env
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for envelope")
+		return nil
+	}
+}
+
+func TestMemoryBusPublishSubscribe(t *testing.T) {
+	bus := NewMemoryBus()
+	defer bus.Close()
+
+	received := make(chan *Envelope, 10)
+	unsubscribe, err := bus.Subscribe("replica.a", func(env *Envelope) { received <- env })
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		bus.Publish("replica.a", &Envelope{Kind: KindTaskLog, TaskID: string(rune('1' + i))})
+	}
+	bus.Publish("replica.b", &Envelope{Kind: KindTaskLog, TaskID: "other"})
+
+	for i := 0; i < 3; i++ {
+		env := waitEnvelope(t, received)
+		if want := string(rune('1' + i)); env.TaskID != want {
+			t.Errorf("envelope #%d task_id = %s, want %s (order must be preserved)", i, env.TaskID, want)
+		}
+	}
+
+	unsubscribe()
+	bus.Publish("replica.a", &Envelope{Kind: KindTaskLog})
+	select {
+	case env := <-received:
+		t.Errorf("received %+v after unsubscribe", env)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestDBBusDeliversAcrossReplicas(t *testing.T) {
+	db, err := storage.NewDatabaseWithConfig(&storage.Config{
+		DSN:      filepath.Join(t.TempDir(), "cloud.db"),
+		LogLevel: "silent",
+	})
+	if err != nil {
+		t.Fatalf("NewDatabaseWithConfig failed: %v", err)
+	}
+	defer db.Close()
+
+	// 两个副本共享同一个数据库
+	replicaA := NewWithDatabase("replica-a", db, 20*time.Millisecond)
+	replicaB := NewWithDatabase("replica-b", db, 20*time.Millisecond)
+	defer replicaA.Close()
+	defer replicaB.Close()
+
+	received := make(chan *Envelope, 10)
+	if _, err := replicaB.Bus.Subscribe(ReplicaTopic("replica-b"), func(env *Envelope) { received <- env }); err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+
+	env := replicaA.NewEnvelope(KindAgentSend)
+	env.AgentID = "agent-1"
+	if err := replicaA.Bus.Publish(ReplicaTopic("replica-b"), env); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+
+	got := waitEnvelope(t, received)
+	if got.Source != "replica-a" || got.AgentID != "agent-1" {
+		t.Errorf("got envelope %+v", got)
+	}
+
+	// 同一条消息不能重复投递
+	select {
+	case dup := <-received:
+		t.Errorf("duplicate delivery: %+v", dup)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestRegistries(t *testing.T) {
+	db, err := storage.NewDatabaseWithConfig(&storage.Config{
+		DSN:      filepath.Join(t.TempDir(), "cloud.db"),
+		LogLevel: "silent",
+	})
+	if err != nil {
+		t.Fatalf("NewDatabaseWithConfig failed: %v", err)
+	}
+	defer db.Close()
+
+	registries := map[string]Registry{
+		"memory": NewMemoryRegistry(time.Minute),
+		"db":     NewDBRegistry(db, time.Minute),
+	}
+
+	for name, r := range registries {
+		t.Run(name, func(t *testing.T) {
+			if _, ok, _ := r.Lookup("agent-1"); ok {
+				t.Fatal("unregistered agent should not be found")
+			}
+
+			if prev, err := r.Register("agent-1", "replica-a"); err != nil || prev != "" {
+				t.Fatalf("Register = %q, %v", prev, err)
+			}
+			if replica, ok, _ := r.Lookup("agent-1"); !ok || replica != "replica-a" {
+				t.Errorf("Lookup = %q, %v, want replica-a", replica, ok)
+			}
+
+			// Agent 重连到另一个副本
+			if prev, _ := r.Register("agent-1", "replica-b"); prev != "replica-a" {
+				t.Errorf("Register previous = %q, want replica-a", prev)
+			}
+
+			// 旧副本注销不应删除新副本的记录
+			r.Unregister("agent-1", "replica-a")
+			if replica, ok, _ := r.Lookup("agent-1"); !ok || replica != "replica-b" {
+				t.Errorf("Lookup after stale unregister = %q, %v, want replica-b", replica, ok)
+			}
+
+			online, _ := r.Online()
+			if online["agent-1"] != "replica-b" {
+				t.Errorf("Online = %v", online)
+			}
+
+			r.Unregister("agent-1", "replica-b")
+			if _, ok, _ := r.Lookup("agent-1"); ok {
+				t.Error("agent should be offline after unregister")
+			}
+		})
+	}
+}
+
+func TestMemoryRegistryExpires(t *testing.T) {
+	r := NewMemoryRegistry(50 * time.Millisecond)
+	r.Register("agent-1", "replica-a")
+	time.Sleep(80 * time.Millisecond)
+	if _, ok, _ := r.Lookup("agent-1"); ok {
+		t.Error("entry should expire after ttl without heartbeat")
+	}
+}
+
+func TestDBBusDeliversBacklogAndLateMessages(t *testing.T) {
+	db, err := storage.NewDatabaseWithConfig(&storage.Config{
+		DSN:      filepath.Join(t.TempDir(), "cloud.db"),
+		LogLevel: "silent",
+	})
+	if err != nil {
+		t.Fatalf("NewDatabaseWithConfig failed: %v", err)
+	}
+	defer db.Close()
+
+	bus := NewDBBus(db, 20*time.Millisecond)
+	defer bus.Close()
+	received := make(chan *Envelope, 2000)
+	if _, err := bus.Subscribe("replica.b", func(env *Envelope) { received <- env }); err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+
+	// 空洞容忍时间内发布超过一批的消息，全部及时投递
+	const n = 2*dbBusBatchSize + 7 // 不超过订阅队列长度
+	for i := range n {
+		if err := bus.Publish("replica.b", &Envelope{Kind: KindTaskLog, TaskID: strconv.Itoa(i)}); err != nil {
+			t.Fatalf("Publish failed: %v", err)
+		}
+	}
+	deadline := time.After(2 * time.Second)
+	for i := range n {
+		select {
+		case env := <-received:
+			if env.TaskID != strconv.Itoa(i) {
+				t.Fatalf("envelope #%d task_id = %s", i, env.TaskID)
+			}
+		case <-deadline:
+			t.Fatalf("only %d of %d messages delivered", i, n)
+		}
+	}
+
+	// 较小的 ID 晚于较大的 ID 提交：在空洞容忍时间内补读
+	bus.Publish("replica.other", &Envelope{Kind: KindTaskLog, TaskID: "other"})
+	bus.Publish("replica.b", &Envelope{Kind: KindTaskLog, TaskID: "next"})
+	if env := waitEnvelope(t, received); env.TaskID != "next" {
+		t.Fatalf("got %s, want next", env.TaskID)
+	}
+	maxID, _ := db.GetMaxClusterMessageID()
+	late := maxID - 1
+	if err := db.DeleteClusterMessagesBefore(time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("DeleteClusterMessagesBefore failed: %v", err)
+	}
+	payload, _ := json.Marshal(&Envelope{Kind: KindTaskLog, TaskID: "late"})
+	if err := db.CreateClusterMessage(&storage.ClusterMessage{ID: late, Topic: "replica.b", Payload: string(payload)}); err != nil {
+		t.Fatalf("CreateClusterMessage failed: %v", err)
+	}
+	if env := waitEnvelope(t, received); env.TaskID != "late" {
+		t.Errorf("got %s, want late", env.TaskID)
+	}
+	select {
+	case dup := <-received:
+		t.Errorf("duplicate delivery: %+v", dup)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,253 @@
+package cluster
+
+import (
+	"encoding/json"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/cloud-agent/internal/cloud/storage"
+	"github.com/cloud-agent/internal/common"
+)
+
+var errBusClosed = common.NewError("cluster bus closed")
+
+const (
+	// dbBusBatchSize 每次查询读取的最大消息数
+	dbBusBatchSize = 500
+	// dbBusGapTolerance 消息 ID 空洞容忍时间
+	// 并发事务可能让较小的 ID 晚于较大的 ID 提交，在该时间窗口内仍会补读
+	dbBusGapTolerance = 5 * time.Second
+	// dbBusMaxGap 一次跳过的 ID 中最多补读的数量（只补读最接近的），避免其他主题消息很多时空洞无限增长
+	dbBusMaxGap = 10000
+	// dbBusRetention 消息保留时间，超过后由清理协程删除
+	dbBusRetention = 10 * time.Minute
+)
+
+// DBBus 基于共享数据库表的消息总线
+// 发布即写入 cluster_messages 表，各副本定期轮询自己订阅的主题。
+// 适合中小规模部署，不需要额外引入消息中间件。
+type DBBus struct {
+	db           *storage.Database
+	pollInterval time.Duration
+
+	mu   sync.RWMutex
+	subs map[string][]*subscription
+
+	cursor uint               // 已投递的最大消息 ID，新消息从这里之后读取
+	gaps   map[uint]time.Time // cursor 之前尚未读到的 ID（其他主题或未提交的事务）-> 发现时间
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// NewDBBus 创建数据库消息总线，从当前最新消息之后开始消费
+func NewDBBus(db *storage.Database, pollInterval time.Duration) *DBBus {
+	if pollInterval <= 0 {
+		pollInterval = 500 * time.Millisecond
+	}
+
+	b := &DBBus{
+		db:           db,
+		pollInterval: pollInterval,
+		subs:         make(map[string][]*subscription),
+		gaps:         make(map[uint]time.Time),
+		done:         make(chan struct{}),
+	}
+
+	if maxID, err := db.GetMaxClusterMessageID(); err == nil {
+		b.cursor = maxID
+	} else {
+		log.Printf("[cluster] failed to load latest message id: %v", err)
+	}
+
+	b.wg.Add(2)
+	go b.pollLoop()
+	go b.cleanupLoop()
+
+	return b
+}
+
+// Publish 发布消息
+func (b *DBBus) Publish(topic string, env *Envelope) error {
+	select {
+	case <-b.done:
+		return errBusClosed
+	default:
+	}
+
+	payload, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	return b.db.CreateClusterMessage(&storage.ClusterMessage{
+		Topic:   topic,
+		Payload: string(payload),
+	})
+}
+
+// Subscribe 订阅主题
+func (b *DBBus) Subscribe(topic string, handler Handler) (func(), error) {
+	select {
+	case <-b.done:
+		return nil, errBusClosed
+	default:
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := newSubscription(topic, handler)
+	b.subs[topic] = append(b.subs[topic], s)
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.subs[topic] = removeSubscription(b.subs[topic], s)
+		if len(b.subs[topic]) == 0 {
+			delete(b.subs, topic)
+		}
+		s.stop()
+	}, nil
+}
+
+// Close 停止轮询和所有订阅
+func (b *DBBus) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.done)
+		b.wg.Wait()
+
+		b.mu.Lock()
+		for _, subs := range b.subs {
+			for _, s := range subs {
+				s.stop()
+			}
+		}
+		b.subs = make(map[string][]*subscription)
+		b.mu.Unlock()
+	})
+	return nil
+}
+
+// pollLoop 轮询新消息
+func (b *DBBus) pollLoop() {
+	defer b.wg.Done()
+
+	ticker := time.NewTicker(b.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			b.poll()
+		case <-b.done:
+			return
+		}
+	}
+}
+
+// poll 读取并投递新消息，并补读空洞中迟到的消息
+func (b *DBBus) poll() {
+	b.mu.RLock()
+	topics := make([]string, 0, len(b.subs))
+	for topic := range b.subs {
+		topics = append(topics, topic)
+	}
+	b.mu.RUnlock()
+
+	if len(topics) == 0 {
+		return
+	}
+
+	now := time.Now()
+	b.pollGaps(topics, now)
+
+	// 读完 cursor 之后的全部新消息，每次最多 dbBusBatchSize 条
+	for {
+		msgs, err := b.db.ListClusterMessages(b.cursor, topics, dbBusBatchSize)
+		if err != nil {
+			log.Printf("[cluster] failed to poll messages: %v", err)
+			return
+		}
+		for _, msg := range msgs {
+			// 跳过的 ID 属于其他主题或尚未提交，在空洞容忍时间内补读
+			from := b.cursor + 1
+			if msg.ID-from > dbBusMaxGap {
+				from = msg.ID - dbBusMaxGap
+			}
+			for id := from; id < msg.ID; id++ {
+				b.gaps[id] = now
+			}
+			b.cursor = msg.ID
+			b.deliver(msg)
+		}
+		if len(msgs) < dbBusBatchSize {
+			return
+		}
+		select {
+		case <-b.done:
+			return
+		default:
+		}
+	}
+}
+
+// pollGaps 补读空洞中的消息：并发事务可能让较小的 ID 晚于较大的 ID 提交
+// 超过空洞容忍时间的 ID 不再补读
+func (b *DBBus) pollGaps(topics []string, now time.Time) {
+	ids := make([]uint, 0, len(b.gaps))
+	for id, foundAt := range b.gaps {
+		if now.Sub(foundAt) > dbBusGapTolerance {
+			delete(b.gaps, id)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	for chunk := range slices.Chunk(ids, dbBusBatchSize) {
+		msgs, err := b.db.ListClusterMessagesByID(chunk, topics)
+		if err != nil {
+			log.Printf("[cluster] failed to poll late messages: %v", err)
+			return
+		}
+		for _, msg := range msgs {
+			delete(b.gaps, msg.ID)
+			b.deliver(msg)
+		}
+	}
+}
+
+// deliver 将消息投递给订阅了其主题的处理函数
+func (b *DBBus) deliver(msg *storage.ClusterMessage) {
+	var env Envelope
+	if err := json.Unmarshal([]byte(msg.Payload), &env); err != nil {
+		log.Printf("[cluster] failed to decode message %d: %v", msg.ID, err)
+		return
+	}
+
+	b.mu.RLock()
+	for _, s := range b.subs[msg.Topic] {
+		s.deliver(&env)
+	}
+	b.mu.RUnlock()
+}
+
+// cleanupLoop 定期清理过期消息
+func (b *DBBus) cleanupLoop() {
+	defer b.wg.Done()
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := b.db.DeleteClusterMessagesBefore(time.Now().Add(-dbBusRetention)); err != nil {
+				log.Printf("[cluster] failed to cleanup messages: %v", err)
+			}
+		case <-b.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package cluster
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/cloud-agent/internal/cloud/storage"
+	"gorm.io/gorm"
+)
+
+// Registry Agent 连接注册表：记录每个 Agent 的 WebSocket 连接由哪个副本持有
+type Registry interface {
+	// Register 记录 Agent 连接到指定副本，返回此前持有连接的副本 ID（没有则为空）
+	Register(agentID, replicaID string) (previous string, err error)
+	// Touch 刷新 Agent 连接的活跃时间（心跳）
+	Touch(agentID, replicaID string) error
+	// Unregister 删除连接记录（仅当记录仍属于该副本时）
+	Unregister(agentID, replicaID string) error
+	// Lookup 查询持有 Agent 连接的副本，记录不存在或已过期时返回 false
+	Lookup(agentID string) (replicaID string, ok bool, err error)
+	// Online 列出所有在线 Agent -> 副本 ID
+	Online() (map[string]string, error)
+}
+
+// memoryEntry 内存注册表记录
+type memoryEntry struct {
+	replicaID string
+	lastSeen  time.Time
+}
+
+// MemoryRegistry 进程内注册表
+type MemoryRegistry struct {
+	ttl     time.Duration
+	mu      sync.RWMutex
+	entries map[string]*memoryEntry
+}
+
+// NewMemoryRegistry 创建进程内注册表
+func NewMemoryRegistry(ttl time.Duration) *MemoryRegistry {
+	return &MemoryRegistry{
+		ttl:     ttl,
+		entries: make(map[string]*memoryEntry),
+	}
+}
+
+// Register 注册连接
+func (r *MemoryRegistry) Register(agentID, replicaID string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	previous := ""
+	if entry, ok := r.entries[agentID]; ok && time.Since(entry.lastSeen) <= r.ttl {
+		previous = entry.replicaID
+	}
+	r.entries[agentID] = &memoryEntry{replicaID: replicaID, lastSeen: time.Now()}
+	return previous, nil
+}
+
+// Touch 刷新活跃时间
+func (r *MemoryRegistry) Touch(agentID, replicaID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if entry, ok := r.entries[agentID]; ok && entry.replicaID == replicaID {
+		entry.lastSeen = time.Now()
+	}
+	return nil
+}
+
+// Unregister 注销连接
+func (r *MemoryRegistry) Unregister(agentID, replicaID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if entry, ok := r.entries[agentID]; ok && entry.replicaID == replicaID {
+		delete(r.entries, agentID)
+	}
+	return nil
+}
+
+// Lookup 查询连接所在副本
+func (r *MemoryRegistry) Lookup(agentID string) (string, bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entry, ok := r.entries[agentID]
+	if !ok || time.Since(entry.lastSeen) > r.ttl {
+		return "", false, nil
+	}
+	return entry.replicaID, true, nil
+}
+
+// Online 列出在线 Agent
+func (r *MemoryRegistry) Online() (map[string]string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := make(map[string]string, len(r.entries))
+	for agentID, entry := range r.entries {
+		if time.Since(entry.lastSeen) <= r.ttl {
+			result[agentID] = entry.replicaID
+		}
+	}
+	return result, nil
+}
+
+// DBRegistry 基于共享数据库的注册表
+type DBRegistry struct {
+	db  *storage.Database
+	ttl time.Duration
+}
+
+// NewDBRegistry 创建数据库注册表
+func NewDBRegistry(db *storage.Database, ttl time.Duration) *DBRegistry {
+	return &DBRegistry{db: db, ttl: ttl}
+}
+
+// Register 注册连接
+func (r *DBRegistry) Register(agentID, replicaID string) (string, error) {
+	previous := ""
+	if existing, err := r.db.GetAgentConnection(agentID); err == nil && time.Since(existing.LastSeen) <= r.ttl {
+		previous = existing.ReplicaID
+	}
+
+	now := time.Now()
+	err := r.db.UpsertAgentConnection(&storage.AgentConnection{
+		AgentID:     agentID,
+		ReplicaID:   replicaID,
+		ConnectedAt: now,
+		LastSeen:    now,
+	})
+	return previous, err
+}
+
+// Touch 刷新活跃时间
+func (r *DBRegistry) Touch(agentID, replicaID string) error {
+	return r.db.TouchAgentConnection(agentID, replicaID)
+}
+
+// Unregister 注销连接
+func (r *DBRegistry) Unregister(agentID, replicaID string) error {
+	return r.db.DeleteAgentConnection(agentID, replicaID)
+}
+
+// Lookup 查询连接所在副本
+func (r *DBRegistry) Lookup(agentID string) (string, bool, error) {
+	conn, err := r.db.GetAgentConnection(agentID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	if time.Since(conn.LastSeen) > r.ttl {
+		return "", false, nil
+	}
+	return conn.ReplicaID, true, nil
+}
+
+// Online 列出在线 Agent
+func (r *DBRegistry) Online() (map[string]string, error) {
+	conns, err := r.db.ListAgentConnections(time.Now().Add(-r.ttl))
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]string, len(conns))
+	for _, conn := range conns {
+		result[conn.AgentID] = conn.ReplicaID
+	}
+	return result, nil
+}
@@ -0,0 +1,132 @@
+package filestore
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/cloud-agent/internal/common"
+)
+
+// 存储后端类型
+const (
+	TypeLocal = "local"
+	TypeS3    = "s3"
+)
+
+var (
+	// ErrNotFound 对象不存在
+	ErrNotFound = common.NewError("object not found")
+	// ErrPresignNotSupported 存储后端不支持预签名下载
+	ErrPresignNotSupported = common.NewError("presigned url not supported by file store")
+)
+
+// ObjectInfo 对象信息
+type ObjectInfo struct {
+	Key         string
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+}
+
+// FileStore 文件存储后端
+type FileStore interface {
+	// Type 返回存储后端类型（local、s3）
+	Type() string
+	// Put 流式写入对象，size 为 -1 表示未知大小
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) (*ObjectInfo, error)
+	// Open 打开对象用于读取，调用方负责关闭
+	Open(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error)
+	// Stat 获取对象信息
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	// Delete 删除对象，对象不存在时不返回错误
+	Delete(ctx context.Context, key string) error
+	// Walk 遍历存储中的所有对象（包括未完成上传留下的临时对象），用于回收孤立对象
+	Walk(ctx context.Context, fn func(info *ObjectInfo) error) error
+	// Location 返回对象的位置描述（本地为绝对路径，S3 为 s3://bucket/key），保存到 File.Path
+	Location(key string) string
+	// PresignGet 生成带有效期的下载链接，downloadName 不为空时作为下载文件名
+	PresignGet(ctx context.Context, key string, expires time.Duration, downloadName string) (string, error)
+}
+
+// ObjectKey 生成对象 key：<fileID>/<清理后的文件名>
+// 保留原始文件名（包括扩展名），方便 Agent 按扩展名识别 zip 等格式
+func ObjectKey(fileID, fileName string) string {
+	return fileID + "/" + SanitizeName(fileName)
+}
+
+// SanitizeName 清理文件名中的路径分隔符等不安全字符，防止路径遍历
+func SanitizeName(name string) string {
+	name = strings.ReplaceAll(name, "/", "_")
+	name = strings.ReplaceAll(name, "\\", "_")
+	name = strings.ReplaceAll(name, "..", "_")
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." {
+		name = "file"
+	}
+	return name
+}
+
+// cleanKey 规范化对象 key，拒绝绝对路径和路径遍历
+func cleanKey(key string) (string, error) {
+	key = strings.ReplaceAll(key, "\\", "/")
+	for _, part := range strings.Split(key, "/") {
+		if part == ".." {
+			return "", common.NewErrorf("invalid object key: %q", key)
+		}
+	}
+	cleaned := strings.TrimPrefix(path.Clean("/"+key), "/")
+	if cleaned == "" || cleaned == "." {
+		return "", common.NewErrorf("invalid object key: %q", key)
+	}
+	return cleaned, nil
+}
+
+// ChecksumReader 在流式读取的同时计算 MD5 和 SHA-256，避免为了计算校验和而读取两次
+type ChecksumReader struct {
+	r      io.Reader
+	md5    hash.Hash
+	sha256 hash.Hash
+	size   int64
+}
+
+// NewChecksumReader 包装 reader
+func NewChecksumReader(r io.Reader) *ChecksumReader {
+	return &ChecksumReader{
+		r:      r,
+		md5:    md5.New(),
+		sha256: sha256.New(),
+	}
+}
+
+// Read 实现 io.Reader
+func (c *ChecksumReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 {
+		c.md5.Write(p[:n])
+		c.sha256.Write(p[:n])
+		c.size += int64(n)
+	}
+	return n, err
+}
+
+// MD5 返回已读取内容的 MD5（十六进制）
+func (c *ChecksumReader) MD5() string {
+	return hex.EncodeToString(c.md5.Sum(nil))
+}
+
+// SHA256 返回已读取内容的 SHA-256（十六进制）
+func (c *ChecksumReader) SHA256() string {
+	return hex.EncodeToString(c.sha256.Sum(nil))
+}
+
+// Size 返回已读取的字节数
+func (c *ChecksumReader) Size() int64 {
+	return c.size
+}
@@ -0,0 +1,206 @@
+package filestore
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestChecksumReader(t *testing.T) {
+	data := "hello cloud-agent"
+	r := NewChecksumReader(strings.NewReader(data))
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+
+	md5Sum := md5.Sum([]byte(data))
+	shaSum := sha256.Sum256([]byte(data))
+	if r.MD5() != hex.EncodeToString(md5Sum[:]) {
+		t.Errorf("MD5 = %s", r.MD5())
+	}
+	if r.SHA256() != hex.EncodeToString(shaSum[:]) {
+		t.Errorf("SHA256 = %s", r.SHA256())
+	}
+	if r.Size() != int64(len(data)) {
+		t.Errorf("Size = %d, want %d", r.Size(), len(data))
+	}
+}
+
+func TestObjectKey(t *testing.T) {
+	if got := ObjectKey("f1", "../../etc/passwd"); strings.Contains(got, "..") || strings.Count(got, "/") != 1 {
+		t.Errorf("ObjectKey did not sanitize name: %q", got)
+	}
+	if _, err := cleanKey("../outside"); err == nil {
+		t.Error("cleanKey should reject path traversal")
+	}
+}
+
+// exerciseStore 对存储后端执行通用的读写删除检查
+func exerciseStore(t *testing.T, store FileStore) {
+	t.Helper()
+	ctx := context.Background()
+	key := ObjectKey("file-1", "data.sql")
+	content := "SELECT 1;"
+
+	info, err := store.Put(ctx, key, strings.NewReader(content), int64(len(content)), "application/sql")
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if info.Size != int64(len(content)) {
+		t.Errorf("Put size = %d, want %d", info.Size, len(content))
+	}
+
+	rc, stat, err := store.Open(ctx, key)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	got, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(got) != content {
+		t.Errorf("Open content = %q, want %q", got, content)
+	}
+	if stat.Size != int64(len(content)) {
+		t.Errorf("Open size = %d", stat.Size)
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := store.Stat(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat after delete = %v, want ErrNotFound", err)
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		t.Errorf("Delete of missing object should succeed, got %v", err)
+	}
+}
+
+func TestLocalStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewLocalStore(dir)
+	if err != nil {
+		t.Fatalf("NewLocalStore failed: %v", err)
+	}
+	exerciseStore(t, store)
+
+	if loc := store.Location("a/b.txt"); loc != filepath.Join(dir, "a", "b.txt") {
+		t.Errorf("Location = %s", loc)
+	}
+	if _, err := store.PresignGet(context.Background(), "a/b.txt", 0, ""); !errors.Is(err, ErrPresignNotSupported) {
+		t.Errorf("PresignGet = %v, want ErrPresignNotSupported", err)
+	}
+
+	// 大小不匹配时不应留下对象
+	if _, err := store.Put(context.Background(), "x/y", strings.NewReader("abc"), 10, ""); err == nil {
+		t.Error("Put with wrong size should fail")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "x", "y")); !os.IsNotExist(err) {
+		t.Error("failed Put left object on disk")
+	}
+}
+
+// fakeS3 一个最小的 S3 兼容服务端（path-style，仅支持单对象 PUT/GET/HEAD/DELETE）
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	types   map[string]string
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	switch r.Method {
+	case http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		f.objects[name] = data
+		f.types[name] = r.Header.Get("Content-Type")
+		w.Header().Set("ETag", `"etag"`)
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet, http.MethodHead:
+		data, ok := f.objects[name]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>not found</Message></Error>`)
+			}
+			return
+		}
+		w.Header().Set("Content-Type", f.types[name])
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("ETag", `"etag"`)
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	case http.MethodDelete:
+		delete(f.objects, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestS3Store(t *testing.T) {
+	fake := &fakeS3{objects: make(map[string][]byte), types: make(map[string]string)}
+	srv := httptest.NewTLSServer(fake)
+	defer srv.Close()
+
+	endpoint := strings.TrimPrefix(srv.URL, "https://")
+	store, err := NewS3Store(&S3Config{
+		Endpoint:           endpoint,
+		Bucket:             "files",
+		AccessKey:          "test",
+		SecretKey:          "test-secret",
+		Region:             "us-east-1",
+		UseSSL:             true,
+		Prefix:             "/cloud-agent/",
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		t.Fatalf("NewS3Store failed: %v", err)
+	}
+	exerciseStore(t, store)
+
+	// 前缀应用到对象名
+	if _, err := store.Put(context.Background(), "f2/a.txt", strings.NewReader("x"), 1, ""); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if _, ok := fake.objects["files/cloud-agent/f2/a.txt"]; !ok {
+		t.Errorf("object not stored under prefix, have %v", fake.objects)
+	}
+	if loc := store.Location("f2/a.txt"); loc != "s3://files/cloud-agent/f2/a.txt" {
+		t.Errorf("Location = %s", loc)
+	}
+
+	presigned, err := store.PresignGet(context.Background(), "f2/a.txt", time.Minute, "a.txt")
+	if err != nil {
+		t.Fatalf("PresignGet failed: %v", err)
+	}
+	u, err := url.Parse(presigned)
+	if err != nil {
+		t.Fatalf("invalid presigned url: %v", err)
+	}
+	q := u.Query()
+	if q.Get("X-Amz-Signature") == "" || q.Get("X-Amz-Expires") != "60" {
+		t.Errorf("presigned url missing signature or expiry: %s", presigned)
+	}
+	if !strings.Contains(q.Get("response-content-disposition"), "a.txt") {
+		t.Errorf("presigned url missing download name: %s", presigned)
+	}
+}
@@ -0,0 +1,191 @@
+package filestore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LocalStore 本地磁盘存储
+// 对象保存在 baseDir/<key>，Location 返回绝对路径（与 Agent 共享存储卷时可直接读取）。
+type LocalStore struct {
+	baseDir string
+}
+
+// NewLocalStore 创建本地磁盘存储
+func NewLocalStore(baseDir string) (*LocalStore, error) {
+	absDir, err := filepath.Abs(baseDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve storage directory: %w", err)
+	}
+	if err := os.MkdirAll(absDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+	return &LocalStore{baseDir: absDir}, nil
+}
+
+// Type 返回存储类型
+func (s *LocalStore) Type() string {
+	return TypeLocal
+}
+
+// objectPath 返回对象在磁盘上的路径
+func (s *LocalStore) objectPath(key string) (string, error) {
+	cleaned, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(s.baseDir, filepath.FromSlash(cleaned)), nil
+}
+
+// Put 写入对象：先写临时文件再重命名，避免读到写了一半的文件
+func (s *LocalStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) (*ObjectInfo, error) {
+	target, err := s.objectPath(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create object directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmp.Name()
+
+	written, err := io.Copy(tmp, &contextReader{ctx: ctx, r: r})
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return nil, fmt.Errorf("failed to write object: %w", err)
+	}
+	if size >= 0 && written != size {
+		os.Remove(tmpPath)
+		return nil, fmt.Errorf("object size mismatch: expected %d bytes, got %d", size, written)
+	}
+
+	if err := os.Rename(tmpPath, target); err != nil {
+		os.Remove(tmpPath)
+		return nil, fmt.Errorf("failed to store object: %w", err)
+	}
+
+	return &ObjectInfo{
+		Key:         key,
+		Size:        written,
+		ContentType: contentType,
+		ModTime:     time.Now(),
+	}, nil
+}
+
+// Open 打开对象
+func (s *LocalStore) Open(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	info, err := s.Stat(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	p, _ := s.objectPath(key)
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open object: %w", err)
+	}
+	return f, info, nil
+}
+
+// Stat 获取对象信息
+func (s *LocalStore) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	p, err := s.objectPath(key)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(p)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat object: %w", err)
+	}
+	return &ObjectInfo{
+		Key:         key,
+		Size:        fi.Size(),
+		ContentType: mime.TypeByExtension(filepath.Ext(p)),
+		ModTime:     fi.ModTime(),
+	}, nil
+}
+
+// Delete 删除对象，并清理空的父目录
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	p, err := s.objectPath(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete object: %w", err)
+	}
+	if dir := filepath.Dir(p); dir != s.baseDir {
+		os.Remove(dir) // 目录非空时会失败，忽略
+	}
+	return nil
+}
+
+// Walk 遍历存储目录中的所有文件
+func (s *LocalStore) Walk(ctx context.Context, fn func(info *ObjectInfo) error) error {
+	return filepath.WalkDir(s.baseDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return nil // 遍历过程中文件被删除
+		}
+		rel, err := filepath.Rel(s.baseDir, p)
+		if err != nil {
+			return err
+		}
+		return fn(&ObjectInfo{
+			Key:     filepath.ToSlash(rel),
+			Size:    fi.Size(),
+			ModTime: fi.ModTime(),
+		})
+	})
+}
+
+// Location 返回对象的绝对路径
+func (s *LocalStore) Location(key string) string {
+	p, err := s.objectPath(key)
+	if err != nil {
+		return ""
+	}
+	return p
+}
+
+// PresignGet 本地存储不支持预签名下载
+func (s *LocalStore) PresignGet(ctx context.Context, key string, expires time.Duration, downloadName string) (string, error) {
+	return "", ErrPresignNotSupported
+}
+
+// contextReader 支持取消的 reader
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
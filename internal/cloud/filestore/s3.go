@@ -0,0 +1,240 @@
+package filestore
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// DefaultPresignExpiry 预签名下载链接的默认有效期
+const DefaultPresignExpiry = 15 * time.Minute
+
+// S3Config S3 兼容对象存储配置（AWS S3、MinIO、OSS/COS 的 S3 兼容接口等）
+type S3Config struct {
+	Endpoint           string        // 服务地址，如 s3.amazonaws.com、minio:9000
+	Bucket             string        // 存储桶
+	AccessKey          string        // 访问密钥 ID
+	SecretKey          string        // 访问密钥
+	Region             string        // 区域（可选）
+	UseSSL             bool          // 是否使用 HTTPS
+	Prefix             string        // 对象 key 前缀（可选），如 cloud-agent/files
+	InsecureSkipVerify bool          // 跳过 TLS 证书校验（仅用于测试环境）
+	PresignExpiry      time.Duration // 预签名下载链接有效期，默认 15 分钟
+}
+
+// S3Store S3 兼容对象存储
+type S3Store struct {
+	client *minio.Client
+	config S3Config
+}
+
+// NewS3Store 创建 S3 兼容对象存储，不会主动访问服务端
+func NewS3Store(config *S3Config) (*S3Store, error) {
+	if config == nil || config.Endpoint == "" {
+		return nil, fmt.Errorf("s3 endpoint is required")
+	}
+	if config.Bucket == "" {
+		return nil, fmt.Errorf("s3 bucket is required")
+	}
+
+	cfg := *config
+	cfg.Prefix = strings.Trim(cfg.Prefix, "/")
+	if cfg.PresignExpiry <= 0 {
+		cfg.PresignExpiry = DefaultPresignExpiry
+	}
+
+	options := &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+	}
+	if cfg.InsecureSkipVerify {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		options.Transport = transport
+	}
+
+	client, err := minio.New(cfg.Endpoint, options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create s3 client: %w", err)
+	}
+	return &S3Store{client: client, config: cfg}, nil
+}
+
+// EnsureBucket 检查存储桶是否存在，不存在时创建
+func (s *S3Store) EnsureBucket(ctx context.Context) error {
+	exists, err := s.client.BucketExists(ctx, s.config.Bucket)
+	if err != nil {
+		return fmt.Errorf("failed to check bucket %s: %w", s.config.Bucket, err)
+	}
+	if exists {
+		return nil
+	}
+	if err := s.client.MakeBucket(ctx, s.config.Bucket, minio.MakeBucketOptions{Region: s.config.Region}); err != nil {
+		return fmt.Errorf("failed to create bucket %s: %w", s.config.Bucket, err)
+	}
+	return nil
+}
+
+// Type 返回存储类型
+func (s *S3Store) Type() string {
+	return TypeS3
+}
+
+// objectName 返回带前缀的对象名
+func (s *S3Store) objectName(key string) (string, error) {
+	cleaned, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	if s.config.Prefix == "" {
+		return cleaned, nil
+	}
+	return s.config.Prefix + "/" + cleaned, nil
+}
+
+// Put 流式上传对象，size 未知时使用分片上传
+func (s *S3Store) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) (*ObjectInfo, error) {
+	name, err := s.objectName(key)
+	if err != nil {
+		return nil, err
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	info, err := s.client.PutObject(ctx, s.config.Bucket, name, r, size, minio.PutObjectOptions{
+		ContentType: contentType,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload object: %w", err)
+	}
+	return &ObjectInfo{
+		Key:         key,
+		Size:        info.Size,
+		ContentType: contentType,
+		ModTime:     time.Now(),
+	}, nil
+}
+
+// Open 打开对象
+func (s *S3Store) Open(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	name, err := s.objectName(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	obj, err := s.client.GetObject(ctx, s.config.Bucket, name, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, nil, convertS3Error(err)
+	}
+	// GetObject 是惰性的，通过 Stat 触发请求并获取对象信息
+	stat, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		return nil, nil, convertS3Error(err)
+	}
+	return obj, toObjectInfo(key, stat), nil
+}
+
+// Stat 获取对象信息
+func (s *S3Store) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	name, err := s.objectName(key)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := s.client.StatObject(ctx, s.config.Bucket, name, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, convertS3Error(err)
+	}
+	return toObjectInfo(key, stat), nil
+}
+
+// Delete 删除对象
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	name, err := s.objectName(key)
+	if err != nil {
+		return err
+	}
+	if err := s.client.RemoveObject(ctx, s.config.Bucket, name, minio.RemoveObjectOptions{}); err != nil {
+		if convertS3Error(err) == ErrNotFound {
+			return nil
+		}
+		return fmt.Errorf("failed to delete object: %w", err)
+	}
+	return nil
+}
+
+// Walk 遍历前缀下的所有对象
+func (s *S3Store) Walk(ctx context.Context, fn func(info *ObjectInfo) error) error {
+	prefix := ""
+	if s.config.Prefix != "" {
+		prefix = s.config.Prefix + "/"
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // 提前返回时停止列举
+	for obj := range s.client.ListObjects(ctx, s.config.Bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return fmt.Errorf("failed to list objects: %w", obj.Err)
+		}
+		if err := fn(toObjectInfo(strings.TrimPrefix(obj.Key, prefix), obj)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Location 返回 s3://bucket/key
+func (s *S3Store) Location(key string) string {
+	name, err := s.objectName(key)
+	if err != nil {
+		return ""
+	}
+	return "s3://" + s.config.Bucket + "/" + name
+}
+
+// PresignGet 生成预签名下载链接，expires 为 0 时使用配置的默认有效期
+func (s *S3Store) PresignGet(ctx context.Context, key string, expires time.Duration, downloadName string) (string, error) {
+	name, err := s.objectName(key)
+	if err != nil {
+		return "", err
+	}
+	if expires <= 0 {
+		expires = s.config.PresignExpiry
+	}
+
+	params := url.Values{}
+	if downloadName != "" {
+		params.Set("response-content-disposition", fmt.Sprintf("attachment; filename=%q", downloadName))
+	}
+	u, err := s.client.PresignedGetObject(ctx, s.config.Bucket, name, expires, params)
+	if err != nil {
+		return "", fmt.Errorf("failed to presign object: %w", err)
+	}
+	return u.String(), nil
+}
+
+// toObjectInfo 转换对象信息
+func toObjectInfo(key string, stat minio.ObjectInfo) *ObjectInfo {
+	return &ObjectInfo{
+		Key:         key,
+		Size:        stat.Size,
+		ContentType: stat.ContentType,
+		ModTime:     stat.LastModified,
+	}
+}
+
+// convertS3Error 将对象不存在的错误转换为 ErrNotFound
+func convertS3Error(err error) error {
+	resp := minio.ToErrorResponse(err)
+	if resp.Code == "NoSuchKey" || resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,186 @@
+// Package metrics 提供 Cloud 的 Prometheus 指标
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/cloud-agent/internal/common"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gorm.io/gorm"
+)
+
+const namespace = "cloud"
+
+// taskDurationBuckets 任务耗时分桶（秒），覆盖秒级命令到小时级部署
+var taskDurationBuckets = []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1800, 3600}
+
+// Metrics Cloud 指标
+// 所有方法都可以在 nil 上调用，未启用指标时不做任何处理
+type Metrics struct {
+	registry        *prometheus.Registry
+	tasksCreated    *prometheus.CounterVec
+	tasksCompleted  *prometheus.CounterVec
+	taskDuration    *prometheus.HistogramVec
+	dbQueryDuration *prometheus.HistogramVec
+}
+
+// New 创建 Cloud 指标，包含 Go 运行时和进程指标
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		tasksCreated: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "tasks_created_total",
+			Help:      "Number of tasks created, by task type.",
+		}, []string{"type"}),
+		tasksCompleted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "tasks_completed_total",
+			Help:      "Number of tasks finished, by task type and final status.",
+		}, []string{"type", "status"}),
+		taskDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "task_duration_seconds",
+			Help:      "Task duration from start (or creation) to completion, by task type and final status.",
+			Buckets:   taskDurationBuckets,
+		}, []string{"type", "status"}),
+		dbQueryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "db_query_duration_seconds",
+			Help:      "Database statement latency, by operation and table.",
+			Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+		}, []string{"operation", "table"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.tasksCreated,
+		m.tasksCompleted,
+		m.taskDuration,
+		m.dbQueryDuration,
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "ws_send_dropped_total",
+			Help:      "Messages dropped because a WebSocket send buffer was full.",
+		}, func() float64 { return float64(common.DroppedMessages()) }),
+	)
+	return m
+}
+
+// Registry 返回指标注册表，用于注册其他组件的指标
+func (m *Metrics) Registry() *prometheus.Registry {
+	return m.registry
+}
+
+// Handler 返回 /metrics 的 HTTP 处理器
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// RegisterConnectedAgents 注册按环境统计的已连接 Agent 数，每次抓取时调用 fn
+// 多副本部署时每个副本只统计本副本持有的连接
+func (m *Metrics) RegisterConnectedAgents(fn func() map[string]int) {
+	if m == nil {
+		return
+	}
+	m.registry.MustRegister(&agentCollector{
+		desc: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "agents_connected"),
+			"Number of agents connected to this replica, by env.", []string{"env"}, nil),
+		count: fn,
+	})
+}
+
+// TaskCreated 记录任务创建
+func (m *Metrics) TaskCreated(taskType common.TaskType) {
+	if m == nil {
+		return
+	}
+	m.tasksCreated.WithLabelValues(string(taskType)).Inc()
+}
+
+// TaskCompleted 记录任务结束及耗时（从开始执行计算，未开始时从创建计算）
+func (m *Metrics) TaskCompleted(task *common.Task) {
+	if m == nil {
+		return
+	}
+	start := task.CreatedAt
+	if task.StartedAt != nil {
+		start = *task.StartedAt
+	}
+	end := time.Now()
+	if task.FinishedAt != nil {
+		end = *task.FinishedAt
+	}
+	labels := []string{string(task.Type), string(task.Status)}
+	m.tasksCompleted.WithLabelValues(labels...).Inc()
+	if !start.IsZero() && end.After(start) {
+		m.taskDuration.WithLabelValues(labels...).Observe(end.Sub(start).Seconds())
+	}
+}
+
+// startTimeKey gorm 语句开始时间在 InstanceSet 中的 key
+const startTimeKey = "metrics:start_time"
+
+// InstrumentDB 通过 gorm 回调记录每条数据库语句的耗时
+func (m *Metrics) InstrumentDB(db *gorm.DB) error {
+	if m == nil {
+		return nil
+	}
+	before := func(tx *gorm.DB) {
+		tx.InstanceSet(startTimeKey, time.Now())
+	}
+	after := func(operation string) func(*gorm.DB) {
+		return func(tx *gorm.DB) {
+			v, ok := tx.InstanceGet(startTimeKey)
+			if !ok {
+				return
+			}
+			start, ok := v.(time.Time)
+			if !ok {
+				return
+			}
+			table := tx.Statement.Table
+			if table == "" {
+				table = "unknown"
+			}
+			m.dbQueryDuration.WithLabelValues(operation, table).Observe(time.Since(start).Seconds())
+		}
+	}
+
+	cb := db.Callback()
+	return errors.Join(
+		cb.Create().Before("gorm:create").Register("metrics:before_create", before),
+		cb.Create().After("gorm:create").Register("metrics:after_create", after("create")),
+		cb.Query().Before("gorm:query").Register("metrics:before_query", before),
+		cb.Query().After("gorm:query").Register("metrics:after_query", after("query")),
+		cb.Update().Before("gorm:update").Register("metrics:before_update", before),
+		cb.Update().After("gorm:update").Register("metrics:after_update", after("update")),
+		cb.Delete().Before("gorm:delete").Register("metrics:before_delete", before),
+		cb.Delete().After("gorm:delete").Register("metrics:after_delete", after("delete")),
+		cb.Row().Before("gorm:row").Register("metrics:before_row", before),
+		cb.Row().After("gorm:row").Register("metrics:after_row", after("row")),
+		cb.Raw().Before("gorm:raw").Register("metrics:before_raw", before),
+		cb.Raw().After("gorm:raw").Register("metrics:after_raw", after("raw")),
+	)
+}
+
+// agentCollector 在抓取时统计已连接的 Agent
+type agentCollector struct {
+	desc  *prometheus.Desc
+	count func() map[string]int
+}
+
+func (c *agentCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *agentCollector) Collect(ch chan<- prometheus.Metric) {
+	for env, n := range c.count() {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(n), env)
+	}
+}
@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloud-agent/internal/cloud/storage"
+	"github.com/cloud-agent/internal/common"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestTaskAndAgentMetrics(t *testing.T) {
+	m := New()
+	m.RegisterConnectedAgents(func() map[string]int { return map[string]int{"prod": 2, "dev": 1} })
+
+	m.TaskCreated(common.TaskTypeShell)
+	m.TaskCreated(common.TaskTypeShell)
+	started := time.Now().Add(-3 * time.Second)
+	finished := time.Now()
+	m.TaskCompleted(&common.Task{Type: common.TaskTypeShell, Status: common.TaskStatusFailed, StartedAt: &started, FinishedAt: &finished})
+
+	if got := testutil.ToFloat64(m.tasksCreated.WithLabelValues("shell")); got != 2 {
+		t.Errorf("tasks created = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(m.tasksCompleted.WithLabelValues("shell", "failed")); got != 1 {
+		t.Errorf("tasks completed = %v, want 1", got)
+	}
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		`cloud_agents_connected{env="prod"} 2`,
+		`cloud_task_duration_seconds_bucket{status="failed",type="shell",le="5"} 1`,
+		"cloud_ws_send_dropped_total",
+		"go_goroutines",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics output missing %q", want)
+		}
+	}
+
+	// nil 指标不做任何处理
+	var disabled *Metrics
+	disabled.TaskCreated(common.TaskTypeShell)
+	disabled.TaskCompleted(&common.Task{})
+}
+
+func TestInstrumentDB(t *testing.T) {
+	db, err := storage.NewDatabaseWithConfig(&storage.Config{
+		DSN:      filepath.Join(t.TempDir(), "cloud.db"),
+		LogLevel: "silent",
+	})
+	if err != nil {
+		t.Fatalf("NewDatabaseWithConfig failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	m := New()
+	if err := m.InstrumentDB(db.GetDB()); err != nil {
+		t.Fatalf("InstrumentDB failed: %v", err)
+	}
+	if err := db.CreateTask(&common.Task{ID: "t1", Type: common.TaskTypeShell}); err != nil {
+		t.Fatalf("CreateTask failed: %v", err)
+	}
+	if _, err := db.GetTask("t1"); err != nil {
+		t.Fatalf("GetTask failed: %v", err)
+	}
+
+	if n := testutil.CollectAndCount(m.dbQueryDuration); n < 2 {
+		t.Errorf("db latency series = %d, want at least create and query", n)
+	}
+}
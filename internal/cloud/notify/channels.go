@@ -0,0 +1,180 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloud-agent/internal/common"
+)
+
+// Webhook 请求头
+const (
+	HeaderEvent     = "X-Notify-Event"     // 事件类型
+	HeaderDelivery  = "X-Notify-Delivery"  // 投递记录 ID，重试时不变，可用于去重
+	HeaderTimestamp = "X-Notify-Timestamp" // 发送时间（Unix 秒）
+	HeaderSignature = "X-Notify-Signature" // sha256=<hex(HMAC-SHA256(secret, timestamp + "." + body))>
+)
+
+// maxResponseBody 读取响应体的最大长度（用于错误信息）
+const maxResponseBody = 4096
+
+// eventTitles 事件标题
+var eventTitles = map[string]string{
+	EventTaskFailed:      "任务失败",
+	EventTaskSucceeded:   "任务成功",
+	EventTaskCanceled:    "任务已取消",
+	EventAgentOffline:    "Agent 离线",
+	EventAgentFlapping:   "Agent 连接抖动",
+	EventApprovalPending: "任务等待审批",
+}
+
+// Sign 计算通用 Webhook 的签名，接收方用相同的密钥计算后比较 X-Notify-Signature
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// send 按渠道类型发送事件，返回 HTTP 状态码，permanent 表示重试也不会成功
+func (n *Notifier) send(ch *ChannelConfig, d *common.NotificationDelivery, e *Event) (code int, permanent bool, err error) {
+	now := time.Now()
+	target := ch.URL
+	var body []byte
+	switch ch.Type {
+	case ChannelWebhook:
+		body, err = json.Marshal(e)
+	case ChannelSlack:
+		body, err = json.Marshal(map[string]string{"text": renderMarkdown(e, "*", "• ")})
+	case ChannelDingTalk:
+		body, err = json.Marshal(map[string]interface{}{
+			"msgtype": "markdown",
+			"markdown": map[string]string{
+				"title": eventTitle(e),
+				"text":  renderMarkdown(e, "**", "- "),
+			},
+		})
+		if err == nil && ch.Secret != "" {
+			target, err = dingTalkSignedURL(ch.URL, ch.Secret, now)
+		}
+	case ChannelWeCom:
+		body, err = json.Marshal(map[string]interface{}{
+			"msgtype":  "markdown",
+			"markdown": map[string]string{"content": renderMarkdown(e, "**", "> ")},
+		})
+	default:
+		return 0, true, fmt.Errorf("unknown channel type: %s", ch.Type)
+	}
+	if err != nil {
+		return 0, true, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ch.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return 0, true, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "cloud-agent-notify")
+	if ch.Type == ChannelWebhook {
+		for k, v := range ch.Headers {
+			req.Header.Set(k, v)
+		}
+		timestamp := strconv.FormatInt(now.Unix(), 10)
+		req.Header.Set(HeaderEvent, e.Type)
+		req.Header.Set(HeaderDelivery, strconv.FormatUint(uint64(d.ID), 10))
+		req.Header.Set(HeaderTimestamp, timestamp)
+		if ch.Secret != "" {
+			req.Header.Set(HeaderSignature, Sign(ch.Secret, timestamp, body))
+		}
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return 0, false, err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		// 4xx 通常是地址或签名配置错误，除超时和限流外不再重试
+		permanent = resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+			resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests
+		return resp.StatusCode, permanent, fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+
+	// 钉钉和企业微信出错时仍返回 200，通过 errcode 判断
+	if ch.Type == ChannelDingTalk || ch.Type == ChannelWeCom {
+		var result struct {
+			ErrCode int    `json:"errcode"`
+			ErrMsg  string `json:"errmsg"`
+		}
+		if json.Unmarshal(respBody, &result) == nil && result.ErrCode != 0 {
+			return resp.StatusCode, false, fmt.Errorf("errcode %d: %s", result.ErrCode, result.ErrMsg)
+		}
+	}
+	return resp.StatusCode, false, nil
+}
+
+// dingTalkSignedURL 为钉钉机器人地址追加加签参数
+func dingTalkSignedURL(rawURL, secret string, now time.Time) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	timestamp := strconv.FormatInt(now.UnixMilli(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "\n" + secret))
+
+	query := u.Query()
+	query.Set("timestamp", timestamp)
+	query.Set("sign", base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// eventTitle 返回事件标题
+func eventTitle(e *Event) string {
+	if title, ok := eventTitles[e.Type]; ok {
+		return title
+	}
+	return e.Type
+}
+
+// renderMarkdown 将事件渲染为聊天消息，bold 为加粗标记，bullet 为每行前缀
+func renderMarkdown(e *Event, bold, bullet string) string {
+	var b strings.Builder
+	b.WriteString(bold + eventTitle(e) + bold + "\n")
+	line := func(label, value string) {
+		if value != "" {
+			b.WriteString(bullet + label + "：" + value + "\n")
+		}
+	}
+	if e.TaskID != "" {
+		line("任务", fmt.Sprintf("%s（%s）", e.TaskID, e.TaskType))
+	}
+	if e.AgentName != "" && e.AgentName != e.AgentID {
+		line("Agent", fmt.Sprintf("%s（%s）", e.AgentName, e.AgentID))
+	} else {
+		line("Agent", e.AgentID)
+	}
+	line("环境", e.Env)
+	line("创建者", e.CreatedBy)
+	line("错误", e.Error)
+	line("时间", e.Timestamp.Local().Format("2006-01-02 15:04:05"))
+	return strings.TrimRight(b.String(), "\n")
+}
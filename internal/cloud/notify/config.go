@@ -0,0 +1,196 @@
+package notify
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/cloud-agent/internal/common"
+	"gopkg.in/yaml.v3"
+)
+
+// 通知渠道类型
+const (
+	ChannelWebhook  = "webhook"  // 通用 HTTP Webhook，请求体为事件 JSON，配置 secret 时使用 HMAC-SHA256 签名
+	ChannelSlack    = "slack"    // Slack Incoming Webhook
+	ChannelDingTalk = "dingtalk" // 钉钉自定义机器人，配置 secret 时使用加签
+	ChannelWeCom    = "wecom"    // 企业微信群机器人
+)
+
+// Config 通知配置
+type Config struct {
+	PollInterval      time.Duration   `yaml:"poll_interval"`      // 检查待投递记录的间隔
+	DeliveryRetention time.Duration   `yaml:"delivery_retention"` // 已结束的投递记录保留时长，0 表示永久保留
+	Retry             RetryConfig     `yaml:"retry"`
+	Channels          []ChannelConfig `yaml:"channels"`
+	Rules             []Rule          `yaml:"rules"`
+}
+
+// RetryConfig 投递失败的重试策略，第 n 次重试前等待 Backoff * 2^(n-1)，最长 MaxBackoff
+type RetryConfig struct {
+	MaxAttempts int           `yaml:"max_attempts"` // 最多尝试次数（包括第一次）
+	Backoff     time.Duration `yaml:"backoff"`
+	MaxBackoff  time.Duration `yaml:"max_backoff"`
+}
+
+// ChannelConfig 通知渠道配置
+type ChannelConfig struct {
+	Name    string            `yaml:"name"`
+	Type    string            `yaml:"type"` // webhook、slack、dingtalk、wecom
+	URL     string            `yaml:"url"`
+	Secret  string            `yaml:"secret"`  // webhook 的签名密钥或钉钉机器人的加签密钥
+	Headers map[string]string `yaml:"headers"` // 额外请求头（仅 webhook）
+	Timeout time.Duration     `yaml:"timeout"` // 单次请求超时
+}
+
+// Rule 通知规则：事件类型匹配且满足所有已配置的过滤条件时发送到 Channels
+type Rule struct {
+	Name      string            `yaml:"name"`
+	Events    []string          `yaml:"events"`     // 事件类型，* 表示所有事件
+	Envs      []string          `yaml:"envs"`       // Agent 所属环境，为空表示不限
+	Tags      []string          `yaml:"tags"`       // 任务或 Agent 标签，命中任意一个即可，为空表示不限
+	TaskTypes []common.TaskType `yaml:"task_types"` // 任务类型，为空表示不限（配置后不匹配 Agent 事件）
+	Channels  []string          `yaml:"channels"`   // 通知渠道名称
+}
+
+// DefaultConfig 返回默认配置：没有通知渠道和规则
+func DefaultConfig() *Config {
+	return &Config{
+		PollInterval:      5 * time.Second,
+		DeliveryRetention: 30 * 24 * time.Hour,
+		Retry: RetryConfig{
+			MaxAttempts: 5,
+			Backoff:     10 * time.Second,
+			MaxBackoff:  10 * time.Minute,
+		},
+	}
+}
+
+// defaultChannelTimeout 渠道未配置超时时的请求超时
+const defaultChannelTimeout = 10 * time.Second
+
+// LoadConfig 从 YAML 文件加载通知配置，path 为空时返回默认配置
+// 文件中的 ${VAR} 会被替换为环境变量的值，便于从 Secret 注入 URL 和密钥
+func LoadConfig(path string) (*Config, error) {
+	cfg := DefaultConfig()
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read notify config: %w", err)
+	}
+	if err := yaml.Unmarshal([]byte(os.ExpandEnv(string(data))), cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse notify config: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid notify config: %w", err)
+	}
+	return cfg, nil
+}
+
+// Validate 校验配置并补全默认值
+func (c *Config) Validate() error {
+	defaults := DefaultConfig()
+	if c.PollInterval <= 0 {
+		c.PollInterval = defaults.PollInterval
+	}
+	if c.Retry.MaxAttempts <= 0 {
+		c.Retry.MaxAttempts = defaults.Retry.MaxAttempts
+	}
+	if c.Retry.Backoff <= 0 {
+		c.Retry.Backoff = defaults.Retry.Backoff
+	}
+	if c.Retry.MaxBackoff < c.Retry.Backoff {
+		c.Retry.MaxBackoff = c.Retry.Backoff
+	}
+
+	channels := make(map[string]bool, len(c.Channels))
+	for i := range c.Channels {
+		ch := &c.Channels[i]
+		if ch.Name == "" {
+			return fmt.Errorf("channel %d: name is required", i+1)
+		}
+		if channels[ch.Name] {
+			return fmt.Errorf("channel %s: duplicate name", ch.Name)
+		}
+		channels[ch.Name] = true
+		switch ch.Type {
+		case ChannelWebhook, ChannelSlack, ChannelDingTalk, ChannelWeCom:
+		default:
+			return fmt.Errorf("channel %s: unknown type %q", ch.Name, ch.Type)
+		}
+		u, err := url.Parse(ch.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("channel %s: url must be an http(s) URL", ch.Name)
+		}
+		if ch.Timeout <= 0 {
+			ch.Timeout = defaultChannelTimeout
+		}
+	}
+
+	for i, rule := range c.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("rule %d: name is required", i+1)
+		}
+		if len(rule.Events) == 0 {
+			return fmt.Errorf("rule %s: events is required", rule.Name)
+		}
+		for _, event := range rule.Events {
+			if event != "*" && !knownEvents[event] {
+				return fmt.Errorf("rule %s: unknown event %q", rule.Name, event)
+			}
+		}
+		if len(rule.Channels) == 0 {
+			return fmt.Errorf("rule %s: channels is required", rule.Name)
+		}
+		for _, name := range rule.Channels {
+			if !channels[name] {
+				return fmt.Errorf("rule %s: unknown channel %q", rule.Name, name)
+			}
+		}
+	}
+	return nil
+}
+
+// channel 按名称查找通知渠道
+func (c *Config) channel(name string) *ChannelConfig {
+	for i := range c.Channels {
+		if c.Channels[i].Name == name {
+			return &c.Channels[i]
+		}
+	}
+	return nil
+}
+
+// backoff 返回第 attempts 次尝试失败后的等待时间
+func (r *RetryConfig) backoff(attempts int) time.Duration {
+	d := r.Backoff
+	for i := 1; i < attempts && d < r.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > r.MaxBackoff {
+		d = r.MaxBackoff
+	}
+	return d
+}
+
+// matches 判断事件是否匹配规则
+func (r *Rule) matches(e *Event) bool {
+	if !slices.Contains(r.Events, "*") && !slices.Contains(r.Events, e.Type) {
+		return false
+	}
+	if len(r.Envs) > 0 && !slices.Contains(r.Envs, e.Env) {
+		return false
+	}
+	if len(r.TaskTypes) > 0 && !slices.Contains(r.TaskTypes, e.TaskType) {
+		return false
+	}
+	if len(r.Tags) > 0 && !slices.ContainsFunc(e.Tags, func(tag string) bool { return slices.Contains(r.Tags, tag) }) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,343 @@
+// Package notify 按规则将任务和 Agent 事件发送到 Webhook、Slack、钉钉、企业微信等通知渠道
+//
+// 事件先写入数据库中的投递记录，再由后台投递：失败时按重试策略重新投递，
+// 多副本部署时各副本共同处理投递记录，每条记录同一时间只由一个副本投递。
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cloud-agent/internal/cloud/storage"
+	"github.com/cloud-agent/internal/common"
+	"github.com/cloud-agent/internal/tracing"
+	"github.com/google/uuid"
+)
+
+// 事件类型
+const (
+	EventTaskFailed      = "task.failed"
+	EventTaskSucceeded   = "task.succeeded"
+	EventTaskCanceled    = "task.canceled"
+	EventAgentOffline    = "agent.offline"
+	EventAgentFlapping   = "agent.flapping"
+	EventApprovalPending = "approval.pending"
+)
+
+// knownEvents 规则中允许配置的事件类型
+var knownEvents = map[string]bool{
+	EventTaskFailed:      true,
+	EventTaskSucceeded:   true,
+	EventTaskCanceled:    true,
+	EventAgentOffline:    true,
+	EventAgentFlapping:   true,
+	EventApprovalPending: true,
+}
+
+// maxErrorLength 事件中错误信息的最大长度
+const maxErrorLength = 1000
+
+// claimBatchSize 每次领取的投递记录数
+const claimBatchSize = 100
+
+// Event 通知事件，通用 Webhook 的请求体
+// 不包含任务命令和结果，避免敏感信息发送到外部系统
+type Event struct {
+	ID         string            `json:"id"`
+	Type       string            `json:"type"`
+	Timestamp  time.Time         `json:"timestamp"`
+	Env        string            `json:"env,omitempty"`
+	Tags       []string          `json:"tags,omitempty"`
+	AgentID    string            `json:"agent_id,omitempty"`
+	AgentName  string            `json:"agent_name,omitempty"`
+	TaskID     string            `json:"task_id,omitempty"`
+	TaskType   common.TaskType   `json:"task_type,omitempty"`
+	TaskStatus common.TaskStatus `json:"task_status,omitempty"`
+	CreatedBy  string            `json:"created_by,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// Notifier 通知器
+// 所有方法都可以在 nil 上调用，未启用通知时不做任何处理
+type Notifier struct {
+	db     *storage.Database
+	cfg    *Config
+	client *http.Client
+
+	wakeCh    chan struct{}
+	stopCh    chan struct{}
+	stopOnce  sync.Once
+	wg        sync.WaitGroup
+	lastPurge time.Time
+}
+
+// NewNotifier 创建通知器，cfg 为 nil 时使用默认配置（不发送通知）
+func NewNotifier(db *storage.Database, cfg *Config) *Notifier {
+	if cfg == nil {
+		cfg = DefaultConfig()
+	}
+	return &Notifier{
+		db:     db,
+		cfg:    cfg,
+		client: &http.Client{Transport: tracing.Transport(nil)},
+		wakeCh: make(chan struct{}, 1),
+		stopCh: make(chan struct{}),
+	}
+}
+
+// Start 启动后台投递，未配置通知渠道时不启动，Close 时停止
+func (n *Notifier) Start() {
+	if n == nil || len(n.cfg.Channels) == 0 {
+		return
+	}
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		ticker := time.NewTicker(n.cfg.PollInterval)
+		defer ticker.Stop()
+		for {
+			n.deliverDue()
+			select {
+			case <-n.stopCh:
+				return
+			case <-ticker.C:
+			case <-n.wakeCh:
+			}
+		}
+	}()
+}
+
+// Close 停止后台投递，等待正在进行的投递结束
+func (n *Notifier) Close() {
+	if n == nil {
+		return
+	}
+	n.stopOnce.Do(func() { close(n.stopCh) })
+	n.wg.Wait()
+}
+
+// TaskFinished 发送任务结束事件（失败、成功或取消）
+func (n *Notifier) TaskFinished(task *common.Task) {
+	if n == nil {
+		return
+	}
+	var eventType string
+	switch task.Status {
+	case common.TaskStatusFailed:
+		eventType = EventTaskFailed
+	case common.TaskStatusSuccess:
+		eventType = EventTaskSucceeded
+	case common.TaskStatusCanceled:
+		eventType = EventTaskCanceled
+	default:
+		return
+	}
+	n.Publish(n.taskEvent(eventType, task))
+}
+
+// ApprovalPending 发送任务等待审批事件
+func (n *Notifier) ApprovalPending(task *common.Task) {
+	if n == nil {
+		return
+	}
+	n.Publish(n.taskEvent(EventApprovalPending, task))
+}
+
+// AgentOffline 发送 Agent 离线事件
+func (n *Notifier) AgentOffline(agent *common.Agent) {
+	if n == nil {
+		return
+	}
+	n.Publish(&Event{
+		Type:      EventAgentOffline,
+		Env:       agent.Env,
+		Tags:      agent.Tags,
+		AgentID:   agent.ID,
+		AgentName: agent.Name,
+	})
+}
+
+// AgentFlapping 发送 Agent 连接抖动事件：window 时间内断开了 disconnects 次
+func (n *Notifier) AgentFlapping(agent *common.Agent, disconnects int, window time.Duration) {
+	if n == nil {
+		return
+	}
+	n.Publish(&Event{
+		Type:      EventAgentFlapping,
+		Env:       agent.Env,
+		Tags:      agent.Tags,
+		AgentID:   agent.ID,
+		AgentName: agent.Name,
+		Error:     fmt.Sprintf("%d disconnects in %s", disconnects, window),
+	})
+}
+
+// taskEvent 构造任务事件，环境取自任务所在 Agent
+func (n *Notifier) taskEvent(eventType string, task *common.Task) *Event {
+	e := &Event{
+		Type:       eventType,
+		Tags:       task.Tags,
+		AgentID:    task.AgentID,
+		TaskID:     task.ID,
+		TaskType:   task.Type,
+		TaskStatus: task.Status,
+		CreatedBy:  task.CreatedBy,
+		Error:      task.Error,
+	}
+	if len(e.Error) > maxErrorLength {
+		e.Error = e.Error[:maxErrorLength] + "..."
+	}
+	if agent, err := n.db.GetAgent(task.AgentID); err == nil {
+		e.Env = agent.Env
+		e.AgentName = agent.Name
+	}
+	return e
+}
+
+// Publish 按规则为事件创建投递记录，每个通知渠道只投递一次
+func (n *Notifier) Publish(e *Event) {
+	if n == nil {
+		return
+	}
+	if e.ID == "" {
+		e.ID = uuid.New().String()
+	}
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now()
+	}
+
+	var deliveries []*common.NotificationDelivery
+	seen := make(map[string]bool)
+	for i := range n.cfg.Rules {
+		rule := &n.cfg.Rules[i]
+		if !rule.matches(e) {
+			continue
+		}
+		for _, name := range rule.Channels {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			deliveries = append(deliveries, &common.NotificationDelivery{
+				EventID:       e.ID,
+				EventType:     e.Type,
+				Rule:          rule.Name,
+				Channel:       name,
+				ChannelType:   n.cfg.channel(name).Type,
+				Status:        common.NotificationStatusPending,
+				NextAttemptAt: e.Timestamp,
+			})
+		}
+	}
+	if len(deliveries) == 0 {
+		return
+	}
+
+	payload, _ := json.Marshal(e)
+	for _, d := range deliveries {
+		d.Payload = string(payload)
+	}
+	if err := n.db.CreateNotificationDeliveries(deliveries); err != nil {
+		log.Printf("[notify] failed to queue %s event %s: %v", e.Type, e.ID, err)
+		return
+	}
+	n.wake()
+}
+
+// Retry 重新投递指定的投递记录
+func (n *Notifier) Retry(id uint) error {
+	if err := n.db.RetryNotificationDelivery(id); err != nil {
+		return err
+	}
+	n.wake()
+	return nil
+}
+
+// wake 唤醒后台投递
+func (n *Notifier) wake() {
+	select {
+	case n.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+// deliverDue 投递所有到期的记录，并定期清理已结束的旧记录
+func (n *Notifier) deliverDue() {
+	for {
+		deliveries, err := n.db.ClaimDueNotificationDeliveries(time.Now(), n.lease(), claimBatchSize)
+		if err != nil {
+			log.Printf("[notify] failed to claim deliveries: %v", err)
+			return
+		}
+		for _, d := range deliveries {
+			select {
+			case <-n.stopCh:
+				// 已领取未投递的记录在 lease 到期后由其他副本或下次启动时投递
+				return
+			default:
+			}
+			n.deliver(d)
+		}
+		if len(deliveries) < claimBatchSize {
+			break
+		}
+	}
+
+	if n.cfg.DeliveryRetention > 0 && time.Since(n.lastPurge) > time.Hour {
+		n.lastPurge = time.Now()
+		if purged, err := n.db.PurgeNotificationDeliveries(time.Now().Add(-n.cfg.DeliveryRetention)); err != nil {
+			log.Printf("[notify] failed to purge deliveries: %v", err)
+		} else if purged > 0 {
+			log.Printf("[notify] purged %d deliveries", purged)
+		}
+	}
+}
+
+// lease 领取投递记录后独占的时长，需要覆盖一批记录的投递时间
+func (n *Notifier) lease() time.Duration {
+	lease := time.Minute
+	for _, ch := range n.cfg.Channels {
+		if ch.Timeout*claimBatchSize > lease {
+			lease = ch.Timeout * claimBatchSize
+		}
+	}
+	return lease
+}
+
+// deliver 投递一条记录并保存结果
+func (n *Notifier) deliver(d *common.NotificationDelivery) {
+	var e Event
+	err := json.Unmarshal([]byte(d.Payload), &e)
+	permanent := err != nil
+	if err == nil {
+		if ch := n.cfg.channel(d.Channel); ch == nil {
+			err, permanent = common.NewErrorf("channel %s is not configured", d.Channel), true
+		} else {
+			d.ResponseCode, permanent, err = n.send(ch, d, &e)
+		}
+	}
+
+	now := time.Now()
+	switch {
+	case err == nil:
+		d.Status = common.NotificationStatusSuccess
+		d.LastError = ""
+		d.DeliveredAt = &now
+	case permanent || d.Attempts >= n.cfg.Retry.MaxAttempts:
+		d.Status = common.NotificationStatusFailed
+		d.LastError = err.Error()
+		log.Printf("[notify] delivery %d of %s event %s to %s failed after %d attempts: %v",
+			d.ID, d.EventType, d.EventID, d.Channel, d.Attempts, err)
+	default:
+		d.Status = common.NotificationStatusPending
+		d.LastError = err.Error()
+		d.NextAttemptAt = now.Add(n.cfg.Retry.backoff(d.Attempts))
+	}
+	if err := n.db.UpdateNotificationDelivery(d); err != nil {
+		log.Printf("[notify] failed to save delivery %d: %v", d.ID, err)
+	}
+}
@@ -0,0 +1,202 @@
+package notify
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloud-agent/internal/cloud/storage"
+	"github.com/cloud-agent/internal/common"
+)
+
+// receiver 本地 HTTP 替身，按顺序返回 statuses 中的状态码（用完后返回 200）
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	body     string
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, body)
+	status := http.StatusOK
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	w.WriteHeader(status)
+	io.WriteString(w, r.body)
+}
+
+func newTestNotifier(t *testing.T, cfg *Config) (*Notifier, *storage.Database) {
+	t.Helper()
+	db, err := storage.NewDatabaseWithConfig(&storage.Config{
+		DSN:      filepath.Join(t.TempDir(), "cloud.db"),
+		LogLevel: "silent",
+	})
+	if err != nil {
+		t.Fatalf("NewDatabaseWithConfig failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	return NewNotifier(db, cfg), db
+}
+
+func listDeliveries(t *testing.T, db *storage.Database) []*common.NotificationDelivery {
+	t.Helper()
+	deliveries, err := db.ListNotificationDeliveries(&storage.NotificationFilter{Limit: 100})
+	if err != nil {
+		t.Fatalf("ListNotificationDeliveries failed: %v", err)
+	}
+	return deliveries
+}
+
+func TestWebhookSignatureAndRetry(t *testing.T) {
+	recv := &receiver{statuses: []int{http.StatusBadGateway}}
+	srv := httptest.NewServer(recv)
+	defer srv.Close()
+
+	n, db := newTestNotifier(t, &Config{
+		Retry:    RetryConfig{MaxAttempts: 3, Backoff: time.Millisecond},
+		Channels: []ChannelConfig{{Name: "hook", Type: ChannelWebhook, URL: srv.URL, Secret: "s3cret"}},
+		Rules:    []Rule{{Name: "prod", Events: []string{EventTaskFailed}, Envs: []string{"prod"}, Channels: []string{"hook"}}},
+	})
+	db.CreateAgent(&common.Agent{ID: "a1", Name: "web-1", Env: "prod"})
+	db.CreateAgent(&common.Agent{ID: "a2", Name: "web-2", Env: "dev"})
+
+	n.TaskFinished(&common.Task{ID: "t1", AgentID: "a1", Type: common.TaskTypeShell, Status: common.TaskStatusFailed, Error: "exit 1"})
+	n.TaskFinished(&common.Task{ID: "t2", AgentID: "a2", Type: common.TaskTypeShell, Status: common.TaskStatusFailed})
+	n.TaskFinished(&common.Task{ID: "t3", AgentID: "a1", Type: common.TaskTypeShell, Status: common.TaskStatusSuccess})
+
+	n.deliverDue()
+	deliveries := listDeliveries(t, db)
+	if len(deliveries) != 1 || deliveries[0].Status != common.NotificationStatusPending || deliveries[0].ResponseCode != http.StatusBadGateway {
+		t.Fatalf("expected one pending delivery after 502, got %+v", deliveries)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	n.deliverDue()
+	deliveries = listDeliveries(t, db)
+	if deliveries[0].Status != common.NotificationStatusSuccess || deliveries[0].Attempts != 2 || deliveries[0].DeliveredAt == nil {
+		t.Fatalf("expected delivery to succeed on retry, got %+v", deliveries[0])
+	}
+
+	if len(recv.requests) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(recv.requests))
+	}
+	req, body := recv.requests[1], recv.bodies[1]
+	if req.Header.Get(HeaderEvent) != EventTaskFailed {
+		t.Fatalf("unexpected event header: %q", req.Header.Get(HeaderEvent))
+	}
+	if want := Sign("s3cret", req.Header.Get(HeaderTimestamp), body); req.Header.Get(HeaderSignature) != want {
+		t.Fatalf("signature mismatch: got %q want %q", req.Header.Get(HeaderSignature), want)
+	}
+	var e Event
+	if err := json.Unmarshal(body, &e); err != nil {
+		t.Fatalf("invalid payload: %v", err)
+	}
+	if e.TaskID != "t1" || e.Env != "prod" || e.AgentName != "web-1" || e.Error != "exit 1" {
+		t.Fatalf("unexpected event: %+v", e)
+	}
+}
+
+func TestPermanentFailureAndManualRetry(t *testing.T) {
+	recv := &receiver{statuses: []int{http.StatusNotFound}}
+	srv := httptest.NewServer(recv)
+	defer srv.Close()
+
+	n, db := newTestNotifier(t, &Config{
+		Channels: []ChannelConfig{{Name: "slack", Type: ChannelSlack, URL: srv.URL}},
+		Rules:    []Rule{{Name: "all", Events: []string{"*"}, Channels: []string{"slack"}}},
+	})
+	n.AgentOffline(&common.Agent{ID: "a1", Name: "web-1", Env: "prod"})
+
+	n.deliverDue()
+	deliveries := listDeliveries(t, db)
+	if len(deliveries) != 1 || deliveries[0].Status != common.NotificationStatusFailed || deliveries[0].Attempts != 1 {
+		t.Fatalf("expected 404 to fail without retry, got %+v", deliveries)
+	}
+
+	if err := n.Retry(deliveries[0].ID); err != nil {
+		t.Fatalf("Retry failed: %v", err)
+	}
+	n.deliverDue()
+	if d := listDeliveries(t, db)[0]; d.Status != common.NotificationStatusSuccess {
+		t.Fatalf("expected manual retry to succeed, got %+v", d)
+	}
+	var msg map[string]string
+	json.Unmarshal(recv.bodies[1], &msg)
+	if !strings.Contains(msg["text"], "Agent 离线") || !strings.Contains(msg["text"], "web-1（a1）") {
+		t.Fatalf("unexpected slack message: %q", msg["text"])
+	}
+}
+
+func TestDingTalkSigningAndErrCode(t *testing.T) {
+	recv := &receiver{body: `{"errcode":310000,"errmsg":"sign not match"}`}
+	srv := httptest.NewServer(recv)
+	defer srv.Close()
+
+	n, db := newTestNotifier(t, &Config{
+		Retry:    RetryConfig{MaxAttempts: 1},
+		Channels: []ChannelConfig{{Name: "ding", Type: ChannelDingTalk, URL: srv.URL + "/robot/send?access_token=abc", Secret: "SEC123"}},
+		Rules:    []Rule{{Name: "db", Events: []string{EventTaskFailed}, Tags: []string{"db"}, Channels: []string{"ding"}}},
+	})
+	n.TaskFinished(&common.Task{ID: "t1", AgentID: "a1", Type: common.TaskTypeMySQL, Status: common.TaskStatusFailed, Tags: []string{"db", "nightly"}})
+	n.TaskFinished(&common.Task{ID: "t2", AgentID: "a1", Type: common.TaskTypeMySQL, Status: common.TaskStatusFailed, Tags: []string{"web"}})
+
+	n.deliverDue()
+	deliveries := listDeliveries(t, db)
+	if len(deliveries) != 1 || deliveries[0].Status != common.NotificationStatusFailed || !strings.Contains(deliveries[0].LastError, "310000") {
+		t.Fatalf("expected errcode to fail the delivery, got %+v", deliveries)
+	}
+
+	query := recv.requests[0].URL.Query()
+	if query.Get("access_token") != "abc" || query.Get("timestamp") == "" || query.Get("sign") == "" {
+		t.Fatalf("missing signing parameters: %s", recv.requests[0].URL.RawQuery)
+	}
+	var msg struct {
+		MsgType  string            `json:"msgtype"`
+		Markdown map[string]string `json:"markdown"`
+	}
+	json.Unmarshal(recv.bodies[0], &msg)
+	if msg.MsgType != "markdown" || msg.Markdown["title"] != "任务失败" || !strings.Contains(msg.Markdown["text"], "t1（mysql）") {
+		t.Fatalf("unexpected dingtalk message: %+v", msg)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	cases := map[string]*Config{
+		"unknown channel type": {Channels: []ChannelConfig{{Name: "x", Type: "email", URL: "http://x"}}},
+		"invalid url":          {Channels: []ChannelConfig{{Name: "x", Type: ChannelWebhook, URL: "ftp://x"}}},
+		"unknown event": {
+			Channels: []ChannelConfig{{Name: "x", Type: ChannelWebhook, URL: "http://x"}},
+			Rules:    []Rule{{Name: "r", Events: []string{"task.exploded"}, Channels: []string{"x"}}},
+		},
+		"unknown rule channel": {
+			Channels: []ChannelConfig{{Name: "x", Type: ChannelWebhook, URL: "http://x"}},
+			Rules:    []Rule{{Name: "r", Events: []string{EventTaskFailed}, Channels: []string{"y"}}},
+		},
+	}
+	for name, cfg := range cases {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s: expected validation error", name)
+		}
+	}
+
+	retry := RetryConfig{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	if retry.backoff(1) != time.Second || retry.backoff(3) != 4*time.Second || retry.backoff(10) != 5*time.Second {
+		t.Fatalf("unexpected backoff: %v %v %v", retry.backoff(1), retry.backoff(3), retry.backoff(10))
+	}
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultUserHeader 认证代理传递已认证用户名的默认请求头
+const DefaultUserHeader = "X-Forwarded-User"
+
+// DefaultTrustedProxies 默认只信任本机上的认证代理
+var DefaultTrustedProxies = []string{"127.0.0.1", "::1"}
+
+// userKey 已认证用户名在 gin.Context 中的键
+const userKey = "auth.user"
+
+// AuthConfig 请求用户的认证方式
+// Cloud 部署在认证代理（如 oauth2-proxy）之后，代理认证用户后通过请求头传递用户名；
+// 只有来自受信任代理地址的请求头才被采用，其他请求视为未认证
+type AuthConfig struct {
+	UserHeader     string   // 用户名请求头，默认 X-Forwarded-User
+	TrustedProxies []string // 受信任代理的 IP 或 CIDR，默认 127.0.0.1 和 ::1
+}
+
+// authenticate 从受信任代理设置的请求头中读取已认证的用户名
+func authenticate(cfg *AuthConfig) gin.HandlerFunc {
+	header := DefaultUserHeader
+	proxies := DefaultTrustedProxies
+	if cfg != nil {
+		if cfg.UserHeader != "" {
+			header = cfg.UserHeader
+		}
+		if cfg.TrustedProxies != nil {
+			proxies = cfg.TrustedProxies
+		}
+	}
+	trusted := parsePrefixes(proxies)
+
+	return func(c *gin.Context) {
+		user := strings.TrimSpace(c.GetHeader(header))
+		if user != "" && trustedPeer(c.Request, trusted) {
+			c.Set(userKey, user)
+		}
+		c.Next()
+	}
+}
+
+// parsePrefixes 解析 IP 和 CIDR 列表，无效的条目记录日志后忽略
+func parsePrefixes(values []string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(value); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			log.Printf("Warning: ignoring invalid trusted proxy %q", value)
+			continue
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes
+}
+
+// trustedPeer 请求的直接来源是否为受信任的代理（不使用 X-Forwarded-For，客户端可以伪造）
+func trustedPeer(r *http.Request, trusted []netip.Prefix) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestUser 返回已认证的用户名，未认证时返回空
+func requestUser(c *gin.Context) string {
+	return c.GetString(userKey)
+}
+
+// resolveUser 以已认证用户作为请求的操作人，请求体中的 field 只能与已认证用户一致
+// 未认证时请求体指定了用户返回 401，与已认证用户不一致时返回 403，失败时已写入响应
+func resolveUser(c *gin.Context, field, claimed string) (string, bool) {
+	user := requestUser(c)
+	if claimed == "" || claimed == user {
+		return user, true
+	}
+	if user == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": field + " requires an authenticated user"})
+	} else {
+		c.JSON(http.StatusForbidden, gin.H{"error": field + " does not match the authenticated user"})
+	}
+	return "", false
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloud-agent/internal/cloud/cluster"
+	"github.com/cloud-agent/internal/cloud/storage"
+	"github.com/cloud-agent/internal/common"
+	"github.com/gin-gonic/gin"
+)
+
+// newTestServer 创建使用临时 SQLite 数据库的服务器，Agent a1 连接在另一个副本上
+func newTestServer(t *testing.T) (*Server, *storage.Database) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	db, err := storage.NewDatabaseWithConfig(&storage.Config{DSN: filepath.Join(dir, "cloud.db"), LogLevel: "silent"})
+	if err != nil {
+		t.Fatalf("NewDatabaseWithConfig failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	cl := cluster.NewLocal("r1")
+	if _, err := cl.Registry.Register("a1", "r2"); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	now := time.Now()
+	db.CreateAgent(&common.Agent{ID: "a1", Name: "a1", Status: common.AgentStatusOnline, LastSeen: &now})
+
+	s := NewServerWithConfig(db, &Config{
+		FileStorage: filepath.Join(dir, "files"),
+		Cluster:     cl,
+		Auth:        &AuthConfig{TrustedProxies: []string{"10.0.0.0/8"}},
+	})
+	t.Cleanup(s.Close)
+	return s, db
+}
+
+// do 从 remote 地址发送请求，user 不为空时设置用户名请求头
+func do(s *Server, method, path, remote, user, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.RemoteAddr = remote
+	req.Header.Set("Content-Type", "application/json")
+	if user != "" {
+		req.Header.Set(DefaultUserHeader, user)
+	}
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestCreateTaskUsesAuthenticatedUser(t *testing.T) {
+	s, _ := newTestServer(t)
+	const proxy, client = "10.1.2.3:40000", "192.0.2.10:40000"
+
+	tests := []struct {
+		name, remote, user, createdBy string
+		status                        int
+		want                          string
+	}{
+		{"anonymous", client, "", "", http.StatusCreated, ""},
+		{"unauthenticated claim", client, "", "alice", http.StatusUnauthorized, ""},
+		{"header from untrusted peer", client, "alice", "alice", http.StatusUnauthorized, ""},
+		{"mismatched claim", proxy, "bob", "alice", http.StatusForbidden, ""},
+		{"from header", proxy, "alice", "", http.StatusCreated, "alice"},
+		{"matching claim", proxy, "alice", "alice", http.StatusCreated, "alice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"agent_id":"a1","type":"shell","command":"true","created_by":"` + tt.createdBy + `"}`
+			w := do(s, http.MethodPost, "/api/v1/tasks", tt.remote, tt.user, body)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			if w.Code != http.StatusCreated {
+				return
+			}
+			var created common.Task
+			json.Unmarshal(w.Body.Bytes(), &created)
+			if created.CreatedBy != tt.want {
+				t.Errorf("created_by = %q, want %q", created.CreatedBy, tt.want)
+			}
+		})
+	}
+}
+
+func TestApprovalRequiresAuthenticatedUser(t *testing.T) {
+	s, db := newTestServer(t)
+	const proxy = "10.1.2.3:40000"
+	db.CreateTask(&common.Task{ID: "t1", AgentID: "a1", Type: common.TaskTypeShell, Status: common.TaskStatusPendingApproval, CreatedBy: "alice"})
+
+	if w := do(s, http.MethodPost, "/api/v1/tasks/t1/reject", "192.0.2.10:40000", "", `{"approver":"bob"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("unauthenticated reject status = %d, want 401", w.Code)
+	}
+	if w := do(s, http.MethodPost, "/api/v1/tasks/t1/approve", proxy, "", `{}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("approve without user status = %d, want 401", w.Code)
+	}
+	if w := do(s, http.MethodPost, "/api/v1/tasks/t1/reject", proxy, "mallory", `{"approver":"bob"}`); w.Code != http.StatusForbidden {
+		t.Errorf("mismatched approver status = %d, want 403", w.Code)
+	}
+	// 已认证的创建者不能审批自己的任务
+	if w := do(s, http.MethodPost, "/api/v1/tasks/t1/approve", proxy, "alice", `{}`); w.Code != http.StatusForbidden {
+		t.Errorf("self approval status = %d, want 403", w.Code)
+	}
+
+	w := do(s, http.MethodPost, "/api/v1/tasks/t1/reject", proxy, "bob", `{"reason":"freeze"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("reject status = %d: %s", w.Code, w.Body.String())
+	}
+	if task, _ := db.GetTask("t1"); task.Status != common.TaskStatusCanceled || !strings.Contains(task.Error, "rejected by bob") {
+		t.Errorf("rejected task = %s %q", task.Status, task.Error)
+	}
+}
@@ -1,12 +1,23 @@
 package server
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/cloud-agent/internal/cloud/agentconfig"
+	"github.com/cloud-agent/internal/cloud/audit"
+	"github.com/cloud-agent/internal/cloud/filestore"
+	"github.com/cloud-agent/internal/cloud/storage"
+	"github.com/cloud-agent/internal/cloud/task"
+	"github.com/cloud-agent/internal/cloud/vault"
 	"github.com/cloud-agent/internal/common"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 // listAgents 列出所有 Agent
@@ -62,6 +73,44 @@ func (s *Server) getAgentStatus(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"status": status})
 }
 
+// getAgentConnections 获取 Agent 连接历史和抖动状态
+func (s *Server) getAgentConnections(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit <= 0 || limit > 1000 {
+		limit = 50
+	}
+	history, err := s.agentMgr.ConnectionHistory(c.Param("id"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, history)
+}
+
+// getAgentTelemetry 获取 Agent 资源使用时间序列（默认最近 1 小时）
+func (s *Server) getAgentTelemetry(c *gin.Context) {
+	since, err := parseTimeParam(c.Query("since"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid since: " + err.Error()})
+		return
+	}
+	if since == nil {
+		t := time.Now().Add(-time.Hour)
+		since = &t
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "500"))
+	if limit <= 0 || limit > 5000 {
+		limit = 500
+	}
+
+	samples, err := s.db.ListAgentTelemetrySamples(c.Param("id"), *since, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, samples)
+}
+
 // deleteAgent 删除 Agent
 func (s *Server) deleteAgent(c *gin.Context) {
 	agentID := c.Param("id")
@@ -93,25 +142,40 @@ func (s *Server) updateAgent(c *gin.Context) {
 	}
 
 	log.Printf("Updated agent %s, tags: %v", agentID, agent.Tags)
+	// 标签变化可能改变适用的远程配置
+	if _, ok := req["tags"]; ok {
+		if err := s.configMgr.PushToAgent(agentID); err != nil {
+			log.Printf("Failed to push configs to agent %s: %v", agentID, err)
+		}
+	}
 	c.JSON(http.StatusOK, agent)
 }
 
 // createTask 创建任务
 func (s *Server) createTask(c *gin.Context) {
 	var req struct {
-		AgentID string                 `json:"agent_id" binding:"required"`
-		Type    common.TaskType        `json:"type" binding:"required"`
-		Command string                 `json:"command"`
-		Params  map[string]interface{} `json:"params"`
-		FileID  string                 `json:"file_id"`
-		Sync    *bool                  `json:"sync"`    // 是否同步等待，默认 false（异步）
-		Timeout *int                   `json:"timeout"` // 同步模式超时时间（秒），默认 60
+		AgentID    string                 `json:"agent_id"`   // 为空时按 selector 选择 Agent
+		Selector   *task.TaskTarget       `json:"selector"`   // Agent 选择条件和调度策略
+		Env        string                 `json:"env"`        // selector.env 的简写
+		Capability string                 `json:"capability"` // selector.capability 的简写，如 postgres 或 postgres:prod
+		Type       common.TaskType        `json:"type" binding:"required"`
+		Command    string                 `json:"command"`
+		Params     map[string]interface{} `json:"params"`
+		FileID     string                 `json:"file_id"`
+		Sync       *bool                  `json:"sync"`       // 是否同步等待，默认 false（异步）
+		Timeout    *int                   `json:"timeout"`    // 同步模式超时时间（秒），默认 60
+		Tags       []string               `json:"tags"`       // 任务标签
+		CreatedBy  string                 `json:"created_by"` // 创建者，可省略，指定时必须与已认证用户一致
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	createdBy, ok := resolveUser(c, "created_by", req.CreatedBy)
+	if !ok {
+		return
+	}
 
 	// 处理 sync 参数，默认为 false（异步）
 	sync := false
@@ -131,30 +195,127 @@ func (s *Server) createTask(c *gin.Context) {
 		}
 	}
 
+	if req.AgentID == "" {
+		target := req.Selector
+		if target == nil {
+			target = &task.TaskTarget{}
+		}
+		if req.Env != "" {
+			target.Env = req.Env
+		}
+		if req.Capability != "" {
+			target.Capability = req.Capability
+		}
+		if target.Empty() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "agent_id or selector is required"})
+			return
+		}
+		agentID, err := s.taskMgr.SelectAgent(target, req.Type, req.Params)
+		if err != nil {
+			c.JSON(taskErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		req.AgentID = agentID
+	}
+
 	log.Printf("[DEBUG] Creating task with sync=%v, timeout=%d", sync, timeout)
 
-	task, err := s.taskMgr.CreateTask(req.AgentID, req.Type, req.Command, req.Params, req.FileID, sync, timeout)
+	task, err := s.taskMgr.CreateTaskWithOptions(c.Request.Context(), req.AgentID, req.Type, req.Command, req.Params, req.FileID, sync, timeout,
+		&task.TaskOptions{Tags: req.Tags, CreatedBy: createdBy})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(taskErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
 	log.Printf("[DEBUG] Returning task %s with Params: %s", task.ID, task.Params)
+	if task.Status == common.TaskStatusPendingApproval {
+		c.JSON(http.StatusAccepted, task)
+		return
+	}
 	c.JSON(http.StatusCreated, task)
 }
 
+// taskErrorStatus 创建任务失败时的 HTTP 状态码
+func taskErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, task.ErrCapabilityMissing), errors.Is(err, task.ErrInvalidTarget), errors.Is(err, task.ErrApprovalParams):
+		return http.StatusBadRequest
+	case errors.Is(err, task.ErrPolicyDenied), errors.Is(err, task.ErrApprovalNotAllowed):
+		return http.StatusForbidden
+	case errors.Is(err, task.ErrNotPendingApproval):
+		return http.StatusConflict
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, task.ErrNoMatchingAgent):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 // listTasks 列出任务
+// 返回任务数组，符合条件的总数和下一页游标分别通过 X-Total-Count 和 X-Next-Cursor 响应头返回
 func (s *Server) listTasks(c *gin.Context) {
-	agentID := c.Query("agent_id")
+	filter, err := parseTaskFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
 	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
 
-	tasks, err := s.db.ListTasks(agentID, limit, offset)
+	order := c.DefaultQuery("order", "desc")
+	if order != "asc" && order != "desc" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "order must be asc or desc"})
+		return
+	}
+
+	page, err := s.db.SearchTasks(&storage.TaskQuery{
+		Filter: *filter,
+		SortBy: c.Query("sort"),
+		Asc:    order == "asc",
+		Cursor: c.Query("cursor"),
+		Limit:  limit,
+		Offset: offset,
+	})
 	if err != nil {
+		var invalid *common.Error
+		if errors.As(err, &invalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, tasks)
+
+	c.Header("X-Total-Count", strconv.FormatInt(page.Total, 10))
+	if page.NextCursor != "" {
+		c.Header("X-Next-Cursor", page.NextCursor)
+	}
+	if page.Tasks == nil {
+		page.Tasks = []*common.Task{}
+	}
+	c.JSON(http.StatusOK, page.Tasks)
+}
+
+// taskStats 按状态统计符合过滤条件的任务数
+func (s *Server) taskStats(c *gin.Context) {
+	filter, err := parseTaskFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	counts, err := s.db.CountTasksByStatus(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var total int64
+	for _, n := range counts {
+		total += n
+	}
+	c.JSON(http.StatusOK, gin.H{"total": total, "by_status": counts})
 }
 
 // getTask 获取任务信息
@@ -173,7 +334,8 @@ func (s *Server) getTaskLogs(c *gin.Context) {
 	taskID := c.Param("id")
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "1000"))
 
-	logs, err := s.db.GetTaskLogs(taskID, limit)
+	// 已归档的日志从存储后端读取
+	logs, err := s.taskMgr.GetTaskLogs(taskID, limit)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -181,6 +343,375 @@ func (s *Server) getTaskLogs(c *gin.Context) {
 	c.JSON(http.StatusOK, logs)
 }
 
+// compactLogs 立即按保留策略归档或删除任务日志
+func (s *Server) compactLogs(c *gin.Context) {
+	result, err := s.taskMgr.CompactLogs(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// searchLogs 全文搜索任务日志
+func (s *Server) searchLogs(c *gin.Context) {
+	q := &storage.LogSearchQuery{
+		Query:   strings.TrimSpace(c.Query("q")),
+		Levels:  splitTags(c.Query("level")),
+		TaskID:  c.Query("task_id"),
+		AgentID: c.Query("agent_id"),
+		Env:     c.Query("env"),
+	}
+	q.Limit, _ = strconv.Atoi(c.DefaultQuery("limit", "100"))
+	q.FacetLimit, _ = strconv.Atoi(c.DefaultQuery("facet_limit", "20"))
+
+	var err error
+	if q.Since, err = parseTimeParam(c.Query("since")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid since: " + err.Error()})
+		return
+	}
+	if q.Until, err = parseTimeParam(c.Query("until")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid until: " + err.Error()})
+		return
+	}
+	if cursor := c.Query("cursor"); cursor != "" {
+		id, err := strconv.ParseUint(cursor, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+			return
+		}
+		q.BeforeID = uint(id)
+	}
+
+	result, err := s.taskMgr.SearchLogs(c.Request.Context(), q, c.Query("include_archived") == "true")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// listAuditEvents 查询审计事件（按 ID 升序）
+// 返回事件数组，下一页游标通过 X-Next-Cursor 响应头返回
+func (s *Server) listAuditEvents(c *gin.Context) {
+	filter, err := parseAuditFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	events, err := s.db.ListAuditEvents(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(events) == filter.PageSize() {
+		c.Header("X-Next-Cursor", strconv.FormatUint(events[len(events)-1].ID, 10))
+	}
+	if events == nil {
+		events = []*common.AuditEvent{}
+	}
+	c.JSON(http.StatusOK, events)
+}
+
+// exportAuditEvents 导出审计事件，format 为 jsonl（默认）或 csv
+func (s *Server) exportAuditEvents(c *gin.Context) {
+	filter, err := parseAuditFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	format := c.DefaultQuery("format", audit.FormatJSONL)
+	var contentType string
+	switch format {
+	case audit.FormatJSONL:
+		contentType = "application/x-ndjson"
+	case audit.FormatCSV:
+		contentType = "text/csv; charset=utf-8"
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be jsonl or csv"})
+		return
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="audit-%s.%s"`, time.Now().Format("20060102-150405"), format))
+	c.Status(http.StatusOK)
+	// 响应头已发送，导出中途失败只能记录日志
+	if n, err := audit.Export(s.db, c.Writer, format, *filter); err != nil {
+		log.Printf("[audit] export failed after %d events: %v", n, err)
+	}
+}
+
+// verifyAuditChain 校验审计事件哈希链
+func (s *Server) verifyAuditChain(c *gin.Context) {
+	result, err := s.db.VerifyAuditChain()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// listNotificationDeliveries 列出通知投递记录（按 ID 倒序）
+// 返回投递记录数组，下一页游标通过 X-Next-Cursor 响应头返回
+func (s *Server) listNotificationDeliveries(c *gin.Context) {
+	filter := &storage.NotificationFilter{
+		Status:    c.Query("status"),
+		EventType: c.Query("event_type"),
+		EventID:   c.Query("event_id"),
+		Channel:   c.Query("channel"),
+	}
+	filter.Limit, _ = strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if filter.Limit <= 0 || filter.Limit > 1000 {
+		filter.Limit = 50
+	}
+	if cursor := c.Query("cursor"); cursor != "" {
+		id, err := strconv.ParseUint(cursor, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+			return
+		}
+		filter.BeforeID = uint(id)
+	}
+
+	deliveries, err := s.db.ListNotificationDeliveries(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(deliveries) == filter.Limit {
+		c.Header("X-Next-Cursor", strconv.FormatUint(uint64(deliveries[len(deliveries)-1].ID), 10))
+	}
+	if deliveries == nil {
+		deliveries = []*common.NotificationDelivery{}
+	}
+	c.JSON(http.StatusOK, deliveries)
+}
+
+// retryNotificationDelivery 重新投递通知
+func (s *Server) retryNotificationDelivery(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid delivery id"})
+		return
+	}
+	if err := s.notifier.Retry(uint(id)); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "delivery not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "delivery queued for retry"})
+}
+
+// publishAgentConfig 发布 Agent 远程配置的新版本
+func (s *Server) publishAgentConfig(c *gin.Context) {
+	var req struct {
+		Kind      string `json:"kind" binding:"required"`
+		ScopeType string `json:"scope_type"` // 默认为 global
+		Scope     string `json:"scope"`
+		Content   string `json:"content" binding:"required"`
+		Comment   string `json:"comment"`
+		CreatedBy string `json:"created_by"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.ScopeType == "" {
+		req.ScopeType = common.AgentConfigScopeGlobal
+	}
+
+	cfg := &common.AgentConfig{
+		Kind:      req.Kind,
+		ScopeType: req.ScopeType,
+		Scope:     req.Scope,
+		Content:   req.Content,
+		Comment:   req.Comment,
+		CreatedBy: req.CreatedBy,
+	}
+	if err := s.configMgr.Publish(cfg); err != nil {
+		if errors.Is(err, agentconfig.ErrInvalidConfig) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, cfg)
+}
+
+// listAgentConfigs 列出 Agent 远程配置的历史版本
+func (s *Server) listAgentConfigs(c *gin.Context) {
+	filter := &storage.AgentConfigFilter{
+		Kind:      c.Query("kind"),
+		ScopeType: c.Query("scope_type"),
+		Scope:     c.Query("scope"),
+	}
+	filter.Limit, _ = strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if filter.Limit <= 0 || filter.Limit > 1000 {
+		filter.Limit = 50
+	}
+
+	configs, err := s.db.ListAgentConfigs(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if configs == nil {
+		configs = []*common.AgentConfig{}
+	}
+	c.JSON(http.StatusOK, configs)
+}
+
+// getAgentConfig 获取 Agent 远程配置的某个版本
+func (s *Server) getAgentConfig(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid config id"})
+		return
+	}
+	cfg, err := s.db.GetAgentConfig(uint(id))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "config not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, cfg)
+}
+
+// rollbackAgentConfig 将历史版本的内容作为新版本重新发布
+func (s *Server) rollbackAgentConfig(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid config id"})
+		return
+	}
+	var req struct {
+		CreatedBy string `json:"created_by"`
+	}
+	// 请求体可以为空
+	_ = c.ShouldBindJSON(&req)
+
+	cfg, err := s.configMgr.Rollback(uint(id), req.CreatedBy)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "config not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, cfg)
+}
+
+// getAgentConfigStatus 获取 Agent 各类配置的期望版本和应用结果
+func (s *Server) getAgentConfigStatus(c *gin.Context) {
+	status, err := s.configMgr.Status(c.Param("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "agent not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// listSecrets 列出密钥库中的密钥（不含值）
+func (s *Server) listSecrets(c *gin.Context) {
+	secrets, err := s.vault.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if secrets == nil {
+		secrets = []*common.Secret{}
+	}
+	c.JSON(http.StatusOK, secrets)
+}
+
+// putSecret 创建或更新密钥
+func (s *Server) putSecret(c *gin.Context) {
+	var req struct {
+		Value        string   `json:"value" binding:"required"`
+		Env          string   `json:"env"`
+		AllowedHosts []string `json:"allowed_hosts"`
+		Description  string   `json:"description"`
+		UpdatedBy    string   `json:"updated_by"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	secret := &common.Secret{
+		Name:         c.Param("name"),
+		Env:          req.Env,
+		AllowedHosts: req.AllowedHosts,
+		Description:  req.Description,
+		UpdatedBy:    req.UpdatedBy,
+	}
+	if err := s.vault.Put(secret, req.Value); err != nil {
+		switch {
+		case errors.Is(err, vault.ErrInvalidSecret):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, vault.ErrDisabled):
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, secret)
+}
+
+// deleteSecret 删除密钥
+func (s *Server) deleteSecret(c *gin.Context) {
+	if err := s.vault.Delete(c.Param("name"), c.Query("updated_by")); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "secret not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "secret deleted"})
+}
+
+// parseAuditFilter 解析审计事件查询参数
+func parseAuditFilter(c *gin.Context) (*storage.AuditFilter, error) {
+	filter := &storage.AuditFilter{
+		Actions: splitTags(c.Query("action")),
+		Actor:   c.Query("actor"),
+		AgentID: c.Query("agent_id"),
+		TaskID:  c.Query("task_id"),
+		Outcome: c.Query("outcome"),
+	}
+	filter.Limit, _ = strconv.Atoi(c.Query("limit"))
+
+	var err error
+	if filter.Since, err = parseTimeParam(c.Query("since")); err != nil {
+		return nil, fmt.Errorf("invalid since: %w", err)
+	}
+	if filter.Until, err = parseTimeParam(c.Query("until")); err != nil {
+		return nil, fmt.Errorf("invalid until: %w", err)
+	}
+	if cursor := c.Query("cursor"); cursor != "" {
+		if filter.AfterID, err = strconv.ParseUint(cursor, 10, 64); err != nil {
+			return nil, errors.New("invalid cursor")
+		}
+	}
+	return filter, nil
+}
+
 // cancelTask 取消任务
 func (s *Server) cancelTask(c *gin.Context) {
 	taskID := c.Param("id")
@@ -191,6 +722,86 @@ func (s *Server) cancelTask(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "task canceled"})
 }
 
+// approvalRequest 审批请求，审批人为已认证用户
+type approvalRequest struct {
+	Approver string `json:"approver"` // 可省略，指定时必须与已认证用户一致
+	Reason   string `json:"reason"`
+}
+
+// bindApproval 解析审批请求并返回审批人，未认证的请求不能审批
+func bindApproval(c *gin.Context) (*approvalRequest, string, bool) {
+	var req approvalRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, "", false
+	}
+	approver, ok := resolveUser(c, "approver", req.Approver)
+	if !ok {
+		return nil, "", false
+	}
+	if approver == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "approval requires an authenticated user"})
+		return nil, "", false
+	}
+	return &req, approver, true
+}
+
+// approveTask 审批通过等待审批的任务并下发到 Agent
+func (s *Server) approveTask(c *gin.Context) {
+	req, approver, ok := bindApproval(c)
+	if !ok {
+		return
+	}
+	t, err := s.taskMgr.ApproveTask(c.Param("id"), approver, req.Reason)
+	if err != nil {
+		c.JSON(taskErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, t)
+}
+
+// rejectTask 拒绝等待审批的任务
+func (s *Server) rejectTask(c *gin.Context) {
+	req, approver, ok := bindApproval(c)
+	if !ok {
+		return
+	}
+	t, err := s.taskMgr.RejectTask(c.Param("id"), approver, req.Reason)
+	if err != nil {
+		c.JSON(taskErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, t)
+}
+
+// dryRunPolicy 按任务策略评估任务但不创建，返回匹配的规则和每条规则的评估过程
+// 指定 agent_id 时使用该 Agent 的环境和标签，否则使用请求中的 env 和 tags
+func (s *Server) dryRunPolicy(c *gin.Context) {
+	var req struct {
+		AgentID   string                 `json:"agent_id"`
+		Env       string                 `json:"env"`
+		Tags      []string               `json:"tags"`
+		Type      common.TaskType        `json:"type" binding:"required"`
+		Command   string                 `json:"command"`
+		Params    map[string]interface{} `json:"params"`
+		CreatedBy string                 `json:"created_by"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	in := &common.PolicyInput{User: req.CreatedBy, Env: req.Env, Tags: req.Tags, TaskType: req.Type, Command: req.Command, Params: req.Params}
+	if req.AgentID != "" {
+		if _, err := s.db.GetAgent(req.AgentID); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "agent not found"})
+			return
+		}
+		in = s.taskMgr.PolicyInput(req.AgentID, req.Type, req.Command, req.Params, req.CreatedBy)
+	}
+	c.JSON(http.StatusOK, s.taskMgr.EvaluatePolicy(in))
+}
+
 // uploadFile 上传文件
 func (s *Server) uploadFile(c *gin.Context) {
 	file, err := c.FormFile("file")
@@ -199,7 +810,17 @@ func (s *Server) uploadFile(c *gin.Context) {
 		return
 	}
 
-	fileInfo, err := s.taskMgr.UploadFile(file, s.fileStorage)
+	opts := &task.UploadOptions{Tags: splitTags(c.PostForm("tags"))}
+	if value, ok := c.GetPostForm("retention"); ok && value != "" {
+		retention, err := parseRetention(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		opts.Retention = &retention
+	}
+
+	fileInfo, err := s.taskMgr.UploadFile(file, opts)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -213,7 +834,10 @@ func (s *Server) listFiles(c *gin.Context) {
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
 	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
 
-	files, err := s.db.ListFiles(limit, offset)
+	files, err := s.db.ListFilesWithFilter(&storage.FileFilter{
+		Tag:  c.Query("tag"),
+		Name: c.Query("name"),
+	}, limit, offset)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -241,7 +865,36 @@ func (s *Server) downloadFile(c *gin.Context) {
 		return
 	}
 
-	c.File(file.Path)
+	// 旧版本上传的文件直接存储在 Path
+	if file.StorageKey == "" || file.Backend != s.fileStore.Type() {
+		c.File(file.Path)
+		return
+	}
+
+	// 支持预签名的存储后端直接重定向，避免文件内容经过 Cloud 中转
+	if url, err := s.fileStore.PresignGet(c.Request.Context(), file.StorageKey, 0, file.Name); err == nil {
+		c.Redirect(http.StatusFound, url)
+		return
+	}
+
+	reader, info, err := s.fileStore.Open(c.Request.Context(), file.StorageKey)
+	if err != nil {
+		if errors.Is(err, filestore.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "file content not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer reader.Close()
+
+	contentType := file.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	c.DataFromReader(http.StatusOK, info.Size, contentType, reader, map[string]string{
+		"Content-Disposition": fmt.Sprintf("attachment; filename=%q", file.Name),
+	})
 }
 
 // distributeFile 分发文件到 Agent
@@ -257,10 +910,165 @@ func (s *Server) distributeFile(c *gin.Context) {
 		return
 	}
 
-	if err := s.taskMgr.DistributeFile(fileID, req.AgentIDs, req.Path); err != nil {
+	dists, err := s.taskMgr.DistributeFile(fileID, req.AgentIDs, req.Path)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "file distribution started", "distributions": dists})
+}
+
+// updateFile 更新文件标签和保留时长
+func (s *Server) updateFile(c *gin.Context) {
+	fileID := c.Param("id")
+	var req struct {
+		Tags      []string `json:"tags"`
+		Retention *string  `json:"retention"` // 如 "72h"、"30d"，"0" 表示永久保留，不传则保持不变
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var retention *time.Duration
+	if req.Retention != nil {
+		d, err := parseRetention(*req.Retention)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		retention = &d
+	}
+
+	file, err := s.taskMgr.UpdateFileMetadata(fileID, req.Tags, retention)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "file not found"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	c.JSON(http.StatusOK, file)
+}
 
-	c.JSON(http.StatusOK, gin.H{"message": "file distribution started"})
+// deleteFile 删除文件，文件仍被待审批、待执行或执行中的任务引用时返回 409
+func (s *Server) deleteFile(c *gin.Context) {
+	fileID := c.Param("id")
+	if err := s.taskMgr.DeleteFile(fileID); err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "file not found"})
+		case errors.Is(err, task.ErrFileInUse):
+			taskIDs := []string{}
+			if tasks, err := s.db.ListActiveFileTasks(fileID); err == nil {
+				for _, t := range tasks {
+					taskIDs = append(taskIDs, t.ID)
+				}
+			}
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "task_ids": taskIDs})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "file deleted"})
+}
+
+// listFileDistributions 列出文件分发记录（文件分发到了哪些 Agent）
+func (s *Server) listFileDistributions(c *gin.Context) {
+	dists, err := s.taskMgr.ListFileDistributions(c.Param("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "file not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, dists)
+}
+
+// runFileGC 立即回收过期文件和孤立对象
+func (s *Server) runFileGC(c *gin.Context) {
+	result, err := s.taskMgr.RunFileGC(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// splitTags 解析逗号分隔的标签
+func splitTags(value string) []string {
+	var tags []string
+	for _, tag := range strings.Split(value, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// parseTaskFilter 解析任务列表的过滤参数
+func parseTaskFilter(c *gin.Context) (*storage.TaskFilter, error) {
+	filter := &storage.TaskFilter{
+		AgentID:   c.Query("agent_id"),
+		Env:       c.Query("env"),
+		Tag:       c.Query("tag"),
+		CreatedBy: c.Query("created_by"),
+		Query:     strings.TrimSpace(c.Query("q")),
+	}
+	for _, status := range splitTags(c.Query("status")) {
+		filter.Statuses = append(filter.Statuses, common.TaskStatus(status))
+	}
+	for _, taskType := range splitTags(c.Query("type")) {
+		filter.Types = append(filter.Types, common.TaskType(taskType))
+	}
+
+	var err error
+	if filter.Since, err = parseTimeParam(c.Query("since")); err != nil {
+		return nil, fmt.Errorf("invalid since: %w", err)
+	}
+	if filter.Until, err = parseTimeParam(c.Query("until")); err != nil {
+		return nil, fmt.Errorf("invalid until: %w", err)
+	}
+	return filter, nil
+}
+
+// parseTimeParam 解析时间参数，支持 RFC3339 和 Unix 时间戳（秒），为空时返回 nil
+func parseTimeParam(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if sec, err := strconv.ParseInt(value, 10, 64); err == nil {
+		t := time.Unix(sec, 0)
+		return &t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// parseRetention 解析保留时长，支持 Go duration 格式和以 d 结尾的天数（如 30d）
+func parseRetention(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "0" {
+		return 0, nil
+	}
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid retention: %s", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid retention: %s", value)
+	}
+	return d, nil
 }
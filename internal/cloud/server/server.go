@@ -4,27 +4,89 @@ import (
 	"crypto/tls"
 	"log"
 	"net/http"
-	"os"
 
 	"github.com/cloud-agent/internal/cloud/agent"
+	"github.com/cloud-agent/internal/cloud/agentconfig"
+	"github.com/cloud-agent/internal/cloud/audit"
+	"github.com/cloud-agent/internal/cloud/cluster"
+	"github.com/cloud-agent/internal/cloud/filestore"
+	"github.com/cloud-agent/internal/cloud/metrics"
+	"github.com/cloud-agent/internal/cloud/notify"
 	"github.com/cloud-agent/internal/cloud/storage"
 	"github.com/cloud-agent/internal/cloud/task"
+	"github.com/cloud-agent/internal/cloud/vault"
+	"github.com/cloud-agent/internal/common"
+	"github.com/cloud-agent/internal/tracing"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Server Cloud 服务器
 type Server struct {
-	router      *gin.Engine
-	db          *storage.Database
-	agentMgr    *agent.Manager
-	taskMgr     *task.Manager
-	upgrader    websocket.Upgrader
-	fileStorage string
+	router    *gin.Engine
+	db        *storage.Database
+	agentMgr  *agent.Manager
+	taskMgr   *task.Manager
+	upgrader  websocket.Upgrader
+	fileStore filestore.FileStore
+	metrics   *metrics.Metrics
+	auditor   *audit.Recorder
+	notifier  *notify.Notifier
+	configMgr *agentconfig.Manager
+	vault     *vault.Vault
 }
 
-// NewServer 创建新服务器
+// Config 服务器配置
+type Config struct {
+	FileStorage string              // 文件存储路径（本地存储）
+	FileStore   filestore.FileStore // 文件存储后端，为 nil 时使用 FileStorage 目录的本地存储
+	Cluster     *cluster.Cluster    // 集群（多副本路由），为 nil 时使用单副本集群
+	// FileLifecycle 文件保留策略和回收配置，为 nil 时使用默认配置
+	FileLifecycle *task.FileLifecycleConfig
+	// LogRetention 任务日志保留策略，为 nil 时使用默认配置
+	LogRetention *task.LogRetentionConfig
+	// Metrics Prometheus 指标，为 nil 时创建默认指标
+	Metrics *metrics.Metrics
+	// Notify 通知渠道和规则，为 nil 时不发送通知
+	Notify *notify.Config
+	// Health Agent 健康检查配置，为 nil 时使用默认配置
+	Health *agent.HealthConfig
+	// InFlight Agent 离线时的在途任务处理策略，为 nil 时在途任务全部标记为失败
+	InFlight *task.InFlightConfig
+	// SecretsKey 密钥库的 32 字节加密密钥，为 nil 时密钥库不可用
+	SecretsKey []byte
+	// Redaction 保存任务日志、结果和参数前的脱敏规则，为 nil 时只使用内置规则
+	Redaction *common.RedactionConfig
+	// Policy 下发前评估的任务策略，为 nil 时允许所有任务
+	Policy *common.PolicyConfig
+	// Auth 请求用户的认证方式（认证代理传递的用户名），为 nil 时使用默认配置
+	Auth *AuthConfig
+}
+
+// NewServer 创建新服务器（单副本）
 func NewServer(db *storage.Database, fileStorage string) *Server {
+	return NewServerWithConfig(db, &Config{FileStorage: fileStorage})
+}
+
+// NewServerWithConfig 根据配置创建服务器
+func NewServerWithConfig(db *storage.Database, cfg *Config) *Server {
+	cl := cfg.Cluster
+	if cl == nil {
+		cl = cluster.NewLocal("")
+	}
+
+	store := cfg.FileStore
+	if store == nil {
+		localStore, err := filestore.NewLocalStore(cfg.FileStorage)
+		if err != nil {
+			log.Fatalf("Failed to create file storage directory: %v", err)
+		}
+		store = localStore
+	}
+
 	upgrader := websocket.Upgrader{
 		CheckOrigin: func(r *http.Request) bool {
 			return true // 允许所有来源，生产环境应该限制
@@ -38,29 +100,73 @@ func NewServer(db *storage.Database, fileStorage string) *Server {
 	router.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, traceparent, tracestate")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "X-Total-Count, X-Next-Cursor")
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
 			return
 		}
 		c.Next()
 	})
+	router.Use(authenticate(cfg.Auth))
+
+	mt := cfg.Metrics
+	if mt == nil {
+		mt = metrics.New()
+	}
+	if err := mt.InstrumentDB(db.GetDB()); err != nil {
+		log.Printf("Warning: failed to instrument database metrics: %v", err)
+	}
 
 	s := &Server{
-		router:      router,
-		db:          db,
-		upgrader:    upgrader,
-		fileStorage: fileStorage,
+		router:    router,
+		db:        db,
+		upgrader:  upgrader,
+		fileStore: store,
+		metrics:   mt,
+		auditor:   audit.NewRecorder(db),
+		notifier:  notify.NewNotifier(db, cfg.Notify),
 	}
 
 	// 初始化管理器
-	s.agentMgr = agent.NewManager(db, s.handleAgentMessage)
-	s.taskMgr = task.NewManager(db, s.agentMgr)
-
-	// 确保文件存储目录存在
-	if err := os.MkdirAll(fileStorage, 0755); err != nil {
-		log.Fatalf("Failed to create file storage directory: %v", err)
+	s.agentMgr = agent.NewManager(db, cl, s.handleAgentMessage)
+	s.taskMgr = task.NewManager(db, s.agentMgr, cl, store)
+	s.taskMgr.SetMetrics(mt)
+	s.taskMgr.SetAuditor(s.auditor)
+	s.taskMgr.SetNotifier(s.notifier)
+	s.agentMgr.SetNotifier(s.notifier)
+	s.taskMgr.SetInFlightPolicy(cfg.InFlight)
+	redactor, err := common.NewRedactor(cfg.Redaction)
+	if err != nil {
+		log.Printf("Warning: invalid redaction config, using builtin rules: %v", err)
+	}
+	s.taskMgr.SetRedactor(redactor)
+	policy, err := common.NewPolicyEngine(cfg.Policy)
+	if err != nil {
+		log.Fatalf("Invalid policy config: %v", err)
+	}
+	s.taskMgr.SetPolicy(policy)
+	s.agentMgr.SetTaskHandler(s.taskMgr)
+	s.configMgr = agentconfig.NewManager(db, s.agentMgr)
+	s.configMgr.SetAuditor(s.auditor)
+	v, err := vault.NewVault(db, s.agentMgr, cfg.SecretsKey)
+	if err != nil {
+		log.Printf("Warning: secret vault disabled: %v", err)
+		v, _ = vault.NewVault(db, s.agentMgr, nil)
 	}
+	s.vault = v
+	s.vault.SetAuditor(s.auditor)
+	s.agentMgr.SetSecretsPusher(func(agentID string) {
+		if err := s.vault.PushToAgent(agentID); err != nil {
+			log.Printf("Failed to push secrets to agent %s: %v", agentID, err)
+		}
+	})
+	s.agentMgr.StartHealthMonitor(cfg.Health)
+	s.notifier.Start()
+	mt.RegisterConnectedAgents(s.agentMgr.ConnectedAgentsByEnv)
+	s.taskMgr.StartFileGC(cfg.FileLifecycle)
+	s.taskMgr.StartLogCompactor(cfg.LogRetention)
+	log.Printf("Cloud replica ID: %s, file store: %s", cl.ReplicaID, store.Type())
 
 	s.setupRoutes()
 
@@ -70,38 +176,99 @@ func NewServer(db *storage.Database, fileStorage string) *Server {
 // setupRoutes 设置路由
 func (s *Server) setupRoutes() {
 	// API 路由
-	api := s.router.Group("/api/v1")
+	api := s.router.Group("/api/v1", traceRequests)
 	{
 		// Agent 相关
 		api.GET("/agents", s.listAgents)
 		api.GET("/agents/:id", s.getAgent)
 		api.GET("/agents/:id/status", s.getAgentStatus)
+		api.GET("/agents/:id/connections", s.getAgentConnections)
+		api.GET("/agents/:id/telemetry", s.getAgentTelemetry)
+		api.GET("/agents/:id/config", s.getAgentConfigStatus)
 		api.PUT("/agents/:id", s.updateAgent)
 		api.DELETE("/agents/:id", s.deleteAgent)
 
+		// Agent 远程配置
+		api.POST("/agent-configs", s.publishAgentConfig)
+		api.GET("/agent-configs", s.listAgentConfigs)
+		api.GET("/agent-configs/:id", s.getAgentConfig)
+		api.POST("/agent-configs/:id/rollback", s.rollbackAgentConfig)
+
+		// 密钥库
+		api.GET("/secrets", s.listSecrets)
+		api.PUT("/secrets/:name", s.putSecret)
+		api.DELETE("/secrets/:name", s.deleteSecret)
+
 		// 任务相关
 		api.POST("/tasks", s.createTask)
 		api.GET("/tasks", s.listTasks)
+		api.GET("/tasks/stats", s.taskStats)
 		api.GET("/tasks/:id", s.getTask)
 		api.GET("/tasks/:id/logs", s.getTaskLogs)
 		api.POST("/tasks/:id/cancel", s.cancelTask)
+		api.POST("/tasks/:id/approve", s.approveTask)
+		api.POST("/tasks/:id/reject", s.rejectTask)
+		api.POST("/policy/dry-run", s.dryRunPolicy)
+		api.POST("/logs/compact", s.compactLogs)
+		api.GET("/logs/search", s.searchLogs)
+
+		// 审计
+		api.GET("/audit/events", s.listAuditEvents)
+		api.GET("/audit/export", s.exportAuditEvents)
+		api.GET("/audit/verify", s.verifyAuditChain)
+
+		// 通知
+		api.GET("/notifications/deliveries", s.listNotificationDeliveries)
+		api.POST("/notifications/deliveries/:id/retry", s.retryNotificationDelivery)
 
 		// 文件相关
 		api.POST("/files", s.uploadFile)
 		api.GET("/files", s.listFiles)
+		api.POST("/files/gc", s.runFileGC)
 		api.GET("/files/:id", s.getFile)
+		api.PUT("/files/:id", s.updateFile)
+		api.DELETE("/files/:id", s.deleteFile)
 		api.GET("/files/:id/download", s.downloadFile)
 		api.POST("/files/:id/distribute", s.distributeFile)
+		api.GET("/files/:id/distributions", s.listFileDistributions)
 	}
 
 	// WebSocket 路由
 	s.router.GET("/ws", s.handleWebSocket)
 
+	// Prometheus 指标
+	s.router.GET("/metrics", gin.WrapH(s.metrics.Handler()))
+
 	// 静态文件服务（用于 Cloud UI）
 	s.router.Static("/static", "./cloud-ui/dist")
 	s.router.StaticFile("/", "./cloud-ui/dist/index.html")
 }
 
+// traceRequests 为 API 请求创建服务端 span，沿用请求头中的 traceparent
+func traceRequests(c *gin.Context) {
+	ctx := tracing.ExtractHTTP(c.Request.Context(), c.Request.Header)
+	ctx, span := tracing.StartKind(ctx, c.Request.Method+" "+c.FullPath(), trace.SpanKindServer,
+		attribute.String("http.request.method", c.Request.Method),
+		attribute.String("http.route", c.FullPath()))
+	defer span.End()
+	c.Request = c.Request.WithContext(ctx)
+
+	c.Next()
+
+	status := c.Writer.Status()
+	span.SetAttributes(attribute.Int("http.response.status_code", status))
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+}
+
+// Close 停止后台任务（文件回收、集群消息订阅）
+func (s *Server) Close() {
+	s.taskMgr.Close()
+	s.agentMgr.Close()
+	s.notifier.Close()
+}
+
 // Run 启动服务器（HTTP）
 func (s *Server) Run(addr string) error {
 	log.Printf("Cloud server starting on %s", addr)
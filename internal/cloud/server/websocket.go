@@ -47,6 +47,8 @@ func (s *Server) handleWebSocket(c *gin.Context) {
 			s.handleMessage(wsConn, msg)
 		}
 		wsConn.Close()
+		// 如果是 Agent 连接，从本副本和集群注册表中注销
+		s.agentMgr.UnregisterConnection(wsConn)
 	}()
 }
 
@@ -63,6 +65,14 @@ func (s *Server) handleMessage(wsConn *common.WSConnection, msg *common.Message)
 		s.handleTaskComplete(wsConn, msg)
 	case common.MessageTypeTaskSubscribeLogs:
 		s.handleTaskSubscribeLogs(wsConn, msg)
+	case common.MessageTypeAuditEvent:
+		s.handleAuditEvent(wsConn, msg)
+	case common.MessageTypeConfigApplied:
+		s.handleConfigApplied(wsConn, msg)
+	case common.MessageTypeJobStatus:
+		s.handleJobStatus(wsConn, msg)
+	case common.MessageTypeJobSync:
+		s.handleJobSync(wsConn, msg)
 	default:
 		wsConn.WriteMessage(common.NewErrorMessage(
 			common.NewError("unknown message type: "+string(msg.Type)),
@@ -97,22 +107,34 @@ func (s *Server) handleAgentRegister(wsConn *common.WSConnection, msg *common.Me
 	})
 	response.RequestID = msg.RequestID
 	wsConn.WriteMessage(response)
+
+	// 先下发密钥，远程插件配置中的密钥引用才能解析
+	if err := s.vault.PushToAgent(actualAgentID); err != nil {
+		log.Printf("Failed to push secrets to agent %s: %v", actualAgentID, err)
+	}
+	// 下发适用于该 Agent 的远程配置
+	if err := s.configMgr.PushToAgent(actualAgentID); err != nil {
+		log.Printf("Failed to push configs to agent %s: %v", actualAgentID, err)
+	}
 }
 
 // handleAgentHeartbeat 处理 Agent 心跳
 func (s *Server) handleAgentHeartbeat(wsConn *common.WSConnection, msg *common.Message) {
 	dataBytes, _ := json.Marshal(msg.Data)
-	var heartbeatData map[string]interface{}
+	var heartbeatData common.AgentHeartbeatData
 	if err := json.Unmarshal(dataBytes, &heartbeatData); err != nil {
 		return
 	}
 
-	agentID, ok := heartbeatData["agent_id"].(string)
+	// Agent 心跳中的 agent_id 是客户端自己生成的 ID，可能与注册时分配的 ID 不同，优先按连接查找
+	agentID, ok := s.agentMgr.AgentIDForConnection(wsConn)
 	if !ok {
-		return
+		if agentID = heartbeatData.AgentID; agentID == "" {
+			return
+		}
 	}
 
-	s.agentMgr.UpdateHeartbeat(agentID)
+	s.agentMgr.UpdateHeartbeat(agentID, heartbeatData.Telemetry)
 }
 
 // handleTaskLog 处理任务日志
@@ -136,7 +158,80 @@ func (s *Server) handleTaskComplete(wsConn *common.WSConnection, msg *common.Mes
 	}
 
 	// 更新任务状态
-	s.taskMgr.CompleteTask(&completeData)
+	if err := s.taskMgr.CompleteTask(&completeData); err != nil {
+		log.Printf("Failed to complete task %s: %v", completeData.TaskID, err)
+		return
+	}
+	// 后台任务的结果保存后通知 Agent 删除任务目录，未确认的结果在 Agent 重新连接时随 job.sync 再次上报
+	if completeData.Detached {
+		wsConn.WriteMessage(common.NewMessage(common.MessageTypeJobAck, common.JobAckData{TaskID: completeData.TaskID}))
+	}
+}
+
+// handleAuditEvent 处理 Agent 上报的审计事件，Agent ID 以连接注册时的为准
+func (s *Server) handleAuditEvent(wsConn *common.WSConnection, msg *common.Message) {
+	agentID, ok := s.agentMgr.AgentIDForConnection(wsConn)
+	if !ok {
+		return
+	}
+
+	dataBytes, _ := json.Marshal(msg.Data)
+	var eventData common.AuditEventData
+	if err := json.Unmarshal(dataBytes, &eventData); err != nil || eventData.Action == "" {
+		return
+	}
+
+	s.auditor.AgentEvent(agentID, &eventData)
+}
+
+// handleConfigApplied 处理 Agent 上报的配置应用结果，Agent ID 以连接注册时的为准
+func (s *Server) handleConfigApplied(wsConn *common.WSConnection, msg *common.Message) {
+	agentID, ok := s.agentMgr.AgentIDForConnection(wsConn)
+	if !ok {
+		return
+	}
+
+	dataBytes, _ := json.Marshal(msg.Data)
+	var appliedData common.AgentConfigAppliedData
+	if err := json.Unmarshal(dataBytes, &appliedData); err != nil {
+		return
+	}
+
+	if err := s.configMgr.RecordApplied(agentID, &appliedData); err != nil {
+		log.Printf("Failed to record config state of agent %s: %v", agentID, err)
+	}
+}
+
+// handleJobStatus 处理 Agent 上报的后台任务状态，Agent ID 以连接注册时的为准
+func (s *Server) handleJobStatus(wsConn *common.WSConnection, msg *common.Message) {
+	agentID, ok := s.agentMgr.AgentIDForConnection(wsConn)
+	if !ok {
+		return
+	}
+
+	dataBytes, _ := json.Marshal(msg.Data)
+	var job common.JobStatus
+	if err := json.Unmarshal(dataBytes, &job); err != nil || job.TaskID == "" {
+		return
+	}
+
+	s.taskMgr.UpdateJobStatus(agentID, &job)
+}
+
+// handleJobSync 处理 Agent 连接后上报的后台任务，Agent ID 以连接注册时的为准
+func (s *Server) handleJobSync(wsConn *common.WSConnection, msg *common.Message) {
+	agentID, ok := s.agentMgr.AgentIDForConnection(wsConn)
+	if !ok {
+		return
+	}
+
+	dataBytes, _ := json.Marshal(msg.Data)
+	var syncData common.JobSyncData
+	if err := json.Unmarshal(dataBytes, &syncData); err != nil {
+		return
+	}
+
+	s.taskMgr.SyncJobs(agentID, &syncData)
 }
 
 // handleTaskSubscribeLogs 处理任务日志订阅
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/cloud-agent/internal/common"
+	"gorm.io/gorm"
+)
+
+// AgentConfigFilter Agent 远程配置的查询条件，空字段不过滤
+type AgentConfigFilter struct {
+	Kind      string
+	ScopeType string
+	Scope     string
+	Limit     int
+}
+
+// CreateAgentConfig 保存新版本的 Agent 远程配置，版本号为同一类型和作用范围的最大版本加一
+// SQLite 在事务开始时加写锁，并发发布依次分配版本号；PostgreSQL/MySQL 上（同一副本或不同副本）
+// 并发发布同一作用范围时唯一索引冲突，后提交的一方返回错误，重新发布即可
+func (d *Database) CreateAgentConfig(cfg *common.AgentConfig) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		if err := d.lockSQLite(tx); err != nil {
+			return err
+		}
+		var latest int
+		err := tx.Model(&common.AgentConfig{}).
+			Where("kind = ? AND scope_type = ? AND scope = ?", cfg.Kind, cfg.ScopeType, cfg.Scope).
+			Select("COALESCE(MAX(version), 0)").
+			Scan(&latest).Error
+		if err != nil {
+			return err
+		}
+		cfg.Version = latest + 1
+		if cfg.CreatedAt.IsZero() {
+			cfg.CreatedAt = time.Now()
+		}
+		return tx.Create(cfg).Error
+	})
+}
+
+// GetAgentConfig 获取 Agent 远程配置
+func (d *Database) GetAgentConfig(id uint) (*common.AgentConfig, error) {
+	var cfg common.AgentConfig
+	if err := d.db.First(&cfg, id).Error; err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// ListAgentConfigs 按发布时间倒序列出 Agent 远程配置
+func (d *Database) ListAgentConfigs(filter *AgentConfigFilter) ([]*common.AgentConfig, error) {
+	query := d.db.Model(&common.AgentConfig{})
+	if filter.Kind != "" {
+		query = query.Where("kind = ?", filter.Kind)
+	}
+	if filter.ScopeType != "" {
+		query = query.Where("scope_type = ?", filter.ScopeType)
+	}
+	if filter.Scope != "" {
+		query = query.Where("scope = ?", filter.Scope)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	var configs []*common.AgentConfig
+	err := query.Order("id DESC").Find(&configs).Error
+	return configs, err
+}
+
+// LatestAgentConfigs 返回每种配置在每个作用范围的最新版本
+func (d *Database) LatestAgentConfigs() ([]*common.AgentConfig, error) {
+	latest := d.db.Model(&common.AgentConfig{}).
+		Select("kind, scope_type, scope, MAX(version) AS version").
+		Group("kind, scope_type, scope")
+	var configs []*common.AgentConfig
+	err := d.db.Model(&common.AgentConfig{}).
+		Joins("JOIN (?) AS latest ON latest.kind = agent_configs.kind AND latest.scope_type = agent_configs.scope_type "+
+			"AND latest.scope = agent_configs.scope AND latest.version = agent_configs.version", latest).
+		Order("agent_configs.id DESC").
+		Find(&configs).Error
+	return configs, err
+}
+
+// SaveAgentConfigState 保存 Agent 上报的远程配置应用结果
+func (d *Database) SaveAgentConfigState(state *common.AgentConfigState) error {
+	state.UpdatedAt = time.Now()
+	return d.db.Save(state).Error
+}
+
+// ListAgentConfigStates 列出 Agent 各类配置的应用结果
+func (d *Database) ListAgentConfigStates(agentID string) ([]*common.AgentConfigState, error) {
+	var states []*common.AgentConfigState
+	err := d.db.Where("agent_id = ?", agentID).Order("kind").Find(&states).Error
+	return states, err
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"slices"
+	"time"
+
+	"github.com/cloud-agent/internal/common"
+	"gorm.io/gorm"
+)
+
+// MarkAgentOffline 将在线的 Agent 标记为离线，返回是否由本次调用完成状态变更
+// seenBefore 不为零时只标记最后活跃时间早于该时间的 Agent（心跳超时）；
+// 多副本同时检查同一 Agent 时只有一个副本返回 true
+func (d *Database) MarkAgentOffline(agentID string, seenBefore time.Time) (bool, error) {
+	query := d.db.Model(&common.Agent{}).Where("id = ? AND status <> ?", agentID, common.AgentStatusOffline)
+	if !seenBefore.IsZero() {
+		query = query.Where("(last_seen IS NULL OR last_seen < ?)", seenBefore.Local())
+	}
+	result := query.Updates(map[string]interface{}{
+		"status":     common.AgentStatusOffline,
+		"updated_at": time.Now(),
+	})
+	return result.RowsAffected == 1, result.Error
+}
+
+// ListStaleOnlineAgents 列出状态为在线但最后活跃时间早于 seenBefore 的 Agent
+func (d *Database) ListStaleOnlineAgents(seenBefore time.Time) ([]*common.Agent, error) {
+	var agents []*common.Agent
+	err := d.db.Where("status = ? AND (last_seen IS NULL OR last_seen < ?)", common.AgentStatusOnline, seenBefore.Local()).
+		Find(&agents).Error
+	return agents, err
+}
+
+// CreateAgentConnectionEvent 记录 Agent 连接事件
+func (d *Database) CreateAgentConnectionEvent(event *common.AgentConnectionEvent) error {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	return d.db.Create(event).Error
+}
+
+// ListAgentConnectionEvents 按时间倒序列出 Agent 的连接事件
+func (d *Database) ListAgentConnectionEvents(agentID string, limit int) ([]*common.AgentConnectionEvent, error) {
+	var events []*common.AgentConnectionEvent
+	err := d.db.Where("agent_id = ?", agentID).Order("timestamp DESC, id DESC").Limit(limit).Find(&events).Error
+	return events, err
+}
+
+// CountAgentDisconnects 统计 Agent 在 since 之后的断开次数（包括心跳超时）
+func (d *Database) CountAgentDisconnects(agentID string, since time.Time) (int64, error) {
+	var count int64
+	err := d.db.Model(&common.AgentConnectionEvent{}).
+		Where("agent_id = ? AND event IN ? AND timestamp >= ?", agentID,
+			[]string{common.AgentConnEventDisconnected, common.AgentConnEventHeartbeatTimeout}, since.Local()).
+		Count(&count).Error
+	return count, err
+}
+
+// PurgeAgentConnectionEvents 删除 before 之前的连接事件，返回删除的条数
+func (d *Database) PurgeAgentConnectionEvents(before time.Time) (int64, error) {
+	result := d.db.Where("timestamp < ?", before.Local()).Delete(&common.AgentConnectionEvent{})
+	return result.RowsAffected, result.Error
+}
+
+// UpdateAgentHeartbeat 记录 Agent 心跳：标记为在线并更新最后活跃时间
+// telemetry 不为 nil 时同时保存资源快照并追加一条时间序列样本
+func (d *Database) UpdateAgentHeartbeat(agentID string, telemetry *common.AgentTelemetry) error {
+	if telemetry == nil {
+		return d.UpdateAgentStatus(agentID, common.AgentStatusOnline)
+	}
+	now := time.Now()
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&common.Agent{ID: agentID}).Select("status", "last_seen", "telemetry", "updated_at").
+			Updates(&common.Agent{
+				Status:    common.AgentStatusOnline,
+				LastSeen:  &now,
+				Telemetry: telemetry,
+				UpdatedAt: now,
+			}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&common.AgentTelemetrySample{
+			AgentID:      agentID,
+			Timestamp:    now,
+			CPUPercent:   telemetry.CPUPercent,
+			MemPercent:   telemetry.MemPercent,
+			DiskPercent:  telemetry.DiskPercent,
+			Load1:        telemetry.Load1,
+			RunningTasks: telemetry.RunningTasks,
+		}).Error
+	})
+}
+
+// ListAgentTelemetrySamples 按时间正序列出 Agent 在 since 之后的资源样本（最多 limit 条，取最新的）
+func (d *Database) ListAgentTelemetrySamples(agentID string, since time.Time, limit int) ([]*common.AgentTelemetrySample, error) {
+	var samples []*common.AgentTelemetrySample
+	err := d.db.Where("agent_id = ? AND timestamp >= ?", agentID, since.Local()).
+		Order("timestamp DESC, id DESC").Limit(limit).Find(&samples).Error
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(samples)
+	return samples, nil
+}
+
+// PurgeAgentTelemetrySamples 删除 before 之前的资源样本，返回删除的条数
+func (d *Database) PurgeAgentTelemetrySamples(before time.Time) (int64, error) {
+	result := d.db.Where("timestamp < ?", before.Local()).Delete(&common.AgentTelemetrySample{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,248 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/cloud-agent/internal/common"
+	"gorm.io/gorm"
+)
+
+// 审计事件分页大小
+const (
+	DefaultAuditPageSize = 100
+	MaxAuditPageSize     = 1000
+)
+
+// auditAppendRetries 多副本并发写入序号冲突时的重试次数
+const auditAppendRetries = 5
+
+// AuditFilter 审计事件过滤条件
+type AuditFilter struct {
+	Actions []string   // 事件类型，多个为或关系
+	Actor   string     // 操作人
+	AgentID string     // Agent ID
+	TaskID  string     // 任务 ID
+	Outcome string     // 结果
+	Since   *time.Time // 时间不早于
+	Until   *time.Time // 时间早于
+	AfterID uint64     // 游标：只返回 ID 大于该值的事件
+	Limit   int
+}
+
+// PageSize 返回有效的分页大小
+func (f *AuditFilter) PageSize() int {
+	if f.Limit <= 0 {
+		return DefaultAuditPageSize
+	}
+	if f.Limit > MaxAuditPageSize {
+		return MaxAuditPageSize
+	}
+	return f.Limit
+}
+
+// AuditVerifyResult 哈希链校验结果
+type AuditVerifyResult struct {
+	Valid    bool   `json:"valid"`
+	Checked  int64  `json:"checked"`             // 已校验的事件数
+	LastID   uint64 `json:"last_id"`             // 最后一条通过校验的事件 ID
+	BrokenID uint64 `json:"broken_id,omitempty"` // 第一条校验失败的事件 ID
+	Reason   string `json:"reason,omitempty"`
+}
+
+// auditHashInput 参与哈希计算的字段，字段顺序固定，不要修改
+type auditHashInput struct {
+	ID         uint64 `json:"id"`
+	PrevHash   string `json:"prev_hash"`
+	Timestamp  int64  `json:"timestamp"` // Unix 毫秒
+	Action     string `json:"action"`
+	Actor      string `json:"actor"`
+	Source     string `json:"source"`
+	AgentID    string `json:"agent_id"`
+	TaskID     string `json:"task_id"`
+	TaskType   string `json:"task_type"`
+	Outcome    string `json:"outcome"`
+	Command    string `json:"command"`
+	Reason     string `json:"reason"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// AuditEventHash 计算审计事件的哈希（SHA-256，十六进制）
+func AuditEventHash(e *common.AuditEvent) string {
+	data, _ := json.Marshal(auditHashInput{
+		ID:         e.ID,
+		PrevHash:   e.PrevHash,
+		Timestamp:  e.Timestamp.UnixMilli(),
+		Action:     e.Action,
+		Actor:      e.Actor,
+		Source:     e.Source,
+		AgentID:    e.AgentID,
+		TaskID:     e.TaskID,
+		TaskType:   string(e.TaskType),
+		Outcome:    e.Outcome,
+		Command:    e.Command,
+		Reason:     e.Reason,
+		DurationMs: e.DurationMs,
+	})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// AppendAuditEvent 追加审计事件，设置 ID、PrevHash 和 Hash
+// 序号为主键，多副本同时写入时只有一个成功，其余重新读取链尾后重试
+func (d *Database) AppendAuditEvent(e *common.AuditEvent) error {
+	d.auditMu.Lock()
+	defer d.auditMu.Unlock()
+
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now()
+	}
+	// 数据库时间精度不同（MySQL 为毫秒），统一截断到毫秒保证哈希可以重新计算
+	e.Timestamp = e.Timestamp.Truncate(time.Millisecond)
+
+	var err error
+	for attempt := 0; attempt < auditAppendRetries; attempt++ {
+		err = d.db.Transaction(func(tx *gorm.DB) error {
+			var last common.AuditEvent
+			if err := tx.Order("id DESC").Limit(1).Find(&last).Error; err != nil {
+				return err
+			}
+			e.ID = last.ID + 1
+			e.PrevHash = last.Hash
+			e.Hash = AuditEventHash(e)
+			return tx.Create(e).Error
+		})
+		if err == nil {
+			return nil
+		}
+		// 序号已被其他副本占用时重试，其他错误直接返回
+		var count int64
+		if d.db.Model(&common.AuditEvent{}).Where("id = ?", e.ID).Count(&count).Error != nil || count == 0 {
+			return err
+		}
+	}
+	return fmt.Errorf("failed to append audit event after %d attempts: %w", auditAppendRetries, err)
+}
+
+// ListAuditEvents 按 ID 升序查询审计事件
+func (d *Database) ListAuditEvents(f *AuditFilter) ([]*common.AuditEvent, error) {
+	query := d.db.Model(&common.AuditEvent{})
+	if len(f.Actions) > 0 {
+		query = query.Where("action IN ?", f.Actions)
+	}
+	if f.Actor != "" {
+		query = query.Where("actor = ?", f.Actor)
+	}
+	if f.AgentID != "" {
+		query = query.Where("agent_id = ?", f.AgentID)
+	}
+	if f.TaskID != "" {
+		query = query.Where("task_id = ?", f.TaskID)
+	}
+	if f.Outcome != "" {
+		query = query.Where("outcome = ?", f.Outcome)
+	}
+	if f.Since != nil {
+		query = query.Where("timestamp >= ?", f.Since.Local())
+	}
+	if f.Until != nil {
+		query = query.Where("timestamp < ?", f.Until.Local())
+	}
+	if f.AfterID > 0 {
+		query = query.Where("id > ?", f.AfterID)
+	}
+
+	var events []*common.AuditEvent
+	err := query.Order("id ASC").Limit(f.PageSize()).Find(&events).Error
+	return events, err
+}
+
+// VerifyAuditChain 从头校验审计事件的哈希链
+// 检查序号连续、PrevHash 与上一条事件一致、Hash 与事件内容一致
+func (d *Database) VerifyAuditChain() (*AuditVerifyResult, error) {
+	result := &AuditVerifyResult{Valid: true}
+	prevHash := ""
+	for {
+		var batch []*common.AuditEvent
+		if err := d.db.Where("id > ?", result.LastID).Order("id ASC").Limit(MaxAuditPageSize).Find(&batch).Error; err != nil {
+			return nil, err
+		}
+		for _, e := range batch {
+			switch {
+			case e.ID != result.LastID+1:
+				result.Reason = fmt.Sprintf("sequence gap: expected id %d", result.LastID+1)
+			case e.PrevHash != prevHash:
+				result.Reason = "prev_hash does not match previous event"
+			case e.Hash != AuditEventHash(e):
+				result.Reason = "hash does not match event content"
+			}
+			if result.Reason != "" {
+				result.Valid = false
+				result.BrokenID = e.ID
+				return result, nil
+			}
+			result.Checked++
+			result.LastID = e.ID
+			prevHash = e.Hash
+		}
+		if len(batch) < MaxAuditPageSize {
+			return result, nil
+		}
+	}
+}
+
+// ensureAuditAppendOnly 通过触发器禁止修改和删除审计事件
+// 触发器需要相应权限，创建失败时仍可通过哈希链发现篡改
+func ensureAuditAppendOnly(tx *gorm.DB) error {
+	var stmts []string
+	switch tx.Dialector.Name() {
+	case DialectSQLite:
+		stmts = []string{
+			`CREATE TRIGGER IF NOT EXISTS audit_events_no_update BEFORE UPDATE ON audit_events BEGIN
+				SELECT RAISE(ABORT, 'audit_events is append-only');
+			END`,
+			`CREATE TRIGGER IF NOT EXISTS audit_events_no_delete BEFORE DELETE ON audit_events BEGIN
+				SELECT RAISE(ABORT, 'audit_events is append-only');
+			END`,
+		}
+	case DialectPostgres:
+		stmts = []string{
+			`CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+			BEGIN
+				RAISE EXCEPTION 'audit_events is append-only';
+			END;
+			$$ LANGUAGE plpgsql`,
+			`DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events`,
+			`CREATE TRIGGER audit_events_append_only BEFORE UPDATE OR DELETE ON audit_events
+				FOR EACH ROW EXECUTE PROCEDURE audit_events_append_only()`,
+			`DROP TRIGGER IF EXISTS audit_events_no_truncate ON audit_events`,
+			`CREATE TRIGGER audit_events_no_truncate BEFORE TRUNCATE ON audit_events
+				FOR EACH STATEMENT EXECUTE PROCEDURE audit_events_append_only()`,
+		}
+	case DialectMySQL:
+		stmts = []string{
+			`DROP TRIGGER IF EXISTS audit_events_no_update`,
+			`CREATE TRIGGER audit_events_no_update BEFORE UPDATE ON audit_events FOR EACH ROW
+				SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'audit_events is append-only'`,
+			`DROP TRIGGER IF EXISTS audit_events_no_delete`,
+			`CREATE TRIGGER audit_events_no_delete BEFORE DELETE ON audit_events FOR EACH ROW
+				SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'audit_events is append-only'`,
+		}
+	default:
+		return nil
+	}
+
+	tx.SavePoint("audit_append_only")
+	for _, stmt := range stmts {
+		if err := tx.Exec(stmt).Error; err != nil {
+			log.Printf("Warning: failed to create append-only triggers for audit_events, relying on hash chain only: %v", err)
+			tx.RollbackTo("audit_append_only")
+			return nil
+		}
+	}
+	return nil
+}
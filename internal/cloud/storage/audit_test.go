@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/cloud-agent/internal/common"
+)
+
+func appendAuditEvents(t *testing.T, db *Database) {
+	t.Helper()
+	events := []*common.AuditEvent{
+		{Action: common.AuditActionTaskSubmitted, Actor: "alice", AgentID: "a1", TaskID: "t1", Command: "ls"},
+		{Action: common.AuditActionValidationRejected, Actor: "alice", AgentID: "a1", TaskID: "t1", Outcome: common.AuditOutcomeDenied, Reason: "blocked"},
+		{Action: common.AuditActionTaskSubmitted, Actor: "bob", AgentID: "a2", TaskID: "t2", Command: "df -h"},
+	}
+	for _, e := range events {
+		if err := db.AppendAuditEvent(e); err != nil {
+			t.Fatalf("AppendAuditEvent failed: %v", err)
+		}
+	}
+}
+
+func TestAppendAuditEventChain(t *testing.T) {
+	db := newTestDatabase(t)
+	appendAuditEvents(t, db)
+
+	events, err := db.ListAuditEvents(&AuditFilter{})
+	if err != nil {
+		t.Fatalf("ListAuditEvents failed: %v", err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(events))
+	}
+	prev := ""
+	for i, e := range events {
+		if e.ID != uint64(i+1) || e.PrevHash != prev || e.Hash != AuditEventHash(e) {
+			t.Fatalf("event %d not chained: id=%d prev=%q hash=%q", i, e.ID, e.PrevHash, e.Hash)
+		}
+		prev = e.Hash
+	}
+
+	result, err := db.VerifyAuditChain()
+	if err != nil {
+		t.Fatalf("VerifyAuditChain failed: %v", err)
+	}
+	if !result.Valid || result.Checked != 3 || result.LastID != 3 {
+		t.Fatalf("unexpected verify result: %+v", result)
+	}
+
+	filtered, _ := db.ListAuditEvents(&AuditFilter{Actor: "alice", Actions: []string{common.AuditActionValidationRejected}})
+	if len(filtered) != 1 || filtered[0].ID != 2 {
+		t.Fatalf("unexpected filtered events: %+v", filtered)
+	}
+	page, _ := db.ListAuditEvents(&AuditFilter{AfterID: 1, Limit: 1})
+	if len(page) != 1 || page[0].ID != 2 {
+		t.Fatalf("unexpected page: %+v", page)
+	}
+}
+
+func TestAuditEventsAppendOnly(t *testing.T) {
+	db := newTestDatabase(t)
+	appendAuditEvents(t, db)
+
+	if err := db.GetDB().Model(&common.AuditEvent{}).Where("id = ?", 2).Update("outcome", common.AuditOutcomeAllowed).Error; err == nil {
+		t.Fatal("expected update to be rejected")
+	}
+	if err := db.GetDB().Where("id = ?", 3).Delete(&common.AuditEvent{}).Error; err == nil {
+		t.Fatal("expected delete to be rejected")
+	}
+}
+
+func TestVerifyAuditChainDetectsTampering(t *testing.T) {
+	db := newTestDatabase(t)
+	appendAuditEvents(t, db)
+
+	// 模拟绕过触发器直接修改数据
+	db.GetDB().Exec("DROP TRIGGER audit_events_no_update")
+	db.GetDB().Model(&common.AuditEvent{}).Where("id = ?", 2).Update("outcome", common.AuditOutcomeAllowed)
+
+	result, err := db.VerifyAuditChain()
+	if err != nil {
+		t.Fatalf("VerifyAuditChain failed: %v", err)
+	}
+	if result.Valid || result.BrokenID != 2 || result.LastID != 1 {
+		t.Fatalf("expected tampering at event 2, got %+v", result)
+	}
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+// ClusterMessage 副本间消息（共享数据库消息总线）
+type ClusterMessage struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	Topic     string    `json:"topic" gorm:"index;not null"`
+	Payload   string    `json:"payload" gorm:"type:text"`
+	CreatedAt time.Time `json:"created_at" gorm:"index"`
+}
+
+// AgentConnection Agent 连接注册表：记录 Agent WebSocket 当前连接在哪个 Cloud 副本
+type AgentConnection struct {
+	AgentID     string    `json:"agent_id" gorm:"primaryKey"`
+	ReplicaID   string    `json:"replica_id" gorm:"index;not null"`
+	ConnectedAt time.Time `json:"connected_at"`
+	LastSeen    time.Time `json:"last_seen" gorm:"index"`
+}
+
+// 集群相关操作
+
+// CreateClusterMessage 写入副本间消息
+func (d *Database) CreateClusterMessage(msg *ClusterMessage) error {
+	msg.CreatedAt = time.Now()
+	return d.db.Create(msg).Error
+}
+
+// ListClusterMessages 按 ID 升序列出指定主题中 ID 大于 afterID 的消息
+func (d *Database) ListClusterMessages(afterID uint, topics []string, limit int) ([]*ClusterMessage, error) {
+	var msgs []*ClusterMessage
+	err := d.db.Where("id > ? AND topic IN ?", afterID, topics).
+		Order("id ASC").
+		Limit(limit).
+		Find(&msgs).Error
+	return msgs, err
+}
+
+// ListClusterMessagesByID 按 ID 升序列出指定主题中 ID 在 ids 中的消息（补读迟到的消息）
+func (d *Database) ListClusterMessagesByID(ids []uint, topics []string) ([]*ClusterMessage, error) {
+	var msgs []*ClusterMessage
+	err := d.db.Where("id IN ? AND topic IN ?", ids, topics).
+		Order("id ASC").
+		Find(&msgs).Error
+	return msgs, err
+}
+
+// GetMaxClusterMessageID 获取当前最大的消息 ID（副本启动时从该位置开始消费）
+func (d *Database) GetMaxClusterMessageID() (uint, error) {
+	var maxID uint
+	err := d.db.Model(&ClusterMessage{}).Select("COALESCE(MAX(id), 0)").Scan(&maxID).Error
+	return maxID, err
+}
+
+// DeleteClusterMessagesBefore 删除指定时间之前的消息
+func (d *Database) DeleteClusterMessagesBefore(before time.Time) error {
+	return d.db.Where("created_at < ?", before).Delete(&ClusterMessage{}).Error
+}
+
+// UpsertAgentConnection 注册或更新 Agent 所在副本
+func (d *Database) UpsertAgentConnection(conn *AgentConnection) error {
+	return d.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "agent_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"replica_id", "connected_at", "last_seen"}),
+	}).Create(conn).Error
+}
+
+// TouchAgentConnection 刷新 Agent 连接的最后活跃时间
+func (d *Database) TouchAgentConnection(agentID, replicaID string) error {
+	return d.db.Model(&AgentConnection{}).
+		Where("agent_id = ? AND replica_id = ?", agentID, replicaID).
+		Update("last_seen", time.Now()).Error
+}
+
+// DeleteAgentConnection 删除 Agent 连接记录（仅删除属于指定副本的记录）
+func (d *Database) DeleteAgentConnection(agentID, replicaID string) error {
+	return d.db.Where("agent_id = ? AND replica_id = ?", agentID, replicaID).
+		Delete(&AgentConnection{}).Error
+}
+
+// GetAgentConnection 获取 Agent 连接记录
+func (d *Database) GetAgentConnection(agentID string) (*AgentConnection, error) {
+	var conn AgentConnection
+	err := d.db.Where("agent_id = ?", agentID).First(&conn).Error
+	if err != nil {
+		return nil, err
+	}
+	return &conn, nil
+}
+
+// ListAgentConnections 列出最后活跃时间在 since 之后的 Agent 连接
+func (d *Database) ListAgentConnections(since time.Time) ([]*AgentConnection, error) {
+	var conns []*AgentConnection
+	err := d.db.Where("last_seen > ?", since).Find(&conns).Error
+	return conns, err
+}
@@ -2,47 +2,143 @@ package storage
 
 import (
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/cloud-agent/internal/common"
 	"github.com/glebarez/sqlite"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
 
+// 支持的数据库类型
+const (
+	DialectSQLite   = "sqlite"
+	DialectPostgres = "postgres"
+	DialectMySQL    = "mysql"
+)
+
+// Config 数据库配置
+type Config struct {
+	DSN             string        // 数据库连接串，根据前缀选择后端（sqlite://、postgres://、mysql://，无前缀视为 SQLite 文件路径）
+	MaxOpenConns    int           // 最大打开连接数，0 表示不限制
+	MaxIdleConns    int           // 最大空闲连接数
+	ConnMaxLifetime time.Duration // 连接最大存活时间
+	ConnMaxIdleTime time.Duration // 连接最大空闲时间
+	LogLevel        string        // SQL 日志级别：silent、error、warn、info
+}
+
 // Database 数据库封装
 type Database struct {
-	db *gorm.DB
+	db      *gorm.DB
+	dialect string
+	auditMu sync.Mutex // 串行化本副本的审计事件写入
 }
 
-// NewDatabase 创建数据库连接
-func NewDatabase(dbPath string) (*Database, error) {
-	db, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Info),
+// NewDatabase 创建数据库连接（使用默认连接池配置）
+func NewDatabase(dsn string) (*Database, error) {
+	return NewDatabaseWithConfig(&Config{DSN: dsn})
+}
+
+// NewDatabaseWithConfig 根据配置创建数据库连接
+func NewDatabaseWithConfig(cfg *Config) (*Database, error) {
+	dialect, dialector, err := openDialector(cfg.DSN)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := gorm.Open(dialector, &gorm.Config{
+		Logger: logger.Default.LogMode(parseLogLevel(cfg.LogLevel)),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect database: %w", err)
 	}
 
-	database := &Database{db: db}
+	// 配置连接池
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sql.DB: %w", err)
+	}
+	if cfg.MaxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+	if cfg.MaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	if cfg.ConnMaxLifetime > 0 {
+		sqlDB.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	}
+	if cfg.ConnMaxIdleTime > 0 {
+		sqlDB.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+	}
 
-	// 自动迁移
+	database := &Database{db: db, dialect: dialect}
+
+	// 执行版本化迁移
 	if err := database.migrate(); err != nil {
+		sqlDB.Close()
 		return nil, fmt.Errorf("failed to migrate database: %w", err)
 	}
 
 	return database, nil
 }
 
-// migrate 数据库迁移
-func (d *Database) migrate() error {
-	return d.db.AutoMigrate(
-		&common.Agent{},
-		&common.Task{},
-		&common.Log{},
-		&common.File{},
-		&common.TaskFile{},
-	)
+// openDialector 根据 DSN 前缀选择数据库驱动
+func openDialector(dsn string) (string, gorm.Dialector, error) {
+	switch {
+	case dsn == "":
+		return "", nil, common.NewError("database dsn is empty")
+	case strings.HasPrefix(dsn, "postgres://"), strings.HasPrefix(dsn, "postgresql://"):
+		return DialectPostgres, postgres.Open(dsn), nil
+	case strings.HasPrefix(dsn, "mysql://"):
+		mysqlDSN := strings.TrimPrefix(dsn, "mysql://")
+		// 时间字段需要 parseTime 才能扫描到 time.Time
+		if !strings.Contains(mysqlDSN, "parseTime=") {
+			if strings.Contains(mysqlDSN, "?") {
+				mysqlDSN += "&parseTime=true"
+			} else {
+				mysqlDSN += "?parseTime=true"
+			}
+		}
+		return DialectMySQL, mysql.New(mysql.Config{
+			DSN: mysqlDSN,
+			// 未指定长度的字符串字段使用 varchar，避免 longtext 无法建索引/设置默认值
+			DefaultStringSize: 191,
+		}), nil
+	default:
+		path := strings.TrimPrefix(strings.TrimPrefix(dsn, "sqlite://"), "sqlite:")
+		// 多个 goroutine 并发写入时等待锁释放，而不是直接返回 SQLITE_BUSY
+		if !strings.Contains(path, "busy_timeout") {
+			if strings.Contains(path, "?") {
+				path += "&_pragma=busy_timeout(5000)"
+			} else {
+				path += "?_pragma=busy_timeout(5000)"
+			}
+		}
+		return DialectSQLite, sqlite.Open(path), nil
+	}
+}
+
+// parseLogLevel 解析 SQL 日志级别，默认只输出警告（包含慢查询）
+func parseLogLevel(level string) logger.LogLevel {
+	switch strings.ToLower(level) {
+	case "silent":
+		return logger.Silent
+	case "error":
+		return logger.Error
+	case "info":
+		return logger.Info
+	default:
+		return logger.Warn
+	}
+}
+
+// Dialect 返回当前数据库类型（sqlite、postgres、mysql）
+func (d *Database) Dialect() string {
+	return d.dialect
 }
 
 // GetDB 获取 GORM 数据库实例
@@ -159,9 +255,10 @@ func (d *Database) GetTask(taskID string) (*common.Task, error) {
 }
 
 // UpdateTask 更新任务
+// 日志计数和归档状态由 SaveLog 和日志压缩单独维护，这里不覆盖，避免并发写入时计数丢失
 func (d *Database) UpdateTask(task *common.Task) error {
 	task.UpdatedAt = time.Now()
-	return d.db.Save(task).Error
+	return d.db.Omit("log_lines", "log_state", "log_archive").Save(task).Error
 }
 
 // ListTasks 列出任务
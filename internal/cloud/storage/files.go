@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/cloud-agent/internal/common"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// FileFilter 文件列表过滤条件
+type FileFilter struct {
+	Tag  string // 按标签过滤
+	Name string // 按文件名模糊匹配
+}
+
+// activeTaskStatuses 仍在使用文件的任务状态
+var activeTaskStatuses = []common.TaskStatus{common.TaskStatusPendingApproval, common.TaskStatusPending, common.TaskStatusRunning}
+
+// ListFilesWithFilter 按条件列出文件
+func (d *Database) ListFilesWithFilter(filter *FileFilter, limit, offset int) ([]*common.File, error) {
+	var files []*common.File
+	query := d.db
+	if filter != nil {
+		if filter.Tag != "" {
+			// 标签以 JSON 数组保存，按带引号的完整标签匹配，避免匹配到前缀相同的其他标签
+			query = query.Where("tags LIKE ? ESCAPE '!'", `%"`+escapeLike(filter.Tag)+`"%`)
+		}
+		if filter.Name != "" {
+			query = query.Where("name LIKE ? ESCAPE '!'", "%"+escapeLike(filter.Name)+"%")
+		}
+	}
+	err := query.Order("created_at DESC").Limit(limit).Offset(offset).Find(&files).Error
+	return files, err
+}
+
+// UpdateFileMetadata 更新文件标签和过期时间
+func (d *Database) UpdateFileMetadata(fileID string, tags []string, expiresAt *time.Time) error {
+	// 使用结构体更新以应用标签的 JSON 序列化，Select 保证 nil 过期时间也会被写入
+	return d.db.Model(&common.File{}).Where("id = ?", fileID).
+		Select("tags", "expires_at", "updated_at").
+		Updates(&common.File{Tags: tags, ExpiresAt: expiresAt, UpdatedAt: time.Now()}).Error
+}
+
+// DeleteFile 删除文件记录及其任务关联和分发记录（不删除存储中的对象），返回仍引用同一存储对象的文件记录数
+// 和引用该文件的活跃任务数；存在活跃任务时不删除任何记录，返回的引用数为 0
+// 删除和计数在同一事务中完成，并锁定共享该对象的文件记录，与 CreateSharedFile 互斥：
+// 返回的活跃任务数为 0 且引用数为 0 时不会再有新的文件记录引用该对象，调用方可以删除对象
+func (d *Database) DeleteFile(file *common.File) (refs, active int64, err error) {
+	err = d.db.Transaction(func(tx *gorm.DB) error {
+		if err := d.lockSQLite(tx); err != nil {
+			return err
+		}
+		// 按 ID 顺序锁定共享对象的记录，避免并发删除同一对象的不同记录时死锁
+		var ids []string
+		if err := d.fileBlobQuery(tx, file).Order("id").Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		// 在持有锁的事务中检查活跃任务，检查与删除之间不会插入新的引用
+		if err := d.activeFileTasksQuery(tx, file.ID).Count(&active).Error; err != nil {
+			return err
+		}
+		if active > 0 {
+			return nil
+		}
+		if err := tx.Where("file_id = ?", file.ID).Delete(&common.TaskFile{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("file_id = ?", file.ID).Delete(&common.FileDistribution{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("id = ?", file.ID).Delete(&common.File{}).Error; err != nil {
+			return err
+		}
+		// 重新读取：等待锁期间其他事务可能已提交共享该对象的新记录
+		ids = nil
+		if err := d.fileBlobQuery(tx, file).Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		refs = int64(len(ids))
+		return nil
+	})
+	return refs, active, err
+}
+
+// CreateSharedFile 创建与 sourceID 共享存储对象的文件记录（MD5 去重）
+// 在同一事务中锁定并确认源记录仍然存在，源记录已被删除时返回 gorm.ErrRecordNotFound，
+// 此时存储对象可能已被删除，调用方需要保存自己的对象
+func (d *Database) CreateSharedFile(file *common.File, sourceID string) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		if err := d.lockSQLite(tx); err != nil {
+			return err
+		}
+		var source common.File
+		if err := d.lockRows(tx).Where("id = ?", sourceID).First(&source).Error; err != nil {
+			return err
+		}
+		if source.Backend != file.Backend || source.StorageKey != file.StorageKey || source.Path != file.Path {
+			return gorm.ErrRecordNotFound
+		}
+		now := time.Now()
+		file.CreatedAt = now
+		file.UpdatedAt = now
+		return tx.Create(file).Error
+	})
+}
+
+// fileBlobQuery 查询引用同一存储对象的文件记录（MD5 去重后多个文件记录共享同一对象）并加行锁
+func (d *Database) fileBlobQuery(tx *gorm.DB, file *common.File) *gorm.DB {
+	query := d.lockRows(tx).Model(&common.File{})
+	if file.StorageKey != "" {
+		return query.Where("backend = ? AND storage_key = ?", file.Backend, file.StorageKey)
+	}
+	return query.Where("path = ?", file.Path)
+}
+
+// lockRows 为事务中的查询加 FOR UPDATE 行锁，SQLite 不支持行锁，由 lockSQLite 锁定整库
+func (d *Database) lockRows(tx *gorm.DB) *gorm.DB {
+	if d.dialect == DialectSQLite {
+		return tx
+	}
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"})
+}
+
+// lockSQLite 在 SQLite 事务开始时取得写锁
+// SQLite 事务默认在第一次写入时才加写锁，并发的先读后写事务会直接返回 SQLITE_BUSY 而不是等待；
+// 先执行一次不修改数据的写入，其他写事务按 busy_timeout 排队
+func (d *Database) lockSQLite(tx *gorm.DB) error {
+	if d.dialect != DialectSQLite {
+		return nil
+	}
+	return tx.Exec("UPDATE files SET id = id WHERE 1 = 0").Error
+}
+
+// ListFileStorageKeys 列出指定存储后端中被文件记录引用的所有对象 key
+func (d *Database) ListFileStorageKeys(backend string) (map[string]bool, error) {
+	var keys []string
+	err := d.db.Model(&common.File{}).
+		Where("backend = ? AND storage_key <> ''", backend).
+		Distinct().
+		Pluck("storage_key", &keys).Error
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		result[key] = true
+	}
+	return result, nil
+}
+
+// ListExpiredFiles 列出已过期的文件
+func (d *Database) ListExpiredFiles(now time.Time, limit int) ([]*common.File, error) {
+	var files []*common.File
+	err := d.db.Where("expires_at IS NOT NULL AND expires_at <= ?", now).
+		Order("expires_at ASC").
+		Limit(limit).
+		Find(&files).Error
+	return files, err
+}
+
+// CreateTaskFile 记录任务使用的文件
+func (d *Database) CreateTaskFile(taskID, fileID string) error {
+	return d.db.Create(&common.TaskFile{
+		TaskID:    taskID,
+		FileID:    fileID,
+		CreatedAt: time.Now(),
+	}).Error
+}
+
+// ListActiveFileTasks 列出仍在使用文件的任务（待审批、待执行或执行中）
+// 同时检查 task_files 关联表和 tasks.file_id（兼容关联表引入之前创建的任务）
+func (d *Database) ListActiveFileTasks(fileID string) ([]*common.Task, error) {
+	var tasks []*common.Task
+	err := d.activeFileTasksQuery(d.db, fileID).Order("created_at DESC").Find(&tasks).Error
+	return tasks, err
+}
+
+// activeFileTasksQuery 查询仍在使用文件的任务
+func (d *Database) activeFileTasksQuery(tx *gorm.DB, fileID string) *gorm.DB {
+	return tx.Model(&common.Task{}).Where("status IN ?", activeTaskStatuses).
+		Where(tx.Where("file_id = ?", fileID).
+			Or("id IN (?)", tx.Model(&common.TaskFile{}).Select("task_id").Where("file_id = ?", fileID)))
+}
+
+// 文件分发记录
+
+// CreateFileDistribution 创建文件分发记录
+func (d *Database) CreateFileDistribution(dist *common.FileDistribution) error {
+	now := time.Now()
+	dist.CreatedAt = now
+	dist.UpdatedAt = now
+	return d.db.Create(dist).Error
+}
+
+// UpdateFileDistributionStatus 根据分发任务更新分发状态
+func (d *Database) UpdateFileDistributionStatus(taskID string, status common.TaskStatus, errMsg string) error {
+	return d.db.Model(&common.FileDistribution{}).Where("task_id = ?", taskID).Updates(map[string]interface{}{
+		"status":     status,
+		"error":      errMsg,
+		"updated_at": time.Now(),
+	}).Error
+}
+
+// ListFileDistributions 列出文件的分发记录
+func (d *Database) ListFileDistributions(fileID string) ([]*common.FileDistribution, error) {
+	var dists []*common.FileDistribution
+	err := d.db.Where("file_id = ?", fileID).Order("created_at DESC").Find(&dists).Error
+	return dists, err
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/cloud-agent/internal/common"
+	"gorm.io/gorm"
+)
+
+// finishedTaskStatuses 已结束的任务状态，只有这些任务的日志会被归档或删除
+var finishedTaskStatuses = []common.TaskStatus{common.TaskStatusSuccess, common.TaskStatusFailed, common.TaskStatusCanceled}
+
+// IncrementTaskLogLines 任务日志行数加一
+func (d *Database) IncrementTaskLogLines(taskID string) error {
+	return d.db.Model(&common.Task{}).Where("id = ?", taskID).
+		UpdateColumn("log_lines", gorm.Expr("log_lines + 1")).Error
+}
+
+// ListLogRetentionCandidates 按 (updated_at, id) 升序列出在 before 之前结束、日志尚未删除的任务
+// includeArchived 为 false 时只返回日志仍在数据库中的任务；afterUpdated/afterID 为上一页最后一条记录，用于分页遍历
+func (d *Database) ListLogRetentionCandidates(before time.Time, includeArchived bool, afterUpdated time.Time, afterID string, limit int) ([]*common.Task, error) {
+	states := []string{""}
+	if includeArchived {
+		states = append(states, common.TaskLogStateArchived)
+	}
+	var tasks []*common.Task
+	err := d.db.Select("id", "agent_id", "type", "status", "log_lines", "log_state", "log_archive", "updated_at").
+		Where("status IN ? AND log_state IN ? AND updated_at < ?", finishedTaskStatuses, states, before).
+		Where("updated_at > ? OR (updated_at = ? AND id > ?)", afterUpdated, afterUpdated, afterID).
+		Order("updated_at ASC, id ASC").
+		Limit(limit).
+		Find(&tasks).Error
+	return tasks, err
+}
+
+// GetAllTaskLogs 获取任务的全部日志（归档使用）
+func (d *Database) GetAllTaskLogs(taskID string) ([]*common.Log, error) {
+	var logs []*common.Log
+	err := d.db.Where("task_id = ?", taskID).Order("timestamp ASC, id ASC").Find(&logs).Error
+	return logs, err
+}
+
+// ArchiveTaskLogs 标记任务日志已归档并删除数据库中的日志
+// 只有日志仍在数据库中的任务会被更新，返回 false 表示已被其他副本归档
+func (d *Database) ArchiveTaskLogs(taskID, archiveKey string, lines int64) (bool, error) {
+	archived := false
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&common.Task{}).
+			Where("id = ? AND log_state = ?", taskID, "").
+			UpdateColumns(map[string]interface{}{
+				"log_state":   common.TaskLogStateArchived,
+				"log_archive": archiveKey,
+				"log_lines":   lines,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		archived = true
+		return tx.Where("task_id = ?", taskID).Delete(&common.Log{}).Error
+	})
+	return archived, err
+}
+
+// PurgeTaskLogs 标记任务日志已删除并删除数据库中的日志（日志行数保留）
+func (d *Database) PurgeTaskLogs(taskID string) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&common.Task{}).Where("id = ?", taskID).
+			UpdateColumns(map[string]interface{}{
+				"log_state":   common.TaskLogStatePurged,
+				"log_archive": "",
+			}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("task_id = ?", taskID).Delete(&common.Log{}).Error
+	})
+}
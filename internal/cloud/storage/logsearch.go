@@ -0,0 +1,407 @@
+package storage
+
+import (
+	"html"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/cloud-agent/internal/common"
+	"gorm.io/gorm"
+)
+
+// 日志搜索分页和分面大小
+const (
+	DefaultLogSearchLimit = 100
+	MaxLogSearchLimit     = 1000
+	DefaultLogFacetLimit  = 20
+)
+
+// snippetRunes 搜索结果摘要的最大字符数，snippetContext 为第一个匹配之前保留的字符数
+const (
+	snippetRunes   = 200
+	snippetContext = 40
+)
+
+// 全文索引匹配的最小词长，更短的搜索词使用 LIKE 匹配
+const (
+	sqliteTrigramMinRunes = 3 // FTS5 trigram 分词
+	mysqlNgramMinRunes    = 2 // InnoDB ngram 分词的默认 ngram_token_size
+)
+
+// LogSearchQuery 日志搜索条件
+type LogSearchQuery struct {
+	Query      string     // 搜索词，空白分隔的多个词为与关系，双引号内为整体短语；不区分大小写的子串匹配
+	Levels     []string   // 日志级别，多个为或关系
+	TaskID     string     // 任务 ID
+	AgentID    string     // Agent ID
+	Env        string     // Agent 所属环境
+	Since      *time.Time // 日志时间不早于
+	Until      *time.Time // 日志时间早于
+	BeforeID   uint       // 游标：只返回 ID 小于该值的日志
+	Limit      int
+	FacetLimit int // 每个分面返回的最大条目数
+}
+
+// LogSearchHit 日志搜索结果
+type LogSearchHit struct {
+	ID        uint      `json:"id"`
+	TaskID    string    `json:"task_id"`
+	AgentID   string    `json:"agent_id"`
+	Level     string    `json:"level"`
+	Timestamp time.Time `json:"timestamp"`
+	Snippet   string    `json:"snippet"` // HTML 转义后的摘要，匹配内容用 <mark> 标记
+	Archived  bool      `json:"archived,omitempty"`
+}
+
+// LogFacet 分面统计条目
+type LogFacet struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// LogFacets 日志搜索分面
+type LogFacets struct {
+	Levels []LogFacet `json:"levels"`
+	Tasks  []LogFacet `json:"tasks"`
+	Agents []LogFacet `json:"agents"`
+}
+
+// LogSearchResult 日志搜索结果
+type LogSearchResult struct {
+	Total      int64           `json:"total"`
+	Hits       []*LogSearchHit `json:"hits"`
+	Facets     LogFacets       `json:"facets"`
+	NextCursor string          `json:"next_cursor"` // 下一页游标（传入 BeforeID），为空表示没有更多数据
+}
+
+// SearchLogs 在数据库中的任务日志中搜索（不包括已归档的日志）
+// SQLite 使用 FTS5 trigram 索引，PostgreSQL 使用 pg_trgm 索引，MySQL 使用 ngram 全文索引
+func (d *Database) SearchLogs(q *LogSearchQuery) (*LogSearchResult, error) {
+	limit := q.PageSize()
+	result := &LogSearchResult{Hits: []*LogSearchHit{}}
+	if err := d.logSearchScope(q).Count(&result.Total).Error; err != nil {
+		return nil, err
+	}
+
+	var rows []struct {
+		ID        uint
+		TaskID    string
+		AgentID   string
+		Level     string
+		Message   string
+		Timestamp time.Time
+	}
+	err := d.logSearchScope(q).
+		Select("logs.id, logs.task_id, tasks.agent_id, logs.level, logs.message, logs.timestamp").
+		Order("logs.id DESC").
+		Limit(limit).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	terms := SearchTerms(q.Query)
+	for _, row := range rows {
+		result.Hits = append(result.Hits, &LogSearchHit{
+			ID:        row.ID,
+			TaskID:    row.TaskID,
+			AgentID:   row.AgentID,
+			Level:     row.Level,
+			Timestamp: row.Timestamp,
+			Snippet:   HighlightSnippet(row.Message, terms),
+		})
+	}
+	if len(rows) == limit {
+		result.NextCursor = strconv.FormatUint(uint64(rows[len(rows)-1].ID), 10)
+	}
+
+	facets := []struct {
+		column string
+		target *[]LogFacet
+	}{
+		{"logs.level", &result.Facets.Levels},
+		{"logs.task_id", &result.Facets.Tasks},
+		{"tasks.agent_id", &result.Facets.Agents},
+	}
+	for _, facet := range facets {
+		*facet.target = []LogFacet{}
+		err := d.logSearchScope(q).
+			Select(facet.column + " AS value, COUNT(*) AS count").
+			Group(facet.column).
+			Order("count DESC").
+			Limit(q.FacetSize()).
+			Scan(facet.target).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// PageSize 返回每页条数（默认 DefaultLogSearchLimit，最大 MaxLogSearchLimit）
+func (q *LogSearchQuery) PageSize() int {
+	if q.Limit <= 0 {
+		return DefaultLogSearchLimit
+	}
+	if q.Limit > MaxLogSearchLimit {
+		return MaxLogSearchLimit
+	}
+	return q.Limit
+}
+
+// FacetSize 返回每个分面的最大条目数
+func (q *LogSearchQuery) FacetSize() int {
+	if q.FacetLimit <= 0 {
+		return DefaultLogFacetLimit
+	}
+	return q.FacetLimit
+}
+
+// ListArchivedLogTasks 列出日志已归档、且可能包含符合条件日志的任务，按结束时间倒序
+func (d *Database) ListArchivedLogTasks(q *LogSearchQuery, limit int) ([]*common.Task, error) {
+	query := d.db.Select("id", "agent_id", "log_archive", "created_at", "updated_at").
+		Where("log_state = ? AND log_archive <> ?", common.TaskLogStateArchived, "")
+	if q.TaskID != "" {
+		query = query.Where("id = ?", q.TaskID)
+	}
+	if q.AgentID != "" {
+		query = query.Where("agent_id = ?", q.AgentID)
+	}
+	if q.Env != "" {
+		query = query.Where("agent_id IN (?)", d.db.Model(&common.Agent{}).Select("id").Where("env = ?", q.Env))
+	}
+	// 任务在 Since 之前结束或在 Until 之后创建时，不会有时间范围内的日志
+	if q.Since != nil {
+		query = query.Where("updated_at >= ?", q.Since.Local())
+	}
+	if q.Until != nil {
+		query = query.Where("created_at < ?", q.Until.Local())
+	}
+	var tasks []*common.Task
+	err := query.Order("updated_at DESC").Limit(limit).Find(&tasks).Error
+	return tasks, err
+}
+
+// logSearchScope 构造日志搜索的基础查询（logs 关联 tasks）
+func (d *Database) logSearchScope(q *LogSearchQuery) *gorm.DB {
+	query := d.db.Table("logs").Joins("LEFT JOIN tasks ON tasks.id = logs.task_id")
+	if len(q.Levels) > 0 {
+		query = query.Where("logs.level IN ?", q.Levels)
+	}
+	if q.TaskID != "" {
+		query = query.Where("logs.task_id = ?", q.TaskID)
+	}
+	if q.AgentID != "" {
+		query = query.Where("tasks.agent_id = ?", q.AgentID)
+	}
+	if q.Env != "" {
+		query = query.Where("tasks.agent_id IN (?)", d.db.Model(&common.Agent{}).Select("id").Where("env = ?", q.Env))
+	}
+	if q.Since != nil {
+		query = query.Where("logs.timestamp >= ?", q.Since.Local())
+	}
+	if q.Until != nil {
+		query = query.Where("logs.timestamp < ?", q.Until.Local())
+	}
+	if q.BeforeID > 0 {
+		query = query.Where("logs.id < ?", q.BeforeID)
+	}
+	return d.applyLogMatch(query, SearchTerms(q.Query))
+}
+
+// applyLogMatch 添加搜索词匹配条件，能使用全文索引的词走索引，其余使用 LIKE
+func (d *Database) applyLogMatch(query *gorm.DB, terms []string) *gorm.DB {
+	var indexed []string
+	for _, term := range terms {
+		runes := utf8.RuneCountInString(term)
+		switch {
+		case d.dialect == DialectSQLite && runes >= sqliteTrigramMinRunes:
+			indexed = append(indexed, `"`+strings.ReplaceAll(term, `"`, `""`)+`"`)
+		case d.dialect == DialectMySQL && runes >= mysqlNgramMinRunes && !strings.ContainsAny(term, `"`):
+			indexed = append(indexed, `+"`+term+`"`)
+		case d.dialect == DialectPostgres:
+			// pg_trgm 索引可以直接加速 ILIKE
+			query = query.Where("logs.message ILIKE ? ESCAPE '!'", "%"+escapeLike(term)+"%")
+		default:
+			query = query.Where("LOWER(logs.message) LIKE ? ESCAPE '!'", "%"+escapeLike(strings.ToLower(term))+"%")
+		}
+	}
+	if len(indexed) == 0 {
+		return query
+	}
+	switch d.dialect {
+	case DialectSQLite:
+		return query.Where("logs.id IN (SELECT rowid FROM logs_fts WHERE logs_fts MATCH ?)", strings.Join(indexed, " "))
+	default:
+		return query.Where("MATCH(logs.message) AGAINST (? IN BOOLEAN MODE)", strings.Join(indexed, " "))
+	}
+}
+
+// Matches 判断日志是否符合搜索条件（用于搜索归档日志；任务、Agent 和环境条件由调用方处理）
+func (q *LogSearchQuery) Matches(entry *common.Log) bool {
+	if q.BeforeID > 0 && entry.ID >= q.BeforeID {
+		return false
+	}
+	if q.Since != nil && entry.Timestamp.Before(*q.Since) {
+		return false
+	}
+	if q.Until != nil && !entry.Timestamp.Before(*q.Until) {
+		return false
+	}
+	if len(q.Levels) > 0 {
+		found := false
+		for _, level := range q.Levels {
+			if entry.Level == level {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	message := strings.ToLower(entry.Message)
+	for _, term := range SearchTerms(q.Query) {
+		if !strings.Contains(message, strings.ToLower(term)) {
+			return false
+		}
+	}
+	return true
+}
+
+// SearchTerms 拆分搜索词：空白分隔，双引号内的内容作为整体短语
+func SearchTerms(query string) []string {
+	var terms []string
+	var current strings.Builder
+	quoted := false
+	flush := func() {
+		if current.Len() > 0 {
+			terms = append(terms, current.String())
+			current.Reset()
+		}
+	}
+	for _, r := range query {
+		switch {
+		case r == '"':
+			flush()
+			quoted = !quoted
+		case unicode.IsSpace(r) && !quoted:
+			flush()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	flush()
+	return terms
+}
+
+// HighlightSnippet 截取日志中第一个匹配附近的内容作为摘要，HTML 转义后用 <mark> 标记所有匹配
+func HighlightSnippet(message string, terms []string) string {
+	runes := []rune(message)
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+
+	// 标记每个字符是否属于匹配内容
+	marked := make([]bool, len(runes))
+	first := -1
+	for _, term := range terms {
+		t := []rune(strings.ToLower(term))
+		if len(t) == 0 {
+			continue
+		}
+		for i := 0; i+len(t) <= len(lower); i++ {
+			if string(lower[i:i+len(t)]) != string(t) {
+				continue
+			}
+			for j := i; j < i+len(t); j++ {
+				marked[j] = true
+			}
+			if first < 0 || i < first {
+				first = i
+			}
+		}
+	}
+
+	start := 0
+	if first > snippetContext {
+		start = first - snippetContext
+	}
+	end := start + snippetRunes
+	if end > len(runes) {
+		end = len(runes)
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	for i := start; i < end; {
+		j := i
+		for j < end && marked[j] == marked[i] {
+			j++
+		}
+		text := html.EscapeString(string(runes[i:j]))
+		if marked[i] {
+			b.WriteString("<mark>" + text + "</mark>")
+		} else {
+			b.WriteString(text)
+		}
+		i = j
+	}
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+// ensureLogSearchIndex 创建日志全文搜索索引
+func ensureLogSearchIndex(tx *gorm.DB) error {
+	switch tx.Dialector.Name() {
+	case DialectSQLite:
+		// 外部内容 FTS5 表，通过触发器与 logs 表同步；trigram 分词支持子串匹配和中文
+		stmts := []string{
+			`CREATE VIRTUAL TABLE IF NOT EXISTS logs_fts USING fts5(message, content='logs', content_rowid='id', tokenize='trigram')`,
+			`CREATE TRIGGER IF NOT EXISTS logs_fts_insert AFTER INSERT ON logs BEGIN
+				INSERT INTO logs_fts(rowid, message) VALUES (new.id, new.message);
+			END`,
+			`CREATE TRIGGER IF NOT EXISTS logs_fts_delete AFTER DELETE ON logs BEGIN
+				INSERT INTO logs_fts(logs_fts, rowid, message) VALUES ('delete', old.id, old.message);
+			END`,
+			`CREATE TRIGGER IF NOT EXISTS logs_fts_update AFTER UPDATE OF message ON logs BEGIN
+				INSERT INTO logs_fts(logs_fts, rowid, message) VALUES ('delete', old.id, old.message);
+				INSERT INTO logs_fts(rowid, message) VALUES (new.id, new.message);
+			END`,
+			// 为已有日志建立索引
+			`INSERT INTO logs_fts(logs_fts) VALUES ('rebuild')`,
+		}
+		for _, stmt := range stmts {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	case DialectPostgres:
+		// 创建扩展需要相应权限，失败时搜索仍可用（不使用索引）
+		tx.SavePoint("log_search_index")
+		err := tx.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm").Error
+		if err == nil {
+			err = tx.Exec("CREATE INDEX IF NOT EXISTS idx_logs_message_trgm ON logs USING gin (message gin_trgm_ops)").Error
+		}
+		if err != nil {
+			log.Printf("Warning: failed to create trigram index for log search, searching without index: %v", err)
+			tx.RollbackTo("log_search_index")
+		}
+		return nil
+	case DialectMySQL:
+		if tx.Migrator().HasIndex(&common.Log{}, "idx_logs_message_ft") {
+			return nil
+		}
+		return tx.Exec("CREATE FULLTEXT INDEX idx_logs_message_ft ON logs (message) WITH PARSER ngram").Error
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cloud-agent/internal/common"
+)
+
+func seedLogs(t *testing.T, db *Database) {
+	t.Helper()
+	db.CreateAgent(&common.Agent{ID: "a1", Name: "a1", Env: "prod"})
+	db.CreateAgent(&common.Agent{ID: "a2", Name: "a2", Env: "dev"})
+	db.CreateTask(&common.Task{ID: "t1", AgentID: "a1", Type: common.TaskTypeShell})
+	db.CreateTask(&common.Task{ID: "t2", AgentID: "a2", Type: common.TaskTypeK8s})
+
+	now := time.Now()
+	logs := []*common.Log{
+		{TaskID: "t1", Level: "info", Message: "starting job", Timestamp: now.Add(-2 * time.Hour)},
+		{TaskID: "t1", Level: "error", Message: "container app OOMKilled, exit 137", Timestamp: now.Add(-time.Hour)},
+		{TaskID: "t2", Level: "warn", Message: "pod web-1 was OOMKilled <restart>", Timestamp: now.Add(-time.Minute)},
+		{TaskID: "t2", Level: "error", Message: "连接数据库超时", Timestamp: now},
+		{TaskID: "t2", Level: "info", Message: "ok", Timestamp: now},
+	}
+	for _, entry := range logs {
+		if err := db.CreateLog(entry); err != nil {
+			t.Fatalf("CreateLog failed: %v", err)
+		}
+	}
+}
+
+func hitTasks(result *LogSearchResult) string {
+	s := ""
+	for _, hit := range result.Hits {
+		s += hit.TaskID
+	}
+	return s
+}
+
+func TestSearchLogs(t *testing.T) {
+	db := newTestDatabase(t)
+	seedLogs(t, db)
+	since := time.Now().Add(-30 * time.Minute)
+
+	tests := []struct {
+		name  string
+		query LogSearchQuery
+		want  string
+	}{
+		{"case insensitive", LogSearchQuery{Query: "oomkilled"}, "t2t1"},
+		{"multiple terms", LogSearchQuery{Query: "oomkilled 137"}, "t1"},
+		{"phrase", LogSearchQuery{Query: `"pod web"`}, "t2"},
+		{"chinese", LogSearchQuery{Query: "数据库"}, "t2"},
+		{"short term", LogSearchQuery{Query: "ok"}, "t2"},
+		{"level", LogSearchQuery{Query: "oomkilled", Levels: []string{"error"}}, "t1"},
+		{"agent", LogSearchQuery{Query: "oomkilled", AgentID: "a2"}, "t2"},
+		{"env", LogSearchQuery{Query: "oomkilled", Env: "prod"}, "t1"},
+		{"since", LogSearchQuery{Query: "oomkilled", Since: &since}, "t2"},
+		{"no query", LogSearchQuery{Levels: []string{"error"}}, "t2t1"},
+		{"no match", LogSearchQuery{Query: "segfault"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := db.SearchLogs(&tt.query)
+			if err != nil {
+				t.Fatalf("SearchLogs failed: %v", err)
+			}
+			if got := hitTasks(result); got != tt.want {
+				t.Errorf("hits = %s, want %s", got, tt.want)
+			}
+			if result.Total != int64(len(result.Hits)) {
+				t.Errorf("total = %d, hits = %d", result.Total, len(result.Hits))
+			}
+		})
+	}
+}
+
+func TestSearchLogsFacetsAndCursor(t *testing.T) {
+	db := newTestDatabase(t)
+	seedLogs(t, db)
+
+	result, err := db.SearchLogs(&LogSearchQuery{Query: "oomkilled", Limit: 1})
+	if err != nil {
+		t.Fatalf("SearchLogs failed: %v", err)
+	}
+	if result.Total != 2 || len(result.Hits) != 1 || result.NextCursor == "" {
+		t.Fatalf("first page = %+v", result)
+	}
+	if len(result.Facets.Agents) != 2 || len(result.Facets.Tasks) != 2 || len(result.Facets.Levels) != 2 {
+		t.Errorf("facets = %+v", result.Facets)
+	}
+	if want := "pod web-1 was <mark>OOMKilled</mark> &lt;restart&gt;"; result.Hits[0].Snippet != want {
+		t.Errorf("snippet = %q, want %q", result.Hits[0].Snippet, want)
+	}
+
+	next, err := db.SearchLogs(&LogSearchQuery{Query: "oomkilled", Limit: 1, BeforeID: result.Hits[0].ID})
+	if err != nil {
+		t.Fatalf("SearchLogs failed: %v", err)
+	}
+	if hitTasks(next) != "t1" {
+		t.Errorf("second page = %s", hitTasks(next))
+	}
+	if next.Hits[0].AgentID != "a1" {
+		t.Errorf("agent_id = %s, want a1", next.Hits[0].AgentID)
+	}
+
+	// 删除的日志同步从全文索引移除
+	if _, err := db.ArchiveTaskLogs("t1", "logs/t1.jsonl.gz", 2); err != nil {
+		t.Fatalf("ArchiveTaskLogs failed: %v", err)
+	}
+	result, _ = db.SearchLogs(&LogSearchQuery{Query: "oomkilled"})
+	if hitTasks(result) != "t2" {
+		t.Errorf("hits after delete = %s", hitTasks(result))
+	}
+}
+
+func TestHighlightSnippet(t *testing.T) {
+	long := ""
+	for i := 0; i < 30; i++ {
+		long += "0123456789"
+	}
+	snippet := HighlightSnippet(long+"ERROR"+long, []string{"error"})
+	if len([]rune(snippet)) > snippetRunes+len("<mark></mark>")+2 {
+		t.Errorf("snippet too long: %d", len([]rune(snippet)))
+	}
+	if snippet[:len("…")] != "…" || snippet[len(snippet)-len("…"):] != "…" {
+		t.Errorf("snippet should be elided on both sides: %q", snippet)
+	}
+
+	if got := SearchTerms(`a "b c"  d`); len(got) != 3 || got[1] != "b c" {
+		t.Errorf("SearchTerms = %q", got)
+	}
+}
@@ -0,0 +1,326 @@
+package storage
+
+import "time"
+
+// 各迁移版本冻结的表结构
+// 迁移不引用 common 中的模型：模型之后新增或修改的字段会让已发布的迁移在新库上建出不同的表。
+// 新建表的迁移保存完整结构，为已有表增加列或索引的迁移只保存主键和本次变更涉及的列；
+// 类型名带版本号，TableName 与模型一致，索引名与模型生成的一致。修改模型时追加新的迁移和结构，不要修改这里已有的结构。
+
+// 版本 1：初始表结构
+
+type agentV1 struct {
+	ID        string `gorm:"primaryKey"`
+	Name      string `gorm:"not null"`
+	Hostname  string
+	IP        string
+	Version   string
+	Env       string `gorm:"index"`
+	Protocol  string `gorm:"type:varchar(10);default:'ws'"`
+	Status    string `gorm:"default:'offline'"`
+	LastSeen  *time.Time
+	Tags      string `gorm:"type:text"`
+	Metadata  string `gorm:"type:text"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (agentV1) TableName() string { return "agents" }
+
+type taskV1 struct {
+	ID         string `gorm:"primaryKey"`
+	AgentID    string `gorm:"index;not null"`
+	Type       string `gorm:"not null"`
+	Status     string `gorm:"default:'pending'"`
+	Command    string `gorm:"type:text"`
+	Params     string `gorm:"type:text"`
+	FileID     string `gorm:"index"`
+	Result     string `gorm:"type:text"`
+	Error      string `gorm:"type:text"`
+	StartedAt  *time.Time
+	FinishedAt *time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+func (taskV1) TableName() string { return "tasks" }
+
+type logV1 struct {
+	ID        uint   `gorm:"primaryKey"`
+	TaskID    string `gorm:"index;not null"`
+	Level     string
+	Message   string `gorm:"type:text"`
+	Timestamp time.Time
+}
+
+func (logV1) TableName() string { return "logs" }
+
+type fileV1 struct {
+	ID          string `gorm:"primaryKey"`
+	Name        string `gorm:"not null"`
+	Path        string `gorm:"not null"`
+	Size        int64
+	ContentType string
+	MD5         string `gorm:"index"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+func (fileV1) TableName() string { return "files" }
+
+type taskFileV1 struct {
+	ID     uint   `gorm:"primaryKey"`
+	TaskID string `gorm:"index;not null"`
+	FileID string `gorm:"index;not null"`
+}
+
+func (taskFileV1) TableName() string { return "task_files" }
+
+// 版本 2：集群连接注册表和消息总线
+
+type agentConnectionV2 struct {
+	AgentID     string `gorm:"primaryKey"`
+	ReplicaID   string `gorm:"index;not null"`
+	ConnectedAt time.Time
+	LastSeen    time.Time `gorm:"index"`
+}
+
+func (agentConnectionV2) TableName() string { return "agent_connections" }
+
+type clusterMessageV2 struct {
+	ID        uint      `gorm:"primaryKey"`
+	Topic     string    `gorm:"index;not null"`
+	Payload   string    `gorm:"type:text"`
+	CreatedAt time.Time `gorm:"index"`
+}
+
+func (clusterMessageV2) TableName() string { return "cluster_messages" }
+
+// 版本 3：文件存储后端和 SHA-256
+
+type fileV3 struct {
+	ID         string `gorm:"primaryKey"`
+	SHA256     string `gorm:"index"`
+	Backend    string
+	StorageKey string
+}
+
+func (fileV3) TableName() string { return "files" }
+
+// 版本 4：文件标签、保留期限和分发记录
+
+type fileV4 struct {
+	ID         string     `gorm:"primaryKey"`
+	StorageKey string     `gorm:"index"`
+	Tags       string     `gorm:"type:text"`
+	ExpiresAt  *time.Time `gorm:"index"`
+}
+
+func (fileV4) TableName() string { return "files" }
+
+type taskFileV4 struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+}
+
+func (taskFileV4) TableName() string { return "task_files" }
+
+type fileDistributionV4 struct {
+	ID         uint   `gorm:"primaryKey"`
+	FileID     string `gorm:"index;not null"`
+	AgentID    string `gorm:"index;not null"`
+	TaskID     string `gorm:"index"`
+	TargetPath string
+	Status     string
+	Error      string `gorm:"type:text"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+func (fileDistributionV4) TableName() string { return "file_distributions" }
+
+// 版本 5：任务日志计数和归档状态
+
+type taskV5 struct {
+	ID         string `gorm:"primaryKey"`
+	LogLines   int64  `gorm:"default:0"`
+	LogState   string `gorm:"index;default:''"`
+	LogArchive string
+}
+
+func (taskV5) TableName() string { return "tasks" }
+
+// 版本 6：任务标签、创建者和检索索引
+
+type taskV6 struct {
+	ID        string    `gorm:"primaryKey"`
+	AgentID   string    `gorm:"index;index:idx_tasks_agent_created,priority:1;not null"`
+	Type      string    `gorm:"index:idx_tasks_type_created,priority:1;not null"`
+	Status    string    `gorm:"index:idx_tasks_status_created,priority:1;default:'pending'"`
+	Tags      string    `gorm:"type:text"`
+	CreatedBy string    `gorm:"index:idx_tasks_creator_created,priority:1"`
+	CreatedAt time.Time `gorm:"index:idx_tasks_created,priority:1;index:idx_tasks_agent_created,priority:2;index:idx_tasks_type_created,priority:2;index:idx_tasks_status_created,priority:2;index:idx_tasks_creator_created,priority:2"`
+	UpdatedAt time.Time `gorm:"index"`
+}
+
+func (taskV6) TableName() string { return "tasks" }
+
+// 版本 7：日志时间索引（全文索引见 ensureLogSearchIndex）
+
+type logV7 struct {
+	ID        uint      `gorm:"primaryKey"`
+	Timestamp time.Time `gorm:"index"`
+}
+
+func (logV7) TableName() string { return "logs" }
+
+// 版本 8：审计事件
+
+type auditEventV8 struct {
+	ID         uint64    `gorm:"primaryKey;autoIncrement:false"`
+	Timestamp  time.Time `gorm:"index"`
+	Action     string    `gorm:"type:varchar(64);index"`
+	Actor      string    `gorm:"type:varchar(255);index"`
+	Source     string    `gorm:"type:varchar(16)"`
+	AgentID    string    `gorm:"type:varchar(255);index"`
+	TaskID     string    `gorm:"type:varchar(255);index"`
+	TaskType   string    `gorm:"type:varchar(64)"`
+	Outcome    string    `gorm:"type:varchar(32);index"`
+	Command    string    `gorm:"type:text"`
+	Reason     string    `gorm:"type:text"`
+	DurationMs int64
+	PrevHash   string `gorm:"type:varchar(64)"`
+	Hash       string `gorm:"type:varchar(64)"`
+}
+
+func (auditEventV8) TableName() string { return "audit_events" }
+
+// 版本 9：通知投递记录
+
+type notificationDeliveryV9 struct {
+	ID            uint   `gorm:"primaryKey"`
+	EventID       string `gorm:"type:varchar(64);index"`
+	EventType     string `gorm:"type:varchar(64);index"`
+	Rule          string `gorm:"type:varchar(255)"`
+	Channel       string `gorm:"type:varchar(255);index"`
+	ChannelType   string `gorm:"type:varchar(32)"`
+	Status        string `gorm:"type:varchar(16);index:idx_notification_due,priority:1"`
+	Attempts      int
+	ResponseCode  int
+	LastError     string    `gorm:"type:text"`
+	Payload       string    `gorm:"type:text"`
+	NextAttemptAt time.Time `gorm:"index:idx_notification_due,priority:2"`
+	DeliveredAt   *time.Time
+	CreatedAt     time.Time `gorm:"index"`
+	UpdatedAt     time.Time
+}
+
+func (notificationDeliveryV9) TableName() string { return "notification_deliveries" }
+
+// 版本 10：Agent 连接历史
+
+type agentConnectionEventV10 struct {
+	ID        uint      `gorm:"primaryKey"`
+	AgentID   string    `gorm:"type:varchar(255);index:idx_agent_conn_events,priority:1;not null"`
+	Event     string    `gorm:"type:varchar(32)"`
+	ReplicaID string    `gorm:"type:varchar(255)"`
+	Reason    string    `gorm:"type:text"`
+	Timestamp time.Time `gorm:"index:idx_agent_conn_events,priority:2;index"`
+}
+
+func (agentConnectionEventV10) TableName() string { return "agent_connection_events" }
+
+// 版本 11：Agent 资源快照和时间序列
+
+type agentV11 struct {
+	ID        string `gorm:"primaryKey"`
+	Telemetry string `gorm:"type:text"`
+}
+
+func (agentV11) TableName() string { return "agents" }
+
+type agentTelemetrySampleV11 struct {
+	ID           uint      `gorm:"primaryKey"`
+	AgentID      string    `gorm:"type:varchar(255);index:idx_agent_telemetry,priority:1;not null"`
+	Timestamp    time.Time `gorm:"index:idx_agent_telemetry,priority:2;index"`
+	CPUPercent   float64
+	MemPercent   float64
+	DiskPercent  float64
+	Load1        float64
+	RunningTasks int
+}
+
+func (agentTelemetrySampleV11) TableName() string { return "agent_telemetry_samples" }
+
+// 版本 12：Agent 执行器能力
+
+type agentV12 struct {
+	ID           string `gorm:"primaryKey"`
+	Capabilities string `gorm:"type:text"`
+}
+
+func (agentV12) TableName() string { return "agents" }
+
+// 版本 13：Agent 远程配置
+
+type agentConfigV13 struct {
+	ID        uint   `gorm:"primaryKey"`
+	Kind      string `gorm:"type:varchar(16);uniqueIndex:idx_agent_config_version,priority:1"`
+	ScopeType string `gorm:"type:varchar(16);uniqueIndex:idx_agent_config_version,priority:2"`
+	Scope     string `gorm:"type:varchar(255);uniqueIndex:idx_agent_config_version,priority:3"`
+	Version   int    `gorm:"uniqueIndex:idx_agent_config_version,priority:4"`
+	Content   string `gorm:"type:text"`
+	Checksum  string `gorm:"type:varchar(64)"`
+	Comment   string `gorm:"type:text"`
+	CreatedBy string `gorm:"type:varchar(255)"`
+	CreatedAt time.Time
+}
+
+func (agentConfigV13) TableName() string { return "agent_configs" }
+
+type agentConfigStateV13 struct {
+	AgentID   string `gorm:"primaryKey;type:varchar(255)"`
+	Kind      string `gorm:"primaryKey;type:varchar(16)"`
+	ConfigID  uint
+	Version   int
+	Checksum  string `gorm:"type:varchar(64)"`
+	Status    string `gorm:"type:varchar(16)"`
+	Error     string `gorm:"type:text"`
+	UpdatedAt time.Time
+}
+
+func (agentConfigStateV13) TableName() string { return "agent_config_states" }
+
+// 版本 14：密钥库
+
+type secretV14 struct {
+	Name         string `gorm:"primaryKey;type:varchar(255)"`
+	Env          string `gorm:"type:varchar(255)"`
+	AllowedHosts string `gorm:"type:text"`
+	Description  string `gorm:"type:text"`
+	Ciphertext   []byte
+	UpdatedBy    string `gorm:"type:varchar(255)"`
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+func (secretV14) TableName() string { return "secrets" }
+
+// 版本 15：任务审批
+
+type taskV15 struct {
+	ID         string `gorm:"primaryKey"`
+	PolicyRule string
+	ApprovedBy string
+}
+
+func (taskV15) TableName() string { return "tasks" }
+
+// 版本 16：后台任务状态
+
+type taskV16 struct {
+	ID  string `gorm:"primaryKey"`
+	Job string `gorm:"type:text"`
+}
+
+func (taskV16) TableName() string { return "tasks" }
@@ -0,0 +1,290 @@
+package storage
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/cloud-agent/internal/common"
+	"gorm.io/gorm"
+)
+
+// Migration 数据库版本化迁移
+// 每个迁移只执行一次，执行记录保存在 schema_migrations 表中。
+// 新增表结构变更时追加新的版本号，不要修改已发布的迁移。
+// 迁移使用 migration_schemas.go 中按版本冻结的表结构，不直接使用 common 中的模型。
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(tx *gorm.DB) error
+}
+
+// SchemaMigration 已执行的迁移记录
+type SchemaMigration struct {
+	Version     int       `json:"version" gorm:"primaryKey;autoIncrement:false"`
+	Description string    `json:"description"`
+	AppliedAt   time.Time `json:"applied_at"`
+}
+
+// TableName 指定表名
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// migrationLockID 多副本同时启动时用于串行化迁移的锁 ID
+const migrationLockID = 7212026
+
+// migrations 按版本号排列的迁移列表
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "initial schema",
+		Up: func(tx *gorm.DB) error {
+			// 兼容旧版本通过 AutoMigrate 创建的数据库：表已存在时只补齐缺失的列和索引
+			return ensureTables(tx,
+				&agentV1{},
+				&taskV1{},
+				&logV1{},
+				&fileV1{},
+				&taskFileV1{},
+			)
+		},
+	},
+	{
+		Version:     2,
+		Description: "cluster registry and message bus",
+		Up: func(tx *gorm.DB) error {
+			return ensureTables(tx, &agentConnectionV2{}, &clusterMessageV2{})
+		},
+	},
+	{
+		Version:     3,
+		Description: "file store backend and sha256 checksum",
+		Up: func(tx *gorm.DB) error {
+			return ensureTables(tx, &fileV3{})
+		},
+	},
+	{
+		Version:     4,
+		Description: "file lifecycle: tags, retention and distribution tracking",
+		Up: func(tx *gorm.DB) error {
+			return ensureTables(tx, &fileV4{}, &taskFileV4{}, &fileDistributionV4{})
+		},
+	},
+	{
+		Version:     5,
+		Description: "task log counter and archival state",
+		Up: func(tx *gorm.DB) error {
+			return ensureTables(tx, &taskV5{})
+		},
+	},
+	{
+		Version:     6,
+		Description: "task tags, creator and search indexes",
+		Up: func(tx *gorm.DB) error {
+			// MySQL 旧表中 type 列为 longtext，不能直接建索引，先改为 varchar
+			if tx.Dialector.Name() == DialectMySQL && tx.Migrator().HasTable(&taskV6{}) {
+				if err := tx.Migrator().AlterColumn(&taskV6{}, "Type"); err != nil {
+					return err
+				}
+			}
+			return ensureTables(tx, &taskV6{})
+		},
+	},
+	{
+		Version:     7,
+		Description: "full-text log search index",
+		Up: func(tx *gorm.DB) error {
+			if err := ensureTables(tx, &logV7{}); err != nil {
+				return err
+			}
+			return ensureLogSearchIndex(tx)
+		},
+	},
+	{
+		Version:     8,
+		Description: "append-only audit events",
+		Up: func(tx *gorm.DB) error {
+			if err := ensureTables(tx, &auditEventV8{}); err != nil {
+				return err
+			}
+			return ensureAuditAppendOnly(tx)
+		},
+	},
+	{
+		Version:     9,
+		Description: "notification delivery log",
+		Up: func(tx *gorm.DB) error {
+			return ensureTables(tx, &notificationDeliveryV9{})
+		},
+	},
+	{
+		Version:     10,
+		Description: "agent connection history",
+		Up: func(tx *gorm.DB) error {
+			return ensureTables(tx, &agentConnectionEventV10{})
+		},
+	},
+	{
+		Version:     11,
+		Description: "agent telemetry snapshot and samples",
+		Up: func(tx *gorm.DB) error {
+			return ensureTables(tx, &agentV11{}, &agentTelemetrySampleV11{})
+		},
+	},
+	{
+		Version:     12,
+		Description: "agent capabilities",
+		Up: func(tx *gorm.DB) error {
+			return ensureTables(tx, &agentV12{})
+		},
+	},
+	{
+		Version:     13,
+		Description: "agent remote configs",
+		Up: func(tx *gorm.DB) error {
+			return ensureTables(tx, &agentConfigV13{}, &agentConfigStateV13{})
+		},
+	},
+	{
+		Version:     14,
+		Description: "secret vault",
+		Up: func(tx *gorm.DB) error {
+			return ensureTables(tx, &secretV14{})
+		},
+	},
+	{
+		Version:     15,
+		Description: "task approval",
+		Up: func(tx *gorm.DB) error {
+			return ensureTables(tx, &taskV15{})
+		},
+	},
+	{
+		Version:     16,
+		Description: "detached job status",
+		Up: func(tx *gorm.DB) error {
+			return ensureTables(tx, &taskV16{})
+		},
+	},
+	{
+		Version:     17,
+		Description: "full-text task search index",
+		Up:          ensureTaskSearchIndex,
+	},
+}
+
+// migrate 执行所有未执行的迁移
+func (d *Database) migrate() error {
+	// 使用单个连接，保证数据库锁在整个迁移过程中有效
+	return d.db.Connection(func(conn *gorm.DB) error {
+		conn = conn.Session(&gorm.Session{NewDB: true})
+		unlock, err := d.lockMigrations(conn)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		if err := ensureTables(conn, &SchemaMigration{}); err != nil {
+			return fmt.Errorf("failed to create schema_migrations table: %w", err)
+		}
+
+		var applied []SchemaMigration
+		if err := conn.Find(&applied).Error; err != nil {
+			return fmt.Errorf("failed to load applied migrations: %w", err)
+		}
+		appliedVersions := make(map[int]bool, len(applied))
+		for _, m := range applied {
+			appliedVersions[m.Version] = true
+		}
+
+		pending := make([]Migration, 0, len(migrations))
+		for _, m := range migrations {
+			if !appliedVersions[m.Version] {
+				pending = append(pending, m)
+			}
+		}
+		sort.Slice(pending, func(i, j int) bool { return pending[i].Version < pending[j].Version })
+
+		for _, m := range pending {
+			log.Printf("Applying database migration %d: %s", m.Version, m.Description)
+			err := conn.Transaction(func(tx *gorm.DB) error {
+				if err := m.Up(tx); err != nil {
+					return err
+				}
+				return tx.Create(&SchemaMigration{
+					Version:     m.Version,
+					Description: m.Description,
+					AppliedAt:   time.Now(),
+				}).Error
+			})
+			if err != nil {
+				return fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Description, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// lockMigrations 获取迁移锁，避免多个副本并发执行迁移
+// SQLite 为单机文件数据库，不需要额外加锁
+func (d *Database) lockMigrations(conn *gorm.DB) (func(), error) {
+	switch d.dialect {
+	case DialectPostgres:
+		if err := conn.Exec("SELECT pg_advisory_lock(?)", migrationLockID).Error; err != nil {
+			return nil, fmt.Errorf("failed to acquire migration lock: %w", err)
+		}
+		return func() {
+			conn.Exec("SELECT pg_advisory_unlock(?)", migrationLockID)
+		}, nil
+	case DialectMySQL:
+		lockName := fmt.Sprintf("cloud_agent_migrate_%d", migrationLockID)
+		var got int
+		if err := conn.Raw("SELECT GET_LOCK(?, 60)", lockName).Scan(&got).Error; err != nil {
+			return nil, fmt.Errorf("failed to acquire migration lock: %w", err)
+		}
+		if got != 1 {
+			return nil, common.NewError("timeout acquiring migration lock")
+		}
+		return func() {
+			conn.Exec("SELECT RELEASE_LOCK(?)", lockName)
+		}, nil
+	default:
+		return func() {}, nil
+	}
+}
+
+// ensureTables 创建不存在的表；表已存在时补齐缺失的列和索引
+func ensureTables(tx *gorm.DB, models ...interface{}) error {
+	for _, model := range models {
+		migrator := tx.Migrator()
+		if !migrator.HasTable(model) {
+			if err := migrator.CreateTable(model); err != nil {
+				return err
+			}
+			continue
+		}
+
+		stmt := &gorm.Statement{DB: tx}
+		if err := stmt.Parse(model); err != nil {
+			return err
+		}
+		for _, column := range stmt.Schema.DBNames {
+			if !migrator.HasColumn(model, column) {
+				if err := migrator.AddColumn(model, column); err != nil {
+					return err
+				}
+			}
+		}
+		for _, idx := range stmt.Schema.ParseIndexes() {
+			if !migrator.HasIndex(model, idx.Name) {
+				if err := migrator.CreateIndex(model, idx.Name); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
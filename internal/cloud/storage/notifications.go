@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/cloud-agent/internal/common"
+	"gorm.io/gorm"
+)
+
+// NotificationFilter 通知投递记录过滤条件
+type NotificationFilter struct {
+	Status    string // 投递状态
+	EventType string // 事件类型
+	EventID   string // 事件 ID
+	Channel   string // 通知渠道名称
+	BeforeID  uint   // 游标：只返回 ID 小于该值的记录
+	Limit     int
+}
+
+// CreateNotificationDeliveries 批量创建通知投递记录
+func (d *Database) CreateNotificationDeliveries(deliveries []*common.NotificationDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return d.db.Create(deliveries).Error
+}
+
+// GetNotificationDelivery 获取通知投递记录
+func (d *Database) GetNotificationDelivery(id uint) (*common.NotificationDelivery, error) {
+	var delivery common.NotificationDelivery
+	if err := d.db.First(&delivery, id).Error; err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// ClaimDueNotificationDeliveries 领取到期待投递的记录（最多 limit 条）
+// 领取时尝试次数加一并将下次投递时间推迟 lease，多副本同时领取同一条记录时只有一个成功；
+// 领取后副本异常退出的记录在 lease 到期后被重新领取
+func (d *Database) ClaimDueNotificationDeliveries(now time.Time, lease time.Duration, limit int) ([]*common.NotificationDelivery, error) {
+	var due []*common.NotificationDelivery
+	err := d.db.Where("status = ? AND next_attempt_at <= ?", common.NotificationStatusPending, now.Local()).
+		Order("next_attempt_at ASC").Limit(limit).Find(&due).Error
+	if err != nil {
+		return nil, err
+	}
+
+	claimed := due[:0]
+	for _, delivery := range due {
+		next := now.Add(lease)
+		result := d.db.Model(&common.NotificationDelivery{}).
+			Where("id = ? AND status = ? AND attempts = ?", delivery.ID, common.NotificationStatusPending, delivery.Attempts).
+			Updates(map[string]interface{}{
+				"attempts":        gorm.Expr("attempts + 1"),
+				"next_attempt_at": next,
+				"updated_at":      now,
+			})
+		if result.Error != nil {
+			return claimed, result.Error
+		}
+		if result.RowsAffected == 1 {
+			delivery.Attempts++
+			delivery.NextAttemptAt = next
+			claimed = append(claimed, delivery)
+		}
+	}
+	return claimed, nil
+}
+
+// UpdateNotificationDelivery 保存投递结果
+func (d *Database) UpdateNotificationDelivery(delivery *common.NotificationDelivery) error {
+	delivery.UpdatedAt = time.Now()
+	return d.db.Model(delivery).Select("status", "response_code", "last_error", "next_attempt_at", "delivered_at", "updated_at").
+		Updates(delivery).Error
+}
+
+// RetryNotificationDelivery 将投递记录重置为待投递（清零尝试次数）
+func (d *Database) RetryNotificationDelivery(id uint) error {
+	now := time.Now()
+	result := d.db.Model(&common.NotificationDelivery{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":          common.NotificationStatusPending,
+		"attempts":        0,
+		"next_attempt_at": now,
+		"updated_at":      now,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// ListNotificationDeliveries 按 ID 倒序列出通知投递记录
+func (d *Database) ListNotificationDeliveries(f *NotificationFilter) ([]*common.NotificationDelivery, error) {
+	query := d.db.Model(&common.NotificationDelivery{})
+	if f.Status != "" {
+		query = query.Where("status = ?", f.Status)
+	}
+	if f.EventType != "" {
+		query = query.Where("event_type = ?", f.EventType)
+	}
+	if f.EventID != "" {
+		query = query.Where("event_id = ?", f.EventID)
+	}
+	if f.Channel != "" {
+		query = query.Where("channel = ?", f.Channel)
+	}
+	if f.BeforeID > 0 {
+		query = query.Where("id < ?", f.BeforeID)
+	}
+
+	var deliveries []*common.NotificationDelivery
+	err := query.Order("id DESC").Limit(f.Limit).Find(&deliveries).Error
+	return deliveries, err
+}
+
+// PurgeNotificationDeliveries 删除 before 之前创建且已结束（成功或失败）的投递记录，返回删除的条数
+func (d *Database) PurgeNotificationDeliveries(before time.Time) (int64, error) {
+	result := d.db.Where("status <> ? AND created_at < ?", common.NotificationStatusPending, before.Local()).
+		Delete(&common.NotificationDelivery{})
+	return result.RowsAffected, result.Error
+}
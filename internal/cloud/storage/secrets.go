@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"github.com/cloud-agent/internal/common"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SaveSecret 创建或更新密钥库密钥
+func (d *Database) SaveSecret(secret *common.Secret) error {
+	return d.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"env", "allowed_hosts", "description", "ciphertext", "updated_by", "updated_at"}),
+	}).Create(secret).Error
+}
+
+// GetSecret 获取密钥库密钥
+func (d *Database) GetSecret(name string) (*common.Secret, error) {
+	var secret common.Secret
+	if err := d.db.Where("name = ?", name).First(&secret).Error; err != nil {
+		return nil, err
+	}
+	return &secret, nil
+}
+
+// ListSecrets 按名称列出密钥库密钥
+func (d *Database) ListSecrets() ([]*common.Secret, error) {
+	var secrets []*common.Secret
+	err := d.db.Order("name").Find(&secrets).Error
+	return secrets, err
+}
+
+// DeleteSecret 删除密钥库密钥，不存在时返回 gorm.ErrRecordNotFound
+func (d *Database) DeleteSecret(name string) error {
+	result := d.db.Where("name = ?", name).Delete(&common.Secret{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,371 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cloud-agent/internal/common"
+	"gorm.io/gorm"
+)
+
+// 任务列表分页大小
+const (
+	DefaultTaskPageSize = 50
+	MaxTaskPageSize     = 1000
+)
+
+// taskSortFields 支持排序的字段及其是否为时间类型
+var taskSortFields = map[string]bool{
+	"created_at": true,
+	"updated_at": true,
+	"agent_id":   false,
+	"type":       false,
+	"status":     false,
+}
+
+// TaskFilter 任务列表过滤条件
+type TaskFilter struct {
+	AgentID   string              // Agent ID
+	Statuses  []common.TaskStatus // 任务状态，多个为或关系
+	Types     []common.TaskType   // 任务类型，多个为或关系
+	Env       string              // Agent 所属环境
+	Tag       string              // 任务标签
+	CreatedBy string              // 创建者
+	Query     string              // 在命令、结果和错误信息中搜索（不区分大小写）
+	Since     *time.Time          // 创建时间不早于
+	Until     *time.Time          // 创建时间早于
+}
+
+// TaskQuery 任务列表查询
+// Cursor 不为空时使用游标分页并忽略 Offset；游标只能用于相同的过滤和排序条件
+type TaskQuery struct {
+	Filter TaskFilter
+	SortBy string // 排序字段，默认 created_at
+	Asc    bool   // 是否升序，默认降序
+	Cursor string // 上一页返回的 NextCursor
+	Limit  int
+	Offset int
+}
+
+// TaskPage 任务列表查询结果
+type TaskPage struct {
+	Tasks      []*common.Task `json:"tasks"`
+	Total      int64          `json:"total"`       // 符合过滤条件的任务总数
+	NextCursor string         `json:"next_cursor"` // 下一页游标，为空表示没有更多数据
+}
+
+// taskCursor 游标内容：最后一条记录的排序字段值和 ID
+type taskCursor struct {
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+// SearchTasks 按条件查询任务，支持排序、游标分页和总数统计
+func (d *Database) SearchTasks(q *TaskQuery) (*TaskPage, error) {
+	sortBy := q.SortBy
+	if sortBy == "" {
+		sortBy = "created_at"
+	}
+	isTime, ok := taskSortFields[sortBy]
+	if !ok {
+		return nil, common.NewErrorf("unsupported sort field: %s", sortBy)
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultTaskPageSize
+	}
+	if limit > MaxTaskPageSize {
+		limit = MaxTaskPageSize
+	}
+
+	page := &TaskPage{}
+	if err := d.applyTaskFilter(d.db.Model(&common.Task{}), &q.Filter).Count(&page.Total).Error; err != nil {
+		return nil, err
+	}
+
+	query := d.applyTaskFilter(d.db.Model(&common.Task{}), &q.Filter)
+	if q.Cursor != "" {
+		cursor, err := decodeTaskCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		var value interface{} = cursor.Value
+		if isTime {
+			t, err := time.Parse(time.RFC3339Nano, cursor.Value)
+			if err != nil {
+				return nil, common.NewError("invalid cursor")
+			}
+			value = t.Local()
+		}
+		op := "<"
+		if q.Asc {
+			op = ">"
+		}
+		query = query.Where("("+sortBy+" "+op+" ? OR ("+sortBy+" = ? AND id "+op+" ?))", value, value, cursor.ID)
+	} else if q.Offset > 0 {
+		query = query.Offset(q.Offset)
+	}
+
+	direction := " DESC"
+	if q.Asc {
+		direction = " ASC"
+	}
+	if err := query.Order(sortBy + direction + ", id" + direction).Limit(limit).Find(&page.Tasks).Error; err != nil {
+		return nil, err
+	}
+
+	if len(page.Tasks) == limit {
+		last := page.Tasks[len(page.Tasks)-1]
+		page.NextCursor = encodeTaskCursor(taskSortValue(last, sortBy), last.ID)
+	}
+	return page, nil
+}
+
+// CountTasksByStatus 按状态统计符合过滤条件的任务数
+func (d *Database) CountTasksByStatus(filter *TaskFilter) (map[common.TaskStatus]int64, error) {
+	var rows []struct {
+		Status common.TaskStatus
+		Count  int64
+	}
+	err := d.applyTaskFilter(d.db.Model(&common.Task{}), filter).
+		Select("status, COUNT(*) AS count").
+		Group("status").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[common.TaskStatus]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+	return counts, nil
+}
+
+// CountActiveTasksByAgent 统计各 Agent 待执行和执行中的任务数，没有任务的 Agent 不在结果中
+func (d *Database) CountActiveTasksByAgent(agentIDs []string) (map[string]int64, error) {
+	var rows []struct {
+		AgentID string
+		Count   int64
+	}
+	err := d.db.Model(&common.Task{}).
+		Where("agent_id IN ? AND status IN ?", agentIDs, []common.TaskStatus{common.TaskStatusPending, common.TaskStatusRunning}).
+		Select("agent_id, COUNT(*) AS count").
+		Group("agent_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.AgentID] = row.Count
+	}
+	return counts, nil
+}
+
+// applyTaskFilter 添加任务过滤条件
+func (d *Database) applyTaskFilter(query *gorm.DB, filter *TaskFilter) *gorm.DB {
+	if filter == nil {
+		return query
+	}
+	if filter.AgentID != "" {
+		query = query.Where("agent_id = ?", filter.AgentID)
+	}
+	if len(filter.Statuses) > 0 {
+		query = query.Where("status IN ?", filter.Statuses)
+	}
+	if len(filter.Types) > 0 {
+		query = query.Where("type IN ?", filter.Types)
+	}
+	if filter.Env != "" {
+		query = query.Where("agent_id IN (?)", d.db.Model(&common.Agent{}).Select("id").Where("env = ?", filter.Env))
+	}
+	if filter.Tag != "" {
+		// 标签以 JSON 数组保存，按带引号的完整标签匹配
+		query = query.Where("tags LIKE ? ESCAPE '!'", `%"`+escapeLike(filter.Tag)+`"%`)
+	}
+	if filter.CreatedBy != "" {
+		query = query.Where("created_by = ?", filter.CreatedBy)
+	}
+	if filter.Query != "" {
+		query = d.applyTaskMatch(query, filter.Query)
+	}
+	if filter.Since != nil {
+		query = query.Where("created_at >= ?", filter.Since.Local())
+	}
+	if filter.Until != nil {
+		query = query.Where("created_at < ?", filter.Until.Local())
+	}
+	return query
+}
+
+// applyTaskMatch 添加命令、结果和错误信息的子串匹配条件
+// 与日志搜索相同：SQLite 使用 FTS5 trigram 索引，PostgreSQL 使用 pg_trgm 索引，MySQL 使用 ngram 全文索引，
+// 搜索词短于分词长度时使用 LIKE 匹配
+func (d *Database) applyTaskMatch(query *gorm.DB, text string) *gorm.DB {
+	runes := utf8.RuneCountInString(text)
+	switch {
+	case d.dialect == DialectSQLite && runes >= sqliteTrigramMinRunes:
+		return query.Where("tasks.rowid IN (SELECT rowid FROM tasks_fts WHERE tasks_fts MATCH ?)",
+			`"`+strings.ReplaceAll(text, `"`, `""`)+`"`)
+	case d.dialect == DialectMySQL && runes >= mysqlNgramMinRunes && !strings.ContainsAny(text, `"`):
+		return query.Where("MATCH(command, result, error) AGAINST (? IN BOOLEAN MODE)", `+"`+text+`"`)
+	case d.dialect == DialectPostgres:
+		// pg_trgm 索引可以直接加速 ILIKE，多个列的条件通过位图索引扫描合并
+		pattern := "%" + escapeLike(text) + "%"
+		return query.Where("(command ILIKE ? ESCAPE '!' OR result ILIKE ? ESCAPE '!' OR error ILIKE ? ESCAPE '!')",
+			pattern, pattern, pattern)
+	default:
+		pattern := "%" + escapeLike(strings.ToLower(text)) + "%"
+		return query.Where("(LOWER(command) LIKE ? ESCAPE '!' OR LOWER(result) LIKE ? ESCAPE '!' OR LOWER(error) LIKE ? ESCAPE '!')",
+			pattern, pattern, pattern)
+	}
+}
+
+// ensureTaskSearchIndex 创建任务命令、结果和错误信息的全文搜索索引
+func ensureTaskSearchIndex(tx *gorm.DB) error {
+	switch tx.Dialector.Name() {
+	case DialectSQLite:
+		// 外部内容 FTS5 表，通过触发器与 tasks 表同步；只有命令、结果和错误信息变化时更新索引
+		stmts := []string{
+			`CREATE VIRTUAL TABLE IF NOT EXISTS tasks_fts USING fts5(command, result, error, content='tasks', tokenize='trigram')`,
+			`CREATE TRIGGER IF NOT EXISTS tasks_fts_insert AFTER INSERT ON tasks BEGIN
+				INSERT INTO tasks_fts(rowid, command, result, error) VALUES (new.rowid, new.command, new.result, new.error);
+			END`,
+			`CREATE TRIGGER IF NOT EXISTS tasks_fts_delete AFTER DELETE ON tasks BEGIN
+				INSERT INTO tasks_fts(tasks_fts, rowid, command, result, error) VALUES ('delete', old.rowid, old.command, old.result, old.error);
+			END`,
+			`CREATE TRIGGER IF NOT EXISTS tasks_fts_update AFTER UPDATE OF command, result, error ON tasks BEGIN
+				INSERT INTO tasks_fts(tasks_fts, rowid, command, result, error) VALUES ('delete', old.rowid, old.command, old.result, old.error);
+				INSERT INTO tasks_fts(rowid, command, result, error) VALUES (new.rowid, new.command, new.result, new.error);
+			END`,
+			// 为已有任务建立索引
+			`INSERT INTO tasks_fts(tasks_fts) VALUES ('rebuild')`,
+		}
+		for _, stmt := range stmts {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	case DialectPostgres:
+		// 创建扩展需要相应权限，失败时搜索仍可用（不使用索引）
+		tx.SavePoint("task_search_index")
+		err := tx.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm").Error
+		for _, column := range []string{"command", "result", "error"} {
+			if err != nil {
+				break
+			}
+			err = tx.Exec("CREATE INDEX IF NOT EXISTS idx_tasks_" + column + "_trgm ON tasks USING gin (" + column + " gin_trgm_ops)").Error
+		}
+		if err != nil {
+			log.Printf("Warning: failed to create trigram indexes for task search, searching without index: %v", err)
+			tx.RollbackTo("task_search_index")
+		}
+		return nil
+	case DialectMySQL:
+		if tx.Migrator().HasIndex(&common.Task{}, "idx_tasks_text_ft") {
+			return nil
+		}
+		return tx.Exec("CREATE FULLTEXT INDEX idx_tasks_text_ft ON tasks (command, result, error) WITH PARSER ngram").Error
+	}
+	return nil
+}
+
+// escapeLike 转义 LIKE 通配符，转义字符为 !
+func escapeLike(s string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
+}
+
+// taskSortValue 返回任务排序字段的值
+func taskSortValue(task *common.Task, sortBy string) string {
+	switch sortBy {
+	case "updated_at":
+		return task.UpdatedAt.Format(time.RFC3339Nano)
+	case "agent_id":
+		return task.AgentID
+	case "type":
+		return string(task.Type)
+	case "status":
+		return string(task.Status)
+	default:
+		return task.CreatedAt.Format(time.RFC3339Nano)
+	}
+}
+
+func encodeTaskCursor(value, id string) string {
+	data, _ := json.Marshal(taskCursor{Value: value, ID: id})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeTaskCursor(s string) (*taskCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, common.NewError("invalid cursor")
+	}
+	var cursor taskCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID == "" {
+		return nil, common.NewError("invalid cursor")
+	}
+	return &cursor, nil
+}
+
+// ListUnfinishedTasks 列出 Agent 上状态为 statuses 且最后更新早于 updatedBefore 的任务
+// agentID 为空时不限 Agent，updatedBefore 为零时不限更新时间
+func (d *Database) ListUnfinishedTasks(agentID string, statuses []common.TaskStatus, updatedBefore time.Time) ([]*common.Task, error) {
+	query := d.db.Where("status IN ?", statuses)
+	if agentID != "" {
+		query = query.Where("agent_id = ?", agentID)
+	}
+	if !updatedBefore.IsZero() {
+		query = query.Where("updated_at < ?", updatedBefore.Local())
+	}
+	var tasks []*common.Task
+	err := query.Order("created_at ASC").Find(&tasks).Error
+	return tasks, err
+}
+
+// CompareAndSetTaskStatus 仅当任务状态为 from 时更新为 to，返回是否更新成功
+// 多副本同时处理同一任务时只有一个副本成功
+func (d *Database) CompareAndSetTaskStatus(taskID string, from, to common.TaskStatus) (bool, error) {
+	now := time.Now()
+	updates := map[string]interface{}{
+		"status":     to,
+		"updated_at": now,
+	}
+	switch to {
+	case common.TaskStatusRunning:
+		updates["started_at"] = now
+	case common.TaskStatusSuccess, common.TaskStatusFailed, common.TaskStatusCanceled:
+		updates["finished_at"] = now
+	}
+	result := d.db.Model(&common.Task{}).Where("id = ? AND status = ?", taskID, from).Updates(updates)
+	return result.RowsAffected == 1, result.Error
+}
+
+// ApproveTask 仅当任务等待审批时记录审批人并改为运行中，返回是否更新成功
+// 多副本同时审批同一任务时只有一个副本成功
+func (d *Database) ApproveTask(taskID, approver string) (bool, error) {
+	now := time.Now()
+	result := d.db.Model(&common.Task{}).
+		Where("id = ? AND status = ?", taskID, common.TaskStatusPendingApproval).
+		Updates(map[string]interface{}{
+			"status":      common.TaskStatusRunning,
+			"approved_by": approver,
+			"started_at":  now,
+			"updated_at":  now,
+		})
+	return result.RowsAffected == 1, result.Error
+}
+
+// UpdateTaskJob 记录 Agent 上报的后台任务状态，任务不属于该 Agent 时不更新，返回是否更新成功
+func (d *Database) UpdateTaskJob(taskID, agentID string, job *common.JobStatus) (bool, error) {
+	result := d.db.Model(&common.Task{}).
+		Where("id = ? AND agent_id = ?", taskID, agentID).
+		Select("job").
+		Updates(&common.Task{Job: job})
+	return result.RowsAffected == 1, result.Error
+}
@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cloud-agent/internal/common"
+)
+
+// seedTasks 创建测试任务，created_at 依次递增一分钟，t3 和 t4 的创建时间相同
+func seedTasks(t *testing.T, db *Database) time.Time {
+	t.Helper()
+	db.CreateAgent(&common.Agent{ID: "a1", Name: "a1", Env: "prod"})
+	db.CreateAgent(&common.Agent{ID: "a2", Name: "a2", Env: "dev"})
+
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+	tasks := []*common.Task{
+		{ID: "t0", AgentID: "a1", Type: common.TaskTypeShell, Status: common.TaskStatusSuccess, Command: "ls /tmp", CreatedBy: "alice"},
+		{ID: "t1", AgentID: "a1", Type: common.TaskTypeMySQL, Status: common.TaskStatusFailed, Command: "SELECT 1", Error: "Access denied", Tags: []string{"db"}},
+		{ID: "t2", AgentID: "a2", Type: common.TaskTypeShell, Status: common.TaskStatusSuccess, Command: "df -h", Result: "100% used", CreatedBy: "bob"},
+		{ID: "t3", AgentID: "a2", Type: common.TaskTypeShell, Status: common.TaskStatusRunning, Command: "sleep 10", Tags: []string{"db-old"}},
+		{ID: "t4", AgentID: "a1", Type: common.TaskTypeShell, Status: common.TaskStatusPending, Command: "echo 50%_done", CreatedBy: "alice"},
+	}
+	offsets := []int{0, 1, 2, 3, 3}
+	for i, task := range tasks {
+		if err := db.CreateTask(task); err != nil {
+			t.Fatalf("CreateTask failed: %v", err)
+		}
+		createdAt := base.Add(time.Duration(offsets[i]) * time.Minute)
+		db.GetDB().Model(&common.Task{}).Where("id = ?", task.ID).UpdateColumn("created_at", createdAt)
+	}
+	return base
+}
+
+func taskIDs(tasks []*common.Task) string {
+	ids := ""
+	for _, task := range tasks {
+		ids += task.ID
+	}
+	return ids
+}
+
+func TestSearchTasksFilters(t *testing.T) {
+	db := newTestDatabase(t)
+	base := seedTasks(t, db)
+	since := base.Add(2 * time.Minute)
+
+	tests := []struct {
+		name   string
+		filter TaskFilter
+		want   string
+	}{
+		{"all", TaskFilter{}, "t4t3t2t1t0"},
+		{"agent", TaskFilter{AgentID: "a2"}, "t3t2"},
+		{"statuses", TaskFilter{Statuses: []common.TaskStatus{common.TaskStatusSuccess, common.TaskStatusFailed}}, "t2t1t0"},
+		{"type", TaskFilter{Types: []common.TaskType{common.TaskTypeMySQL}}, "t1"},
+		{"env", TaskFilter{Env: "dev"}, "t3t2"},
+		{"tag", TaskFilter{Tag: "db"}, "t1"},
+		{"tag wildcard escaped", TaskFilter{Tag: "d_"}, ""},
+		{"creator", TaskFilter{CreatedBy: "alice"}, "t4t0"},
+		{"query command", TaskFilter{Query: "TMP"}, "t0"},
+		{"query result", TaskFilter{Query: "used"}, "t2"},
+		{"query error", TaskFilter{Query: "denied"}, "t1"},
+		{"query wildcard escaped", TaskFilter{Query: "%_"}, "t4"},
+		{"since", TaskFilter{Since: &since}, "t4t3t2"},
+		{"until", TaskFilter{Until: &since}, "t1t0"},
+		{"combined", TaskFilter{Env: "prod", Types: []common.TaskType{common.TaskTypeShell}}, "t4t0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := db.SearchTasks(&TaskQuery{Filter: tt.filter})
+			if err != nil {
+				t.Fatalf("SearchTasks failed: %v", err)
+			}
+			if got := taskIDs(page.Tasks); got != tt.want {
+				t.Errorf("tasks = %s, want %s", got, tt.want)
+			}
+			if page.Total != int64(len(page.Tasks)) {
+				t.Errorf("total = %d, want %d", page.Total, len(page.Tasks))
+			}
+		})
+	}
+}
+
+func TestSearchTasksIndexFollowsUpdates(t *testing.T) {
+	db := newTestDatabase(t)
+	seedTasks(t, db)
+	search := func(query string) string {
+		t.Helper()
+		page, err := db.SearchTasks(&TaskQuery{Filter: TaskFilter{Query: query}})
+		if err != nil {
+			t.Fatalf("SearchTasks failed: %v", err)
+		}
+		return taskIDs(page.Tasks)
+	}
+
+	task, err := db.GetTask("t3")
+	if err != nil {
+		t.Fatalf("GetTask failed: %v", err)
+	}
+	task.Result = "Disk Quota exceeded"
+	if err := db.UpdateTask(task); err != nil {
+		t.Fatalf("UpdateTask failed: %v", err)
+	}
+	if got := search("quota"); got != "t3" {
+		t.Errorf("search after update = %q, want t3", got)
+	}
+	// 只更新状态不影响索引内容
+	if err := db.UpdateTaskStatus("t3", common.TaskStatusFailed); err != nil {
+		t.Fatalf("UpdateTaskStatus failed: %v", err)
+	}
+	if got := search("quota"); got != "t3" {
+		t.Errorf("search after status update = %q, want t3", got)
+	}
+
+	task.Result = ""
+	if err := db.UpdateTask(task); err != nil {
+		t.Fatalf("UpdateTask failed: %v", err)
+	}
+	if got := search("quota"); got != "" {
+		t.Errorf("search after result cleared = %q, want none", got)
+	}
+	if err := db.GetDB().Delete(&common.Task{}, "id = ?", "t2").Error; err != nil {
+		t.Fatalf("delete task failed: %v", err)
+	}
+	if got := search("used"); got != "" {
+		t.Errorf("search after delete = %q, want none", got)
+	}
+}
+
+func TestSearchTasksCursorPagination(t *testing.T) {
+	db := newTestDatabase(t)
+	seedTasks(t, db)
+
+	for _, asc := range []bool{false, true} {
+		t.Run(fmt.Sprintf("asc=%v", asc), func(t *testing.T) {
+			got := ""
+			cursor := ""
+			for pages := 0; ; pages++ {
+				if pages > 5 {
+					t.Fatal("pagination did not terminate")
+				}
+				page, err := db.SearchTasks(&TaskQuery{Asc: asc, Cursor: cursor, Limit: 2})
+				if err != nil {
+					t.Fatalf("SearchTasks failed: %v", err)
+				}
+				if page.Total != 5 {
+					t.Errorf("total = %d, want 5", page.Total)
+				}
+				got += taskIDs(page.Tasks)
+				if page.NextCursor == "" {
+					break
+				}
+				cursor = page.NextCursor
+			}
+			want := "t4t3t2t1t0"
+			if asc {
+				want = "t0t1t2t3t4"
+			}
+			if got != want {
+				t.Errorf("pages = %s, want %s", got, want)
+			}
+		})
+	}
+
+	page, err := db.SearchTasks(&TaskQuery{SortBy: "type", Asc: true, Limit: 1})
+	if err != nil || taskIDs(page.Tasks) != "t1" {
+		t.Fatalf("sort by type = %v, %v", page, err)
+	}
+	page, _ = db.SearchTasks(&TaskQuery{SortBy: "type", Asc: true, Limit: 10, Cursor: page.NextCursor})
+	if got := taskIDs(page.Tasks); got != "t0t2t3t4" {
+		t.Errorf("sort by type second page = %s", got)
+	}
+
+	if _, err := db.SearchTasks(&TaskQuery{SortBy: "command"}); err == nil {
+		t.Error("unsupported sort field should fail")
+	}
+	if _, err := db.SearchTasks(&TaskQuery{Cursor: "bogus"}); err == nil {
+		t.Error("invalid cursor should fail")
+	}
+}
+
+func TestCountTasksByStatus(t *testing.T) {
+	db := newTestDatabase(t)
+	seedTasks(t, db)
+
+	counts, err := db.CountTasksByStatus(&TaskFilter{Types: []common.TaskType{common.TaskTypeShell}})
+	if err != nil {
+		t.Fatalf("CountTasksByStatus failed: %v", err)
+	}
+	if counts[common.TaskStatusSuccess] != 2 || counts[common.TaskStatusRunning] != 1 ||
+		counts[common.TaskStatusPending] != 1 || counts[common.TaskStatusFailed] != 0 {
+		t.Errorf("counts = %v", counts)
+	}
+}
@@ -0,0 +1,35 @@
+package task
+
+import (
+	"fmt"
+
+	"github.com/cloud-agent/internal/common"
+)
+
+// ErrCapabilityMissing Agent 没有注册任务所需的执行器或连接
+var ErrCapabilityMissing = common.NewError("agent lacks required capability")
+
+// requiredConnection 任务使用的已配置连接名称，使用 target 动态连接或未指定时返回空
+func requiredConnection(params map[string]interface{}) string {
+	if _, ok := params["target"]; ok {
+		return ""
+	}
+	connection, _ := params["connection"].(string)
+	return connection
+}
+
+// checkCapability 检查 Agent 是否注册了任务类型的执行器和任务指定的连接
+// Agent 记录不存在或未上报能力（旧版本）时不做检查
+func (m *Manager) checkCapability(agentID string, taskType common.TaskType, params map[string]interface{}) error {
+	agent, err := m.db.GetAgent(agentID)
+	if err != nil || !agent.CapabilitiesKnown() {
+		return nil
+	}
+	if !agent.HasCapability(taskType, "") {
+		return fmt.Errorf("%w: agent %s has no %s executor", ErrCapabilityMissing, agentID, taskType)
+	}
+	if connection := requiredConnection(params); connection != "" && !agent.HasCapability(taskType, connection) {
+		return fmt.Errorf("%w: agent %s has no %s connection %q", ErrCapabilityMissing, agentID, taskType, connection)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package task
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cloud-agent/internal/cloud/agent"
+	"github.com/cloud-agent/internal/common"
+)
+
+func TestCapabilityRouting(t *testing.T) {
+	m, db, _ := newTestManager(t)
+	m.agentMgr = agent.NewManager(db, nil, nil)
+	t.Cleanup(m.agentMgr.Close)
+
+	now := time.Now()
+	db.CreateAgent(&common.Agent{ID: "prod-db", Name: "prod-db", Env: "prod", Status: common.AgentStatusOnline, LastSeen: &now,
+		Capabilities: []common.AgentCapability{{Type: common.TaskTypeShell}, {Type: common.TaskTypePostgres, Connections: []string{"default", "prod"}}}})
+	db.CreateAgent(&common.Agent{ID: "prod-web", Name: "prod-web", Env: "prod", Status: common.AgentStatusOnline, LastSeen: &now,
+		Capabilities: []common.AgentCapability{{Type: common.TaskTypeShell}}})
+	// 旧版本 Agent 未上报能力
+	db.CreateAgent(&common.Agent{ID: "prod-legacy", Name: "prod-legacy", Env: "prod", Status: common.AgentStatusOnline, LastSeen: &now})
+
+	pgParams := map[string]interface{}{"connection": "prod"}
+	for i := 0; i < 10; i++ {
+		agentID, err := m.SelectAgent(&TaskTarget{Selector: agent.Selector{Env: "prod", Capability: "postgres:prod"}}, common.TaskTypePostgres, pgParams)
+		if err != nil || agentID != "prod-db" {
+			t.Fatalf("SelectAgent = %q, %v; want prod-db", agentID, err)
+		}
+	}
+	if _, err := m.SelectAgent(&TaskTarget{Selector: agent.Selector{Env: "staging", Capability: "shell"}}, common.TaskTypeShell, nil); !errors.Is(err, ErrNoMatchingAgent) {
+		t.Errorf("SelectAgent in empty env error = %v, want ErrNoMatchingAgent", err)
+	}
+	// 任务本身的要求同样参与筛选
+	if _, err := m.SelectAgent(&TaskTarget{Selector: agent.Selector{Capability: "shell"}}, common.TaskTypePostgres, map[string]interface{}{"connection": "reporting"}); !errors.Is(err, ErrNoMatchingAgent) {
+		t.Errorf("SelectAgent without matching connection error = %v, want ErrNoMatchingAgent", err)
+	}
+
+	tests := []struct {
+		agentID  string
+		taskType common.TaskType
+		params   map[string]interface{}
+		wantErr  bool
+	}{
+		{"prod-db", common.TaskTypePostgres, pgParams, false},
+		{"prod-db", common.TaskTypePostgres, map[string]interface{}{"connection": "reporting"}, true},
+		{"prod-db", common.TaskTypePostgres, map[string]interface{}{"connection": "reporting", "target": map[string]interface{}{}}, false},
+		{"prod-web", common.TaskTypeMongo, nil, true},
+		{"prod-legacy", common.TaskTypeMongo, nil, false},
+		{"unknown", common.TaskTypeMongo, nil, false},
+	}
+	for _, tt := range tests {
+		err := m.checkCapability(tt.agentID, tt.taskType, tt.params)
+		if (err != nil) != tt.wantErr || (err != nil && !errors.Is(err, ErrCapabilityMissing)) {
+			t.Errorf("checkCapability(%s, %s, %v) = %v, wantErr %v", tt.agentID, tt.taskType, tt.params, err, tt.wantErr)
+		}
+	}
+}
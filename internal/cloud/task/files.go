@@ -0,0 +1,239 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cloud-agent/internal/cloud/filestore"
+	"github.com/cloud-agent/internal/common"
+)
+
+// ErrFileInUse 文件仍被待审批、待执行或执行中的任务引用
+var ErrFileInUse = common.NewError("file is referenced by active tasks")
+
+// expiredFileBatch 每轮回收处理的过期文件数上限
+const expiredFileBatch = 500
+
+// UploadOptions 上传选项
+type UploadOptions struct {
+	Tags      []string       // 文件标签
+	Retention *time.Duration // 保留时长，nil 使用默认保留策略，0 表示永久保留
+}
+
+// FileLifecycleConfig 文件生命周期配置
+type FileLifecycleConfig struct {
+	DefaultRetention  time.Duration // 上传文件的默认保留时长，0 表示永久保留
+	GCInterval        time.Duration // 回收过期文件和孤立对象的间隔，0 表示不自动回收
+	OrphanGracePeriod time.Duration // 孤立对象的最小存在时间，避免回收正在上传的对象
+}
+
+// DefaultFileLifecycleConfig 返回默认文件生命周期配置
+func DefaultFileLifecycleConfig() *FileLifecycleConfig {
+	return &FileLifecycleConfig{
+		DefaultRetention:  0,
+		GCInterval:        time.Hour,
+		OrphanGracePeriod: time.Hour,
+	}
+}
+
+// FileGCResult 文件回收结果
+type FileGCResult struct {
+	ExpiredFiles  int   `json:"expired_files"`  // 删除的过期文件记录数
+	SkippedInUse  int   `json:"skipped_in_use"` // 已过期但仍被任务引用、暂不删除的文件数
+	OrphanedBlobs int   `json:"orphaned_blobs"` // 删除的孤立对象数
+	FreedBytes    int64 `json:"freed_bytes"`    // 孤立对象释放的空间
+}
+
+// StartFileGC 设置文件生命周期配置并启动后台回收，Close 时停止
+func (m *Manager) StartFileGC(cfg *FileLifecycleConfig) {
+	if cfg == nil {
+		cfg = DefaultFileLifecycleConfig()
+	}
+	m.lifecycle = cfg
+	if cfg.GCInterval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(cfg.GCInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-m.stopCh:
+				return
+			case <-ticker.C:
+				result, err := m.RunFileGC(context.Background())
+				if err != nil {
+					log.Printf("[file-gc] failed: %v", err)
+					continue
+				}
+				if result.ExpiredFiles > 0 || result.OrphanedBlobs > 0 {
+					log.Printf("[file-gc] removed %d expired files, %d orphaned blobs (%d bytes)",
+						result.ExpiredFiles, result.OrphanedBlobs, result.FreedBytes)
+				}
+			}
+		}
+	}()
+}
+
+// expiresAt 根据保留时长计算过期时间
+func (m *Manager) expiresAt(retention *time.Duration) *time.Time {
+	d := m.lifecycle.DefaultRetention
+	if retention != nil {
+		d = *retention
+	}
+	if d <= 0 {
+		return nil
+	}
+	t := time.Now().Add(d)
+	return &t
+}
+
+// UpdateFileMetadata 更新文件标签和保留时长，retention 为 nil 时保持原过期时间
+func (m *Manager) UpdateFileMetadata(fileID string, tags []string, retention *time.Duration) (*common.File, error) {
+	file, err := m.db.GetFile(fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := file.ExpiresAt
+	if retention != nil {
+		expiresAt = nil
+		if *retention > 0 {
+			t := time.Now().Add(*retention)
+			expiresAt = &t
+		}
+	}
+	if tags == nil {
+		tags = file.Tags
+	}
+
+	if err := m.db.UpdateFileMetadata(fileID, tags, expiresAt); err != nil {
+		return nil, err
+	}
+	return m.db.GetFile(fileID)
+}
+
+// DeleteFile 删除文件
+// 文件仍被待审批、待执行或执行中的任务引用时返回 ErrFileInUse；存储对象在没有其他文件记录引用时一并删除
+func (m *Manager) DeleteFile(fileID string) error {
+	file, err := m.db.GetFile(fileID)
+	if err != nil {
+		return err
+	}
+
+	refs, active, err := m.db.DeleteFile(file)
+	if err != nil {
+		return err
+	}
+	if active > 0 {
+		return fmt.Errorf("%w (%d)", ErrFileInUse, active)
+	}
+	if refs == 0 {
+		m.releaseObject(context.Background(), file)
+	}
+	return nil
+}
+
+// releaseObject 删除不再被任何文件记录引用的存储对象
+// 引用计数与记录删除在同一事务中完成（见 storage.DeleteFile），去重创建的记录不会引用到这里删除的对象
+func (m *Manager) releaseObject(ctx context.Context, file *common.File) {
+	switch {
+	case file.StorageKey == "":
+		// 旧版本上传的文件直接存储在本地路径
+		if err := os.Remove(file.Path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to delete file %s: %v", file.Path, err)
+		}
+	case file.Backend == m.store.Type():
+		m.deleteObject(ctx, file.StorageKey)
+	default:
+		log.Printf("File %s is stored in %s backend, object %s is left for manual cleanup", file.ID, file.Backend, file.StorageKey)
+	}
+}
+
+// withFileLocation 返回填充了文件位置 file_path 的下发参数副本
+// 预签名链接有有效期且持有即可下载文件，只在每次下发时生成，不保存到数据库
+func (m *Manager) withFileLocation(params map[string]interface{}, file *common.File) map[string]interface{} {
+	if file == nil {
+		return params
+	}
+	dispatched := make(map[string]interface{}, len(params)+1)
+	maps.Copy(dispatched, params)
+	dispatched["file_path"] = m.fileLocation(file)
+	return dispatched
+}
+
+// deleteObject 删除存储对象，失败时记录日志（对象会被孤立对象回收清理）
+func (m *Manager) deleteObject(ctx context.Context, key string) {
+	if err := m.store.Delete(ctx, key); err != nil {
+		log.Printf("Failed to delete object %s: %v", key, err)
+	}
+}
+
+// ListFileDistributions 列出文件的分发记录
+func (m *Manager) ListFileDistributions(fileID string) ([]*common.FileDistribution, error) {
+	if _, err := m.db.GetFile(fileID); err != nil {
+		return nil, err
+	}
+	return m.db.ListFileDistributions(fileID)
+}
+
+// RunFileGC 回收过期文件和孤立对象
+func (m *Manager) RunFileGC(ctx context.Context) (*FileGCResult, error) {
+	result := &FileGCResult{}
+
+	// 过期文件：仍被任务引用的文件推迟到下一轮
+	expired, err := m.db.ListExpiredFiles(time.Now(), expiredFileBatch)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range expired {
+		if err := m.DeleteFile(file.ID); err != nil {
+			if errors.Is(err, ErrFileInUse) {
+				result.SkippedInUse++
+				continue
+			}
+			log.Printf("[file-gc] failed to delete expired file %s: %v", file.ID, err)
+			continue
+		}
+		result.ExpiredFiles++
+	}
+
+	// 孤立对象：存储中存在但没有文件记录引用（上传中断、删除对象失败等）
+	referenced, err := m.db.ListFileStorageKeys(m.store.Type())
+	if err != nil {
+		return nil, err
+	}
+	cutoff := time.Now().Add(-m.lifecycle.OrphanGracePeriod)
+	var orphans []*filestore.ObjectInfo
+	err = m.store.Walk(ctx, func(info *filestore.ObjectInfo) error {
+		// 只回收 <fileID>/<文件名> 格式的对象：旧版本直接存放在存储目录顶层的文件不在回收范围内，
+		// 日志归档由日志压缩管理
+		if !strings.Contains(info.Key, "/") || strings.HasPrefix(info.Key, LogArchivePrefix) {
+			return nil
+		}
+		if !referenced[info.Key] && info.ModTime.Before(cutoff) {
+			orphans = append(orphans, info)
+		}
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+	for _, info := range orphans {
+		if err := m.store.Delete(ctx, info.Key); err != nil {
+			log.Printf("[file-gc] failed to delete orphaned object %s: %v", info.Key, err)
+			continue
+		}
+		result.OrphanedBlobs++
+		result.FreedBytes += info.Size
+	}
+
+	return result, nil
+}
@@ -0,0 +1,252 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloud-agent/internal/cloud/filestore"
+	"github.com/cloud-agent/internal/cloud/storage"
+	"github.com/cloud-agent/internal/common"
+	"gorm.io/gorm"
+)
+
+// newTestManager 创建使用临时 SQLite 数据库和本地存储的任务管理器
+func newTestManager(t *testing.T) (*Manager, *storage.Database, string) {
+	t.Helper()
+	dir := t.TempDir()
+	db, err := storage.NewDatabaseWithConfig(&storage.Config{
+		DSN:      filepath.Join(dir, "cloud.db"),
+		LogLevel: "silent",
+	})
+	if err != nil {
+		t.Fatalf("NewDatabaseWithConfig failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	filesDir := filepath.Join(dir, "files")
+	store, err := filestore.NewLocalStore(filesDir)
+	if err != nil {
+		t.Fatalf("NewLocalStore failed: %v", err)
+	}
+
+	m := NewManager(db, nil, nil, store)
+	t.Cleanup(m.Close)
+	return m, db, filesDir
+}
+
+func saveFile(t *testing.T, m *Manager, name, content string, opts *UploadOptions) *common.File {
+	t.Helper()
+	file, err := m.SaveFile(strings.NewReader(content), name, "text/plain", int64(len(content)), opts)
+	if err != nil {
+		t.Fatalf("SaveFile(%s) failed: %v", name, err)
+	}
+	return file
+}
+
+func TestSaveFileDedupSharesObject(t *testing.T) {
+	m, db, _ := newTestManager(t)
+
+	a := saveFile(t, m, "a.sql", "SELECT 1;", nil)
+	again := saveFile(t, m, "a.sql", "SELECT 1;", nil)
+	if again.ID != a.ID {
+		t.Errorf("same name and content should return existing record")
+	}
+
+	b := saveFile(t, m, "b.sql", "SELECT 1;", nil)
+	if b.ID == a.ID || b.Name != "b.sql" {
+		t.Fatalf("different name should create a new record, got %+v", b)
+	}
+	if b.StorageKey != a.StorageKey {
+		t.Errorf("deduplicated records should share the object: %s vs %s", b.StorageKey, a.StorageKey)
+	}
+
+	// 删除其中一条记录不应删除共享对象
+	if err := m.DeleteFile(a.ID); err != nil {
+		t.Fatalf("DeleteFile failed: %v", err)
+	}
+	if _, err := os.Stat(b.Path); err != nil {
+		t.Errorf("shared object removed while still referenced: %v", err)
+	}
+
+	if err := m.DeleteFile(b.ID); err != nil {
+		t.Fatalf("DeleteFile failed: %v", err)
+	}
+	if _, err := os.Stat(b.Path); !os.IsNotExist(err) {
+		t.Errorf("object should be removed with last reference, stat err = %v", err)
+	}
+	if _, err := db.GetFile(b.ID); err == nil {
+		t.Error("file record should be deleted")
+	}
+}
+
+func TestSaveFileDedupRacesWithDelete(t *testing.T) {
+	m, db, _ := newTestManager(t)
+
+	// 源记录已被删除时不能创建共享其对象的记录
+	a := saveFile(t, m, "a.sql", "SELECT 1;", nil)
+	if err := m.DeleteFile(a.ID); err != nil {
+		t.Fatalf("DeleteFile failed: %v", err)
+	}
+	shared := &common.File{ID: "shared", Name: "b.sql", Path: a.Path, Backend: a.Backend, StorageKey: a.StorageKey}
+	if err := db.CreateSharedFile(shared, a.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("CreateSharedFile after source deleted = %v, want ErrRecordNotFound", err)
+	}
+
+	// 并发去重上传和删除：任何时候留下的记录都能读到对象
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Go(func() {
+			file, err := m.SaveFile(strings.NewReader("SELECT 2;"), fmt.Sprintf("f%d.sql", i), "text/plain", 9, nil)
+			if err != nil {
+				t.Errorf("SaveFile failed: %v", err)
+				return
+			}
+			if i%2 == 0 {
+				if err := m.DeleteFile(file.ID); err != nil {
+					t.Errorf("DeleteFile failed: %v", err)
+				}
+			}
+		})
+	}
+	wg.Wait()
+
+	files, err := db.ListFilesWithFilter(nil, 100, 0)
+	if err != nil {
+		t.Fatalf("ListFilesWithFilter failed: %v", err)
+	}
+	if len(files) == 0 {
+		t.Fatal("expected remaining files")
+	}
+	for _, file := range files {
+		if _, err := os.Stat(file.Path); err != nil {
+			t.Errorf("file %s (%s) lost its object: %v", file.ID, file.Name, err)
+		}
+	}
+}
+
+func TestDeleteFileReferencedByActiveTask(t *testing.T) {
+	m, db, _ := newTestManager(t)
+	file := saveFile(t, m, "deploy.sh", "echo hi", nil)
+
+	task := &common.Task{ID: "task-1", AgentID: "agent-1", Type: common.TaskTypeShell, Status: common.TaskStatusRunning}
+	if err := db.CreateTask(task); err != nil {
+		t.Fatalf("CreateTask failed: %v", err)
+	}
+	if err := db.CreateTaskFile(task.ID, file.ID); err != nil {
+		t.Fatalf("CreateTaskFile failed: %v", err)
+	}
+
+	if err := m.DeleteFile(file.ID); !errors.Is(err, ErrFileInUse) {
+		t.Fatalf("DeleteFile = %v, want ErrFileInUse", err)
+	}
+
+	if err := db.UpdateTaskStatus(task.ID, common.TaskStatusSuccess); err != nil {
+		t.Fatalf("UpdateTaskStatus failed: %v", err)
+	}
+	if err := m.DeleteFile(file.ID); err != nil {
+		t.Errorf("DeleteFile after task finished failed: %v", err)
+	}
+}
+
+func TestDeleteFileReferencedByPendingApprovalTask(t *testing.T) {
+	m, db, _ := newTestManager(t)
+	file := saveFile(t, m, "deploy.sh", "echo hi", nil)
+
+	// 等待审批的任务批准后才下发，文件在此之前不能删除
+	task := &common.Task{ID: "task-1", AgentID: "agent-1", Type: common.TaskTypeShell, Status: common.TaskStatusPendingApproval}
+	if err := db.CreateTask(task); err != nil {
+		t.Fatalf("CreateTask failed: %v", err)
+	}
+	if err := db.CreateTaskFile(task.ID, file.ID); err != nil {
+		t.Fatalf("CreateTaskFile failed: %v", err)
+	}
+	if err := m.DeleteFile(file.ID); !errors.Is(err, ErrFileInUse) {
+		t.Fatalf("DeleteFile = %v, want ErrFileInUse", err)
+	}
+	if _, err := os.Stat(file.Path); err != nil {
+		t.Errorf("object removed for pending approval task: %v", err)
+	}
+}
+
+func TestRunFileGC(t *testing.T) {
+	m, db, filesDir := newTestManager(t)
+	m.lifecycle.OrphanGracePeriod = time.Minute
+
+	retention := time.Millisecond
+	expired := saveFile(t, m, "old.txt", "old", &UploadOptions{Retention: &retention, Tags: []string{"tmp"}})
+	kept := saveFile(t, m, "keep.txt", "keep", nil)
+	if kept.ExpiresAt != nil {
+		t.Errorf("file without retention should never expire")
+	}
+
+	// 孤立对象（上传中断）和旧版本直接存放在存储目录顶层的文件
+	orphan := filepath.Join(filesDir, "orphan-id", "x.bin")
+	os.MkdirAll(filepath.Dir(orphan), 0755)
+	os.WriteFile(orphan, []byte("12345"), 0644)
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(orphan, old, old)
+	legacy := filepath.Join(filesDir, "legacy.txt")
+	os.WriteFile(legacy, []byte("legacy"), 0644)
+	os.Chtimes(legacy, old, old)
+
+	time.Sleep(5 * time.Millisecond)
+	result, err := m.RunFileGC(context.Background())
+	if err != nil {
+		t.Fatalf("RunFileGC failed: %v", err)
+	}
+	if result.ExpiredFiles != 1 || result.OrphanedBlobs != 1 || result.FreedBytes != 5 {
+		t.Errorf("RunFileGC = %+v", result)
+	}
+
+	if _, err := db.GetFile(expired.ID); err == nil {
+		t.Error("expired file should be deleted")
+	}
+	if _, err := os.Stat(kept.Path); err != nil {
+		t.Errorf("referenced object should be kept: %v", err)
+	}
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Error("orphaned object should be removed")
+	}
+	if _, err := os.Stat(legacy); err != nil {
+		t.Error("legacy top-level file should not be collected")
+	}
+}
+
+func TestUpdateFileMetadataAndTagFilter(t *testing.T) {
+	m, db, _ := newTestManager(t)
+	file := saveFile(t, m, "conf.yaml", "a: 1", &UploadOptions{Tags: []string{"config"}})
+	saveFile(t, m, "other.yaml", "b: 2", &UploadOptions{Tags: []string{"config-old"}})
+
+	files, err := db.ListFilesWithFilter(&storage.FileFilter{Tag: "config"}, 10, 0)
+	if err != nil || len(files) != 1 || files[0].ID != file.ID {
+		t.Fatalf("tag filter = %v, %v", files, err)
+	}
+	// LIKE 通配符按字面匹配
+	for _, filter := range []storage.FileFilter{{Tag: "%"}, {Tag: "conf_g"}, {Name: "%"}, {Name: "conf_yaml"}} {
+		if files, err := db.ListFilesWithFilter(&filter, 10, 0); err != nil || len(files) != 0 {
+			t.Errorf("filter %+v = %d files, %v, want none", filter, len(files), err)
+		}
+	}
+
+	retention := 24 * time.Hour
+	updated, err := m.UpdateFileMetadata(file.ID, []string{"prod"}, &retention)
+	if err != nil {
+		t.Fatalf("UpdateFileMetadata failed: %v", err)
+	}
+	if len(updated.Tags) != 1 || updated.Tags[0] != "prod" || updated.ExpiresAt == nil {
+		t.Errorf("updated file = %+v", updated)
+	}
+
+	forever := time.Duration(0)
+	updated, _ = m.UpdateFileMetadata(file.ID, nil, &forever)
+	if updated.ExpiresAt != nil || len(updated.Tags) != 1 {
+		t.Errorf("retention 0 should clear expiry and keep tags, got %+v", updated)
+	}
+}
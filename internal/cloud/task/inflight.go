@@ -0,0 +1,154 @@
+package task
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/cloud-agent/internal/common"
+)
+
+// ErrAgentOffline Agent 离线导致任务失败时记录的错误信息
+const ErrAgentOffline = "agent offline"
+
+// ErrRedactedParams 重新排队的任务参数包含已脱敏的明文密钥时记录的错误信息
+const ErrRedactedParams = "params contain redacted secrets, use secret:// references for requeueable tasks"
+
+// InFlightConfig Agent 离线时在途任务的处理策略
+// RequeueTypes 中的任务重新排队，等待 Agent 重新上线后再次下发；其他任务直接标记为失败
+type InFlightConfig struct {
+	RequeueTypes   []common.TaskType // 可以安全重新执行的任务类型，默认为空（全部失败）
+	RequeueTimeout time.Duration     // 重新排队的任务等待 Agent 上线的最长时间，超时后标记为失败
+}
+
+// DefaultInFlightConfig 返回默认策略：Agent 离线时在途任务全部标记为失败
+func DefaultInFlightConfig() *InFlightConfig {
+	return &InFlightConfig{
+		RequeueTimeout: 10 * time.Minute,
+	}
+}
+
+// SetInFlightPolicy 设置在途任务处理策略，为 nil 时使用默认策略
+func (m *Manager) SetInFlightPolicy(cfg *InFlightConfig) {
+	if cfg == nil {
+		cfg = DefaultInFlightConfig()
+	}
+	if cfg.RequeueTimeout <= 0 {
+		cfg.RequeueTimeout = DefaultInFlightConfig().RequeueTimeout
+	}
+	m.inFlight = cfg
+}
+
+// unfinishedStatuses 未结束的任务状态
+var unfinishedStatuses = []common.TaskStatus{common.TaskStatusPending, common.TaskStatusRunning}
+
+// HandleAgentOffline Agent 离线后失败或重新排队其未完成的任务
+func (m *Manager) HandleAgentOffline(agentID string) {
+	tasks, err := m.db.ListUnfinishedTasks(agentID, unfinishedStatuses, time.Time{})
+	if err != nil {
+		log.Printf("[inflight] failed to list tasks of agent %s: %v", agentID, err)
+		return
+	}
+
+	for _, task := range tasks {
+		// 后台任务由 Agent 监护，Agent 重新连接后通过 job.sync 重新关联
+		if task.Status == common.TaskStatusRunning && detached(task) {
+			m.taskLog(task.ID, "warn", "agent went offline, detached job keeps running on the agent")
+			continue
+		}
+		if slices.Contains(m.inFlight.RequeueTypes, task.Type) {
+			if task.Status == common.TaskStatusPending {
+				continue
+			}
+			requeued, err := m.db.CompareAndSetTaskStatus(task.ID, task.Status, common.TaskStatusPending)
+			if err != nil || !requeued {
+				continue
+			}
+			log.Printf("[inflight] agent %s offline, requeued task %s", agentID, task.ID)
+			m.taskLog(task.ID, "warn", "agent went offline, task requeued until the agent reconnects")
+			continue
+		}
+		m.failTask(task, ErrAgentOffline)
+	}
+}
+
+// HandleAgentOnline Agent 重新上线后下发 since 之前重新排队的任务
+func (m *Manager) HandleAgentOnline(agentID string, since time.Time) {
+	if len(m.inFlight.RequeueTypes) == 0 {
+		return
+	}
+	tasks, err := m.db.ListUnfinishedTasks(agentID, []common.TaskStatus{common.TaskStatusPending}, since)
+	if err != nil {
+		log.Printf("[inflight] failed to list requeued tasks of agent %s: %v", agentID, err)
+		return
+	}
+
+	for _, task := range tasks {
+		// 存储的参数中明文密钥已被脱敏，无法重新下发
+		if paramsRedacted(task.Params) {
+			m.failTask(task, ErrRedactedParams)
+			continue
+		}
+		claimed, err := m.db.CompareAndSetTaskStatus(task.ID, common.TaskStatusPending, common.TaskStatusRunning)
+		if err != nil || !claimed {
+			continue
+		}
+		if err := m.agentMgr.SendMessage(agentID, common.NewMessage(common.MessageTypeTaskCreate, m.storedTaskData(task))); err != nil {
+			// 发送失败时放回队列，等待下次上线或超时
+			m.db.CompareAndSetTaskStatus(task.ID, common.TaskStatusRunning, common.TaskStatusPending)
+			log.Printf("[inflight] failed to redispatch task %s to agent %s: %v", task.ID, agentID, err)
+			continue
+		}
+		log.Printf("[inflight] redispatched task %s to agent %s", task.ID, agentID)
+		m.taskLog(task.ID, "info", "agent reconnected, task dispatched again")
+	}
+}
+
+// ExpireRequeuedTasks 将等待超过 RequeueTimeout 的重新排队任务标记为失败
+func (m *Manager) ExpireRequeuedTasks() {
+	if len(m.inFlight.RequeueTypes) == 0 {
+		return
+	}
+	tasks, err := m.db.ListUnfinishedTasks("", []common.TaskStatus{common.TaskStatusPending}, time.Now().Add(-m.inFlight.RequeueTimeout))
+	if err != nil {
+		log.Printf("[inflight] failed to list requeued tasks: %v", err)
+		return
+	}
+	for _, task := range tasks {
+		m.failTask(task, fmt.Sprintf("%s: not reconnected within %s", ErrAgentOffline, m.inFlight.RequeueTimeout))
+	}
+}
+
+// failTask 将未结束的任务标记为失败，并触发与 Agent 上报失败相同的处理（指标、审计、通知、同步等待）
+// 多副本同时处理时只有一个副本成功
+func (m *Manager) failTask(task *common.Task, reason string) {
+	claimed, err := m.db.CompareAndSetTaskStatus(task.ID, task.Status, common.TaskStatusFailed)
+	if err != nil {
+		log.Printf("[inflight] failed to fail task %s: %v", task.ID, err)
+		return
+	}
+	if !claimed {
+		return
+	}
+	log.Printf("[inflight] task %s on agent %s failed: %s", task.ID, task.AgentID, reason)
+	if err := m.finishTask(&common.TaskCompleteData{
+		TaskID: task.ID,
+		Status: common.TaskStatusFailed,
+		Error:  reason,
+	}); err != nil {
+		log.Printf("[inflight] failed to complete task %s: %v", task.ID, err)
+	}
+}
+
+// taskLog 为任务写入一条 Cloud 侧日志
+func (m *Manager) taskLog(taskID, level, message string) {
+	if err := m.SaveLog(&common.TaskLogData{
+		TaskID:    taskID,
+		Level:     level,
+		Message:   message,
+		Timestamp: time.Now().Unix(),
+	}); err != nil {
+		log.Printf("[inflight] failed to save log of task %s: %v", taskID, err)
+	}
+}
@@ -0,0 +1,94 @@
+package task
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cloud-agent/internal/cloud/agent"
+	"github.com/cloud-agent/internal/common"
+)
+
+func TestHandleAgentOfflineFailsAndRequeues(t *testing.T) {
+	m, db, _ := newTestManager(t)
+	m.agentMgr = agent.NewManager(db, nil, nil)
+	t.Cleanup(m.agentMgr.Close)
+	m.SetInFlightPolicy(&InFlightConfig{RequeueTypes: []common.TaskType{common.TaskTypeFile}, RequeueTimeout: time.Hour})
+
+	db.CreateTask(&common.Task{ID: "shell", AgentID: "a1", Type: common.TaskTypeShell, Status: common.TaskStatusRunning})
+	db.CreateTask(&common.Task{ID: "file", AgentID: "a1", Type: common.TaskTypeFile, Status: common.TaskStatusRunning})
+	db.CreateTask(&common.Task{ID: "other", AgentID: "a2", Type: common.TaskTypeShell, Status: common.TaskStatusRunning})
+	db.CreateTask(&common.Task{ID: "done", AgentID: "a1", Type: common.TaskTypeShell, Status: common.TaskStatusSuccess})
+
+	m.HandleAgentOffline("a1")
+	// 重复处理（例如另一个副本）不应产生影响
+	m.HandleAgentOffline("a1")
+
+	want := map[string]common.TaskStatus{
+		"shell": common.TaskStatusFailed,
+		"file":  common.TaskStatusPending,
+		"other": common.TaskStatusRunning,
+		"done":  common.TaskStatusSuccess,
+	}
+	for id, status := range want {
+		task, err := db.GetTask(id)
+		if err != nil {
+			t.Fatalf("GetTask(%s) failed: %v", id, err)
+		}
+		if task.Status != status {
+			t.Errorf("task %s status = %s, want %s", id, task.Status, status)
+		}
+	}
+	if task, _ := db.GetTask("shell"); task.Error != ErrAgentOffline || task.FinishedAt == nil {
+		t.Errorf("failed task error = %q, finished_at = %v", task.Error, task.FinishedAt)
+	}
+
+	// Agent 未连接时重新下发失败，任务保持排队
+	m.HandleAgentOnline("a1", time.Now().Add(time.Second))
+	if task, _ := db.GetTask("file"); task.Status != common.TaskStatusPending {
+		t.Errorf("task should stay pending when redispatch fails, got %s", task.Status)
+	}
+
+	// 排队超时后标记为失败
+	m.inFlight.RequeueTimeout = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	m.ExpireRequeuedTasks()
+	if task, _ := db.GetTask("file"); task.Status != common.TaskStatusFailed {
+		t.Errorf("expired task status = %s, want failed", task.Status)
+	}
+}
+
+func TestCompleteTaskIgnoresLateResults(t *testing.T) {
+	m, db, _ := newTestManager(t)
+
+	db.CreateTask(&common.Task{ID: "running", AgentID: "a1", Type: common.TaskTypeShell, Status: common.TaskStatusRunning})
+	db.CreateTask(&common.Task{ID: "failed", AgentID: "a1", Type: common.TaskTypeShell, Status: common.TaskStatusFailed, Error: ErrAgentOffline})
+	db.CreateTask(&common.Task{ID: "requeued", AgentID: "a1", Type: common.TaskTypeFile, Status: common.TaskStatusPending})
+	db.CreateTask(&common.Task{ID: "canceled", AgentID: "a1", Type: common.TaskTypeShell, Status: common.TaskStatusCanceled})
+
+	want := map[string]common.TaskStatus{
+		"running":  common.TaskStatusSuccess,
+		"failed":   common.TaskStatusFailed,
+		"requeued": common.TaskStatusPending,
+		"canceled": common.TaskStatusCanceled,
+	}
+	for id := range want {
+		if err := m.CompleteTask(&common.TaskCompleteData{TaskID: id, Status: common.TaskStatusSuccess, Result: "ok"}); err != nil {
+			t.Fatalf("CompleteTask(%s) failed: %v", id, err)
+		}
+	}
+	for id, status := range want {
+		task, err := db.GetTask(id)
+		if err != nil {
+			t.Fatalf("GetTask(%s) failed: %v", id, err)
+		}
+		if task.Status != status {
+			t.Errorf("task %s status = %s, want %s", id, task.Status, status)
+		}
+		if id != "running" && task.Result != "" {
+			t.Errorf("late result of task %s should be ignored, got %q", id, task.Result)
+		}
+	}
+	if task, _ := db.GetTask("failed"); task.Error != ErrAgentOffline {
+		t.Errorf("failed task error = %q, want %q", task.Error, ErrAgentOffline)
+	}
+}
@@ -0,0 +1,90 @@
+package task
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/cloud-agent/internal/common"
+	"gorm.io/gorm"
+)
+
+// ErrJobLost Agent 重新连接后没有上报后台任务时记录的错误信息
+const ErrJobLost = "detached job not found on agent"
+
+// detached 任务是否为后台任务（Shell 任务 params.detach 为 true），Agent 离线时不影响其执行
+func detached(task *common.Task) bool {
+	if task.Type != common.TaskTypeShell || task.Params == "" {
+		return false
+	}
+	var params struct {
+		Detach bool `json:"detach"`
+	}
+	return json.Unmarshal([]byte(task.Params), &params) == nil && params.Detach
+}
+
+// UpdateJobStatus 记录 Agent 上报的后台任务状态，任务不属于该 Agent 时忽略
+func (m *Manager) UpdateJobStatus(agentID string, job *common.JobStatus) {
+	if _, err := m.db.UpdateTaskJob(job.TaskID, agentID, job); err != nil {
+		log.Printf("[jobs] failed to update job status of task %s: %v", job.TaskID, err)
+	}
+}
+
+// SyncJobs Agent 连接后按其上报的后台任务重新关联任务
+// 先保存 Agent 已上报但未被确认的结果并回复 job.ack；Agent 上已不存在的运行中后台任务标记为失败；
+// Agent 离线期间已取消或结束的任务再次通知 Agent 取消
+func (m *Manager) SyncJobs(agentID string, data *common.JobSyncData) {
+	for i := range data.Completed {
+		complete := &data.Completed[i]
+		task, err := m.db.GetTask(complete.TaskID)
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			// 任务已被删除，结果不再需要
+		case err != nil:
+			log.Printf("[jobs] failed to load task %s: %v", complete.TaskID, err)
+			continue
+		case task.AgentID != agentID:
+			continue
+		default:
+			if err := m.CompleteTask(complete); err != nil {
+				log.Printf("[jobs] failed to complete task %s: %v", complete.TaskID, err)
+				continue
+			}
+		}
+		msg := common.NewMessage(common.MessageTypeJobAck, common.JobAckData{TaskID: complete.TaskID})
+		if err := m.agentMgr.SendMessage(agentID, msg); err != nil {
+			log.Printf("[jobs] failed to acknowledge result of task %s: %v", complete.TaskID, err)
+		}
+	}
+
+	reported := make(map[string]bool, len(data.Jobs))
+	for i := range data.Jobs {
+		job := &data.Jobs[i]
+		task, err := m.db.GetTask(job.TaskID)
+		if err != nil || task.AgentID != agentID {
+			continue
+		}
+		reported[job.TaskID] = true
+		m.UpdateJobStatus(agentID, job)
+
+		if task.Status != common.TaskStatusRunning && job.State == common.JobStateRunning {
+			log.Printf("[jobs] task %s is %s, killing its job on agent %s", task.ID, task.Status, agentID)
+			msg := common.NewMessage(common.MessageTypeTaskCancel, map[string]interface{}{"task_id": task.ID})
+			if err := m.agentMgr.SendMessage(agentID, msg); err != nil {
+				log.Printf("[jobs] failed to cancel job of task %s: %v", task.ID, err)
+			}
+		}
+	}
+
+	tasks, err := m.db.ListUnfinishedTasks(agentID, []common.TaskStatus{common.TaskStatusRunning}, time.Time{})
+	if err != nil {
+		log.Printf("[jobs] failed to list tasks of agent %s: %v", agentID, err)
+		return
+	}
+	for _, task := range tasks {
+		if detached(task) && !reported[task.ID] {
+			m.failTask(task, ErrJobLost)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package task
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/cloud-agent/internal/cloud/agent"
+	"github.com/cloud-agent/internal/cloud/cluster"
+	"github.com/cloud-agent/internal/common"
+)
+
+func TestDetachedJobs(t *testing.T) {
+	m, db, _ := newTestManager(t)
+	// Agent 连接在另一个副本上，通过订阅该副本的主题观察下发的消息
+	cl := cluster.NewLocal("r1")
+	m.agentMgr = agent.NewManager(db, cl, nil)
+	t.Cleanup(m.agentMgr.Close)
+	if _, err := cl.Registry.Register("a1", "r2"); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	sent := make(chan *common.Message, 10)
+	unsubscribe, err := cl.Bus.Subscribe(cluster.ReplicaTopic("r2"), func(env *cluster.Envelope) { sent <- env.Message })
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	t.Cleanup(unsubscribe)
+
+	detach := `{"detach":true}`
+	db.CreateTask(&common.Task{ID: "job", AgentID: "a1", Type: common.TaskTypeShell, Status: common.TaskStatusRunning, Params: detach})
+	db.CreateTask(&common.Task{ID: "finished", AgentID: "a1", Type: common.TaskTypeShell, Status: common.TaskStatusRunning, Params: detach})
+	db.CreateTask(&common.Task{ID: "lost", AgentID: "a1", Type: common.TaskTypeShell, Status: common.TaskStatusRunning, Params: detach})
+	db.CreateTask(&common.Task{ID: "canceled", AgentID: "a1", Type: common.TaskTypeShell, Status: common.TaskStatusCanceled, Params: detach})
+	db.CreateTask(&common.Task{ID: "attached", AgentID: "a1", Type: common.TaskTypeShell, Status: common.TaskStatusRunning})
+	db.CreateTask(&common.Task{ID: "other", AgentID: "a2", Type: common.TaskTypeShell, Status: common.TaskStatusRunning, Params: detach})
+
+	// Agent 离线时后台任务继续运行，其他任务失败
+	m.HandleAgentOffline("a1")
+	if task, _ := db.GetTask("job"); task.Status != common.TaskStatusRunning {
+		t.Errorf("detached task status = %s, want running", task.Status)
+	}
+	if task, _ := db.GetTask("attached"); task.Status != common.TaskStatusFailed {
+		t.Errorf("attached task status = %s, want failed", task.Status)
+	}
+
+	// 重新连接后：上报的任务记录状态，未上报的失败，已取消的通知 Agent 结束
+	// finished 的结果在 Agent 离线前已发出但没有保存，随 job.sync 再次上报
+	exitCode := 0
+	m.SyncJobs("a1", &common.JobSyncData{
+		Jobs: []common.JobStatus{
+			{TaskID: "job", State: common.JobStateRunning, PID: 42, OutputBytes: 10, LastOutput: "50% done"},
+			{TaskID: "finished", State: common.JobStateExited, ExitCode: &exitCode},
+			{TaskID: "canceled", State: common.JobStateRunning, PID: 43},
+			{TaskID: "other", State: common.JobStateExited, ExitCode: &exitCode},
+		},
+		Completed: []common.TaskCompleteData{
+			{TaskID: "finished", Status: common.TaskStatusSuccess, Result: "ok", Detached: true},
+			{TaskID: "other", Status: common.TaskStatusSuccess, Detached: true},
+		},
+	})
+
+	task, _ := db.GetTask("job")
+	if task.Status != common.TaskStatusRunning || task.Job == nil || task.Job.PID != 42 || task.Job.LastOutput != "50% done" {
+		t.Errorf("job task = %s %+v", task.Status, task.Job)
+	}
+	if task, _ := db.GetTask("lost"); task.Status != common.TaskStatusFailed || task.Error != ErrJobLost {
+		t.Errorf("lost task = %s %q", task.Status, task.Error)
+	}
+	// 其他 Agent 的任务不受影响
+	if task, _ := db.GetTask("other"); task.Status != common.TaskStatusRunning || task.Job != nil {
+		t.Errorf("other agent's task = %s %+v", task.Status, task.Job)
+	}
+	if task, _ := db.GetTask("finished"); task.Status != common.TaskStatusSuccess || task.Result != "ok" {
+		t.Errorf("finished task = %s %q, want the reported result", task.Status, task.Result)
+	}
+	// 保存结果后确认，其他 Agent 的任务不确认
+	for _, want := range []struct {
+		msgType common.MessageType
+		taskID  string
+	}{{common.MessageTypeJobAck, "finished"}, {common.MessageTypeTaskCancel, "canceled"}} {
+		msg := <-sent
+		var data common.JobAckData
+		raw, _ := json.Marshal(msg.Data)
+		if json.Unmarshal(raw, &data); msg.Type != want.msgType || data.TaskID != want.taskID {
+			t.Errorf("sent %s %v, want %s for %s", msg.Type, msg.Data, want.msgType, want.taskID)
+		}
+	}
+	select {
+	case msg := <-sent:
+		t.Errorf("unexpected message %s %v", msg.Type, msg.Data)
+	default:
+	}
+
+	// 之后的状态上报更新进度
+	m.UpdateJobStatus("a1", &common.JobStatus{TaskID: "job", State: common.JobStateExited, ExitCode: &exitCode})
+	if task, _ := db.GetTask("job"); task.Job == nil || task.Job.State != common.JobStateExited || task.Job.ExitCode == nil {
+		t.Errorf("updated job = %+v", task.Job)
+	}
+}
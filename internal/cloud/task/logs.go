@@ -0,0 +1,316 @@
+package task
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/cloud-agent/internal/common"
+	"gopkg.in/yaml.v3"
+)
+
+// LogArchivePrefix 日志归档在存储后端中的对象 key 前缀
+const LogArchivePrefix = "logs/"
+
+// logCandidateBatch 日志压缩每次读取的任务数
+const logCandidateBatch = 200
+
+// LogPolicy 日志保留策略，时间从任务结束开始计算
+type LogPolicy struct {
+	ArchiveAfter time.Duration `yaml:"archive_after"` // 多久后将日志压缩归档到存储并从数据库删除，0 表示不归档
+	DeleteAfter  time.Duration `yaml:"delete_after"`  // 多久后彻底删除日志（包括归档），0 表示永久保留
+}
+
+// LogRetentionConfig 日志保留配置
+// 策略优先级：envs（按 Agent 所属环境）> types（按任务类型）> default
+type LogRetentionConfig struct {
+	Interval time.Duration                 `yaml:"interval"` // 后台压缩间隔，0 表示不自动执行
+	Default  LogPolicy                     `yaml:"default"`
+	Types    map[common.TaskType]LogPolicy `yaml:"types"`
+	Envs     map[string]LogPolicy          `yaml:"envs"`
+}
+
+// DefaultLogRetentionConfig 返回默认日志保留配置：任务结束 7 天后归档，永久保留
+func DefaultLogRetentionConfig() *LogRetentionConfig {
+	return &LogRetentionConfig{
+		Interval: 10 * time.Minute,
+		Default: LogPolicy{
+			ArchiveAfter: 7 * 24 * time.Hour,
+		},
+	}
+}
+
+// LoadLogRetentionConfig 从 YAML 文件加载日志保留配置，未配置的字段使用默认值
+func LoadLogRetentionConfig(path string) (*LogRetentionConfig, error) {
+	cfg := DefaultLogRetentionConfig()
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read log retention config: %w", err)
+	}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse log retention config: %w", err)
+	}
+	return cfg, nil
+}
+
+// PolicyFor 返回任务适用的日志保留策略
+func (c *LogRetentionConfig) PolicyFor(taskType common.TaskType, env string) LogPolicy {
+	if policy, ok := c.Envs[env]; ok && env != "" {
+		return policy
+	}
+	if policy, ok := c.Types[taskType]; ok {
+		return policy
+	}
+	return c.Default
+}
+
+// minAge 返回所有策略中最短的生效时间（早于该时间结束的任务才需要检查），以及是否有策略会删除日志
+func (c *LogRetentionConfig) minAge() (min time.Duration, found bool, deletes bool) {
+	check := func(d time.Duration) {
+		if d > 0 && (!found || d < min) {
+			min, found = d, true
+		}
+	}
+	policies := []LogPolicy{c.Default}
+	for _, p := range c.Types {
+		policies = append(policies, p)
+	}
+	for _, p := range c.Envs {
+		policies = append(policies, p)
+	}
+	for _, p := range policies {
+		check(p.ArchiveAfter)
+		check(p.DeleteAfter)
+		if p.DeleteAfter > 0 {
+			deletes = true
+		}
+	}
+	return min, found, deletes
+}
+
+// LogCompactionResult 日志压缩结果
+type LogCompactionResult struct {
+	ArchivedTasks int   `json:"archived_tasks"` // 归档的任务数
+	ArchivedLines int64 `json:"archived_lines"` // 归档的日志行数
+	PurgedTasks   int   `json:"purged_tasks"`   // 删除日志的任务数
+}
+
+// StartLogCompactor 设置日志保留配置并启动后台压缩，Close 时停止
+func (m *Manager) StartLogCompactor(cfg *LogRetentionConfig) {
+	if cfg == nil {
+		cfg = DefaultLogRetentionConfig()
+	}
+	m.logRetention = cfg
+	if cfg.Interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-m.stopCh:
+				return
+			case <-ticker.C:
+				result, err := m.CompactLogs(context.Background())
+				if err != nil {
+					log.Printf("[log-compactor] failed: %v", err)
+					continue
+				}
+				if result.ArchivedTasks > 0 || result.PurgedTasks > 0 {
+					log.Printf("[log-compactor] archived %d tasks (%d lines), purged %d tasks",
+						result.ArchivedTasks, result.ArchivedLines, result.PurgedTasks)
+				}
+			}
+		}
+	}()
+}
+
+// CompactLogs 按保留策略归档或删除已结束任务的日志
+func (m *Manager) CompactLogs(ctx context.Context) (*LogCompactionResult, error) {
+	result := &LogCompactionResult{}
+	cfg := m.logRetention
+	minAge, ok, deletes := cfg.minAge()
+	if !ok {
+		return result, nil
+	}
+
+	now := time.Now()
+	envs := make(map[string]string) // agentID -> env
+	var afterUpdated time.Time
+	afterID := ""
+	for {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		tasks, err := m.db.ListLogRetentionCandidates(now.Add(-minAge), deletes, afterUpdated, afterID, logCandidateBatch)
+		if err != nil {
+			return result, err
+		}
+		for _, task := range tasks {
+			env, cached := envs[task.AgentID]
+			if !cached {
+				if agent, err := m.db.GetAgent(task.AgentID); err == nil {
+					env = agent.Env
+				}
+				envs[task.AgentID] = env
+			}
+
+			policy := cfg.PolicyFor(task.Type, env)
+			age := now.Sub(task.UpdatedAt)
+			switch {
+			case policy.DeleteAfter > 0 && age >= policy.DeleteAfter:
+				if err := m.purgeTaskLogs(ctx, task); err != nil {
+					log.Printf("[log-compactor] failed to purge logs of task %s: %v", task.ID, err)
+					continue
+				}
+				result.PurgedTasks++
+			case policy.ArchiveAfter > 0 && age >= policy.ArchiveAfter && task.LogState == "":
+				lines, archived, err := m.archiveTaskLogs(ctx, task)
+				if err != nil {
+					log.Printf("[log-compactor] failed to archive logs of task %s: %v", task.ID, err)
+					continue
+				}
+				if archived {
+					result.ArchivedTasks++
+					result.ArchivedLines += lines
+				}
+			}
+		}
+
+		if len(tasks) < logCandidateBatch {
+			return result, nil
+		}
+		last := tasks[len(tasks)-1]
+		afterUpdated, afterID = last.UpdatedAt, last.ID
+	}
+}
+
+// archiveTaskLogs 将任务日志压缩为 gzip JSONL 写入存储后端，并删除数据库中的日志
+func (m *Manager) archiveTaskLogs(ctx context.Context, task *common.Task) (int64, bool, error) {
+	logs, err := m.db.GetAllTaskLogs(task.ID)
+	if err != nil {
+		return 0, false, err
+	}
+
+	key := ""
+	if len(logs) > 0 {
+		key = LogArchivePrefix + task.ID + ".jsonl.gz"
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(writeLogArchive(pw, logs))
+		}()
+		_, err := m.store.Put(ctx, key, pr, -1, "application/gzip")
+		pr.Close()
+		if err != nil {
+			return 0, false, err
+		}
+	}
+
+	lines := int64(len(logs))
+	archived, err := m.db.ArchiveTaskLogs(task.ID, key, lines)
+	if err != nil {
+		return 0, false, err
+	}
+	return lines, archived, nil
+}
+
+// purgeTaskLogs 删除任务的数据库日志和归档
+func (m *Manager) purgeTaskLogs(ctx context.Context, task *common.Task) error {
+	if err := m.db.PurgeTaskLogs(task.ID); err != nil {
+		return err
+	}
+	if task.LogArchive != "" {
+		m.deleteObject(ctx, task.LogArchive)
+	}
+	return nil
+}
+
+// writeLogArchive 以 gzip 压缩的 JSONL 格式写入日志，每行一条日志
+func writeLogArchive(w io.Writer, logs []*common.Log) error {
+	gz := gzip.NewWriter(w)
+	enc := json.NewEncoder(gz)
+	for _, entry := range logs {
+		if err := enc.Encode(entry); err != nil {
+			return err
+		}
+	}
+	return gz.Close()
+}
+
+// readLogArchive 读取日志归档，最多返回 limit 条（limit <= 0 表示全部）
+func readLogArchive(r io.Reader, limit int) ([]*common.Log, error) {
+	var logs []*common.Log
+	err := scanLogArchive(r, func(entry *common.Log) bool {
+		if limit > 0 && len(logs) >= limit {
+			return false
+		}
+		logs = append(logs, entry)
+		return true
+	})
+	return logs, err
+}
+
+// scanLogArchive 逐条读取日志归档，fn 返回 false 时停止
+func scanLogArchive(r io.Reader, fn func(entry *common.Log) bool) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("invalid log archive: %w", err)
+	}
+	defer gz.Close()
+
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry common.Log
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("invalid log archive line: %w", err)
+		}
+		if !fn(&entry) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+// GetTaskLogs 获取任务日志：已归档的任务从存储读取归档，再追加归档之后写入数据库的日志
+func (m *Manager) GetTaskLogs(taskID string, limit int) ([]*common.Log, error) {
+	task, err := m.db.GetTask(taskID)
+	if err != nil || task.LogState != common.TaskLogStateArchived || task.LogArchive == "" {
+		return m.db.GetTaskLogs(taskID, limit)
+	}
+
+	reader, _, err := m.store.Open(context.Background(), task.LogArchive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log archive: %w", err)
+	}
+	defer reader.Close()
+
+	logs, err := readLogArchive(reader, limit)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 || len(logs) < limit {
+		restLimit := -1 // gorm 中负数表示不限制
+		if limit > 0 {
+			restLimit = limit - len(logs)
+		}
+		rest, err := m.db.GetTaskLogs(taskID, restLimit)
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, rest...)
+	}
+	return logs, nil
+}
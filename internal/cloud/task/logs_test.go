@@ -0,0 +1,191 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloud-agent/internal/cloud/cluster"
+	"github.com/cloud-agent/internal/cloud/storage"
+	"github.com/cloud-agent/internal/common"
+)
+
+// createFinishedTask 创建一个已结束的任务并写入日志，updated_at 设置为 age 之前
+func createFinishedTask(t *testing.T, m *Manager, db *storage.Database, id string, taskType common.TaskType, agentID string, lines int, age time.Duration) {
+	t.Helper()
+	task := &common.Task{ID: id, AgentID: agentID, Type: taskType, Status: common.TaskStatusSuccess}
+	if err := db.CreateTask(task); err != nil {
+		t.Fatalf("CreateTask failed: %v", err)
+	}
+	for i := 0; i < lines; i++ {
+		err := m.SaveLog(&common.TaskLogData{TaskID: id, Level: "info", Message: fmt.Sprintf("line %d", i), Timestamp: time.Now().Unix()})
+		if err != nil {
+			t.Fatalf("SaveLog failed: %v", err)
+		}
+	}
+	err := db.GetDB().Model(&common.Task{}).Where("id = ?", id).UpdateColumn("updated_at", time.Now().Add(-age)).Error
+	if err != nil {
+		t.Fatalf("failed to set updated_at: %v", err)
+	}
+}
+
+func TestCompactLogsArchivesAndServesTransparently(t *testing.T) {
+	m, db, _ := newTestManager(t)
+	m.logRetention = &LogRetentionConfig{Default: LogPolicy{ArchiveAfter: time.Hour}}
+
+	createFinishedTask(t, m, db, "old", common.TaskTypeShell, "agent-1", 5, 2*time.Hour)
+	createFinishedTask(t, m, db, "recent", common.TaskTypeShell, "agent-1", 3, time.Minute)
+
+	task, _ := db.GetTask("old")
+	if task.LogLines != 5 {
+		t.Errorf("log_lines = %d, want 5", task.LogLines)
+	}
+
+	result, err := m.CompactLogs(context.Background())
+	if err != nil {
+		t.Fatalf("CompactLogs failed: %v", err)
+	}
+	if result.ArchivedTasks != 1 || result.ArchivedLines != 5 {
+		t.Errorf("CompactLogs = %+v", result)
+	}
+
+	task, _ = db.GetTask("old")
+	if task.LogState != common.TaskLogStateArchived || task.LogArchive == "" || task.LogLines != 5 {
+		t.Errorf("archived task = %+v", task)
+	}
+	if rows, _ := db.GetTaskLogs("old", 100); len(rows) != 0 {
+		t.Errorf("archived logs should be removed from database, got %d rows", len(rows))
+	}
+	if rows, _ := db.GetTaskLogs("recent", 100); len(rows) != 3 {
+		t.Errorf("recent task logs should stay in database, got %d rows", len(rows))
+	}
+
+	// 归档后写入的日志追加在归档之后
+	m.SaveLog(&common.TaskLogData{TaskID: "old", Level: "info", Message: "late", Timestamp: time.Now().Unix()})
+
+	logs, err := m.GetTaskLogs("old", 100)
+	if err != nil {
+		t.Fatalf("GetTaskLogs failed: %v", err)
+	}
+	if len(logs) != 6 || logs[0].Message != "line 0" || logs[5].Message != "late" {
+		t.Errorf("GetTaskLogs returned %d logs: %+v", len(logs), logs)
+	}
+	if limited, _ := m.GetTaskLogs("old", 2); len(limited) != 2 {
+		t.Errorf("GetTaskLogs limit = %d, want 2", len(limited))
+	}
+
+	// 重复执行不会重复归档
+	if result, _ := m.CompactLogs(context.Background()); result.ArchivedTasks != 0 {
+		t.Errorf("second CompactLogs = %+v", result)
+	}
+}
+
+func TestCompactLogsPoliciesByTypeAndEnv(t *testing.T) {
+	m, db, _ := newTestManager(t)
+	m.logRetention = &LogRetentionConfig{
+		Default: LogPolicy{ArchiveAfter: 48 * time.Hour},
+		Types:   map[common.TaskType]LogPolicy{common.TaskTypeShell: {ArchiveAfter: time.Hour}},
+		Envs:    map[string]LogPolicy{"dev": {DeleteAfter: time.Hour}},
+	}
+	db.CreateAgent(&common.Agent{ID: "dev-agent", Name: "dev-agent", Env: "dev"})
+
+	createFinishedTask(t, m, db, "shell", common.TaskTypeShell, "agent-1", 2, 2*time.Hour)
+	createFinishedTask(t, m, db, "mysql", common.TaskTypeMySQL, "agent-1", 2, 2*time.Hour)
+	createFinishedTask(t, m, db, "dev", common.TaskTypeShell, "dev-agent", 2, 2*time.Hour)
+
+	result, err := m.CompactLogs(context.Background())
+	if err != nil {
+		t.Fatalf("CompactLogs failed: %v", err)
+	}
+	if result.ArchivedTasks != 1 || result.PurgedTasks != 1 {
+		t.Errorf("CompactLogs = %+v", result)
+	}
+
+	states := map[string]string{"shell": common.TaskLogStateArchived, "mysql": "", "dev": common.TaskLogStatePurged}
+	for id, want := range states {
+		task, _ := db.GetTask(id)
+		if task.LogState != want {
+			t.Errorf("task %s log_state = %q, want %q", id, task.LogState, want)
+		}
+	}
+	if logs, _ := m.GetTaskLogs("dev", 100); len(logs) != 0 {
+		t.Errorf("purged task should have no logs, got %d", len(logs))
+	}
+}
+
+func TestLoadLogRetentionConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "retention.yaml")
+	os.WriteFile(path, []byte("default:\n  archive_after: 24h\ntypes:\n  shell:\n    delete_after: 720h\n"), 0644)
+
+	cfg, err := LoadLogRetentionConfig(path)
+	if err != nil {
+		t.Fatalf("LoadLogRetentionConfig failed: %v", err)
+	}
+	if cfg.Interval != 10*time.Minute {
+		t.Errorf("interval should keep default, got %v", cfg.Interval)
+	}
+	if cfg.Default.ArchiveAfter != 24*time.Hour {
+		t.Errorf("default archive_after = %v", cfg.Default.ArchiveAfter)
+	}
+	if p := cfg.PolicyFor(common.TaskTypeShell, ""); p.DeleteAfter != 720*time.Hour {
+		t.Errorf("shell policy = %+v", p)
+	}
+}
+
+func TestSaveLogBatchesClusterBroadcast(t *testing.T) {
+	m, db, _ := newTestManager(t)
+	if err := db.CreateTask(&common.Task{ID: "t1", AgentID: "agent-1", Type: common.TaskTypeShell, Status: common.TaskStatusRunning}); err != nil {
+		t.Fatalf("CreateTask failed: %v", err)
+	}
+	envelopes := make(chan *cluster.Envelope, remoteLogBatchSize)
+	unsubscribe, err := m.cluster.Bus.Subscribe(cluster.TopicBroadcast, func(env *cluster.Envelope) { envelopes <- env })
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	defer unsubscribe()
+	receive := func() []*common.TaskLogData {
+		t.Helper()
+		select {
+		case env := <-envelopes:
+			var batch []*common.TaskLogData
+			if env.Kind != cluster.KindTaskLogs || env.DecodeData(&batch) != nil {
+				t.Fatalf("unexpected envelope %+v", env)
+			}
+			return batch
+		case <-time.After(5 * time.Second):
+			t.Fatal("log batch was not published")
+			return nil
+		}
+	}
+
+	// 多行日志合并为一条集群消息
+	for i := range 3 {
+		m.SaveLog(&common.TaskLogData{TaskID: "t1", Level: "info", Message: fmt.Sprintf("line %d", i)})
+	}
+	batch := receive()
+	if len(batch) != 3 || batch[0].Message != "line 0" || batch[2].Message != "line 2" {
+		t.Fatalf("batch = %+v, want 3 lines in order", batch)
+	}
+
+	// 大量日志按批发布，顺序不变
+	const lines = 2 * remoteLogBatchSize
+	for i := range lines {
+		m.SaveLog(&common.TaskLogData{TaskID: "t1", Level: "info", Message: fmt.Sprintf("line %d", i)})
+	}
+	received, batches := 0, 0
+	for received < lines {
+		for _, logData := range receive() {
+			if want := fmt.Sprintf("line %d", received); logData.Message != want {
+				t.Fatalf("log %d = %q, want %q", received, logData.Message, want)
+			}
+			received++
+		}
+		batches++
+	}
+	if batches > lines/10 {
+		t.Errorf("%d lines were published in %d messages", lines, batches)
+	}
+}
@@ -0,0 +1,129 @@
+package task
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strconv"
+
+	"github.com/cloud-agent/internal/cloud/storage"
+	"github.com/cloud-agent/internal/common"
+)
+
+// maxArchiveScan 一次搜索最多读取的日志归档数
+const maxArchiveScan = 100
+
+// LogSearchResult 日志搜索结果
+type LogSearchResult struct {
+	*storage.LogSearchResult
+	ArchivesScanned   int  `json:"archives_scanned"`   // 读取的日志归档数
+	ArchivesTruncated bool `json:"archives_truncated"` // 符合条件的归档超过上限，部分归档未搜索
+}
+
+// SearchLogs 搜索任务日志
+// includeArchived 为 true 时同时搜索已归档到存储后端的日志（按任务结束时间倒序最多读取 maxArchiveScan 个归档）
+func (m *Manager) SearchLogs(ctx context.Context, q *storage.LogSearchQuery, includeArchived bool) (*LogSearchResult, error) {
+	dbResult, err := m.db.SearchLogs(q)
+	if err != nil {
+		return nil, err
+	}
+	result := &LogSearchResult{LogSearchResult: dbResult}
+	if !includeArchived {
+		return result, nil
+	}
+
+	tasks, err := m.db.ListArchivedLogTasks(q, maxArchiveScan+1)
+	if err != nil {
+		return nil, err
+	}
+	if len(tasks) > maxArchiveScan {
+		tasks = tasks[:maxArchiveScan]
+		result.ArchivesTruncated = true
+	}
+
+	terms := storage.SearchTerms(q.Query)
+	levels := facetCounts(result.Facets.Levels)
+	taskCounts := facetCounts(result.Facets.Tasks)
+	agents := facetCounts(result.Facets.Agents)
+	var hits []*storage.LogSearchHit
+	for _, task := range tasks {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		reader, _, err := m.store.Open(ctx, task.LogArchive)
+		if err != nil {
+			log.Printf("[log-search] failed to open log archive of task %s: %v", task.ID, err)
+			continue
+		}
+		err = scanLogArchive(reader, func(entry *common.Log) bool {
+			if !q.Matches(entry) {
+				return true
+			}
+			result.Total++
+			levels[entry.Level]++
+			taskCounts[task.ID]++
+			agents[task.AgentID]++
+			hits = append(hits, &storage.LogSearchHit{
+				ID:        entry.ID,
+				TaskID:    task.ID,
+				AgentID:   task.AgentID,
+				Level:     entry.Level,
+				Timestamp: entry.Timestamp,
+				Snippet:   storage.HighlightSnippet(entry.Message, terms),
+				Archived:  true,
+			})
+			return true
+		})
+		reader.Close()
+		if err != nil {
+			log.Printf("[log-search] failed to read log archive of task %s: %v", task.ID, err)
+		}
+		result.ArchivesScanned++
+	}
+
+	result.Facets.Levels = topFacets(levels, q.FacetSize())
+	result.Facets.Tasks = topFacets(taskCounts, q.FacetSize())
+	result.Facets.Agents = topFacets(agents, q.FacetSize())
+
+	// 日志 ID 全局递增且归档时保留，合并后按 ID 倒序分页
+	limit := q.PageSize()
+	merged := append(dbResult.Hits, hits...)
+	sort.Slice(merged, func(i, j int) bool { return merged[i].ID > merged[j].ID })
+	more := dbResult.NextCursor != "" || len(merged) > limit
+	if len(merged) > limit {
+		merged = merged[:limit]
+	}
+	result.Hits = merged
+	result.NextCursor = ""
+	if more {
+		result.NextCursor = strconv.FormatUint(uint64(merged[len(merged)-1].ID), 10)
+	}
+	return result, nil
+}
+
+// facetCounts 将分面列表转换为计数表
+func facetCounts(facets []storage.LogFacet) map[string]int64 {
+	counts := make(map[string]int64, len(facets))
+	for _, f := range facets {
+		counts[f.Value] = f.Count
+	}
+	return counts
+}
+
+// topFacets 返回计数最多的 limit 个分面条目
+func topFacets(counts map[string]int64, limit int) []storage.LogFacet {
+	facets := make([]storage.LogFacet, 0, len(counts))
+	for value, count := range counts {
+		facets = append(facets, storage.LogFacet{Value: value, Count: count})
+	}
+	sort.Slice(facets, func(i, j int) bool {
+		if facets[i].Count != facets[j].Count {
+			return facets[i].Count > facets[j].Count
+		}
+		return facets[i].Value < facets[j].Value
+	})
+	if len(facets) > limit {
+		facets = facets[:limit]
+	}
+	return facets
+}
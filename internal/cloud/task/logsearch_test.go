@@ -0,0 +1,59 @@
+package task
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cloud-agent/internal/cloud/storage"
+	"github.com/cloud-agent/internal/common"
+)
+
+func TestSearchLogsIncludesArchives(t *testing.T) {
+	m, db, _ := newTestManager(t)
+	m.logRetention = &LogRetentionConfig{Default: LogPolicy{ArchiveAfter: time.Hour}}
+
+	createFinishedTask(t, m, db, "old", common.TaskTypeShell, "agent-1", 3, 2*time.Hour)
+	m.SaveLog(&common.TaskLogData{TaskID: "old", Level: "error", Message: "worker OOMKilled", Timestamp: time.Now().Unix()})
+	db.GetDB().Model(&common.Task{}).Where("id = ?", "old").UpdateColumn("updated_at", time.Now().Add(-2*time.Hour))
+	if _, err := m.CompactLogs(context.Background()); err != nil {
+		t.Fatalf("CompactLogs failed: %v", err)
+	}
+	createFinishedTask(t, m, db, "new", common.TaskTypeShell, "agent-2", 0, 0)
+	m.SaveLog(&common.TaskLogData{TaskID: "new", Level: "error", Message: "api OOMKilled again", Timestamp: time.Now().Unix()})
+
+	q := &storage.LogSearchQuery{Query: "oomkilled"}
+	result, err := m.SearchLogs(context.Background(), q, false)
+	if err != nil {
+		t.Fatalf("SearchLogs failed: %v", err)
+	}
+	if result.Total != 1 || result.Hits[0].TaskID != "new" {
+		t.Errorf("database only search = %+v", result.Hits)
+	}
+
+	result, err = m.SearchLogs(context.Background(), q, true)
+	if err != nil {
+		t.Fatalf("SearchLogs failed: %v", err)
+	}
+	if result.Total != 2 || len(result.Hits) != 2 || result.ArchivesScanned != 1 {
+		t.Fatalf("archive search = %+v", result)
+	}
+	if result.Hits[0].TaskID != "new" || result.Hits[1].TaskID != "old" || !result.Hits[1].Archived {
+		t.Errorf("hits should be ordered by id with archived flag: %+v, %+v", result.Hits[0], result.Hits[1])
+	}
+	if len(result.Facets.Agents) != 2 || len(result.Facets.Tasks) != 2 {
+		t.Errorf("facets = %+v", result.Facets)
+	}
+
+	// 分页：第一页只返回数据库中的日志，第二页从归档中返回
+	q.Limit = 1
+	page, _ := m.SearchLogs(context.Background(), q, true)
+	if len(page.Hits) != 1 || page.NextCursor == "" {
+		t.Fatalf("first page = %+v", page)
+	}
+	q.BeforeID = page.Hits[0].ID
+	page, _ = m.SearchLogs(context.Background(), q, true)
+	if len(page.Hits) != 1 || page.Hits[0].TaskID != "old" || page.NextCursor != "" {
+		t.Errorf("second page = %+v", page)
+	}
+}
@@ -1,44 +1,149 @@
 package task
 
 import (
-	"crypto/md5"
-	"encoding/hex"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"mime/multipart"
-	"os"
-	"path/filepath"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/cloud-agent/internal/cloud/agent"
+	"github.com/cloud-agent/internal/cloud/audit"
+	"github.com/cloud-agent/internal/cloud/cluster"
+	"github.com/cloud-agent/internal/cloud/filestore"
+	"github.com/cloud-agent/internal/cloud/metrics"
+	"github.com/cloud-agent/internal/cloud/notify"
 	"github.com/cloud-agent/internal/cloud/storage"
 	"github.com/cloud-agent/internal/common"
+	"github.com/cloud-agent/internal/tracing"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"gorm.io/gorm"
 )
 
 // Manager 任务管理器
 type Manager struct {
 	db       *storage.Database
 	agentMgr *agent.Manager
+	cluster  *cluster.Cluster
+	store    filestore.FileStore
 	// 日志订阅：taskID -> []*common.WSConnection
 	logSubscribers map[string][]*common.WSConnection
 	// 同步等待：taskID -> chan *common.Task
 	waitChannels map[string]chan *common.Task
 	mu           sync.RWMutex
+	unsubscribe  func()
+	// 文件生命周期
+	lifecycle *FileLifecycleConfig
+	// 日志保留策略
+	logRetention *LogRetentionConfig
+	metrics      *metrics.Metrics
+	auditor      *audit.Recorder
+	notifier     *notify.Notifier
+	inFlight     *InFlightConfig // Agent 离线时的在途任务处理策略
+	redactor     *common.Redactor // 保存日志、结果和参数前的第二次脱敏
+	policy       *common.PolicyEngine // 下发前评估的任务策略，nil 时允许所有任务
+	schedMu      sync.Mutex
+	roundRobin   map[string]uint64 // 轮询调度的位置，按调度键记录
+	// 等待批量广播给其他副本的任务日志
+	logBatchMu    sync.Mutex
+	logBatch      []*common.TaskLogData
+	logBatchTimer *time.Timer
+	logFlushMu    sync.Mutex // 保证批次按顺序发布
+	stopCh       chan struct{}
+	stopOnce     sync.Once
 }
 
-// NewManager 创建任务管理器
-func NewManager(db *storage.Database, agentMgr *agent.Manager) *Manager {
-	return &Manager{
+// SetMetrics 设置指标收集器，为 nil 时不记录指标
+func (m *Manager) SetMetrics(mt *metrics.Metrics) {
+	m.metrics = mt
+}
+
+// SetAuditor 设置审计记录器，为 nil 时不记录审计事件
+func (m *Manager) SetAuditor(r *audit.Recorder) {
+	m.auditor = r
+}
+
+// SetNotifier 设置通知器，为 nil 时不发送通知
+func (m *Manager) SetNotifier(n *notify.Notifier) {
+	m.notifier = n
+}
+
+// NewManager 创建任务管理器，cl 为 nil 时使用单副本集群，store 为上传文件的存储后端
+func NewManager(db *storage.Database, agentMgr *agent.Manager, cl *cluster.Cluster, store filestore.FileStore) *Manager {
+	if cl == nil {
+		cl = cluster.NewLocal("")
+	}
+
+	m := &Manager{
 		db:             db,
 		agentMgr:       agentMgr,
+		cluster:        cl,
+		store:          store,
+		lifecycle:      DefaultFileLifecycleConfig(),
+		logRetention:   DefaultLogRetentionConfig(),
+		inFlight:       DefaultInFlightConfig(),
+		redactor:       common.DefaultRedactor(),
+		stopCh:         make(chan struct{}),
 		logSubscribers: make(map[string][]*common.WSConnection),
 		waitChannels:   make(map[string]chan *common.Task),
 	}
+
+	// 订阅广播主题：其他副本收到的任务日志和完成通知需要推送给本副本的订阅者和同步等待者
+	unsubscribe, err := cl.Bus.Subscribe(cluster.TopicBroadcast, m.handleEnvelope)
+	if err != nil {
+		log.Printf("[cluster] failed to subscribe broadcast topic: %v", err)
+	} else {
+		m.unsubscribe = unsubscribe
+	}
+
+	return m
+}
+
+// handleEnvelope 处理其他副本广播的任务事件
+func (m *Manager) handleEnvelope(env *cluster.Envelope) {
+	if env.Source == m.cluster.ReplicaID {
+		return
+	}
+
+	switch env.Kind {
+	case cluster.KindTaskLog:
+		// 兼容滚动升级期间旧版本副本逐条广播的日志
+		var logData common.TaskLogData
+		if err := env.DecodeData(&logData); err != nil {
+			return
+		}
+		m.broadcastLog(&logData)
+	case cluster.KindTaskLogs:
+		var batch []*common.TaskLogData
+		if err := env.DecodeData(&batch); err != nil {
+			return
+		}
+		for _, logData := range batch {
+			m.broadcastLog(logData)
+		}
+	case cluster.KindTaskComplete:
+		m.notifyWaiter(env.TaskID)
+	}
+}
+
+// Close 停止接收集群消息、后台文件回收和日志压缩，并发布尚未广播的日志
+func (m *Manager) Close() {
+	if m.unsubscribe != nil {
+		m.unsubscribe()
+	}
+	m.flushRemoteLogs()
+	m.stopOnce.Do(func() { close(m.stopCh) })
+}
+
+// TaskOptions 任务附加信息
+type TaskOptions struct {
+	Tags      []string // 任务标签，用于检索
+	CreatedBy string   // 创建者
 }
 
 // CreateTask 创建任务
@@ -46,25 +151,57 @@ func NewManager(db *storage.Database, agentMgr *agent.Manager) *Manager {
 // sync: 是否同步等待任务完成，默认 false（异步）
 // timeout: 同步模式超时时间（秒），默认 60
 func (m *Manager) CreateTask(agentID string, taskType common.TaskType, command string, params map[string]interface{}, fileID string, sync bool, timeout int) (*common.Task, error) {
-	// Check if Agent is online
-	_, exists := m.agentMgr.GetConnection(agentID)
-	if !exists {
+	return m.CreateTaskWithOptions(context.Background(), agentID, taskType, command, params, fileID, sync, timeout, nil)
+}
+
+// CreateTaskWithOptions 创建任务并记录标签和创建者
+// ctx 中的链路上下文会随任务下发到 Agent
+func (m *Manager) CreateTaskWithOptions(ctx context.Context, agentID string, taskType common.TaskType, command string, params map[string]interface{}, fileID string, sync bool, timeout int, opts *TaskOptions) (created *common.Task, err error) {
+	ctx, span := tracing.Start(ctx, "task.create",
+		attribute.String("agent.id", agentID),
+		attribute.String("task.type", string(taskType)),
+		attribute.Bool("task.sync", sync))
+	defer func() { tracing.End(span, err) }()
+
+	// Check if Agent is online (on this replica or any other replica)
+	if !m.agentMgr.IsOnline(agentID) {
 		return nil, common.NewError("agent not online")
 	}
+	if err := m.checkCapability(agentID, taskType, params); err != nil {
+		return nil, err
+	}
+
+	// 按任务策略评估，拒绝的任务不创建，要求审批的任务保存后等待审批
+	createdBy := ""
+	if opts != nil {
+		createdBy = opts.CreatedBy
+	}
+	policyInput := m.PolicyInput(agentID, taskType, command, params, createdBy)
+	decision := m.policy.Evaluate(policyInput)
+	if decision.Effect == common.PolicyEffectDeny {
+		m.auditor.PolicyDenied(agentID, createdBy, taskType, command, describeDecision(decision))
+		return nil, fmt.Errorf("%w: %s", ErrPolicyDenied, describeDecision(decision))
+	}
+	needsApproval := decision.Effect == common.PolicyEffectRequireApproval
 
 	taskID := uuid.New().String()
+	span.SetAttributes(attribute.String("task.id", taskID))
 
 	// If fileID is provided, get file information and add to params BEFORE serialization
+	var taskFile *common.File
 	if fileID != "" {
 		file, err := m.db.GetFile(fileID)
 		if err == nil {
+			taskFile = file
 			// Ensure params is not nil
 			if params == nil {
 				params = make(map[string]interface{})
 			}
-			// Add file path and file name information
-			params["file_path"] = file.Path
+			// Add file name information（file_path 在下发时填充，不保存）
 			params["file_name"] = file.Name
+			if file.SHA256 != "" {
+				params["file_sha256"] = file.SHA256
+			}
 		}
 	}
 
@@ -76,7 +213,7 @@ func (m *Manager) CreateTask(agentID string, taskType common.TaskType, command s
 	if sync {
 		params["_timeout"] = timeout
 	}
-	
+
 	// 调试日志：确保 _sync 被正确添加
 	if sync {
 		log.Printf("[DEBUG] Task %s: Added _sync=true, _timeout=%d to params", taskID, timeout)
@@ -84,10 +221,10 @@ func (m *Manager) CreateTask(agentID string, taskType common.TaskType, command s
 		log.Printf("[DEBUG] Task %s: Added _sync=false to params", taskID)
 	}
 
-	// Serialize parameters
+	// Serialize parameters（敏感字段的明文值和匹配脱敏规则的值脱敏后保存，下发给 Agent 的仍是原始参数）
 	paramsJSON := ""
 	if params != nil {
-		paramsBytes, err := json.Marshal(params)
+		paramsBytes, err := json.Marshal(m.redactor.RedactValues(common.RedactParams(params)))
 		if err != nil {
 			log.Printf("[ERROR] Failed to marshal params: %v", err)
 		} else {
@@ -105,12 +242,40 @@ func (m *Manager) CreateTask(agentID string, taskType common.TaskType, command s
 		Params:  paramsJSON,
 		FileID:  fileID,
 	}
-	
+	if opts != nil {
+		task.Tags = opts.Tags
+		task.CreatedBy = opts.CreatedBy
+	}
+	if needsApproval {
+		// 审批通过后根据保存的参数下发，明文密钥脱敏后无法恢复
+		if paramsRedacted(paramsJSON) {
+			return nil, ErrApprovalParams
+		}
+		task.Status = common.TaskStatusPendingApproval
+		task.PolicyRule = decision.Rule
+	}
+
 	log.Printf("[DEBUG] Task %s: Created task with Params field: %s", taskID, task.Params)
 
 	if err := m.db.CreateTask(task); err != nil {
 		return nil, err
 	}
+	m.metrics.TaskCreated(taskType)
+	m.auditor.TaskSubmitted(task)
+
+	// 记录任务使用的文件，删除文件时据此检查引用
+	if taskFile != nil {
+		if err := m.db.CreateTaskFile(taskID, fileID); err != nil {
+			log.Printf("Failed to record file %s for task %s: %v", fileID, taskID, err)
+		}
+	}
+
+	// 等待审批，不下发也不等待完成
+	if needsApproval {
+		m.notifier.ApprovalPending(task)
+		m.taskLog(taskID, "warn", "waiting for approval required by "+describeDecision(decision))
+		return task, nil
+	}
 
 	// 如果是同步模式，创建等待 channel
 	var waitChan chan *common.Task
@@ -123,17 +288,25 @@ func (m *Manager) CreateTask(agentID string, taskType common.TaskType, command s
 
 	// 发送任务到 Agent
 	taskData := common.TaskCreateData{
-		TaskID:  taskID,
-		Type:    taskType,
-		Command: command,
-		FileID:  fileID,
+		TaskID:    taskID,
+		Type:      taskType,
+		Command:   command,
+		FileID:    fileID,
+		CreatedBy: createdBy,
+		AgentTags: policyInput.Tags,
 	}
 	if params != nil {
-		taskData.Params = params
+		taskData.Params = m.withFileLocation(params, taskFile)
 	}
 
+	dispatchCtx, dispatchSpan := tracing.Start(ctx, "task.dispatch", attribute.String("task.id", taskID))
+	taskData.TraceContext = tracing.Inject(dispatchCtx)
 	msg := common.NewMessage(common.MessageTypeTaskCreate, taskData)
-	if err := m.agentMgr.SendMessage(agentID, msg); err != nil {
+	// 发送前更新为运行中：Agent 可能在发送返回前上报结果，结果只对运行中的任务生效
+	m.db.UpdateTaskStatus(taskID, common.TaskStatusRunning)
+	err = m.agentMgr.SendMessage(agentID, msg)
+	tracing.End(dispatchSpan, err)
+	if err != nil {
 		// 如果发送失败，清理等待 channel
 		if sync {
 			m.mu.Lock()
@@ -145,9 +318,6 @@ func (m *Manager) CreateTask(agentID string, taskType common.TaskType, command s
 		return nil, fmt.Errorf("failed to send task to agent: %w", err)
 	}
 
-	// 更新任务状态为运行中
-	m.db.UpdateTaskStatus(taskID, common.TaskStatusRunning)
-
 	// 如果是异步模式，立即返回
 	if !sync {
 		return task, nil
@@ -155,7 +325,7 @@ func (m *Manager) CreateTask(agentID string, taskType common.TaskType, command s
 
 	// 同步模式：等待任务完成或超时
 	select {
-	case completedTask := <-waitChan:
+	case <-waitChan:
 		// 清理等待 channel
 		m.mu.Lock()
 		delete(m.waitChannels, taskID)
@@ -163,7 +333,7 @@ func (m *Manager) CreateTask(agentID string, taskType common.TaskType, command s
 		// 从数据库获取最新状态
 		latestTask, err := m.db.GetTask(taskID)
 		if err != nil {
-			return completedTask, nil
+			return task, nil
 		}
 		return latestTask, nil
 	case <-time.After(time.Duration(timeout) * time.Second):
@@ -180,35 +350,70 @@ func (m *Manager) CreateTask(agentID string, taskType common.TaskType, command s
 	}
 }
 
-// CompleteTask 完成任务
+// CompleteTask 完成 Agent 上报结果的任务
+// 只接受运行中的任务：已结束（已取消、因 Agent 离线标记为失败等）或已重新排队等待下发的任务忽略迟到的结果
 func (m *Manager) CompleteTask(data *common.TaskCompleteData) error {
+	claimed, err := m.db.CompareAndSetTaskStatus(data.TaskID, common.TaskStatusRunning, data.Status)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		log.Printf("Ignoring result of task %s: task is not running", data.TaskID)
+		return nil
+	}
+	return m.finishTask(data)
+}
+
+// finishTask 保存已变更为结束状态的任务结果，并更新指标、审计、通知和同步等待
+func (m *Manager) finishTask(data *common.TaskCompleteData) error {
 	task, err := m.db.GetTask(data.TaskID)
 	if err != nil {
 		return err
 	}
 
+	// Agent 上报前已脱敏，这里按 Cloud 的规则再处理一次
 	task.Status = data.Status
-	task.Result = data.Result
-	task.Error = data.Error
+	task.Result = m.redactor.Redact(data.Result)
+	task.Error = m.redactor.Redact(data.Error)
 
 	if err := m.db.UpdateTask(task); err != nil {
 		return err
 	}
+	m.metrics.TaskCompleted(task)
+	m.auditor.TaskCompleted(task)
+	m.notifier.TaskFinished(task)
+
+	if task.Type == common.TaskTypeFile {
+		if err := m.db.UpdateFileDistributionStatus(task.ID, data.Status, task.Error); err != nil {
+			log.Printf("Failed to update distribution status of task %s: %v", task.ID, err)
+		}
+	}
 
-	// 通知等待的 goroutine（同步模式）
+	m.wakeWaiters(data.TaskID)
+	return nil
+}
+
+// wakeWaiters 通知等待任务完成的同步请求，同步请求可能由其他副本发起
+func (m *Manager) wakeWaiters(taskID string) {
+	m.notifyWaiter(taskID)
+	if err := m.cluster.PublishBroadcast(cluster.KindTaskComplete, taskID, nil); err != nil {
+		log.Printf("[cluster] failed to publish completion of task %s: %v", taskID, err)
+	}
+}
+
+// notifyWaiter 唤醒等待任务完成的同步请求
+func (m *Manager) notifyWaiter(taskID string) {
 	m.mu.RLock()
-	waitChan, exists := m.waitChannels[data.TaskID]
+	waitChan, exists := m.waitChannels[taskID]
 	m.mu.RUnlock()
 
 	if exists {
-		// 非阻塞发送，避免阻塞
+		// 非阻塞发送，避免阻塞；等待方会从数据库读取最新状态
 		select {
-		case waitChan <- task:
+		case waitChan <- nil:
 		default:
 		}
 	}
-
-	return nil
 }
 
 // CancelTask 取消任务
@@ -218,24 +423,47 @@ func (m *Manager) CancelTask(taskID string) error {
 		return err
 	}
 
-	if task.Status != common.TaskStatusPending && task.Status != common.TaskStatusRunning {
+	if task.Status != common.TaskStatusPending && task.Status != common.TaskStatusRunning && task.Status != common.TaskStatusPendingApproval {
 		return common.NewError("task cannot be canceled")
 	}
 
-	// 发送取消消息到 Agent
-	msg := common.NewMessage(common.MessageTypeTaskCancel, map[string]interface{}{
-		"task_id": taskID,
-	})
-	if err := m.agentMgr.SendMessage(task.AgentID, msg); err != nil {
-		// 即使发送失败，也更新状态
+	// 与审批、Agent 上报结果竞争时只有一方成功：任务已被审批下发或已结束时不再取消
+	claimed, err := m.db.CompareAndSetTaskStatus(taskID, task.Status, common.TaskStatusCanceled)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return common.NewError("task cannot be canceled: status changed, please retry")
+	}
+
+	// 等待审批的任务还没有下发给 Agent
+	if task.Status != common.TaskStatusPendingApproval {
+		msg := common.NewMessage(common.MessageTypeTaskCancel, map[string]interface{}{
+			"task_id": taskID,
+		})
+		if err := m.agentMgr.SendMessage(task.AgentID, msg); err != nil {
+			// 即使发送失败，状态也已更新为取消
+			log.Printf("Failed to send cancel of task %s to agent %s: %v", taskID, task.AgentID, err)
+		}
+	}
+
+	if task.Type == common.TaskTypeFile {
+		m.db.UpdateFileDistributionStatus(taskID, common.TaskStatusCanceled, "")
 	}
 
-	return m.db.UpdateTaskStatus(taskID, common.TaskStatusCanceled)
+	task.Status = common.TaskStatusCanceled
+	m.metrics.TaskCompleted(task)
+	m.auditor.TaskCanceled(task)
+	m.notifier.TaskFinished(task)
+	// Agent 随后上报的取消结果会被忽略，这里直接唤醒同步请求
+	m.wakeWaiters(taskID)
+	return nil
 }
 
-// SaveLog 保存日志
+// SaveLog 保存日志，保存和推送前按脱敏规则处理
 func (m *Manager) SaveLog(logData *common.TaskLogData) error {
-	log := &common.Log{
+	logData.Message = m.redactor.Redact(logData.Message)
+	entry := &common.Log{
 		TaskID:    logData.TaskID,
 		Level:     logData.Level,
 		Message:   logData.Message,
@@ -243,16 +471,63 @@ func (m *Manager) SaveLog(logData *common.TaskLogData) error {
 	}
 
 	// 保存到数据库
-	if err := m.db.CreateLog(log); err != nil {
+	if err := m.db.CreateLog(entry); err != nil {
 		return err
 	}
+	if err := m.db.IncrementTaskLogLines(logData.TaskID); err != nil {
+		log.Printf("Failed to update log line counter of task %s: %v", logData.TaskID, err)
+	}
 
-	// 推送给所有订阅者
+	// 推送给所有订阅者（本副本直接推送，其他副本通过批量广播推送）
 	m.broadcastLog(logData)
+	m.queueRemoteLog(logData)
 
 	return nil
 }
 
+// 广播给其他副本的日志按批合并为一条集群消息，避免每行日志都写一次消息总线
+const (
+	remoteLogBatchInterval = 200 * time.Millisecond // 第一行日志进入批次后最多等待的时间
+	remoteLogBatchSize     = 500                    // 批次达到该行数时立即发布
+)
+
+// queueRemoteLog 将日志加入待广播批次
+func (m *Manager) queueRemoteLog(logData *common.TaskLogData) {
+	m.logBatchMu.Lock()
+	m.logBatch = append(m.logBatch, logData)
+	full := len(m.logBatch) >= remoteLogBatchSize
+	if !full && m.logBatchTimer == nil {
+		m.logBatchTimer = time.AfterFunc(remoteLogBatchInterval, m.flushRemoteLogs)
+	}
+	m.logBatchMu.Unlock()
+
+	if full {
+		m.flushRemoteLogs()
+	}
+}
+
+// flushRemoteLogs 将当前批次的日志广播给其他副本
+func (m *Manager) flushRemoteLogs() {
+	m.logFlushMu.Lock()
+	defer m.logFlushMu.Unlock()
+
+	m.logBatchMu.Lock()
+	batch := m.logBatch
+	m.logBatch = nil
+	if m.logBatchTimer != nil {
+		m.logBatchTimer.Stop()
+		m.logBatchTimer = nil
+	}
+	m.logBatchMu.Unlock()
+
+	if len(batch) == 0 {
+		return
+	}
+	if err := m.cluster.PublishBroadcast(cluster.KindTaskLogs, "", batch); err != nil {
+		log.Printf("[cluster] failed to publish %d task logs: %v", len(batch), err)
+	}
+}
+
 // SubscribeLogs 订阅任务日志
 func (m *Manager) SubscribeLogs(taskID string, conn *common.WSConnection) {
 	m.mu.Lock()
@@ -328,7 +603,7 @@ func (m *Manager) broadcastLog(logData *common.TaskLogData) {
 }
 
 // UploadFile 上传文件
-func (m *Manager) UploadFile(fileHeader *multipart.FileHeader, storagePath string) (*common.File, error) {
+func (m *Manager) UploadFile(fileHeader *multipart.FileHeader, opts *UploadOptions) (*common.File, error) {
 	// 打开上传的文件
 	src, err := fileHeader.Open()
 	if err != nil {
@@ -336,109 +611,138 @@ func (m *Manager) UploadFile(fileHeader *multipart.FileHeader, storagePath strin
 	}
 	defer src.Close()
 
-	// 计算 MD5
-	hash := md5.New()
-	if _, err := io.Copy(hash, src); err != nil {
-		return nil, err
-	}
-	md5Sum := hex.EncodeToString(hash.Sum(nil))
+	return m.SaveFile(src, fileHeader.Filename, fileHeader.Header.Get("Content-Type"), fileHeader.Size, opts)
+}
 
-	// 检查文件是否已存在
-	existingFile, err := m.db.GetFileByMD5(md5Sum)
-	if err == nil {
-		// 文件已存在，返回现有记录
-		return existingFile, nil
+// SaveFile 保存文件到存储后端并创建文件记录，size 为 -1 表示未知大小
+// 文件只读取一次：写入存储后端的同时计算 MD5 和 SHA-256
+func (m *Manager) SaveFile(r io.Reader, name string, contentType string, size int64, opts *UploadOptions) (*common.File, error) {
+	if opts == nil {
+		opts = &UploadOptions{}
 	}
 
-	// 重置文件指针
-	src.Seek(0, 0)
-
-	// 生成文件ID，但使用原始文件名存储
+	// 生成文件ID，对象 key 中保留原始文件名（已清理路径分隔符等不安全字符）
 	fileID := uuid.New().String()
-	// 使用原始文件名，但需要清理文件名中的路径分隔符等不安全字符
-	originalFileName := fileHeader.Filename
-	// 清理文件名：移除路径分隔符，防止路径遍历攻击
-	originalFileName = strings.ReplaceAll(originalFileName, "/", "_")
-	originalFileName = strings.ReplaceAll(originalFileName, "\\", "_")
-	originalFileName = strings.ReplaceAll(originalFileName, "..", "_")
-
-	// 如果文件名已存在，添加文件ID前缀避免冲突
-	filePath := filepath.Join(storagePath, originalFileName)
-	if _, err := os.Stat(filePath); err == nil {
-		// 文件已存在，使用 fileID-原文件名 格式
-		ext := filepath.Ext(originalFileName)
-		nameWithoutExt := strings.TrimSuffix(originalFileName, ext)
-		originalFileName = fmt.Sprintf("%s-%s%s", nameWithoutExt, fileID[:8], ext)
-		filePath = filepath.Join(storagePath, originalFileName)
-	}
-
-	// 创建目标文件
-	dst, err := os.Create(filePath)
-	if err != nil {
-		return nil, err
-	}
-	defer dst.Close()
-
-	// 复制文件内容
-	if _, err := io.Copy(dst, src); err != nil {
-		return nil, err
-	}
+	key := filestore.ObjectKey(fileID, name)
 
-	// 获取文件信息
-	fileInfo, err := os.Stat(filePath)
+	ctx := context.Background()
+	checksum := filestore.NewChecksumReader(r)
+	info, err := m.store.Put(ctx, key, checksum, size, contentType)
 	if err != nil {
 		return nil, err
 	}
+	md5Sum := checksum.MD5()
 
 	// 创建文件记录
 	file := &common.File{
 		ID:          fileID,
-		Name:        fileHeader.Filename,
-		Path:        filePath,
-		Size:        fileInfo.Size(),
-		ContentType: fileHeader.Header.Get("Content-Type"),
+		Name:        name,
+		Path:        m.store.Location(key),
+		Size:        info.Size,
+		ContentType: contentType,
 		MD5:         md5Sum,
+		SHA256:      checksum.SHA256(),
+		Backend:     m.store.Type(),
+		StorageKey:  key,
+		Tags:        opts.Tags,
+		ExpiresAt:   m.expiresAt(opts.Retention),
+	}
+
+	// 检查文件内容是否已存在
+	if existingFile, err := m.db.GetFileByMD5(md5Sum); err == nil && existingFile.Backend == file.Backend {
+		// 同名文件直接返回现有记录
+		if existingFile.Name == name {
+			m.deleteObject(ctx, key)
+			return existingFile, nil
+		}
+		// 内容相同但文件名不同：创建新的文件记录，与现有记录共享存储对象
+		shared := *file
+		shared.Path = existingFile.Path
+		shared.StorageKey = existingFile.StorageKey
+		if existingFile.SHA256 != "" {
+			shared.SHA256 = existingFile.SHA256
+		}
+		err := m.db.CreateSharedFile(&shared, existingFile.ID)
+		if err == nil {
+			m.deleteObject(ctx, key)
+			return &shared, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			m.deleteObject(ctx, key)
+			return nil, err
+		}
+		// 现有记录在此期间被删除，其对象可能已不存在，保留本次上传的对象
 	}
 
 	if err := m.db.CreateFile(file); err != nil {
-		os.Remove(filePath) // 删除文件
+		m.deleteObject(ctx, key) // 删除对象
 		return nil, err
 	}
 
 	return file, nil
 }
 
-// DistributeFile 分发文件到 Agent
-func (m *Manager) DistributeFile(fileID string, agentIDs []string, targetPath string) error {
+// fileLocation 返回 Agent 获取文件的位置：
+// 存储后端支持预签名时返回带有效期的下载链接，否则返回存储路径（Agent 与 Cloud 共享存储卷）
+func (m *Manager) fileLocation(file *common.File) string {
+	if file.StorageKey == "" || file.Backend != m.store.Type() {
+		return file.Path
+	}
+	url, err := m.store.PresignGet(context.Background(), file.StorageKey, 0, file.Name)
+	if err != nil {
+		if err != filestore.ErrPresignNotSupported {
+			log.Printf("Failed to presign file %s: %v", file.ID, err)
+		}
+		return file.Path
+	}
+	return url
+}
+
+// DistributeFile 分发文件到 Agent，返回每个 Agent 的分发记录
+func (m *Manager) DistributeFile(fileID string, agentIDs []string, targetPath string) ([]*common.FileDistribution, error) {
 	file, err := m.db.GetFile(fileID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// 为每个 Agent 创建文件分发任务
+	dists := make([]*common.FileDistribution, 0, len(agentIDs))
 	for _, agentID := range agentIDs {
-		// 检查 Agent 是否在线
-		_, exists := m.agentMgr.GetConnection(agentID)
-		if !exists {
-			continue
+		dist := &common.FileDistribution{
+			FileID:     fileID,
+			AgentID:    agentID,
+			TargetPath: targetPath,
 		}
 
-		// 创建文件分发任务
-		params := map[string]interface{}{
-			"operation":   "distribute",
-			"file_id":     fileID,
-			"file_path":   file.Path,
-			"file_name":   file.Name, // 传递原始文件名
-			"target_path": targetPath,
+		// 检查 Agent 是否在线
+		if !m.agentMgr.IsOnline(agentID) {
+			dist.Status = common.TaskStatusFailed
+			dist.Error = "agent not online"
+		} else {
+			// 创建文件分发任务
+			params := map[string]interface{}{
+				"operation":   "distribute",
+				"file_id":     fileID,
+				"file_name":   file.Name, // 传递原始文件名，file_path 由 CreateTask 下发时填充
+				"target_path": targetPath,
+			}
+
+			// 文件分发使用异步模式，记录错误但继续处理其他 Agent
+			task, err := m.CreateTask(agentID, common.TaskTypeFile, "", params, fileID, false, 60)
+			if err != nil {
+				dist.Status = common.TaskStatusFailed
+				dist.Error = err.Error()
+			} else {
+				dist.TaskID = task.ID
+				dist.Status = common.TaskStatusRunning
+			}
 		}
 
-		// 文件分发使用异步模式
-		_, err := m.CreateTask(agentID, common.TaskTypeFile, "", params, fileID, false, 60)
-		if err != nil {
-			// 记录错误但继续处理其他 Agent
-			continue
+		if err := m.db.CreateFileDistribution(dist); err != nil {
+			log.Printf("Failed to record distribution of file %s to agent %s: %v", fileID, agentID, err)
 		}
+		dists = append(dists, dist)
 	}
 
-	return nil
+	return dists, nil
 }
@@ -0,0 +1,175 @@
+package task
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/cloud-agent/internal/common"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrPolicyDenied 任务被任务策略拒绝
+var ErrPolicyDenied = common.NewError("task denied by policy")
+
+// ErrApprovalNotAllowed 审批人不能审批该任务
+var ErrApprovalNotAllowed = common.NewError("approval not allowed")
+
+// ErrNotPendingApproval 任务不在等待审批状态
+var ErrNotPendingApproval = common.NewError("task is not pending approval")
+
+// ErrApprovalParams 需要审批的任务参数中有明文密钥，脱敏保存后审批通过时无法下发
+var ErrApprovalParams = common.NewError("params contain secrets that would be redacted while waiting for approval, use secret:// references")
+
+// LoadPolicyConfig 从 YAML 文件加载任务策略，path 为空时返回 nil（允许所有任务）
+// 格式与 Agent 安全配置中的 policy 段相同
+func LoadPolicyConfig(path string) (*common.PolicyConfig, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy config: %w", err)
+	}
+	cfg := &common.PolicyConfig{}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse policy config: %w", err)
+	}
+	if _, err := common.NewPolicyEngine(cfg); err != nil {
+		return nil, fmt.Errorf("invalid policy config: %w", err)
+	}
+	return cfg, nil
+}
+
+// SetPolicy 设置下发前评估的任务策略，为 nil 时允许所有任务
+func (m *Manager) SetPolicy(p *common.PolicyEngine) {
+	m.policy = p
+}
+
+// EvaluatePolicy 按任务策略评估任务，不创建任务
+func (m *Manager) EvaluatePolicy(in *common.PolicyInput) *common.PolicyDecision {
+	return m.policy.Evaluate(in)
+}
+
+// PolicyInput 构造任务在 agentID 上执行时的策略评估输入，环境和标签取自 Agent 记录
+func (m *Manager) PolicyInput(agentID string, taskType common.TaskType, command string, params map[string]interface{}, user string) *common.PolicyInput {
+	in := &common.PolicyInput{User: user, TaskType: taskType, Command: command, Params: params}
+	if agent, err := m.db.GetAgent(agentID); err == nil {
+		in.Env = agent.Env
+		in.Tags = agent.Tags
+	}
+	return in
+}
+
+// describeDecision 拒绝或要求审批时的说明
+func describeDecision(d *common.PolicyDecision) string {
+	if d.Reason == "" {
+		return fmt.Sprintf("policy rule %q", d.Rule)
+	}
+	return fmt.Sprintf("policy rule %q: %s", d.Rule, d.Reason)
+}
+
+// ApproveTask 审批通过并下发任务
+// Agent 不在线时返回错误，任务保持等待审批；下发失败时任务标记为失败
+func (m *Manager) ApproveTask(taskID, approver, reason string) (*common.Task, error) {
+	task, err := m.pendingApproval(taskID, approver)
+	if err != nil {
+		return nil, err
+	}
+	if !m.agentMgr.IsOnline(task.AgentID) {
+		return nil, common.NewError("agent not online")
+	}
+	claimed, err := m.db.ApproveTask(taskID, approver)
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		return nil, ErrNotPendingApproval
+	}
+	task.Status = common.TaskStatusRunning
+	task.ApprovedBy = approver
+	m.auditor.Approval(task, approver, true, reason)
+	m.taskLog(taskID, "info", fmt.Sprintf("approved by %s", approver))
+
+	if err := m.agentMgr.SendMessage(task.AgentID, common.NewMessage(common.MessageTypeTaskCreate, m.storedTaskData(task))); err != nil {
+		m.failTask(task, fmt.Sprintf("failed to send task to agent: %v", err))
+	}
+	return m.db.GetTask(taskID)
+}
+
+// RejectTask 拒绝等待审批的任务，任务标记为已取消
+func (m *Manager) RejectTask(taskID, approver, reason string) (*common.Task, error) {
+	task, err := m.pendingApproval(taskID, approver)
+	if err != nil {
+		return nil, err
+	}
+	claimed, err := m.db.CompareAndSetTaskStatus(taskID, common.TaskStatusPendingApproval, common.TaskStatusCanceled)
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		return nil, ErrNotPendingApproval
+	}
+	m.auditor.Approval(task, approver, false, reason)
+
+	message := "approval rejected by " + approver
+	if reason != "" {
+		message += ": " + reason
+	}
+	if err := m.finishTask(&common.TaskCompleteData{TaskID: taskID, Status: common.TaskStatusCanceled, Error: message}); err != nil {
+		log.Printf("[policy] failed to complete rejected task %s: %v", taskID, err)
+	}
+	return m.db.GetTask(taskID)
+}
+
+// pendingApproval 返回等待审批的任务，并检查审批人能否审批
+func (m *Manager) pendingApproval(taskID, approver string) (*common.Task, error) {
+	task, err := m.db.GetTask(taskID)
+	if err != nil {
+		return nil, err
+	}
+	if task.Status != common.TaskStatusPendingApproval {
+		return nil, ErrNotPendingApproval
+	}
+	if err := m.policy.CheckApprover(task.PolicyRule, task.CreatedBy, approver); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrApprovalNotAllowed, err)
+	}
+	return task, nil
+}
+
+// storedTaskData 根据保存的任务构造下发给 Agent 的数据（重新下发和审批通过后下发）
+func (m *Manager) storedTaskData(task *common.Task) common.TaskCreateData {
+	taskData := common.TaskCreateData{
+		TaskID:     task.ID,
+		Type:       task.Type,
+		Command:    task.Command,
+		FileID:     task.FileID,
+		CreatedBy:  task.CreatedBy,
+		ApprovedBy: task.ApprovedBy,
+	}
+	if task.Params != "" {
+		if err := json.Unmarshal([]byte(task.Params), &taskData.Params); err != nil {
+			log.Printf("Invalid params of task %s: %v", task.ID, err)
+		}
+	}
+	if task.FileID != "" {
+		file, err := m.db.GetFile(task.FileID)
+		if err != nil {
+			log.Printf("Failed to get file %s of task %s: %v", task.FileID, task.ID, err)
+		} else {
+			taskData.Params = m.withFileLocation(taskData.Params, file)
+		}
+	}
+	if agent, err := m.db.GetAgent(task.AgentID); err == nil {
+		taskData.AgentTags = agent.Tags
+	}
+	return taskData
+}
+
+// paramsRedacted 保存的参数中是否有已脱敏的值
+func paramsRedacted(paramsJSON string) bool {
+	return strings.Contains(paramsJSON, common.RedactedValue)
+}
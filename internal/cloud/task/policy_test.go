@@ -0,0 +1,359 @@
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloud-agent/internal/cloud/agent"
+	"github.com/cloud-agent/internal/cloud/cluster"
+	"github.com/cloud-agent/internal/cloud/filestore"
+	"github.com/cloud-agent/internal/common"
+)
+
+func newPolicyTestManager(t *testing.T) (*Manager, func(id string) *common.Task) {
+	t.Helper()
+	m, db, _ := newTestManager(t)
+	// Agent 连接在另一个副本上，任务通过消息总线转发
+	cl := cluster.NewLocal("r1")
+	m.agentMgr = agent.NewManager(db, cl, nil)
+	m.cluster = cl
+	t.Cleanup(m.agentMgr.Close)
+	for _, id := range []string{"prod-db", "dev-db"} {
+		if _, err := cl.Registry.Register(id, "r2"); err != nil {
+			t.Fatalf("Register(%s) failed: %v", id, err)
+		}
+	}
+
+	now := time.Now()
+	db.CreateAgent(&common.Agent{ID: "prod-db", Name: "prod-db", Env: "prod", Tags: []string{"pci"}, Status: common.AgentStatusOnline, LastSeen: &now})
+	db.CreateAgent(&common.Agent{ID: "dev-db", Name: "dev-db", Env: "dev", Status: common.AgentStatusOnline, LastSeen: &now})
+
+	engine, err := common.NewPolicyEngine(&common.PolicyConfig{
+		Roles:       map[string][]string{"dba": {"alice", "bob"}, "oncall": {"carol"}},
+		DefaultRole: "viewer",
+		Rules: []common.PolicyRule{
+			{Name: "viewers-read-only", Effect: common.PolicyEffectDeny, Roles: []string{"viewer"}, SQLClasses: []string{common.SQLClassWrite, common.SQLClassDDL, common.SQLClassAdmin}, Reason: "viewers can only read"},
+			{Name: "prod-ddl", Effect: common.PolicyEffectRequireApproval, Envs: []string{"prod"}, SQLClasses: []string{common.SQLClassDDL}, Approvers: []string{"dba"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewPolicyEngine failed: %v", err)
+	}
+	m.SetPolicy(engine)
+	return m, func(id string) *common.Task {
+		task, err := db.GetTask(id)
+		if err != nil {
+			t.Fatalf("GetTask(%s) failed: %v", id, err)
+		}
+		return task
+	}
+}
+
+func TestCreateTaskPolicy(t *testing.T) {
+	m, getTask := newPolicyTestManager(t)
+
+	_, err := m.CreateTaskWithOptions(t.Context(), "dev-db", common.TaskTypeMySQL, "DELETE FROM users", nil, "", false, 0, &TaskOptions{CreatedBy: "dave"})
+	if !errors.Is(err, ErrPolicyDenied) || !strings.Contains(err.Error(), "viewers-read-only") {
+		t.Fatalf("write by viewer error = %v, want ErrPolicyDenied", err)
+	}
+
+	task, err := m.CreateTaskWithOptions(t.Context(), "prod-db", common.TaskTypeMySQL, "DROP TABLE users", nil, "", false, 0, &TaskOptions{CreatedBy: "alice"})
+	if err != nil {
+		t.Fatalf("CreateTaskWithOptions failed: %v", err)
+	}
+	if task.Status != common.TaskStatusPendingApproval || task.PolicyRule != "prod-ddl" {
+		t.Fatalf("task status = %s, rule = %q; want pending_approval by prod-ddl", task.Status, task.PolicyRule)
+	}
+
+	// 明文密钥保存时被脱敏，审批通过后无法下发
+	params := map[string]interface{}{"target": map[string]interface{}{"password": "plain"}}
+	if _, err := m.CreateTaskWithOptions(t.Context(), "prod-db", common.TaskTypeMySQL, "DROP TABLE users", params, "", false, 0, &TaskOptions{CreatedBy: "alice"}); !errors.Is(err, ErrApprovalParams) {
+		t.Errorf("approval with plaintext secret error = %v, want ErrApprovalParams", err)
+	}
+
+	// 提交者不能审批自己的任务，审批人必须有规则要求的角色
+	if _, err := m.ApproveTask(task.ID, "alice", ""); !errors.Is(err, ErrApprovalNotAllowed) {
+		t.Errorf("self approval error = %v, want ErrApprovalNotAllowed", err)
+	}
+	if _, err := m.ApproveTask(task.ID, "carol", ""); !errors.Is(err, ErrApprovalNotAllowed) {
+		t.Errorf("approval by non-dba error = %v, want ErrApprovalNotAllowed", err)
+	}
+	if got := getTask(task.ID); got.Status != common.TaskStatusPendingApproval {
+		t.Fatalf("task status after refused approval = %s", got.Status)
+	}
+
+	if _, err := m.ApproveTask(task.ID, "bob", "change ticket 42"); err != nil {
+		t.Fatalf("ApproveTask failed: %v", err)
+	}
+	got := getTask(task.ID)
+	if got.ApprovedBy != "bob" || got.Status != common.TaskStatusRunning {
+		t.Errorf("approved task = %s approved by %q, want running approved by bob", got.Status, got.ApprovedBy)
+	}
+	if _, err := m.ApproveTask(task.ID, "bob", ""); !errors.Is(err, ErrNotPendingApproval) {
+		t.Errorf("second approval error = %v, want ErrNotPendingApproval", err)
+	}
+}
+
+// presignStore 支持预签名下载的存储，用于检查预签名链接不被保存
+type presignStore struct {
+	filestore.FileStore
+}
+
+func (presignStore) PresignGet(ctx context.Context, key string, expires time.Duration, downloadName string) (string, error) {
+	return "https://files.example.com/" + key + "?signature=secret", nil
+}
+
+func TestApprovedTaskPresignsFileAtDispatch(t *testing.T) {
+	m, getTask := newPolicyTestManager(t)
+	m.store = presignStore{m.store}
+	sent := make(chan *common.Message, 10)
+	unsubscribe, err := m.cluster.Bus.Subscribe(cluster.ReplicaTopic("r2"), func(env *cluster.Envelope) { sent <- env.Message })
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	t.Cleanup(unsubscribe)
+
+	file := saveFile(t, m, "migrate.sql", "DROP TABLE users;", nil)
+	task, err := m.CreateTaskWithOptions(t.Context(), "prod-db", common.TaskTypeMySQL, "DROP TABLE users", nil, file.ID, false, 0, &TaskOptions{CreatedBy: "alice"})
+	if err != nil {
+		t.Fatalf("CreateTaskWithOptions failed: %v", err)
+	}
+	if _, err := m.ApproveTask(task.ID, "bob", ""); err != nil {
+		t.Fatalf("ApproveTask failed: %v", err)
+	}
+
+	select {
+	case msg := <-sent:
+		data, _ := json.Marshal(msg.Data)
+		var taskData common.TaskCreateData
+		json.Unmarshal(data, &taskData)
+		if want := "https://files.example.com/" + file.StorageKey + "?signature=secret"; taskData.Params["file_path"] != want {
+			t.Errorf("dispatched file_path = %v, want %s", taskData.Params["file_path"], want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("approved task was not dispatched")
+	}
+	// 数据库只保存文件 ID，不保存预签名链接
+	if got := getTask(task.ID); strings.Contains(got.Params, "signature") || got.FileID != file.ID {
+		t.Errorf("stored task params = %s, file = %s", got.Params, got.FileID)
+	}
+}
+
+func TestRejectTask(t *testing.T) {
+	m, getTask := newPolicyTestManager(t)
+
+	task, err := m.CreateTaskWithOptions(t.Context(), "prod-db", common.TaskTypePostgres, "ALTER TABLE users ADD c int", nil, "", false, 0, &TaskOptions{CreatedBy: "alice"})
+	if err != nil {
+		t.Fatalf("CreateTaskWithOptions failed: %v", err)
+	}
+	if _, err := m.RejectTask(task.ID, "bob", "not during freeze"); err != nil {
+		t.Fatalf("RejectTask failed: %v", err)
+	}
+	got := getTask(task.ID)
+	if got.Status != common.TaskStatusCanceled || !strings.Contains(got.Error, "rejected by bob: not during freeze") {
+		t.Errorf("rejected task = %s %q", got.Status, got.Error)
+	}
+	if _, err := m.RejectTask(task.ID, "bob", ""); !errors.Is(err, ErrNotPendingApproval) {
+		t.Errorf("second reject error = %v, want ErrNotPendingApproval", err)
+	}
+}
+
+func TestCancelPendingApprovalTask(t *testing.T) {
+	m, getTask := newPolicyTestManager(t)
+	sent := make(chan *common.Message, 10)
+	unsubscribe, err := m.cluster.Bus.Subscribe(cluster.ReplicaTopic("r2"), func(env *cluster.Envelope) { sent <- env.Message })
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	t.Cleanup(unsubscribe)
+
+	task, err := m.CreateTaskWithOptions(t.Context(), "prod-db", common.TaskTypeMySQL, "DROP TABLE users", nil, "", false, 0, &TaskOptions{CreatedBy: "alice"})
+	if err != nil {
+		t.Fatalf("CreateTaskWithOptions failed: %v", err)
+	}
+	if err := m.CancelTask(task.ID); err != nil {
+		t.Fatalf("CancelTask failed: %v", err)
+	}
+	if got := getTask(task.ID); got.Status != common.TaskStatusCanceled {
+		t.Errorf("canceled task status = %s", got.Status)
+	}
+	// 任务还没有下发，不通知 Agent 取消
+	select {
+	case msg := <-sent:
+		t.Errorf("unexpected message to agent: %s", msg.Type)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if _, err := m.ApproveTask(task.ID, "bob", ""); !errors.Is(err, ErrNotPendingApproval) {
+		t.Errorf("approval after cancel error = %v, want ErrNotPendingApproval", err)
+	}
+	if err := m.CancelTask(task.ID); err == nil {
+		t.Error("second cancel should fail")
+	}
+}
+
+func TestCancelRacesWithApproval(t *testing.T) {
+	m, getTask := newPolicyTestManager(t)
+	var mu sync.Mutex
+	sent := make(map[string][]common.MessageType) // 任务 ID -> 发送给 Agent 的消息类型
+	unsubscribe, err := m.cluster.Bus.Subscribe(cluster.ReplicaTopic("r2"), func(env *cluster.Envelope) {
+		data, _ := json.Marshal(env.Message.Data)
+		var msg struct {
+			TaskID string `json:"task_id"`
+		}
+		json.Unmarshal(data, &msg)
+		mu.Lock()
+		sent[msg.TaskID] = append(sent[msg.TaskID], env.Message.Type)
+		mu.Unlock()
+	})
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	t.Cleanup(unsubscribe)
+
+	type result struct {
+		id                    string
+		approveErr, cancelErr error
+	}
+	var results []result
+	for range 20 {
+		task, err := m.CreateTaskWithOptions(t.Context(), "prod-db", common.TaskTypeMySQL, "DROP TABLE users", nil, "", false, 0, &TaskOptions{CreatedBy: "alice"})
+		if err != nil {
+			t.Fatalf("CreateTaskWithOptions failed: %v", err)
+		}
+		r := result{id: task.ID}
+		var wg sync.WaitGroup
+		wg.Go(func() { _, r.approveErr = m.ApproveTask(task.ID, "bob", "") })
+		wg.Go(func() { r.cancelErr = m.CancelTask(task.ID) })
+		wg.Wait()
+		results = append(results, r)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, r := range results {
+		got, msgs := getTask(r.id), sent[r.id]
+		switch {
+		case r.cancelErr != nil:
+			// 审批先完成下发，取消时状态已经变化
+			if r.approveErr != nil || got.Status != common.TaskStatusRunning {
+				t.Errorf("task %s: approve error = %v, status = %s", r.id, r.approveErr, got.Status)
+			}
+		case r.approveErr != nil:
+			// 取消先完成，任务不会下发
+			if got.Status != common.TaskStatusCanceled || len(msgs) != 0 {
+				t.Errorf("task %s canceled before approval: status = %s, messages = %v", r.id, got.Status, msgs)
+			}
+		default:
+			// 审批下发后再取消，必须通知 Agent 停止执行
+			if got.Status != common.TaskStatusCanceled || !slices.Equal(msgs, []common.MessageType{common.MessageTypeTaskCreate, common.MessageTypeTaskCancel}) {
+				t.Errorf("task %s canceled after approval: status = %s, messages = %v", r.id, got.Status, msgs)
+			}
+		}
+	}
+}
+
+func TestEvaluatePolicyTrace(t *testing.T) {
+	m, _ := newPolicyTestManager(t)
+
+	decision := m.EvaluatePolicy(m.PolicyInput("prod-db", common.TaskTypeMySQL, "SELECT 1; DROP TABLE t", nil, "alice"))
+	if decision.Effect != common.PolicyEffectRequireApproval || decision.Rule != "prod-ddl" {
+		t.Fatalf("decision = %s by %q, want require_approval by prod-ddl", decision.Effect, decision.Rule)
+	}
+	if decision.Facts.Env != "prod" || decision.Facts.SQLClass != common.SQLClassDDL || len(decision.Facts.Tags) != 1 {
+		t.Errorf("facts = %+v", decision.Facts)
+	}
+	if len(decision.Trace) != 2 || decision.Trace[0].Matched || !strings.Contains(decision.Trace[0].Reason, "roles") || !decision.Trace[1].Matched {
+		t.Errorf("trace = %+v", decision.Trace)
+	}
+
+	decision = m.EvaluatePolicy(m.PolicyInput("dev-db", common.TaskTypeMySQL, "SELECT 1", nil, "dave"))
+	if decision.Effect != common.PolicyEffectAllow || decision.Rule != common.PolicyRuleDefault {
+		t.Errorf("read by viewer = %s by %q, want allow by default", decision.Effect, decision.Rule)
+	}
+}
+
+func TestPolicyK8sTargets(t *testing.T) {
+	engine, err := common.NewPolicyEngine(&common.PolicyConfig{
+		Default: common.PolicyEffectDeny,
+		Rules: []common.PolicyRule{
+			{Name: "no-secrets", Effect: common.PolicyEffectDeny, Kinds: []string{"secret"}},
+			{Name: "apps", Effect: common.PolicyEffectAllow, TaskTypes: []string{"k8s"}, Namespaces: []string{"app-*"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewPolicyEngine failed: %v", err)
+	}
+	manifest := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: a\n  namespace: app-web\n---\nkind: ConfigMap\nmetadata:\n  name: b\n  namespace: kube-system\n"
+	tests := []struct {
+		command string
+		params  map[string]interface{}
+		want    string
+	}{
+		{"deployment/web", map[string]interface{}{"operation": "get", "namespace": "app-web"}, "apps"},
+		{"secret/db", map[string]interface{}{"operation": "get", "namespace": "app-web"}, "no-secrets"},
+		{"deployment/web", map[string]interface{}{"operation": "get"}, common.PolicyRuleDefault},
+		// allow 规则要求清单中所有资源的命名空间都匹配
+		{manifest, map[string]interface{}{"operation": "apply"}, common.PolicyRuleDefault},
+		{`{"kind":"Secret","metadata":{"namespace":"app-web"}}`, map[string]interface{}{"operation": "apply"}, "no-secrets"},
+	}
+	for _, tt := range tests {
+		decision := engine.Evaluate(&common.PolicyInput{TaskType: common.TaskTypeK8s, Command: tt.command, Params: tt.params})
+		if decision.Rule != tt.want {
+			t.Errorf("Evaluate(%q, %v) rule = %q (facts %+v), want %q", tt.command, tt.params, decision.Rule, decision.Facts, tt.want)
+		}
+	}
+
+	for sql, want := range map[string]string{
+		"select * from t -- ; drop table t":  common.SQLClassRead,
+		"SELECT ';DROP TABLE t'":             common.SQLClassRead,
+		"with x as (select 1) delete from t": common.SQLClassWrite,
+		"select 1; grant all on *.* to u":    common.SQLClassAdmin,
+		"frobnicate":                         common.SQLClassOther,
+	} {
+		if got := common.ClassifySQL(sql); got != want {
+			t.Errorf("ClassifySQL(%q) = %q, want %q", sql, got, want)
+		}
+	}
+}
+
+func TestPolicyShellProfiles(t *testing.T) {
+	engine, err := common.NewPolicyEngine(&common.PolicyConfig{
+		Rules: []common.PolicyRule{
+			{Name: "prod-shell-sandboxed", Effect: common.PolicyEffectAllow, Envs: []string{"prod"}, Profiles: []string{"restricted*"}},
+			{Name: "prod-shell", Effect: common.PolicyEffectDeny, Envs: []string{"prod"}, TaskTypes: []string{"shell"}},
+			{Name: "unsandboxed", Effect: common.PolicyEffectRequireApproval, Profiles: []string{common.PolicyProfileNone}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewPolicyEngine failed: %v", err)
+	}
+	tests := []struct {
+		env      string
+		taskType common.TaskType
+		profile  string
+		want     string
+	}{
+		{"prod", common.TaskTypeShell, "restricted-ro", "prod-shell-sandboxed"},
+		{"prod", common.TaskTypeShell, "privileged", "prod-shell"},
+		{"dev", common.TaskTypeShell, "", "unsandboxed"},
+		{"dev", common.TaskTypeShell, "privileged", common.PolicyRuleDefault},
+		// 执行配置条件只匹配 Shell 任务
+		{"dev", common.TaskTypeMySQL, "", common.PolicyRuleDefault},
+	}
+	for _, tt := range tests {
+		params := map[string]interface{}{}
+		if tt.profile != "" {
+			params["profile"] = tt.profile
+		}
+		if decision := engine.Evaluate(&common.PolicyInput{Env: tt.env, TaskType: tt.taskType, Params: params}); decision.Rule != tt.want {
+			t.Errorf("Evaluate(%s, %s, %q) rule = %q, want %q", tt.env, tt.taskType, tt.profile, decision.Rule, tt.want)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package task
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/cloud-agent/internal/common"
+	"gopkg.in/yaml.v3"
+)
+
+// LoadRedactionConfig 从 YAML 文件加载脱敏规则，path 为空时只使用内置规则
+// 格式与 Agent 安全配置中的 redaction 段相同
+func LoadRedactionConfig(path string) (*common.RedactionConfig, error) {
+	cfg := &common.RedactionConfig{}
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read redaction config: %w", err)
+	}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse redaction config: %w", err)
+	}
+	if _, err := common.NewRedactor(cfg); err != nil {
+		return nil, fmt.Errorf("invalid redaction config: %w", err)
+	}
+	return cfg, nil
+}
+
+// SetRedactor 设置保存任务日志、结果和参数前使用的脱敏规则，为 nil 时只使用内置规则
+func (m *Manager) SetRedactor(r *common.Redactor) {
+	if r == nil {
+		r = common.DefaultRedactor()
+	}
+	m.redactor = r
+}
@@ -0,0 +1,148 @@
+package task
+
+import (
+	"cmp"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"slices"
+
+	"github.com/cloud-agent/internal/cloud/agent"
+	"github.com/cloud-agent/internal/common"
+)
+
+// ErrNoMatchingAgent 没有符合条件的在线 Agent
+var ErrNoMatchingAgent = common.NewError("no online agent matches")
+
+// ErrInvalidTarget Agent 选择条件或调度策略不合法
+var ErrInvalidTarget = common.NewError("invalid selector")
+
+// 调度策略
+const (
+	StrategyLeastLoaded = "least_loaded" // 选择待执行和执行中任务最少的 Agent（默认）
+	StrategyRoundRobin  = "round_robin"  // 按 Agent ID 顺序轮流选择，轮询位置记录在当前副本
+	StrategySticky      = "sticky"       // 候选 Agent 不变时，相同调度键总是选择同一个 Agent
+)
+
+// maxRoundRobinKeys 轮询位置最多记录的调度键数
+const maxRoundRobinKeys = 10000
+
+// TaskTarget 未指定 Agent ID 时选择 Agent 的条件和调度策略
+type TaskTarget struct {
+	agent.Selector
+	Strategy  string `json:"strategy,omitempty"`   // 调度策略，默认 least_loaded
+	StickyKey string `json:"sticky_key,omitempty"` // 轮询和粘滞调度的键，默认为选择条件
+}
+
+// Validate 检查选择条件和调度策略
+func (t *TaskTarget) Validate() error {
+	if t.Empty() {
+		return fmt.Errorf("%w: at least one of env, tags, hostname or capability is required", ErrInvalidTarget)
+	}
+	switch t.Strategy {
+	case "", StrategyLeastLoaded, StrategyRoundRobin, StrategySticky:
+	default:
+		return fmt.Errorf("%w: unknown strategy %q, must be %s, %s or %s", ErrInvalidTarget, t.Strategy, StrategyLeastLoaded, StrategyRoundRobin, StrategySticky)
+	}
+	if err := t.Selector.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidTarget, err)
+	}
+	return nil
+}
+
+// key 轮询和粘滞调度使用的键
+func (t *TaskTarget) key() string {
+	if t.StickyKey != "" {
+		return t.StickyKey
+	}
+	return t.Selector.String()
+}
+
+// SelectAgent 从符合 target 条件、并能执行该任务的在线 Agent 中按调度策略选择一个
+func (m *Manager) SelectAgent(target *TaskTarget, taskType common.TaskType, params map[string]interface{}) (string, error) {
+	if err := target.Validate(); err != nil {
+		return "", err
+	}
+	agents, err := m.agentMgr.FindAgents(&target.Selector)
+	if err != nil {
+		return "", err
+	}
+	connection := requiredConnection(params)
+
+	var candidates []*common.Agent
+	for _, a := range agents {
+		if a.HasCapability(taskType, connection) {
+			candidates = append(candidates, a)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("%w: %s type=%s", ErrNoMatchingAgent, target.Selector.String(), taskType)
+	}
+	slices.SortFunc(candidates, func(a, b *common.Agent) int { return cmp.Compare(a.ID, b.ID) })
+
+	var selected *common.Agent
+	switch target.Strategy {
+	case StrategyRoundRobin:
+		selected = candidates[m.nextRoundRobin(target.key())%uint64(len(candidates))]
+	case StrategySticky:
+		selected = rendezvous(candidates, target.key())
+	default:
+		if selected, err = m.leastLoaded(candidates); err != nil {
+			return "", err
+		}
+	}
+	log.Printf("[scheduler] selected agent %s from %d candidates (strategy=%s, %s)",
+		selected.ID, len(candidates), cmp.Or(target.Strategy, StrategyLeastLoaded), target.Selector.String())
+	return selected.ID, nil
+}
+
+// leastLoaded 选择待执行和执行中任务最少的 Agent，任务数相同时选择 CPU 使用率较低的
+// 任务数从数据库统计，包含其他副本下发的任务
+func (m *Manager) leastLoaded(candidates []*common.Agent) (*common.Agent, error) {
+	ids := make([]string, len(candidates))
+	for i, a := range candidates {
+		ids[i] = a.ID
+	}
+	counts, err := m.db.CountActiveTasksByAgent(ids)
+	if err != nil {
+		return nil, err
+	}
+	cpu := func(a *common.Agent) float64 {
+		if a.Telemetry == nil {
+			return 0
+		}
+		return a.Telemetry.CPUPercent
+	}
+	return slices.MinFunc(candidates, func(a, b *common.Agent) int {
+		return cmp.Or(cmp.Compare(counts[a.ID], counts[b.ID]), cmp.Compare(cpu(a), cpu(b)))
+	}), nil
+}
+
+// nextRoundRobin 返回调度键的下一个轮询位置
+func (m *Manager) nextRoundRobin(key string) uint64 {
+	m.schedMu.Lock()
+	defer m.schedMu.Unlock()
+	// 调度键可由调用方指定，数量过多时重置，避免无限增长
+	if m.roundRobin == nil || len(m.roundRobin) >= maxRoundRobinKeys {
+		m.roundRobin = make(map[string]uint64)
+	}
+	n := m.roundRobin[key]
+	m.roundRobin[key] = n + 1
+	return n
+}
+
+// rendezvous 最高随机权重哈希：候选 Agent 增减时只有原本落在变化 Agent 上的键会改变选择
+func rendezvous(candidates []*common.Agent, key string) *common.Agent {
+	var selected *common.Agent
+	var best uint64
+	for _, a := range candidates {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write([]byte(a.ID))
+		if score := h.Sum64(); selected == nil || score > best {
+			selected, best = a, score
+		}
+	}
+	return selected
+}
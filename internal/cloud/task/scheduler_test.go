@@ -0,0 +1,82 @@
+package task
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/cloud-agent/internal/cloud/agent"
+	"github.com/cloud-agent/internal/common"
+)
+
+func TestSelectAgentStrategies(t *testing.T) {
+	m, db, _ := newTestManager(t)
+	m.agentMgr = agent.NewManager(db, nil, nil)
+	t.Cleanup(m.agentMgr.Close)
+
+	now := time.Now()
+	for _, a := range []*common.Agent{
+		{ID: "web-1", Hostname: "web-1", Env: "prod", Tags: []string{"frontend", "zone-a"}},
+		{ID: "web-2", Hostname: "web-2", Env: "prod", Tags: []string{"frontend", "zone-b"}},
+		{ID: "web-3", Hostname: "web-3", Env: "prod", Tags: []string{"frontend", "zone-a"}},
+		{ID: "db-1", Hostname: "db-1", Env: "prod", Tags: []string{"frontend"}},
+		{ID: "web-9", Hostname: "web-9", Env: "prod", Tags: []string{"frontend"}, Status: common.AgentStatusOffline},
+	} {
+		a.Name = a.ID
+		if a.Status == "" {
+			a.Status = common.AgentStatusOnline
+			a.LastSeen = &now
+		}
+		db.CreateAgent(a)
+	}
+	selector := agent.Selector{Env: "prod", Tags: []string{"frontend"}, Hostname: "web-*"}
+
+	// web-1 和 web-2 上已有任务，选择空闲的 web-3
+	db.CreateTask(&common.Task{ID: "t1", AgentID: "web-1", Type: common.TaskTypeShell, Status: common.TaskStatusRunning})
+	db.CreateTask(&common.Task{ID: "t2", AgentID: "web-2", Type: common.TaskTypeShell, Status: common.TaskStatusPending})
+	db.CreateTask(&common.Task{ID: "t3", AgentID: "web-3", Type: common.TaskTypeShell, Status: common.TaskStatusSuccess})
+	if id, err := m.SelectAgent(&TaskTarget{Selector: selector}, common.TaskTypeShell, nil); err != nil || id != "web-3" {
+		t.Errorf("least loaded = %q, %v; want web-3", id, err)
+	}
+
+	var order []string
+	for i := 0; i < 4; i++ {
+		id, err := m.SelectAgent(&TaskTarget{Selector: selector, Strategy: StrategyRoundRobin}, common.TaskTypeShell, nil)
+		if err != nil {
+			t.Fatalf("round robin failed: %v", err)
+		}
+		order = append(order, id)
+	}
+	if want := []string{"web-1", "web-2", "web-3", "web-1"}; !slices.Equal(order, want) {
+		t.Errorf("round robin order = %v, want %v", order, want)
+	}
+
+	sticky := &TaskTarget{Selector: selector, Strategy: StrategySticky, StickyKey: "order-42"}
+	first, err := m.SelectAgent(sticky, common.TaskTypeShell, nil)
+	if err != nil {
+		t.Fatalf("sticky failed: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		if id, _ := m.SelectAgent(sticky, common.TaskTypeShell, nil); id != first {
+			t.Errorf("sticky selection changed from %s to %s", first, id)
+		}
+	}
+
+	zoneA := &TaskTarget{Selector: agent.Selector{Tags: []string{"frontend", "zone-a"}}, Strategy: StrategyRoundRobin}
+	for i := 0; i < 4; i++ {
+		if id, _ := m.SelectAgent(zoneA, common.TaskTypeShell, nil); id != "web-1" && id != "web-3" {
+			t.Errorf("selected %s outside zone-a", id)
+		}
+	}
+
+	for _, target := range []*TaskTarget{
+		{},
+		{Selector: selector, Strategy: "fastest"},
+		{Selector: agent.Selector{Hostname: "web-["}},
+	} {
+		if _, err := m.SelectAgent(target, common.TaskTypeShell, nil); !errors.Is(err, ErrInvalidTarget) {
+			t.Errorf("SelectAgent(%+v) error = %v, want ErrInvalidTarget", target, err)
+		}
+	}
+}
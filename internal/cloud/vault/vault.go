@@ -0,0 +1,224 @@
+// Package vault Cloud 侧的密钥库
+//
+// 密钥值使用 AES-256-GCM 加密后保存在数据库中，API 只返回名称和元数据。Agent 注册和密钥变更时，
+// Cloud 把适用于该 Agent 环境的密钥解密后通过 WebSocket 下发（secrets.sync），Agent 只在内存中保存，
+// 用于解析插件配置和任务参数中的 secret:// 引用。未配置加密密钥时密钥库不可用。
+package vault
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"time"
+
+	"github.com/cloud-agent/internal/cloud/agent"
+	"github.com/cloud-agent/internal/cloud/audit"
+	"github.com/cloud-agent/internal/cloud/storage"
+	"github.com/cloud-agent/internal/common"
+)
+
+var (
+	// ErrDisabled 未配置加密密钥
+	ErrDisabled = errors.New("secret vault is not configured")
+	// ErrInvalidSecret 密钥名称、值或主机模式不合法
+	ErrInvalidSecret = errors.New("invalid secret")
+)
+
+// namePattern 密钥名称，与 secret:// 引用中的名称一致
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,254}$`)
+
+// ParseKey 解析 base64 编码的 32 字节加密密钥，为空时返回 nil（不启用密钥库）
+func ParseKey(s string) ([]byte, error) {
+	if s == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("secrets key must be base64 encoded: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("secrets key must be 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+// Vault 密钥库
+// key 为空时所有写入和下发操作返回 ErrDisabled 或不做处理
+type Vault struct {
+	db       *storage.Database
+	agentMgr *agent.Manager
+	aead     cipher.AEAD
+	auditor  *audit.Recorder
+}
+
+// NewVault 创建密钥库，key 为 nil 时密钥库不可用
+func NewVault(db *storage.Database, agentMgr *agent.Manager, key []byte) (*Vault, error) {
+	v := &Vault{db: db, agentMgr: agentMgr}
+	if key == nil {
+		return v, nil
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if v.aead, err = cipher.NewGCM(block); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// SetAuditor 设置审计记录器，为 nil 时不记录审计事件
+func (v *Vault) SetAuditor(r *audit.Recorder) {
+	v.auditor = r
+}
+
+// Enabled 是否配置了加密密钥
+func (v *Vault) Enabled() bool {
+	return v.aead != nil
+}
+
+// Put 加密保存密钥，然后下发给相关的在线 Agent
+func (v *Vault) Put(secret *common.Secret, value string) error {
+	if !v.Enabled() {
+		return ErrDisabled
+	}
+	if !namePattern.MatchString(secret.Name) {
+		return fmt.Errorf("%w: name must match %s", ErrInvalidSecret, namePattern)
+	}
+	if value == "" {
+		return fmt.Errorf("%w: value is empty", ErrInvalidSecret)
+	}
+	for _, pattern := range secret.AllowedHosts {
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return fmt.Errorf("%w: invalid allowed_hosts pattern %q", ErrInvalidSecret, pattern)
+		}
+	}
+
+	previous, _ := v.db.GetSecret(secret.Name)
+	nonce := make([]byte, v.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	// 以名称作为附加数据，密文不能挪用到其他密钥
+	secret.Ciphertext = v.aead.Seal(nonce, nonce, []byte(value), []byte(secret.Name))
+	now := time.Now()
+	secret.CreatedAt, secret.UpdatedAt = now, now
+	// 更新已有密钥时保留创建时间
+	if previous != nil {
+		secret.CreatedAt = previous.CreatedAt
+	}
+	if err := v.db.SaveSecret(secret); err != nil {
+		return err
+	}
+	v.auditor.Record(&common.AuditEvent{
+		Action:  common.AuditActionSecretUpdated,
+		Actor:   secret.UpdatedBy,
+		Outcome: "updated",
+		Command: secret.Name,
+	})
+
+	v.pushEnv(secret.Env)
+	// 环境变化时，原环境的 Agent 需要移除该密钥
+	if previous != nil && previous.Env != secret.Env {
+		v.pushEnv(previous.Env)
+	}
+	return nil
+}
+
+// Delete 删除密钥，然后通知相关的在线 Agent
+func (v *Vault) Delete(name, actor string) error {
+	secret, err := v.db.GetSecret(name)
+	if err != nil {
+		return err
+	}
+	if err := v.db.DeleteSecret(name); err != nil {
+		return err
+	}
+	v.auditor.Record(&common.AuditEvent{
+		Action:  common.AuditActionSecretDeleted,
+		Actor:   actor,
+		Outcome: "deleted",
+		Command: name,
+	})
+	v.pushEnv(secret.Env)
+	return nil
+}
+
+// List 列出密钥元数据（不含值）
+func (v *Vault) List() ([]*common.Secret, error) {
+	return v.db.ListSecrets()
+}
+
+// ForAgent 返回适用于 Agent 的解密后的密钥
+func (v *Vault) ForAgent(a *common.Agent) ([]common.SecretValue, error) {
+	if !v.Enabled() {
+		return nil, ErrDisabled
+	}
+	secrets, err := v.db.ListSecrets()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]common.SecretValue, 0, len(secrets))
+	for _, s := range secrets {
+		if s.Env != "" && s.Env != a.Env {
+			continue
+		}
+		value, err := v.decrypt(s)
+		if err != nil {
+			log.Printf("[vault] failed to decrypt secret %s: %v", s.Name, err)
+			continue
+		}
+		values = append(values, common.SecretValue{Name: s.Name, Value: value, AllowedHosts: s.AllowedHosts})
+	}
+	return values, nil
+}
+
+// PushToAgent 向连接在当前副本的 Agent 下发适用的全部密钥，在 Agent 注册后调用；密钥库未启用时不做处理
+// 密钥值不经过副本间的消息总线，其他副本上的 Agent 通过 agent.Manager.SyncSecrets 由对应副本下发
+func (v *Vault) PushToAgent(agentID string) error {
+	if !v.Enabled() {
+		return nil
+	}
+	a, err := v.db.GetAgent(agentID)
+	if err != nil {
+		return err
+	}
+	values, err := v.ForAgent(a)
+	if err != nil {
+		return err
+	}
+	return v.agentMgr.SendLocalMessage(agentID, common.NewMessage(common.MessageTypeSecretsSync, &common.SecretsSyncData{Secrets: values}))
+}
+
+// pushEnv 向 env 环境（为空时为全部）的在线 Agent 下发密钥
+func (v *Vault) pushEnv(env string) {
+	agents, err := v.agentMgr.FindAgents(&agent.Selector{Env: env})
+	if err != nil {
+		log.Printf("[vault] failed to list agents: %v", err)
+		return
+	}
+	for _, a := range agents {
+		if err := v.agentMgr.SyncSecrets(a.ID); err != nil {
+			log.Printf("[vault] failed to push secrets to agent %s: %v", a.ID, err)
+		}
+	}
+}
+
+// decrypt 解密密钥值
+func (v *Vault) decrypt(s *common.Secret) (string, error) {
+	size := v.aead.NonceSize()
+	if len(s.Ciphertext) < size {
+		return "", errors.New("ciphertext too short")
+	}
+	plain, err := v.aead.Open(nil, s.Ciphertext[:size], s.Ciphertext[size:], []byte(s.Name))
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
@@ -0,0 +1,121 @@
+package vault
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloud-agent/internal/cloud/agent"
+	"github.com/cloud-agent/internal/cloud/storage"
+	"github.com/cloud-agent/internal/common"
+)
+
+func newTestVault(t *testing.T, key []byte) (*Vault, *storage.Database) {
+	t.Helper()
+	db, err := storage.NewDatabaseWithConfig(&storage.Config{
+		DSN:      filepath.Join(t.TempDir(), "cloud.db"),
+		LogLevel: "silent",
+	})
+	if err != nil {
+		t.Fatalf("NewDatabaseWithConfig failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	agentMgr := agent.NewManager(db, nil, nil)
+	t.Cleanup(agentMgr.Close)
+	v, err := NewVault(db, agentMgr, key)
+	if err != nil {
+		t.Fatalf("NewVault failed: %v", err)
+	}
+	return v, db
+}
+
+func TestVaultPutAndForAgent(t *testing.T) {
+	key, err := ParseKey("MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+	if err != nil {
+		t.Fatalf("ParseKey failed: %v", err)
+	}
+	v, db := newTestVault(t, key)
+
+	if err := v.Put(&common.Secret{Name: "pg-prod", Env: "prod", AllowedHosts: []string{"*.db.internal"}}, "prod-pass"); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := v.Put(&common.Secret{Name: "shared"}, "shared-pass"); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	for _, s := range []*common.Secret{{Name: "bad name"}, {Name: "empty"}} {
+		value := "x"
+		if s.Name == "empty" {
+			value = ""
+		}
+		if err := v.Put(s, value); !errors.Is(err, ErrInvalidSecret) {
+			t.Errorf("Put(%s) error = %v, want ErrInvalidSecret", s.Name, err)
+		}
+	}
+
+	stored, err := db.GetSecret("pg-prod")
+	if err != nil {
+		t.Fatalf("GetSecret failed: %v", err)
+	}
+	if string(stored.Ciphertext) == "prod-pass" || len(stored.Ciphertext) == 0 {
+		t.Error("secret value should be stored encrypted")
+	}
+
+	values, err := v.ForAgent(&common.Agent{ID: "a1", Env: "prod"})
+	if err != nil {
+		t.Fatalf("ForAgent failed: %v", err)
+	}
+	if len(values) != 2 {
+		t.Fatalf("prod agent secrets = %+v, want 2", values)
+	}
+	if values, _ := v.ForAgent(&common.Agent{ID: "a2", Env: "dev"}); len(values) != 1 || values[0].Value != "shared-pass" {
+		t.Errorf("dev agent secrets = %+v, want only shared", values)
+	}
+
+	// 密文挪用到其他名称时无法解密
+	stored.Name = "shared"
+	if _, err := v.decrypt(stored); err == nil {
+		t.Error("ciphertext should be bound to the secret name")
+	}
+}
+
+func TestVaultDisabled(t *testing.T) {
+	v, _ := newTestVault(t, nil)
+	if v.Enabled() {
+		t.Fatal("vault without key should be disabled")
+	}
+	if err := v.Put(&common.Secret{Name: "pg"}, "pass"); !errors.Is(err, ErrDisabled) {
+		t.Errorf("Put error = %v, want ErrDisabled", err)
+	}
+	if err := v.PushToAgent("a1"); err != nil {
+		t.Errorf("PushToAgent on disabled vault should be a no-op, got %v", err)
+	}
+	if _, err := ParseKey("c2hvcnQ="); err == nil {
+		t.Error("ParseKey should reject short keys")
+	}
+}
+
+func TestVaultPutKeepsCreatedAt(t *testing.T) {
+	key, err := ParseKey("MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+	if err != nil {
+		t.Fatalf("ParseKey failed: %v", err)
+	}
+	v, db := newTestVault(t, key)
+
+	if err := v.Put(&common.Secret{Name: "pg-prod"}, "old-pass"); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	created := time.Now().Add(-time.Hour).Truncate(time.Second)
+	db.GetDB().Model(&common.Secret{}).Where("name = ?", "pg-prod").Update("created_at", created)
+
+	updated := &common.Secret{Name: "pg-prod", UpdatedBy: "alice"}
+	if err := v.Put(updated, "new-pass"); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if !updated.CreatedAt.Equal(created) || !updated.UpdatedAt.After(created) {
+		t.Errorf("updated secret created_at = %s, updated_at = %s, want created_at %s", updated.CreatedAt, updated.UpdatedAt, created)
+	}
+	if stored, _ := db.GetSecret("pg-prod"); !stored.CreatedAt.Equal(created) {
+		t.Errorf("stored created_at = %s, want %s", stored.CreatedAt, created)
+	}
+}
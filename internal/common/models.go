@@ -1,6 +1,8 @@
 package common
 
 import (
+	"slices"
+	"strings"
 	"time"
 )
 
@@ -15,19 +17,47 @@ const (
 
 // Agent Agent 节点信息
 type Agent struct {
-	ID        string      `json:"id" gorm:"primaryKey"`
-	Name      string      `json:"name" gorm:"not null"`
-	Hostname  string      `json:"hostname"`
-	IP        string      `json:"ip"`
-	Version   string      `json:"version"`
-	Env       string      `json:"env" gorm:"index"`                              // K8s 集群名称
-	Protocol  string      `json:"protocol" gorm:"type:varchar(10);default:'ws'"` // 连接协议: ws 或 wss
-	Status    AgentStatus `json:"status" gorm:"default:'offline'"`
-	LastSeen  *time.Time  `json:"last_seen"`
-	Tags      []string    `json:"tags" gorm:"serializer:json"` // Agent 标签
-	Metadata  string      `json:"metadata" gorm:"type:text"`   // JSON 格式的元数据
-	CreatedAt time.Time   `json:"created_at"`
-	UpdatedAt time.Time   `json:"updated_at"`
+	ID           string            `json:"id" gorm:"primaryKey"`
+	Name         string            `json:"name" gorm:"not null"`
+	Hostname     string            `json:"hostname"`
+	IP           string            `json:"ip"`
+	Version      string            `json:"version"`
+	Env          string            `json:"env" gorm:"index"`                              // K8s 集群名称
+	Protocol     string            `json:"protocol" gorm:"type:varchar(10);default:'ws'"` // 连接协议: ws 或 wss
+	Status       AgentStatus       `json:"status" gorm:"default:'offline'"`
+	LastSeen     *time.Time        `json:"last_seen"`
+	Tags         []string          `json:"tags" gorm:"type:text;serializer:json"`                // Agent 标签
+	Metadata     string            `json:"metadata" gorm:"type:text"`                            // JSON 格式的元数据
+	Telemetry    *AgentTelemetry   `json:"telemetry,omitempty" gorm:"type:text;serializer:json"` // 最近一次心跳上报的资源快照
+	Capabilities []AgentCapability `json:"capabilities" gorm:"type:text;serializer:json"`        // 注册时上报的执行器能力，nil 表示未上报（旧版本 Agent）
+	CreatedAt    time.Time         `json:"created_at"`
+	UpdatedAt    time.Time         `json:"updated_at"`
+}
+
+// ParseCapability 解析 "type" 或 "type:connection" 格式的能力要求，如 postgres:prod
+func ParseCapability(s string) (TaskType, string) {
+	taskType, connection, _ := strings.Cut(strings.TrimSpace(s), ":")
+	return TaskType(taskType), connection
+}
+
+// CapabilitiesKnown Agent 是否上报了能力
+func (a *Agent) CapabilitiesKnown() bool {
+	return a.Capabilities != nil
+}
+
+// HasCapability Agent 是否注册了 taskType 执行器，connection 非空时还要求配置了该名称的连接
+// 未上报能力的旧版本 Agent 无法判断，返回 true
+func (a *Agent) HasCapability(taskType TaskType, connection string) bool {
+	if !a.CapabilitiesKnown() {
+		return true
+	}
+	for _, c := range a.Capabilities {
+		if c.Type != taskType {
+			continue
+		}
+		return connection == "" || slices.Contains(c.Connections, connection)
+	}
+	return false
 }
 
 // TaskType 任务类型
@@ -61,23 +91,39 @@ const (
 	TaskStatusSuccess  TaskStatus = "success"
 	TaskStatusFailed   TaskStatus = "failed"
 	TaskStatusCanceled TaskStatus = "canceled"
+	// 任务策略要求审批，审批通过后才下发到 Agent
+	TaskStatusPendingApproval TaskStatus = "pending_approval"
+)
+
+// 任务日志状态
+const (
+	TaskLogStateArchived = "archived" // 日志已压缩归档到存储后端
+	TaskLogStatePurged   = "purged"   // 日志已按保留策略删除
 )
 
 // Task 任务信息
 type Task struct {
 	ID         string     `json:"id" gorm:"primaryKey"`
-	AgentID    string     `json:"agent_id" gorm:"index;not null"`
-	Type       TaskType   `json:"type" gorm:"not null"`
-	Status     TaskStatus `json:"status" gorm:"default:'pending'"`
-	Command    string     `json:"command" gorm:"type:text"` // 执行的命令或脚本内容
-	Params     string     `json:"params" gorm:"type:text"`  // JSON 格式的参数
-	FileID     string     `json:"file_id" gorm:"index"`     // 关联的文件ID（如果有）
-	Result     string     `json:"result" gorm:"type:text"`  // 执行结果
-	Error      string     `json:"error" gorm:"type:text"`   // 错误信息
+	AgentID    string     `json:"agent_id" gorm:"index;index:idx_tasks_agent_created,priority:1;not null"`
+	Type       TaskType   `json:"type" gorm:"index:idx_tasks_type_created,priority:1;not null"`
+	Status     TaskStatus `json:"status" gorm:"index:idx_tasks_status_created,priority:1;default:'pending'"`
+	Command    string     `json:"command" gorm:"type:text"`                                     // 执行的命令或脚本内容
+	Params     string     `json:"params" gorm:"type:text"`                                      // JSON 格式的参数
+	FileID     string     `json:"file_id" gorm:"index"`                                         // 关联的文件ID（如果有）
+	Tags       []string   `json:"tags" gorm:"type:text;serializer:json"`                        // 任务标签
+	CreatedBy  string     `json:"created_by" gorm:"index:idx_tasks_creator_created,priority:1"` // 创建者
+	Result     string     `json:"result" gorm:"type:text"`                                      // 执行结果
+	Error      string     `json:"error" gorm:"type:text"`                                       // 错误信息
+	LogLines   int64      `json:"log_lines" gorm:"default:0"`                                   // 日志行数
+	LogState   string     `json:"log_state" gorm:"index;default:''"`                            // 日志状态：空表示在数据库中，archived 已归档到存储，purged 已删除
+	LogArchive string     `json:"log_archive"`                                                  // 日志归档在存储后端中的对象 key
+	PolicyRule string     `json:"policy_rule,omitempty"`                                        // 要求审批的策略规则
+	ApprovedBy string     `json:"approved_by,omitempty"`                                        // 审批人
+	Job        *JobStatus `json:"job,omitempty" gorm:"type:text;serializer:json"`               // 后台任务最近上报的状态
 	StartedAt  *time.Time `json:"started_at"`
 	FinishedAt *time.Time `json:"finished_at"`
-	CreatedAt  time.Time  `json:"created_at"`
-	UpdatedAt  time.Time  `json:"updated_at"`
+	CreatedAt  time.Time  `json:"created_at" gorm:"index:idx_tasks_created,priority:1;index:idx_tasks_agent_created,priority:2;index:idx_tasks_type_created,priority:2;index:idx_tasks_status_created,priority:2;index:idx_tasks_creator_created,priority:2"`
+	UpdatedAt  time.Time  `json:"updated_at" gorm:"index"`
 }
 
 // Log 日志记录
@@ -86,24 +132,194 @@ type Log struct {
 	TaskID    string    `json:"task_id" gorm:"index;not null"`
 	Level     string    `json:"level"` // info, error, warn, debug
 	Message   string    `json:"message" gorm:"type:text"`
-	Timestamp time.Time `json:"timestamp"`
+	Timestamp time.Time `json:"timestamp" gorm:"index"`
 }
 
 // File 文件信息
 type File struct {
-	ID          string    `json:"id" gorm:"primaryKey"`
-	Name        string    `json:"name" gorm:"not null"`
-	Path        string    `json:"path" gorm:"not null"` // 存储路径
-	Size        int64     `json:"size"`
-	ContentType string    `json:"content_type"`
-	MD5         string    `json:"md5" gorm:"index"` // 文件MD5，用于去重
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID          string     `json:"id" gorm:"primaryKey"`
+	Name        string     `json:"name" gorm:"not null"`
+	Path        string     `json:"path" gorm:"not null"` // 存储路径
+	Size        int64      `json:"size"`
+	ContentType string     `json:"content_type"`
+	MD5         string     `json:"md5" gorm:"index"`                      // 文件MD5，用于去重
+	SHA256      string     `json:"sha256" gorm:"index"`                   // 文件SHA-256，Agent 下载后校验
+	Backend     string     `json:"backend"`                               // 存储后端：local、s3，为空表示旧版本直接存储在 Path
+	StorageKey  string     `json:"storage_key" gorm:"index"`              // 存储后端中的对象 key，多个文件记录可共享同一个对象
+	Tags        []string   `json:"tags" gorm:"type:text;serializer:json"` // 文件标签
+	ExpiresAt   *time.Time `json:"expires_at" gorm:"index"`               // 过期时间（保留策略），为空表示永久保留
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
 }
 
 // TaskFile 任务文件关联表
 type TaskFile struct {
-	ID     uint   `json:"id" gorm:"primaryKey"`
-	TaskID string `json:"task_id" gorm:"index;not null"`
-	FileID string `json:"file_id" gorm:"index;not null"`
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	TaskID    string    `json:"task_id" gorm:"index;not null"`
+	FileID    string    `json:"file_id" gorm:"index;not null"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// FileDistribution 文件分发记录：记录文件分发到了哪些 Agent
+type FileDistribution struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	FileID     string     `json:"file_id" gorm:"index;not null"`
+	AgentID    string     `json:"agent_id" gorm:"index;not null"`
+	TaskID     string     `json:"task_id" gorm:"index"` // 分发任务 ID，Agent 不在线时为空
+	TargetPath string     `json:"target_path"`
+	Status     TaskStatus `json:"status"`
+	Error      string     `json:"error" gorm:"type:text"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// 审计事件类型
+const (
+	AuditActionTaskSubmitted      = "task.submitted"      // 提交任务
+	AuditActionTaskCanceled       = "task.canceled"       // 取消任务
+	AuditActionTaskCompleted      = "task.completed"      // 任务结束
+	AuditActionApproval           = "approval"            // 审批决定
+	AuditActionCommandAttempt     = "command.attempt"     // Agent 命令通过安全校验，开始执行
+	AuditActionCommandResult      = "command.result"      // Agent 命令执行结果
+	AuditActionValidationRejected = "validation.rejected" // 插件安全校验拒绝
+	AuditActionPluginAudit        = "plugin.audit"        // 插件记录的审计信息
+	AuditActionConfigPublished    = "config.published"    // 发布 Agent 远程配置
+	AuditActionConfigApplied      = "config.applied"      // Agent 应用远程配置的结果
+	AuditActionSecretUpdated      = "secret.updated"      // 创建或更新密钥库密钥
+	AuditActionSecretDeleted      = "secret.deleted"      // 删除密钥库密钥
+	AuditActionPolicyDenied       = "policy.denied"       // 任务策略拒绝任务
+)
+
+// 审计事件结果（任务结束时使用任务状态）
+const (
+	AuditOutcomeAllowed  = "allowed"
+	AuditOutcomeDenied   = "denied"
+	AuditOutcomeApproved = "approved"
+	AuditOutcomeRejected = "rejected"
+)
+
+// AuditEvent 审计事件
+// 只追加不修改：ID 为从 1 开始连续递增的序号，Hash 由事件内容和上一条事件的 Hash 计算，
+// 任何修改、删除或插入都会使之后的哈希链校验失败
+type AuditEvent struct {
+	ID         uint64    `json:"id" gorm:"primaryKey;autoIncrement:false"`
+	Timestamp  time.Time `json:"timestamp" gorm:"index"`
+	Action     string    `json:"action" gorm:"type:varchar(64);index"`
+	Actor      string    `json:"actor" gorm:"type:varchar(255);index"` // 操作人（任务创建者、审批人）
+	Source     string    `json:"source" gorm:"type:varchar(16)"`       // 事件来源：cloud 或 agent
+	AgentID    string    `json:"agent_id" gorm:"type:varchar(255);index"`
+	TaskID     string    `json:"task_id" gorm:"type:varchar(255);index"`
+	TaskType   TaskType  `json:"task_type" gorm:"type:varchar(64)"`
+	Outcome    string    `json:"outcome" gorm:"type:varchar(32);index"`
+	Command    string    `json:"command" gorm:"type:text"`
+	Reason     string    `json:"reason" gorm:"type:text"`
+	DurationMs int64     `json:"duration_ms"`
+	PrevHash   string    `json:"prev_hash" gorm:"type:varchar(64)"`
+	Hash       string    `json:"hash" gorm:"type:varchar(64)"`
+}
+
+// 通知投递状态
+const (
+	NotificationStatusPending = "pending" // 等待投递或等待重试
+	NotificationStatusSuccess = "success"
+	NotificationStatusFailed  = "failed" // 重试次数用尽或不可重试的错误
+)
+
+// NotificationDelivery 通知投递记录：每个事件发往每个通知渠道的投递状态
+type NotificationDelivery struct {
+	ID            uint       `json:"id" gorm:"primaryKey"`
+	EventID       string     `json:"event_id" gorm:"type:varchar(64);index"`
+	EventType     string     `json:"event_type" gorm:"type:varchar(64);index"`
+	Rule          string     `json:"rule" gorm:"type:varchar(255)"`          // 匹配的通知规则
+	Channel       string     `json:"channel" gorm:"type:varchar(255);index"` // 通知渠道名称
+	ChannelType   string     `json:"channel_type" gorm:"type:varchar(32)"`   // webhook、slack、dingtalk、wecom
+	Status        string     `json:"status" gorm:"type:varchar(16);index:idx_notification_due,priority:1"`
+	Attempts      int        `json:"attempts"`      // 已尝试次数
+	ResponseCode  int        `json:"response_code"` // 最后一次投递的 HTTP 状态码
+	LastError     string     `json:"last_error" gorm:"type:text"`
+	Payload       string     `json:"payload" gorm:"type:text"` // 事件内容（JSON），投递时按渠道格式渲染
+	NextAttemptAt time.Time  `json:"next_attempt_at" gorm:"index:idx_notification_due,priority:2"`
+	DeliveredAt   *time.Time `json:"delivered_at"`
+	CreatedAt     time.Time  `json:"created_at" gorm:"index"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// Agent 连接事件类型
+const (
+	AgentConnEventConnected        = "connected"         // 注册成功
+	AgentConnEventDisconnected     = "disconnected"      // 连接断开
+	AgentConnEventHeartbeatTimeout = "heartbeat_timeout" // 心跳超时，由健康检查断开
+)
+
+// AgentConnectionEvent Agent 连接历史，用于排查和抖动检测
+type AgentConnectionEvent struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	AgentID   string    `json:"agent_id" gorm:"type:varchar(255);index:idx_agent_conn_events,priority:1;not null"`
+	Event     string    `json:"event" gorm:"type:varchar(32)"`
+	ReplicaID string    `json:"replica_id" gorm:"type:varchar(255)"`
+	Reason    string    `json:"reason,omitempty" gorm:"type:text"`
+	Timestamp time.Time `json:"timestamp" gorm:"index:idx_agent_conn_events,priority:2;index"`
+}
+
+// AgentTelemetrySample Agent 资源使用时间序列，保留较短时间用于趋势展示
+type AgentTelemetrySample struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	AgentID      string    `json:"agent_id" gorm:"type:varchar(255);index:idx_agent_telemetry,priority:1;not null"`
+	Timestamp    time.Time `json:"timestamp" gorm:"index:idx_agent_telemetry,priority:2;index"`
+	CPUPercent   float64   `json:"cpu_percent"`
+	MemPercent   float64   `json:"mem_percent"`
+	DiskPercent  float64   `json:"disk_percent"`
+	Load1        float64   `json:"load1"`
+	RunningTasks int       `json:"running_tasks"`
+}
+
+// Agent 远程配置类型
+const (
+	AgentConfigKindPlugins  = "plugins"  // 插件配置，格式同 configs/agent-plugins.yaml
+	AgentConfigKindSecurity = "security" // 安全配置，格式同 configs/agent-security.yaml
+)
+
+// Agent 远程配置作用范围，同时适用时优先级 tag > env > global
+const (
+	AgentConfigScopeGlobal = "global" // 所有 Agent
+	AgentConfigScopeEnv    = "env"    // 指定环境（K8s 集群名称）的 Agent
+	AgentConfigScopeTag    = "tag"    // 带有指定标签的 Agent
+)
+
+// AgentConfig Cloud 下发给 Agent 的插件或安全配置，同一类型和作用范围每次发布生成新版本
+type AgentConfig struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	Kind      string    `json:"kind" gorm:"type:varchar(16);uniqueIndex:idx_agent_config_version,priority:1"`
+	ScopeType string    `json:"scope_type" gorm:"type:varchar(16);uniqueIndex:idx_agent_config_version,priority:2"`
+	Scope     string    `json:"scope" gorm:"type:varchar(255);uniqueIndex:idx_agent_config_version,priority:3"` // 环境名或标签，global 时为空
+	Version   int       `json:"version" gorm:"uniqueIndex:idx_agent_config_version,priority:4"`
+	Content   string    `json:"content" gorm:"type:text"`         // YAML
+	Checksum  string    `json:"checksum" gorm:"type:varchar(64)"` // 内容的 SHA-256
+	Comment   string    `json:"comment" gorm:"type:text"`
+	CreatedBy string    `json:"created_by" gorm:"type:varchar(255)"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AgentConfigState Agent 最近一次上报的远程配置应用结果，每个 Agent 每种配置一条
+type AgentConfigState struct {
+	AgentID   string    `json:"agent_id" gorm:"primaryKey;type:varchar(255)"`
+	Kind      string    `json:"kind" gorm:"primaryKey;type:varchar(16)"`
+	ConfigID  uint      `json:"config_id"` // 0 表示使用本地配置文件
+	Version   int       `json:"version"`
+	Checksum  string    `json:"checksum" gorm:"type:varchar(64)"`
+	Status    string    `json:"status" gorm:"type:varchar(16)"` // 见 AgentConfigStatus* 常量
+	Error     string    `json:"error,omitempty" gorm:"type:text"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Secret Cloud 密钥库中加密保存的密钥，值不会通过 API 返回
+type Secret struct {
+	Name         string    `json:"name" gorm:"primaryKey;type:varchar(255)"`
+	Env          string    `json:"env" gorm:"type:varchar(255)"`                   // 只下发给该环境的 Agent，为空时下发给全部 Agent
+	AllowedHosts []string  `json:"allowed_hosts" gorm:"type:text;serializer:json"` // 允许在任务 target 中使用的主机，为空时只能用于插件配置
+	Description  string    `json:"description" gorm:"type:text"`
+	Ciphertext   []byte    `json:"-"` // AES-GCM 加密的值，前 12 字节为 nonce
+	UpdatedBy    string    `json:"updated_by" gorm:"type:varchar(255)"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
 }
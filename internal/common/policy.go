@@ -0,0 +1,486 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+// 策略结果
+const (
+	PolicyEffectAllow           = "allow"
+	PolicyEffectDeny            = "deny"
+	PolicyEffectRequireApproval = "require_approval"
+)
+
+// PolicyRuleDefault 没有规则匹配时决策中的规则名称
+const PolicyRuleDefault = "default"
+
+// PolicyProfileNone 未指定 params.profile 的 Shell 任务在策略中的执行配置名称
+const PolicyProfileNone = "none"
+
+// SQL 语句类别，按风险从低到高排列
+const (
+	SQLClassRead  = "read"  // SELECT、SHOW、EXPLAIN 等
+	SQLClassWrite = "write" // INSERT、UPDATE、DELETE 等
+	SQLClassDDL   = "ddl"   // CREATE、ALTER、DROP、TRUNCATE 等
+	SQLClassAdmin = "admin" // GRANT、REVOKE、KILL、SET 等
+	SQLClassOther = "other" // 无法识别的语句
+)
+
+// sqlClassOrder SQL 类别的风险顺序，多条语句取风险最高的类别
+var sqlClassOrder = []string{SQLClassRead, SQLClassWrite, SQLClassDDL, SQLClassAdmin, SQLClassOther}
+
+// sqlKeywordClasses 语句首个关键字对应的类别
+var sqlKeywordClasses = map[string]string{
+	"SELECT": SQLClassRead, "SHOW": SQLClassRead, "DESCRIBE": SQLClassRead, "DESC": SQLClassRead,
+	"EXPLAIN": SQLClassRead, "WITH": SQLClassRead, "VALUES": SQLClassRead, "TABLE": SQLClassRead, "USE": SQLClassRead,
+	"INSERT": SQLClassWrite, "UPDATE": SQLClassWrite, "DELETE": SQLClassWrite, "REPLACE": SQLClassWrite,
+	"MERGE": SQLClassWrite, "UPSERT": SQLClassWrite, "COPY": SQLClassWrite, "LOAD": SQLClassWrite,
+	"CREATE": SQLClassDDL, "ALTER": SQLClassDDL, "DROP": SQLClassDDL, "TRUNCATE": SQLClassDDL,
+	"RENAME": SQLClassDDL, "COMMENT": SQLClassDDL, "OPTIMIZE": SQLClassDDL,
+	"GRANT": SQLClassAdmin, "REVOKE": SQLClassAdmin, "KILL": SQLClassAdmin, "SET": SQLClassAdmin,
+	"FLUSH": SQLClassAdmin, "RESET": SQLClassAdmin, "SHUTDOWN": SQLClassAdmin, "SYSTEM": SQLClassAdmin,
+	"VACUUM": SQLClassAdmin, "REINDEX": SQLClassAdmin, "CLUSTER": SQLClassAdmin,
+}
+
+// sqlWriteKeywords WITH 等读语句中出现时说明实际是写操作的关键字
+var sqlWriteKeywords = []string{"INSERT", "UPDATE", "DELETE", "MERGE"}
+
+// sqlTaskTypes 命令为 SQL 语句的任务类型
+var sqlTaskTypes = []TaskType{TaskTypeMySQL, TaskTypePostgres, TaskTypeClickHouse, TaskTypeDoris, TaskTypeSQL}
+
+// PolicyConfig 任务策略
+// 规则按顺序匹配，第一条匹配的规则决定结果；没有规则匹配时使用 Default
+type PolicyConfig struct {
+	// 没有规则匹配时的结果，默认 allow
+	Default string `yaml:"default" json:"default,omitempty"`
+	// 角色 -> 用户（任务的 created_by），一个用户可以有多个角色
+	Roles map[string][]string `yaml:"roles" json:"roles,omitempty"`
+	// 不在 roles 中的用户的角色
+	DefaultRole string       `yaml:"default_role" json:"default_role,omitempty"`
+	Rules       []PolicyRule `yaml:"rules" json:"rules,omitempty"`
+}
+
+// PolicyRule 策略规则
+// 条件为空时不限制，所有条件都满足时规则匹配；条件中的值支持 * 和 ? 通配
+type PolicyRule struct {
+	Name   string `yaml:"name" json:"name"`
+	Effect string `yaml:"effect" json:"effect"` // allow、deny 或 require_approval
+	Reason string `yaml:"reason" json:"reason,omitempty"`
+
+	Roles      []string `yaml:"roles" json:"roles,omitempty"`             // 提交者的角色
+	Users      []string `yaml:"users" json:"users,omitempty"`             // 提交者
+	Envs       []string `yaml:"envs" json:"envs,omitempty"`               // Agent 环境
+	Tags       []string `yaml:"tags" json:"tags,omitempty"`               // Agent 带有其中任一标签
+	TaskTypes  []string `yaml:"task_types" json:"task_types,omitempty"`   // 任务类型
+	Operations []string `yaml:"operations" json:"operations,omitempty"`   // params.operation，如 k8s 的 delete
+	SQLClasses []string `yaml:"sql_classes" json:"sql_classes,omitempty"` // SQL 语句类别，只匹配 SQL 任务
+	Namespaces []string `yaml:"namespaces" json:"namespaces,omitempty"`   // k8s 命名空间，只匹配 k8s 任务
+	Kinds      []string `yaml:"kinds" json:"kinds,omitempty"`             // k8s 资源类型，只匹配 k8s 任务
+	Profiles   []string `yaml:"profiles" json:"profiles,omitempty"`       // Shell 执行配置（params.profile），未指定时为 none，只匹配 Shell 任务
+
+	// require_approval 时可以审批的角色，为空时除提交者外的任何人都可以审批
+	Approvers []string `yaml:"approvers" json:"approvers,omitempty"`
+}
+
+// PolicyInput 策略评估的输入
+type PolicyInput struct {
+	User     string
+	Env      string
+	Tags     []string
+	TaskType TaskType
+	Command  string
+	Params   map[string]interface{}
+}
+
+// PolicyFacts 从任务中提取的参与匹配的属性
+type PolicyFacts struct {
+	User       string   `json:"user,omitempty"`
+	Roles      []string `json:"roles,omitempty"`
+	Env        string   `json:"env,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+	TaskType   TaskType `json:"task_type"`
+	Operation  string   `json:"operation,omitempty"`
+	SQLClass   string   `json:"sql_class,omitempty"`
+	Namespaces []string `json:"namespaces,omitempty"`
+	Kinds      []string `json:"kinds,omitempty"`
+	Profile    string   `json:"profile,omitempty"`
+}
+
+// PolicyDecision 策略评估结果
+type PolicyDecision struct {
+	Effect    string            `json:"effect"`
+	Rule      string            `json:"rule"` // 决定结果的规则名称，没有规则匹配时为 default
+	Reason    string            `json:"reason,omitempty"`
+	Approvers []string          `json:"approvers,omitempty"`
+	Facts     PolicyFacts       `json:"facts"`
+	Trace     []PolicyRuleTrace `json:"trace,omitempty"` // 依次评估的规则及不匹配的原因
+}
+
+// PolicyRuleTrace 单条规则的评估结果
+type PolicyRuleTrace struct {
+	Rule    string `json:"rule"`
+	Matched bool   `json:"matched"`
+	Reason  string `json:"reason,omitempty"` // 不匹配的条件
+}
+
+// PolicyEngine 任务策略引擎
+// 方法可以在 nil 上调用，此时允许所有任务
+type PolicyEngine struct {
+	config    PolicyConfig
+	userRoles map[string][]string
+}
+
+// NewPolicyEngine 校验并创建策略引擎，cfg 为 nil 或没有规则时允许所有任务
+func NewPolicyEngine(cfg *PolicyConfig) (*PolicyEngine, error) {
+	if cfg == nil {
+		cfg = &PolicyConfig{}
+	}
+	if cfg.Default != "" && !validEffect(cfg.Default) {
+		return nil, fmt.Errorf("invalid default policy effect %q", cfg.Default)
+	}
+
+	names := make(map[string]bool, len(cfg.Rules))
+	for i, rule := range cfg.Rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("policy rule %d has no name", i)
+		}
+		if names[rule.Name] || rule.Name == PolicyRuleDefault {
+			return nil, fmt.Errorf("duplicate policy rule name %q", rule.Name)
+		}
+		names[rule.Name] = true
+		if !validEffect(rule.Effect) {
+			return nil, fmt.Errorf("policy rule %q: invalid effect %q, must be allow, deny or require_approval", rule.Name, rule.Effect)
+		}
+		for _, class := range rule.SQLClasses {
+			if !slices.Contains(sqlClassOrder, class) {
+				return nil, fmt.Errorf("policy rule %q: invalid sql class %q, available: %s", rule.Name, class, strings.Join(sqlClassOrder, ", "))
+			}
+		}
+		for _, patterns := range [][]string{rule.Roles, rule.Users, rule.Envs, rule.Tags, rule.TaskTypes, rule.Operations, rule.Namespaces, rule.Kinds, rule.Profiles, rule.Approvers} {
+			for _, pattern := range patterns {
+				if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+					return nil, fmt.Errorf("policy rule %q: invalid pattern %q", rule.Name, pattern)
+				}
+			}
+		}
+	}
+
+	e := &PolicyEngine{config: *cfg, userRoles: make(map[string][]string)}
+	for role, users := range cfg.Roles {
+		for _, user := range users {
+			e.userRoles[user] = append(e.userRoles[user], role)
+		}
+	}
+	for _, roles := range e.userRoles {
+		slices.Sort(roles)
+	}
+	return e, nil
+}
+
+// validEffect 是否为有效的策略结果
+func validEffect(effect string) bool {
+	return effect == PolicyEffectAllow || effect == PolicyEffectDeny || effect == PolicyEffectRequireApproval
+}
+
+// RolesOf 返回用户的角色
+func (e *PolicyEngine) RolesOf(user string) []string {
+	if e == nil {
+		return nil
+	}
+	if roles, ok := e.userRoles[user]; ok && user != "" {
+		return roles
+	}
+	if e.config.DefaultRole != "" {
+		return []string{e.config.DefaultRole}
+	}
+	return nil
+}
+
+// Evaluate 评估任务，返回结果和匹配过程
+func (e *PolicyEngine) Evaluate(in *PolicyInput) *PolicyDecision {
+	facts := e.facts(in)
+	decision := &PolicyDecision{Effect: PolicyEffectAllow, Rule: PolicyRuleDefault, Facts: facts}
+	if e == nil {
+		return decision
+	}
+
+	for _, rule := range e.config.Rules {
+		mismatch := rule.mismatch(&facts)
+		decision.Trace = append(decision.Trace, PolicyRuleTrace{Rule: rule.Name, Matched: mismatch == "", Reason: mismatch})
+		if mismatch != "" {
+			continue
+		}
+		decision.Effect = rule.Effect
+		decision.Rule = rule.Name
+		decision.Reason = rule.Reason
+		decision.Approvers = rule.Approvers
+		return decision
+	}
+	if e.config.Default != "" {
+		decision.Effect = e.config.Default
+	}
+	if decision.Effect != PolicyEffectAllow {
+		decision.Reason = "no policy rule matched"
+	}
+	return decision
+}
+
+// CheckApprover 检查 approver 能否审批 requester 提交的、由 ruleName 要求审批的任务
+// 提交者不能审批自己的任务；规则配置了 approvers 时审批人必须有其中的角色
+func (e *PolicyEngine) CheckApprover(ruleName, requester, approver string) error {
+	if approver == "" {
+		return fmt.Errorf("approver is required")
+	}
+	if requester != "" && approver == requester {
+		return fmt.Errorf("%s cannot approve their own task", approver)
+	}
+	if e == nil {
+		return nil
+	}
+	for _, rule := range e.config.Rules {
+		if rule.Name != ruleName || len(rule.Approvers) == 0 {
+			continue
+		}
+		if !matchAny(rule.Approvers, e.RolesOf(approver)) {
+			return fmt.Errorf("%s is not an approver for policy rule %q (approver roles: %s)", approver, ruleName, strings.Join(rule.Approvers, ", "))
+		}
+	}
+	return nil
+}
+
+// facts 提取任务的匹配属性
+func (e *PolicyEngine) facts(in *PolicyInput) PolicyFacts {
+	facts := PolicyFacts{
+		User:     in.User,
+		Roles:    e.RolesOf(in.User),
+		Env:      in.Env,
+		Tags:     in.Tags,
+		TaskType: in.TaskType,
+	}
+	facts.Operation, _ = in.Params["operation"].(string)
+	facts.Operation = strings.ToLower(facts.Operation)
+	if slices.Contains(sqlTaskTypes, in.TaskType) {
+		facts.SQLClass = ClassifySQL(in.Command)
+	}
+	if in.TaskType == TaskTypeK8s {
+		facts.Namespaces, facts.Kinds = K8sTargets(in.Command, in.Params)
+	}
+	if in.TaskType == TaskTypeShell {
+		facts.Profile, _ = in.Params["profile"].(string)
+		if facts.Profile == "" {
+			facts.Profile = PolicyProfileNone
+		}
+	}
+	return facts
+}
+
+// mismatch 返回规则第一个不满足的条件，规则匹配时返回空字符串
+func (r *PolicyRule) mismatch(f *PolicyFacts) string {
+	// allow 规则要求多个资源全部满足条件，deny 和 require_approval 规则任一资源满足即可
+	all := r.Effect == PolicyEffectAllow
+	switch {
+	case len(r.Users) > 0 && !matchAny(r.Users, []string{f.User}):
+		return fmt.Sprintf("user %q not in %v", f.User, r.Users)
+	case len(r.Roles) > 0 && !matchAny(r.Roles, f.Roles):
+		return fmt.Sprintf("roles %v not in %v", f.Roles, r.Roles)
+	case len(r.Envs) > 0 && !matchAny(r.Envs, []string{f.Env}):
+		return fmt.Sprintf("env %q not in %v", f.Env, r.Envs)
+	case len(r.Tags) > 0 && !matchAny(r.Tags, f.Tags):
+		return fmt.Sprintf("tags %v not in %v", f.Tags, r.Tags)
+	case len(r.TaskTypes) > 0 && !matchAny(r.TaskTypes, []string{string(f.TaskType)}):
+		return fmt.Sprintf("task type %q not in %v", f.TaskType, r.TaskTypes)
+	case len(r.Operations) > 0 && !matchAny(r.Operations, []string{f.Operation}):
+		return fmt.Sprintf("operation %q not in %v", f.Operation, r.Operations)
+	case len(r.SQLClasses) > 0 && !slices.Contains(r.SQLClasses, f.SQLClass):
+		return fmt.Sprintf("sql class %q not in %v", f.SQLClass, r.SQLClasses)
+	case len(r.Namespaces) > 0 && !matchValues(r.Namespaces, f.Namespaces, all):
+		return fmt.Sprintf("namespaces %v not in %v", f.Namespaces, r.Namespaces)
+	case len(r.Kinds) > 0 && !matchValues(r.Kinds, f.Kinds, all):
+		return fmt.Sprintf("kinds %v not in %v", f.Kinds, r.Kinds)
+	case len(r.Profiles) > 0 && (f.Profile == "" || !matchAny(r.Profiles, []string{f.Profile})):
+		return fmt.Sprintf("profile %q not in %v", f.Profile, r.Profiles)
+	}
+	return ""
+}
+
+// matchAny 任一值匹配任一模式
+func matchAny(patterns, values []string) bool {
+	return matchValues(patterns, values, false)
+}
+
+// matchValues 值是否匹配模式（不区分大小写），all 为 true 时要求所有值都匹配，没有值时不匹配
+func matchValues(patterns, values []string, all bool) bool {
+	if len(values) == 0 {
+		return false
+	}
+	for _, value := range values {
+		matched := slices.ContainsFunc(patterns, func(pattern string) bool {
+			ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(value))
+			return ok
+		})
+		if matched && !all {
+			return true
+		}
+		if !matched && all {
+			return false
+		}
+	}
+	return all
+}
+
+// ClassifySQL 返回 SQL 的语句类别，多条语句时返回风险最高的类别
+func ClassifySQL(sql string) string {
+	class := ""
+	for _, stmt := range splitSQL(sql) {
+		fields := strings.Fields(strings.ToUpper(stmt))
+		if len(fields) == 0 {
+			continue
+		}
+		keyword := strings.TrimLeft(fields[0], "(")
+		c, ok := sqlKeywordClasses[keyword]
+		if !ok {
+			c = SQLClassOther
+		}
+		if c == SQLClassRead && keyword == "WITH" && slices.ContainsFunc(fields, func(f string) bool { return slices.Contains(sqlWriteKeywords, f) }) {
+			c = SQLClassWrite
+		}
+		if slices.Index(sqlClassOrder, c) > slices.Index(sqlClassOrder, class) {
+			class = c
+		}
+	}
+	if class == "" {
+		return SQLClassOther
+	}
+	return class
+}
+
+// splitSQL 去掉注释并按分号拆分语句，引号中的内容替换为空字符串
+func splitSQL(sql string) []string {
+	var stmts []string
+	var b strings.Builder
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end := strings.IndexByte(sql[i+1:], c)
+			if end < 0 {
+				i = len(sql)
+			} else {
+				i += end + 1
+			}
+			b.WriteString("''")
+		case c == '-' && strings.HasPrefix(sql[i:], "--"), c == '#':
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				i = len(sql)
+			} else {
+				i += end
+			}
+			b.WriteByte(' ')
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				i = len(sql)
+			} else {
+				i += end + 3
+			}
+			b.WriteByte(' ')
+		case c == ';':
+			stmts = append(stmts, b.String())
+			b.Reset()
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return append(stmts, b.String())
+}
+
+// K8sTargets 返回 k8s 任务操作的命名空间和资源类型
+// 命令为 Kind/Name 形式的资源引用或 YAML/JSON 资源清单；params.namespace 优先于清单中的命名空间，都未指定时为 default
+func K8sTargets(command string, params map[string]interface{}) ([]string, []string) {
+	var namespaces, kinds []string
+	operation, _ := params["operation"].(string)
+	switch strings.ToLower(operation) {
+	case "logs":
+		kinds = []string{"Pod"}
+	case "events":
+		kinds = []string{"Event"}
+	default:
+		kinds, namespaces = k8sManifestTargets(strings.TrimSpace(command))
+	}
+
+	if ns, ok := params["namespace"].(string); ok && ns != "" {
+		namespaces = []string{ns}
+	}
+	if len(namespaces) == 0 {
+		namespaces = []string{"default"}
+	}
+	return slices.Compact(namespaces), kinds
+}
+
+// k8sManifestTargets 从资源引用、JSON 或 YAML 清单中提取资源类型和命名空间
+func k8sManifestTargets(command string) (kinds, namespaces []string) {
+	add := func(kind, namespace string) {
+		if kind != "" && !slices.Contains(kinds, kind) {
+			kinds = append(kinds, kind)
+		}
+		if namespace != "" && !slices.Contains(namespaces, namespace) {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+
+	if strings.HasPrefix(command, "{") {
+		var obj struct {
+			Kind     string `json:"kind"`
+			Metadata struct {
+				Namespace string `json:"namespace"`
+			} `json:"metadata"`
+			Items []struct {
+				Kind     string `json:"kind"`
+				Metadata struct {
+					Namespace string `json:"namespace"`
+				} `json:"metadata"`
+			} `json:"items"`
+		}
+		if json.Unmarshal([]byte(command), &obj) == nil {
+			add(obj.Kind, obj.Metadata.Namespace)
+			for _, item := range obj.Items {
+				add(item.Kind, item.Metadata.Namespace)
+			}
+		}
+		return kinds, namespaces
+	}
+
+	if kind, _, ok := strings.Cut(command, "/"); ok && !strings.ContainsAny(command, ":\n") {
+		add(strings.TrimSpace(kind), "")
+		return kinds, namespaces
+	}
+
+	// YAML 清单：顶层的 kind 和 metadata 下一级的 namespace
+	inMetadata := false
+	for _, line := range strings.Split(command, "\n") {
+		trimmed := strings.TrimSpace(line)
+		indented := len(line) > 0 && (line[0] == ' ' || line[0] == '\t')
+		switch {
+		case trimmed == "---":
+			inMetadata = false
+		case !indented && strings.HasPrefix(trimmed, "kind:"):
+			add(yamlScalar(trimmed[len("kind:"):]), "")
+		case !indented:
+			inMetadata = strings.HasPrefix(trimmed, "metadata:")
+		case inMetadata && strings.HasPrefix(trimmed, "namespace:"):
+			add("", yamlScalar(trimmed[len("namespace:"):]))
+		}
+	}
+	return kinds, namespaces
+}
+
+// yamlScalar 去掉 YAML 标量值两侧的空白、引号和行尾注释
+func yamlScalar(s string) string {
+	s, _, _ = strings.Cut(s, " #")
+	return strings.Trim(strings.TrimSpace(s), `"'`)
+}
@@ -22,6 +22,21 @@ const (
 	MessageTypeFileDownload   MessageType = "file.download"
 	MessageTypeFileDistribute MessageType = "file.distribute"
 
+	// 审计相关消息
+	MessageTypeAuditEvent MessageType = "audit.event"
+
+	// 远程配置相关消息
+	MessageTypeConfigUpdate  MessageType = "config.update"  // Cloud 下发插件或安全配置
+	MessageTypeConfigApplied MessageType = "config.applied" // Agent 上报配置应用结果
+
+	// 密钥相关消息
+	MessageTypeSecretsSync MessageType = "secrets.sync" // Cloud 下发适用于 Agent 的密钥库密钥（全量替换）
+
+	// 后台任务相关消息
+	MessageTypeJobStatus MessageType = "job.status" // Agent 上报后台任务的状态和进度
+	MessageTypeJobSync   MessageType = "job.sync"   // Agent 连接后上报正在管理的全部后台任务
+	MessageTypeJobAck    MessageType = "job.ack"    // Cloud 确认已保存后台任务的结果，Agent 收到后删除任务目录
+
 	// 错误消息
 	MessageTypeError MessageType = "error"
 )
@@ -38,13 +53,51 @@ type Message struct {
 
 // AgentRegisterData Agent 注册数据
 type AgentRegisterData struct {
-	AgentID  string            `json:"agent_id"`
-	Name     string            `json:"name"`
-	Hostname string            `json:"hostname"`
-	IP       string            `json:"ip"`
-	Version  string            `json:"version"`
-	Env      string            `json:"env,omitempty"` // K8s 集群名称
-	Metadata map[string]string `json:"metadata,omitempty"`
+	AgentID      string            `json:"agent_id"`
+	Name         string            `json:"name"`
+	Hostname     string            `json:"hostname"`
+	IP           string            `json:"ip"`
+	Version      string            `json:"version"`
+	Env          string            `json:"env,omitempty"` // K8s 集群名称
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	Capabilities []AgentCapability `json:"capabilities,omitempty"` // 已注册的执行器，旧版本 Agent 不上报
+}
+
+// AgentCapability Agent 已注册的执行器及其配置的连接
+type AgentCapability struct {
+	Type        TaskType `json:"type"`
+	Connections []string `json:"connections,omitempty"` // 插件配置中的连接名称
+	Version     string   `json:"version,omitempty"`     // 执行器使用的客户端库版本
+}
+
+// AgentHeartbeatData Agent 心跳数据
+type AgentHeartbeatData struct {
+	AgentID   string          `json:"agent_id"`
+	Telemetry *AgentTelemetry `json:"telemetry,omitempty"` // 主机资源快照，旧版本 Agent 不上报
+}
+
+// AgentTelemetry Agent 所在主机的资源使用和 Agent 运行状态快照
+// 容量和用量单位为字节，百分比范围 0-100，采集失败的项为零值
+type AgentTelemetry struct {
+	OS           string     `json:"os"`
+	Arch         string     `json:"arch"`
+	CPUCores     int        `json:"cpu_cores"`
+	CPUPercent   float64    `json:"cpu_percent"` // 距上次采集的平均 CPU 使用率
+	MemTotal     uint64     `json:"mem_total"`
+	MemUsed      uint64     `json:"mem_used"`
+	MemPercent   float64    `json:"mem_percent"`
+	DiskPath     string     `json:"disk_path"` // 统计磁盘用量的挂载路径
+	DiskTotal    uint64     `json:"disk_total"`
+	DiskUsed     uint64     `json:"disk_used"`
+	DiskPercent  float64    `json:"disk_percent"`
+	Load1        float64    `json:"load1"`
+	Load5        float64    `json:"load5"`
+	Load15       float64    `json:"load15"`
+	Uptime       int64      `json:"uptime"`       // 主机运行时长（秒）
+	AgentUptime  int64      `json:"agent_uptime"` // Agent 进程运行时长（秒）
+	RunningTasks int        `json:"running_tasks"`
+	Executors    []TaskType `json:"executors"`    // 已注册的执行器类型
+	CollectedAt  int64      `json:"collected_at"` // 采集时间（Unix 秒）
 }
 
 // TaskCreateData 任务创建数据
@@ -54,6 +107,12 @@ type TaskCreateData struct {
 	Command string                 `json:"command"`
 	Params  map[string]interface{} `json:"params,omitempty"`
 	FileID  string                 `json:"file_id,omitempty"`
+	// TraceContext W3C 链路上下文（traceparent、tracestate 等），未启用链路追踪时为空
+	TraceContext map[string]string `json:"trace_context,omitempty"`
+	// 提交者、审批人和 Agent 标签，Agent 据此再次评估任务策略
+	CreatedBy  string   `json:"created_by,omitempty"`
+	ApprovedBy string   `json:"approved_by,omitempty"`
+	AgentTags  []string `json:"agent_tags,omitempty"`
 }
 
 // TaskLogData 任务日志数据
@@ -71,6 +130,91 @@ type TaskCompleteData struct {
 	Result    string     `json:"result,omitempty"`
 	Error     string     `json:"error,omitempty"`
 	Timestamp int64      `json:"timestamp"`
+	Detached  bool       `json:"detached,omitempty"` // 后台任务的结果，Cloud 保存后回复 job.ack
+}
+
+// AuditEventData Agent 上报的审计事件，Cloud 补充 Agent ID 和操作人后写入审计表
+type AuditEventData struct {
+	TaskID     string   `json:"task_id,omitempty"`
+	TaskType   TaskType `json:"task_type,omitempty"`
+	Action     string   `json:"action"`            // 见 AuditAction* 常量
+	Outcome    string   `json:"outcome,omitempty"` // 见 AuditOutcome* 常量
+	Command    string   `json:"command,omitempty"`
+	Reason     string   `json:"reason,omitempty"` // 拒绝原因、错误信息或审计说明
+	DurationMs int64    `json:"duration_ms,omitempty"`
+	Timestamp  int64    `json:"timestamp"`
+}
+
+// Agent 远程配置应用状态
+const (
+	AgentConfigStatusApplied  = "applied"  // 已应用
+	AgentConfigStatusFailed   = "failed"   // 校验或应用失败，继续使用之前的配置
+	AgentConfigStatusDisabled = "disabled" // Agent 未启用远程配置（AGENT_REMOTE_CONFIG）
+)
+
+// AgentConfigData Cloud 下发的远程配置
+// ConfigID 为 0 且 Content 为空表示不再有适用的远程配置，Agent 恢复使用本地配置文件
+type AgentConfigData struct {
+	Kind     string `json:"kind"` // plugins 或 security
+	ConfigID uint   `json:"config_id"`
+	Version  int    `json:"version"`
+	Checksum string `json:"checksum"`
+	Content  string `json:"content"` // YAML，格式与本地配置文件相同
+}
+
+// AgentConfigAppliedData Agent 应用远程配置的结果
+type AgentConfigAppliedData struct {
+	Kind         string            `json:"kind"`
+	ConfigID     uint              `json:"config_id"`
+	Version      int               `json:"version"`
+	Checksum     string            `json:"checksum"`
+	Status       string            `json:"status"` // 见 AgentConfigStatus* 常量
+	Error        string            `json:"error,omitempty"`
+	Capabilities []AgentCapability `json:"capabilities,omitempty"` // 插件配置应用后的执行器能力
+}
+
+// SecretsSyncData Cloud 下发的密钥库密钥，Agent 用它们解析 secret:// 引用
+type SecretsSyncData struct {
+	Secrets []SecretValue `json:"secrets"`
+}
+
+// SecretValue 解密后的密钥
+type SecretValue struct {
+	Name         string   `json:"name"`
+	Value        string   `json:"value"`
+	AllowedHosts []string `json:"allowed_hosts,omitempty"` // 允许在任务 target 中使用该密钥的主机，为空时只能用于插件配置
+}
+
+// 后台任务状态
+const (
+	JobStateRunning = "running" // 命令正在运行
+	JobStateExited  = "exited"  // 命令已结束，可能还有日志未上报
+	JobStateLost    = "lost"    // 监护进程异常退出，无法获得命令的结束状态
+)
+
+// JobStatus Agent 上后台任务（Shell 任务 params.detach 为 true）的状态和进度
+type JobStatus struct {
+	TaskID      string `json:"task_id"`
+	State       string `json:"state"` // 见 JobState* 常量
+	PID         int    `json:"pid,omitempty"`
+	StartedAt   int64  `json:"started_at,omitempty"`
+	FinishedAt  int64  `json:"finished_at,omitempty"`
+	ExitCode    *int   `json:"exit_code,omitempty"`
+	Signal      string `json:"signal,omitempty"`
+	OutputBytes int64  `json:"output_bytes"`          // 已输出的字节数（stdout 和 stderr）
+	LastOutput  string `json:"last_output,omitempty"` // 最后一行非空输出
+	UpdatedAt   int64  `json:"updated_at"`
+}
+
+// JobSyncData Agent 连接后上报的后台任务，Cloud 据此重新关联任务、结束已取消的任务
+type JobSyncData struct {
+	Jobs      []JobStatus        `json:"jobs"`
+	Completed []TaskCompleteData `json:"completed,omitempty"` // 已上报但 Cloud 尚未确认的结果，Cloud 保存后回复 job.ack
+}
+
+// JobAckData Cloud 确认已保存结果的后台任务
+type JobAckData struct {
+	TaskID string `json:"task_id"`
 }
 
 // FileDistributeData 文件分发数据
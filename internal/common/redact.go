@@ -0,0 +1,177 @@
+package common
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// RedactionConfig 日志、结果和任务参数的脱敏规则
+// 内置规则默认全部启用，Agent 在上报前、Cloud 在保存前各执行一次
+type RedactionConfig struct {
+	// 关闭的内置规则名称，* 表示关闭全部内置规则
+	DisableBuiltin []string `yaml:"disable_builtin" json:"disable_builtin,omitempty"`
+	// 额外的关键字，替换 keyword=value、keyword: value 形式中的值
+	Keywords []string `yaml:"keywords" json:"keywords,omitempty"`
+	// 自定义正则规则
+	Rules []RedactionRule `yaml:"rules" json:"rules,omitempty"`
+}
+
+// RedactionRule 正则脱敏规则，Pattern 中有名为 secret 的捕获组时只替换该组，否则替换整个匹配
+type RedactionRule struct {
+	Name    string `yaml:"name" json:"name"`
+	Pattern string `yaml:"pattern" json:"pattern"`
+}
+
+// redactKeywordValue 关键字后的值：不以 / 开头，避免把 secret:// 引用当作 secret 关键字的值
+const redactKeywordValue = `["']?\s*[:=]\s*["']?(?P<secret>[^\s"'&,;/][^\s"'&,;]*)`
+
+// builtinRedactionRules 内置脱敏规则
+var builtinRedactionRules = []RedactionRule{
+	{Name: "aws_access_key", Pattern: `\b(?:AKIA|ASIA|ABIA|ACCA|A3T[A-Z0-9])[A-Z0-9]{16}\b`},
+	{Name: "aws_secret_key", Pattern: `(?i)aws_?secret_?access_?key["']?\s*[:=]\s*["']?(?P<secret>[A-Za-z0-9/+=]{40})`},
+	{Name: "jwt", Pattern: `\beyJ[A-Za-z0-9_-]{5,}\.eyJ[A-Za-z0-9_-]{5,}\.[A-Za-z0-9_-]{5,}`},
+	{Name: "bearer", Pattern: `(?i)\bbearer\s+(?P<secret>[A-Za-z0-9._~+/-]{8,}=*)`},
+	{Name: "basic_auth", Pattern: `(?i)authorization["']?\s*[:=]\s*["']?basic\s+(?P<secret>[A-Za-z0-9+/]+=*)`},
+	{Name: "dsn", Pattern: `(?i)\b[a-z][a-z0-9+.-]*://[^\s:/@]*:(?P<secret>[^\s@/]+)@`},
+	{Name: "private_key", Pattern: `-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`},
+	{Name: "keywords", Pattern: `(?i)(?:` + strings.Join(sensitiveKeys, "|") + `)` + redactKeywordValue},
+}
+
+// BuiltinRedactionRules 返回内置规则的名称
+func BuiltinRedactionRules() []string {
+	names := make([]string, len(builtinRedactionRules))
+	for i, rule := range builtinRedactionRules {
+		names[i] = rule.Name
+	}
+	return names
+}
+
+// Redactor 按规则把字符串中的敏感值替换为 RedactedValue
+// 方法可以在 nil 上调用，此时不做替换
+type Redactor struct {
+	rules []compiledRedactionRule
+}
+
+// compiledRedactionRule 编译后的规则，group 为要替换的捕获组序号，0 表示整个匹配
+type compiledRedactionRule struct {
+	re    *regexp.Regexp
+	group int
+}
+
+// NewRedactor 编译脱敏规则，cfg 为 nil 时只使用内置规则
+// 内置规则名称不存在、关键字为空或正则表达式无效时返回错误
+func NewRedactor(cfg *RedactionConfig) (*Redactor, error) {
+	if cfg == nil {
+		cfg = &RedactionConfig{}
+	}
+	builtins := BuiltinRedactionRules()
+	for _, name := range cfg.DisableBuiltin {
+		if name != "*" && !slices.Contains(builtins, name) {
+			return nil, fmt.Errorf("unknown builtin redaction rule %q, available: %s", name, strings.Join(builtins, ", "))
+		}
+	}
+
+	var rules []RedactionRule
+	if !slices.Contains(cfg.DisableBuiltin, "*") {
+		for _, rule := range builtinRedactionRules {
+			if !slices.Contains(cfg.DisableBuiltin, rule.Name) {
+				rules = append(rules, rule)
+			}
+		}
+	}
+	if len(cfg.Keywords) > 0 {
+		quoted := make([]string, len(cfg.Keywords))
+		for i, keyword := range cfg.Keywords {
+			if strings.TrimSpace(keyword) == "" {
+				return nil, fmt.Errorf("redaction keyword must not be empty")
+			}
+			quoted[i] = regexp.QuoteMeta(keyword)
+		}
+		rules = append(rules, RedactionRule{Name: "custom_keywords", Pattern: `(?i)(?:` + strings.Join(quoted, "|") + `)` + redactKeywordValue})
+	}
+	rules = append(rules, cfg.Rules...)
+
+	r := &Redactor{}
+	for _, rule := range rules {
+		if rule.Pattern == "" {
+			return nil, fmt.Errorf("redaction rule %q has an empty pattern", rule.Name)
+		}
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction rule %q: %w", rule.Name, err)
+		}
+		r.rules = append(r.rules, compiledRedactionRule{re: re, group: max(re.SubexpIndex("secret"), 0)})
+	}
+	return r, nil
+}
+
+// DefaultRedactor 返回只使用内置规则的脱敏器
+func DefaultRedactor() *Redactor {
+	r, _ := NewRedactor(nil)
+	return r
+}
+
+// Redact 返回脱敏后的字符串
+func (r *Redactor) Redact(s string) string {
+	if r == nil || s == "" {
+		return s
+	}
+	for _, rule := range r.rules {
+		s = rule.apply(s)
+	}
+	return s
+}
+
+// RedactValues 返回参数的副本，所有字符串值按规则脱敏
+func (r *Redactor) RedactValues(params map[string]interface{}) map[string]interface{} {
+	if r == nil || params == nil {
+		return params
+	}
+	redacted, _ := r.redactValue(params).(map[string]interface{})
+	return redacted
+}
+
+// redactValue 递归脱敏字符串值
+func (r *Redactor) redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			out[k] = r.redactValue(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = r.redactValue(item)
+		}
+		return out
+	case string:
+		return r.Redact(v)
+	}
+	return value
+}
+
+// apply 替换字符串中所有匹配的部分
+func (c *compiledRedactionRule) apply(s string) string {
+	matches := c.re.FindAllStringSubmatchIndex(s, -1)
+	if matches == nil {
+		return s
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		start, end := m[2*c.group], m[2*c.group+1]
+		// 已脱敏的值和密钥引用保持不变
+		if start < last || start == end || s[start:end] == RedactedValue || IsSecretRef(s[start:end]) {
+			continue
+		}
+		b.WriteString(s[last:start])
+		b.WriteString(RedactedValue)
+		last = end
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
@@ -0,0 +1,71 @@
+package common
+
+import (
+	"strings"
+)
+
+// SecretRefPrefix 密钥引用的前缀，如 secret://pg-prod，由 Agent 在执行前解析为实际值
+const SecretRefPrefix = "secret://"
+
+// RedactedValue 脱敏后的占位值
+const RedactedValue = "******"
+
+// sensitiveKeys 参数中值需要脱敏的字段名（小写比较，包含即匹配）
+var sensitiveKeys = []string{"password", "passwd", "secret", "token", "api_key", "apikey", "access_key", "private_key", "credential"}
+
+// IsSecretRef 判断字符串是否为密钥引用
+func IsSecretRef(s string) bool {
+	return strings.HasPrefix(s, SecretRefPrefix) && len(s) > len(SecretRefPrefix)
+}
+
+// SecretRefName 返回密钥引用中的密钥名，不是引用时返回空字符串
+func SecretRefName(s string) string {
+	if !IsSecretRef(s) {
+		return ""
+	}
+	return strings.TrimPrefix(s, SecretRefPrefix)
+}
+
+// IsSensitiveKey 判断参数字段名是否表示密码、令牌等敏感值
+func IsSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, k := range sensitiveKeys {
+		if strings.Contains(key, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactParams 返回参数的副本，敏感字段中的明文值替换为占位值，密钥引用保持不变
+// 用于持久化和展示任务参数，下发给 Agent 的仍是原始参数
+func RedactParams(params map[string]interface{}) map[string]interface{} {
+	if params == nil {
+		return nil
+	}
+	redacted, _ := redactValue("", params).(map[string]interface{})
+	return redacted
+}
+
+// redactValue 递归脱敏 key 对应的值
+func redactValue(key string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			out[k] = redactValue(k, item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = redactValue(key, item)
+		}
+		return out
+	case string:
+		if v != "" && key != "" && IsSensitiveKey(key) && !IsSecretRef(v) {
+			return RedactedValue
+		}
+	}
+	return value
+}
@@ -3,6 +3,7 @@ package common
 import (
 	"encoding/json"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -22,6 +23,14 @@ const (
 	maxMessageSize = 512 * 1024 // 512KB
 )
 
+// droppedMessages 发送缓冲区已满被丢弃的消息数（进程内所有连接）
+var droppedMessages atomic.Uint64
+
+// DroppedMessages 返回因发送缓冲区已满被丢弃的消息总数
+func DroppedMessages() uint64 {
+	return droppedMessages.Load()
+}
+
 // WSConnection WebSocket 连接封装
 type WSConnection struct {
 	conn     *websocket.Conn
@@ -94,6 +103,8 @@ func (ws *WSConnection) WriteMessage(msg *Message) error {
 	case <-ws.done:
 		return websocket.ErrCloseSent
 	default:
+		// 发送缓冲区已满
+		droppedMessages.Add(1)
 		return websocket.ErrCloseSent
 	}
 }
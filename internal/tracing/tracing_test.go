@@ -0,0 +1,81 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestPropagationAcrossMessage(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := SetupWithExporter("test", exporter)
+
+	// Cloud：创建任务并将链路上下文写入消息
+	ctx, parent := Start(context.Background(), "task.dispatch")
+	carrier := Inject(ctx)
+	parent.End()
+	if carrier["traceparent"] == "" {
+		t.Fatalf("carrier = %v, want traceparent", carrier)
+	}
+
+	// Agent：从消息恢复链路上下文
+	agentCtx, child := Start(Extract(context.Background(), carrier), "agent.task")
+	child.End()
+	if got := trace.SpanContextFromContext(agentCtx).TraceID(); got != parent.SpanContext().TraceID() {
+		t.Errorf("trace id = %s, want %s", got, parent.SpanContext().TraceID())
+	}
+
+	// 没有链路上下文时不写入任何内容
+	if got := Inject(context.Background()); got != nil {
+		t.Errorf("Inject without span = %v, want nil", got)
+	}
+
+	if err := tp.ForceFlush(context.Background()); err != nil {
+		t.Fatalf("ForceFlush failed: %v", err)
+	}
+	spans := exporter.GetSpans()
+	if len(spans) != 2 || spans[1].Parent.SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("spans = %+v", spans.Snapshots())
+	}
+}
+
+func TestTransport(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := SetupWithExporter("test", exporter)
+
+	var header string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	ctx, parent := Start(context.Background(), "plugin.mysql")
+	req, _ := http.NewRequestWithContext(ctx, "POST", srv.URL+"/check", nil)
+	resp, err := (&http.Client{Transport: Transport(nil)}).Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	parent.End()
+
+	if header == "" {
+		t.Error("traceparent header was not sent")
+	}
+	tp.ForceFlush(context.Background())
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("spans = %d, want 2", len(spans))
+	}
+	client := spans[0]
+	if client.Name != "HTTP POST" || client.SpanKind != trace.SpanKindClient || client.Parent.SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("client span = %s kind=%s parent=%s", client.Name, client.SpanKind, client.Parent.SpanID())
+	}
+	if client.Status.Code.String() != "Error" {
+		t.Errorf("status = %v, want Error for 502", client.Status)
+	}
+}